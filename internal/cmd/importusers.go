@@ -0,0 +1,291 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/sftpgo/sdk"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultImportPasswdFile = "/etc/passwd"
+	defaultImportShadowFile = "/etc/shadow"
+	defaultImportLoginDefs  = "/etc/login.defs"
+)
+
+var (
+	importUsersFormat             string
+	importUsersInputFile          string
+	importUsersShadowFile         string
+	importUsersLoginDefsFile      string
+	importUsersMinUID             int
+	importUsersMaxUID             int
+	importUsersUsernames          []string
+	importUsersForceUID           int
+	importUsersForceGID           int
+	importUsersHomeBaseDir        string
+	importUsersAuthorizedKeysFile string
+	importUsersPrimaryGroup       string
+	importUsersSecondaryGroups    []string
+	importUsersMembershipGroups   []string
+	importUsersUpdateExisting     bool
+	importUsersDryRun             bool
+	importUsersCmd                = &cobra.Command{
+		Use:   "import-users",
+		Short: "Import users from OpenSSH/Linux, ProFTPD, Pure-FTPd or vsftpd",
+		Long: `This command reads the data provider connection details from the specified
+configuration file and imports users from the following formats:
+
+- "unix", Linux/Unix users from /etc/passwd and /etc/shadow. /etc/shadow is
+  typically readable by the root user only, if it cannot be read the passwords
+  are not imported
+- "proftpd", ProFTPD AuthUserFile
+- "pure-ftpd", Pure-FTPd pureftpd.passwd
+- "vsftpd", vsftpd virtual users: usernames and plain text passwords on
+  alternating lines, the same file used as input for db_load. The
+  "--home-base-dir" flag is required for this format
+
+Hashed passwords are preserved, the md5crypt, sha256crypt, sha512crypt and
+bcrypt formats are supported. yescrypt is only supported if SFTPGo is built
+with the "unixcrypt" tag. Users with an unsupported password format are
+imported without a password if they have at least a public key, otherwise they
+are skipped. Public keys are imported from the authorized keys file, if set.
+
+The root user is never imported. For the unix format the system accounts are
+skipped unless "--min-uid" is set, the system UID range is read from
+/etc/login.defs, SYS_UID_MAX or UID_MIN, and defaults to UIDs lower than 1000.
+Locked accounts and accounts with a "nologin" or "false" shell are imported as
+disabled. Users whose home dir is a system directory, for example /root or
+/usr/sbin, are imported with the list permission only.
+
+Existing users are skipped unless "--update-existing" is set. Use "--dry-run"
+to validate the users without saving them.
+This command is not supported for the memory provider.
+For embedded providers like bolt and SQLite you should stop the running SFTPGo
+instance to avoid database corruption.
+
+$ sftpgo import-users --format unix --authorized-keys-file .ssh/authorized_keys --dry-run
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			opts, err := getImportUsersOptions()
+			if err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			mfaConfig := config.GetMFAConfig()
+			err = mfaConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize MFA: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("memory provider is not supported")
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Initializing provider: %q config file: %q", providerConf.Driver, viper.ConfigFileUsed())
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+				os.Exit(1)
+			}
+			results, err := dataprovider.ImportUsers(opts, dataprovider.ActionExecutorSystem, "", "")
+			if err != nil {
+				logger.ErrorToConsole("Unable to import users: %v", err)
+				os.Exit(1)
+			}
+			printImportUsersResults(results)
+			if results.Count(dataprovider.ImportStatusFailed) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func readImportUsersFile(name string) (string, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() > dataprovider.MaxImportUsersSize {
+		return "", fmt.Errorf("file %q is too big: %d/%d bytes", name, fi.Size(), dataprovider.MaxImportUsersSize)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func getImportUsersOptions() (dataprovider.ImportUsersOptions, error) {
+	opts := dataprovider.ImportUsersOptions{
+		Format:             importUsersFormat,
+		MinUID:             importUsersMinUID,
+		MaxUID:             importUsersMaxUID,
+		Usernames:          importUsersUsernames,
+		ForceUID:           importUsersForceUID,
+		ForceGID:           importUsersForceGID,
+		HomeBaseDir:        importUsersHomeBaseDir,
+		AuthorizedKeysFile: importUsersAuthorizedKeysFile,
+		UpdateExisting:     importUsersUpdateExisting,
+		DryRun:             importUsersDryRun,
+	}
+	if importUsersPrimaryGroup != "" {
+		opts.Groups = append(opts.Groups, sdk.GroupMapping{Name: importUsersPrimaryGroup, Type: sdk.GroupTypePrimary})
+	}
+	for _, name := range importUsersSecondaryGroups {
+		opts.Groups = append(opts.Groups, sdk.GroupMapping{Name: name, Type: sdk.GroupTypeSecondary})
+	}
+	for _, name := range importUsersMembershipGroups {
+		opts.Groups = append(opts.Groups, sdk.GroupMapping{Name: name, Type: sdk.GroupTypeMembership})
+	}
+	inputFile := importUsersInputFile
+	if inputFile == "" {
+		if importUsersFormat != dataprovider.ImportFormatUnix {
+			return opts, errors.New("the input file is required for this format")
+		}
+		inputFile = defaultImportPasswdFile
+	}
+	data, err := readImportUsersFile(inputFile)
+	if err != nil {
+		return opts, fmt.Errorf("unable to read the input file: %w", err)
+	}
+	opts.Data = data
+	if importUsersFormat == dataprovider.ImportFormatUnix {
+		shadowFile := importUsersShadowFile
+		if shadowFile == "" {
+			shadowFile = defaultImportShadowFile
+		}
+		shadow, err := readImportUsersFile(shadowFile)
+		if err != nil {
+			if importUsersShadowFile != "" {
+				return opts, fmt.Errorf("unable to read the shadow file: %w", err)
+			}
+			logger.WarnToConsole("Unable to read %q, passwords stored there will not be imported: %v", shadowFile, err)
+		}
+		opts.Shadow = shadow
+		loginDefsFile := importUsersLoginDefsFile
+		if loginDefsFile == "" {
+			loginDefsFile = defaultImportLoginDefs
+		}
+		loginDefs, err := readImportUsersFile(loginDefsFile)
+		if err != nil {
+			if importUsersLoginDefsFile != "" {
+				return opts, fmt.Errorf("unable to read the login.defs file: %w", err)
+			}
+			logger.WarnToConsole("Unable to read %q, the default system UID range will be used: %v", loginDefsFile, err)
+		}
+		opts.LoginDefs = loginDefs
+	}
+	return opts, nil
+}
+
+func printImportUsersResults(results dataprovider.ImportUsersResults) {
+	for _, parseErr := range results.Errors {
+		logger.WarnToConsole("Parse error: %s", parseErr)
+	}
+	for _, result := range results.Results {
+		for _, warning := range result.Warnings {
+			logger.WarnToConsole("User %q: %s", result.Username, warning)
+		}
+		if result.Error != "" {
+			logger.WarnToConsole("User %q %s: %s", result.Username, result.Status, result.Error)
+		} else {
+			logger.InfoToConsole("User %q %s", result.Username, result.Status)
+		}
+	}
+	var dryRun string
+	if results.DryRun {
+		dryRun = ", dry run: no changes saved"
+	}
+	logger.InfoToConsole("Users import completed, added: %d, updated: %d, skipped: %d, failed: %d%s",
+		results.Count(dataprovider.ImportStatusAdded), results.Count(dataprovider.ImportStatusUpdated),
+		results.Count(dataprovider.ImportStatusSkipped), results.Count(dataprovider.ImportStatusFailed), dryRun)
+}
+
+func init() {
+	addConfigFlags(importUsersCmd)
+	importUsersCmd.Flags().StringVar(&importUsersFormat, "format", "", fmt.Sprintf(`Input format. Supported values: %s`,
+		strings.Join([]string{dataprovider.ImportFormatUnix, dataprovider.ImportFormatProFTPD,
+			dataprovider.ImportFormatPureFTPd, dataprovider.ImportFormatVsftpd}, ", ")))
+	importUsersCmd.MarkFlagRequired("format") //nolint:errcheck
+	importUsersCmd.Flags().StringVar(&importUsersInputFile, "input-file", "", `Path to the users file. Default "`+
+		defaultImportPasswdFile+`" for
+the unix format, required for the other
+formats`)
+	importUsersCmd.Flags().StringVar(&importUsersShadowFile, "shadow-file", "", `Path to the shadow file, unix format only.
+Default "`+defaultImportShadowFile+`"`)
+	importUsersCmd.Flags().StringVar(&importUsersLoginDefsFile, "login-defs-file", "", `Path to the login.defs file used to
+detect the system accounts, unix format
+only. Default "`+defaultImportLoginDefs+`"`)
+	importUsersCmd.Flags().IntVar(&importUsersMinUID, "min-uid", 0, `If greater than 0 only import users with
+UID greater or equal to this value. If not
+set the system accounts are skipped for the
+unix format`)
+	importUsersCmd.Flags().IntVar(&importUsersMaxUID, "max-uid", 0, `If greater than 0 only import users with
+UID lesser or equal to this value`)
+	importUsersCmd.Flags().StringSliceVar(&importUsersUsernames, "usernames", nil, `Only import users with these usernames.
+Comma separated`)
+	importUsersCmd.Flags().IntVar(&importUsersForceUID, "force-uid", 0, `If greater than 0 the imported users will
+have this UID`)
+	importUsersCmd.Flags().IntVar(&importUsersForceGID, "force-gid", 0, `If greater than 0 the imported users will
+have this GID`)
+	importUsersCmd.Flags().StringVar(&importUsersHomeBaseDir, "home-base-dir", "", `If set the home dir for the imported
+users will be this absolute path joined
+with the username`)
+	importUsersCmd.Flags().StringVar(&importUsersAuthorizedKeysFile, "authorized-keys-file", "", `Import public keys from this authorized
+keys file. Relative paths are resolved
+against the user's home dir, the %h and %u
+tokens are supported. Empty means no public
+keys are imported`)
+	importUsersCmd.Flags().StringVar(&importUsersPrimaryGroup, "primary-group", "", `Assign the imported users to this
+primary group`)
+	importUsersCmd.Flags().StringSliceVar(&importUsersSecondaryGroups, "secondary-groups", nil, `Assign the imported users to these
+secondary groups. Comma separated`)
+	importUsersCmd.Flags().StringSliceVar(&importUsersMembershipGroups, "membership-groups", nil, `Assign the imported users to these
+membership groups. Comma separated`)
+	importUsersCmd.Flags().BoolVar(&importUsersUpdateExisting, "update-existing", false, `Update existing users instead of
+skipping them`)
+	importUsersCmd.Flags().BoolVar(&importUsersDryRun, "dry-run", false, `Validate the users without saving them`)
+
+	rootCmd.AddCommand(importUsersCmd)
+}
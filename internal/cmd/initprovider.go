@@ -92,6 +92,7 @@ Please take a look at the usage below to customize the options.`,
 					LoadDataMode:      loadDataMode,
 					LoadDataQuotaScan: loadDataQuotaScan,
 					LoadDataClean:     loadDataClean,
+					LoadDataKey:       loadDataKey,
 				}
 				if err = service.LoadInitialData(); err != nil {
 					logger.ErrorToConsole("Cannot load initial data: %v", err)
@@ -0,0 +1,158 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/service"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	migrateProviderTargetConfigDir  string
+	migrateProviderTargetConfigFile string
+	migrateProviderWorkDir          string
+	migrateProviderResume           bool
+	migrateProviderCmd              = &cobra.Command{
+		Use:   "migrate-provider",
+		Short: "Copy all the data from the configured data provider to another one",
+		Long: `This command reads the source data provider connection details from the
+specified configuration file and the target data provider connection details
+from the target configuration file, then it copies users, groups, folders,
+admins, API keys, shares, event actions and rules, roles, IP list entries and
+configurations, including the used quota and the timestamps, from the source
+provider to the target one.
+
+Only the "data_provider" section of the target configuration file is used,
+everything else, for example the KMS configuration, is read from the source
+configuration file. The target database is created/updated as needed, it must
+not contain any data. The relations between objects are preserved, the IDs are
+assigned by the target provider.
+
+The source data are read in batches, one object type at a time, and saved
+within the work dir before starting the copy, the progress is saved there too, so an interrupted migration can be resumed using
+the "--resume" flag. Once all the objects are copied, the number of objects and
+a checksum for each object type are compared between the source and the target.
+The work dir contains sensitive data, remove it once the migration completes.
+
+Data modified within the source provider after the migration starts are not
+copied, stop SFTPGo or avoid changes while migrating. The memory provider is
+not supported.
+
+$ sftpgo migrate-provider --target-config-file /etc/sftpgo/target.json --work-dir /var/lib/sftpgo/migration
+
+If the target configuration file is not set, the default configuration file
+is looked for in the target configuration directory:
+
+$ sftpgo migrate-provider --target-config-dir /etc/sftpgo/target --work-dir /var/lib/sftpgo/migration
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to load the source configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			mfaConfig := config.GetMFAConfig()
+			err = mfaConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize MFA: %v", err)
+				os.Exit(1)
+			}
+			sourceConf := config.GetProviderConf()
+			logger.InfoToConsole("Source provider: %q config file: %q", sourceConf.Driver, viper.ConfigFileUsed())
+
+			targetConfigDir := util.CleanDirInput(migrateProviderTargetConfigDir)
+			if migrateProviderTargetConfigDir == "" {
+				targetConfigDir = configDir
+			}
+			err = config.LoadConfig(targetConfigDir, migrateProviderTargetConfigFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to load the target configuration: %v", err)
+				os.Exit(1)
+			}
+			targetConf := config.GetProviderConf()
+			logger.InfoToConsole("Target provider: %q config file: %q", targetConf.Driver, viper.ConfigFileUsed())
+
+			migration := service.ProviderMigration{
+				Source:         getMigrationProviderConf(sourceConf),
+				SourceBasePath: configDir,
+				Target:         getMigrationProviderConf(targetConf),
+				TargetBasePath: targetConfigDir,
+				WorkDir:        util.CleanDirInput(migrateProviderWorkDir),
+				Resume:         migrateProviderResume,
+			}
+			if err := migration.Run(); err != nil {
+				logger.ErrorToConsole("Unable to migrate the data provider: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func getMigrationProviderConf(providerConf dataprovider.Config) dataprovider.Config {
+	// ignore actions, inter-node communications and the default admin
+	providerConf.Actions.Hook = ""
+	providerConf.Actions.ExecuteFor = nil
+	providerConf.Actions.ExecuteOn = nil
+	providerConf.CreateDefaultAdmin = false
+	providerConf.IsShared = 0
+	providerConf.Node.Host = ""
+	providerConf.ChangeNotifications.Mode = dataprovider.ChangeNotificationsModeDisabled
+	return providerConf
+}
+
+func init() {
+	addConfigFlags(migrateProviderCmd)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderTargetConfigFile, "target-config-file", "",
+		`Path to the configuration file containing
+the target data provider settings. It must be
+an absolute path or a path relative to the
+target configuration directory. If not set,
+the default configuration file is looked for
+in the target configuration directory, as for
+the "config-file" flag`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderTargetConfigDir, "target-config-dir", "",
+		`Base directory for relative paths within the
+target configuration, for example the SQLite
+database path. Empty means the same
+configuration dir used for the source`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderWorkDir, "work-dir", "",
+		`Absolute path to the directory used to save
+the source data and the migration progress`)
+	migrateProviderCmd.MarkFlagRequired("work-dir") //nolint:errcheck
+	migrateProviderCmd.Flags().BoolVar(&migrateProviderResume, "resume", false,
+		`Resume a previously interrupted migration
+using the data saved within the work dir`)
+
+	rootCmd.AddCommand(migrateProviderCmd)
+}
@@ -52,6 +52,8 @@ const (
 	loadDataQuotaScanKey     = "loaddata_scan"
 	loadDataCleanFlag        = "loaddata-clean"
 	loadDataCleanKey         = "loaddata_clean"
+	loadDataKeyFlag          = "loaddata-key"
+	loadDataKeyKey           = "loaddata_key"
 	graceTimeFlag            = "grace-time"
 	graceTimeKey             = "grace_time"
 	defaultConfigDir         = "."
@@ -67,6 +69,7 @@ const (
 	defaultLoadDataMode      = 1
 	defaultLoadDataQuotaScan = 0
 	defaultLoadDataClean     = false
+	defaultLoadDataKey       = ""
 	defaultGraceTime         = 0
 )
 
@@ -84,6 +87,7 @@ var (
 	loadDataMode      int
 	loadDataQuotaScan int
 	loadDataClean     bool
+	loadDataKey       string
 	graceTime         int
 	// used if awscontainer build tag is enabled
 	disableAWSInstallationCode bool
@@ -181,6 +185,16 @@ can be set using SFTPGO_LOADDATA_CLEAN env var
 too. (default "false")
 `)
 	viper.BindPFlag(loadDataCleanKey, cmd.Flags().Lookup(loadDataCleanFlag)) //nolint:errcheck
+
+	viper.SetDefault(loadDataKeyKey, defaultLoadDataKey)
+	viper.BindEnv(loadDataKeyKey, "SFTPGO_LOADDATA_KEY") //nolint:errcheck
+	cmd.Flags().StringVar(&loadDataKey, loadDataKeyFlag, viper.GetString(loadDataKeyKey),
+		`Passphrase or age identity required to load
+an encrypted backup. Compressed backups are
+automatically detected. This flag can be set
+using SFTPGO_LOADDATA_KEY env var too.
+`)
+	viper.BindPFlag(loadDataKeyKey, cmd.Flags().Lookup(loadDataKeyFlag)) //nolint:errcheck
 }
 
 func addServeFlags(cmd *cobra.Command) {
@@ -0,0 +1,124 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	rotateKMSKeyNewMasterKeyPath string
+	rotateKMSKeyCmd              = &cobra.Command{
+		Use:   "rotatekmskey",
+		Short: "Re-encrypt the stored secrets using a new KMS master key",
+		Long: `This command reads the data provider connection details and the current KMS
+configuration from the specified configuration file, decrypts all the secrets
+stored within the data provider and encrypts them again using the master key
+read from the specified file.
+Secrets encrypted using the builtin provider are migrated to the local one.
+The new master key is saved to the configured master key path before
+re-encrypting the secrets, the previous one is saved to the same path with the
+".previous" suffix and it is used to decrypt the secrets not yet rotated, it is
+removed once all the secrets are re-encrypted.
+If other instances share the same data provider, copy the new master key to
+their configured master key path before running this command, the new master
+key is not stored within the data provider.
+The command can be safely executed again if it is interrupted: the secrets
+already encrypted using the new master key are skipped.
+This command is only supported for the local KMS provider with a master key read
+from a file and it is not supported for the memory data provider.
+For embedded providers like bolt and SQLite you should stop the running SFTPGo
+instance to avoid database corruption.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(_ *cobra.Command, _ []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = util.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			masterKey, err := os.ReadFile(rotateKMSKeyNewMasterKeyPath)
+			if err != nil {
+				logger.ErrorToConsole("Unable to read the new master key: %v", err)
+				os.Exit(1)
+			}
+			newMasterKey := strings.TrimSpace(string(masterKey))
+			if newMasterKey == "" {
+				logger.ErrorToConsole("The new master key cannot be empty")
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			if err := kms.CanRotateMasterKey(); err != nil {
+				logger.ErrorToConsole("Unable to rotate the master key: %v", err)
+				os.Exit(1)
+			}
+			mfaConfig := config.GetMFAConfig()
+			err = mfaConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize MFA: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("memory provider is not supported")
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Initializing provider: %q config file: %q", providerConf.Driver, viper.ConfigFileUsed())
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("Unable to initialize data provider: %v", err)
+				os.Exit(1)
+			}
+			result, err := dataprovider.RotateKMSMasterKey(newMasterKey, dataprovider.ActionExecutorSystem, "")
+			if err != nil {
+				logger.ErrorToConsole("Unable to re-encrypt secrets: %v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Secrets re-encrypted, updated users: %d, folders: %d, groups: %d, admins: %d, "+
+				"event actions: %d, configs: %d", result.Users, result.Folders, result.Groups, result.Admins,
+				result.EventActions, result.Configs)
+			logger.InfoToConsole("The new master key was saved to %q, restart SFTPGo to use it",
+				kmsConfig.Secrets.MasterKeyPath)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(rotateKMSKeyCmd)
+	rotateKMSKeyCmd.Flags().StringVar(&rotateKMSKeyNewMasterKeyPath, "new-master-key-path", "",
+		`Path to the file containing the new master key`)
+	rotateKMSKeyCmd.MarkFlagRequired("new-master-key-path") //nolint:errcheck
+
+	rootCmd.AddCommand(rotateKMSKeyCmd)
+}
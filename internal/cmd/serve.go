@@ -49,6 +49,7 @@ Please take a look at the usage below to customize the startup options`,
 				LoadDataMode:      loadDataMode,
 				LoadDataQuotaScan: loadDataQuotaScan,
 				LoadDataClean:     loadDataClean,
+				LoadDataKey:       loadDataKey,
 				Shutdown:          make(chan bool),
 			}
 			if err := service.Start(disableAWSInstallationCode); err == nil {
@@ -38,6 +38,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 var (
@@ -212,6 +213,12 @@ func newActionNotification(
 		endpoint = fsConfig.SFTPConfig.Endpoint
 	case sdk.HTTPFilesystemProvider:
 		endpoint = fsConfig.HTTPConfig.Endpoint
+	case vfs.WebDAVFilesystemProvider:
+		endpoint = fsConfig.WebDAVConfig.Endpoint
+	case vfs.FTPFilesystemProvider:
+		endpoint = fsConfig.FTPConfig.Endpoint
+	case vfs.SMBFilesystemProvider:
+		endpoint = fsConfig.SMBConfig.Endpoint
 	}
 
 	return &notifier.FsEvent{
@@ -227,6 +227,11 @@ func Initialize(c Configuration, isShared int) error {
 	dataprovider.SetTempPath(c.TempPath)
 	vfs.SetAllowSelfConnections(c.AllowSelfConnections)
 	vfs.SetRenameMode(c.RenameMode)
+	vfs.SetCloudUploadResume(c.CloudUploadResume.Enabled)
+	vfs.SetMetadataCacheMaxEntries(c.MetadataCacheMaxEntries)
+	if err := c.DiskCache.initialize(); err != nil {
+		return fmt.Errorf("disk cache initialization error: %w", err)
+	}
 	dataprovider.SetAllowSelfConnections(c.AllowSelfConnections)
 	transfersChecker = getTransfersChecker(isShared)
 	return nil
@@ -251,7 +256,7 @@ func WaitForTransfers(graceTime int) {
 		return
 	}
 
-	if activeHooks.Load() == 0 && getActiveConnections() == 0 {
+	if activeHooks.Load() == 0 && getActiveConnections() == 0 && vfs.GetDiskCachePendingUploads() == 0 {
 		return
 	}
 
@@ -262,8 +267,9 @@ func WaitForTransfers(graceTime int) {
 		select {
 		case <-ticker.C:
 			hooks := activeHooks.Load()
-			logger.Info(logSender, "", "active hooks: %d", hooks)
-			if hooks == 0 && getActiveConnections() == 0 {
+			pendingUploads := vfs.GetDiskCachePendingUploads()
+			logger.Info(logSender, "", "active hooks: %d, pending disk cache uploads: %d", hooks, pendingUploads)
+			if hooks == 0 && getActiveConnections() == 0 && pendingUploads == 0 {
 				logger.Info(logSender, "", "no more active connections, graceful shutdown")
 				ticker.Stop()
 				graceTimer.Stop()
@@ -513,6 +519,14 @@ type Configuration struct {
 	// renames for these providers, they may be slow, there is no atomic rename API like for local
 	// filesystem, so SFTPGo will recursively list the directory contents and do a rename for each entry
 	RenameMode int `json:"rename_mode" mapstructure:"rename_mode"`
+	// CloudUploadResume defines the configuration for resuming interrupted uploads
+	// on cloud storage providers (S3, GCS, Azure Blob)
+	CloudUploadResume CloudUploadResumeConfig `json:"cloud_upload_resume" mapstructure:"cloud_upload_resume"`
+	// Maximum number of entries for each metadata cache. The metadata cache for cloud storage
+	// providers is enabled and configured per filesystem. 0 means the default (10000)
+	MetadataCacheMaxEntries int `json:"metadata_cache_max_entries" mapstructure:"metadata_cache_max_entries"`
+	// DiskCache defines the configuration for the local disk cache used for remote filesystems
+	DiskCache DiskCacheConfig `json:"disk_cache" mapstructure:"disk_cache"`
 	// TempPath defines the path for temporary files such as those used for atomic uploads or file pipes.
 	// If you set this option you must make sure that the defined path exists, is accessible for writing
 	// by the user running SFTPGo, and is on the same filesystem as the users home directories otherwise
@@ -578,6 +592,47 @@ type Configuration struct {
 	proxySkipped          []func(net.IP) bool
 }
 
+// CloudUploadResumeConfig defines the configuration for resuming interrupted uploads
+// on cloud storage providers
+type CloudUploadResumeConfig struct {
+	// Set to true to keep the data received before an upload error or a client
+	// disconnection so that the upload can be resumed later. Multipart uploads are
+	// not aborted for S3, uncommitted blocks are kept for Azure Blob and a partial
+	// object is saved for GCS
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Incomplete uploads not updated for more than the specified hours are removed
+	// by the "Abandoned uploads cleanup" event action. 0 means the default: 24 hours
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+}
+
+func (c *CloudUploadResumeConfig) getMaxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.MaxAge) * time.Hour
+}
+
+// DiskCacheConfig defines the configuration for caching, on the local disk, the files
+// downloaded from remote filesystems: S3, GCS, Azure Blob and SFTP with buffering enabled
+type DiskCacheConfig struct {
+	// Absolute path to the directory used to store the cached files.
+	// Leave empty to disable the cache
+	Path string `json:"path" mapstructure:"path"`
+	// Maximum size, in MB, for all the cached files. 0 means disabled
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Maximum size, in MB, for a single cached file. Bigger files are not cached.
+	// 0 means no limit other than the max size
+	MaxFileSize int64 `json:"max_file_size" mapstructure:"max_file_size"`
+	// If enabled, uploads are stored on the local disk, the client upload completes
+	// as soon as the file is stored and then the file is asynchronously uploaded
+	// to the remote filesystem
+	WriteBack bool `json:"write_back" mapstructure:"write_back"`
+}
+
+func (c *DiskCacheConfig) initialize() error {
+	return vfs.SetDiskCache(c.Path, c.MaxSize*1048576, c.MaxFileSize*1048576, c.WriteBack)
+}
+
 // IsAtomicUploadEnabled returns true if atomic upload is enabled
 func (c *Configuration) IsAtomicUploadEnabled() bool {
 	return c.UploadMode == UploadModeAtomic || c.UploadMode == UploadModeAtomicWithResume
@@ -903,6 +958,9 @@ func (conns *ActiveConnections) Add(c ActiveConnection) error {
 				return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 			}
 		}
+		if CryptFsReEncryptions.IsActive(username) {
+			return dataprovider.ErrCryptFsReEncryptionInProgress
+		}
 		conns.addUserConnection(username)
 	}
 	conns.mapping[c.GetID()] = len(conns.connections)
@@ -931,6 +989,10 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 					return fmt.Errorf("too many open sessions: %d/%d", val, maxSessions)
 				}
 			}
+			if CryptFsReEncryptions.IsActive(username) {
+				conns.addUserConnection(conn.GetUsername())
+				return dataprovider.ErrCryptFsReEncryptionInProgress
+			}
 			conns.addUserConnection(username)
 		}
 		err := conn.CloseFS()
@@ -16,6 +16,7 @@ package common
 
 import (
 	"errors"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -502,6 +503,31 @@ func TestMaxWriteSize(t *testing.T) {
 	assert.Equal(t, int64(0), size)
 }
 
+func TestGetCreateChecks(t *testing.T) {
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	permissions["/sub"] = []string{dataprovider.PermUpload}
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    userTestUsername,
+			Permissions: permissions,
+			HomeDir:     filepath.Clean(os.TempDir()),
+		},
+	}
+	for _, protocol := range []string{ProtocolSFTP, ProtocolFTP} {
+		c := NewBaseConnection(xid.New().String(), protocol, "", "", user)
+		assert.Equal(t, vfs.CheckResume, c.GetCreateChecks("/file", true))
+		assert.Equal(t, vfs.CheckResume, c.GetCreateChecks("/sub/file", false))
+		assert.Equal(t, vfs.CheckResume|vfs.CheckParentDir, c.GetCreateChecks("/sub/file", true))
+	}
+	for _, protocol := range []string{ProtocolSCP, ProtocolWebDAV, ProtocolHTTP, protocolEventAction} {
+		c := NewBaseConnection(xid.New().String(), protocol, "", "", user)
+		assert.Equal(t, 0, c.GetCreateChecks("/file", true))
+		assert.Equal(t, 0, c.GetCreateChecks("/sub/file", false))
+		assert.Equal(t, vfs.CheckParentDir, c.GetCreateChecks("/sub/file", true))
+	}
+}
+
 func TestCheckParentDirsErrors(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
@@ -645,3 +671,54 @@ func TestFsFileCopier(t *testing.T) {
 	_, ok = fs.(vfs.FsFileCopier)
 	assert.True(t, ok)
 }
+
+func TestDirListerAt(t *testing.T) {
+	u := dataprovider.User{}
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       "vfolder",
+			MappedPath: filepath.Join(os.TempDir(), "vfolder"),
+		},
+		VirtualPath: "/vdir",
+	})
+	conn := NewBaseConnection("", ProtocolSFTP, "", "", u)
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	getFiles := func() []os.FileInfo {
+		return []os.FileInfo{
+			vfs.NewFileInfo("a", false, 1, time.Now(), false),
+			vfs.NewFileInfo("vdir", false, 1, time.Now(), false),
+			vfs.NewFileInfo("b", true, 0, time.Now(), false),
+			vfs.NewFileInfo("c", false, 2, time.Now(), false),
+			vfs.NewFileInfo("d", false, 3, time.Now(), false),
+		}
+	}
+	lister := newDirListerAt(conn, fs, "/", vfs.NewSliceDirLister(getFiles()))
+	lister.Prepend(vfs.NewFileInfo("..", true, 0, time.Now(), false))
+	_, err := lister.ListAt(nil, 0)
+	assert.ErrorIs(t, err, errInvalidListAtDestination)
+	var names []string
+	buf := make([]os.FileInfo, 2)
+	for {
+		n, err := lister.ListAt(buf, 0)
+		for _, fi := range buf[:n] {
+			names = append(names, fi.Name())
+		}
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+		assert.Equal(t, len(buf), n)
+	}
+	assert.Equal(t, []string{"..", "vdir", "a", "b", "c", "d"}, names)
+	n, err := lister.ListAt(buf, 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, lister.Close())
+
+	lister = newDirListerAt(conn, fs, "/", vfs.NewSliceDirLister(getFiles()))
+	entries, err := vfs.ReadAllDirEntries(lister)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+	_, err = lister.Next(1)
+	assert.ErrorIs(t, err, io.EOF)
+}
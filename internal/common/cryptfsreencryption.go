@@ -0,0 +1,417 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// CryptFsReEncryptions is the list of active CryptFs re-encryptions
+	CryptFsReEncryptions ActiveCryptFsReEncryptions
+	// ErrCryptFsReEncryptionConflict is returned if a CryptFs re-encryption cannot be
+	// started, resumed or rolled back in the current state
+	ErrCryptFsReEncryptionConflict = errors.New("CryptFs re-encryption conflict")
+)
+
+// CryptFsReEncryption defines an active CryptFs re-encryption
+type CryptFsReEncryption struct {
+	// Username to which the re-encryption refers
+	Username string `json:"username"`
+	// re-encryption start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// number of files and their size to process
+	TotalFiles int   `json:"total_files"`
+	TotalSize  int64 `json:"total_size"`
+	// number of files and their size already processed
+	ProcessedFiles int   `json:"processed_files"`
+	ProcessedSize  int64 `json:"processed_size"`
+	// processed files that were already encrypted using the new passphrase
+	SkippedFiles int `json:"skipped_files"`
+	// true if the files are restored using the current passphrase
+	Rollback bool   `json:"rollback"`
+	Role     string `json:"-"`
+}
+
+// ActiveCryptFsReEncryptions holds the CryptFs re-encryptions running on this instance
+type ActiveCryptFsReEncryptions struct {
+	sync.RWMutex
+	reEncryptions []CryptFsReEncryption
+}
+
+// Get returns the active CryptFs re-encryptions
+func (c *ActiveCryptFsReEncryptions) Get(role string) []CryptFsReEncryption {
+	c.RLock()
+	defer c.RUnlock()
+
+	reEncryptions := make([]CryptFsReEncryption, 0, len(c.reEncryptions))
+	for _, r := range c.reEncryptions {
+		if role == "" || role == r.Role {
+			reEncryptions = append(reEncryptions, r)
+		}
+	}
+
+	return reEncryptions
+}
+
+// IsActive returns true if a re-encryption is active for the specified user
+func (c *ActiveCryptFsReEncryptions) IsActive(username string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add adds a user to the ones with active re-encryptions.
+// Return false if a re-encryption is already active for the specified user
+func (c *ActiveCryptFsReEncryptions) Add(username, role string, rollback bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			return false
+		}
+	}
+
+	c.reEncryptions = append(c.reEncryptions, CryptFsReEncryption{
+		Username:  username,
+		StartTime: util.GetTimeAsMsSinceEpoch(time.Now()),
+		Rollback:  rollback,
+		Role:      role,
+	})
+
+	return true
+}
+
+// Remove removes a user from the ones with active re-encryptions
+func (c *ActiveCryptFsReEncryptions) Remove(username string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			lastIdx := len(c.reEncryptions) - 1
+			c.reEncryptions[idx] = c.reEncryptions[lastIdx]
+			c.reEncryptions = c.reEncryptions[:lastIdx]
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ActiveCryptFsReEncryptions) setTotals(username string, files int, size int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			c.reEncryptions[idx].TotalFiles = files
+			c.reEncryptions[idx].TotalSize = size
+			return
+		}
+	}
+}
+
+func (c *ActiveCryptFsReEncryptions) setProgress(username string, files int, size int64, skipped int) {
+	c.Lock()
+	defer c.Unlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			c.reEncryptions[idx].ProcessedFiles = files
+			c.reEncryptions[idx].ProcessedSize = size
+			c.reEncryptions[idx].SkippedFiles = skipped
+			return
+		}
+	}
+}
+
+func (c *ActiveCryptFsReEncryptions) updateProgress(username string, size int64, skipped bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for idx := range c.reEncryptions {
+		if c.reEncryptions[idx].Username == username {
+			c.reEncryptions[idx].ProcessedFiles++
+			c.reEncryptions[idx].ProcessedSize += size
+			if skipped {
+				c.reEncryptions[idx].SkippedFiles++
+			}
+			return
+		}
+	}
+}
+
+// StartCryptFsReEncryption starts the re-encryption of the CryptFs of the specified
+// user using the new passphrase, or resumes an interrupted one started using the same
+// passphrase. The re-encryption state is stored within the user, the user cannot login,
+// on any instance, until the re-encryption completes or it is rolled back.
+// Only the user's home directory is re-encrypted, virtual folders are not affected.
+// The files are processed in background
+func StartCryptFsReEncryption(user dataprovider.User, passphrase, executor, ipAddress, role string) error {
+	if user.FsConfig.Provider != sdk.CryptedFilesystemProvider {
+		return util.NewValidationError(fmt.Sprintf("user %q does not use a CryptFs", user.Username))
+	}
+	if passphrase == "" {
+		return util.NewValidationError("the new passphrase is mandatory")
+	}
+	state := user.Filters.CryptFsReEncryption
+	if state == nil {
+		state = &dataprovider.CryptFsReEncryptionState{
+			ID:         util.GenerateUniqueID(),
+			Passphrase: kms.NewPlainSecret(passphrase),
+			StartTime:  util.GetTimeAsMsSinceEpoch(time.Now()),
+		}
+	} else {
+		if err := state.Passphrase.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt the re-encryption passphrase: %w", err)
+		}
+		if state.Passphrase.GetPayload() != passphrase {
+			return fmt.Errorf("%w: a re-encryption using a different passphrase must be resumed or rolled back",
+				ErrCryptFsReEncryptionConflict)
+		}
+		if state.Rollback {
+			state.ResetProgress()
+		}
+	}
+	state.Rollback = false
+	return startCryptFsReEncryption(user, state, executor, ipAddress, role)
+}
+
+// RollbackCryptFsReEncryption restores the files of an interrupted CryptFs re-encryption
+// using the current user passphrase and then removes the re-encryption state.
+// The files are processed in background
+func RollbackCryptFsReEncryption(user dataprovider.User, executor, ipAddress, role string) error {
+	state := user.Filters.CryptFsReEncryption
+	if state == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("no CryptFs re-encryption for user %q", user.Username))
+	}
+	if !state.Rollback {
+		state.ResetProgress()
+	}
+	state.Rollback = true
+	return startCryptFsReEncryption(user, state, executor, ipAddress, role)
+}
+
+func startCryptFsReEncryption(user dataprovider.User, state *dataprovider.CryptFsReEncryptionState,
+	executor, ipAddress, role string,
+) error {
+	if !CryptFsReEncryptions.Add(user.Username, user.Role, state.Rollback) {
+		return fmt.Errorf("%w: another re-encryption is already in progress for user %q",
+			ErrCryptFsReEncryptionConflict, user.Username)
+	}
+	if state.RunID != "" && state.IsRunning() {
+		CryptFsReEncryptions.Remove(user.Username)
+		return fmt.Errorf("%w: the re-encryption for user %q is running on another instance",
+			ErrCryptFsReEncryptionConflict, user.Username)
+	}
+	// new sessions are denied from now on, existing ones must be closed
+	if sessions := Connections.GetActiveSessions(user.Username); sessions > 0 {
+		CryptFsReEncryptions.Remove(user.Username)
+		return fmt.Errorf("%w: user %q has %d active sessions", ErrCryptFsReEncryptionConflict,
+			user.Username, sessions)
+	}
+	expectedRunID := state.RunID
+	state.RunID = util.GenerateUniqueID()
+	state.Error = ""
+	if err := dataprovider.UpdateCryptFsReEncryptionState(user.Username, expectedRunID, state); err != nil {
+		CryptFsReEncryptions.Remove(user.Username)
+		if errors.Is(err, dataprovider.ErrVersionConflict) {
+			return fmt.Errorf("%w: the re-encryption state for user %q was modified", ErrCryptFsReEncryptionConflict,
+				user.Username)
+		}
+		return err
+	}
+	CryptFsReEncryptions.setProgress(user.Username, state.ProcessedFiles, state.ProcessedSize, state.SkippedFiles)
+	job := &cryptFsReEncryptionJob{
+		user:  user,
+		state: state,
+		runID: state.RunID,
+	}
+	go job.run(executor, ipAddress, role)
+	return nil
+}
+
+type cryptFsReEncryptionJob struct {
+	sync.Mutex
+	user  dataprovider.User
+	state *dataprovider.CryptFsReEncryptionState
+	runID string
+	// set if the state was modified by someone else
+	checkpointErr error
+}
+
+func (j *cryptFsReEncryptionJob) run(executor, ipAddress, role string) {
+	defer CryptFsReEncryptions.Remove(j.user.Username)
+
+	startTime := time.Now()
+	err := j.processFiles()
+	if err == nil {
+		err = j.complete(executor, ipAddress, role)
+	}
+	if err != nil {
+		logger.Warn(logSender, "", "CryptFs re-encryption for user %q, rollback? %t, stopped: %v",
+			j.user.Username, j.state.Rollback, err)
+		j.Lock()
+		defer j.Unlock()
+
+		if j.checkpointErr != nil {
+			return
+		}
+		// the user stays locked until the re-encryption is resumed or rolled back
+		j.state.Error = err.Error()
+		if err := dataprovider.UpdateCryptFsReEncryptionState(j.user.Username, j.runID, j.state); err != nil {
+			logger.Warn(logSender, "", "unable to save the CryptFs re-encryption state for user %q: %v",
+				j.user.Username, err)
+		}
+		return
+	}
+	logger.Info(logSender, "", "CryptFs re-encryption completed for user %q, rollback? %t, elapsed: %s",
+		j.user.Username, j.state.Rollback, time.Since(startTime))
+}
+
+func (j *cryptFsReEncryptionJob) processFiles() error {
+	if err := j.user.FsConfig.CryptConfig.Passphrase.TryDecrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt the current passphrase: %w", err)
+	}
+	if err := j.state.Passphrase.TryDecrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt the new passphrase: %w", err)
+	}
+	source := j.user.FsConfig.CryptConfig.Passphrase.GetPayload()
+	target := j.state.Passphrase.GetPayload()
+	if j.state.Rollback {
+		source, target = target, source
+	}
+	config := j.user.FsConfig.CryptConfig
+	config.Passphrase = kms.NewPlainSecret(source)
+	fs, err := vfs.NewCryptFs("", j.user.GetHomeDir(), "", config)
+	if err != nil {
+		return err
+	}
+	cryptFs, ok := fs.(*vfs.CryptFs)
+	if !ok {
+		return fmt.Errorf("unexpected filesystem for user %q", j.user.Username)
+	}
+	numFiles, size, err := cryptFs.GetDirSize(j.user.GetHomeDir())
+	if err != nil && !fs.IsNotExist(err) {
+		return fmt.Errorf("unable to get the home dir size: %w", err)
+	}
+	CryptFsReEncryptions.setTotals(j.user.Username, numFiles, size)
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(dataprovider.CryptFsReEncryptionCheckpointInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				j.checkpoint()
+			}
+		}
+	}()
+	err = cryptFs.ReEncrypt(vfs.CryptFsReEncryptOptions{
+		Passphrase:  target,
+		ID:          j.state.ID,
+		ResumeAfter: j.state.LastPath,
+		Progress:    j.onProgress,
+	})
+	close(done)
+	wg.Wait()
+	return err
+}
+
+func (j *cryptFsReEncryptionJob) onProgress(name string, size int64, skipped bool) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.checkpointErr != nil {
+		return j.checkpointErr
+	}
+	j.state.LastPath = name
+	j.state.ProcessedFiles++
+	j.state.ProcessedSize += size
+	if skipped {
+		j.state.SkippedFiles++
+	}
+	CryptFsReEncryptions.updateProgress(j.user.Username, size, skipped)
+	return nil
+}
+
+// checkpoint saves the progress, it also signals to the other instances that
+// the re-encryption is still running
+func (j *cryptFsReEncryptionJob) checkpoint() {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.checkpointErr != nil {
+		return
+	}
+	err := dataprovider.UpdateCryptFsReEncryptionState(j.user.Username, j.runID, j.state)
+	if err == nil {
+		return
+	}
+	logger.Warn(logSender, "", "unable to save the CryptFs re-encryption state for user %q: %v", j.user.Username, err)
+	if errors.Is(err, dataprovider.ErrVersionConflict) || errors.Is(err, util.ErrNotFound) {
+		j.checkpointErr = fmt.Errorf("the re-encryption state was modified: %w", err)
+	}
+}
+
+// complete updates the user passphrase, if required, and removes the re-encryption state
+func (j *cryptFsReEncryptionJob) complete(executor, ipAddress, role string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.checkpointErr != nil {
+		return j.checkpointErr
+	}
+	if !j.state.Rollback {
+		// reload the user, it could be modified while the re-encryption is in progress
+		user, err := dataprovider.UserExists(j.user.Username, "")
+		if err != nil {
+			return fmt.Errorf("unable to reload the user: %w", err)
+		}
+		user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret(j.state.Passphrase.GetPayload())
+		if err := dataprovider.UpdateUser(&user, executor, ipAddress, role); err != nil {
+			return fmt.Errorf("unable to update the passphrase: %w", err)
+		}
+	}
+	return dataprovider.UpdateCryptFsReEncryptionState(j.user.Username, j.runID, nil)
+}
@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -37,6 +39,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/logger"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 // RetentionCheckNotification defines the supported notification methods for a retention check result
@@ -259,7 +262,7 @@ func (c *RetentionCheck) cleanupFolder(folderPath string) error {
 	}
 	c.conn.Log(logger.LevelDebug, "start retention check for folder %q, retention: %v hours, delete empty dirs? %v, ignore user perms? %v",
 		folderPath, folderRetention.Retention, folderRetention.DeleteEmptyDirs, folderRetention.IgnoreUserPermissions)
-	files, err := c.conn.ListDir(folderPath)
+	lister, err := c.conn.ListDir(folderPath)
 	if err != nil {
 		result.Elapsed = time.Since(startTime)
 		if err == c.conn.GetNotExistError() {
@@ -267,34 +270,30 @@ func (c *RetentionCheck) cleanupFolder(folderPath string) error {
 			c.conn.Log(logger.LevelDebug, "folder %q does not exist, retention check skipped", folderPath)
 			return nil
 		}
-		result.Error = fmt.Sprintf("unable to list directory %q", folderPath)
+		result.Error = fmt.Sprintf("unable to get lister for directory %q", folderPath)
 		c.conn.Log(logger.LevelError, result.Error)
 		return err
 	}
-	for _, info := range files {
-		virtualPath := path.Join(folderPath, info.Name())
-		if info.IsDir() {
-			if err := c.cleanupFolder(virtualPath); err != nil {
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			result.Elapsed = time.Since(startTime)
+			result.Error = fmt.Sprintf("unable to list directory %q", folderPath)
+			c.conn.Log(logger.LevelError, result.Error)
+			return err
+		}
+		for _, info := range files {
+			if err := c.cleanupFolderEntry(folderPath, info, folderRetention.Retention, &result); err != nil {
 				result.Elapsed = time.Since(startTime)
-				result.Error = fmt.Sprintf("unable to check folder: %v", err)
-				c.conn.Log(logger.LevelError, "unable to cleanup folder %q: %v", virtualPath, err)
 				return err
 			}
-		} else {
-			retentionTime := info.ModTime().Add(time.Duration(folderRetention.Retention) * time.Hour)
-			if retentionTime.Before(time.Now()) {
-				if err := c.removeFile(virtualPath, info); err != nil {
-					result.Elapsed = time.Since(startTime)
-					result.Error = fmt.Sprintf("unable to remove file %q: %v", virtualPath, err)
-					c.conn.Log(logger.LevelError, "unable to remove file %q, retention %v: %v",
-						virtualPath, retentionTime, err)
-					return err
-				}
-				c.conn.Log(logger.LevelDebug, "removed file %q, modification time: %v, retention: %v hours, retention time: %v",
-					virtualPath, info.ModTime(), folderRetention.Retention, retentionTime)
-				result.DeletedFiles++
-				result.DeletedSize += info.Size()
-			}
+		}
+		if finished {
+			lister.Close()
+			break
 		}
 	}
 
@@ -308,6 +307,34 @@ func (c *RetentionCheck) cleanupFolder(folderPath string) error {
 	return nil
 }
 
+func (c *RetentionCheck) cleanupFolderEntry(folderPath string, info os.FileInfo, retention int,
+	result *folderRetentionCheckResult,
+) error {
+	virtualPath := path.Join(folderPath, info.Name())
+	if info.IsDir() {
+		if err := c.cleanupFolder(virtualPath); err != nil {
+			result.Error = fmt.Sprintf("unable to check folder: %v", err)
+			c.conn.Log(logger.LevelError, "unable to cleanup folder %q: %v", virtualPath, err)
+			return err
+		}
+		return nil
+	}
+	retentionTime := info.ModTime().Add(time.Duration(retention) * time.Hour)
+	if retentionTime.Before(time.Now()) {
+		if err := c.removeFile(virtualPath, info); err != nil {
+			result.Error = fmt.Sprintf("unable to remove file %q: %v", virtualPath, err)
+			c.conn.Log(logger.LevelError, "unable to remove file %q, retention %v: %v",
+				virtualPath, retentionTime, err)
+			return err
+		}
+		c.conn.Log(logger.LevelDebug, "removed file %q, modification time: %v, retention: %v hours, retention time: %v",
+			virtualPath, info.ModTime(), retention, retentionTime)
+		result.DeletedFiles++
+		result.DeletedSize += info.Size()
+	}
+	return nil
+}
+
 func (c *RetentionCheck) checkEmptyDirRemoval(folderPath string) {
 	if folderPath == "/" {
 		return
@@ -322,8 +349,13 @@ func (c *RetentionCheck) checkEmptyDirRemoval(folderPath string) {
 		dataprovider.PermDeleteDirs,
 	}, path.Dir(folderPath),
 	) {
-		files, err := c.conn.ListDir(folderPath)
-		if err == nil && len(files) == 0 {
+		lister, err := c.conn.ListDir(folderPath)
+		if err != nil {
+			return
+		}
+		files, err := lister.Next(1)
+		lister.Close()
+		if errors.Is(err, io.EOF) && len(files) == 0 {
 			err = c.conn.RemoveDir(folderPath)
 			c.conn.Log(logger.LevelDebug, "tryed to remove empty dir %q, error: %v", folderPath, err)
 		}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var errInvalidListAtDestination = errors.New("invalid ListAt destination, zero size")
+
+// DirListerAt wraps a vfs.DirLister. Virtual folders are returned before the directory
+// contents and the items hidden by the file patterns filters are removed.
+// It also implements the ListAt method required by the SFTP server
+type DirListerAt struct {
+	conn        *BaseConnection
+	fs          vfs.Fs
+	virtualPath string
+	lister      vfs.DirLister
+	mu          sync.Mutex
+	// entries to return before the directory contents
+	info []os.FileInfo
+	// entries already read but not yet returned by ListAt
+	entries []os.FileInfo
+	closed  bool
+}
+
+func newDirListerAt(conn *BaseConnection, fs vfs.Fs, virtualPath string, lister vfs.DirLister) *DirListerAt {
+	return &DirListerAt{
+		conn:        conn,
+		fs:          fs,
+		virtualPath: virtualPath,
+		lister:      lister,
+		info:        conn.User.GetVirtualFoldersInfo(virtualPath),
+	}
+}
+
+// Prepend adds the specified entry before the ones not yet returned
+func (l *DirListerAt) Prepend(fi os.FileInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.info = util.PrependFileInfo(l.info, fi)
+}
+
+// ListAt implements sftp.ListerAt. The SFTP server requests the entries sequentially,
+// so the offset is ignored. The lister is closed when all the entries are returned
+// or on error, because the SFTP server does not close it
+func (l *DirListerAt) ListAt(f []os.FileInfo, _ int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(f) == 0 {
+		return 0, errInvalidListAtDestination
+	}
+	for len(l.entries) < len(f) {
+		files, err := l.next(len(f) - len(l.entries))
+		l.entries = append(l.entries, files...)
+		if err != nil {
+			n := copy(f, l.entries)
+			l.entries = nil
+			l.close() //nolint:errcheck
+			return n, err
+		}
+	}
+	n := copy(f, l.entries)
+	l.entries = l.entries[n:]
+	return n, nil
+}
+
+// Next reads at most limit directory entries, io.EOF is returned
+// when there are no more entries
+func (l *DirListerAt) Next(limit int) ([]os.FileInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.next(limit)
+}
+
+func (l *DirListerAt) next(limit int) ([]os.FileInfo, error) {
+	if len(l.info) >= limit {
+		result := l.info[:limit]
+		l.info = l.info[limit:]
+		return result, nil
+	}
+	if l.closed {
+		return nil, io.EOF
+	}
+	files, err := l.lister.Next(limit - len(l.info))
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			l.conn.Log(logger.LevelDebug, "error listing directory %q: %+v", l.virtualPath, err)
+			return nil, l.conn.GetFsError(l.fs, err)
+		}
+		err = io.EOF
+	}
+	files = l.conn.User.FilterListDir(files, l.virtualPath)
+	if len(l.info) > 0 {
+		files = append(l.info, files...)
+		l.info = nil
+	}
+	return files, err
+}
+
+// Close closes the underlying lister
+func (l *DirListerAt) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.close()
+}
+
+func (l *DirListerAt) close() error {
+	l.info = nil
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.lister.Close()
+}
@@ -604,7 +604,7 @@ func (p *EventParams) AddError(err error) {
 	p.errors = append(p.errors, err.Error())
 }
 
-func (p *EventParams) setBackupParams(backupPath string) {
+func (p *EventParams) setBackupParams(backupPath string, size int64) {
 	if p.sender != "" {
 		return
 	}
@@ -613,10 +613,7 @@ func (p *EventParams) setBackupParams(backupPath string) {
 	p.ObjectName = filepath.Base(backupPath)
 	p.VirtualPath = "/" + p.ObjectName
 	p.Timestamp = time.Now().UnixNano()
-	info, err := os.Stat(backupPath)
-	if err == nil {
-		p.FileSize = info.Size()
-	}
+	p.FileSize = size
 }
 
 func (p *EventParams) getStatusString() string {
@@ -975,19 +972,31 @@ func addZipEntry(wr *zipWriterWrapper, conn *BaseConnection, entryPath, baseDir
 			eventManagerLog(logger.LevelError, "unable to create zip entry %q: %v", entryPath, err)
 			return fmt.Errorf("unable to create zip entry %q: %w", entryPath, err)
 		}
-		contents, err := conn.ListDir(entryPath)
+		lister, err := conn.ListDir(entryPath)
 		if err != nil {
-			eventManagerLog(logger.LevelError, "unable to add zip entry %q, read dir error: %v", entryPath, err)
+			eventManagerLog(logger.LevelError, "unable to add zip entry %q, get dir lister error: %v", entryPath, err)
 			return fmt.Errorf("unable to add zip entry %q: %w", entryPath, err)
 		}
-		for _, info := range contents {
-			fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
-			if err := addZipEntry(wr, conn, fullPath, baseDir); err != nil {
-				eventManagerLog(logger.LevelError, "unable to add zip entry: %v", err)
-				return err
+		defer lister.Close()
+
+		for {
+			contents, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				eventManagerLog(logger.LevelError, "unable to add zip entry %q, read dir error: %v", entryPath, err)
+				return fmt.Errorf("unable to add zip entry %q: %w", entryPath, err)
+			}
+			for _, info := range contents {
+				fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
+				if err := addZipEntry(wr, conn, fullPath, baseDir); err != nil {
+					eventManagerLog(logger.LevelError, "unable to add zip entry: %v", err)
+					return err
+				}
+			}
+			if finished {
+				return nil
 			}
 		}
-		return nil
 	}
 	if !info.Mode().IsRegular() {
 		// we only allow regular files
@@ -1811,18 +1820,29 @@ func getArchiveBaseDir(paths []string) string {
 func getSizeForPath(conn *BaseConnection, p string, info os.FileInfo) (int64, error) {
 	if info.IsDir() {
 		var dirSize int64
-		entries, err := conn.ListDir(p)
+		lister, err := conn.ListDir(p)
 		if err != nil {
 			return 0, err
 		}
-		for _, entry := range entries {
-			size, err := getSizeForPath(conn, path.Join(p, entry.Name()), entry)
-			if err != nil {
+		defer lister.Close()
+
+		for {
+			entries, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
 				return 0, err
 			}
-			dirSize += size
+			for _, entry := range entries {
+				size, err := getSizeForPath(conn, path.Join(p, entry.Name()), entry)
+				if err != nil {
+					return 0, err
+				}
+				dirSize += size
+			}
+			if finished {
+				return dirSize, nil
+			}
 		}
-		return dirSize, nil
 	}
 	if info.Mode().IsRegular() {
 		return info.Size(), nil
@@ -2294,6 +2314,55 @@ func executeMetadataCheckRuleAction(conditions dataprovider.ConditionOptions, pa
 	return nil
 }
 
+func executeUploadsCleanupForUser(user *dataprovider.User, olderThan time.Time) error {
+	if err := user.LoadAndApplyGroupSettings(); err != nil {
+		eventManagerLog(logger.LevelError, "skipping abandoned uploads cleanup for user %q, cannot apply group settings: %v",
+			user.Username, err)
+		return err
+	}
+	removed, err := user.CleanupAbandonedUploads(olderThan)
+	if err != nil {
+		eventManagerLog(logger.LevelError, "error removing abandoned uploads for user %q: %v", user.Username, err)
+		return fmt.Errorf("error removing abandoned uploads for user %q: %w", user.Username, err)
+	}
+	eventManagerLog(logger.LevelDebug, "abandoned uploads cleanup completed for user %q, removed uploads: %d",
+		user.Username, removed)
+	return nil
+}
+
+func executeUploadsCleanupRuleAction(conditions dataprovider.ConditionOptions, params *EventParams) error {
+	users, err := params.getUsers()
+	if err != nil {
+		return fmt.Errorf("unable to get users: %w", err)
+	}
+	olderThan := time.Now().Add(-Config.CloudUploadResume.getMaxAge())
+	var failures []string
+	var executed int
+	for _, user := range users {
+		// if sender is set, the conditions have already been evaluated
+		if params.sender == "" {
+			if !checkUserConditionOptions(&user, &conditions) {
+				eventManagerLog(logger.LevelDebug, "skipping abandoned uploads cleanup for user %q, condition options don't match",
+					user.Username)
+				continue
+			}
+		}
+		executed++
+		if err = executeUploadsCleanupForUser(&user, olderThan); err != nil {
+			params.AddError(err)
+			failures = append(failures, user.Username)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("abandoned uploads cleanup failed for users: %s", strings.Join(failures, ", "))
+	}
+	if executed == 0 {
+		eventManagerLog(logger.LevelError, "no abandoned uploads cleanup executed")
+		return errors.New("no abandoned uploads cleanup executed")
+	}
+	return nil
+}
+
 func executePwdExpirationCheckForUser(user *dataprovider.User, config dataprovider.EventActionPasswordExpiration) error {
 	if err := user.LoadAndApplyGroupSettings(); err != nil {
 		eventManagerLog(logger.LevelError, "skipping password expiration check for user %q, cannot apply group settings: %v",
@@ -2445,9 +2514,10 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = executeEmailRuleAction(action.Options.EmailConfig, params)
 	case dataprovider.ActionTypeBackup:
 		var backupPath string
-		backupPath, err = dataprovider.ExecuteBackup()
+		var backupSize int64
+		backupPath, backupSize, err = dataprovider.ExecuteBackup(&action.Options.BackupConfig)
 		if err == nil {
-			params.setBackupParams(backupPath)
+			params.setBackupParams(backupPath, backupSize)
 		}
 	case dataprovider.ActionTypeUserQuotaReset:
 		err = executeUsersQuotaResetRuleAction(conditions, params)
@@ -2465,6 +2535,8 @@ func executeRuleAction(action dataprovider.BaseEventAction, params *EventParams,
 		err = executePwdExpirationCheckRuleAction(action.Options.PwdExpirationConfig, conditions, params)
 	case dataprovider.ActionTypeUserExpirationCheck:
 		err = executeUserExpirationCheckRuleAction(conditions, params)
+	case dataprovider.ActionTypeUploadsCleanup:
+		err = executeUploadsCleanupRuleAction(conditions, params)
 	default:
 		err = fmt.Errorf("unsupported action type: %d", action.Type)
 	}
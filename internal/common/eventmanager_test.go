@@ -31,6 +31,7 @@ import (
 	"testing"
 	"time"
 
+	"filippo.io/age"
 	"github.com/klauspost/compress/zip"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -925,6 +926,29 @@ func TestEventRuleActions(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
+	action = dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeUploadsCleanup,
+	}
+
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: "don't match",
+			},
+		},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, getErrorString(err), "no abandoned uploads cleanup executed")
+	// local filesystem, nothing to cleanup
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{
+		Names: []dataprovider.ConditionPattern{
+			{
+				Pattern: username1,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
 	dataRetentionAction := dataprovider.BaseEventAction{
 		Type: dataprovider.ActionTypeDataRetentionCheck,
 		Options: dataprovider.BaseEventActionOptions{
@@ -1257,6 +1281,100 @@ func TestEventRuleActions(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestEventRuleBackupOptions(t *testing.T) {
+	foldername := "backup_folder"
+	folder := vfs.BaseVirtualFolder{
+		Name:       foldername,
+		MappedPath: filepath.Join(os.TempDir(), foldername),
+	}
+	err := dataprovider.AddFolder(&folder, "", "", "")
+	assert.NoError(t, err)
+	backupDir := filepath.Join(folder.MappedPath, "backups", "daily")
+	err = os.MkdirAll(backupDir, os.ModePerm)
+	assert.NoError(t, err)
+	oldBackups := []string{"backup_20200101T000000.json.gz.age", "backup_20200102T000000.json.gz.age",
+		"backup_20200103T000000.json"}
+	for _, name := range oldBackups {
+		err = os.WriteFile(filepath.Join(backupDir, name), []byte("old backup"), 0600)
+		assert.NoError(t, err)
+	}
+	unrelatedFile := filepath.Join(backupDir, "file.json")
+	err = os.WriteFile(unrelatedFile, []byte("{}"), 0600)
+	assert.NoError(t, err)
+
+	action := dataprovider.BaseEventAction{
+		Type: dataprovider.ActionTypeBackup,
+		Options: dataprovider.BaseEventActionOptions{
+			BackupConfig: dataprovider.EventActionBackupConfig{
+				Compression: dataprovider.BackupCompressionGzip,
+				Passphrase:  kms.NewPlainSecret("backup passphrase"),
+				Folder:      foldername,
+				Path:        "backups/daily",
+				Retention:   2,
+			},
+		},
+	}
+	params := &EventParams{}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(params.ObjectName, ".json.gz.age"), params.ObjectName)
+	assert.Greater(t, params.FileSize, int64(0))
+	assert.FileExists(t, unrelatedFile)
+	assert.NoFileExists(t, filepath.Join(backupDir, oldBackups[0]))
+	assert.NoFileExists(t, filepath.Join(backupDir, oldBackups[1]))
+	assert.FileExists(t, filepath.Join(backupDir, oldBackups[2]))
+	content, err := os.ReadFile(filepath.Join(backupDir, params.ObjectName))
+	assert.NoError(t, err)
+	assert.Equal(t, params.FileSize, int64(len(content)))
+	assert.True(t, dataprovider.IsBackupEncrypted(content))
+	_, err = dataprovider.DecodeBackup(content, "", 0)
+	assert.ErrorIs(t, err, util.ErrValidation)
+	_, err = dataprovider.DecodeBackup(content, "wrong passphrase", 0)
+	assert.Error(t, err)
+	_, err = dataprovider.DecodeBackup(content, "backup passphrase", 10)
+	assert.ErrorContains(t, err, "exceeds the max allowed size")
+	decoded, err := dataprovider.DecodeBackup(content, "backup passphrase", 0)
+	assert.NoError(t, err)
+	dump, err := dataprovider.ParseDumpData(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.DumpVersion, dump.Version)
+	// backup to the default path using zstd and an age recipient
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	action.Options.BackupConfig = dataprovider.EventActionBackupConfig{
+		Compression: dataprovider.BackupCompressionZstd,
+		Recipients:  []string{identity.Recipient().String()},
+	}
+	params = &EventParams{}
+	err = executeRuleAction(action, params, dataprovider.ConditionOptions{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(params.ObjectName, ".json.zst.age"), params.ObjectName)
+	assert.Equal(t, filepath.Join(dataprovider.GetBackupsPath(), params.ObjectName), params.FsPath)
+	content, err = os.ReadFile(params.FsPath)
+	assert.NoError(t, err)
+	_, err = dataprovider.DecodeBackup(content, "passphrase", 0)
+	assert.Error(t, err)
+	decoded, err = dataprovider.DecodeBackup(content, identity.String(), 0)
+	assert.NoError(t, err)
+	_, err = dataprovider.ParseDumpData(decoded)
+	assert.NoError(t, err)
+	err = os.Remove(params.FsPath)
+	assert.NoError(t, err)
+	// plain JSON content is returned unchanged
+	decoded, err = dataprovider.DecodeBackup([]byte("{}"), "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("{}"), decoded)
+
+	action.Options.BackupConfig.Folder = "missing folder"
+	err = executeRuleAction(action, &EventParams{}, dataprovider.ConditionOptions{})
+	assert.Error(t, err)
+
+	err = os.RemoveAll(folder.MappedPath)
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(foldername, "", "", "")
+	assert.NoError(t, err)
+}
+
 func TestIDPAccountCheckRule(t *testing.T) {
 	_, _, err := executeIDPAccountCheckRule(dataprovider.EventRule{}, EventParams{})
 	if assert.Error(t, err) {
@@ -19,6 +19,7 @@ import (
 
 	"github.com/robfig/cron/v3"
 
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -38,7 +39,7 @@ func startEventScheduler() {
 
 	eventScheduler = cron.New(cron.WithLocation(time.UTC), cron.WithLogger(cron.DiscardLogger))
 	eventManager.loadRules()
-	_, err := eventScheduler.AddFunc("@every 10m", eventManager.loadRules)
+	_, err := eventScheduler.AddFunc(dataprovider.GetCacheCheckInterval(), eventManager.loadRules)
 	util.PanicOnError(err)
 	eventScheduler.Start()
 }
@@ -4018,6 +4018,8 @@ func TestEventRuleProviderEvents(t *testing.T) {
 		var folderGet vfs.BaseVirtualFolder
 		err = json.Unmarshal(content, &folderGet)
 		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, folderGet.UpdatedAt, folder.UpdatedAt)
+		folder.UpdatedAt = folderGet.UpdatedAt
 		assert.Equal(t, folder, folderGet)
 		err = os.Remove(outPath)
 		assert.NoError(t, err)
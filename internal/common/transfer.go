@@ -325,8 +325,15 @@ func (t *BaseTransfer) getUploadFileSize() (int64, int, error) {
 	info, err := t.Fs.Stat(t.fsPath)
 	if err == nil {
 		fileSize = info.Size()
+	} else if t.ErrTransfer != nil && t.File == nil && t.Fs.IsNotExist(err) && t.Connection.isUploadResumeSupported() {
+		// interrupted cloud uploads are not visible, the data that can be resumed
+		// must be included in the quota
+		if info, errPending := vfs.GetPendingUploadInfo(t.Fs, t.fsPath); errPending == nil {
+			fileSize = info.Size()
+			err = nil
+		}
 	}
-	if t.ErrTransfer != nil && vfs.IsCryptOsFs(t.Fs) {
+	if t.ErrTransfer != nil && vfs.IsEncryptedFs(t.Fs) && (err == nil || vfs.IsCryptOsFs(t.Fs)) {
 		errDelete := t.Fs.Remove(t.fsPath, false)
 		if errDelete != nil {
 			t.Connection.Log(logger.LevelWarn, "error removing partial crypto file %q: %v", t.fsPath, errDelete)
@@ -382,6 +389,16 @@ func (t *BaseTransfer) Close() error {
 		}
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %q, deletion error: %v",
 			t.File.Name(), err)
+	} else if t.File == nil && t.transferType == TransferUpload && t.Fs.IsUploadResumeSupported() &&
+		t.Connection.IsQuotaExceededError(t.ErrTransfer) {
+		// partial data are kept for resumable cloud uploads, remove them if quota is exceeded
+		err = t.Fs.Remove(t.effectiveFsPath, false)
+		if err == nil || t.Fs.IsNotExist(err) {
+			t.BytesReceived.Store(0)
+			t.MinWriteOffset = 0
+		}
+		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete partial file: %q, deletion error: %v",
+			t.effectiveFsPath, err)
 	} else if t.transferType == TransferUpload && t.effectiveFsPath != t.fsPath {
 		if t.ErrTransfer == nil || Config.UploadMode == UploadModeAtomicWithResume {
 			_, _, err = t.Fs.Rename(t.effectiveFsPath, t.fsPath)
@@ -498,7 +515,8 @@ func (t *BaseTransfer) updateTimes() {
 
 func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	// Uploads on some filesystem (S3 and similar) are atomic, if there is an error nothing is uploaded
-	if t.File == nil && t.ErrTransfer != nil && vfs.HasImplicitAtomicUploads(t.Fs) {
+	// unless resuming interrupted uploads is enabled
+	if t.File == nil && t.ErrTransfer != nil && vfs.HasImplicitAtomicUploads(t.Fs) && !t.Fs.IsUploadResumeSupported() {
 		return false
 	}
 	sizeDiff := fileSize - t.InitialSize
@@ -126,6 +126,8 @@ var (
 			ImplicitRoles:              false,
 			Scopes:                     []string{"openid", "profile", "email"},
 			CustomFields:               []string{},
+			APIAudiences:               []string{},
+			APIUsernameField:           "",
 			InsecureSkipSignatureCheck: false,
 			Debug:                      false,
 		},
@@ -213,6 +215,17 @@ func Init() {
 			MaxPerHostConnections: 20,
 			AllowListStatus:       0,
 			AllowSelfConnections:  0,
+			CloudUploadResume: common.CloudUploadResumeConfig{
+				Enabled: false,
+				MaxAge:  24,
+			},
+			MetadataCacheMaxEntries: 10000,
+			DiskCache: common.DiskCacheConfig{
+				Path:        "",
+				MaxSize:     0,
+				MaxFileSize: 0,
+				WriteBack:   false,
+			},
 			DefenderConfig: common.DefenderConfig{
 				Enabled:            false,
 				Driver:             common.DefenderDriverMemory,
@@ -330,7 +343,11 @@ func Init() {
 			ClientKey:          "",
 			TrackQuota:         2,
 			PoolSize:           0,
-			UsersBaseDir:       "",
+			ReadReplicas: dataprovider.ReadReplicasConfig{
+				ConnectionStrings: nil,
+				MaxLag:            5,
+			},
+			UsersBaseDir: "",
 			Actions: dataprovider.ObjectsActions{
 				ExecuteOn:  []string{},
 				ExecuteFor: []string{},
@@ -356,10 +373,12 @@ func Init() {
 			},
 			PasswordValidation: dataprovider.PasswordValidation{
 				Admins: dataprovider.PasswordValidationRules{
-					MinEntropy: 0,
+					MinEntropy:  0,
+					HistorySize: 0,
 				},
 				Users: dataprovider.PasswordValidationRules{
-					MinEntropy: 0,
+					MinEntropy:  0,
+					HistorySize: 0,
 				},
 			},
 			PasswordCaching:    true,
@@ -373,6 +392,16 @@ func Init() {
 				Port:  0,
 				Proto: "http",
 			},
+			ChangeNotifications: dataprovider.ChangeNotificationsConfig{
+				Mode:         0,
+				PollInterval: 0,
+			},
+			MetadataCacheSyncInterval: 0,
+			ObjectHistory: dataprovider.ObjectHistoryConfig{
+				Enabled:     false,
+				Retention:   90,
+				MaxVersions: 100,
+			},
 			BackupsPath: "backups",
 		},
 		HTTPDConfig: httpd.Conf{
@@ -1557,6 +1586,18 @@ func getHTTPDOIDCFromEnv(idx int) (httpd.OIDC, bool) {
 		isSet = true
 	}
 
+	apiAudiences, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__API_AUDIENCES", idx))
+	if ok {
+		result.APIAudiences = apiAudiences
+		isSet = true
+	}
+
+	apiUsernameField, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__API_USERNAME_FIELD", idx))
+	if ok {
+		result.APIUsernameField = apiUsernameField
+		isSet = true
+	}
+
 	skipSignatureCheck, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", idx))
 	if ok {
 		result.InsecureSkipSignatureCheck = skipSignatureCheck
@@ -1958,6 +1999,13 @@ func setViperDefaults() {
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
 	viper.SetDefault("common.rename_mode", globalConf.Common.RenameMode)
+	viper.SetDefault("common.cloud_upload_resume.enabled", globalConf.Common.CloudUploadResume.Enabled)
+	viper.SetDefault("common.cloud_upload_resume.max_age", globalConf.Common.CloudUploadResume.MaxAge)
+	viper.SetDefault("common.metadata_cache_max_entries", globalConf.Common.MetadataCacheMaxEntries)
+	viper.SetDefault("common.disk_cache.path", globalConf.Common.DiskCache.Path)
+	viper.SetDefault("common.disk_cache.max_size", globalConf.Common.DiskCache.MaxSize)
+	viper.SetDefault("common.disk_cache.max_file_size", globalConf.Common.DiskCache.MaxFileSize)
+	viper.SetDefault("common.disk_cache.write_back", globalConf.Common.DiskCache.WriteBack)
 	viper.SetDefault("common.temp_path", globalConf.Common.TempPath)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
@@ -2056,6 +2104,8 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.sql_tables_prefix", globalConf.ProviderConf.SQLTablesPrefix)
 	viper.SetDefault("data_provider.track_quota", globalConf.ProviderConf.TrackQuota)
 	viper.SetDefault("data_provider.pool_size", globalConf.ProviderConf.PoolSize)
+	viper.SetDefault("data_provider.read_replicas.connection_strings", globalConf.ProviderConf.ReadReplicas.ConnectionStrings)
+	viper.SetDefault("data_provider.read_replicas.max_lag", globalConf.ProviderConf.ReadReplicas.MaxLag)
 	viper.SetDefault("data_provider.users_base_dir", globalConf.ProviderConf.UsersBaseDir)
 	viper.SetDefault("data_provider.actions.execute_on", globalConf.ProviderConf.Actions.ExecuteOn)
 	viper.SetDefault("data_provider.actions.execute_for", globalConf.ProviderConf.Actions.ExecuteFor)
@@ -2074,6 +2124,8 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.password_hashing.algo", globalConf.ProviderConf.PasswordHashing.Algo)
 	viper.SetDefault("data_provider.password_validation.admins.min_entropy", globalConf.ProviderConf.PasswordValidation.Admins.MinEntropy)
 	viper.SetDefault("data_provider.password_validation.users.min_entropy", globalConf.ProviderConf.PasswordValidation.Users.MinEntropy)
+	viper.SetDefault("data_provider.password_validation.admins.history_size", globalConf.ProviderConf.PasswordValidation.Admins.HistorySize)
+	viper.SetDefault("data_provider.password_validation.users.history_size", globalConf.ProviderConf.PasswordValidation.Users.HistorySize)
 	viper.SetDefault("data_provider.password_caching", globalConf.ProviderConf.PasswordCaching)
 	viper.SetDefault("data_provider.update_mode", globalConf.ProviderConf.UpdateMode)
 	viper.SetDefault("data_provider.delayed_quota_update", globalConf.ProviderConf.DelayedQuotaUpdate)
@@ -2083,6 +2135,12 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.node.host", globalConf.ProviderConf.Node.Host)
 	viper.SetDefault("data_provider.node.port", globalConf.ProviderConf.Node.Port)
 	viper.SetDefault("data_provider.node.proto", globalConf.ProviderConf.Node.Proto)
+	viper.SetDefault("data_provider.change_notifications.mode", globalConf.ProviderConf.ChangeNotifications.Mode)
+	viper.SetDefault("data_provider.change_notifications.poll_interval", globalConf.ProviderConf.ChangeNotifications.PollInterval)
+	viper.SetDefault("data_provider.metadata_cache_sync_interval", globalConf.ProviderConf.MetadataCacheSyncInterval)
+	viper.SetDefault("data_provider.object_history.enabled", globalConf.ProviderConf.ObjectHistory.Enabled)
+	viper.SetDefault("data_provider.object_history.retention", globalConf.ProviderConf.ObjectHistory.Retention)
+	viper.SetDefault("data_provider.object_history.max_versions", globalConf.ProviderConf.ObjectHistory.MaxVersions)
 	viper.SetDefault("data_provider.backups_path", globalConf.ProviderConf.BackupsPath)
 	viper.SetDefault("httpd.templates_path", globalConf.HTTPDConfig.TemplatesPath)
 	viper.SetDefault("httpd.static_files_path", globalConf.HTTPDConfig.StaticFilesPath)
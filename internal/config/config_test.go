@@ -445,6 +445,101 @@ func TestSMTPFromEnv(t *testing.T) {
 	assert.Equal(t, 587, smtpConfig.Port)
 }
 
+func TestCloudUploadResumeFromEnv(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	commonConf := config.GetCommonConfig()
+	assert.False(t, commonConf.CloudUploadResume.Enabled)
+	assert.Equal(t, 24, commonConf.CloudUploadResume.MaxAge)
+
+	os.Setenv("SFTPGO_COMMON__CLOUD_UPLOAD_RESUME__ENABLED", "true")
+	os.Setenv("SFTPGO_COMMON__CLOUD_UPLOAD_RESUME__MAX_AGE", "48")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__CLOUD_UPLOAD_RESUME__ENABLED")
+		os.Unsetenv("SFTPGO_COMMON__CLOUD_UPLOAD_RESUME__MAX_AGE")
+	})
+
+	reset()
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	commonConf = config.GetCommonConfig()
+	assert.True(t, commonConf.CloudUploadResume.Enabled)
+	assert.Equal(t, 48, commonConf.CloudUploadResume.MaxAge)
+}
+
+func TestMetadataCacheMaxEntriesFromEnv(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 10000, config.GetCommonConfig().MetadataCacheMaxEntries)
+
+	os.Setenv("SFTPGO_COMMON__METADATA_CACHE_MAX_ENTRIES", "500")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__METADATA_CACHE_MAX_ENTRIES")
+	})
+
+	reset()
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 500, config.GetCommonConfig().MetadataCacheMaxEntries)
+}
+
+func TestDiskCacheFromEnv(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	diskCache := config.GetCommonConfig().DiskCache
+	assert.Empty(t, diskCache.Path)
+	assert.Equal(t, int64(0), diskCache.MaxSize)
+	assert.False(t, diskCache.WriteBack)
+
+	os.Setenv("SFTPGO_COMMON__DISK_CACHE__PATH", "/var/cache/sftpgo")
+	os.Setenv("SFTPGO_COMMON__DISK_CACHE__MAX_SIZE", "1024")
+	os.Setenv("SFTPGO_COMMON__DISK_CACHE__MAX_FILE_SIZE", "100")
+	os.Setenv("SFTPGO_COMMON__DISK_CACHE__WRITE_BACK", "true")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_COMMON__DISK_CACHE__PATH")
+		os.Unsetenv("SFTPGO_COMMON__DISK_CACHE__MAX_SIZE")
+		os.Unsetenv("SFTPGO_COMMON__DISK_CACHE__MAX_FILE_SIZE")
+		os.Unsetenv("SFTPGO_COMMON__DISK_CACHE__WRITE_BACK")
+	})
+
+	reset()
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	diskCache = config.GetCommonConfig().DiskCache
+	assert.Equal(t, "/var/cache/sftpgo", diskCache.Path)
+	assert.Equal(t, int64(1024), diskCache.MaxSize)
+	assert.Equal(t, int64(100), diskCache.MaxFileSize)
+	assert.True(t, diskCache.WriteBack)
+}
+
+func TestMetadataCacheSyncIntervalFromEnv(t *testing.T) {
+	reset()
+
+	err := config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, config.GetProviderConf().MetadataCacheSyncInterval)
+
+	os.Setenv("SFTPGO_DATA_PROVIDER__METADATA_CACHE_SYNC_INTERVAL", "30")
+	t.Cleanup(func() {
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__METADATA_CACHE_SYNC_INTERVAL")
+	})
+
+	reset()
+
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, config.GetProviderConf().MetadataCacheSyncInterval)
+}
+
 func TestMFAFromEnv(t *testing.T) {
 	reset()
 
@@ -1211,6 +1306,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__SCOPES", "openid")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__IMPLICIT_ROLES", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS", "field1,field2")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__API_AUDIENCES", "sftpgo-api")
+	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__API_USERNAME_FIELD", "client_id")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG", "1")
 	os.Setenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED", "true")
@@ -1280,6 +1377,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__SCOPES")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__IMPLICIT_ROLES")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__CUSTOM_FIELDS")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__API_AUDIENCES")
+		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__API_USERNAME_FIELD")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__INSECURE_SKIP_SIGNATURE_CHECK")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__OIDC__DEBUG")
 		os.Unsetenv("SFTPGO_HTTPD__BINDINGS__2__SECURITY__ENABLED")
@@ -1386,6 +1485,8 @@ func TestHTTPDBindingsFromEnv(t *testing.T) {
 	require.Len(t, bindings[2].OIDC.CustomFields, 2)
 	require.Equal(t, "field1", bindings[2].OIDC.CustomFields[0])
 	require.Equal(t, "field2", bindings[2].OIDC.CustomFields[1])
+	require.Equal(t, []string{"sftpgo-api"}, bindings[2].OIDC.APIAudiences)
+	require.Equal(t, "client_id", bindings[2].OIDC.APIUsernameField)
 	require.True(t, bindings[2].OIDC.InsecureSkipSignatureCheck)
 	require.True(t, bindings[2].OIDC.Debug)
 	require.True(t, bindings[2].Security.Enabled)
@@ -1560,6 +1661,8 @@ func TestConfigFromEnv(t *testing.T) {
 	os.Setenv("SFTPGO_DATA_PROVIDER__POOL_SIZE", "10")
 	os.Setenv("SFTPGO_DATA_PROVIDER__IS_SHARED", "1")
 	os.Setenv("SFTPGO_DATA_PROVIDER__ACTIONS__EXECUTE_ON", "add")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS", "postgres://replica1/sftpgo,postgres://replica2/sftpgo")
+	os.Setenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG", "10")
 	os.Setenv("SFTPGO_KMS__SECRETS__URL", "local")
 	os.Setenv("SFTPGO_KMS__SECRETS__MASTER_KEY_PATH", "path")
 	os.Setenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA")
@@ -1572,6 +1675,8 @@ func TestConfigFromEnv(t *testing.T) {
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__POOL_SIZE")
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__IS_SHARED")
 		os.Unsetenv("SFTPGO_DATA_PROVIDER__ACTIONS__EXECUTE_ON")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__CONNECTION_STRINGS")
+		os.Unsetenv("SFTPGO_DATA_PROVIDER__READ_REPLICAS__MAX_LAG")
 		os.Unsetenv("SFTPGO_KMS__SECRETS__URL")
 		os.Unsetenv("SFTPGO_KMS__SECRETS__MASTER_KEY_PATH")
 		os.Unsetenv("SFTPGO_TELEMETRY__TLS_CIPHER_SUITES")
@@ -1589,6 +1694,9 @@ func TestConfigFromEnv(t *testing.T) {
 	assert.Equal(t, 1, dataProviderConf.IsShared)
 	assert.Len(t, dataProviderConf.Actions.ExecuteOn, 1)
 	assert.Contains(t, dataProviderConf.Actions.ExecuteOn, "add")
+	assert.Equal(t, []string{"postgres://replica1/sftpgo", "postgres://replica2/sftpgo"},
+		dataProviderConf.ReadReplicas.ConnectionStrings)
+	assert.Equal(t, 10, dataProviderConf.ReadReplicas.MaxLag)
 	kmsConfig := config.GetKMSConfig()
 	assert.Equal(t, "local", kmsConfig.Secrets.URL)
 	assert.Equal(t, "path", kmsConfig.Secrets.MasterKeyPath)
@@ -61,6 +61,8 @@ var (
 )
 
 func executeAction(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer) {
+	addObjectVersion(operation, executor, ip, objectType, objectName, role, object)
+	notifyChange(operation, objectType, objectName, object)
 	if plugin.Handler.HasNotifiers() {
 		plugin.Handler.NotifyProviderEvent(&notifier.ProviderEvent{
 			Action:     operation,
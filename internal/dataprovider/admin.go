@@ -206,6 +206,8 @@ type AdminFilters struct {
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode   `json:"recovery_codes,omitempty"`
 	Preferences   AdminPreferences `json:"preferences"`
+	// Hashes of the last used passwords, the current one included
+	PasswordHistory []string `json:"password_history,omitempty"`
 }
 
 // AdminGroupMappingOptions defines the options for admin/group mapping
@@ -268,6 +270,8 @@ type Admin struct {
 	// - manage_event_rules
 	// - manage_roles
 	Role string `json:"role,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // CountUnusedRecoveryCodes returns the number of unused recovery codes
@@ -288,6 +292,11 @@ func (a *Admin) hashPassword() error {
 				return util.NewValidationError(err.Error())
 			}
 		}
+		historySize := config.PasswordValidation.Admins.HistorySize
+		if isPasswordInHistory(a.Password, a.Filters.PasswordHistory, historySize) {
+			return util.NewValidationError(fmt.Sprintf("the password cannot be equal to one of the last %d passwords",
+				historySize))
+		}
 		if config.PasswordHashing.Algo == HashingAlgoBcrypt {
 			pwd, err := bcrypt.GenerateFromPassword([]byte(a.Password), config.PasswordHashing.BcryptOptions.Cost)
 			if err != nil {
@@ -301,6 +310,7 @@ func (a *Admin) hashPassword() error {
 			}
 			a.Password = pwd
 		}
+		a.Filters.PasswordHistory = addToPasswordHistory(a.Filters.PasswordHistory, a.Password, historySize)
 	}
 	return nil
 }
@@ -518,6 +528,7 @@ func (a *Admin) RenderAsJSON(reload bool) ([]byte, error) {
 // HideConfidentialData hides admin confidential data
 func (a *Admin) HideConfidentialData() {
 	a.Password = ""
+	a.Filters.PasswordHistory = nil
 	if a.Filters.TOTPConfig.Secret != nil {
 		a.Filters.TOTPConfig.Secret.Hide()
 	}
@@ -612,6 +623,10 @@ func (a *Admin) getACopy() Admin {
 		HideUserPageSections:   a.Filters.Preferences.HideUserPageSections,
 		DefaultUsersExpiration: a.Filters.Preferences.DefaultUsersExpiration,
 	}
+	if len(a.Filters.PasswordHistory) > 0 {
+		filters.PasswordHistory = make([]string, len(a.Filters.PasswordHistory))
+		copy(filters.PasswordHistory, a.Filters.PasswordHistory)
+	}
 	groups := make([]AdminGroupMapping, 0, len(a.Groups))
 	for _, g := range a.Groups {
 		groups = append(groups, AdminGroupMapping{
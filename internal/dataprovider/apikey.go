@@ -66,6 +66,8 @@ type APIKey struct {
 	// Admin username associated with this API key.
 	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
 	Admin string `json:"admin,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 	// these fields are for internal use
 	userID   int64
 	adminID  int64
@@ -145,6 +147,13 @@ func (k *APIKey) DisplayKey() string {
 }
 
 func (k *APIKey) validate() error {
+	if err := k.validateAttributes(); err != nil {
+		return err
+	}
+	return k.validateRelatedObjects()
+}
+
+func (k *APIKey) validateAttributes() error {
 	if k.Name == "" {
 		return util.NewValidationError("name is mandatory")
 	}
@@ -164,6 +173,11 @@ func (k *APIKey) validate() error {
 	if k.Scope == APIKeyScopeUser {
 		k.Admin = ""
 	}
+	return nil
+}
+
+// validateRelatedObjects checks that the user or admin associated with the API key exists
+func (k *APIKey) validateRelatedObjects() error {
 	if k.User != "" {
 		_, err := provider.userExists(k.User, "")
 		if err != nil {
@@ -0,0 +1,403 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported backup compressions
+const (
+	BackupCompressionGzip = "gzip"
+	BackupCompressionZstd = "zstd"
+)
+
+const (
+	backupConnectionID     = "backup"
+	backupTimestampFormat  = "20060102T150405"
+	ageIdentityPrefix      = "AGE-SECRET-KEY-"
+	ageEncryptionHeader    = "age-encryption.org/v1"
+	backupGzipExtension    = ".gz"
+	backupZstdExtension    = ".zst"
+	backupAgeExtension     = ".age"
+	backupFileNamePrefix   = "backup_"
+	backupFileNameJSONPart = ".json"
+)
+
+var (
+	supportedBackupCompressions = []string{"", BackupCompressionGzip, BackupCompressionZstd}
+	backupFileNameRegex         = regexp.MustCompile(`^backup_\d{8}T\d{6}\.json(\.gz|\.zst)?(\.age)?$`)
+	gzipMagic                   = []byte{0x1f, 0x8b}
+	zstdMagic                   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// ErrBackupKeyRequired is returned if an encrypted backup is restored without a key
+	ErrBackupKeyRequired = errors.New("the backup is encrypted, a passphrase or an age identity is required")
+)
+
+func parseBackupRecipient(recipient string) (age.Recipient, error) {
+	return age.ParseX25519Recipient(strings.TrimSpace(recipient))
+}
+
+func getBackupFileName(options *EventActionBackupConfig, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString(backupFileNamePrefix)
+	sb.WriteString(now.Format(backupTimestampFormat))
+	sb.WriteString(backupFileNameJSONPart)
+	switch options.Compression {
+	case BackupCompressionGzip:
+		sb.WriteString(backupGzipExtension)
+	case BackupCompressionZstd:
+		sb.WriteString(backupZstdExtension)
+	}
+	if options.IsEncrypted() {
+		sb.WriteString(backupAgeExtension)
+	}
+	return sb.String()
+}
+
+func getBackupRecipients(options *EventActionBackupConfig) ([]age.Recipient, error) {
+	if options.Passphrase != nil && !options.Passphrase.IsEmpty() {
+		if err := options.TryDecryptPassphrase(); err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(options.Passphrase.GetPayload())
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+	recipients := make([]age.Recipient, 0, len(options.Recipients))
+	for _, r := range options.Recipients {
+		recipient, err := parseBackupRecipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup recipient %q: %w", r, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+func getBackupFs(options *EventActionBackupConfig) (vfs.Fs, error) {
+	if options.Folder == "" {
+		if err := os.MkdirAll(config.BackupsPath, 0700); err != nil {
+			return nil, fmt.Errorf("unable to create backup dir: %w", err)
+		}
+		return vfs.NewOsFs(backupConnectionID, config.BackupsPath, ""), nil
+	}
+	baseFolder, err := provider.getFolderByName(options.Folder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get folder %q: %w", options.Folder, err)
+	}
+	folder := vfs.VirtualFolder{
+		BaseVirtualFolder: baseFolder,
+		VirtualPath:       "/",
+	}
+	return folder.GetFilesystem(backupConnectionID, nil)
+}
+
+func createBackupDirs(fs vfs.Fs, dirPath string) error {
+	if dirPath == "" {
+		return nil
+	}
+	var current string
+	for _, dir := range strings.Split(dirPath, "/") {
+		current = path.Join(current, dir)
+		fsPath, err := fs.ResolvePath("/" + current)
+		if err != nil {
+			return err
+		}
+		if _, err := fs.Stat(fsPath); err == nil {
+			continue
+		} else if !fs.IsNotExist(err) {
+			return err
+		}
+		if err := fs.Mkdir(fsPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func writeBackup(dst io.Writer, options *EventActionBackupConfig) error {
+	backup, err := DumpData(nil)
+	if err != nil {
+		return fmt.Errorf("unable to dump backup data: %w", err)
+	}
+	closers := make([]io.Closer, 0, 2)
+	w := dst
+	if options.IsEncrypted() {
+		recipients, err := getBackupRecipients(options)
+		if err != nil {
+			return err
+		}
+		encWriter, err := age.Encrypt(w, recipients...)
+		if err != nil {
+			return fmt.Errorf("unable to initialize backup encryption: %w", err)
+		}
+		closers = append(closers, encWriter)
+		w = encWriter
+	}
+	switch options.Compression {
+	case BackupCompressionGzip:
+		gzWriter := gzip.NewWriter(w)
+		closers = append(closers, gzWriter)
+		w = gzWriter
+	case BackupCompressionZstd:
+		zstdWriter, err := zstd.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("unable to initialize backup compression: %w", err)
+		}
+		closers = append(closers, zstdWriter)
+		w = zstdWriter
+	}
+	if err := json.NewEncoder(w).Encode(backup); err != nil {
+		return fmt.Errorf("unable to marshal backup data as JSON: %w", err)
+	}
+	// close the writers in reverse order, the compressor must be flushed before the encryptor
+	for idx := len(closers) - 1; idx >= 0; idx-- {
+		if err := closers[idx].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveBackup(fs vfs.Fs, fsPath string, options *EventActionBackupConfig) (int64, error) {
+	f, pipeWriter, cancelFn, err := fs.Create(fsPath, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	var w io.WriteCloser = pipeWriter
+	if f != nil {
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	err = writeBackup(cw, options)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		cancelFn()
+		w.Close()                //nolint:errcheck
+		fs.Remove(fsPath, false) //nolint:errcheck
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		cancelFn()
+		return 0, err
+	}
+	cancelFn()
+	return cw.n, nil
+}
+
+func applyBackupRetention(fs vfs.Fs, dirPath string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	fsDirPath, err := fs.ResolvePath("/" + dirPath)
+	if err != nil {
+		return err
+	}
+	lister, err := fs.ReadDir(fsDirPath)
+	if err != nil {
+		return err
+	}
+	defer lister.Close()
+
+	var backups []string
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return err
+		}
+		for _, info := range files {
+			if info.Mode().IsRegular() && backupFileNameRegex.MatchString(info.Name()) {
+				backups = append(backups, info.Name())
+			}
+		}
+		if finished {
+			break
+		}
+	}
+	if len(backups) <= retention {
+		return nil
+	}
+	// the file names start with a sortable timestamp, newer backups come first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for _, name := range backups[retention:] {
+		fsPath := fs.Join(fsDirPath, name)
+		providerLog(logger.LevelDebug, "removing backup %q, retention %d", fsPath, retention)
+		if err := fs.Remove(fsPath, false); err != nil {
+			return fmt.Errorf("unable to remove backup %q: %w", fsPath, err)
+		}
+	}
+	return nil
+}
+
+func doBackupWithOptions(options *EventActionBackupConfig) (string, int64, error) {
+	fs, err := getBackupFs(options)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get backup filesystem: %v", err)
+		return "", 0, err
+	}
+	defer fs.Close()
+
+	if err := createBackupDirs(fs, options.Path); err != nil {
+		providerLog(logger.LevelError, "unable to create backup dir %q: %v", options.Path, err)
+		return "", 0, fmt.Errorf("unable to create backup dir: %w", err)
+	}
+	fsPath, err := fs.ResolvePath(path.Join("/", options.Path, getBackupFileName(options, time.Now().UTC())))
+	if err != nil {
+		return "", 0, err
+	}
+	providerLog(logger.LevelDebug, "starting backup to file %q, folder %q", fsPath, options.Folder)
+	size, err := saveBackup(fs, fsPath, options)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to save backup %q: %v", fsPath, err)
+		return fsPath, 0, fmt.Errorf("unable to save backup: %w", err)
+	}
+	providerLog(logger.LevelDebug, "backup saved to %q, size: %d", fsPath, size)
+	if err := applyBackupRetention(fs, options.Path, options.Retention); err != nil {
+		providerLog(logger.LevelError, "unable to apply backup retention: %v", err)
+		return fsPath, size, fmt.Errorf("unable to apply backup retention: %w", err)
+	}
+	return fsPath, size, nil
+}
+
+// ExecuteBackup executes a backup using the specified options and returns the
+// path of the backup file and its size. The path is relative to the storage
+// backend of the configured folder, if any
+func ExecuteBackup(options *EventActionBackupConfig) (string, int64, error) {
+	if options == nil || options.IsDefault() {
+		backupPath, err := config.doBackup()
+		if err != nil {
+			return backupPath, 0, err
+		}
+		var size int64
+		if info, err := os.Stat(backupPath); err == nil {
+			size = info.Size()
+		}
+		return backupPath, size, nil
+	}
+	return doBackupWithOptions(options)
+}
+
+// IsBackupEncrypted returns true if the provided content is an encrypted backup
+func IsBackupEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(ageEncryptionHeader))
+}
+
+func getBackupIdentity(key string) (age.Identity, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, ageIdentityPrefix) {
+		return age.ParseX25519Identity(key)
+	}
+	return age.NewScryptIdentity(key)
+}
+
+func readBackup(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("unable to read backup: %v", err))
+		}
+		return content, nil
+	}
+	content, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, util.NewValidationError(fmt.Sprintf("unable to read backup: %v", err))
+	}
+	if int64(len(content)) > maxSize {
+		return nil, util.NewValidationError(fmt.Sprintf("the decoded backup exceeds the max allowed size: %d", maxSize))
+	}
+	return content, nil
+}
+
+// DecodeBackup returns the JSON content of a backup. Encrypted and/or
+// compressed backups are automatically detected, the key can be a passphrase
+// or an age identity. Plain JSON backups are returned unchanged.
+// maxSize limits the size of the decoded content, 0 means no limit
+func DecodeBackup(content []byte, key string, maxSize int64) ([]byte, error) {
+	if IsBackupEncrypted(content) {
+		if key == "" {
+			return nil, util.NewValidationError(ErrBackupKeyRequired.Error())
+		}
+		identity, err := getBackupIdentity(key)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("invalid backup key: %v", err))
+		}
+		r, err := age.Decrypt(bytes.NewReader(content), identity)
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("unable to decrypt backup: %v", err))
+		}
+		content, err = readBackup(r, maxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case bytes.HasPrefix(content, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("unable to decompress backup: %v", err))
+		}
+		defer r.Close()
+
+		return readBackup(r, maxSize)
+	case bytes.HasPrefix(content, zstdMagic):
+		r, err := zstd.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, util.NewValidationError(fmt.Sprintf("unable to decompress backup: %v", err))
+		}
+		defer r.Close()
+
+		return readBackup(r, maxSize)
+	}
+	return content, nil
+}
@@ -26,6 +26,7 @@ import (
 	"net/netip"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -37,7 +38,7 @@ import (
 )
 
 const (
-	boltDatabaseVersion = 28
+	boltDatabaseVersion = 29
 )
 
 var (
@@ -52,11 +53,13 @@ var (
 	rolesBucket     = []byte("roles")
 	ipListsBucket   = []byte("ip_lists")
 	configsBucket   = []byte("configs")
+	historyBucket   = []byte("object_history")
 	dbVersionBucket = []byte("db_version")
 	dbVersionKey    = []byte("version")
 	configsKey      = []byte("configs")
 	boltBuckets     = [][]byte{usersBucket, groupsBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, dbVersionBucket}
+		sharesBucket, actionsBucket, rulesBucket, rolesBucket, ipListsBucket, configsBucket, historyBucket,
+		dbVersionBucket}
 )
 
 // BoltProvider defines the auth provider for bolt key/value store
@@ -138,17 +141,17 @@ func (p *BoltProvider) validateAdminAndPass(username, password, ip string) (Admi
 	return admin, err
 }
 
-func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
 	var user User
 	if len(pubKey) == 0 {
-		return user, "", errors.New("credentials cannot be null or empty")
+		return user, PublicKey{}, errors.New("credentials cannot be null or empty")
 	}
 	user, err := p.userExists(username, "")
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
-		return user, "", err
+		return user, PublicKey{}, err
 	}
-	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+	return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 }
 
 func (p *BoltProvider) updateAPIKeyLastUse(keyID string) error {
@@ -442,6 +445,9 @@ func (p *BoltProvider) updateAdmin(admin *Admin) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(admin.ExpectedVersion, oldAdmin.UpdatedAt); err != nil {
+			return err
+		}
 
 		if err = p.removeAdminFromRole(oldAdmin.Username, oldAdmin.Role, rolesBucket); err != nil {
 			return err
@@ -464,7 +470,7 @@ func (p *BoltProvider) updateAdmin(admin *Admin) error {
 		admin.ID = oldAdmin.ID
 		admin.CreatedAt = oldAdmin.CreatedAt
 		admin.LastLogin = oldAdmin.LastLogin
-		admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		admin.UpdatedAt = getNextObjectVersion(oldAdmin.UpdatedAt)
 		buf, err := json.Marshal(admin)
 		if err != nil {
 			return err
@@ -489,6 +495,9 @@ func (p *BoltProvider) deleteAdmin(admin Admin) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(admin.ExpectedVersion, oldAdmin.UpdatedAt); err != nil {
+			return err
+		}
 		if len(oldAdmin.Groups) > 0 {
 			groupBucket, err := p.getGroupsBucket(tx)
 			if err != nil {
@@ -675,6 +684,8 @@ func (p *BoltProvider) addUser(user *User) error {
 				return err
 			}
 		}
+		// the parsed public keys are only used for rendering
+		user.PublicKeysInfo = nil
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
@@ -689,44 +700,71 @@ func (p *BoltProvider) updateUser(user *User) error {
 		return err
 	}
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := p.getUsersBucket(tx)
-		if err != nil {
-			return err
-		}
-		var u []byte
-		if u = bucket.Get([]byte(user.Username)); u == nil {
-			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
-		}
-		var oldUser User
-		err = json.Unmarshal(u, &oldUser)
-		if err != nil {
-			return err
-		}
-		if err = p.updateUserRelations(tx, user, oldUser); err != nil {
-			return err
+		return p.updateUserInTx(tx, user)
+	})
+}
+
+func (p *BoltProvider) updateUsers(users []*User) error {
+	for _, user := range users {
+		if err := ValidateUser(user); err != nil {
+			return newBulkUpdateError(user.Username, err)
 		}
-		user.ID = oldUser.ID
-		user.LastQuotaUpdate = oldUser.LastQuotaUpdate
-		user.UsedQuotaSize = oldUser.UsedQuotaSize
-		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
-		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
-		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
-		user.LastLogin = oldUser.LastLogin
-		user.FirstDownload = oldUser.FirstDownload
-		user.FirstUpload = oldUser.FirstUpload
-		user.CreatedAt = oldUser.CreatedAt
-		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		buf, err := json.Marshal(user)
-		if err != nil {
-			return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		for _, user := range users {
+			if err := p.updateUserInTx(tx, user); err != nil {
+				return newBulkUpdateError(user.Username, err)
+			}
 		}
+		return nil
+	})
+}
 
-		err = bucket.Put([]byte(user.Username), buf)
-		if err == nil {
-			setLastUserUpdate()
-		}
+func (p *BoltProvider) updateUserInTx(tx *bolt.Tx, user *User) error {
+	bucket, err := p.getUsersBucket(tx)
+	if err != nil {
 		return err
-	})
+	}
+	var u []byte
+	if u = bucket.Get([]byte(user.Username)); u == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", user.Username))
+	}
+	var oldUser User
+	err = json.Unmarshal(u, &oldUser)
+	if err != nil {
+		return err
+	}
+	if err = checkObjectVersion(user.ExpectedVersion, oldUser.UpdatedAt); err != nil {
+		return err
+	}
+	if err = p.updateUserRelations(tx, user, oldUser); err != nil {
+		return err
+	}
+	user.ID = oldUser.ID
+	user.mergePublicKeysLastUse(oldUser.Filters.PublicKeysLastUse)
+	user.Filters.CryptFsReEncryption = oldUser.Filters.CryptFsReEncryption
+	user.LastQuotaUpdate = oldUser.LastQuotaUpdate
+	user.UsedQuotaSize = oldUser.UsedQuotaSize
+	user.UsedQuotaFiles = oldUser.UsedQuotaFiles
+	user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+	user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+	user.LastLogin = oldUser.LastLogin
+	user.FirstDownload = oldUser.FirstDownload
+	user.FirstUpload = oldUser.FirstUpload
+	user.CreatedAt = oldUser.CreatedAt
+	user.UpdatedAt = getNextObjectVersion(oldUser.UpdatedAt)
+	// the parsed public keys are only used for rendering
+	user.PublicKeysInfo = nil
+	buf, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Put([]byte(user.Username), buf)
+	if err == nil {
+		setLastUserUpdate()
+	}
+	return err
 }
 
 func (p *BoltProvider) deleteUser(user User, _ bool) error {
@@ -756,6 +794,9 @@ func (p *BoltProvider) deleteUser(user User, _ bool) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(user.ExpectedVersion, oldUser.UpdatedAt); err != nil {
+			return err
+		}
 		if err := p.removeUserFromRole(oldUser.Username, oldUser.Role, rolesBucket); err != nil {
 			return err
 		}
@@ -805,6 +846,60 @@ func (p *BoltProvider) updateUserPassword(username, password string) error {
 	})
 }
 
+func (p *BoltProvider) updateUserPublicKeyLastUse(username, fingerprint string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if user.Filters.PublicKeysLastUse == nil {
+			user.Filters.PublicKeysLastUse = make(map[string]int64)
+		}
+		user.Filters.PublicKeysLastUse[fingerprint] = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(username), buf)
+	})
+}
+
+func (p *BoltProvider) updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if err := checkCryptFsReEncryptionRunID(user.Filters.CryptFsReEncryption, expectedRunID); err != nil {
+			return err
+		}
+		user.Filters.CryptFsReEncryption = state
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(username), buf)
+	})
+}
+
 func (p *BoltProvider) dumpUsers() ([]User, error) {
 	users := make([]User, 0, 100)
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -949,7 +1044,7 @@ func (p *BoltProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, e
 	return users, err
 }
 
-func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *BoltProvider) getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -968,15 +1063,15 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
 				user, err := p.joinUserAndFolders(v, foldersBucket)
 				if err != nil {
 					return err
 				}
-				if !user.hasRole(role) {
+				if !filters.match(&user) {
+					continue
+				}
+				itNum++
+				if itNum <= offset {
 					continue
 				}
 				user.PrepareForRendering()
@@ -987,15 +1082,15 @@ func (p *BoltProvider) getUsers(limit int, offset int, order, role string) ([]Us
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
 				user, err := p.joinUserAndFolders(v, foldersBucket)
 				if err != nil {
 					return err
 				}
-				if !user.hasRole(role) {
+				if !filters.match(&user) {
+					continue
+				}
+				itNum++
+				if itNum <= offset {
 					continue
 				}
 				user.PrepareForRendering()
@@ -1031,7 +1126,7 @@ func (p *BoltProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return folders, err
 }
 
-func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vfs.BaseVirtualFolder, error) {
+func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -1046,15 +1141,18 @@ func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vf
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
 				var folder vfs.BaseVirtualFolder
 				err = json.Unmarshal(v, &folder)
 				if err != nil {
 					return err
 				}
+				if !filters.match(&folder) {
+					continue
+				}
+				itNum++
+				if itNum <= offset {
+					continue
+				}
 				folder.PrepareForRendering()
 				folders = append(folders, folder)
 				if len(folders) >= limit {
@@ -1063,15 +1161,18 @@ func (p *BoltProvider) getFolders(limit, offset int, order string, _ bool) ([]vf
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
 				var folder vfs.BaseVirtualFolder
 				err = json.Unmarshal(v, &folder)
 				if err != nil {
 					return err
 				}
+				if !filters.match(&folder) {
+					continue
+				}
+				itNum++
+				if itNum <= offset {
+					continue
+				}
 				folder.PrepareForRendering()
 				folders = append(folders, folder)
 				if len(folders) >= limit {
@@ -1122,35 +1223,59 @@ func (p *BoltProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 		return err
 	}
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := p.getFoldersBucket(tx)
-		if err != nil {
-			return err
-		}
-		var f []byte
+		return p.updateFolderInTx(tx, folder)
+	})
+}
 
-		if f = bucket.Get([]byte(folder.Name)); f == nil {
-			return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+func (p *BoltProvider) updateFolders(folders []*vfs.BaseVirtualFolder) error {
+	for _, folder := range folders {
+		if err := ValidateFolder(folder); err != nil {
+			return newBulkUpdateError(folder.Name, err)
 		}
-		var oldFolder vfs.BaseVirtualFolder
-		err = json.Unmarshal(f, &oldFolder)
-		if err != nil {
-			return err
-		}
-
-		folder.ID = oldFolder.ID
-		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
-		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
-		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
-		folder.Users = oldFolder.Users
-		folder.Groups = oldFolder.Groups
-		buf, err := json.Marshal(folder)
-		if err != nil {
-			return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		for _, folder := range folders {
+			if err := p.updateFolderInTx(tx, folder); err != nil {
+				return newBulkUpdateError(folder.Name, err)
+			}
 		}
-		return bucket.Put([]byte(folder.Name), buf)
+		return nil
 	})
 }
 
+func (p *BoltProvider) updateFolderInTx(tx *bolt.Tx, folder *vfs.BaseVirtualFolder) error {
+	bucket, err := p.getFoldersBucket(tx)
+	if err != nil {
+		return err
+	}
+	var f []byte
+
+	if f = bucket.Get([]byte(folder.Name)); f == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("folder %v does not exist", folder.Name))
+	}
+	var oldFolder vfs.BaseVirtualFolder
+	err = json.Unmarshal(f, &oldFolder)
+	if err != nil {
+		return err
+	}
+	if err = checkObjectVersion(folder.ExpectedVersion, oldFolder.UpdatedAt); err != nil {
+		return err
+	}
+
+	folder.ID = oldFolder.ID
+	folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
+	folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
+	folder.UsedQuotaSize = oldFolder.UsedQuotaSize
+	folder.Users = oldFolder.Users
+	folder.Groups = oldFolder.Groups
+	folder.UpdatedAt = getNextObjectVersion(oldFolder.UpdatedAt)
+	buf, err := json.Marshal(folder)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(folder.Name), buf)
+}
+
 func (p *BoltProvider) deleteFolderMappings(folder vfs.BaseVirtualFolder, usersBucket, groupsBucket *bolt.Bucket) error {
 	for _, username := range folder.Users {
 		var u []byte
@@ -1231,6 +1356,9 @@ func (p *BoltProvider) deleteFolder(baseFolder vfs.BaseVirtualFolder) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(baseFolder.ExpectedVersion, folder.UpdatedAt); err != nil {
+			return err
+		}
 		if err = p.deleteFolderMappings(folder, usersBucket, groupsBucket); err != nil {
 			return err
 		}
@@ -1279,7 +1407,7 @@ func (p *BoltProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return folder.UsedQuotaFiles, folder.UsedQuotaSize, err
 }
 
-func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Group, error) {
+func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool, filters SearchFilters) ([]Group, error) {
 	groups := make([]Group, 0, limit)
 	var err error
 	if limit <= 0 {
@@ -1298,6 +1426,9 @@ func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Gro
 		itNum := 0
 		if order == OrderASC {
 			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				if !filters.match(string(k)) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1315,6 +1446,9 @@ func (p *BoltProvider) getGroups(limit, offset int, order string, _ bool) ([]Gro
 			}
 		} else {
 			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if !filters.match(string(k)) {
+					continue
+				}
 				itNum++
 				if itNum <= offset {
 					continue
@@ -1452,46 +1586,69 @@ func (p *BoltProvider) updateGroup(group *Group) error {
 		return err
 	}
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := p.getGroupsBucket(tx)
-		if err != nil {
-			return err
-		}
-		foldersBucket, err := p.getFoldersBucket(tx)
-		if err != nil {
-			return err
+		return p.updateGroupInTx(tx, group)
+	})
+}
+
+func (p *BoltProvider) updateGroups(groups []*Group) error {
+	for _, group := range groups {
+		if err := group.validate(); err != nil {
+			return newBulkUpdateError(group.Name, err)
 		}
-		var g []byte
-		if g = bucket.Get([]byte(group.Name)); g == nil {
-			return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		for _, group := range groups {
+			if err := p.updateGroupInTx(tx, group); err != nil {
+				return newBulkUpdateError(group.Name, err)
+			}
 		}
-		var oldGroup Group
-		err = json.Unmarshal(g, &oldGroup)
+		return nil
+	})
+}
+
+func (p *BoltProvider) updateGroupInTx(tx *bolt.Tx, group *Group) error {
+	bucket, err := p.getGroupsBucket(tx)
+	if err != nil {
+		return err
+	}
+	foldersBucket, err := p.getFoldersBucket(tx)
+	if err != nil {
+		return err
+	}
+	var g []byte
+	if g = bucket.Get([]byte(group.Name)); g == nil {
+		return util.NewRecordNotFoundError(fmt.Sprintf("group %q does not exist", group.Name))
+	}
+	var oldGroup Group
+	err = json.Unmarshal(g, &oldGroup)
+	if err != nil {
+		return err
+	}
+	if err = checkObjectVersion(group.ExpectedVersion, oldGroup.UpdatedAt); err != nil {
+		return err
+	}
+	for idx := range oldGroup.VirtualFolders {
+		err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
 		if err != nil {
 			return err
 		}
-		for idx := range oldGroup.VirtualFolders {
-			err = p.removeRelationFromFolderMapping(oldGroup.VirtualFolders[idx], "", oldGroup.Name, foldersBucket)
-			if err != nil {
-				return err
-			}
-		}
-		for idx := range group.VirtualFolders {
-			err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
-			if err != nil {
-				return err
-			}
-		}
-		group.ID = oldGroup.ID
-		group.CreatedAt = oldGroup.CreatedAt
-		group.Users = oldGroup.Users
-		group.Admins = oldGroup.Admins
-		group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		buf, err := json.Marshal(group)
+	}
+	for idx := range group.VirtualFolders {
+		err = p.addRelationToFolderMapping(&group.VirtualFolders[idx].BaseVirtualFolder, nil, group, foldersBucket)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(group.Name), buf)
-	})
+	}
+	group.ID = oldGroup.ID
+	group.CreatedAt = oldGroup.CreatedAt
+	group.Users = oldGroup.Users
+	group.Admins = oldGroup.Admins
+	group.UpdatedAt = getNextObjectVersion(oldGroup.UpdatedAt)
+	buf, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(group.Name), buf)
 }
 
 func (p *BoltProvider) deleteGroup(group Group) error {
@@ -1509,6 +1666,9 @@ func (p *BoltProvider) deleteGroup(group Group) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(group.ExpectedVersion, oldGroup.UpdatedAt); err != nil {
+			return err
+		}
 		if len(oldGroup.Users) > 0 {
 			return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", oldGroup.Name))
 		}
@@ -1641,13 +1801,16 @@ func (p *BoltProvider) updateAPIKey(apiKey *APIKey) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(apiKey.ExpectedVersion, oldAPIKey.UpdatedAt); err != nil {
+			return err
+		}
 
 		apiKey.ID = oldAPIKey.ID
 		apiKey.KeyID = oldAPIKey.KeyID
 		apiKey.Key = oldAPIKey.Key
 		apiKey.CreatedAt = oldAPIKey.CreatedAt
 		apiKey.LastUseAt = oldAPIKey.LastUseAt
-		apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = getNextObjectVersion(oldAPIKey.UpdatedAt)
 		if apiKey.User != "" {
 			if err := p.userExistsInternal(tx, apiKey.User); err != nil {
 				return util.NewValidationError(fmt.Sprintf("related user %q does not exists", apiKey.User))
@@ -1673,9 +1836,17 @@ func (p *BoltProvider) deleteAPIKey(apiKey APIKey) error {
 			return err
 		}
 
-		if bucket.Get([]byte(apiKey.KeyID)) == nil {
+		var k []byte
+		if k = bucket.Get([]byte(apiKey.KeyID)); k == nil {
 			return util.NewRecordNotFoundError(fmt.Sprintf("API key %v does not exist", apiKey.KeyID))
 		}
+		var oldAPIKey APIKey
+		if err = json.Unmarshal(k, &oldAPIKey); err != nil {
+			return err
+		}
+		if err = checkObjectVersion(apiKey.ExpectedVersion, oldAPIKey.UpdatedAt); err != nil {
+			return err
+		}
 
 		return bucket.Delete([]byte(apiKey.KeyID))
 	})
@@ -1841,6 +2012,9 @@ func (p *BoltProvider) updateShare(share *Share) error {
 		if oldObject.Username != share.Username {
 			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
 		}
+		if err = checkObjectVersion(share.ExpectedVersion, oldObject.UpdatedAt); err != nil {
+			return err
+		}
 
 		share.ID = oldObject.ID
 		share.ShareID = oldObject.ShareID
@@ -1848,7 +2022,7 @@ func (p *BoltProvider) updateShare(share *Share) error {
 			share.UsedTokens = oldObject.UsedTokens
 			share.CreatedAt = oldObject.CreatedAt
 			share.LastUseAt = oldObject.LastUseAt
-			share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+			share.UpdatedAt = getNextObjectVersion(oldObject.UpdatedAt)
 		}
 		if share.CreatedAt == 0 {
 			share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
@@ -1886,12 +2060,15 @@ func (p *BoltProvider) deleteShare(share Share) error {
 		if oldObject.Username != share.Username {
 			return util.NewRecordNotFoundError(fmt.Sprintf("Share %v does not exist", share.ShareID))
 		}
+		if err = checkObjectVersion(share.ExpectedVersion, oldObject.UpdatedAt); err != nil {
+			return err
+		}
 
 		return bucket.Delete([]byte(share.ShareID))
 	})
 }
 
-func (p *BoltProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+func (p *BoltProvider) getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error) {
 	shares := make([]Share, 0, limit)
 
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -1907,7 +2084,7 @@ func (p *BoltProvider) getShares(limit int, offset int, order, username string)
 				if err := json.Unmarshal(v, &share); err != nil {
 					return err
 				}
-				if share.Username != username {
+				if share.Username != username || !filters.match(share.Name) {
 					continue
 				}
 				itNum++
@@ -1928,7 +2105,7 @@ func (p *BoltProvider) getShares(limit int, offset int, order, username string)
 			if err != nil {
 				return err
 			}
-			if share.Username != username {
+			if share.Username != username || !filters.match(share.Name) {
 				continue
 			}
 			itNum++
@@ -2065,6 +2242,10 @@ func (p *BoltProvider) getSharedSession(_ string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *BoltProvider) getSharedSessionsUpdatedAfter(_ SessionType, _ int64) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *BoltProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
 	return ErrNotImplemented
 }
@@ -2177,6 +2358,7 @@ func (p *BoltProvider) addEventAction(action *BaseEventAction) error {
 		}
 		action.ID = int64(id)
 		action.Rules = nil
+		action.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		buf, err := json.Marshal(action)
 		if err != nil {
 			return err
@@ -2205,9 +2387,13 @@ func (p *BoltProvider) updateEventAction(action *BaseEventAction) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(action.ExpectedVersion, oldAction.UpdatedAt); err != nil {
+			return err
+		}
 		action.ID = oldAction.ID
 		action.Name = oldAction.Name
 		action.Rules = nil
+		action.UpdatedAt = getNextObjectVersion(oldAction.UpdatedAt)
 		if len(oldAction.Rules) > 0 {
 			rulesBucket, err := p.getRulesBucket(tx)
 			if err != nil {
@@ -2260,6 +2446,9 @@ func (p *BoltProvider) deleteEventAction(action BaseEventAction) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(action.ExpectedVersion, oldAction.UpdatedAt); err != nil {
+			return err
+		}
 		if len(oldAction.Rules) > 0 {
 			return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
 		}
@@ -2480,6 +2669,9 @@ func (p *BoltProvider) updateEventRule(rule *EventRule) error {
 		if err = json.Unmarshal(r, &oldRule); err != nil {
 			return err
 		}
+		if err = checkObjectVersion(rule.ExpectedVersion, oldRule.UpdatedAt); err != nil {
+			return err
+		}
 		for idx := range oldRule.Actions {
 			if err = p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name, actionsBucket); err != nil {
 				return err
@@ -2492,7 +2684,7 @@ func (p *BoltProvider) updateEventRule(rule *EventRule) error {
 		}
 		rule.ID = oldRule.ID
 		rule.CreatedAt = oldRule.CreatedAt
-		rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		rule.UpdatedAt = getNextObjectVersion(oldRule.UpdatedAt)
 		buf, err := json.Marshal(rule)
 		if err != nil {
 			return err
@@ -2522,6 +2714,9 @@ func (p *BoltProvider) deleteEventRule(rule EventRule, _ bool) error {
 		if err = json.Unmarshal(r, &oldRule); err != nil {
 			return err
 		}
+		if err = checkObjectVersion(rule.ExpectedVersion, oldRule.UpdatedAt); err != nil {
+			return err
+		}
 		if len(oldRule.Actions) > 0 {
 			actionsBucket, err := p.getActionsBucket(tx)
 			if err != nil {
@@ -2573,6 +2768,215 @@ func (*BoltProvider) cleanupNodes() error {
 	return ErrNotImplemented
 }
 
+func (*BoltProvider) addChangeNotification(_ *ChangeNotification) error {
+	return ErrNotImplemented
+}
+
+func (*BoltProvider) getChangeNotifications(_ int64) ([]ChangeNotification, error) {
+	return nil, ErrNotImplemented
+}
+
+func (*BoltProvider) cleanupChangeNotifications(_ int64) error {
+	return ErrNotImplemented
+}
+
+func (p *BoltProvider) addObjectVersion(version *ObjectVersion) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getHistoryBucket(tx)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		version.ID = int64(id)
+		buf, err := json.Marshal(version)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(getObjectVersionKey(version.ObjectType, version.ObjectName, version.ID), buf); err != nil {
+			return err
+		}
+		if config.ObjectHistory.MaxVersions <= 0 {
+			return nil
+		}
+		prefix := getObjectVersionKeyPrefix(version.ObjectType, version.ObjectName)
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for len(keys) > config.ObjectHistory.MaxVersions {
+			if err := bucket.Delete(keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error) {
+	versions := make([]ObjectVersion, 0, limit)
+	if limit <= 0 {
+		return versions, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getHistoryBucket(tx)
+		if err != nil {
+			return err
+		}
+		prefix := getObjectVersionKeyPrefix(objectType, objectName)
+		cursor := bucket.Cursor()
+		var k, v []byte
+		if order == OrderASC {
+			k, v = cursor.Seek(prefix)
+		} else {
+			k, v = seekLastObjectVersion(cursor, prefix)
+		}
+		itNum := 0
+		for ; k != nil && bytes.HasPrefix(k, prefix); itNum++ {
+			if itNum >= offset {
+				var version ObjectVersion
+				if err := json.Unmarshal(v, &version); err != nil {
+					return err
+				}
+				version.Data = nil
+				versions = append(versions, version)
+				if len(versions) >= limit {
+					break
+				}
+			}
+			if order == OrderASC {
+				k, v = cursor.Next()
+			} else {
+				k, v = cursor.Prev()
+			}
+		}
+		return nil
+	})
+	return versions, err
+}
+
+func (p *BoltProvider) getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	var version ObjectVersion
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getHistoryBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get(getObjectVersionKey(objectType, objectName, id))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("version %d for %s %q not found", id, objectType, objectName))
+		}
+		return json.Unmarshal(v, &version)
+	})
+	return version, err
+}
+
+func (p *BoltProvider) getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	var version ObjectVersion
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := p.getHistoryBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		k, _ := cursor.Seek(getObjectVersionKey(objectType, objectName, id))
+		var v []byte
+		if k == nil {
+			k, v = cursor.Last()
+		} else {
+			k, v = cursor.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, getObjectVersionKeyPrefix(objectType, objectName)) {
+			return util.NewRecordNotFoundError(fmt.Sprintf("no version before %d for %s %q", id, objectType, objectName))
+		}
+		return json.Unmarshal(v, &version)
+	})
+	return version, err
+}
+
+func (p *BoltProvider) cleanupObjectHistory(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := p.getHistoryBucket(tx)
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var version ObjectVersion
+			if err := json.Unmarshal(v, &version); err != nil {
+				return err
+			}
+			if version.CreatedAt < before {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) restoreTimestamps(timestamps *objectTimestamps) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		var bucket *bolt.Bucket
+		var err error
+		key := timestamps.Name
+
+		switch timestamps.ObjectType {
+		case actionObjectUser:
+			bucket, err = p.getUsersBucket(tx)
+		case actionObjectGroup:
+			bucket, err = p.getGroupsBucket(tx)
+		case actionObjectFolder:
+			bucket, err = p.getFoldersBucket(tx)
+		case actionObjectAdmin:
+			bucket, err = p.getAdminsBucket(tx)
+		case actionObjectAPIKey:
+			bucket, err = p.getAPIKeysBucket(tx)
+		case actionObjectEventAction:
+			bucket, err = p.getActionsBucket(tx)
+		case actionObjectEventRule:
+			bucket, err = p.getRulesBucket(tx)
+		case actionObjectRole:
+			bucket, err = p.getRolesBucket(tx)
+		case actionObjectIPListEntry:
+			entry := IPListEntry{IPOrNet: timestamps.Name, Type: timestamps.ListType}
+			key = entry.getKey()
+			bucket, err = p.getIPListsBucket(tx)
+		default:
+			return fmt.Errorf("unable to restore timestamps, unsupported object type %q", timestamps.ObjectType)
+		}
+		if err != nil {
+			return err
+		}
+		o := bucket.Get([]byte(key))
+		if o == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("%s %q does not exist", timestamps.ObjectType, timestamps.Name))
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(o, &object); err != nil {
+			return err
+		}
+		fields, values := timestamps.getFields()
+		for idx, field := range fields {
+			object[field] = json.RawMessage(strconv.FormatInt(values[idx], 10))
+		}
+		buf, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), buf)
+	})
+}
+
 func (p *BoltProvider) roleExists(name string) (Role, error) {
 	var role Role
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -2636,9 +3040,12 @@ func (p *BoltProvider) updateRole(role *Role) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(role.ExpectedVersion, oldRole.UpdatedAt); err != nil {
+			return err
+		}
 		role.ID = oldRole.ID
 		role.CreatedAt = oldRole.CreatedAt
-		role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		role.UpdatedAt = getNextObjectVersion(oldRole.UpdatedAt)
 		role.Users = oldRole.Users
 		role.Admins = oldRole.Admins
 		buf, err := json.Marshal(role)
@@ -2664,6 +3071,9 @@ func (p *BoltProvider) deleteRole(role Role) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(role.ExpectedVersion, oldRole.UpdatedAt); err != nil {
+			return err
+		}
 		if len(oldRole.Admins) > 0 {
 			return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
 		}
@@ -2817,8 +3227,11 @@ func (p *BoltProvider) updateIPListEntry(entry *IPListEntry) error {
 		if err != nil {
 			return err
 		}
+		if err = checkObjectVersion(entry.ExpectedVersion, oldEntry.UpdatedAt); err != nil {
+			return err
+		}
 		entry.CreatedAt = oldEntry.CreatedAt
-		entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		entry.UpdatedAt = getNextObjectVersion(oldEntry.UpdatedAt)
 		buf, err := json.Marshal(entry)
 		if err != nil {
 			return err
@@ -2833,9 +3246,17 @@ func (p *BoltProvider) deleteIPListEntry(entry IPListEntry, _ bool) error {
 		if err != nil {
 			return err
 		}
-		if e := bucket.Get([]byte(entry.getKey())); e == nil {
+		e := bucket.Get([]byte(entry.getKey()))
+		if e == nil {
 			return fmt.Errorf("entry %q does not exist", entry.IPOrNet)
 		}
+		var oldEntry IPListEntry
+		if err = json.Unmarshal(e, &oldEntry); err != nil {
+			return err
+		}
+		if err = checkObjectVersion(entry.ExpectedVersion, oldEntry.UpdatedAt); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(entry.getKey()))
 	})
 }
@@ -2979,6 +3400,103 @@ func (p *BoltProvider) getListEntriesForIP(ip string, listType IPListType) ([]IP
 	return entries, err
 }
 
+func (p *BoltProvider) dumpPage(scope, after string, limit int) (BackupData, error) {
+	data := BackupData{
+		Version: DumpVersion,
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		var bucket *bolt.Bucket
+		var err error
+		var decode func(v []byte) error
+
+		switch scope {
+		case DumpScopeUsers, DumpScopeGroups:
+			var foldersBucket *bolt.Bucket
+			foldersBucket, err = p.getFoldersBucket(tx)
+			if err != nil {
+				return err
+			}
+			if scope == DumpScopeUsers {
+				bucket, err = p.getUsersBucket(tx)
+				decode = func(v []byte) error {
+					user, err := p.joinUserAndFolders(v, foldersBucket)
+					data.Users = append(data.Users, user)
+					return err
+				}
+			} else {
+				bucket, err = p.getGroupsBucket(tx)
+				decode = func(v []byte) error {
+					group, err := p.joinGroupAndFolders(v, foldersBucket)
+					data.Groups = append(data.Groups, group)
+					return err
+				}
+			}
+		case DumpScopeFolders:
+			bucket, err = p.getFoldersBucket(tx)
+			decode = func(v []byte) error {
+				var folder vfs.BaseVirtualFolder
+				err := json.Unmarshal(v, &folder)
+				data.Folders = append(data.Folders, folder)
+				return err
+			}
+		case DumpScopeAdmins:
+			bucket, err = p.getAdminsBucket(tx)
+			decode = func(v []byte) error {
+				var admin Admin
+				err := json.Unmarshal(v, &admin)
+				data.Admins = append(data.Admins, admin)
+				return err
+			}
+		case DumpScopeAPIKeys:
+			bucket, err = p.getAPIKeysBucket(tx)
+			decode = func(v []byte) error {
+				var apiKey APIKey
+				err := json.Unmarshal(v, &apiKey)
+				data.APIKeys = append(data.APIKeys, apiKey)
+				return err
+			}
+		case DumpScopeShares:
+			bucket, err = p.getSharesBucket(tx)
+			decode = func(v []byte) error {
+				var share Share
+				err := json.Unmarshal(v, &share)
+				data.Shares = append(data.Shares, share)
+				return err
+			}
+		case DumpScopeIPLists:
+			bucket, err = p.getIPListsBucket(tx)
+			decode = func(v []byte) error {
+				var entry IPListEntry
+				err := json.Unmarshal(v, &entry)
+				entry.PrepareForRendering()
+				data.IPLists = append(data.IPLists, entry)
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported dump scope %q", scope)
+		}
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		k, v := cursor.First()
+		if after != "" {
+			k, v = cursor.Seek([]byte(after))
+			if k != nil && string(k) == after {
+				k, v = cursor.Next()
+			}
+		}
+		for n := 0; k != nil && n < limit; k, v = cursor.Next() {
+			if err := decode(v); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return data, err
+}
+
 func (p *BoltProvider) getConfigs() (Configs, error) {
 	var configs Configs
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -3012,6 +3530,10 @@ func (p *BoltProvider) setConfigs(configs *Configs) error {
 	})
 }
 
+func (p *BoltProvider) restore(plan *restorePlan) error {
+	return plan.applyWithRollback(p)
+}
+
 func (p *BoltProvider) setFirstDownloadTimestamp(username string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := p.getUsersBucket(tx)
@@ -3130,7 +3652,17 @@ func (p *BoltProvider) migrateDatabase() error {
 		if err != nil {
 			return err
 		}
-		return updateBoltDatabaseVersion(p.dbHandle, 28)
+		if err := updateBoltDatabaseVersion(p.dbHandle, 28); err != nil {
+			return err
+		}
+		return p.migrateDatabase()
+	case version == 28:
+		logger.InfoToConsole("updating database schema version: %d -> 29", version)
+		providerLog(logger.LevelInfo, "updating database schema version: %d -> 29", version)
+		if err := p.moveIPListEntriesFromRolesBucket(); err != nil {
+			return err
+		}
+		return updateBoltDatabaseVersion(p.dbHandle, 29)
 	default:
 		if version > boltDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -3143,6 +3675,43 @@ func (p *BoltProvider) migrateDatabase() error {
 	}
 }
 
+// moveIPListEntriesFromRolesBucket moves the IP list entries stored, by mistake,
+// within the roles bucket to the IP lists bucket
+func (p *BoltProvider) moveIPListEntriesFromRolesBucket() error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		roles, err := p.getRolesBucket(tx)
+		if err != nil {
+			return err
+		}
+		ipLists, err := p.getIPListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var keys [][]byte
+		cursor := roles.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var entry IPListEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if entry.IPOrNet == "" {
+				continue
+			}
+			if err := ipLists.Put(k, v); err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := roles.Delete(k); err != nil {
+				return err
+			}
+		}
+		providerLog(logger.LevelInfo, "IP list entries moved from the roles bucket: %d", len(keys))
+		return nil
+	})
+}
+
 func (p *BoltProvider) revertDatabase(targetVersion int) error { //nolint:gocyclo
 	dbVersion, err := getBoltDatabaseVersion(p.dbHandle)
 	if err != nil {
@@ -3152,7 +3721,7 @@ func (p *BoltProvider) revertDatabase(targetVersion int) error { //nolint:gocycl
 		return errors.New("current version match target version, nothing to do")
 	}
 	switch dbVersion.Version {
-	case 24, 25, 26, 27, 28:
+	case 24, 25, 26, 27, 28, 29:
 		logger.InfoToConsole("downgrading database schema version: %d -> 23", dbVersion.Version)
 		providerLog(logger.LevelInfo, "downgrading database schema version: %d -> 23", dbVersion.Version)
 		err := p.dbHandle.Update(func(tx *bolt.Tx) error {
@@ -3309,6 +3878,7 @@ func (p *BoltProvider) addFolderInternal(folder vfs.BaseVirtualFolder, bucket *b
 		return err
 	}
 	folder.ID = int64(id)
+	folder.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	buf, err := json.Marshal(folder)
 	if err != nil {
 		return err
@@ -3904,9 +4474,18 @@ func (p *BoltProvider) getRolesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func (p *BoltProvider) getHistoryBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(historyBucket)
+	if bucket == nil {
+		err = fmt.Errorf("unable to find object history bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func (p *BoltProvider) getIPListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
-	bucket := tx.Bucket(rolesBucket)
+	bucket := tx.Bucket(ipListsBucket)
 	if bucket == nil {
 		err = fmt.Errorf("unable to find IP lists bucket, bolt database structure not correcly defined")
 	}
@@ -3976,3 +4555,18 @@ func updateBoltDatabaseVersion(dbHandle *bolt.DB, version int) error {
 	})
 	return err
 }
+
+func getObjectVersionKey(objectType, objectName string, id int64) []byte {
+	return []byte(fmt.Sprintf("%s\x00%s\x00%020d", objectType, objectName, id))
+}
+
+// seekLastObjectVersion moves the cursor to the last key with the specified prefix
+func seekLastObjectVersion(cursor *bolt.Cursor, prefix []byte) ([]byte, []byte) {
+	end := append([]byte(nil), prefix...)
+	end[len(end)-1]++
+	k, _ := cursor.Seek(end)
+	if k == nil {
+		return cursor.Last()
+	}
+	return cursor.Prev()
+}
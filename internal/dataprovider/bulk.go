@@ -0,0 +1,424 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Supported patch types for bulk updates
+const (
+	// RFC 6902 JSON Patch
+	BulkPatchTypeJSON = "json_patch"
+	// RFC 7386 JSON Merge Patch
+	BulkPatchTypeMerge = "merge_patch"
+)
+
+// Bulk update statuses for the single objects
+const (
+	BulkUpdateStatusUpdated   = "updated"
+	BulkUpdateStatusUnchanged = "unchanged"
+	BulkUpdateStatusFailed    = "failed"
+	// the object can be updated but the changes were not saved because
+	// another object failed
+	BulkUpdateStatusSkipped = "skipped"
+)
+
+// MaxBulkUpdateObjects defines the maximum number of objects that can be
+// selected for a single bulk update
+const MaxBulkUpdateObjects = 1000
+
+// BulkUpdateOptions defines the patch to apply to the objects selected for a bulk update
+type BulkUpdateOptions struct {
+	// json_patch or merge_patch
+	PatchType string `json:"patch_type"`
+	// array of JSON Patch operations or JSON Merge Patch object
+	Patch json.RawMessage `json:"patch"`
+	// If true the patched objects are validated but the changes are not saved
+	DryRun     bool `json:"dry_run,omitempty"`
+	operations []util.JSONPatchOperation
+}
+
+func (o *BulkUpdateOptions) validate() error {
+	patch := bytes.TrimSpace(o.Patch)
+	if len(patch) == 0 {
+		return util.NewValidationError("patch is mandatory")
+	}
+	switch o.PatchType {
+	case BulkPatchTypeJSON:
+		if err := json.Unmarshal(patch, &o.operations); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid JSON patch, it must be an array of operations: %v", err))
+		}
+		if len(o.operations) == 0 {
+			return util.NewValidationError("the JSON patch must contain at least an operation")
+		}
+	case BulkPatchTypeMerge:
+		if patch[0] != '{' {
+			return util.NewValidationError("invalid merge patch, it must be a JSON object")
+		}
+	default:
+		return util.NewValidationError(fmt.Sprintf("invalid patch type %q", o.PatchType))
+	}
+	return nil
+}
+
+func (o *BulkUpdateOptions) apply(doc []byte) ([]byte, error) {
+	if o.PatchType == BulkPatchTypeJSON {
+		return util.ApplyJSONPatch(doc, o.operations)
+	}
+	return util.ApplyJSONMergePatch(doc, o.Patch)
+}
+
+// BulkUpdateResult defines the bulk update outcome for a single object
+type BulkUpdateResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkUpdateResults defines the outcome of a bulk update
+type BulkUpdateResults struct {
+	DryRun bool `json:"dry_run"`
+	// true if all the changes were saved
+	Applied bool               `json:"applied"`
+	Results []BulkUpdateResult `json:"results"`
+}
+
+// HasFailures returns true if at least one object failed
+func (r *BulkUpdateResults) HasFailures() bool {
+	for _, result := range r.Results {
+		if result.Status == BulkUpdateStatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkUpdateError is returned by the providers if an object cannot be saved
+// during a bulk update
+type bulkUpdateError struct {
+	name string
+	err  error
+	// number of objects already saved, always 0 for providers supporting transactions
+	numApplied int
+}
+
+func newBulkUpdateError(name string, err error) *bulkUpdateError {
+	return &bulkUpdateError{
+		name: name,
+		err:  err,
+	}
+}
+
+func (e *bulkUpdateError) Error() string {
+	return fmt.Sprintf("unable to update %q: %v", e.name, e.err)
+}
+
+func (e *bulkUpdateError) Unwrap() error {
+	return e.err
+}
+
+type bulkUpdater[T any] struct {
+	// returns the names of the objects matching the selection filters
+	list func(limit, offset int) ([]string, error)
+	load func(name string) (T, error)
+	// restores the fields that cannot be modified using a patch and validates
+	// the patched object
+	check   func(original, patched *T) error
+	save    func(objects []*T) error
+	notify  func(object *T)
+	getName func(object *T) string
+}
+
+func (u *bulkUpdater[T]) listNames() ([]string, error) {
+	var names []string
+	for {
+		result, err := u.list(100, len(names))
+		if err != nil {
+			return names, err
+		}
+		names = append(names, result...)
+		if len(names) > MaxBulkUpdateObjects {
+			return names, util.NewValidationError(fmt.Sprintf("too many objects selected, the maximum allowed is %d",
+				MaxBulkUpdateObjects))
+		}
+		if len(result) < 100 {
+			return names, nil
+		}
+	}
+}
+
+func (u *bulkUpdater[T]) patch(name string, options *BulkUpdateOptions) (*T, bool, error) {
+	original, err := u.load(name)
+	if err != nil {
+		return nil, false, err
+	}
+	doc, err := json.Marshal(original)
+	if err != nil {
+		return nil, false, err
+	}
+	patchedDoc, err := options.apply(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	if util.IsJSONEqual(doc, patchedDoc) {
+		return &original, false, nil
+	}
+	var patched T
+	if err := json.Unmarshal(patchedDoc, &patched); err != nil {
+		return nil, false, util.NewValidationError(fmt.Sprintf("the patched object is not valid: %v", err))
+	}
+	if err := u.check(&original, &patched); err != nil {
+		return nil, false, err
+	}
+	return &patched, true, nil
+}
+
+func (u *bulkUpdater[T]) run(names []string, useFilters bool, options BulkUpdateOptions) (BulkUpdateResults, error) {
+	results := BulkUpdateResults{
+		DryRun: options.DryRun,
+	}
+	if err := options.validate(); err != nil {
+		return results, err
+	}
+	if useFilters {
+		if len(names) > 0 {
+			return results, util.NewValidationError("names and filters are mutually exclusive")
+		}
+		var err error
+		names, err = u.listNames()
+		if err != nil {
+			return results, err
+		}
+	} else if len(names) == 0 {
+		return results, util.NewValidationError("please select the objects to update using names or filters")
+	}
+	names = util.RemoveDuplicates(names, false)
+	if len(names) > MaxBulkUpdateObjects {
+		return results, util.NewValidationError(fmt.Sprintf("too many objects selected, the maximum allowed is %d",
+			MaxBulkUpdateObjects))
+	}
+	results.Results = make([]BulkUpdateResult, 0, len(names))
+	var toSave []*T
+	var toSaveIdx []int
+	for _, name := range names {
+		name = config.convertName(name)
+		result := BulkUpdateResult{
+			Name:   name,
+			Status: BulkUpdateStatusUpdated,
+		}
+		object, changed, err := u.patch(name, &options)
+		switch {
+		case err != nil:
+			result.Status = BulkUpdateStatusFailed
+			result.Error = err.Error()
+			if errors.Is(err, util.ErrNotFound) {
+				result.Error = "not found"
+			}
+		case !changed:
+			result.Status = BulkUpdateStatusUnchanged
+		default:
+			toSave = append(toSave, object)
+			toSaveIdx = append(toSaveIdx, len(results.Results))
+		}
+		results.Results = append(results.Results, result)
+	}
+	if results.HasFailures() {
+		for _, idx := range toSaveIdx {
+			results.Results[idx].Status = BulkUpdateStatusSkipped
+		}
+		return results, nil
+	}
+	if options.DryRun {
+		return results, nil
+	}
+	if len(toSave) > 0 {
+		if err := u.save(toSave); err != nil {
+			var bulkErr *bulkUpdateError
+			if !errors.As(err, &bulkErr) {
+				return results, err
+			}
+			providerLog(logger.LevelWarn, "bulk update failed: %v", err)
+			for idx, object := range toSave {
+				result := &results.Results[toSaveIdx[idx]]
+				switch {
+				case idx < bulkErr.numApplied:
+					u.notify(object)
+				case u.getName(object) == bulkErr.name:
+					result.Status = BulkUpdateStatusFailed
+					result.Error = bulkErr.err.Error()
+				default:
+					result.Status = BulkUpdateStatusSkipped
+				}
+			}
+			return results, nil
+		}
+		for _, object := range toSave {
+			u.notify(object)
+		}
+	}
+	results.Applied = true
+	return results, nil
+}
+
+// BulkUpdateUsers applies the specified patch to the users with the given
+// usernames or, if filters are not nil, to the users matching the filters.
+// The changes are saved only if all the patched users are valid, and within
+// a single transaction if supported by the data provider
+func BulkUpdateUsers(usernames []string, filters *UserSearchFilters, options BulkUpdateOptions,
+	executor, ipAddress, role string,
+) (BulkUpdateResults, error) {
+	if filters != nil {
+		if role != "" {
+			filters.Role = role
+		}
+		if err := filters.Validate(); err != nil {
+			return BulkUpdateResults{DryRun: options.DryRun}, err
+		}
+	}
+	updater := bulkUpdater[User]{
+		list: func(limit, offset int) ([]string, error) {
+			users, err := provider.getUsers(limit, offset, OrderASC, *filters)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(users))
+			for _, user := range users {
+				names = append(names, user.Username)
+			}
+			return names, nil
+		},
+		load: func(name string) (User, error) {
+			return provider.userExists(name, role)
+		},
+		check: func(original, patched *User) error {
+			if patched.Username != original.Username {
+				return util.NewValidationError("the username cannot be changed")
+			}
+			patched.ID = original.ID
+			patched.Filters.RecoveryCodes = original.Filters.RecoveryCodes
+			patched.Filters.TOTPConfig = original.Filters.TOTPConfig
+			patched.Filters.PasswordHistory = original.Filters.PasswordHistory
+			patched.Filters.PublicKeysLastUse = original.Filters.PublicKeysLastUse
+			patched.LastPasswordChange = original.LastPasswordChange
+			if role != "" {
+				patched.Role = role
+			}
+			patched.SetEmptySecretsIfNil()
+			return ValidateUser(patched)
+		},
+		save: provider.updateUsers,
+		notify: func(user *User) {
+			onUserUpdated(user, executor, ipAddress, role)
+		},
+		getName: func(user *User) string {
+			return user.Username
+		},
+	}
+	return updater.run(usernames, filters != nil, options)
+}
+
+// BulkUpdateGroups applies the specified patch to the groups with the given
+// names or, if filters are not nil, to the groups matching the filters.
+// The changes are saved only if all the patched groups are valid, and within
+// a single transaction if supported by the data provider
+func BulkUpdateGroups(names []string, filters *SearchFilters, options BulkUpdateOptions,
+	executor, ipAddress, role string,
+) (BulkUpdateResults, error) {
+	updater := bulkUpdater[Group]{
+		list: func(limit, offset int) ([]string, error) {
+			groups, err := provider.getGroups(limit, offset, OrderASC, true, *filters)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(groups))
+			for _, group := range groups {
+				names = append(names, group.Name)
+			}
+			return names, nil
+		},
+		load: provider.groupExists,
+		check: func(original, patched *Group) error {
+			if patched.Name != original.Name {
+				return util.NewValidationError("the group name cannot be changed")
+			}
+			patched.ID = original.ID
+			patched.CreatedAt = original.CreatedAt
+			patched.Users = original.Users
+			patched.Admins = original.Admins
+			patched.SetEmptySecretsIfNil()
+			return patched.validate()
+		},
+		save: provider.updateGroups,
+		notify: func(group *Group) {
+			onGroupUpdated(group, group.Users, executor, ipAddress, role)
+		},
+		getName: func(group *Group) string {
+			return group.Name
+		},
+	}
+	return updater.run(names, filters != nil, options)
+}
+
+// BulkUpdateFolders applies the specified patch to the virtual folders with the
+// given names or, if filters are not nil, to the folders matching the filters.
+// The changes are saved only if all the patched folders are valid, and within
+// a single transaction if supported by the data provider
+func BulkUpdateFolders(names []string, filters *FolderSearchFilters, options BulkUpdateOptions,
+	executor, ipAddress, role string,
+) (BulkUpdateResults, error) {
+	updater := bulkUpdater[vfs.BaseVirtualFolder]{
+		list: func(limit, offset int) ([]string, error) {
+			folders, err := provider.getFolders(limit, offset, OrderASC, true, *filters)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(folders))
+			for _, folder := range folders {
+				names = append(names, folder.Name)
+			}
+			return names, nil
+		},
+		load: provider.getFolderByName,
+		check: func(original, patched *vfs.BaseVirtualFolder) error {
+			if patched.Name != original.Name {
+				return util.NewValidationError("the folder name cannot be changed")
+			}
+			patched.ID = original.ID
+			patched.UsedQuotaSize = original.UsedQuotaSize
+			patched.UsedQuotaFiles = original.UsedQuotaFiles
+			patched.LastQuotaUpdate = original.LastQuotaUpdate
+			patched.Users = original.Users
+			patched.Groups = original.Groups
+			patched.FsConfig.SetEmptySecretsIfNil()
+			return ValidateFolder(patched)
+		},
+		save: provider.updateFolders,
+		notify: func(folder *vfs.BaseVirtualFolder) {
+			onFolderUpdated(folder, folder.Users, folder.Groups, executor, ipAddress, role)
+		},
+		getName: func(folder *vfs.BaseVirtualFolder) string {
+			return folder.Name
+		},
+	}
+	return updater.run(names, filters != nil, options)
+}
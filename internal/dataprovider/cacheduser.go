@@ -149,6 +149,23 @@ func (cache *usersCache) remove(username string) {
 	delete(cache.users, username)
 }
 
+// getUsersWithFolder returns the cached users with the specified virtual folder
+func (cache *usersCache) getUsersWithFolder(folderName string) []string {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	var usernames []string
+	for username, cachedUser := range cache.users {
+		for idx := range cachedUser.User.VirtualFolders {
+			if cachedUser.User.VirtualFolders[idx].Name == folderName {
+				usernames = append(usernames, username)
+				break
+			}
+		}
+	}
+	return usernames
+}
+
 func (cache *usersCache) get(username string) (*CachedUser, bool) {
 	cache.RLock()
 	defer cache.RUnlock()
@@ -0,0 +1,514 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported change notifications modes
+const (
+	// ChangeNotificationsModeDisabled means that the changes made on other nodes are detected
+	// by periodically polling the data provider
+	ChangeNotificationsModeDisabled = iota
+	// ChangeNotificationsModeProvider means that the changes are propagated using an outbox table,
+	// PostgreSQL uses LISTEN/NOTIFY to wake up the other instances, MySQL and CockroachDB poll the table
+	ChangeNotificationsModeProvider
+	// ChangeNotificationsModeNodes means that the changes are propagated by sending HTTP
+	// notifications to the other cluster nodes
+	ChangeNotificationsModeNodes
+)
+
+const (
+	// NodeChangeNotificationsPath defines the REST API path used to deliver change notifications to
+	// the cluster nodes
+	NodeChangeNotificationsPath  = "/api/v2/nodes/notifications"
+	changeNotificationsQueueSize = 1024
+	// notifications read from the outbox table are also searched in this time window before the
+	// last check, this way we handle transactions committed out of order and small clock differences
+	changeNotificationsLookBack  = 10 * time.Second
+	changeNotificationsRetention = time.Hour
+	// the polling based cache check is still executed, as a safety net, if the change notifications
+	// are enabled but less frequently
+	changeNotificationsCacheCheckInterval = "@every 1h"
+	defaultCacheCheckInterval             = "@every 10m"
+	// the KMS master key rotation is not an object change but it is propagated
+	// using the change notifications too
+	changeNotificationObjectKMSMasterKey = "kms_master_key"
+)
+
+var (
+	// identifies this instance in the change notifications
+	changeNotificationsInstanceID = xid.New().String()
+	changeNotifications           atomic.Pointer[changeNotificationsManager]
+	// object types whose changes invalidate the caches on the other nodes
+	changeNotificationsObjectTypes = []string{actionObjectUser, actionObjectGroup, actionObjectFolder,
+		actionObjectAdmin, actionObjectAPIKey, actionObjectEventAction, actionObjectEventRule, actionObjectIPListEntry,
+		actionObjectConfigs, changeNotificationObjectKMSMasterKey}
+)
+
+// ChangeNotificationsConfig defines how the changes to the cached objects, such as users,
+// event rules, IP lists and configurations, are propagated to the other instances sharing
+// the same data provider
+type ChangeNotificationsConfig struct {
+	// Mode:
+	// - 0 means disabled, the changes made on other instances are detected by periodically
+	//     polling the data provider
+	// - 1 means the changes are propagated using an outbox table in the data provider:
+	//     PostgreSQL uses LISTEN/NOTIFY to wake up the other instances, MySQL and
+	//     CockroachDB poll the table
+	// - 2 means HTTP notifications are sent to the other cluster nodes, the node
+	//     configuration is required
+	// Change notifications are ignored if the data provider is not shared
+	Mode int `json:"mode" mapstructure:"mode"`
+	// Interval, in seconds, for reading new notifications from the outbox table.
+	// 0 means the default: 2 seconds
+	PollInterval int `json:"poll_interval" mapstructure:"poll_interval"`
+}
+
+func (c *ChangeNotificationsConfig) initialize() error {
+	stopChangeNotifications()
+	if config.IsShared != 1 || c.Mode == ChangeNotificationsModeDisabled {
+		return nil
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = 2
+	}
+	var transport changeNotificationsTransport
+	switch c.Mode {
+	case ChangeNotificationsModeProvider:
+		if config.Driver == PGSQLDataProviderName {
+			pgsqlTransport, err := newPGSQLChangeNotificationsTransport()
+			if err != nil {
+				return err
+			}
+			transport = pgsqlTransport
+		} else {
+			transport = &outboxChangeNotificationsTransport{
+				interval: time.Duration(c.PollInterval) * time.Second,
+			}
+		}
+	case ChangeNotificationsModeNodes:
+		if currentNode == nil {
+			return errors.New("change notifications: the node configuration is required to notify the cluster nodes")
+		}
+		transport = &nodesChangeNotificationsTransport{}
+	default:
+		return fmt.Errorf("change notifications: invalid mode %d", c.Mode)
+	}
+	m := &changeNotificationsManager{
+		transport: transport,
+		queue:     make(chan *ChangeNotification, changeNotificationsQueueSize),
+		done:      make(chan struct{}),
+	}
+	m.start()
+	changeNotifications.Store(m)
+	providerLog(logger.LevelInfo, "change notifications enabled, mode %d, transport %q, instance id %q",
+		c.Mode, transport.name(), changeNotificationsInstanceID)
+	return nil
+}
+
+// ChangeNotification describes a change to an object cached by the SFTPGo instances
+type ChangeNotification struct {
+	// ID is the identifier for the notifications stored in the outbox table
+	ID int64 `json:"-"`
+	// Instance identifies the SFTPGo instance that made the change
+	Instance   string `json:"instance"`
+	Operation  string `json:"operation"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// ListType is set for IP list entries, the object name is the IP or network
+	ListType  IPListType `json:"list_type,omitempty"`
+	Timestamp int64      `json:"timestamp"`
+}
+
+func newChangeNotification(operation, objectType, objectName string, object plugin.Renderer) *ChangeNotification {
+	n := &ChangeNotification{
+		Instance:   changeNotificationsInstanceID,
+		Operation:  operation,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Timestamp:  util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	if entry, ok := object.(*IPListEntry); ok {
+		n.ObjectName = entry.IPOrNet
+		n.ListType = entry.Type
+	}
+	return n
+}
+
+type changeNotificationsTransport interface {
+	name() string
+	// hasOutbox returns true if the notifications are stored in the change_notifications table
+	hasOutbox() bool
+	publish(notification *ChangeNotification) error
+	// start starts receiving notifications from the other instances, if required
+	start()
+	stop()
+}
+
+type changeNotificationsManager struct {
+	transport changeNotificationsTransport
+	queue     chan *ChangeNotification
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func (m *changeNotificationsManager) start() {
+	m.transport.start()
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case notification := <-m.queue:
+				if err := m.transport.publish(notification); err != nil {
+					providerLog(logger.LevelError, "unable to publish change notification for %s %q: %v",
+						notification.ObjectType, notification.ObjectName, err)
+				}
+			}
+		}
+	}()
+}
+
+func (m *changeNotificationsManager) stop() {
+	close(m.done)
+	m.wg.Wait()
+	m.transport.stop()
+}
+
+func (m *changeNotificationsManager) add(notification *ChangeNotification) {
+	select {
+	case m.queue <- notification:
+	default:
+		providerLog(logger.LevelWarn, "change notifications queue is full, notification for %s %q dropped",
+			notification.ObjectType, notification.ObjectName)
+	}
+}
+
+func stopChangeNotifications() {
+	if m := changeNotifications.Swap(nil); m != nil {
+		m.stop()
+	}
+}
+
+// HasChangeNotifications returns true if the changes are propagated to the other instances
+// using change notifications
+func HasChangeNotifications() bool {
+	return changeNotifications.Load() != nil
+}
+
+// GetCacheCheckInterval returns the interval, in cron format, for the polling based check of
+// the cached objects
+func GetCacheCheckInterval() string {
+	if HasChangeNotifications() {
+		return changeNotificationsCacheCheckInterval
+	}
+	return defaultCacheCheckInterval
+}
+
+func notifyChange(operation, objectType, objectName string, object plugin.Renderer) {
+	m := changeNotifications.Load()
+	if m == nil {
+		return
+	}
+	if !util.Contains(changeNotificationsObjectTypes, objectType) {
+		return
+	}
+	m.add(newChangeNotification(operation, objectType, objectName, object))
+}
+
+// HandleChangeNotification updates the caches based on a change notification
+// received from another instance
+func HandleChangeNotification(notification *ChangeNotification) {
+	if notification.Instance == changeNotificationsInstanceID {
+		return
+	}
+	providerLog(logger.LevelDebug, "handle change notification from instance %q, operation %q, %s %q",
+		notification.Instance, notification.Operation, notification.ObjectType, notification.ObjectName)
+	// the read replicas could lag behind the instance that sent the notification
+	readReplicas.onWrite(notification.ObjectType, notification.ObjectName)
+
+	switch notification.ObjectType {
+	case actionObjectUser:
+		refreshCachedUser(notification.ObjectName)
+	case actionObjectGroup:
+		usernames, err := provider.getUsersInGroups([]string{notification.ObjectName})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get the users in group %q: %v", notification.ObjectName, err)
+			return
+		}
+		for _, username := range usernames {
+			refreshCachedUser(username)
+		}
+	case actionObjectFolder:
+		refreshFolderUsers(notification.ObjectName, notification.Operation)
+	case actionObjectAdmin:
+		cachedAdminPasswords.Remove(notification.ObjectName)
+	case actionObjectAPIKey:
+		cachedAPIKeys.Remove(notification.ObjectName)
+	case actionObjectEventRule:
+		if notification.Operation == operationDelete {
+			if fnRemoveRule != nil {
+				fnRemoveRule(notification.ObjectName)
+			}
+			return
+		}
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	case actionObjectEventAction:
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	case actionObjectIPListEntry:
+		refreshCachedIPListEntry(notification.ObjectName, notification.ListType)
+	case actionObjectConfigs:
+		loadConfigs()
+	case changeNotificationObjectKMSMasterKey:
+		checkKMSMasterKey()
+	}
+}
+
+func refreshCachedUser(username string) {
+	user, err := provider.userExists(username, "")
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			providerLog(logger.LevelError, "unable to refresh cached user %q: %v", username, err)
+			return
+		}
+		webDAVUsersCache.remove(username)
+		cachedUserPasswords.Remove(username)
+		delayedQuotaUpdater.resetUserQuota(username)
+		return
+	}
+	webDAVUsersCache.swap(&user)
+}
+
+// refreshFolderUsers refreshes the cached users with the specified virtual folder,
+// mapped directly or inherited from a group
+func refreshFolderUsers(folderName, operation string) {
+	usernames := webDAVUsersCache.getUsersWithFolder(folderName)
+	if operation == operationDelete {
+		delayedQuotaUpdater.resetFolderQuota(folderName)
+	} else {
+		folder, err := provider.getFolderByName(folderName)
+		if err != nil {
+			providerLog(logger.LevelError, "unable to get folder %q: %v", folderName, err)
+		} else {
+			usernames = append(usernames, folder.Users...)
+			usersInGroups, err := provider.getUsersInGroups(folder.Groups)
+			if err != nil {
+				providerLog(logger.LevelError, "unable to get the users in groups %+v: %v", folder.Groups, err)
+			}
+			usernames = append(usernames, usersInGroups...)
+		}
+	}
+	for _, username := range util.RemoveDuplicates(usernames, false) {
+		refreshCachedUser(username)
+	}
+}
+
+func refreshCachedIPListEntry(ipOrNet string, listType IPListType) {
+	entry, err := provider.ipListEntryExists(ipOrNet, listType)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			providerLog(logger.LevelError, "unable to refresh cached IP list entry %q, type %d: %v", ipOrNet, listType, err)
+			return
+		}
+		entry = IPListEntry{
+			IPOrNet: ipOrNet,
+			Type:    listType,
+		}
+		for _, l := range inMemoryLists {
+			l.removeEntry(&entry)
+		}
+		return
+	}
+	for _, l := range inMemoryLists {
+		l.updateEntry(&entry)
+	}
+}
+
+// resyncCaches reloads the recently updated objects, it is used if some notifications
+// may have been lost
+func resyncCaches() {
+	checkUserCache()
+	checkIPListEntryCache()
+	loadConfigs()
+	if fnReloadRules != nil {
+		fnReloadRules()
+	}
+}
+
+// changeNotificationsReader reads the notifications added by the other instances to the
+// change_notifications table and makes sure that each notification is handled only once
+type changeNotificationsReader struct {
+	lastCheck int64
+	// ids of the notifications already handled inside the look back window
+	seen map[int64]int64
+}
+
+func newChangeNotificationsReader() changeNotificationsReader {
+	return changeNotificationsReader{
+		lastCheck: util.GetTimeAsMsSinceEpoch(time.Now()),
+		seen:      make(map[int64]int64),
+	}
+}
+
+// check handles the notifications added after the last check and returns the number of
+// handled notifications. If the last check is older than the retention period, some
+// notifications may have been removed, so the recently updated objects are reloaded
+func (r *changeNotificationsReader) check() (int, error) {
+	checkTime := util.GetTimeAsMsSinceEpoch(time.Now())
+	after := r.lastCheck - changeNotificationsLookBack.Milliseconds()
+	if checkTime-after > changeNotificationsRetention.Milliseconds() {
+		providerLog(logger.LevelWarn, "the last change notifications check is too old, resync the caches")
+		resyncCaches()
+		after = checkTime - changeNotificationsLookBack.Milliseconds()
+	}
+	notifications, err := provider.getChangeNotifications(after)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get change notifications: %v", err)
+		return 0, err
+	}
+	handled := 0
+	for idx := range notifications {
+		notification := notifications[idx]
+		if _, ok := r.seen[notification.ID]; ok {
+			continue
+		}
+		r.seen[notification.ID] = notification.Timestamp
+		HandleChangeNotification(&notification)
+		handled++
+	}
+	for id, timestamp := range r.seen {
+		if timestamp <= after {
+			delete(r.seen, id)
+		}
+	}
+	r.lastCheck = checkTime
+	return handled, nil
+}
+
+// outboxChangeNotificationsTransport stores the notifications in a database table
+// and periodically reads the notifications added by the other instances
+type outboxChangeNotificationsTransport struct {
+	changeNotificationsReader
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (*outboxChangeNotificationsTransport) name() string {
+	return "outbox"
+}
+
+func (*outboxChangeNotificationsTransport) hasOutbox() bool {
+	return true
+}
+
+func (*outboxChangeNotificationsTransport) publish(notification *ChangeNotification) error {
+	return provider.addChangeNotification(notification)
+}
+
+func (t *outboxChangeNotificationsTransport) start() {
+	t.done = make(chan struct{})
+	t.changeNotificationsReader = newChangeNotificationsReader()
+	t.wg.Add(1)
+
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				t.check() //nolint:errcheck
+			}
+		}
+	}()
+}
+
+func (t *outboxChangeNotificationsTransport) stop() {
+	close(t.done)
+	t.wg.Wait()
+}
+
+func cleanupChangeNotifications() {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(-changeNotificationsRetention))
+	if err := provider.cleanupChangeNotifications(before); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup change notifications: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "cleanup change notifications ok")
+	}
+}
+
+// nodesChangeNotificationsTransport sends the notifications to the other cluster nodes.
+// The notifications are received using the REST API
+type nodesChangeNotificationsTransport struct{}
+
+func (*nodesChangeNotificationsTransport) name() string {
+	return "nodes"
+}
+
+func (*nodesChangeNotificationsTransport) hasOutbox() bool {
+	return false
+}
+
+func (*nodesChangeNotificationsTransport) publish(notification *ChangeNotification) error {
+	nodes, err := GetNodes()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+
+	for _, n := range nodes {
+		wg.Add(1)
+
+		go func(node Node) {
+			defer wg.Done()
+
+			if err := node.SendPostRequest(ActionExecutorSystem, "", NodeChangeNotificationsPath, notification); err != nil {
+				providerLog(logger.LevelError, "unable to send change notification to node %q: %v", node.Name, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func (*nodesChangeNotificationsTransport) start() {}
+
+func (*nodesChangeNotificationsTransport) stop() {}
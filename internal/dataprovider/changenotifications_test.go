@@ -0,0 +1,461 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+type testChangeNotificationsTransport struct {
+	published chan *ChangeNotification
+}
+
+func (*testChangeNotificationsTransport) name() string {
+	return "test"
+}
+
+func (*testChangeNotificationsTransport) hasOutbox() bool {
+	return false
+}
+
+func (t *testChangeNotificationsTransport) publish(notification *ChangeNotification) error {
+	t.published <- notification
+	return nil
+}
+
+func (*testChangeNotificationsTransport) start() {}
+
+func (*testChangeNotificationsTransport) stop() {}
+
+func waitForChangeNotification(t *testing.T, published chan *ChangeNotification, operation, objectType, objectName string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case n := <-published:
+			if n.Operation == operation && n.ObjectType == objectType && n.ObjectName == objectName {
+				assert.Equal(t, changeNotificationsInstanceID, n.Instance)
+				return
+			}
+		case <-timeout:
+			t.Fatalf("change notification for %s %q, operation %q not published", objectType, objectName, operation)
+		}
+	}
+}
+
+// cacheTestAdminPassword caches a password for the specified admin, the cached password is
+// removed if a change notification for the admin is handled
+func cacheTestAdminPassword(username string) {
+	cachedAdminPasswords.Add(username, "password", "hash")
+}
+
+func isTestAdminPasswordCached(username string) bool {
+	found, _ := cachedAdminPasswords.Check(username, "password", "hash")
+	return found
+}
+
+func newTestChangeNotification(instance, objectName string) *ChangeNotification {
+	n := newChangeNotification(operationUpdate, actionObjectAdmin, objectName, nil)
+	n.Instance = instance
+	return n
+}
+
+func TestChangeNotificationsPublish(t *testing.T) {
+	conf := getTestProviderConf(t)
+	initializeTestProvider(t, conf)
+
+	transport := &testChangeNotificationsTransport{
+		published: make(chan *ChangeNotification, changeNotificationsQueueSize),
+	}
+	m := &changeNotificationsManager{
+		transport: transport,
+		queue:     make(chan *ChangeNotification, changeNotificationsQueueSize),
+		done:      make(chan struct{}),
+	}
+	m.start()
+	changeNotifications.Store(m)
+	defer stopChangeNotifications()
+
+	basePath := t.TempDir()
+	folder := vfs.BaseVirtualFolder{
+		Name:       "cn_folder_" + xid.New().String(),
+		MappedPath: filepath.Join(basePath, "folder"),
+	}
+	err := AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	waitForChangeNotification(t, transport.published, operationAdd, actionObjectFolder, folder.Name)
+	user := User{
+		BaseUser: sdk.BaseUser{
+			Username: "cn_user_" + xid.New().String(),
+			Password: "password",
+			HomeDir:  filepath.Join(basePath, "user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/vdir",
+			},
+		},
+	}
+	err = AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	waitForChangeNotification(t, transport.published, operationAdd, actionObjectUser, user.Username)
+	folder.MappedPath = filepath.Join(basePath, "folder_updated")
+	err = UpdateFolder(&folder, []string{user.Username}, nil, "", "", "")
+	require.NoError(t, err)
+	waitForChangeNotification(t, transport.published, operationUpdate, actionObjectFolder, folder.Name)
+	waitForChangeNotification(t, transport.published, operationUpdate, actionObjectUser, user.Username)
+	err = DeleteFolder(folder.Name, "", "", "")
+	require.NoError(t, err)
+	waitForChangeNotification(t, transport.published, operationDelete, actionObjectFolder, folder.Name)
+	err = DeleteUser(user.Username, "", "", "")
+	require.NoError(t, err)
+	waitForChangeNotification(t, transport.published, operationDelete, actionObjectUser, user.Username)
+	// roles are not cached so changes are not notified
+	role := Role{
+		Name: "cn_role_" + xid.New().String(),
+	}
+	err = AddRole(&role, "", "", "")
+	require.NoError(t, err)
+	err = DeleteRole(role.Name, "", "", "")
+	require.NoError(t, err)
+	notifyChange(operationUpdate, changeNotificationObjectKMSMasterKey, "", nil)
+	waitForChangeNotification(t, transport.published, operationUpdate, changeNotificationObjectKMSMasterKey, "")
+	assert.Len(t, transport.published, 0)
+}
+
+func TestFolderChangeNotification(t *testing.T) {
+	conf := getTestProviderConf(t)
+	initializeTestProvider(t, conf)
+
+	basePath := t.TempDir()
+	folder := vfs.BaseVirtualFolder{
+		Name:       "cn_folder_" + xid.New().String(),
+		MappedPath: filepath.Join(basePath, "folder"),
+	}
+	err := AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	group := Group{
+		BaseGroup: sdk.BaseGroup{
+			Name: "cn_group_" + xid.New().String(),
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/group_vdir",
+			},
+		},
+	}
+	err = AddGroup(&group, "", "", "")
+	require.NoError(t, err)
+	user1 := User{
+		BaseUser: sdk.BaseUser{
+			Username: "cn_user1_" + xid.New().String(),
+			Password: "password",
+			HomeDir:  filepath.Join(basePath, "user1"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/vdir",
+			},
+		},
+	}
+	err = AddUser(&user1, "", "", "")
+	require.NoError(t, err)
+	user2 := User{
+		BaseUser: sdk.BaseUser{
+			Username: "cn_user2_" + xid.New().String(),
+			Password: "password",
+			HomeDir:  filepath.Join(basePath, "user2"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+		Groups: []sdk.GroupMapping{
+			{
+				Name: group.Name,
+				Type: sdk.GroupTypePrimary,
+			},
+		},
+	}
+	err = AddUser(&user2, "", "", "")
+	require.NoError(t, err)
+	user3 := User{
+		BaseUser: sdk.BaseUser{
+			Username: "cn_user3_" + xid.New().String(),
+			Password: "password",
+			HomeDir:  filepath.Join(basePath, "user3"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+	}
+	err = AddUser(&user3, "", "", "")
+	require.NoError(t, err)
+
+	cacheUsers := func() {
+		for _, username := range []string{user1.Username, user2.Username, user3.Username} {
+			u, err := UserExists(username, "")
+			require.NoError(t, err)
+			err = u.LoadAndApplyGroupSettings()
+			require.NoError(t, err)
+			webDAVUsersCache.add(&CachedUser{
+				User:     u,
+				Password: "password",
+			})
+		}
+	}
+	isUserCached := func(username string) bool {
+		_, ok := webDAVUsersCache.get(username)
+		return ok
+	}
+	// the cached users reference the previous folder mapped path
+	cacheUsers()
+	folder.MappedPath = filepath.Join(basePath, "folder_updated")
+	err = provider.updateFolder(&folder)
+	require.NoError(t, err)
+	HandleChangeNotification(&ChangeNotification{
+		Instance:   xid.New().String(),
+		Operation:  operationUpdate,
+		ObjectType: actionObjectFolder,
+		ObjectName: folder.Name,
+	})
+	assert.False(t, isUserCached(user1.Username))
+	assert.False(t, isUserCached(user2.Username))
+	assert.True(t, isUserCached(user3.Username))
+	// the notifications sent from this instance are ignored
+	cacheUsers()
+	folder.MappedPath = filepath.Join(basePath, "folder_updated1")
+	err = provider.updateFolder(&folder)
+	require.NoError(t, err)
+	HandleChangeNotification(&ChangeNotification{
+		Instance:   changeNotificationsInstanceID,
+		Operation:  operationUpdate,
+		ObjectType: actionObjectFolder,
+		ObjectName: folder.Name,
+	})
+	assert.True(t, isUserCached(user1.Username))
+	assert.True(t, isUserCached(user2.Username))
+	// the users referencing a deleted folder are found using the cache
+	u1, err := UserExists(user1.Username, "")
+	require.NoError(t, err)
+	u3, err := UserExists(user3.Username, "")
+	require.NoError(t, err)
+	err = DeleteFolder(folder.Name, "", "", "")
+	require.NoError(t, err)
+	webDAVUsersCache.add(&CachedUser{
+		User:     u1,
+		Password: "password",
+	})
+	webDAVUsersCache.add(&CachedUser{
+		User:     u3,
+		Password: "password",
+	})
+	assert.True(t, isUserCached(user1.Username))
+	HandleChangeNotification(&ChangeNotification{
+		Instance:   xid.New().String(),
+		Operation:  operationDelete,
+		ObjectType: actionObjectFolder,
+		ObjectName: folder.Name,
+	})
+	assert.False(t, isUserCached(user1.Username))
+	assert.True(t, isUserCached(user3.Username))
+
+	for _, username := range []string{user1.Username, user2.Username, user3.Username} {
+		err = DeleteUser(username, "", "", "")
+		assert.NoError(t, err)
+	}
+	err = DeleteGroup(group.Name, "", "", "")
+	assert.NoError(t, err)
+}
+
+func TestOutboxChangeNotifications(t *testing.T) {
+	conf := getTestProviderConf(t)
+	if conf.Driver == BoltDataProviderName || conf.Driver == MemoryDataProviderName {
+		t.Skip("this test requires a SQL based data provider")
+	}
+	initializeTestProvider(t, conf)
+
+	otherInstance := xid.New().String()
+	transport := &outboxChangeNotificationsTransport{
+		interval: 100 * time.Millisecond,
+	}
+	transport.changeNotificationsReader = newChangeNotificationsReader()
+	admin1 := "cn_admin1_" + xid.New().String()
+	admin2 := "cn_admin2_" + xid.New().String()
+	cacheTestAdminPassword(admin1)
+	cacheTestAdminPassword(admin2)
+	err := transport.publish(newTestChangeNotification(otherInstance, admin1))
+	require.NoError(t, err)
+	// notifications published by this instance are not handled
+	err = transport.publish(newTestChangeNotification(changeNotificationsInstanceID, admin2))
+	require.NoError(t, err)
+	handled, err := transport.check()
+	require.NoError(t, err)
+	assert.Equal(t, 1, handled)
+	assert.False(t, isTestAdminPasswordCached(admin1))
+	assert.True(t, isTestAdminPasswordCached(admin2))
+	// the notifications inside the look back window are read again but handled only once
+	cacheTestAdminPassword(admin1)
+	handled, err = transport.check()
+	require.NoError(t, err)
+	assert.Equal(t, 0, handled)
+	assert.True(t, isTestAdminPasswordCached(admin1))
+	notifications, err := provider.getChangeNotifications(transport.lastCheck - changeNotificationsLookBack.Milliseconds())
+	require.NoError(t, err)
+	assert.Len(t, notifications, 1)
+	// the ids outside the look back window are pruned
+	transport.seen[-1] = util.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Minute))
+	handled, err = transport.check()
+	require.NoError(t, err)
+	assert.Equal(t, 0, handled)
+	assert.Len(t, transport.seen, 1)
+	assert.NotContains(t, transport.seen, int64(-1))
+	// if the last check is older than the retention period the caches are reloaded
+	// and the recent notifications are read
+	transport.lastCheck = util.GetTimeAsMsSinceEpoch(time.Now().Add(-2 * changeNotificationsRetention))
+	handled, err = transport.check()
+	require.NoError(t, err)
+	assert.Equal(t, 0, handled)
+	assert.Len(t, transport.seen, 1)
+	assert.True(t, isTestAdminPasswordCached(admin1))
+	// the notifications are polled
+	cacheTestAdminPassword(admin1)
+	transport.start()
+	err = transport.publish(newTestChangeNotification(otherInstance, admin1))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !isTestAdminPasswordCached(admin1)
+	}, 5*time.Second, 50*time.Millisecond)
+	transport.stop()
+	assert.True(t, isTestAdminPasswordCached(admin2))
+
+	cleanupChangeNotifications()
+	notifications, err = provider.getChangeNotifications(0)
+	require.NoError(t, err)
+	assert.Greater(t, len(notifications), 0)
+	err = provider.cleanupChangeNotifications(util.GetTimeAsMsSinceEpoch(time.Now().Add(time.Minute)))
+	require.NoError(t, err)
+	notifications, err = provider.getChangeNotifications(0)
+	require.NoError(t, err)
+	assert.Len(t, notifications, 0)
+}
+
+func TestNodesChangeNotifications(t *testing.T) {
+	conf := getTestProviderConf(t)
+	if !isSharedTestProvider(conf) {
+		t.Skip("this test requires a shared data provider")
+	}
+	conf.IsShared = 1
+	conf.Node.Host = "127.0.0.1"
+	conf.Node.Port = 1
+	conf.Node.Proto = NodeProtoHTTP
+	conf.ChangeNotifications.Mode = ChangeNotificationsModeNodes
+	initializeTestProvider(t, conf)
+
+	m := changeNotifications.Load()
+	require.NotNil(t, m)
+	require.Equal(t, "nodes", m.transport.name())
+	require.False(t, m.transport.hasOutbox())
+	// register another node that handles the notifications in this process
+	var remoteNode *Node
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != NodeChangeNotificationsPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get(NodeTokenHeader), "Bearer ")
+		if _, _, err := remoteNode.authenticate(token); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var notification ChangeNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		HandleChangeNotification(&notification)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	localNode := currentNode
+	currentNode = &Node{
+		Data: NodeData{
+			Host:  host,
+			Port:  port,
+			Proto: NodeProtoHTTP,
+		},
+	}
+	err = provider.addNode()
+	remoteNode = currentNode
+	currentNode = localNode
+	require.NoError(t, err)
+
+	otherInstance := xid.New().String()
+	admin1 := "cn_admin1_" + xid.New().String()
+	admin2 := "cn_admin2_" + xid.New().String()
+	cacheTestAdminPassword(admin1)
+	cacheTestAdminPassword(admin2)
+	// the publish is synchronous, the notification is handled when it returns
+	err = m.transport.publish(newTestChangeNotification(otherInstance, admin1))
+	require.NoError(t, err)
+	assert.False(t, isTestAdminPasswordCached(admin1))
+	err = m.transport.publish(newTestChangeNotification(changeNotificationsInstanceID, admin2))
+	require.NoError(t, err)
+	assert.True(t, isTestAdminPasswordCached(admin2))
+	// the notifications queued by the manager are sent to the node too
+	cacheTestAdminPassword(admin1)
+	m.add(newTestChangeNotification(otherInstance, admin1))
+	assert.Eventually(t, func() bool {
+		return !isTestAdminPasswordCached(admin1)
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/internal/kms"
 	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
 	"github.com/drakkan/sftpgo/v2/internal/util"
 )
 
@@ -218,6 +219,141 @@ func (c *SMTPConfigs) getACopy() *SMTPConfigs {
 	}
 }
 
+// Supported RADIUS authentication modes
+const (
+	// RADIUS replaces the local password check
+	RADIUSModePassword = 1
+	// RADIUS is used as second authentication factor
+	RADIUSModeSecondFactor = 2
+)
+
+// RADIUSConfigs defines the configuration for the builtin RADIUS authentication
+type RADIUSConfigs struct {
+	// RADIUS servers as host:port, they are tried in order
+	Servers []string `json:"servers,omitempty"`
+	// Shared secret
+	Secret *kms.Secret `json:"secret,omitempty"`
+	// Authentication type, pap or chap
+	AuthType string `json:"auth_type,omitempty"`
+	// Timeout, in seconds, for each server
+	Timeout int `json:"timeout,omitempty"`
+	// Number of retransmissions for each server
+	Retries int `json:"retries,omitempty"`
+	// NAS-Identifier attribute to send
+	NASIdentifier string `json:"nas_identifier,omitempty"`
+	// 1 means the RADIUS server validates the users passwords,
+	// 2 means the RADIUS server validates a one time passcode after
+	// a successful local password authentication, only SSH and HTTP
+	// are supported in this mode and TOTP is not required for them
+	Mode int `json:"mode,omitempty"`
+	// RADIUS authentication will be used for the specified protocols.
+	// For SSH, challenges are supported for keyboard interactive authentication,
+	// for HTTP, challenges are supported for WebClient logins
+	Protocols []string `json:"protocols,omitempty"`
+}
+
+func (c *RADIUSConfigs) isEmpty() bool {
+	return len(c.Servers) == 0
+}
+
+func (c *RADIUSConfigs) validateSecret() error {
+	if c.Secret == nil || c.Secret.IsEmpty() {
+		return util.NewValidationError("radius: the shared secret is mandatory")
+	}
+	if c.Secret.IsRedacted() {
+		return util.NewValidationError("cannot save a redacted radius secret")
+	}
+	if c.Secret.IsEncrypted() && !c.Secret.IsValid() {
+		return util.NewValidationError("invalid encrypted radius secret")
+	}
+	if !c.Secret.IsValidInput() {
+		return util.NewValidationError("invalid radius secret")
+	}
+	if c.Secret.IsPlain() {
+		c.Secret.SetAdditionalData("radius")
+		if err := c.Secret.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt radius secret: %v", err))
+		}
+	}
+	return nil
+}
+
+func (c *RADIUSConfigs) validate() error {
+	if c.isEmpty() {
+		return nil
+	}
+	c.Servers = util.RemoveDuplicates(c.Servers, true)
+	for _, server := range c.Servers {
+		if server == "" {
+			return util.NewValidationError("radius: empty server address")
+		}
+	}
+	if err := c.validateSecret(); err != nil {
+		return err
+	}
+	if c.AuthType == "" {
+		c.AuthType = radius.AuthTypePAP
+	}
+	if !util.Contains([]string{radius.AuthTypePAP, radius.AuthTypeCHAP}, c.AuthType) {
+		return util.NewValidationError(fmt.Sprintf("radius: invalid auth type %q", c.AuthType))
+	}
+	if c.Timeout < 0 {
+		return util.NewValidationError(fmt.Sprintf("radius: invalid timeout %d", c.Timeout))
+	}
+	if c.Retries < 0 {
+		return util.NewValidationError(fmt.Sprintf("radius: invalid retries %d", c.Retries))
+	}
+	if c.Mode != RADIUSModePassword && c.Mode != RADIUSModeSecondFactor {
+		return util.NewValidationError(fmt.Sprintf("radius: invalid mode %d", c.Mode))
+	}
+	c.Protocols = util.RemoveDuplicates(c.Protocols, false)
+	if len(c.Protocols) == 0 {
+		return util.NewValidationError("radius: at least a protocol is required")
+	}
+	for _, protocol := range c.Protocols {
+		if !util.Contains(ValidProtocols, protocol) {
+			return util.NewValidationError(fmt.Sprintf("radius: invalid protocol %q", protocol))
+		}
+		if c.Mode == RADIUSModeSecondFactor && protocol != protocolSSH && protocol != protocolHTTP {
+			return util.NewValidationError(fmt.Sprintf("radius: second factor authentication is not supported for protocol %q",
+				protocol))
+		}
+	}
+	return nil
+}
+
+// HasProtocol returns true if RADIUS authentication is enabled for the specified protocol
+func (c *RADIUSConfigs) HasProtocol(protocol string) bool {
+	return util.Contains(c.Protocols, protocol)
+}
+
+// GetServersAsString returns the RADIUS servers as comma separated string
+func (c *RADIUSConfigs) GetServersAsString() string {
+	return strings.Join(c.Servers, ",")
+}
+
+func (c *RADIUSConfigs) getACopy() *RADIUSConfigs {
+	var secret *kms.Secret
+	if c.Secret != nil {
+		secret = c.Secret.Clone()
+	}
+	servers := make([]string, len(c.Servers))
+	copy(servers, c.Servers)
+	protocols := make([]string, len(c.Protocols))
+	copy(protocols, c.Protocols)
+
+	return &RADIUSConfigs{
+		Servers:       servers,
+		Secret:        secret,
+		AuthType:      c.AuthType,
+		Timeout:       c.Timeout,
+		Retries:       c.Retries,
+		NASIdentifier: c.NASIdentifier,
+		Mode:          c.Mode,
+		Protocols:     protocols,
+	}
+}
+
 // ACMEHTTP01Challenge defines the configuration for HTTP-01 challenge type
 type ACMEHTTP01Challenge struct {
 	Port int `json:"port"`
@@ -281,10 +417,14 @@ func (c *ACMEConfigs) getACopy() *ACMEConfigs {
 // Configs allows to set configuration keys disabled by default without
 // modifying the config file or setting env vars
 type Configs struct {
-	SFTPD     *SFTPDConfigs `json:"sftpd,omitempty"`
-	SMTP      *SMTPConfigs  `json:"smtp,omitempty"`
-	ACME      *ACMEConfigs  `json:"acme,omitempty"`
-	UpdatedAt int64         `json:"updated_at,omitempty"`
+	SFTPD     *SFTPDConfigs  `json:"sftpd,omitempty"`
+	SMTP      *SMTPConfigs   `json:"smtp,omitempty"`
+	ACME      *ACMEConfigs   `json:"acme,omitempty"`
+	RADIUS    *RADIUSConfigs `json:"radius,omitempty"`
+	UpdatedAt int64          `json:"updated_at,omitempty"`
+
+	// SSH user certificates revocations
+	RevokedUserCerts []RevokedUserCert `json:"revoked_user_certs,omitempty"`
 }
 
 func (c *Configs) validate() error {
@@ -303,6 +443,20 @@ func (c *Configs) validate() error {
 			return err
 		}
 	}
+	if c.RADIUS != nil {
+		if err := c.RADIUS.validate(); err != nil {
+			return err
+		}
+	}
+	for idx := range c.RevokedUserCerts {
+		cert := &c.RevokedUserCerts[idx]
+		if err := cert.validate(); err != nil {
+			return err
+		}
+		if cert.ID == "" {
+			cert.ID = util.GenerateUniqueID()
+		}
+	}
 	return nil
 }
 
@@ -319,12 +473,18 @@ func (c *Configs) PrepareForRendering() {
 	if c.ACME != nil && c.ACME.isEmpty() {
 		c.ACME = nil
 	}
+	if c.RADIUS != nil && c.RADIUS.isEmpty() {
+		c.RADIUS = nil
+	}
 	if c.SMTP != nil && c.SMTP.Password != nil {
 		c.SMTP.Password.Hide()
 		if c.SMTP.Password.IsEmpty() {
 			c.SMTP.Password = nil
 		}
 	}
+	if c.RADIUS != nil && c.RADIUS.Secret != nil {
+		c.RADIUS.Secret.Hide()
+	}
 }
 
 // SetNilsToEmpty sets nil fields to empty
@@ -341,6 +501,12 @@ func (c *Configs) SetNilsToEmpty() {
 	if c.ACME == nil {
 		c.ACME = &ACMEConfigs{}
 	}
+	if c.RADIUS == nil {
+		c.RADIUS = &RADIUSConfigs{}
+	}
+	if c.RADIUS.Secret == nil {
+		c.RADIUS.Secret = kms.NewEmptySecret()
+	}
 }
 
 // RenderAsJSON implements the renderer interface used within plugins
@@ -369,6 +535,13 @@ func (c *Configs) getACopy() Configs {
 	if c.ACME != nil {
 		result.ACME = c.ACME.getACopy()
 	}
+	if c.RADIUS != nil {
+		result.RADIUS = c.RADIUS.getACopy()
+	}
+	if len(c.RevokedUserCerts) > 0 {
+		result.RevokedUserCerts = make([]RevokedUserCert, len(c.RevokedUserCerts))
+		copy(result.RevokedUserCerts, c.RevokedUserCerts)
+	}
 	result.UpdatedAt = c.UpdatedAt
 	return result
 }
@@ -0,0 +1,148 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// CryptFsReEncryptionCheckpointInterval defines how often the state of a
+	// running CryptFs re-encryption is saved
+	CryptFsReEncryptionCheckpointInterval = 10 * time.Second
+	// a re-encryption not saved for this time is considered interrupted
+	cryptFsReEncryptionStaleTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrCryptFsReEncryptionInProgress defines the error to return if a user with
+	// a stored CryptFs re-encryption state tries to login
+	ErrCryptFsReEncryptionInProgress = errors.New("a CryptFs re-encryption is in progress for this user")
+)
+
+// CryptFsReEncryptionState defines the persisted state of a CryptFs re-encryption.
+// The state is stored within the user and it is managed by the re-encryption job,
+// user updates do not change it. The user cannot login while the state is stored,
+// it is removed when the re-encryption completes or it is rolled back
+type CryptFsReEncryptionState struct {
+	// ID identifies the re-encryption, the temporary files are named after it
+	ID string `json:"id"`
+	// RunID identifies the current run and changes each time the re-encryption
+	// is resumed. It is used to ensure that only one instance processes the files
+	RunID string `json:"run_id"`
+	// The new passphrase
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+	// True if the files are restored to the current passphrase
+	Rollback bool `json:"rollback,omitempty"`
+	// Start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// Last save time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+	// Path, relative to the home directory, of the last processed file
+	LastPath       string `json:"last_path,omitempty"`
+	ProcessedFiles int    `json:"processed_files"`
+	ProcessedSize  int64  `json:"processed_size"`
+	SkippedFiles   int    `json:"skipped_files"`
+	// Error that stopped the last run, if any
+	Error string `json:"error,omitempty"`
+}
+
+// IsRunning returns true if the re-encryption is processing the files.
+// A re-encryption not saved recently, or stopped by an error, can be
+// resumed or rolled back
+func (s *CryptFsReEncryptionState) IsRunning() bool {
+	if s.Error != "" {
+		return false
+	}
+	return time.Since(util.GetTimeFromMsecSinceEpoch(s.UpdatedAt)) < cryptFsReEncryptionStaleTimeout
+}
+
+// ResetProgress resets the processed files, they will be visited again
+func (s *CryptFsReEncryptionState) ResetProgress() {
+	s.LastPath = ""
+	s.ProcessedFiles = 0
+	s.ProcessedSize = 0
+	s.SkippedFiles = 0
+}
+
+func (s *CryptFsReEncryptionState) getACopy() *CryptFsReEncryptionState {
+	if s == nil {
+		return nil
+	}
+	state := *s
+	if s.Passphrase != nil {
+		state.Passphrase = s.Passphrase.Clone()
+	}
+	return &state
+}
+
+func (s *CryptFsReEncryptionState) hideConfidentialData() {
+	if s != nil && s.Passphrase != nil {
+		s.Passphrase.Hide()
+	}
+}
+
+func getCryptFsReEncryptionRunID(state *CryptFsReEncryptionState) string {
+	if state == nil {
+		return ""
+	}
+	return state.RunID
+}
+
+// checkCryptFsReEncryptionRunID returns ErrVersionConflict if the stored state
+// does not match the expected run, an empty run ID means no stored state
+func checkCryptFsReEncryptionRunID(stored *CryptFsReEncryptionState, expectedRunID string) error {
+	if getCryptFsReEncryptionRunID(stored) != expectedRunID {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// UpdateCryptFsReEncryptionState stores the CryptFs re-encryption state for the
+// specified user if the stored one refers to the expected run.
+// An empty expected run ID means that no state must be stored, this way only one
+// re-encryption can be started for a user, also across multiple instances.
+// A nil state removes the stored one. The passphrase is encrypted, if in plain text,
+// and the update time is set within the provided state
+func UpdateCryptFsReEncryptionState(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	if state != nil {
+		state.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		if state.Passphrase == nil || state.Passphrase.IsEmpty() {
+			return util.NewValidationError("the CryptFs re-encryption passphrase is mandatory")
+		}
+		if state.Passphrase.IsPlain() {
+			state.Passphrase.SetAdditionalData(username)
+			if err := state.Passphrase.Encrypt(); err != nil {
+				return fmt.Errorf("could not encrypt the CryptFs re-encryption passphrase: %w", err)
+			}
+		}
+	}
+	if err := provider.updateUserCryptFsReEncryption(username, expectedRunID, state); err != nil {
+		return err
+	}
+	if expectedRunID == "" || state == nil {
+		// login conditions changed
+		RemoveCachedWebDAVUser(username)
+		notifyChange(operationUpdate, actionObjectUser, username, nil)
+		providerLog(logger.LevelInfo, "CryptFs re-encryption state for user %q stored: %t", username, state != nil)
+	}
+	return nil
+}
@@ -173,13 +173,16 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	// ErrLoginNotAllowedFromIP defines the error to return if login is denied from the current IP
 	ErrLoginNotAllowedFromIP = errors.New("login is not allowed from this IP")
-	isAdminCreated           atomic.Bool
-	validTLSUsernames        = []string{string(sdk.TLSUsernameNone), string(sdk.TLSUsernameCN)}
-	config                   Config
-	provider                 Provider
-	sqlPlaceholders          []string
-	internalHashPwdPrefixes  = []string{argonPwdPrefix, bcryptPwdPrefix}
-	hashPwdPrefixes          = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
+	// ErrVersionConflict defines the error to return if the object to update or delete was modified
+	// after the expected version
+	ErrVersionConflict      = errors.New("the object has been modified, please reload it and try again")
+	isAdminCreated          atomic.Bool
+	validTLSUsernames       = []string{string(sdk.TLSUsernameNone), string(sdk.TLSUsernameCN)}
+	config                  Config
+	provider                Provider
+	sqlPlaceholders         []string
+	internalHashPwdPrefixes = []string{argonPwdPrefix, bcryptPwdPrefix}
+	hashPwdPrefixes         = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
 		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, md5LDAPPwdPrefix,
 		sha256cryptPwdPrefix, sha512cryptPwdPrefix, yescryptPwdPrefix}
 	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
@@ -210,6 +213,8 @@ var (
 	sqlTableRoles                string
 	sqlTableIPLists              string
 	sqlTableConfigs              string
+	sqlTableChangeNotifications  string
+	sqlTableObjectHistory        string
 	sqlTableSchemaVersion        string
 	argon2Params                 *argon2id.Params
 	lastLoginMinDelay            = 10 * time.Minute
@@ -244,6 +249,8 @@ func initSQLTables() {
 	sqlTableRoles = "roles"
 	sqlTableIPLists = "ip_lists"
 	sqlTableConfigs = "configurations"
+	sqlTableChangeNotifications = "change_notifications"
+	sqlTableObjectHistory = "object_history"
 	sqlTableSchemaVersion = "schema_version"
 }
 
@@ -294,6 +301,9 @@ type PasswordValidationRules struct {
 	// Take a look at the following link for more details
 	// https://github.com/wagslane/go-password-validator#what-entropy-value-should-i-use
 	MinEntropy float64 `json:"min_entropy" mapstructure:"min_entropy"`
+	// HistorySize defines the number of previous passwords that cannot be reused.
+	// 0 means disabled, any previous password can be reused
+	HistorySize int `json:"history_size" mapstructure:"history_size"`
 }
 
 // PasswordValidation defines the password validation rules for admins and protocol users
@@ -334,9 +344,10 @@ type ObjectsActions struct {
 
 // ProviderStatus defines the provider status
 type ProviderStatus struct {
-	Driver   string `json:"driver"`
-	IsActive bool   `json:"is_active"`
-	Error    string `json:"error"`
+	Driver       string              `json:"driver"`
+	IsActive     bool                `json:"is_active"`
+	Error        string              `json:"error"`
+	ReadReplicas []ReadReplicaStatus `json:"read_replicas,omitempty"`
 }
 
 // Config defines the provider configuration
@@ -389,6 +400,9 @@ type Config struct {
 	// Sets the maximum number of open connections for mysql and postgresql driver.
 	// Default 0 (unlimited)
 	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
+	// ReadReplicas defines the read replicas for MySQL, PostgreSQL and CockroachDB.
+	// Ignored for other data providers
+	ReadReplicas ReadReplicasConfig `json:"read_replicas" mapstructure:"read_replicas"`
 	// Users default base directory.
 	// If no home dir is defined while adding a new user, and this value is
 	// a valid absolute path, then the user home dir will be automatically
@@ -489,13 +503,23 @@ type Config struct {
 	// MySQL, PostgreSQL and CockroachDB can be shared, this setting is ignored for other data
 	// providers. For shared data providers, SFTPGo periodically reloads the latest updated users,
 	// based on the "updated_at" field, and updates its internal caches if users are updated from
-	// a different instance. This check, if enabled, is executed every 10 minutes.
+	// a different instance. This check, if enabled, is executed every 10 minutes, or every hour
+	// if change notifications are enabled.
 	// For shared data providers, active transfers are persisted in the database and thus
 	// quota checks between ongoing transfers will work cross multiple instances
 	IsShared int `json:"is_shared" mapstructure:"is_shared"`
 	// Node defines the configuration for this cluster node.
 	// Ignored if the provider is not shared/shareable
 	Node NodeConfig `json:"node" mapstructure:"node"`
+	// ChangeNotifications defines how the changes are propagated to the other instances.
+	// Ignored if the provider is not shared/shareable
+	ChangeNotifications ChangeNotificationsConfig `json:"change_notifications" mapstructure:"change_notifications"`
+	// Interval, in seconds, to exchange the cloud storage metadata caches invalidations
+	// with the other instances. 0 means the default (10). Ignored if the provider is not shared
+	MetadataCacheSyncInterval int `json:"metadata_cache_sync_interval" mapstructure:"metadata_cache_sync_interval"`
+	// ObjectHistory defines the configuration for the change history of users, groups,
+	// folders, admins, event rules and roles
+	ObjectHistory ObjectHistoryConfig `json:"object_history" mapstructure:"object_history"`
 	// Path to the backup directory. This can be an absolute path or a path relative to the config dir
 	BackupsPath string `json:"backups_path" mapstructure:"backups_path"`
 }
@@ -575,11 +599,6 @@ func (c *Config) doBackup() (string, error) {
 	return outputFile, nil
 }
 
-// ExecuteBackup executes a backup
-func ExecuteBackup() (string, error) {
-	return config.doBackup()
-}
-
 // ConvertName converts the given name based on the configured rules
 func ConvertName(name string) string {
 	return config.convertName(name)
@@ -727,7 +746,7 @@ func HasUsersBaseDir() bool {
 // Provider defines the interface that data providers must implement.
 type Provider interface {
 	validateUserAndPass(username, password, ip, protocol string) (User, error)
-	validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error)
+	validateUserAndPubKey(username string, pubKey []byte, ip string, isSSHCert bool) (User, PublicKey, error)
 	validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error)
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
 	updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error
@@ -735,29 +754,34 @@ type Provider interface {
 	userExists(username, role string) (User, error)
 	addUser(user *User) error
 	updateUser(user *User) error
+	updateUsers(users []*User) error
 	deleteUser(user User, softDelete bool) error
 	updateUserPassword(username, password string) error // used internally when converting passwords from other hash
-	getUsers(limit int, offset int, order, role string) ([]User, error)
+	updateUserPublicKeyLastUse(username, fingerprint string) error
+	updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error
+	getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error)
 	dumpUsers() ([]User, error)
 	getRecentlyUpdatedUsers(after int64) ([]User, error)
 	getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error)
 	updateLastLogin(username string) error
 	updateAdminLastLogin(username string) error
 	setUpdatedAt(username string)
-	getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error)
+	getFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error)
 	getFolderByName(name string) (vfs.BaseVirtualFolder, error)
 	addFolder(folder *vfs.BaseVirtualFolder) error
 	updateFolder(folder *vfs.BaseVirtualFolder) error
+	updateFolders(folders []*vfs.BaseVirtualFolder) error
 	deleteFolder(folder vfs.BaseVirtualFolder) error
 	updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedFolderQuota(name string) (int, int64, error)
 	dumpFolders() ([]vfs.BaseVirtualFolder, error)
-	getGroups(limit, offset int, order string, minimal bool) ([]Group, error)
+	getGroups(limit, offset int, order string, minimal bool, filters SearchFilters) ([]Group, error)
 	getGroupsWithNames(names []string) ([]Group, error)
 	getUsersInGroups(names []string) ([]string, error)
 	groupExists(name string) (Group, error)
 	addGroup(group *Group) error
 	updateGroup(group *Group) error
+	updateGroups(groups []*Group) error
 	deleteGroup(group Group) error
 	dumpGroups() ([]Group, error)
 	adminExists(username string) (Admin, error)
@@ -778,7 +802,7 @@ type Provider interface {
 	addShare(share *Share) error
 	updateShare(share *Share) error
 	deleteShare(share Share) error
-	getShares(limit int, offset int, order, username string) ([]Share, error)
+	getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error)
 	dumpShares() ([]Share, error)
 	updateShareLastUse(shareID string, numTokens int) error
 	getDefenderHosts(from int64, limit int) ([]DefenderEntry, error)
@@ -797,6 +821,7 @@ type Provider interface {
 	addSharedSession(session Session) error
 	deleteSharedSession(key string) error
 	getSharedSession(key string) (Session, error)
+	getSharedSessionsUpdatedAfter(sessionType SessionType, after int64) ([]Session, error)
 	cleanupSharedSessions(sessionType SessionType, before int64) error
 	getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error)
 	dumpEventActions() ([]BaseEventAction, error)
@@ -822,6 +847,15 @@ type Provider interface {
 	getNodes() ([]Node, error)
 	updateNodeTimestamp() error
 	cleanupNodes() error
+	addChangeNotification(notification *ChangeNotification) error
+	getChangeNotifications(after int64) ([]ChangeNotification, error)
+	cleanupChangeNotifications(before int64) error
+	addObjectVersion(version *ObjectVersion) error
+	getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error)
+	getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error)
+	getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error)
+	cleanupObjectHistory(before int64) error
+	restoreTimestamps(timestamps *objectTimestamps) error
 	roleExists(name string) (Role, error)
 	addRole(role *Role) error
 	updateRole(role *Role) error
@@ -835,10 +869,12 @@ type Provider interface {
 	getIPListEntries(listType IPListType, filter, from, order string, limit int) ([]IPListEntry, error)
 	getRecentlyUpdatedIPListEntries(after int64) ([]IPListEntry, error)
 	dumpIPListEntries() ([]IPListEntry, error)
+	dumpPage(scope, after string, limit int) (BackupData, error)
 	countIPListEntries(listType IPListType) (int64, error)
 	getListEntriesForIP(ip string, listType IPListType) ([]IPListEntry, error)
 	getConfigs() (Configs, error)
 	setConfigs(configs *Configs) error
+	restore(plan *restorePlan) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -903,6 +939,17 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	if err := config.Node.validate(); err != nil {
 		return err
 	}
+	if err := config.ObjectHistory.validate(); err != nil {
+		return err
+	}
+	if err := config.ChangeNotifications.initialize(); err != nil {
+		return err
+	}
+	if err := validateMetadataCacheSyncInterval(); err != nil {
+		return err
+	}
+	checkKMSMasterKey()
+	loadConfigs()
 	delayedQuotaUpdater.start()
 	return startScheduler()
 }
@@ -1020,17 +1067,20 @@ func validateSQLTablesPrefix() error {
 		sqlTableRoles = config.SQLTablesPrefix + sqlTableRoles
 		sqlTableIPLists = config.SQLTablesPrefix + sqlTableIPLists
 		sqlTableConfigs = config.SQLTablesPrefix + sqlTableConfigs
+		sqlTableChangeNotifications = config.SQLTablesPrefix + sqlTableChangeNotifications
+		sqlTableObjectHistory = config.SQLTablesPrefix + sqlTableObjectHistory
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %q, folders %q users folders mapping %q admins %q "+
 			"api keys %q shares %q defender hosts %q defender events %q transfers %q  groups %q "+
 			"users groups mapping %q admins groups mapping %q groups folders mapping %q shared sessions %q "+
 			"schema version %q events actions %q events rules %q rules actions mapping %q tasks %q nodes %q roles %q"+
-			"ip lists %q configs %q",
+			"ip lists %q configs %q change notifications %q object history %q",
 			sqlTableUsers, sqlTableFolders, sqlTableUsersFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
 			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableActiveTransfers, sqlTableGroups,
 			sqlTableUsersGroupsMapping, sqlTableAdminsGroupsMapping, sqlTableGroupsFoldersMapping, sqlTableSharedSessions,
 			sqlTableSchemaVersion, sqlTableEventsActions, sqlTableEventsRules, sqlTableRulesActionsMapping,
-			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs)
+			sqlTableTasks, sqlTableNodes, sqlTableRoles, sqlTableIPLists, sqlTableConfigs, sqlTableChangeNotifications,
+			sqlTableObjectHistory)
 	}
 	return nil
 }
@@ -1130,6 +1180,12 @@ func CheckCachedUserCredentials(user *CachedUser, password, loginMethod, protoco
 			return nil
 		}
 	} else {
+		if a := getRADIUSPasswordAuthenticator(&user.User, protocol); a != nil {
+			if err := a.authenticate(user.User.Username, password, nil); err == nil {
+				return nil
+			}
+			return ErrInvalidCredentials
+		}
 		if ok, _ := isPasswordOK(&user.User, password); ok {
 			return nil
 		}
@@ -1268,31 +1324,32 @@ func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
 	return provider.validateUserAndPass(username, password, ip, protocol)
 }
 
-// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
-func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, string, error) {
+// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error.
+// The matching public key is returned too, it is empty for SSH certificates
+func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, PublicKey, error) {
 	username = config.convertName(username)
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePublicKey) {
 		user, err := doPluginAuth(username, "", pubKey, ip, protocol, nil, plugin.AuthScopePublicKey)
 		if err != nil {
-			return user, "", err
+			return user, PublicKey{}, err
 		}
-		return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
 		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol, nil)
 		if err != nil {
-			return user, "", err
+			return user, PublicKey{}, err
 		}
-		return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 	}
 	if config.PreLoginHook != "" {
 		user, err := executePreLoginHook(username, SSHLoginMethodPublicKey, ip, protocol, nil)
 		if err != nil {
-			return user, "", err
+			return user, PublicKey{}, err
 		}
-		return checkUserAndPubKey(&user, pubKey, isSSHCert)
+		return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 	}
-	return provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	return provider.validateUserAndPubKey(username, pubKey, ip, isSSHCert)
 }
 
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
@@ -1428,6 +1485,22 @@ func UpdateLastLogin(user *User) {
 	}
 }
 
+// UpdatePublicKeyLastUse updates the last use for the given public key of the specified SFTPGo user.
+// Like the last login, the last use is updated at most once per the configured delay
+func UpdatePublicKeyLastUse(user *User, pk *PublicKey) {
+	delay := lastLoginMinDelay
+	if user.Filters.ExternalAuthCacheTime > 0 {
+		delay = time.Duration(user.Filters.ExternalAuthCacheTime) * time.Second
+	}
+	if pk.Fingerprint == "" || isLastActivityRecent(pk.LastUseAt, delay) {
+		return
+	}
+	if err := provider.updateUserPublicKeyLastUse(user.Username, pk.Fingerprint); err != nil {
+		providerLog(logger.LevelWarn, "unable to update last use for public key %q, user %q: %v",
+			pk.GetID(), user.Username, err)
+	}
+}
+
 // UpdateAdminLastLogin updates the last login field for the given SFTPGo admin
 func UpdateAdminLastLogin(admin *Admin) {
 	if !isLastActivityRecent(admin.LastLogin, lastLoginMinDelay) {
@@ -1546,6 +1619,20 @@ func GetConfigs() (Configs, error) {
 	return provider.getConfigs()
 }
 
+// loadConfigs applies the configurations stored within the data provider
+// that need to be cached in memory
+func loadConfigs() {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		providerLog(logger.LevelError, "unable to load configs: %v", err)
+		return
+	}
+	if err := setRADIUSConfig(configs.RADIUS); err != nil {
+		providerLog(logger.LevelError, "unable to apply RADIUS settings: %v", err)
+	}
+	setRevokedUserCerts(configs.RevokedUserCerts)
+}
+
 // UpdateConfigs updates configurations
 func UpdateConfigs(configs *Configs, executor, ipAddress, role string) error {
 	if configs == nil {
@@ -1555,6 +1642,14 @@ func UpdateConfigs(configs *Configs, executor, ipAddress, role string) error {
 	}
 	err := provider.setConfigs(configs)
 	if err == nil {
+		var radiusConfigs *RADIUSConfigs
+		if configs.RADIUS != nil {
+			radiusConfigs = configs.RADIUS.getACopy()
+		}
+		if err := setRADIUSConfig(radiusConfigs); err != nil {
+			providerLog(logger.LevelError, "unable to apply RADIUS settings: %v", err)
+		}
+		setRevokedUserCerts(configs.RevokedUserCerts)
 		executeAction(operationUpdate, executor, ipAddress, actionObjectConfigs, "configs", role, configs)
 	}
 	return err
@@ -1579,11 +1674,18 @@ func UpdateShare(share *Share, executor, ipAddress, role string) error {
 }
 
 // DeleteShare deletes an existing share
-func DeleteShare(shareID string, executor, ipAddress, role string) error {
+func DeleteShare(shareID, executor, ipAddress, role string) error {
+	return DeleteShareIfVersion(shareID, 0, executor, ipAddress, role)
+}
+
+// DeleteShareIfVersion deletes an existing share if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteShareIfVersion(shareID string, version int64, executor, ipAddress, role string) error {
 	share, err := provider.shareExists(shareID, executor)
 	if err != nil {
 		return err
 	}
+	share.ExpectedVersion = version
 	err = provider.deleteShare(share)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectShare, shareID, role, &share)
@@ -1625,10 +1727,17 @@ func UpdateIPListEntry(entry *IPListEntry, executor, ipAddress, executorRole str
 
 // DeleteIPListEntry deletes an existing IP list entry
 func DeleteIPListEntry(ipOrNet string, listType IPListType, executor, ipAddress, executorRole string) error {
+	return DeleteIPListEntryIfVersion(ipOrNet, listType, 0, executor, ipAddress, executorRole)
+}
+
+// DeleteIPListEntryIfVersion deletes an existing IP list entry if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteIPListEntryIfVersion(ipOrNet string, listType IPListType, version int64, executor, ipAddress, executorRole string) error {
 	entry, err := provider.ipListEntryExists(ipOrNet, listType)
 	if err != nil {
 		return err
 	}
+	entry.ExpectedVersion = version
 	err = provider.deleteIPListEntry(entry, config.IsShared == 1)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectIPListEntry, entry.getName(), executorRole, &entry)
@@ -1672,7 +1781,13 @@ func UpdateRole(role *Role, executor, ipAddress, executorRole string) error {
 }
 
 // DeleteRole deletes an existing Role
-func DeleteRole(name string, executor, ipAddress, executorRole string) error {
+func DeleteRole(name, executor, ipAddress, executorRole string) error {
+	return DeleteRoleIfVersion(name, 0, executor, ipAddress, executorRole)
+}
+
+// DeleteRoleIfVersion deletes an existing Role if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteRoleIfVersion(name string, version int64, executor, ipAddress, executorRole string) error {
 	name = config.convertName(name)
 	role, err := provider.roleExists(name)
 	if err != nil {
@@ -1682,6 +1797,7 @@ func DeleteRole(name string, executor, ipAddress, executorRole string) error {
 		errorString := fmt.Sprintf("the role %q is referenced, it cannot be removed", role.Name)
 		return util.NewValidationError(errorString)
 	}
+	role.ExpectedVersion = version
 	err = provider.deleteRole(role)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectRole, role.Name, executorRole, &role)
@@ -1717,22 +1833,32 @@ func AddGroup(group *Group, executor, ipAddress, role string) error {
 func UpdateGroup(group *Group, users []string, executor, ipAddress, role string) error {
 	err := provider.updateGroup(group)
 	if err == nil {
-		for _, user := range users {
-			provider.setUpdatedAt(user)
-			u, err := provider.userExists(user, "")
-			if err == nil {
-				webDAVUsersCache.swap(&u)
-			} else {
-				RemoveCachedWebDAVUser(user)
-			}
-		}
-		executeAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, role, group)
+		onGroupUpdated(group, users, executor, ipAddress, role)
 	}
 	return err
 }
 
+func onGroupUpdated(group *Group, users []string, executor, ipAddress, role string) {
+	for _, user := range users {
+		provider.setUpdatedAt(user)
+		u, err := provider.userExists(user, "")
+		if err == nil {
+			webDAVUsersCache.swap(&u)
+		} else {
+			RemoveCachedWebDAVUser(user)
+		}
+	}
+	executeAction(operationUpdate, executor, ipAddress, actionObjectGroup, group.Name, role, group)
+}
+
 // DeleteGroup deletes an existing Group
-func DeleteGroup(name string, executor, ipAddress, role string) error {
+func DeleteGroup(name, executor, ipAddress, role string) error {
+	return DeleteGroupIfVersion(name, 0, executor, ipAddress, role)
+}
+
+// DeleteGroupIfVersion deletes an existing Group if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteGroupIfVersion(name string, version int64, executor, ipAddress, role string) error {
 	name = config.convertName(name)
 	group, err := provider.groupExists(name)
 	if err != nil {
@@ -1742,6 +1868,7 @@ func DeleteGroup(name string, executor, ipAddress, role string) error {
 		errorString := fmt.Sprintf("the group %q is referenced, it cannot be removed", group.Name)
 		return util.NewValidationError(errorString)
 	}
+	group.ExpectedVersion = version
 	err = provider.deleteGroup(group)
 	if err == nil {
 		for _, user := range group.Users {
@@ -1782,11 +1909,18 @@ func UpdateAPIKey(apiKey *APIKey, executor, ipAddress, role string) error {
 }
 
 // DeleteAPIKey deletes an existing API key
-func DeleteAPIKey(keyID string, executor, ipAddress, role string) error {
+func DeleteAPIKey(keyID, executor, ipAddress, role string) error {
+	return DeleteAPIKeyIfVersion(keyID, 0, executor, ipAddress, role)
+}
+
+// DeleteAPIKeyIfVersion deletes an existing API key if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteAPIKeyIfVersion(keyID string, version int64, executor, ipAddress, role string) error {
 	apiKey, err := provider.apiKeyExists(keyID)
 	if err != nil {
 		return err
 	}
+	apiKey.ExpectedVersion = version
 	err = provider.deleteAPIKey(apiKey)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectAPIKey, apiKey.KeyID, role, &apiKey)
@@ -1837,7 +1971,13 @@ func UpdateEventAction(action *BaseEventAction, executor, ipAddress, role string
 }
 
 // DeleteEventAction deletes an existing event action
-func DeleteEventAction(name string, executor, ipAddress, role string) error {
+func DeleteEventAction(name, executor, ipAddress, role string) error {
+	return DeleteEventActionIfVersion(name, 0, executor, ipAddress, role)
+}
+
+// DeleteEventActionIfVersion deletes an existing event action if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteEventActionIfVersion(name string, version int64, executor, ipAddress, role string) error {
 	name = config.convertName(name)
 	action, err := provider.eventActionExists(name)
 	if err != nil {
@@ -1847,6 +1987,7 @@ func DeleteEventAction(name string, executor, ipAddress, role string) error {
 		errorString := fmt.Sprintf("the event action %#q is referenced, it cannot be removed", action.Name)
 		return util.NewValidationError(errorString)
 	}
+	action.ExpectedVersion = version
 	err = provider.deleteEventAction(action)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectEventAction, action.Name, role, &action)
@@ -1896,12 +2037,19 @@ func UpdateEventRule(rule *EventRule, executor, ipAddress, role string) error {
 }
 
 // DeleteEventRule deletes an existing event rule
-func DeleteEventRule(name string, executor, ipAddress, role string) error {
+func DeleteEventRule(name, executor, ipAddress, role string) error {
+	return DeleteEventRuleIfVersion(name, 0, executor, ipAddress, role)
+}
+
+// DeleteEventRuleIfVersion deletes an existing event rule if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteEventRuleIfVersion(name string, version int64, executor, ipAddress, role string) error {
 	name = config.convertName(name)
 	rule, err := provider.eventRuleExists(name)
 	if err != nil {
 		return err
 	}
+	rule.ExpectedVersion = version
 	err = provider.deleteEventRule(rule, config.IsShared == 1)
 	if err == nil {
 		if fnRemoveRule != nil {
@@ -1969,6 +2117,7 @@ func HasAdmin() bool {
 // AddAdmin adds a new SFTPGo admin
 func AddAdmin(admin *Admin, executor, ipAddress, role string) error {
 	admin.Filters.RecoveryCodes = nil
+	admin.Filters.PasswordHistory = nil
 	admin.Filters.TOTPConfig = AdminTOTPConfig{
 		Enabled: false,
 	}
@@ -1992,11 +2141,18 @@ func UpdateAdmin(admin *Admin, executor, ipAddress, role string) error {
 
 // DeleteAdmin deletes an existing SFTPGo admin
 func DeleteAdmin(username, executor, ipAddress, role string) error {
+	return DeleteAdminIfVersion(username, 0, executor, ipAddress, role)
+}
+
+// DeleteAdminIfVersion deletes an existing SFTPGo admin if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteAdminIfVersion(username string, version int64, executor, ipAddress, role string) error {
 	username = config.convertName(username)
 	admin, err := provider.adminExists(username)
 	if err != nil {
 		return err
 	}
+	admin.ExpectedVersion = version
 	err = provider.deleteAdmin(admin)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectAdmin, admin.Username, role, &admin)
@@ -2068,6 +2224,7 @@ func UpdateUserPassword(username, plainPwd, executor, ipAddress, role string) er
 	}
 	user.LastPasswordChange = userCopy.LastPasswordChange
 	user.Password = userCopy.Password
+	user.Filters.PasswordHistory = userCopy.Filters.PasswordHistory
 	user.Filters.RequirePasswordChange = false
 	// the last password change is set when validating the user
 	if err := provider.updateUser(&user); err != nil {
@@ -2085,19 +2242,30 @@ func UpdateUser(user *User, executor, ipAddress, role string) error {
 	}
 	err := provider.updateUser(user)
 	if err == nil {
-		webDAVUsersCache.swap(user)
-		executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, user)
+		onUserUpdated(user, executor, ipAddress, role)
 	}
 	return err
 }
 
+func onUserUpdated(user *User, executor, ipAddress, role string) {
+	webDAVUsersCache.swap(user)
+	executeAction(operationUpdate, executor, ipAddress, actionObjectUser, user.Username, role, user)
+}
+
 // DeleteUser deletes an existing SFTPGo user.
 func DeleteUser(username, executor, ipAddress, role string) error {
+	return DeleteUserIfVersion(username, 0, executor, ipAddress, role)
+}
+
+// DeleteUserIfVersion deletes an existing SFTPGo user if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteUserIfVersion(username string, version int64, executor, ipAddress, role string) error {
 	username = config.convertName(username)
 	user, err := provider.userExists(username, role)
 	if err != nil {
 		return err
 	}
+	user.ExpectedVersion = version
 	err = provider.deleteUser(user, config.IsShared == 1)
 	if err == nil {
 		RemoveCachedWebDAVUser(user.Username)
@@ -2188,12 +2356,14 @@ func CleanupSharedSessions(sessionType SessionType, before time.Time) error {
 // Currently only implemented for memory provider, allows to reload the users
 // from the configured file, if defined
 func ReloadConfig() error {
+	loadConfigs()
 	return provider.reloadConfig()
 }
 
-// GetShares returns an array of shares respecting limit and offset
-func GetShares(limit, offset int, order, username string) ([]Share, error) {
-	return provider.getShares(limit, offset, order, username)
+// GetShares returns an array of shares matching the specified filters and
+// respecting limit and offset
+func GetShares(limit, offset int, order, username string, filters SearchFilters) ([]Share, error) {
+	return provider.getShares(limit, offset, order, username, filters)
 }
 
 // GetAPIKeys returns an array of API keys respecting limit and offset
@@ -2211,14 +2381,16 @@ func GetRoles(limit, offset int, order string, minimal bool) ([]Role, error) {
 	return provider.getRoles(limit, offset, order, minimal)
 }
 
-// GetGroups returns an array of groups respecting limit and offset
-func GetGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return provider.getGroups(limit, offset, order, minimal)
+// GetGroups returns an array of groups matching the specified filters and
+// respecting limit and offset
+func GetGroups(limit, offset int, order string, minimal bool, filters SearchFilters) ([]Group, error) {
+	return provider.getGroups(limit, offset, order, minimal, filters)
 }
 
-// GetUsers returns an array of users respecting limit and offset
-func GetUsers(limit, offset int, order, role string) ([]User, error) {
-	return provider.getUsers(limit, offset, order, role)
+// GetUsers returns an array of users matching the specified filters and
+// respecting limit and offset
+func GetUsers(limit, offset int, order string, filters UserSearchFilters) ([]User, error) {
+	return provider.getUsers(limit, offset, order, filters)
 }
 
 // GetUsersForQuotaCheck returns the users with the fields required for a quota check
@@ -2240,35 +2412,46 @@ func AddFolder(folder *vfs.BaseVirtualFolder, executor, ipAddress, role string)
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress, role string) error {
 	err := provider.updateFolder(folder)
 	if err == nil {
-		executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
-		usersInGroups, errGrp := provider.getUsersInGroups(groups)
-		if errGrp == nil {
-			users = append(users, usersInGroups...)
-			users = util.RemoveDuplicates(users, false)
+		onFolderUpdated(folder, users, groups, executor, ipAddress, role)
+	}
+	return err
+}
+
+func onFolderUpdated(folder *vfs.BaseVirtualFolder, users []string, groups []string, executor, ipAddress, role string) {
+	executeAction(operationUpdate, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: *folder})
+	usersInGroups, errGrp := provider.getUsersInGroups(groups)
+	if errGrp == nil {
+		users = append(users, usersInGroups...)
+		users = util.RemoveDuplicates(users, false)
+	} else {
+		providerLog(logger.LevelWarn, "unable to get users in groups %+v: %v", groups, errGrp)
+	}
+	for _, user := range users {
+		provider.setUpdatedAt(user)
+		u, err := provider.userExists(user, "")
+		if err == nil {
+			webDAVUsersCache.swap(&u)
+			executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
 		} else {
-			providerLog(logger.LevelWarn, "unable to get users in groups %+v: %v", groups, errGrp)
-		}
-		for _, user := range users {
-			provider.setUpdatedAt(user)
-			u, err := provider.userExists(user, "")
-			if err == nil {
-				webDAVUsersCache.swap(&u)
-				executeAction(operationUpdate, executor, ipAddress, actionObjectUser, u.Username, u.Role, &u)
-			} else {
-				RemoveCachedWebDAVUser(user)
-			}
+			RemoveCachedWebDAVUser(user)
 		}
 	}
-	return err
 }
 
 // DeleteFolder deletes an existing folder.
 func DeleteFolder(folderName, executor, ipAddress, role string) error {
+	return DeleteFolderIfVersion(folderName, 0, executor, ipAddress, role)
+}
+
+// DeleteFolderIfVersion deletes an existing folder if the stored version,
+// the last update time, matches the specified one. 0 means no check
+func DeleteFolderIfVersion(folderName string, version int64, executor, ipAddress, role string) error {
 	folderName = config.convertName(folderName)
 	folder, err := provider.getFolderByName(folderName)
 	if err != nil {
 		return err
 	}
+	folder.ExpectedVersion = version
 	err = provider.deleteFolder(folder)
 	if err == nil {
 		executeAction(operationDelete, executor, ipAddress, actionObjectFolder, folder.Name, role, &wrappedFolder{Folder: folder})
@@ -2299,9 +2482,10 @@ func GetFolderByName(name string) (vfs.BaseVirtualFolder, error) {
 	return provider.getFolderByName(name)
 }
 
-// GetFolders returns an array of folders respecting limit and offset
-func GetFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return provider.getFolders(limit, offset, order, minimal)
+// GetFolders returns an array of folders matching the specified filters and
+// respecting limit and offset
+func GetFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
+	return provider.getFolders(limit, offset, order, minimal, filters)
 }
 
 func dumpUsers(data *BackupData, scopes []string) error {
@@ -2492,6 +2676,7 @@ func GetProviderStatus() ProviderStatus {
 		status.IsActive = false
 		status.Error = err.Error()
 	}
+	status.ReadReplicas = readReplicas.getStatus()
 	return status
 }
 
@@ -2500,11 +2685,14 @@ func GetProviderStatus() ProviderStatus {
 // Closing an uninitialized provider is not supported
 func Close() error {
 	stopScheduler()
+	stopChangeNotifications()
+	closeSQLReadReplicas()
 	return provider.close()
 }
 
 func createProvider(basePath string) error {
 	var err error
+	closeSQLReadReplicas()
 	sqlPlaceholders = getSQLPlaceholders()
 	if err = validateSQLTablesPrefix(); err != nil {
 		return err
@@ -2592,7 +2780,8 @@ func buildUserHomeDir(user *User) {
 			return
 		}
 		switch user.FsConfig.Provider {
-		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider, sdk.HTTPFilesystemProvider:
+		case sdk.SFTPFilesystemProvider, sdk.S3FilesystemProvider, sdk.AzureBlobFilesystemProvider, sdk.GCSFilesystemProvider,
+			sdk.HTTPFilesystemProvider, vfs.WebDAVFilesystemProvider, vfs.FTPFilesystemProvider, vfs.SMBFilesystemProvider:
 			if tempPath != "" {
 				user.HomeDir = filepath.Join(tempPath, user.Username)
 			} else {
@@ -2805,17 +2994,26 @@ func validatePublicKeys(user *User) error {
 		user.PublicKeys = []string{}
 	}
 	var validatedKeys []string
+	lastUse := make(map[string]int64)
 	for i, k := range user.PublicKeys {
 		if k == "" {
 			continue
 		}
-		_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		pk, err := ParsePublicKey(k)
 		if err != nil {
 			return util.NewValidationError(fmt.Sprintf("could not parse key nr. %d: %s", i+1, err))
 		}
 		validatedKeys = append(validatedKeys, k)
+		if val, ok := user.Filters.PublicKeysLastUse[pk.Fingerprint]; ok {
+			lastUse[pk.Fingerprint] = val
+		}
 	}
 	user.PublicKeys = util.RemoveDuplicates(validatedKeys, false)
+	// remove the last use for the deleted keys
+	user.Filters.PublicKeysLastUse = nil
+	if len(lastUse) > 0 {
+		user.Filters.PublicKeysLastUse = lastUse
+	}
 	return nil
 }
 
@@ -3018,6 +3216,9 @@ func validateCombinedUserFilters(user *User) error {
 	if user.Filters.RequirePasswordChange && util.Contains(user.Filters.WebClient, sdk.WebClientPasswordChangeDisabled) {
 		return util.NewValidationError("you cannot require password change and at the same time disallow it")
 	}
+	if user.Filters.PasswordHistorySize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid password history size: %d", user.Filters.PasswordHistorySize))
+	}
 	return nil
 }
 
@@ -3087,16 +3288,80 @@ func createUserPasswordHash(user *User) error {
 				return util.NewValidationError(err.Error())
 			}
 		}
+		historySize, err := getUserPasswordHistorySize(user)
+		if err != nil {
+			return err
+		}
+		if isPasswordInHistory(user.Password, user.Filters.PasswordHistory, historySize) {
+			return util.NewValidationError(fmt.Sprintf("the password cannot be equal to one of the last %d passwords",
+				historySize))
+		}
 		hashedPwd, err := hashPlainPassword(user.Password)
 		if err != nil {
 			return err
 		}
 		user.Password = hashedPwd
 		user.LastPasswordChange = util.GetTimeAsMsSinceEpoch(time.Now())
+		user.Filters.PasswordHistory = addToPasswordHistory(user.Filters.PasswordHistory, hashedPwd, historySize)
 	}
 	return nil
 }
 
+// getUserPasswordHistorySize returns the password history size for the
+// specified user after merging the settings from its groups
+func getUserPasswordHistorySize(user *User) (int, error) {
+	if user.Filters.PasswordHistorySize > 0 || user.groupSettingsApplied || len(user.Groups) == 0 {
+		return user.getPasswordHistorySize(), nil
+	}
+	userCopy := user.getACopy()
+	if err := userCopy.LoadAndApplyGroupSettings(); err != nil {
+		return 0, err
+	}
+	return userCopy.getPasswordHistorySize(), nil
+}
+
+// getPasswordHistory returns the last size password hashes from the specified history
+func getPasswordHistory(history []string, size int) []string {
+	if size <= 0 {
+		return nil
+	}
+	if len(history) > size {
+		return history[len(history)-size:]
+	}
+	return history
+}
+
+// isPasswordInHistory returns true if the specified plain text password matches
+// one of the last size password hashes within the given history
+func isPasswordInHistory(plainPwd string, history []string, size int) bool {
+	for _, hashedPwd := range getPasswordHistory(history, size) {
+		if strings.HasPrefix(hashedPwd, bcryptPwdPrefix) {
+			if bcrypt.CompareHashAndPassword([]byte(hashedPwd), []byte(plainPwd)) == nil {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(hashedPwd, argonPwdPrefix) {
+			if match, err := argon2id.ComparePasswordAndHash(plainPwd, hashedPwd); err == nil && match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addToPasswordHistory returns a new history with the specified password hash
+// appended and limited to the last size entries. The history is returned
+// unchanged if the size is not positive
+func addToPasswordHistory(history []string, hashedPwd string, size int) []string {
+	if size <= 0 {
+		return history
+	}
+	result := make([]string, 0, size)
+	result = append(result, getPasswordHistory(history, size-1)...)
+	return append(result, hashedPwd)
+}
+
 // ValidateFolder returns an error if the folder is not valid
 // FIXME: this should be defined as Folder struct method
 func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
@@ -3119,6 +3384,9 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 	if folder.HasRedactedSecret() {
 		return errors.New("cannot save a folder with a redacted secret")
 	}
+	if folder.FsConfig.Provider == vfs.OverlayFilesystemProvider {
+		return util.NewValidationError("the overlay filesystem is not supported for virtual folders")
+	}
 	return folder.FsConfig.Validate(folder.GetEncryptionAdditionalData())
 }
 
@@ -3126,7 +3394,10 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 // FIXME: this should be defined as User struct method
 func ValidateUser(user *User) error {
 	user.OIDCCustomFields = nil
+	user.PublicKeysInfo = nil
 	user.HasPassword = false
+	// managed by the CryptFs re-encryption, the providers keep the stored value on updates
+	user.Filters.CryptFsReEncryption = nil
 	user.SetEmptySecretsIfNil()
 	buildUserHomeDir(user)
 	if err := validateBaseParams(user); err != nil {
@@ -3270,7 +3541,8 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 	if err != nil {
 		return *user, ErrInvalidCredentials
 	}
-	if user.Password == "" || password == "" {
+	radiusPwdAuth := getRADIUSPasswordAuthenticator(user, protocol)
+	if (user.Password == "" && radiusPwdAuth == nil) || password == "" {
 		return *user, errors.New("credentials cannot be null or empty")
 	}
 	if !user.Filters.Hooks.CheckPasswordDisabled {
@@ -3297,6 +3569,9 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 			return *user, ErrInvalidCredentials
 		}
 	}
+	if radiusPwdAuth != nil {
+		return *user, radiusPwdAuth.authenticate(user.Username, password, nil)
+	}
 
 	match, err := isPasswordOK(user, password)
 	if !match {
@@ -3339,32 +3614,43 @@ func checkUserPasscode(user *User, password, protocol string) (string, error) {
 	return password, nil
 }
 
-func checkUserAndPubKey(user *User, pubKey []byte, isSSHCert bool) (User, string, error) {
+func checkUserAndPubKey(user *User, pubKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
 	err := user.LoadAndApplyGroupSettings()
 	if err != nil {
-		return *user, "", err
+		return *user, PublicKey{}, err
 	}
 	err = user.CheckLoginConditions()
 	if err != nil {
-		return *user, "", err
+		return *user, PublicKey{}, err
 	}
 	if isSSHCert {
-		return *user, "", nil
+		return *user, PublicKey{}, nil
 	}
 	if len(user.PublicKeys) == 0 {
-		return *user, "", ErrInvalidCredentials
+		return *user, PublicKey{}, ErrInvalidCredentials
 	}
 	for i, k := range user.PublicKeys {
-		storedPubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		storedPubKey, err := ParsePublicKey(k)
 		if err != nil {
-			providerLog(logger.LevelError, "error parsing stored public key %d for user %s: %v", i, user.Username, err)
-			return *user, "", err
+			providerLog(logger.LevelError, "skipping invalid stored public key nr. %d for user %q: %v",
+				i+1, user.Username, err)
+			continue
 		}
-		if bytes.Equal(storedPubKey.Marshal(), pubKey) {
-			return *user, fmt.Sprintf("%s:%s", ssh.FingerprintSHA256(storedPubKey), comment), nil
+		if bytes.Equal(storedPubKey.key.Marshal(), pubKey) {
+			if storedPubKey.IsExpired() {
+				providerLog(logger.LevelInfo, "public key %q for user %q is expired", storedPubKey.GetID(), user.Username)
+				return *user, PublicKey{}, ErrInvalidCredentials
+			}
+			if !storedPubKey.IsAllowedFrom(ip) {
+				providerLog(logger.LevelInfo, "public key %q for user %q cannot be used from ip %q",
+					storedPubKey.GetID(), user.Username, ip)
+				return *user, PublicKey{}, ErrInvalidCredentials
+			}
+			storedPubKey.LastUseAt = user.Filters.PublicKeysLastUse[storedPubKey.Fingerprint]
+			return *user, storedPubKey, nil
 		}
 	}
-	return *user, "", ErrInvalidCredentials
+	return *user, PublicKey{}, ErrInvalidCredentials
 }
 
 func compareUnixPasswordAndHash(user *User, password string) (bool, error) {
@@ -3500,7 +3786,16 @@ func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractive
 	}
 	_, err = checkUserAndPass(user, answers[0], ip, protocol)
 	if err != nil {
-		return 0, err
+		if a := getRADIUSPasswordAuthenticator(user, protocol); a != nil {
+			err = a.handleKeyboardInteractiveChallenges(user, client, err)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if user.Filters.IsAnonymous || user.MustUseRADIUSSecondFactor(protocol) {
+		// the RADIUS passcode is asked after the first factor, for any keyboard interactive method
+		return 1, nil
 	}
 	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, protocolSSH) {
 		return 1, nil
@@ -3767,6 +4062,11 @@ func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardI
 	if err != nil {
 		return *user, err
 	}
+	if user.MustUseRADIUSSecondFactor(protocol) {
+		if err := CheckRADIUSSecondFactor(user, client); err != nil {
+			return *user, err
+		}
+	}
 	return *user, nil
 }
 
@@ -3925,6 +4225,8 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 	userCreatedAt := u.CreatedAt
 	totpConfig := u.Filters.TOTPConfig
 	recoveryCodes := u.Filters.RecoveryCodes
+	passwordHistory := u.Filters.PasswordHistory
+	publicKeysLastUse := u.Filters.PublicKeysLastUse
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("invalid pre-login hook response %q, error: %v", string(out), err)
@@ -3944,9 +4246,11 @@ func executePreLoginHook(username, loginMethod, ip, protocol string, oidcTokenFi
 		err = provider.addUser(&u)
 	} else {
 		u.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes, password history and public keys last use
 		u.Filters.TOTPConfig = totpConfig
 		u.Filters.RecoveryCodes = recoveryCodes
+		u.Filters.PasswordHistory = passwordHistory
+		u.Filters.PublicKeysLastUse = publicKeysLastUse
 		err = provider.updateUser(&u)
 		if err == nil {
 			webDAVUsersCache.swap(&u)
@@ -4178,9 +4482,11 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.FirstUpload = u.FirstUpload
 		user.CreatedAt = u.CreatedAt
 		user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes, password history and public keys last use
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.PasswordHistory = u.Filters.PasswordHistory
+		user.Filters.PublicKeysLastUse = u.Filters.PublicKeysLastUse
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
@@ -4251,9 +4557,11 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		user.LastPasswordChange = u.LastPasswordChange
 		user.FirstDownload = u.FirstDownload
 		user.FirstUpload = u.FirstUpload
-		// preserve TOTP config and recovery codes
+		// preserve TOTP config, recovery codes, password history and public keys last use
 		user.Filters.TOTPConfig = u.Filters.TOTPConfig
 		user.Filters.RecoveryCodes = u.Filters.RecoveryCodes
+		user.Filters.PasswordHistory = u.Filters.PasswordHistory
+		user.Filters.PublicKeysLastUse = u.Filters.PublicKeysLastUse
 		err = provider.updateUser(&user)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
@@ -4303,6 +4611,27 @@ func getUserAndJSONForHook(username string, oidcTokenFields *map[string]any) (Us
 	return u, mergedUser, userAsJSON, err
 }
 
+// checkObjectVersion returns ErrVersionConflict if an expected version is set
+// and it does not match the current one
+func checkObjectVersion(expected, current int64) error {
+	if expected > 0 && expected != current {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// getNextObjectVersion returns the update time to set for an object whose
+// current version is the specified one. The update time comes from the writer's
+// clock, it is forced to be greater than the current version so two updates
+// within the same millisecond, or a clock going backwards, never reuse a version
+func getNextObjectVersion(current int64) int64 {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	if now <= current {
+		return current + 1
+	}
+	return now
+}
+
 func isLastActivityRecent(lastActivity int64, minDelay time.Duration) bool {
 	lastActivityTime := util.GetTimeFromMsecSinceEpoch(lastActivity)
 	diff := -time.Until(lastActivityTime)
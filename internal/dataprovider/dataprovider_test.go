@@ -0,0 +1,70 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// getTestProviderConf returns the data provider configuration defined using the same
+// environment variables used for the other test packages, SQLite is used by default
+func getTestProviderConf(t *testing.T) Config {
+	port, _ := strconv.Atoi(os.Getenv("SFTPGO_DATA_PROVIDER__PORT"))
+	conf := Config{
+		Driver:             os.Getenv("SFTPGO_DATA_PROVIDER__DRIVER"),
+		Name:               os.Getenv("SFTPGO_DATA_PROVIDER__NAME"),
+		Host:               os.Getenv("SFTPGO_DATA_PROVIDER__HOST"),
+		Port:               port,
+		Username:           os.Getenv("SFTPGO_DATA_PROVIDER__USERNAME"),
+		Password:           os.Getenv("SFTPGO_DATA_PROVIDER__PASSWORD"),
+		SQLTablesPrefix:    os.Getenv("SFTPGO_DATA_PROVIDER__SQL_TABLES_PREFIX"),
+		TargetSessionAttrs: os.Getenv("SFTPGO_DATA_PROVIDER__TARGET_SESSION_ATTRS"),
+		TrackQuota:         2,
+		PasswordHashing: PasswordHashing{
+			BcryptOptions: BcryptOptions{
+				Cost: 10,
+			},
+			Algo: HashingAlgoBcrypt,
+		},
+		PasswordCaching: true,
+		NamingRules:     1,
+		BackupsPath:     "backups",
+	}
+	if conf.Driver == "" {
+		conf.Driver = SQLiteDataProviderName
+		conf.Name = filepath.Join(t.TempDir(), "sftpgo.db")
+	}
+	return conf
+}
+
+func isSharedTestProvider(conf Config) bool {
+	return util.Contains(sharedProviders, conf.Driver)
+}
+
+func initializeTestProvider(t *testing.T, conf Config) {
+	err := Initialize(conf, t.TempDir(), false)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		err := Close()
+		require.NoError(t, err)
+	})
+}
@@ -48,13 +48,14 @@ const (
 	ActionTypePasswordExpirationCheck
 	ActionTypeUserExpirationCheck
 	ActionTypeIDPAccountCheck
+	ActionTypeUploadsCleanup
 )
 
 var (
 	supportedEventActions = []int{ActionTypeHTTP, ActionTypeCommand, ActionTypeEmail, ActionTypeFilesystem,
 		ActionTypeBackup, ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypePasswordExpirationCheck,
-		ActionTypeUserExpirationCheck, ActionTypeIDPAccountCheck}
+		ActionTypeUserExpirationCheck, ActionTypeIDPAccountCheck, ActionTypeUploadsCleanup}
 )
 
 func isActionTypeValid(action int) bool {
@@ -87,6 +88,8 @@ func getActionTypeAsString(action int) string {
 		return "User expiration check"
 	case ActionTypeIDPAccountCheck:
 		return "Identity Provider account check"
+	case ActionTypeUploadsCleanup:
+		return "Abandoned uploads cleanup"
 	default:
 		return "Command"
 	}
@@ -878,6 +881,90 @@ func (c *EventActionIDPAccountCheck) validate() error {
 	return nil
 }
 
+// EventActionBackupConfig defines the configuration for backup actions.
+// If no option is set the backup is saved, as plain JSON, within the configured
+// backups path using a file name based on the weekday and the hour
+type EventActionBackupConfig struct {
+	// Supported values: empty (no compression), "gzip", "zstd"
+	Compression string `json:"compression,omitempty"`
+	// Passphrase used to encrypt the backup, mutually exclusive with recipients
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+	// age public keys used to encrypt the backup
+	Recipients []string `json:"recipients,omitempty"`
+	// Virtual folder where to save the backup, empty means the configured backups path
+	Folder string `json:"folder,omitempty"`
+	// Directory, relative to the folder root or to the backups path, where to save the backup
+	Path string `json:"path,omitempty"`
+	// Number of backups to keep, 0 means no limit
+	Retention int `json:"retention,omitempty"`
+}
+
+// IsDefault returns true if no backup option is set
+func (c *EventActionBackupConfig) IsDefault() bool {
+	return c.Compression == "" && (c.Passphrase == nil || c.Passphrase.IsEmpty()) && len(c.Recipients) == 0 &&
+		c.Folder == "" && c.Path == "" && c.Retention == 0
+}
+
+// IsEncrypted returns true if the backup must be encrypted
+func (c *EventActionBackupConfig) IsEncrypted() bool {
+	return (c.Passphrase != nil && !c.Passphrase.IsEmpty()) || len(c.Recipients) > 0
+}
+
+// TryDecryptPassphrase decrypts the passphrase if encrypted
+func (c *EventActionBackupConfig) TryDecryptPassphrase() error {
+	if c.Passphrase != nil && !c.Passphrase.IsEmpty() {
+		if err := c.Passphrase.TryDecrypt(); err != nil {
+			return fmt.Errorf("unable to decrypt backup passphrase: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *EventActionBackupConfig) getACopy() EventActionBackupConfig {
+	recipients := make([]string, len(c.Recipients))
+	copy(recipients, c.Recipients)
+	return EventActionBackupConfig{
+		Compression: c.Compression,
+		Passphrase:  c.Passphrase.Clone(),
+		Recipients:  recipients,
+		Folder:      c.Folder,
+		Path:        c.Path,
+		Retention:   c.Retention,
+	}
+}
+
+func (c *EventActionBackupConfig) validate(additionalData string) error {
+	if !util.Contains(supportedBackupCompressions, c.Compression) {
+		return util.NewValidationError(fmt.Sprintf("unsupported backup compression: %q", c.Compression))
+	}
+	if c.Retention < 0 {
+		return util.NewValidationError("backup retention cannot be negative")
+	}
+	if c.Path != "" {
+		c.Path = strings.TrimPrefix(util.CleanPath(c.Path), "/")
+	}
+	c.Recipients = util.RemoveDuplicates(c.Recipients, true)
+	if c.Passphrase.IsRedacted() {
+		return util.NewValidationError("cannot save backup configuration with a redacted secret")
+	}
+	if !c.Passphrase.IsEmpty() && len(c.Recipients) > 0 {
+		return util.NewValidationError("backup passphrase and recipients are mutually exclusive")
+	}
+	for _, recipient := range c.Recipients {
+		if _, err := parseBackupRecipient(recipient); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid backup recipient %q: %v", recipient, err))
+		}
+	}
+	if c.Passphrase.IsPlain() {
+		c.Passphrase.SetAdditionalData(additionalData)
+		err := c.Passphrase.Encrypt()
+		if err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt backup passphrase: %v", err))
+		}
+	}
+	return nil
+}
+
 // BaseEventActionOptions defines the supported configuration options for a base event actions
 type BaseEventActionOptions struct {
 	HTTPConfig          EventActionHTTPConfig          `json:"http_config"`
@@ -887,6 +974,7 @@ type BaseEventActionOptions struct {
 	FsConfig            EventActionFilesystemConfig    `json:"fs_config"`
 	PwdExpirationConfig EventActionPasswordExpiration  `json:"pwd_expiration_config"`
 	IDPConfig           EventActionIDPAccountCheck     `json:"idp_config"`
+	BackupConfig        EventActionBackupConfig        `json:"backup_config"`
 }
 
 func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
@@ -952,7 +1040,8 @@ func (o *BaseEventActionOptions) getACopy() BaseEventActionOptions {
 			TemplateUser:  o.IDPConfig.TemplateUser,
 			TemplateAdmin: o.IDPConfig.TemplateAdmin,
 		},
-		FsConfig: o.FsConfig.getACopy(),
+		FsConfig:     o.FsConfig.getACopy(),
+		BackupConfig: o.BackupConfig.getACopy(),
 	}
 }
 
@@ -961,18 +1050,27 @@ func (o *BaseEventActionOptions) SetEmptySecretsIfNil() {
 	if o.HTTPConfig.Password == nil {
 		o.HTTPConfig.Password = kms.NewEmptySecret()
 	}
+	if o.BackupConfig.Passphrase == nil {
+		o.BackupConfig.Passphrase = kms.NewEmptySecret()
+	}
 }
 
 func (o *BaseEventActionOptions) setNilSecretsIfEmpty() {
 	if o.HTTPConfig.Password != nil && o.HTTPConfig.Password.IsEmpty() {
 		o.HTTPConfig.Password = nil
 	}
+	if o.BackupConfig.Passphrase != nil && o.BackupConfig.Passphrase.IsEmpty() {
+		o.BackupConfig.Passphrase = nil
+	}
 }
 
 func (o *BaseEventActionOptions) hideConfidentialData() {
 	if o.HTTPConfig.Password != nil {
 		o.HTTPConfig.Password.Hide()
 	}
+	if o.BackupConfig.Passphrase != nil {
+		o.BackupConfig.Passphrase.Hide()
+	}
 }
 
 func (o *BaseEventActionOptions) validate(action int, name string) error {
@@ -985,6 +1083,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.HTTPConfig.validate(name)
 	case ActionTypeCommand:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -993,6 +1092,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.CmdConfig.validate()
 	case ActionTypeEmail:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -1001,6 +1101,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.EmailConfig.validate()
 	case ActionTypeDataRetentionCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -1009,6 +1110,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.RetentionConfig.validate()
 	case ActionTypeFilesystem:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -1017,6 +1119,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.FsConfig.validate()
 	case ActionTypePasswordExpirationCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -1025,6 +1128,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.PwdExpirationConfig.validate()
 	case ActionTypeIDPAccountCheck:
 		o.HTTPConfig = EventActionHTTPConfig{}
@@ -1033,7 +1137,17 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.RetentionConfig = EventActionDataRetentionConfig{}
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.BackupConfig = EventActionBackupConfig{}
 		return o.IDPConfig.validate()
+	case ActionTypeBackup:
+		o.HTTPConfig = EventActionHTTPConfig{}
+		o.CmdConfig = EventActionCommandConfig{}
+		o.EmailConfig = EventActionEmailConfig{}
+		o.RetentionConfig = EventActionDataRetentionConfig{}
+		o.FsConfig = EventActionFilesystemConfig{}
+		o.PwdExpirationConfig = EventActionPasswordExpiration{}
+		o.IDPConfig = EventActionIDPAccountCheck{}
+		return o.BackupConfig.validate(name)
 	default:
 		o.HTTPConfig = EventActionHTTPConfig{}
 		o.CmdConfig = EventActionCommandConfig{}
@@ -1042,6 +1156,7 @@ func (o *BaseEventActionOptions) validate(action int, name string) error {
 		o.FsConfig = EventActionFilesystemConfig{}
 		o.PwdExpirationConfig = EventActionPasswordExpiration{}
 		o.IDPConfig = EventActionIDPAccountCheck{}
+		o.BackupConfig = EventActionBackupConfig{}
 	}
 	return nil
 }
@@ -1060,6 +1175,10 @@ type BaseEventAction struct {
 	Options BaseEventActionOptions `json:"options"`
 	// list of rule names associated with this event action
 	Rules []string `json:"rules,omitempty"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 func (a *BaseEventAction) getACopy() BaseEventAction {
@@ -1072,6 +1191,7 @@ func (a *BaseEventAction) getACopy() BaseEventAction {
 		Type:        a.Type,
 		Options:     a.Options.getACopy(),
 		Rules:       rules,
+		UpdatedAt:   a.UpdatedAt,
 	}
 }
 
@@ -1443,6 +1563,8 @@ type EventRule struct {
 	Actions []EventAction `json:"actions"`
 	// in multi node setups we mark the rule as deleted to be able to update the cache
 	DeletedAt int64 `json:"-"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 func (r *EventRule) getACopy() EventRule {
@@ -1559,7 +1681,7 @@ func (r *EventRule) validateMandatorySyncActions() error {
 func (r *EventRule) checkIPBlockedAndCertificateActions() error {
 	unavailableActions := []int{ActionTypeUserQuotaReset, ActionTypeFolderQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem, ActionTypePasswordExpirationCheck,
-		ActionTypeUserExpirationCheck}
+		ActionTypeUserExpirationCheck, ActionTypeUploadsCleanup}
 	for _, action := range r.Actions {
 		if util.Contains(unavailableActions, action.Type) {
 			return fmt.Errorf("action %q, type %q is not supported for event trigger %q",
@@ -1575,7 +1697,7 @@ func (r *EventRule) checkProviderEventActions(providerObjectType string) error {
 	// affected user. Folder quota reset can be executed only for folders.
 	userSpecificActions := []int{ActionTypeUserQuotaReset, ActionTypeTransferQuotaReset,
 		ActionTypeDataRetentionCheck, ActionTypeMetadataCheck, ActionTypeFilesystem,
-		ActionTypePasswordExpirationCheck, ActionTypeUserExpirationCheck}
+		ActionTypePasswordExpirationCheck, ActionTypeUserExpirationCheck, ActionTypeUploadsCleanup}
 	for _, action := range r.Actions {
 		if util.Contains(userSpecificActions, action.Type) && providerObjectType != actionObjectUser {
 			return fmt.Errorf("action %q, type %q is only supported for provider user events",
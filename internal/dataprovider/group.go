@@ -33,6 +33,8 @@ type GroupUserSettings struct {
 	sdk.BaseGroupUserSettings
 	// Filesystem configuration details
 	FsConfig vfs.Filesystem `json:"filesystem"`
+	// Number of previous passwords that cannot be reused
+	PasswordHistorySize int `json:"password_history_size,omitempty"`
 }
 
 // Group defines an SFTPGo group.
@@ -43,6 +45,8 @@ type Group struct {
 	UserSettings GroupUserSettings `json:"user_settings,omitempty"`
 	// Mapping between virtual paths and virtual folders
 	VirtualFolders []vfs.VirtualFolder `json:"virtual_folders,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // GetPermissions returns the permissions as list
@@ -177,6 +181,9 @@ func (g *Group) validateUserSettings() error {
 	if err := validateBaseFilters(&g.UserSettings.Filters); err != nil {
 		return err
 	}
+	if g.UserSettings.PasswordHistorySize < 0 {
+		return util.NewValidationError(fmt.Sprintf("invalid password history size: %d", g.UserSettings.PasswordHistorySize))
+	}
 	if !g.HasExternalAuth() {
 		g.UserSettings.Filters.ExternalAuthCacheTime = 0
 	}
@@ -226,7 +233,8 @@ func (g *Group) getACopy() Group {
 				ExpiresIn:            g.UserSettings.ExpiresIn,
 				Filters:              copyBaseUserFilters(g.UserSettings.Filters),
 			},
-			FsConfig: g.UserSettings.FsConfig.GetACopy(),
+			FsConfig:            g.UserSettings.FsConfig.GetACopy(),
+			PasswordHistorySize: g.UserSettings.PasswordHistorySize,
 		},
 		VirtualFolders: virtualFolders,
 	}
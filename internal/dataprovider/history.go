@@ -0,0 +1,543 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	sdkkms "github.com/sftpgo/sdk/kms"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/plugin"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+var (
+	// HistoryObjectTypes defines the object types with a change history
+	HistoryObjectTypes = []string{actionObjectUser, actionObjectGroup, actionObjectFolder, actionObjectAdmin,
+		actionObjectEventRule, actionObjectRole}
+	secretJSONFields = []string{"status", "payload", "key", "additional_data", "mode"}
+)
+
+// ObjectHistoryConfig defines the configuration for the change history of users,
+// groups, folders, admins, event rules and roles
+type ObjectHistoryConfig struct {
+	// Set to true to store a snapshot of the objects each time they are added,
+	// updated or deleted. Secrets are redacted within the stored snapshots
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of days to keep the stored versions, 0 means no time based limit
+	Retention int `json:"retention" mapstructure:"retention"`
+	// Maximum number of versions to keep for each object, 0 means no limit
+	MaxVersions int `json:"max_versions" mapstructure:"max_versions"`
+}
+
+func (c *ObjectHistoryConfig) validate() error {
+	if c.Retention < 0 {
+		return fmt.Errorf("invalid object history retention: %d", c.Retention)
+	}
+	if c.MaxVersions < 0 {
+		return fmt.Errorf("invalid object history max versions: %d", c.MaxVersions)
+	}
+	return nil
+}
+
+// ObjectVersion defines a stored snapshot of an object
+type ObjectVersion struct {
+	ID         int64  `json:"id"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// Provider action that generated this version: add, update, delete
+	Action   string `json:"action"`
+	Executor string `json:"executor"`
+	IP       string `json:"ip"`
+	Role     string `json:"role,omitempty"`
+	// Snapshot of the object as JSON with secrets redacted,
+	// it is not returned when listing the versions
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// GetCreatedAtAsString returns the version creation time as string
+func (v *ObjectVersion) GetCreatedAtAsString() string {
+	if v.CreatedAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(v.CreatedAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+// ObjectVersionDiff defines the field level differences between two versions
+type ObjectVersionDiff struct {
+	// Version compared with, 0 means an empty object
+	From    int64                `json:"from"`
+	To      int64                `json:"to"`
+	Changes []util.JSONDiffEntry `json:"changes"`
+}
+
+// HasObjectHistory returns true if the object change history is enabled
+func HasObjectHistory() bool {
+	return config.ObjectHistory.Enabled
+}
+
+func isObjectHistoryEnabled(objectType string) bool {
+	return config.ObjectHistory.Enabled && util.Contains(HistoryObjectTypes, objectType)
+}
+
+func addObjectVersion(operation, executor, ip, objectType, objectName, role string, object plugin.Renderer) {
+	if !isObjectHistoryEnabled(objectType) {
+		return
+	}
+	data, err := object.RenderAsJSON(operation != operationDelete)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to render %s %q for the change history: %v", objectType, objectName, err)
+		return
+	}
+	data, err = redactJSONSecrets(data)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to redact secrets for %s %q: %v", objectType, objectName, err)
+		return
+	}
+	version := ObjectVersion{
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Action:     operation,
+		Executor:   executor,
+		IP:         ip,
+		Role:       role,
+		Data:       data,
+		CreatedAt:  util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	if err := provider.addObjectVersion(&version); err != nil {
+		providerLog(logger.LevelError, "unable to add a new version for %s %q: %v", objectType, objectName, err)
+	}
+}
+
+func cleanupObjectHistory() {
+	before := util.GetTimeAsMsSinceEpoch(time.Now().Add(-24 * time.Hour * time.Duration(config.ObjectHistory.Retention)))
+	if err := provider.cleanupObjectHistory(before); err != nil {
+		providerLog(logger.LevelError, "unable to cleanup object history: %v", err)
+	} else {
+		providerLog(logger.LevelDebug, "object history cleanup ok")
+	}
+}
+
+func checkObjectHistoryAccess(objectType, objectName, role string) (string, error) {
+	if !util.Contains(HistoryObjectTypes, objectType) {
+		return "", util.NewValidationError(fmt.Sprintf("unsupported object type %q", objectType))
+	}
+	objectName = config.convertName(objectName)
+	// role admins can only access the history of the existing users with their role
+	if role != "" && objectType == actionObjectUser {
+		if _, err := provider.userExists(objectName, role); err != nil {
+			return "", err
+		}
+	}
+	return objectName, nil
+}
+
+// GetObjectHistory returns the versions stored for the specified object, the
+// snapshots are not included
+func GetObjectHistory(objectType, objectName string, limit, offset int, order, role string) ([]ObjectVersion, error) {
+	objectName, err := checkObjectHistoryAccess(objectType, objectName, role)
+	if err != nil {
+		return nil, err
+	}
+	return provider.getObjectVersions(objectType, objectName, limit, offset, order)
+}
+
+// GetObjectVersion returns the specified version including the object snapshot
+func GetObjectVersion(objectType, objectName string, id int64, role string) (ObjectVersion, error) {
+	objectName, err := checkObjectHistoryAccess(objectType, objectName, role)
+	if err != nil {
+		return ObjectVersion{}, err
+	}
+	return provider.getObjectVersion(objectType, objectName, id)
+}
+
+// GetObjectVersionDiff returns the differences between the specified version and
+// the one to compare with. If compareTo is 0 the previous version is used
+func GetObjectVersionDiff(objectType, objectName string, id, compareTo int64, role string) (ObjectVersionDiff, error) {
+	diff := ObjectVersionDiff{
+		To: id,
+	}
+	objectName, err := checkObjectHistoryAccess(objectType, objectName, role)
+	if err != nil {
+		return diff, err
+	}
+	version, err := provider.getObjectVersion(objectType, objectName, id)
+	if err != nil {
+		return diff, err
+	}
+	var from ObjectVersion
+	if compareTo > 0 {
+		from, err = provider.getObjectVersion(objectType, objectName, compareTo)
+	} else {
+		from, err = provider.getPreviousObjectVersion(objectType, objectName, id)
+		if errors.Is(err, util.ErrNotFound) {
+			err = nil
+			from.Data = []byte("{}")
+		}
+	}
+	if err != nil {
+		return diff, err
+	}
+	diff.From = from.ID
+	diff.Changes, err = util.DiffJSON(from.Data, version.Data)
+	if diff.Changes == nil {
+		diff.Changes = []util.JSONDiffEntry{}
+	}
+	return diff, err
+}
+
+// RestoreObjectVersion restores the specified object version. Secrets are not
+// stored within the history so the current ones are preserved, if any.
+// Deleted objects are added again
+func RestoreObjectVersion(objectType, objectName string, id int64, executor, ipAddress, role string) error {
+	objectName, err := checkObjectHistoryAccess(objectType, objectName, role)
+	if err != nil {
+		return err
+	}
+	version, err := provider.getObjectVersion(objectType, objectName, id)
+	if err != nil {
+		return err
+	}
+	switch objectType {
+	case actionObjectUser:
+		return restoreUserVersion(&version, executor, ipAddress, role)
+	case actionObjectGroup:
+		return restoreGroupVersion(&version, executor, ipAddress, role)
+	case actionObjectFolder:
+		return restoreFolderVersion(&version, executor, ipAddress, role)
+	case actionObjectAdmin:
+		return restoreAdminVersion(&version, executor, ipAddress, role)
+	case actionObjectEventRule:
+		return restoreEventRuleVersion(&version, executor, ipAddress, role)
+	default:
+		return restoreRoleVersion(&version, executor, ipAddress, role)
+	}
+}
+
+func restoreUserVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var user User
+	current, err := provider.userExists(version.ObjectName, "")
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &user); err != nil {
+			return err
+		}
+		if role != "" {
+			user.Role = role
+		}
+		user.ID = 0
+		user.Username = version.ObjectName
+		user.Filters.TOTPConfig = UserTOTPConfig{}
+		user.Filters.RecoveryCodes = nil
+		user.SetEmptySecretsIfNil()
+		return AddUser(&user, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &user); err != nil {
+		return err
+	}
+	if role != "" {
+		user.Role = role
+	}
+	user.ID = current.ID
+	user.Username = current.Username
+	user.Password = current.Password
+	user.Filters.RecoveryCodes = current.Filters.RecoveryCodes
+	user.Filters.TOTPConfig = current.Filters.TOTPConfig
+	user.Filters.PasswordHistory = current.Filters.PasswordHistory
+	user.Filters.PublicKeysLastUse = current.Filters.PublicKeysLastUse
+	user.LastPasswordChange = current.LastPasswordChange
+	user.SetEmptySecretsIfNil()
+	return UpdateUser(&user, executor, ipAddress, role)
+}
+
+func restoreGroupVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var group Group
+	current, err := provider.groupExists(version.ObjectName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &group); err != nil {
+			return err
+		}
+		group.ID = 0
+		group.Name = version.ObjectName
+		group.Users = nil
+		group.Admins = nil
+		group.SetEmptySecretsIfNil()
+		return AddGroup(&group, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &group); err != nil {
+		return err
+	}
+	group.ID = current.ID
+	group.Name = current.Name
+	group.CreatedAt = current.CreatedAt
+	group.Users = current.Users
+	group.Admins = current.Admins
+	group.SetEmptySecretsIfNil()
+	return UpdateGroup(&group, current.Users, executor, ipAddress, role)
+}
+
+func restoreFolderVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var folder vfs.BaseVirtualFolder
+	current, err := provider.getFolderByName(version.ObjectName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &folder); err != nil {
+			return err
+		}
+		folder.ID = 0
+		folder.Name = version.ObjectName
+		folder.UsedQuotaSize = 0
+		folder.UsedQuotaFiles = 0
+		folder.LastQuotaUpdate = 0
+		folder.Users = nil
+		folder.Groups = nil
+		folder.FsConfig.SetEmptySecretsIfNil()
+		return AddFolder(&folder, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &folder); err != nil {
+		return err
+	}
+	folder.ID = current.ID
+	folder.Name = current.Name
+	folder.UsedQuotaSize = current.UsedQuotaSize
+	folder.UsedQuotaFiles = current.UsedQuotaFiles
+	folder.LastQuotaUpdate = current.LastQuotaUpdate
+	folder.Users = current.Users
+	folder.Groups = current.Groups
+	folder.FsConfig.SetEmptySecretsIfNil()
+	return UpdateFolder(&folder, current.Users, current.Groups, executor, ipAddress, role)
+}
+
+func restoreAdminVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var admin Admin
+	current, err := provider.adminExists(version.ObjectName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &admin); err != nil {
+			return err
+		}
+		admin.ID = 0
+		admin.Username = version.ObjectName
+		return AddAdmin(&admin, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &admin); err != nil {
+		return err
+	}
+	admin.ID = current.ID
+	admin.Username = current.Username
+	admin.Password = current.Password
+	admin.Filters.TOTPConfig = current.Filters.TOTPConfig
+	admin.Filters.RecoveryCodes = current.Filters.RecoveryCodes
+	admin.Filters.PasswordHistory = current.Filters.PasswordHistory
+	return UpdateAdmin(&admin, executor, ipAddress, role)
+}
+
+func restoreEventRuleVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var rule EventRule
+	current, err := provider.eventRuleExists(version.ObjectName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &rule); err != nil {
+			return err
+		}
+		rule.ID = 0
+		rule.Name = version.ObjectName
+		return AddEventRule(&rule, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &rule); err != nil {
+		return err
+	}
+	rule.ID = current.ID
+	rule.Name = current.Name
+	return UpdateEventRule(&rule, executor, ipAddress, role)
+}
+
+func restoreRoleVersion(version *ObjectVersion, executor, ipAddress, role string) error {
+	var r Role
+	current, err := provider.roleExists(version.ObjectName)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) {
+			return err
+		}
+		if err := decodeObjectVersion(version, nil, &r); err != nil {
+			return err
+		}
+		r.ID = 0
+		r.Name = version.ObjectName
+		return AddRole(&r, executor, ipAddress, role)
+	}
+	if err := decodeObjectVersion(version, &current, &r); err != nil {
+		return err
+	}
+	r.ID = current.ID
+	r.Name = current.Name
+	return UpdateRole(&r, executor, ipAddress, role)
+}
+
+// decodeObjectVersion decodes the version snapshot into the target object.
+// The redacted secrets are replaced with the ones of the current object,
+// if any, or removed
+func decodeObjectVersion(version *ObjectVersion, current, target any) error {
+	currentData := []byte("{}")
+	if current != nil {
+		data, err := json.Marshal(current)
+		if err != nil {
+			return err
+		}
+		currentData = data
+	}
+	snapshot, err := decodeJSONDocument(version.Data)
+	if err != nil {
+		return fmt.Errorf("unable to decode version %d: %w", version.ID, err)
+	}
+	currentDoc, err := decodeJSONDocument(currentData)
+	if err != nil {
+		return err
+	}
+	snapshot, _ = restoreJSONSecrets(snapshot, currentDoc, true)
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func decodeJSONDocument(data []byte) (any, error) {
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	return doc, err
+}
+
+func redactJSONSecrets(data []byte) ([]byte, error) {
+	doc, err := decodeJSONDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactJSONValue(doc))
+}
+
+func isJSONSecret(m map[string]any) bool {
+	if status, ok := m["status"].(string); !ok || status == "" {
+		return false
+	}
+	if _, ok := m["payload"]; !ok {
+		return false
+	}
+	for key := range m {
+		if !util.Contains(secretJSONFields, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRedactedJSONSecret(value any) bool {
+	m, ok := value.(map[string]any)
+	if !ok || len(m) != 1 {
+		return false
+	}
+	return m["status"] == sdkkms.SecretStatusRedacted
+}
+
+func redactJSONValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if isJSONSecret(v) {
+			return map[string]any{
+				"status": sdkkms.SecretStatusRedacted,
+			}
+		}
+		for key, val := range v {
+			v[key] = redactJSONValue(val)
+		}
+		return v
+	case []any:
+		for idx, val := range v {
+			v[idx] = redactJSONValue(val)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// restoreJSONSecrets replaces the redacted secrets within value with the ones
+// found at the same path within current. It returns false if value is a
+// redacted secret with no replacement and so it must be removed
+func restoreJSONSecrets(value, current any, found bool) (any, bool) {
+	if isRedactedJSONSecret(value) {
+		if m, ok := current.(map[string]any); ok && found && isJSONSecret(m) {
+			return m, true
+		}
+		return nil, false
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		c, _ := current.(map[string]any)
+		for key, val := range v {
+			currentVal, ok := c[key]
+			restored, keep := restoreJSONSecrets(val, currentVal, ok)
+			if keep {
+				v[key] = restored
+			} else {
+				delete(v, key)
+			}
+		}
+		return v, true
+	case []any:
+		c, _ := current.([]any)
+		for idx, val := range v {
+			var currentVal any
+			ok := idx < len(c)
+			if ok {
+				currentVal = c[idx]
+			}
+			restored, keep := restoreJSONSecrets(val, currentVal, ok)
+			if !keep {
+				restored = nil
+			}
+			v[idx] = restored
+		}
+		return v, true
+	default:
+		return value, true
+	}
+}
+
+// getObjectVersionKeyPrefix returns the key prefix for the versions of the specified
+// object, the keys are sorted by version ID
+func getObjectVersionKeyPrefix(objectType, objectName string) []byte {
+	return []byte(objectType + "\x00" + objectName + "\x00")
+}
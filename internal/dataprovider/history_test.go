@@ -0,0 +1,69 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+func TestDiffRedactedVersions(t *testing.T) {
+	from, err := redactJSONSecrets([]byte(`{"username":"user","filesystem":{"provider":1,"s3config":{"bucket":"b1",
+		"access_secret":{"status":"Plain","payload":"secret1"}}},"filters":{"hooks":{"status":"Plain","payload":"not a secret",
+		"other":1}},"actions":[{"secret":{"status":"Plain","payload":"secret2"}}]}`))
+	require.NoError(t, err)
+	to, err := redactJSONSecrets([]byte(`{"username":"user","filesystem":{"provider":1,"s3config":{"bucket":"b2",
+		"access_secret":{"status":"AES-256-GCM","payload":"encrypted","key":"k","additional_data":"user","mode":0}},
+		"sftpconfig":{"password":{"status":"Plain","payload":"secret3"}}},"filters":{"hooks":{"status":"Plain",
+		"payload":"not a secret","other":2}},"actions":[{"secret":{"status":"Plain","payload":"secret4"}},
+		{"secret":{"status":"Plain","payload":"secret5"}}]}`))
+	require.NoError(t, err)
+	for _, secret := range []string{"secret1", "secret2", "secret3", "secret4", "secret5", "encrypted"} {
+		assert.NotContains(t, string(from), secret)
+		assert.NotContains(t, string(to), secret)
+	}
+
+	changes, err := util.DiffJSON(from, to)
+	require.NoError(t, err)
+	expected := []util.JSONDiffEntry{
+		// a new secret in an array is reported within the array, redacted
+		{Path: "/actions", OldValue: json.RawMessage(`[{"secret":{"status":"Redacted"}}]`),
+			NewValue: json.RawMessage(`[{"secret":{"status":"Redacted"}},{"secret":{"status":"Redacted"}}]`)},
+		{Path: "/filesystem/s3config/bucket", OldValue: json.RawMessage(`"b1"`), NewValue: json.RawMessage(`"b2"`)},
+		// an added secret is reported redacted
+		{Path: "/filesystem/sftpconfig", NewValue: json.RawMessage(`{"password":{"status":"Redacted"}}`)},
+		// objects that are not secrets are not redacted
+		{Path: "/filters/hooks/other", OldValue: json.RawMessage(`1`), NewValue: json.RawMessage(`2`)},
+	}
+	// changed secrets cannot be detected, their redacted snapshots are equal
+	assert.Equal(t, expected, changes)
+	// the diff of the first version is computed against an empty object
+	changes, err = util.DiffJSON([]byte("{}"), from)
+	require.NoError(t, err)
+	if assert.Len(t, changes, 4) {
+		assert.Equal(t, "/actions", changes[0].Path)
+		assert.Equal(t, "/filesystem", changes[1].Path)
+		assert.JSONEq(t, `{"provider":1,"s3config":{"bucket":"b1","access_secret":{"status":"Redacted"}}}`,
+			string(changes[1].NewValue))
+		assert.Equal(t, "/filters", changes[2].Path)
+		assert.Empty(t, changes[2].OldValue)
+		assert.Equal(t, "/username", changes[3].Path)
+	}
+}
@@ -0,0 +1,814 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported formats for user imports
+const (
+	// /etc/passwd and, optionally, /etc/shadow
+	ImportFormatUnix = "unix"
+	// ProFTPD AuthUserFile, same format as /etc/passwd
+	ImportFormatProFTPD = "proftpd"
+	// Pure-FTPd pureftpd.passwd
+	ImportFormatPureFTPd = "pure-ftpd"
+	// vsftpd virtual users, usernames and plain text passwords on alternating
+	// lines, the same file used as input for db_load
+	ImportFormatVsftpd = "vsftpd"
+)
+
+// Import statuses for the single users
+const (
+	ImportStatusAdded   = "added"
+	ImportStatusUpdated = "updated"
+	ImportStatusSkipped = "skipped"
+	ImportStatusFailed  = "failed"
+)
+
+const (
+	// MaxImportUsersSize defines the max allowed size for the files to import
+	MaxImportUsersSize           = 10485760
+	maxAuthorizedKeysFileSize    = 1048576
+	daysToMilliseconds           = 86400 * 1000
+	importUnsupportedPwdWarning  = "the password hash format is not supported, the user was imported without a password"
+	importLockedPwdWarning       = "the password is locked, the user was imported as disabled"
+	importNoLoginShellWarning    = "the login shell %q does not allow logins, the user was imported as disabled"
+	importSystemHomeDirWarning   = "the home dir %q is a system directory, the user was imported with the list permission only"
+	importNoCredentialsError     = "no supported password or public key"
+	importExistingUserSkippedMsg = "the user already exists"
+	// used if the login.defs content is not provided or does not define
+	// the system accounts range
+	defaultImportSysUIDMax = 999
+)
+
+var (
+	validImportFormats  = []string{ImportFormatUnix, ImportFormatProFTPD, ImportFormatPureFTPd, ImportFormatVsftpd}
+	importedPwdPrefixes = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha256cryptPwdPrefix,
+		sha512cryptPwdPrefix, yescryptPwdPrefix, bcryptPwdPrefix, "$2b$", "$2y$"}
+	importNoLoginShells = []string{"nologin", "false"}
+	// home dirs of the system accounts, the users with these home dirs, or
+	// with a home dir inside the directories in importSystemDirs, do not get
+	// full permissions
+	importSystemHomeDirs = []string{"/", "/home", "/var", "/srv", "/opt", "/mnt", "/media", "/tmp", "/nonexistent"}
+	importSystemDirs     = []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib32", "/lib64", "/libx32", "/proc",
+		"/root", "/run", "/sbin", "/sys", "/usr", "/var/cache", "/var/empty", "/var/lib", "/var/log", "/var/run",
+		"/var/spool"}
+)
+
+// ImportUsersOptions defines the users to import and how to map them to SFTPGo users
+type ImportUsersOptions struct {
+	// Input format: unix, proftpd, pure-ftpd or vsftpd
+	Format string `json:"format"`
+	// Content of the users file, for example /etc/passwd for the unix format
+	Data string `json:"data"`
+	// Content of /etc/shadow, optional and supported for the unix format only
+	Shadow string `json:"shadow,omitempty"`
+	// Content of /etc/login.defs, optional and supported for the unix format
+	// only. SYS_UID_MAX, or UID_MIN, defines the system accounts range
+	LoginDefs string `json:"login_defs,omitempty"`
+	// If greater than 0 only import users with UID greater or equal to this value.
+	// For the unix format the system accounts are skipped by default
+	MinUID int `json:"min_uid,omitempty"`
+	// If greater than 0 only import users with UID lesser or equal to this value
+	MaxUID int `json:"max_uid,omitempty"`
+	// Only import users with these usernames, empty means all users
+	Usernames []string `json:"usernames,omitempty"`
+	// If greater than 0 the imported users will have this UID
+	ForceUID int `json:"force_uid,omitempty"`
+	// If greater than 0 the imported users will have this GID
+	ForceGID int `json:"force_gid,omitempty"`
+	// If set the home dir for the imported users will be this directory
+	// joined with the username. Required for the vsftpd format
+	HomeBaseDir string `json:"home_base_dir,omitempty"`
+	// Path to the OpenSSH authorized keys file to import public keys from.
+	// Relative paths are resolved against the user's home directory, the %h
+	// and %u tokens are replaced with the home directory and the username.
+	// Empty means no public keys are imported. The files are read from the
+	// local filesystem, so this option is only available from the CLI
+	AuthorizedKeysFile string `json:"-"`
+	// Content of the OpenSSH authorized keys for the users to import,
+	// the map key is the username
+	AuthorizedKeys map[string]string `json:"authorized_keys,omitempty"`
+	// Groups to assign to the imported users
+	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// If true existing users are updated, otherwise they are skipped
+	UpdateExisting bool `json:"update_existing,omitempty"`
+	// If true the users are validated but not saved
+	DryRun bool `json:"dry_run,omitempty"`
+	// max UID for the system accounts, 0 means no limit
+	sysUIDMax int
+}
+
+func (o *ImportUsersOptions) validate() error {
+	if !util.Contains(validImportFormats, o.Format) {
+		return util.NewValidationError(fmt.Sprintf("invalid import format %q, supported formats: %s", o.Format,
+			strings.Join(validImportFormats, ", ")))
+	}
+	if strings.TrimSpace(o.Data) == "" {
+		return util.NewValidationError("no data to import")
+	}
+	if len(o.Data) > MaxImportUsersSize || len(o.Shadow) > MaxImportUsersSize || len(o.LoginDefs) > MaxImportUsersSize {
+		return util.NewValidationError(fmt.Sprintf("the data to import exceed the max allowed size: %d bytes",
+			MaxImportUsersSize))
+	}
+	if err := o.validateAuthorizedKeys(); err != nil {
+		return err
+	}
+	if o.Shadow != "" && o.Format != ImportFormatUnix {
+		return util.NewValidationError("shadow data are only supported for the unix format")
+	}
+	if o.LoginDefs != "" && o.Format != ImportFormatUnix {
+		return util.NewValidationError("login.defs data are only supported for the unix format")
+	}
+	if o.Format == ImportFormatUnix && o.MinUID <= 0 {
+		// skip the system accounts unless a UID range is explicitly set
+		o.sysUIDMax = parseLoginDefsSysUIDMax(o.LoginDefs)
+	}
+	if o.MinUID > 0 && o.MaxUID > 0 && o.MinUID > o.MaxUID {
+		return util.NewValidationError("min_uid cannot be greater than max_uid")
+	}
+	if o.HomeBaseDir != "" {
+		if !filepath.IsAbs(o.HomeBaseDir) {
+			return util.NewValidationError(fmt.Sprintf("home_base_dir must be an absolute path, actual value: %q",
+				o.HomeBaseDir))
+		}
+		o.HomeBaseDir = filepath.Clean(o.HomeBaseDir)
+	}
+	if o.Format == ImportFormatVsftpd && o.HomeBaseDir == "" {
+		return util.NewValidationError("home_base_dir is required for the vsftpd format")
+	}
+	for idx := range o.Usernames {
+		o.Usernames[idx] = config.convertName(strings.TrimSpace(o.Usernames[idx]))
+	}
+	return nil
+}
+
+func (o *ImportUsersOptions) validateAuthorizedKeys() error {
+	if len(o.AuthorizedKeys) == 0 {
+		return nil
+	}
+	if o.AuthorizedKeysFile != "" {
+		return util.NewValidationError("authorized keys contents and file cannot be set together")
+	}
+	authorizedKeys := make(map[string]string)
+	totalSize := 0
+	for username, content := range o.AuthorizedKeys {
+		if len(content) > maxAuthorizedKeysFileSize {
+			return util.NewValidationError(fmt.Sprintf("the authorized keys for user %q exceed the max allowed size: %d bytes",
+				username, maxAuthorizedKeysFileSize))
+		}
+		totalSize += len(content)
+		authorizedKeys[config.convertName(strings.TrimSpace(username))] = content
+	}
+	if totalSize > MaxImportUsersSize {
+		return util.NewValidationError(fmt.Sprintf("the authorized keys exceed the max allowed size: %d bytes",
+			MaxImportUsersSize))
+	}
+	o.AuthorizedKeys = authorizedKeys
+	return nil
+}
+
+func (o *ImportUsersOptions) isUIDAllowed(uid int) bool {
+	// root is never imported
+	if uid == 0 {
+		return false
+	}
+	if o.sysUIDMax > 0 && uid <= o.sysUIDMax {
+		return false
+	}
+	if o.MinUID > 0 && uid < o.MinUID {
+		return false
+	}
+	if o.MaxUID > 0 && uid > o.MaxUID {
+		return false
+	}
+	return true
+}
+
+func (o *ImportUsersOptions) isUsernameAllowed(username string) bool {
+	if len(o.Usernames) == 0 {
+		return true
+	}
+	return util.Contains(o.Usernames, config.convertName(username))
+}
+
+// ImportUserResult defines the import outcome for a single user
+type ImportUserResult struct {
+	Username string   `json:"username"`
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ImportUsersResults defines the outcome of a users import
+type ImportUsersResults struct {
+	DryRun bool `json:"dry_run"`
+	// lines that cannot be parsed
+	Errors  []string           `json:"errors,omitempty"`
+	Results []ImportUserResult `json:"results"`
+}
+
+// Count returns the number of users with the specified status
+func (r *ImportUsersResults) Count(status string) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+type importedUser struct {
+	user     User
+	warnings []string
+}
+
+func (u *importedUser) setPassword(pwd string) {
+	if strings.HasPrefix(pwd, "!") {
+		// the account is locked, for example using "usermod -L", it could
+		// still have public keys so it is imported as disabled
+		pwd = strings.TrimLeft(pwd, "!")
+		u.user.Status = 0
+		u.warnings = append(u.warnings, importLockedPwdWarning)
+	}
+	switch {
+	case pwd == "" || pwd == "*" || pwd == "x":
+		return
+	case isImportablePasswordHash(pwd):
+		if strings.HasPrefix(pwd, "$2b$") || strings.HasPrefix(pwd, "$2y$") {
+			// same algorithm, we only recognize the $2a$ prefix as bcrypt hash
+			pwd = bcryptPwdPrefix + pwd[4:]
+		}
+		u.user.Password = pwd
+	default:
+		u.warnings = append(u.warnings, importUnsupportedPwdWarning)
+	}
+}
+
+// setShell disables the user if the login shell does not allow logins
+func (u *importedUser) setShell(shell string) {
+	shell = strings.TrimSpace(shell)
+	if shell == "" {
+		return
+	}
+	if util.Contains(importNoLoginShells, filepath.Base(shell)) {
+		u.user.Status = 0
+		u.warnings = append(u.warnings, fmt.Sprintf(importNoLoginShellWarning, shell))
+	}
+}
+
+func isImportablePasswordHash(pwd string) bool {
+	if strings.HasPrefix(pwd, yescryptPwdPrefix) && !isYescryptSupported() {
+		return false
+	}
+	return util.IsStringPrefixInSlice(pwd, importedPwdPrefixes)
+}
+
+// ImportUsers imports users from the supported external formats
+func ImportUsers(opts ImportUsersOptions, executor, ipAddress, role string) (ImportUsersResults, error) {
+	results := ImportUsersResults{
+		DryRun:  opts.DryRun,
+		Results: []ImportUserResult{},
+	}
+	if err := opts.validate(); err != nil {
+		return results, err
+	}
+	var users []importedUser
+
+	switch opts.Format {
+	case ImportFormatUnix:
+		var shadow map[string]shadowEntry
+		if opts.Shadow != "" {
+			shadow = parseShadow(opts.Shadow)
+		}
+		users, results.Errors = parsePasswdUsers(&opts, shadow)
+	case ImportFormatPureFTPd:
+		users, results.Errors = parsePureFTPdUsers(&opts)
+	case ImportFormatVsftpd:
+		users, results.Errors = parseVsftpdUsers(&opts)
+	default:
+		users, results.Errors = parsePasswdUsers(&opts, nil)
+	}
+	for idx := range users {
+		imported := &users[idx]
+		prepareImportedUser(imported, &opts, role)
+		results.Results = append(results.Results, importUser(imported, &opts, executor, ipAddress, role))
+	}
+	providerLog(logger.LevelInfo, "users import completed, format: %q, dry run: %t, added: %d, updated: %d, "+
+		"skipped: %d, failed: %d", opts.Format, opts.DryRun, results.Count(ImportStatusAdded),
+		results.Count(ImportStatusUpdated), results.Count(ImportStatusSkipped), results.Count(ImportStatusFailed))
+	return results, nil
+}
+
+func prepareImportedUser(imported *importedUser, opts *ImportUsersOptions, role string) {
+	user := &imported.user
+	if opts.ForceUID > 0 {
+		user.UID = opts.ForceUID
+	}
+	if opts.ForceGID > 0 {
+		user.GID = opts.ForceGID
+	}
+	if opts.HomeBaseDir != "" {
+		user.HomeDir = filepath.Join(opts.HomeBaseDir, user.Username)
+	} else if user.HomeDir != "" {
+		user.HomeDir = filepath.Clean(user.HomeDir)
+	}
+	if user.HomeDir != "" && isImportSystemDir(user.HomeDir) {
+		user.Permissions = map[string][]string{
+			"/": {PermListItems},
+		}
+		imported.warnings = append(imported.warnings, fmt.Sprintf(importSystemHomeDirWarning, user.HomeDir))
+	} else {
+		user.Permissions = map[string][]string{
+			"/": {PermAny},
+		}
+	}
+	user.Groups = append(user.Groups, opts.Groups...)
+	if role != "" {
+		user.Role = role
+	}
+	if opts.AuthorizedKeysFile != "" && user.HomeDir != "" {
+		keys, warnings := readAuthorizedKeys(opts.AuthorizedKeysFile, user.Username, user.HomeDir)
+		user.PublicKeys = keys
+		imported.warnings = append(imported.warnings, warnings...)
+	} else if content, ok := opts.AuthorizedKeys[config.convertName(user.Username)]; ok {
+		keys, warnings := parseAuthorizedKeys(content, "the provided authorized keys")
+		user.PublicKeys = keys
+		imported.warnings = append(imported.warnings, warnings...)
+	}
+}
+
+func importUser(imported *importedUser, opts *ImportUsersOptions, executor, ipAddress, role string) ImportUserResult {
+	user := imported.user
+	result := ImportUserResult{
+		Username: user.Username,
+		Warnings: imported.warnings,
+	}
+	if user.Password == "" && len(user.PublicKeys) == 0 {
+		result.Status = ImportStatusSkipped
+		result.Error = importNoCredentialsError
+		return result
+	}
+	existing, err := UserExists(user.Username, "")
+	if err == nil {
+		if !opts.UpdateExisting {
+			result.Status = ImportStatusSkipped
+			result.Error = importExistingUserSkippedMsg
+			return result
+		}
+		if role != "" && existing.Role != role {
+			result.Status = ImportStatusFailed
+			result.Error = "the existing user is not managed by your role"
+			return result
+		}
+		user = mergeImportedUser(existing, user)
+		result.Status = ImportStatusUpdated
+	} else {
+		var errNotFound *util.RecordNotFoundError
+		if !errors.As(err, &errNotFound) {
+			result.Status = ImportStatusFailed
+			result.Error = err.Error()
+			return result
+		}
+		result.Status = ImportStatusAdded
+	}
+	if opts.DryRun {
+		userCopy := user.getACopy()
+		err = ValidateUser(&userCopy)
+		if err == nil {
+			err = checkImportedUserGroups(&userCopy)
+		}
+	} else if result.Status == ImportStatusAdded {
+		err = AddUser(&user, executor, ipAddress, role)
+	} else {
+		err = UpdateUser(&user, executor, ipAddress, role)
+	}
+	if err != nil {
+		result.Status = ImportStatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func checkImportedUserGroups(user *User) error {
+	for _, g := range user.Groups {
+		if _, err := GroupExists(g.Name); err != nil {
+			return util.NewValidationError(fmt.Sprintf("unable to get group %q: %v", g.Name, err))
+		}
+	}
+	return nil
+}
+
+// mergeImportedUser applies the imported settings to an existing user
+func mergeImportedUser(existing, imported User) User {
+	user := existing.getACopy()
+	if imported.Password != "" {
+		user.Password = imported.Password
+	}
+	if len(imported.PublicKeys) > 0 {
+		user.PublicKeys = imported.PublicKeys
+	}
+	user.HomeDir = imported.HomeDir
+	user.UID = imported.UID
+	user.GID = imported.GID
+	user.Status = imported.Status
+	user.ExpirationDate = imported.ExpirationDate
+	if imported.Filters.RequirePasswordChange {
+		user.Filters.RequirePasswordChange = true
+	}
+	if imported.MaxSessions > 0 {
+		user.MaxSessions = imported.MaxSessions
+	}
+	if imported.QuotaSize > 0 {
+		user.QuotaSize = imported.QuotaSize
+	}
+	if imported.QuotaFiles > 0 {
+		user.QuotaFiles = imported.QuotaFiles
+	}
+	if imported.UploadBandwidth > 0 {
+		user.UploadBandwidth = imported.UploadBandwidth
+	}
+	if imported.DownloadBandwidth > 0 {
+		user.DownloadBandwidth = imported.DownloadBandwidth
+	}
+	if len(imported.Filters.AllowedIP) > 0 {
+		user.Filters.AllowedIP = imported.Filters.AllowedIP
+	}
+	if len(imported.Filters.DeniedIP) > 0 {
+		user.Filters.DeniedIP = imported.Filters.DeniedIP
+	}
+	for _, g := range imported.Groups {
+		found := false
+		for _, existingGroup := range user.Groups {
+			if existingGroup.Name == g.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			user.Groups = append(user.Groups, g)
+		}
+	}
+	return user
+}
+
+// isImportSystemDir returns true if the specified home dir is a system
+// directory or is inside one
+func isImportSystemDir(homeDir string) bool {
+	homeDir = path.Clean("/" + filepath.ToSlash(homeDir))
+	if util.Contains(importSystemHomeDirs, homeDir) {
+		return true
+	}
+	for _, dir := range importSystemDirs {
+		if homeDir == dir || strings.HasPrefix(homeDir, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLoginDefsSysUIDMax returns the max UID for the system accounts from the
+// login.defs content. SYS_UID_MAX is used if defined, otherwise UID_MIN - 1
+func parseLoginDefsSysUIDMax(data string) int {
+	sysUIDMax := -1
+	uidMin := -1
+	for _, line := range getImportLines(data) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		val, err := strconv.Atoi(fields[1])
+		if err != nil || val <= 0 {
+			continue
+		}
+		switch fields[0] {
+		case "SYS_UID_MAX":
+			sysUIDMax = val
+		case "UID_MIN":
+			uidMin = val
+		}
+	}
+	if sysUIDMax > 0 {
+		return sysUIDMax
+	}
+	if uidMin > 1 {
+		return uidMin - 1
+	}
+	return defaultImportSysUIDMax
+}
+
+func getImportLines(data string) []string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), MaxImportUsersSize)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return lines
+}
+
+type shadowEntry struct {
+	password   string
+	lastChange int64
+	maxDays    int64
+	inactive   int64
+	expire     int64
+}
+
+func parseShadowField(val string) int64 {
+	if val == "" {
+		return -1
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func parseShadow(data string) map[string]shadowEntry {
+	entries := make(map[string]shadowEntry)
+	for _, line := range getImportLines(data) {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 8 {
+			continue
+		}
+		entries[fields[0]] = shadowEntry{
+			password:   fields[1],
+			lastChange: parseShadowField(fields[2]),
+			maxDays:    parseShadowField(fields[4]),
+			inactive:   parseShadowField(fields[6]),
+			expire:     parseShadowField(fields[7]),
+		}
+	}
+	return entries
+}
+
+func (e *shadowEntry) apply(imported *importedUser) {
+	imported.setPassword(e.password)
+	if e.expire > 0 {
+		imported.user.ExpirationDate = e.expire * daysToMilliseconds
+	}
+	if e.lastChange == 0 && imported.user.Password != "" {
+		imported.user.Filters.RequirePasswordChange = true
+	}
+	if e.lastChange > 0 && e.maxDays >= 0 && e.inactive >= 0 {
+		today := time.Now().Unix() / 86400
+		if today > e.lastChange+e.maxDays+e.inactive {
+			// the password is expired and the inactivity period is over
+			imported.user.Status = 0
+		}
+	}
+}
+
+// parsePasswdUsers parses files using the /etc/passwd format:
+// username:password:uid:gid:gecos:home:shell
+func parsePasswdUsers(opts *ImportUsersOptions, shadow map[string]shadowEntry) ([]importedUser, []string) {
+	var users []importedUser
+	var parseErrors []string
+
+	for idx, line := range getImportLines(opts.Data) {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: invalid number of fields", idx+1))
+			continue
+		}
+		uid, errUID := strconv.Atoi(fields[2])
+		gid, errGID := strconv.Atoi(fields[3])
+		if errUID != nil || errGID != nil {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: invalid uid or gid", idx+1))
+			continue
+		}
+		if !opts.isUsernameAllowed(fields[0]) || !opts.isUIDAllowed(uid) {
+			continue
+		}
+		imported := importedUser{
+			user: User{
+				BaseUser: sdk.BaseUser{
+					Username: fields[0],
+					HomeDir:  fields[5],
+					UID:      uid,
+					GID:      gid,
+					Status:   1,
+				},
+			},
+		}
+		if entry, ok := shadow[fields[0]]; ok && fields[1] == "x" {
+			entry.apply(&imported)
+		} else {
+			imported.setPassword(fields[1])
+		}
+		imported.setShell(fields[6])
+		users = append(users, imported)
+	}
+	return users, parseErrors
+}
+
+func parsePureFTPdIPs(val string) ([]string, []string) {
+	var ips, warnings []string
+	for _, ip := range strings.Split(val, ",") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip += "/128"
+			} else {
+				ip += "/32"
+			}
+		}
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			warnings = append(warnings, fmt.Sprintf("IP restriction %q ignored: %v", ip, err))
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips, warnings
+}
+
+func parsePureFTPdInt(val string) int64 {
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parsePureFTPdUsers parses the pureftpd.passwd file format:
+// account:password:uid:gid:gecos:home:bw_ul:bw_dl:ratio_ul:ratio_dl:max_sessions:
+// files_quota:size_quota:local_ip_allow:local_ip_deny:client_ip_allow:client_ip_deny:time_restrictions...
+func parsePureFTPdUsers(opts *ImportUsersOptions) ([]importedUser, []string) {
+	var users []importedUser
+	var parseErrors []string
+
+	for idx, line := range getImportLines(opts.Data) {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 17 {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: invalid number of fields", idx+1))
+			continue
+		}
+		uid, errUID := strconv.Atoi(fields[2])
+		gid, errGID := strconv.Atoi(fields[3])
+		if errUID != nil || errGID != nil {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: invalid uid or gid", idx+1))
+			continue
+		}
+		if !opts.isUsernameAllowed(fields[0]) || !opts.isUIDAllowed(uid) {
+			continue
+		}
+		imported := importedUser{
+			user: User{
+				BaseUser: sdk.BaseUser{
+					Username: fields[0],
+					// chrooted users have home dirs like /home/user/./
+					HomeDir: fields[5],
+					UID:     uid,
+					GID:     gid,
+					Status:  1,
+					// bandwidth limits are saved as bytes per second
+					UploadBandwidth:   parsePureFTPdInt(fields[6]) / 1024,
+					DownloadBandwidth: parsePureFTPdInt(fields[7]) / 1024,
+					MaxSessions:       int(parsePureFTPdInt(fields[10])),
+					QuotaFiles:        int(parsePureFTPdInt(fields[11])),
+					QuotaSize:         parsePureFTPdInt(fields[12]),
+				},
+			},
+		}
+		imported.setPassword(fields[1])
+		allowed, warnings := parsePureFTPdIPs(fields[15])
+		imported.user.Filters.AllowedIP = allowed
+		imported.warnings = append(imported.warnings, warnings...)
+		denied, warnings := parsePureFTPdIPs(fields[16])
+		imported.user.Filters.DeniedIP = denied
+		imported.warnings = append(imported.warnings, warnings...)
+		users = append(users, imported)
+	}
+	return users, parseErrors
+}
+
+// parseVsftpdUsers parses the vsftpd virtual users file, usernames and plain
+// text passwords are on alternating lines
+func parseVsftpdUsers(opts *ImportUsersOptions) ([]importedUser, []string) {
+	var users []importedUser
+	var parseErrors []string
+
+	lines := getImportLines(strings.TrimRight(opts.Data, "\r\n"))
+	if len(lines)%2 != 0 {
+		parseErrors = append(parseErrors, fmt.Sprintf("line %d: missing password", len(lines)))
+		lines = lines[:len(lines)-1]
+	}
+	for idx := 0; idx < len(lines); idx += 2 {
+		username := strings.TrimSpace(lines[idx])
+		if username == "" {
+			parseErrors = append(parseErrors, fmt.Sprintf("line %d: empty username", idx+1))
+			continue
+		}
+		if !opts.isUsernameAllowed(username) {
+			continue
+		}
+		users = append(users, importedUser{
+			user: User{
+				BaseUser: sdk.BaseUser{
+					Username: username,
+					// plain text password, it will be hashed when the user is saved
+					Password: lines[idx+1],
+					Status:   1,
+				},
+			},
+		})
+	}
+	return users, parseErrors
+}
+
+func getAuthorizedKeysPath(authorizedKeysFile, username, homeDir string) string {
+	p := strings.NewReplacer("%%", "%", "%h", homeDir, "%u", username).Replace(authorizedKeysFile)
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(homeDir, p)
+	}
+	return p
+}
+
+// readAuthorizedKeys reads the public keys for the specified user from the
+// authorized keys file, a missing file is not an error
+func readAuthorizedKeys(authorizedKeysFile, username, homeDir string) ([]string, []string) {
+	var keys, warnings []string
+
+	p := getAuthorizedKeysPath(authorizedKeysFile, username, homeDir)
+	fi, err := os.Stat(p)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			warnings = append(warnings, fmt.Sprintf("unable to read authorized keys from %q: %v", p, err))
+		}
+		return keys, warnings
+	}
+	if fi.Size() > maxAuthorizedKeysFileSize {
+		warnings = append(warnings, fmt.Sprintf("authorized keys file %q is too big: %d bytes", p, fi.Size()))
+		return keys, warnings
+	}
+	content, err := os.ReadFile(p)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("unable to read authorized keys from %q: %v", p, err))
+		return keys, warnings
+	}
+	return parseAuthorizedKeys(string(content), fmt.Sprintf("%q", p))
+}
+
+// parseAuthorizedKeys returns the valid public keys from the authorized keys content,
+// source describes the content origin within the warnings
+func parseAuthorizedKeys(content, source string) ([]string, []string) {
+	var keys, warnings []string
+
+	for idx, line := range getImportLines(content) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := ParsePublicKey(line); err != nil {
+			warnings = append(warnings, fmt.Sprintf("public key at line %d of %s ignored: %v", idx+1, source, err))
+			continue
+		}
+		if !util.Contains(keys, line) {
+			keys = append(keys, line)
+		}
+	}
+	return keys, warnings
+}
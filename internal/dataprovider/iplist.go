@@ -114,6 +114,8 @@ type IPListEntry struct {
 	UpdatedAt int64 `json:"updated_at"`
 	// in multi node setups we mark the rule as deleted to be able to update the cache
 	DeletedAt int64 `json:"-"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // PrepareForRendering prepares an IP list entry for rendering.
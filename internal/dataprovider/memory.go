@@ -82,6 +82,10 @@ type memoryProviderHandle struct {
 	ipListEntriesKeys []string
 	// configurations
 	configs Configs
+	// map for object versions, object type and name are the key
+	objectHistory map[string][]ObjectVersion
+	// last assigned object version ID
+	objectVersionID int64
 }
 
 // MemoryProvider defines the auth provider for a memory store
@@ -121,6 +125,7 @@ func initializeMemoryProvider(basePath string) {
 			ipListEntries:     map[string]IPListEntry{},
 			ipListEntriesKeys: []string{},
 			configs:           Configs{},
+			objectHistory:     map[string][]ObjectVersion{},
 			configFile:        configFile,
 		},
 	}
@@ -171,17 +176,17 @@ func (p *MemoryProvider) validateUserAndPass(username, password, ip, protocol st
 	return checkUserAndPass(&user, password, ip, protocol)
 }
 
-func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
+func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
 	var user User
 	if len(pubKey) == 0 {
-		return user, "", errors.New("credentials cannot be null or empty")
+		return user, PublicKey{}, errors.New("credentials cannot be null or empty")
 	}
 	user, err := p.userExists(username, "")
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
-		return user, "", err
+		return user, PublicKey{}, err
 	}
-	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+	return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 }
 
 func (p *MemoryProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
@@ -386,6 +391,9 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(user.ExpectedVersion, u.UpdatedAt); err != nil {
+		return err
+	}
 	p.removeUserFromRole(u.Username, u.Role)
 	if err := p.addUserToRole(user.Username, user.Role); err != nil {
 		// try ro add old role
@@ -414,6 +422,8 @@ func (p *MemoryProvider) updateUser(user *User) error {
 		p.removeRelationFromFolderMapping(oldFolder.Name, u.Username, "")
 	}
 	user.VirtualFolders = p.joinUserVirtualFoldersFields(user)
+	user.mergePublicKeysLastUse(u.Filters.PublicKeysLastUse)
+	user.Filters.CryptFsReEncryption = u.Filters.CryptFsReEncryption
 	user.LastQuotaUpdate = u.LastQuotaUpdate
 	user.UsedQuotaSize = u.UsedQuotaSize
 	user.UsedQuotaFiles = u.UsedQuotaFiles
@@ -423,7 +433,7 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	user.FirstDownload = u.FirstDownload
 	user.FirstUpload = u.FirstUpload
 	user.CreatedAt = u.CreatedAt
-	user.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	user.UpdatedAt = getNextObjectVersion(u.UpdatedAt)
 	user.ID = u.ID
 	// pre-login and external auth hook will use the passed *user so save a copy
 	p.dbHandle.users[user.Username] = user.getACopy()
@@ -431,6 +441,23 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	return nil
 }
 
+// the memory provider does not support transactions: all the users are
+// validated before applying any change but an update error stops the processing
+// and the users already updated are not reverted
+func (p *MemoryProvider) updateUsers(users []*User) error {
+	for _, user := range users {
+		if err := ValidateUser(user); err != nil {
+			return newBulkUpdateError(user.Username, err)
+		}
+	}
+	for idx, user := range users {
+		if err := p.updateUser(user); err != nil {
+			return &bulkUpdateError{name: user.Username, err: err, numApplied: idx}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) deleteUser(user User, _ bool) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -441,6 +468,9 @@ func (p *MemoryProvider) deleteUser(user User, _ bool) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(user.ExpectedVersion, u.UpdatedAt); err != nil {
+		return err
+	}
 	p.removeUserFromRole(u.Username, u.Role)
 	for _, oldFolder := range u.VirtualFolders {
 		p.removeRelationFromFolderMapping(oldFolder.Name, u.Username, "")
@@ -476,6 +506,44 @@ func (p *MemoryProvider) updateUserPassword(username, password string) error {
 	return nil
 }
 
+func (p *MemoryProvider) updateUserPublicKeyLastUse(username, fingerprint string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	if user.Filters.PublicKeysLastUse == nil {
+		user.Filters.PublicKeysLastUse = make(map[string]int64)
+	}
+	user.Filters.PublicKeysLastUse[fingerprint] = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.users[username] = user
+	return nil
+}
+
+func (p *MemoryProvider) updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		return err
+	}
+	if err := checkCryptFsReEncryptionRunID(user.Filters.CryptFsReEncryption, expectedRunID); err != nil {
+		return err
+	}
+	user.Filters.CryptFsReEncryption = state.getACopy()
+	p.dbHandle.users[username] = user
+	return nil
+}
+
 func (p *MemoryProvider) dumpUsers() ([]User, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -576,7 +644,7 @@ func (p *MemoryProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User,
 	return users, nil
 }
 
-func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
+func (p *MemoryProvider) getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -590,15 +658,15 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 	itNum := 0
 	if order == OrderASC {
 		for _, username := range p.dbHandle.usernames {
+			u := p.dbHandle.users[username]
+			if !filters.match(&u) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			u := p.dbHandle.users[username]
 			user := u.getACopy()
-			if !user.hasRole(role) {
-				continue
-			}
 			p.addVirtualFoldersToUser(&user)
 			user.PrepareForRendering()
 			users = append(users, user)
@@ -608,16 +676,16 @@ func (p *MemoryProvider) getUsers(limit int, offset int, order, role string) ([]
 		}
 	} else {
 		for i := len(p.dbHandle.usernames) - 1; i >= 0; i-- {
+			username := p.dbHandle.usernames[i]
+			u := p.dbHandle.users[username]
+			if !filters.match(&u) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			username := p.dbHandle.usernames[i]
-			u := p.dbHandle.users[username]
 			user := u.getACopy()
-			if !user.hasRole(role) {
-				continue
-			}
 			p.addVirtualFoldersToUser(&user)
 			user.PrepareForRendering()
 			users = append(users, user)
@@ -740,6 +808,9 @@ func (p *MemoryProvider) updateAdmin(admin *Admin) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(admin.ExpectedVersion, a.UpdatedAt); err != nil {
+		return err
+	}
 	p.removeAdminFromRole(a.Username, a.Role)
 	if err := p.addAdminToRole(admin.Username, admin.Role); err != nil {
 		// try ro add old role
@@ -767,7 +838,7 @@ func (p *MemoryProvider) updateAdmin(admin *Admin) error {
 	admin.ID = a.ID
 	admin.CreatedAt = a.CreatedAt
 	admin.LastLogin = a.LastLogin
-	admin.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	admin.UpdatedAt = getNextObjectVersion(a.UpdatedAt)
 	p.dbHandle.admins[admin.Username] = admin.getACopy()
 	return nil
 }
@@ -782,6 +853,9 @@ func (p *MemoryProvider) deleteAdmin(admin Admin) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(admin.ExpectedVersion, a.UpdatedAt); err != nil {
+		return err
+	}
 	p.removeAdminFromRole(a.Username, a.Role)
 	for idx := range a.Groups {
 		p.removeAdminFromGroupMapping(a.Username, a.Groups[idx].Name)
@@ -898,7 +972,7 @@ func (p *MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd in
 	return nil
 }
 
-func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]Group, error) {
+func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool, filters SearchFilters) ([]Group, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -911,6 +985,9 @@ func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]G
 	itNum := 0
 	if order == OrderASC {
 		for _, name := range p.dbHandle.groupnames {
+			if !filters.match(name) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
@@ -926,11 +1003,14 @@ func (p *MemoryProvider) getGroups(limit, offset int, order string, _ bool) ([]G
 		}
 	} else {
 		for i := len(p.dbHandle.groupnames) - 1; i >= 0; i-- {
+			name := p.dbHandle.groupnames[i]
+			if !filters.match(name) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.groupnames[i]
 			g := p.dbHandle.groups[name]
 			group := g.getACopy()
 			p.addVirtualFoldersToGroup(&group)
@@ -1032,12 +1112,15 @@ func (p *MemoryProvider) updateGroup(group *Group) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(group.ExpectedVersion, g.UpdatedAt); err != nil {
+		return err
+	}
 	for _, oldFolder := range g.VirtualFolders {
 		p.removeRelationFromFolderMapping(oldFolder.Name, "", g.Name)
 	}
 	group.VirtualFolders = p.joinGroupVirtualFoldersFields(group)
 	group.CreatedAt = g.CreatedAt
-	group.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	group.UpdatedAt = getNextObjectVersion(g.UpdatedAt)
 	group.ID = g.ID
 	group.Users = g.Users
 	group.Admins = g.Admins
@@ -1045,6 +1128,20 @@ func (p *MemoryProvider) updateGroup(group *Group) error {
 	return nil
 }
 
+func (p *MemoryProvider) updateGroups(groups []*Group) error {
+	for _, group := range groups {
+		if err := group.validate(); err != nil {
+			return newBulkUpdateError(group.Name, err)
+		}
+	}
+	for idx, group := range groups {
+		if err := p.updateGroup(group); err != nil {
+			return &bulkUpdateError{name: group.Name, err: err, numApplied: idx}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) deleteGroup(group Group) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -1055,6 +1152,9 @@ func (p *MemoryProvider) deleteGroup(group Group) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(group.ExpectedVersion, g.UpdatedAt); err != nil {
+		return err
+	}
 	if len(g.Users) > 0 {
 		return util.NewValidationError(fmt.Sprintf("the group %q is referenced, it cannot be removed", group.Name))
 	}
@@ -1388,6 +1488,7 @@ func (p *MemoryProvider) addOrUpdateFolderInternal(baseFolder *vfs.BaseVirtualFo
 		folder.MappedPath = baseFolder.MappedPath
 		folder.Description = baseFolder.Description
 		folder.FsConfig = baseFolder.FsConfig.GetACopy()
+		folder.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		if username != "" && !util.Contains(folder.Users, username) {
 			folder.Users = append(folder.Users, username)
 		}
@@ -1403,6 +1504,7 @@ func (p *MemoryProvider) addOrUpdateFolderInternal(baseFolder *vfs.BaseVirtualFo
 		folder.UsedQuotaSize = usedQuotaSize
 		folder.UsedQuotaFiles = usedQuotaFiles
 		folder.LastQuotaUpdate = lastQuotaUpdate
+		folder.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 		if username != "" {
 			folder.Users = []string{username}
 		}
@@ -1422,7 +1524,7 @@ func (p *MemoryProvider) folderExistsInternal(name string) (vfs.BaseVirtualFolde
 	return vfs.BaseVirtualFolder{}, util.NewRecordNotFoundError(fmt.Sprintf("folder %q does not exist", name))
 }
 
-func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]vfs.BaseVirtualFolder, error) {
+func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	var err error
 	p.dbHandle.Lock()
@@ -1436,11 +1538,14 @@ func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]
 	itNum := 0
 	if order == OrderASC {
 		for _, name := range p.dbHandle.vfoldersNames {
+			f := p.dbHandle.vfolders[name]
+			if !filters.match(&f) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			f := p.dbHandle.vfolders[name]
 			folder := f.GetACopy()
 			folder.PrepareForRendering()
 			folders = append(folders, folder)
@@ -1450,12 +1555,15 @@ func (p *MemoryProvider) getFolders(limit, offset int, order string, _ bool) ([]
 		}
 	} else {
 		for i := len(p.dbHandle.vfoldersNames) - 1; i >= 0; i-- {
+			name := p.dbHandle.vfoldersNames[i]
+			f := p.dbHandle.vfolders[name]
+			if !filters.match(&f) {
+				continue
+			}
 			itNum++
 			if itNum <= offset {
 				continue
 			}
-			name := p.dbHandle.vfoldersNames[i]
-			f := p.dbHandle.vfolders[name]
 			folder := f.GetACopy()
 			folder.PrepareForRendering()
 			folders = append(folders, folder)
@@ -1499,6 +1607,7 @@ func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 	folder.ID = p.getNextFolderID()
 	folder.Users = nil
 	folder.Groups = nil
+	folder.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, folder.Name)
 	sort.Strings(p.dbHandle.vfoldersNames)
@@ -1520,12 +1629,16 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(folder.ExpectedVersion, f.UpdatedAt); err != nil {
+		return err
+	}
 	folder.ID = f.ID
 	folder.LastQuotaUpdate = f.LastQuotaUpdate
 	folder.UsedQuotaFiles = f.UsedQuotaFiles
 	folder.UsedQuotaSize = f.UsedQuotaSize
 	folder.Users = f.Users
 	folder.Groups = f.Groups
+	folder.UpdatedAt = getNextObjectVersion(f.UpdatedAt)
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	// now update the related users
 	for _, username := range folder.Users {
@@ -1546,6 +1659,20 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	return nil
 }
 
+func (p *MemoryProvider) updateFolders(folders []*vfs.BaseVirtualFolder) error {
+	for _, folder := range folders {
+		if err := ValidateFolder(folder); err != nil {
+			return newBulkUpdateError(folder.Name, err)
+		}
+	}
+	for idx, folder := range folders {
+		if err := p.updateFolder(folder); err != nil {
+			return &bulkUpdateError{name: folder.Name, err: err, numApplied: idx}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -1557,6 +1684,9 @@ func (p *MemoryProvider) deleteFolder(f vfs.BaseVirtualFolder) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(f.ExpectedVersion, folder.UpdatedAt); err != nil {
+		return err
+	}
 	for _, username := range folder.Users {
 		user, err := p.userExistsInternal(username)
 		if err == nil {
@@ -1660,6 +1790,9 @@ func (p *MemoryProvider) updateAPIKey(apiKey *APIKey) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(apiKey.ExpectedVersion, k.UpdatedAt); err != nil {
+		return err
+	}
 	if apiKey.User != "" {
 		if _, err := p.userExistsInternal(apiKey.User); err != nil {
 			return util.NewValidationError(fmt.Sprintf("related user %q does not exists", apiKey.User))
@@ -1675,7 +1808,7 @@ func (p *MemoryProvider) updateAPIKey(apiKey *APIKey) error {
 	apiKey.Key = k.Key
 	apiKey.CreatedAt = k.CreatedAt
 	apiKey.LastUseAt = k.LastUseAt
-	apiKey.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	apiKey.UpdatedAt = getNextObjectVersion(k.UpdatedAt)
 	p.dbHandle.apiKeys[apiKey.KeyID] = apiKey.getACopy()
 	return nil
 }
@@ -1686,10 +1819,13 @@ func (p *MemoryProvider) deleteAPIKey(apiKey APIKey) error {
 	if p.dbHandle.isClosed {
 		return errMemoryProviderClosed
 	}
-	_, err := p.apiKeyExistsInternal(apiKey.KeyID)
+	oldAPIKey, err := p.apiKeyExistsInternal(apiKey.KeyID)
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(apiKey.ExpectedVersion, oldAPIKey.UpdatedAt); err != nil {
+		return err
+	}
 
 	delete(p.dbHandle.apiKeys, apiKey.KeyID)
 	p.updateAPIKeysOrdering()
@@ -1886,6 +2022,9 @@ func (p *MemoryProvider) updateShare(share *Share) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(share.ExpectedVersion, s.UpdatedAt); err != nil {
+		return err
+	}
 	if _, err := p.userExistsInternal(share.Username); err != nil {
 		return util.NewValidationError(fmt.Sprintf("related user %q does not exists", share.Username))
 	}
@@ -1895,7 +2034,7 @@ func (p *MemoryProvider) updateShare(share *Share) error {
 		share.UsedTokens = s.UsedTokens
 		share.CreatedAt = s.CreatedAt
 		share.LastUseAt = s.LastUseAt
-		share.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		share.UpdatedAt = getNextObjectVersion(s.UpdatedAt)
 	}
 	if share.CreatedAt == 0 {
 		share.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
@@ -1913,10 +2052,13 @@ func (p *MemoryProvider) deleteShare(share Share) error {
 	if p.dbHandle.isClosed {
 		return errMemoryProviderClosed
 	}
-	_, err := p.shareExistsInternal(share.ShareID, share.Username)
+	oldShare, err := p.shareExistsInternal(share.ShareID, share.Username)
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(share.ExpectedVersion, oldShare.UpdatedAt); err != nil {
+		return err
+	}
 
 	delete(p.dbHandle.shares, share.ShareID)
 	p.updateSharesOrdering()
@@ -1924,7 +2066,7 @@ func (p *MemoryProvider) deleteShare(share Share) error {
 	return nil
 }
 
-func (p *MemoryProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+func (p *MemoryProvider) getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 
@@ -1940,7 +2082,7 @@ func (p *MemoryProvider) getShares(limit int, offset int, order, username string
 		for i := len(p.dbHandle.sharesIDs) - 1; i >= 0; i-- {
 			shareID := p.dbHandle.sharesIDs[i]
 			s := p.dbHandle.shares[shareID]
-			if s.Username != username {
+			if s.Username != username || !filters.match(s.Name) {
 				continue
 			}
 			itNum++
@@ -1957,7 +2099,7 @@ func (p *MemoryProvider) getShares(limit int, offset int, order, username string
 	} else {
 		for _, shareID := range p.dbHandle.sharesIDs {
 			s := p.dbHandle.shares[shareID]
-			if s.Username != username {
+			if s.Username != username || !filters.match(s.Name) {
 				continue
 			}
 			itNum++
@@ -2070,6 +2212,10 @@ func (p *MemoryProvider) getSharedSession(_ string) (Session, error) {
 	return Session{}, ErrNotImplemented
 }
 
+func (p *MemoryProvider) getSharedSessionsUpdatedAfter(_ SessionType, _ int64) ([]Session, error) {
+	return nil, ErrNotImplemented
+}
+
 func (p *MemoryProvider) cleanupSharedSessions(_ SessionType, _ int64) error {
 	return ErrNotImplemented
 }
@@ -2158,6 +2304,7 @@ func (p *MemoryProvider) addEventAction(action *BaseEventAction) error {
 	}
 	action.ID = p.getNextActionID()
 	action.Rules = nil
+	action.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
 	p.dbHandle.actions[action.Name] = action.getACopy()
 	p.dbHandle.actionsNames = append(p.dbHandle.actionsNames, action.Name)
 	sort.Strings(p.dbHandle.actionsNames)
@@ -2178,9 +2325,13 @@ func (p *MemoryProvider) updateEventAction(action *BaseEventAction) error {
 	if err != nil {
 		return fmt.Errorf("event action %s does not exist", action.Name)
 	}
+	if err = checkObjectVersion(action.ExpectedVersion, oldAction.UpdatedAt); err != nil {
+		return err
+	}
 	action.ID = oldAction.ID
 	action.Name = oldAction.Name
 	action.Rules = nil
+	action.UpdatedAt = getNextObjectVersion(oldAction.UpdatedAt)
 	if len(oldAction.Rules) > 0 {
 		var relatedRules []string
 		for _, ruleName := range oldAction.Rules {
@@ -2208,6 +2359,9 @@ func (p *MemoryProvider) deleteEventAction(action BaseEventAction) error {
 	if err != nil {
 		return fmt.Errorf("event action %s does not exist", action.Name)
 	}
+	if err = checkObjectVersion(action.ExpectedVersion, oldAction.UpdatedAt); err != nil {
+		return err
+	}
 	if len(oldAction.Rules) > 0 {
 		return util.NewValidationError(fmt.Sprintf("action %s is referenced, it cannot be removed", oldAction.Name))
 	}
@@ -2369,6 +2523,9 @@ func (p *MemoryProvider) updateEventRule(rule *EventRule) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(rule.ExpectedVersion, oldRule.UpdatedAt); err != nil {
+		return err
+	}
 	for idx := range oldRule.Actions {
 		p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name)
 	}
@@ -2386,7 +2543,7 @@ func (p *MemoryProvider) updateEventRule(rule *EventRule) error {
 	}
 	rule.ID = oldRule.ID
 	rule.CreatedAt = oldRule.CreatedAt
-	rule.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	rule.UpdatedAt = getNextObjectVersion(oldRule.UpdatedAt)
 	sort.Slice(rule.Actions, func(i, j int) bool {
 		return rule.Actions[i].Order < rule.Actions[j].Order
 	})
@@ -2405,6 +2562,9 @@ func (p *MemoryProvider) deleteEventRule(rule EventRule, _ bool) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(rule.ExpectedVersion, oldRule.UpdatedAt); err != nil {
+		return err
+	}
 	if len(oldRule.Actions) > 0 {
 		for idx := range oldRule.Actions {
 			p.removeRuleFromActionMapping(rule.Name, oldRule.Actions[idx].Name)
@@ -2456,6 +2616,115 @@ func (*MemoryProvider) cleanupNodes() error {
 	return ErrNotImplemented
 }
 
+func (*MemoryProvider) addChangeNotification(_ *ChangeNotification) error {
+	return ErrNotImplemented
+}
+
+func (*MemoryProvider) getChangeNotifications(_ int64) ([]ChangeNotification, error) {
+	return nil, ErrNotImplemented
+}
+
+func (*MemoryProvider) cleanupChangeNotifications(_ int64) error {
+	return ErrNotImplemented
+}
+
+func (p *MemoryProvider) addObjectVersion(version *ObjectVersion) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.objectVersionID++
+	version.ID = p.dbHandle.objectVersionID
+	key := string(getObjectVersionKeyPrefix(version.ObjectType, version.ObjectName))
+	versions := append(p.dbHandle.objectHistory[key], *version)
+	if config.ObjectHistory.MaxVersions > 0 && len(versions) > config.ObjectHistory.MaxVersions {
+		versions = versions[len(versions)-config.ObjectHistory.MaxVersions:]
+	}
+	p.dbHandle.objectHistory[key] = versions
+	return nil
+}
+
+func (p *MemoryProvider) getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	versions := make([]ObjectVersion, 0, limit)
+	stored := p.dbHandle.objectHistory[string(getObjectVersionKeyPrefix(objectType, objectName))]
+	for idx := range stored {
+		if len(versions) >= limit {
+			break
+		}
+		if idx < offset {
+			continue
+		}
+		version := stored[idx]
+		if order == OrderDESC {
+			version = stored[len(stored)-1-idx]
+		}
+		version.Data = nil
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (p *MemoryProvider) getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return ObjectVersion{}, errMemoryProviderClosed
+	}
+	for _, version := range p.dbHandle.objectHistory[string(getObjectVersionKeyPrefix(objectType, objectName))] {
+		if version.ID == id {
+			return version, nil
+		}
+	}
+	return ObjectVersion{}, util.NewRecordNotFoundError(fmt.Sprintf("version %d for %s %q not found", id, objectType, objectName))
+}
+
+func (p *MemoryProvider) getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return ObjectVersion{}, errMemoryProviderClosed
+	}
+	stored := p.dbHandle.objectHistory[string(getObjectVersionKeyPrefix(objectType, objectName))]
+	for idx := len(stored) - 1; idx >= 0; idx-- {
+		if stored[idx].ID < id {
+			return stored[idx], nil
+		}
+	}
+	return ObjectVersion{}, util.NewRecordNotFoundError(fmt.Sprintf("no version before %d for %s %q", id, objectType, objectName))
+}
+
+func (p *MemoryProvider) cleanupObjectHistory(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for key, stored := range p.dbHandle.objectHistory {
+		versions := make([]ObjectVersion, 0, len(stored))
+		for _, version := range stored {
+			if version.CreatedAt >= before {
+				versions = append(versions, version)
+			}
+		}
+		if len(versions) == 0 {
+			delete(p.dbHandle.objectHistory, key)
+		} else {
+			p.dbHandle.objectHistory[key] = versions
+		}
+	}
+	return nil
+}
+
+func (*MemoryProvider) restoreTimestamps(_ *objectTimestamps) error {
+	return ErrNotImplemented
+}
+
 func (p *MemoryProvider) roleExists(name string) (Role, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2507,9 +2776,12 @@ func (p *MemoryProvider) updateRole(role *Role) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(role.ExpectedVersion, oldRole.UpdatedAt); err != nil {
+		return err
+	}
 	role.ID = oldRole.ID
 	role.CreatedAt = oldRole.CreatedAt
-	role.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	role.UpdatedAt = getNextObjectVersion(oldRole.UpdatedAt)
 	role.Users = oldRole.Users
 	role.Admins = oldRole.Admins
 	p.dbHandle.roles[role.Name] = role.getACopy()
@@ -2526,6 +2798,9 @@ func (p *MemoryProvider) deleteRole(role Role) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(role.ExpectedVersion, oldRole.UpdatedAt); err != nil {
+		return err
+	}
 	if len(oldRole.Admins) > 0 {
 		return util.NewValidationError(fmt.Sprintf("the role %q is referenced, it cannot be removed", oldRole.Name))
 	}
@@ -2656,8 +2931,11 @@ func (p *MemoryProvider) updateIPListEntry(entry *IPListEntry) error {
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(entry.ExpectedVersion, oldEntry.UpdatedAt); err != nil {
+		return err
+	}
 	entry.CreatedAt = oldEntry.CreatedAt
-	entry.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	entry.UpdatedAt = getNextObjectVersion(oldEntry.UpdatedAt)
 	p.dbHandle.ipListEntries[entry.getKey()] = entry.getACopy()
 	return nil
 }
@@ -2671,10 +2949,13 @@ func (p *MemoryProvider) deleteIPListEntry(entry IPListEntry, _ bool) error {
 	if p.dbHandle.isClosed {
 		return errMemoryProviderClosed
 	}
-	_, err := p.ipListEntryExistsInternal(&entry)
+	oldEntry, err := p.ipListEntryExistsInternal(&entry)
 	if err != nil {
 		return err
 	}
+	if err = checkObjectVersion(entry.ExpectedVersion, oldEntry.UpdatedAt); err != nil {
+		return err
+	}
 	delete(p.dbHandle.ipListEntries, entry.getKey())
 	p.dbHandle.ipListEntriesKeys = make([]string, 0, len(p.dbHandle.ipListEntries))
 	for k := range p.dbHandle.ipListEntries {
@@ -2800,6 +3081,10 @@ func (p *MemoryProvider) getListEntriesForIP(ip string, listType IPListType) ([]
 	return entries, nil
 }
 
+func (p *MemoryProvider) dumpPage(_, _ string, _ int) (BackupData, error) {
+	return BackupData{}, ErrNotImplemented
+}
+
 func (p *MemoryProvider) getConfigs() (Configs, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -2822,6 +3107,10 @@ func (p *MemoryProvider) setConfigs(configs *Configs) error {
 	return nil
 }
 
+func (p *MemoryProvider) restore(plan *restorePlan) error {
+	return plan.applyWithRollback(p)
+}
+
 func (p *MemoryProvider) setFirstDownloadTimestamp(username string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -0,0 +1,145 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	metadataCacheSessionPrefix       = "metadatacache_"
+	defaultMetadataCacheSyncInterval = 10
+	maxMetadataCacheSyncInterval     = 3600
+	// notifications are read again for this time so the ones from nodes with a
+	// slightly different clock are not missed
+	metadataCacheSyncOverlap = time.Minute
+)
+
+var (
+	// identifies this instance in the metadata cache notifications
+	metadataCacheInstanceID = xid.New().String()
+	metadataCacheSync       = metadataCacheSyncStatus{
+		lastSeen: make(map[string]int64),
+	}
+)
+
+type metadataCacheSyncStatus struct {
+	sync.Mutex
+	// last notification for the namespaces changed within the overlap window
+	lastSeen map[string]int64
+	// most recent notification read
+	lastTimestamp int64
+	lastCleanup   time.Time
+}
+
+func validateMetadataCacheSyncInterval() error {
+	if config.MetadataCacheSyncInterval < 0 || config.MetadataCacheSyncInterval > maxMetadataCacheSyncInterval {
+		return fmt.Errorf("invalid metadata cache sync interval %d, valid range: 0-%d", config.MetadataCacheSyncInterval,
+			maxMetadataCacheSyncInterval)
+	}
+	if config.MetadataCacheSyncInterval == 0 {
+		config.MetadataCacheSyncInterval = defaultMetadataCacheSyncInterval
+	}
+	return nil
+}
+
+// syncMetadataCaches notifies the local changes for the cloud storage metadata
+// caches to the other nodes sharing the same data provider and invalidates the
+// local caches changed on other nodes. The cached entries also expire after
+// their TTL, so a missed notification only delays the cache refresh
+func syncMetadataCaches() {
+	metadataCacheSync.Lock()
+	defer metadataCacheSync.Unlock()
+
+	after := metadataCacheSync.lastTimestamp - metadataCacheSyncOverlap.Milliseconds()
+	if metadataCacheSync.lastTimestamp == 0 {
+		// the local caches are empty at startup, older changes are not relevant
+		after = util.GetTimeAsMsSinceEpoch(time.Now().Add(-metadataCacheSyncOverlap))
+	}
+	sessions, err := provider.getSharedSessionsUpdatedAfter(SessionTypeMetadataCache, after)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get metadata cache notifications: %v", err)
+	}
+	for _, session := range sessions {
+		namespace := strings.TrimPrefix(session.Key, metadataCacheSessionPrefix)
+		if session.Timestamp > metadataCacheSync.lastTimestamp {
+			metadataCacheSync.lastTimestamp = session.Timestamp
+		}
+		if session.Timestamp <= metadataCacheSync.lastSeen[namespace] {
+			continue
+		}
+		metadataCacheSync.lastSeen[namespace] = session.Timestamp
+		if getMetadataCacheSessionInstance(session.Data) == metadataCacheInstanceID {
+			continue
+		}
+		if vfs.InvalidateMetadataCache(namespace) {
+			providerLog(logger.LevelDebug, "metadata cache %q invalidated, changed on another node at %s",
+				namespace, util.GetTimeFromMsecSinceEpoch(session.Timestamp))
+		}
+	}
+	// notifications older than the window start are not read anymore
+	for namespace, timestamp := range metadataCacheSync.lastSeen {
+		if timestamp <= after {
+			delete(metadataCacheSync.lastSeen, namespace)
+		}
+	}
+	for namespace := range vfs.GetMetadataCacheChanges() {
+		err := provider.addSharedSession(Session{
+			Key:       metadataCacheSessionPrefix + namespace,
+			Data:      metadataCacheInstanceID,
+			Type:      SessionTypeMetadataCache,
+			Timestamp: util.GetTimeAsMsSinceEpoch(time.Now()),
+		})
+		if err != nil {
+			providerLog(logger.LevelError, "unable to notify changes for metadata cache %q: %v", namespace, err)
+		}
+	}
+	if time.Since(metadataCacheSync.lastCleanup) > 12*time.Hour {
+		metadataCacheSync.lastCleanup = time.Now()
+		// caches not changed for a day are already expired on all nodes
+		err := provider.cleanupSharedSessions(SessionTypeMetadataCache,
+			util.GetTimeAsMsSinceEpoch(time.Now().Add(-24*time.Hour)))
+		if err != nil {
+			providerLog(logger.LevelError, "unable to cleanup metadata cache notifications: %v", err)
+		}
+	}
+}
+
+func removeIdleMetadataCaches() {
+	if removed := vfs.RemoveIdleMetadataCaches(); removed > 0 {
+		providerLog(logger.LevelDebug, "idle metadata caches removed: %d", removed)
+	}
+}
+
+func getMetadataCacheSessionInstance(data any) string {
+	var instanceID string
+	switch v := data.(type) {
+	case []byte:
+		json.Unmarshal(v, &instanceID) //nolint:errcheck
+	case string:
+		json.Unmarshal([]byte(v), &instanceID) //nolint:errcheck
+	}
+	return instanceID
+}
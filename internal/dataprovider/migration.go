@@ -0,0 +1,499 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// keys ignored while computing dump checksums, they are assigned by the data
+// provider and cannot be preserved while copying objects between providers
+var dumpChecksumIgnoredKeys = []string{"id", "last_quota_update"}
+
+// the object hashes are summed modulo 2^256
+var dumpChecksumModulus = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// DumpChecksum defines the number of objects and their checksum for a dump scope
+type DumpChecksum struct {
+	Count    int    `json:"count"`
+	Checksum string `json:"checksum"`
+}
+
+// objectTimestamps defines the timestamps to restore for an object copied
+// from another data provider
+type objectTimestamps struct {
+	ObjectType         string
+	Name               string
+	ListType           IPListType
+	CreatedAt          int64
+	UpdatedAt          int64
+	LastLogin          int64
+	LastUseAt          int64
+	FirstUpload        int64
+	FirstDownload      int64
+	LastPasswordChange int64
+}
+
+// getFields returns the columns, and the JSON keys, to update for the object
+// type and the related values
+func (t *objectTimestamps) getFields() ([]string, []int64) {
+	switch t.ObjectType {
+	case actionObjectUser:
+		return []string{"created_at", "updated_at", "last_login", "first_upload", "first_download", "last_password_change"},
+			[]int64{t.CreatedAt, t.UpdatedAt, t.LastLogin, t.FirstUpload, t.FirstDownload, t.LastPasswordChange}
+	case actionObjectAdmin:
+		return []string{"created_at", "updated_at", "last_login"}, []int64{t.CreatedAt, t.UpdatedAt, t.LastLogin}
+	case actionObjectAPIKey:
+		return []string{"created_at", "updated_at", "last_use_at"}, []int64{t.CreatedAt, t.UpdatedAt, t.LastUseAt}
+	case actionObjectFolder, actionObjectEventAction:
+		// folders and event actions have no creation time
+		return []string{"updated_at"}, []int64{t.UpdatedAt}
+	default:
+		return []string{"created_at", "updated_at"}, []int64{t.CreatedAt, t.UpdatedAt}
+	}
+}
+
+// OpenDatabase creates the data provider using the specified configuration.
+// The database structure is not created or migrated and no background task
+// is started, this is useful to read data from a provider, for example to copy
+// them to another provider
+func OpenDatabase(cnf Config, basePath string) error {
+	config = cnf
+
+	if err := initializeHashingAlgo(&cnf); err != nil {
+		return err
+	}
+	if err := createProvider(basePath); err != nil {
+		return err
+	}
+	return provider.checkAvailability()
+}
+
+// RestoreMigratedMetadata restores the used quota and the timestamps for the
+// objects in the specified data. These objects must be already copied, from
+// another data provider, to the configured one. Shares timestamps are preserved
+// while restoring them, so they are ignored here
+func RestoreMigratedMetadata(data *BackupData) error {
+	for idx := range data.Users {
+		if err := restoreUserMetadata(&data.Users[idx]); err != nil {
+			return err
+		}
+	}
+	timestamps := make([]objectTimestamps, 0, len(data.Folders)+len(data.Groups)+len(data.Admins)+len(data.APIKeys)+
+		len(data.EventActions)+len(data.EventRules)+len(data.Roles)+len(data.IPLists))
+	for idx := range data.Folders {
+		folder := &data.Folders[idx]
+		if err := provider.updateFolderQuota(folder.Name, folder.UsedQuotaFiles, folder.UsedQuotaSize, true); err != nil {
+			return fmt.Errorf("unable to restore the used quota for folder %q: %w", folder.Name, err)
+		}
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectFolder, Name: folder.Name,
+			UpdatedAt: folder.UpdatedAt})
+	}
+	for idx := range data.Groups {
+		g := &data.Groups[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectGroup, Name: g.Name,
+			CreatedAt: g.CreatedAt, UpdatedAt: g.UpdatedAt})
+	}
+	for idx := range data.Admins {
+		a := &data.Admins[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectAdmin, Name: a.Username,
+			CreatedAt: a.CreatedAt, UpdatedAt: a.UpdatedAt, LastLogin: a.LastLogin})
+	}
+	for idx := range data.APIKeys {
+		k := &data.APIKeys[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectAPIKey, Name: k.KeyID,
+			CreatedAt: k.CreatedAt, UpdatedAt: k.UpdatedAt, LastUseAt: k.LastUseAt})
+	}
+	for idx := range data.EventActions {
+		a := &data.EventActions[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectEventAction, Name: a.Name,
+			UpdatedAt: a.UpdatedAt})
+	}
+	for idx := range data.EventRules {
+		r := &data.EventRules[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectEventRule, Name: r.Name,
+			CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt})
+	}
+	for idx := range data.Roles {
+		r := &data.Roles[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectRole, Name: r.Name,
+			CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt})
+	}
+	for idx := range data.IPLists {
+		e := &data.IPLists[idx]
+		timestamps = append(timestamps, objectTimestamps{ObjectType: actionObjectIPListEntry, Name: e.IPOrNet,
+			ListType: e.Type, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt})
+	}
+	for idx := range timestamps {
+		if err := provider.restoreTimestamps(&timestamps[idx]); err != nil {
+			return fmt.Errorf("unable to restore the timestamps for %s %q: %w", timestamps[idx].ObjectType,
+				timestamps[idx].Name, err)
+		}
+	}
+	return nil
+}
+
+func restoreUserMetadata(user *User) error {
+	if err := provider.updateQuota(user.Username, user.UsedQuotaFiles, user.UsedQuotaSize, true); err != nil {
+		return fmt.Errorf("unable to restore the used quota for user %q: %w", user.Username, err)
+	}
+	err := provider.updateTransferQuota(user.Username, user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, true)
+	if err != nil {
+		return fmt.Errorf("unable to restore the used transfer quota for user %q: %w", user.Username, err)
+	}
+	err = provider.restoreTimestamps(&objectTimestamps{
+		ObjectType:         actionObjectUser,
+		Name:               user.Username,
+		CreatedAt:          user.CreatedAt,
+		UpdatedAt:          user.UpdatedAt,
+		LastLogin:          user.LastLogin,
+		FirstUpload:        user.FirstUpload,
+		FirstDownload:      user.FirstDownload,
+		LastPasswordChange: user.LastPasswordChange,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to restore the timestamps for user %q: %w", user.Username, err)
+	}
+	return nil
+}
+
+// DumpDataPage returns up to limit objects for the specified scope, ordered by their
+// key and with a key greater than the specified one, an empty key means from the start.
+// It also returns the key of the last returned object, to use to get the next page,
+// an empty key means that there are no more objects.
+// The users, folders, groups, admins, API keys, shares and IP lists scopes are supported,
+// the IP list entries are returned even if they exceed the limit for the regular dumps
+func DumpDataPage(scope, after string, limit int) (BackupData, string, error) {
+	data, err := provider.dumpPage(scope, after, limit)
+	if err != nil {
+		return data, "", err
+	}
+	var keys []string
+	switch scope {
+	case DumpScopeUsers:
+		for idx := range data.Users {
+			keys = append(keys, data.Users[idx].Username)
+		}
+	case DumpScopeFolders:
+		for idx := range data.Folders {
+			keys = append(keys, data.Folders[idx].Name)
+		}
+	case DumpScopeGroups:
+		for idx := range data.Groups {
+			keys = append(keys, data.Groups[idx].Name)
+		}
+	case DumpScopeAdmins:
+		for idx := range data.Admins {
+			keys = append(keys, data.Admins[idx].Username)
+		}
+	case DumpScopeAPIKeys:
+		for idx := range data.APIKeys {
+			keys = append(keys, data.APIKeys[idx].KeyID)
+		}
+	case DumpScopeShares:
+		for idx := range data.Shares {
+			keys = append(keys, data.Shares[idx].ShareID)
+		}
+	case DumpScopeIPLists:
+		for idx := range data.IPLists {
+			keys = append(keys, data.IPLists[idx].getKey())
+		}
+	}
+	if len(keys) < limit {
+		return data, "", nil
+	}
+	return data, keys[len(keys)-1], nil
+}
+
+// getIPListEntryFromKey returns the list type and the IP or network from
+// a key returned by the getKey method
+func getIPListEntryFromKey(key string) (IPListType, string) {
+	listType, ipOrNet, ok := strings.Cut(key, "_")
+	if !ok {
+		return 0, ""
+	}
+	val, err := strconv.Atoi(listType)
+	if err != nil {
+		return 0, ""
+	}
+	return IPListType(val), ipOrNet
+}
+
+// DumpChecksums computes the number of objects and a checksum for each dump scope.
+// Checksums do not depend on the objects order and ignore the fields assigned
+// by the data provider, such as the IDs, so they can be used to compare the
+// same data read from different providers. The objects can be added in batches
+type DumpChecksums struct {
+	counts map[string]int
+	sums   map[string]*big.Int
+}
+
+// NewDumpChecksums returns a new DumpChecksums
+func NewDumpChecksums() *DumpChecksums {
+	return &DumpChecksums{
+		counts: make(map[string]int),
+		sums:   make(map[string]*big.Int),
+	}
+}
+
+// Get returns the number of objects and the checksum for each dump scope
+func (c *DumpChecksums) Get() map[string]DumpChecksum {
+	scopes := []string{DumpScopeUsers, DumpScopeFolders, DumpScopeGroups, DumpScopeAdmins, DumpScopeAPIKeys,
+		DumpScopeShares, DumpScopeActions, DumpScopeRules, DumpScopeRoles, DumpScopeIPLists, DumpScopeConfigs}
+	result := make(map[string]DumpChecksum)
+	for _, scope := range scopes {
+		checksum := make([]byte, sha256.Size)
+		if sum, ok := c.sums[scope]; ok {
+			sum.FillBytes(checksum)
+		}
+		result[scope] = DumpChecksum{
+			Count:    c.counts[scope],
+			Checksum: hex.EncodeToString(checksum),
+		}
+	}
+	return result
+}
+
+// Add adds the objects within the specified dump
+func (c *DumpChecksums) Add(data *BackupData) error {
+	// the inverse relations are not included in all the providers dumps, they are
+	// verified using the objects that define the relations
+	users := make([]User, 0, len(data.Users))
+	for idx := range data.Users {
+		user := data.Users[idx]
+		user.VirtualFolders = getVirtualFoldersWithoutRelations(user.VirtualFolders)
+		users = append(users, user)
+	}
+	if err := addDumpChecksums(c, DumpScopeUsers, users); err != nil {
+		return err
+	}
+	folders := make([]vfs.BaseVirtualFolder, 0, len(data.Folders))
+	for idx := range data.Folders {
+		folder := data.Folders[idx]
+		folder.Users = nil
+		folder.Groups = nil
+		folders = append(folders, folder)
+	}
+	if err := addDumpChecksums(c, DumpScopeFolders, folders); err != nil {
+		return err
+	}
+	groups := make([]Group, 0, len(data.Groups))
+	for idx := range data.Groups {
+		group := data.Groups[idx]
+		group.Users = nil
+		group.Admins = nil
+		group.VirtualFolders = getVirtualFoldersWithoutRelations(group.VirtualFolders)
+		groups = append(groups, group)
+	}
+	if err := addDumpChecksums(c, DumpScopeGroups, groups); err != nil {
+		return err
+	}
+	if err := addDumpChecksums(c, DumpScopeAdmins, data.Admins); err != nil {
+		return err
+	}
+	if err := addDumpChecksums(c, DumpScopeAPIKeys, data.APIKeys); err != nil {
+		return err
+	}
+	if err := addDumpChecksums(c, DumpScopeShares, data.Shares); err != nil {
+		return err
+	}
+	actions := make([]BaseEventAction, 0, len(data.EventActions))
+	for idx := range data.EventActions {
+		action := data.EventActions[idx]
+		action.Rules = nil
+		actions = append(actions, action)
+	}
+	if err := addDumpChecksums(c, DumpScopeActions, actions); err != nil {
+		return err
+	}
+	rules := make([]EventRule, 0, len(data.EventRules))
+	for idx := range data.EventRules {
+		rule := data.EventRules[idx]
+		rule.Actions = make([]EventAction, 0, len(data.EventRules[idx].Actions))
+		for _, action := range data.EventRules[idx].Actions {
+			// the referenced actions are compared within their own scope and
+			// some providers do not include the update time here
+			action.Rules = nil
+			action.UpdatedAt = 0
+			rule.Actions = append(rule.Actions, action)
+		}
+		rules = append(rules, rule)
+	}
+	if err := addDumpChecksums(c, DumpScopeRules, rules); err != nil {
+		return err
+	}
+	roles := make([]Role, 0, len(data.Roles))
+	for idx := range data.Roles {
+		role := data.Roles[idx]
+		role.Users = nil
+		role.Admins = nil
+		roles = append(roles, role)
+	}
+	if err := addDumpChecksums(c, DumpScopeRoles, roles); err != nil {
+		return err
+	}
+	if err := addDumpChecksums(c, DumpScopeIPLists, data.IPLists); err != nil {
+		return err
+	}
+	var configs []Configs
+	if data.Configs != nil {
+		// configs are updated, not restored, so the update time cannot be preserved
+		cfg := data.Configs.getACopy()
+		cfg.UpdatedAt = 0
+		configs = append(configs, cfg)
+	}
+	return addDumpChecksums(c, DumpScopeConfigs, configs)
+}
+
+// GetDumpChecksums returns the number of objects and a checksum for each dump scope
+func GetDumpChecksums(data *BackupData) (map[string]DumpChecksum, error) {
+	c := NewDumpChecksums()
+	if err := c.Add(data); err != nil {
+		return nil, err
+	}
+	return c.Get(), nil
+}
+
+func getVirtualFoldersWithoutRelations(folders []vfs.VirtualFolder) []vfs.VirtualFolder {
+	result := make([]vfs.VirtualFolder, 0, len(folders))
+	for _, folder := range folders {
+		// the referenced folders are compared within their own scope and
+		// some providers do not include the update time here
+		folder.Users = nil
+		folder.Groups = nil
+		folder.UpdatedAt = 0
+		result = append(result, folder)
+	}
+	return result
+}
+
+// addDumpChecksums adds the hashes of the specified objects to the scope checksum.
+// The hashes are added modulo 2^256 so the result does not depend on the order
+func addDumpChecksums[T any](c *DumpChecksums, scope string, objects []T) error {
+	sum, ok := c.sums[scope]
+	if !ok {
+		sum = new(big.Int)
+		c.sums[scope] = sum
+	}
+	for idx := range objects {
+		h, err := getDumpObjectHash(&objects[idx])
+		if err != nil {
+			return err
+		}
+		sum.Add(sum, new(big.Int).SetBytes(h))
+	}
+	sum.Mod(sum, dumpChecksumModulus)
+	c.counts[scope] += len(objects)
+	return nil
+}
+
+func getDumpObjectHash(object any) ([]byte, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	normalized = normalizeDumpValue(normalized)
+	// map keys are sorted while marshaling so the result is deterministic
+	data, err = json.Marshal(normalized)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// normalizeDumpValue removes the keys assigned by the data provider and the
+// empty values, different providers could return an empty list or a nil one,
+// and sorts the lists, the relations could be returned in a different order
+func normalizeDumpValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, val := range v {
+			val = normalizeDumpValue(val)
+			if val == nil || util.Contains(dumpChecksumIgnoredKeys, key) {
+				delete(v, key)
+				continue
+			}
+			v[key] = val
+		}
+		if len(v) == 0 {
+			return nil
+		}
+		return v
+	case []any:
+		items := make([]any, 0, len(v))
+		keys := make(map[int]string)
+		for _, item := range v {
+			item = normalizeDumpValue(item)
+			if item == nil {
+				continue
+			}
+			data, err := json.Marshal(item)
+			if err != nil {
+				providerLog(logger.LevelError, "unable to marshal dump value: %v", err)
+				return v
+			}
+			keys[len(items)] = string(data)
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		sorted := make([]int, len(items))
+		for idx := range sorted {
+			sorted[idx] = idx
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return keys[sorted[i]] < keys[sorted[j]]
+		})
+		result := make([]any, 0, len(items))
+		for _, idx := range sorted {
+			result = append(result, items[idx])
+		}
+		return result
+	case string:
+		if v == "" {
+			return nil
+		}
+		return v
+	case float64:
+		if v == 0 {
+			return nil
+		}
+		return v
+	case bool:
+		if !v {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -60,6 +60,8 @@ const (
 		"DROP TABLE IF EXISTS `{{roles}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{ip_lists}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{configs}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{change_notifications}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{object_history}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
 	mysqlInitialSQL = "CREATE TABLE `{{schema_version}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);" +
 		"CREATE TABLE `{{admins}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `username` varchar(255) NOT NULL UNIQUE, " +
@@ -206,6 +208,21 @@ const (
 	mysqlV28SQL     = "CREATE TABLE `{{configs}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `configs` longtext NOT NULL);" +
 		"INSERT INTO {{configs}} (configs) VALUES ('{}');"
 	mysqlV28DownSQL = "DROP TABLE `{{configs}}` CASCADE;"
+	mysqlV29SQL     = "CREATE TABLE `{{change_notifications}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`instance` varchar(50) NOT NULL, `payload` longtext NOT NULL, `created_at` bigint NOT NULL);" +
+		"CREATE INDEX `{{prefix}}change_notifications_created_at_idx` ON `{{change_notifications}}` (`created_at`);"
+	mysqlV29DownSQL = "DROP TABLE `{{change_notifications}}` CASCADE;"
+	mysqlV30SQL     = "CREATE TABLE `{{object_history}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`object_type` varchar(50) NOT NULL, `object_name` varchar(255) NOT NULL, `action` varchar(20) NOT NULL, " +
+		"`executor` varchar(255) NOT NULL, `ip` varchar(50) NOT NULL, `role` varchar(255) NOT NULL, " +
+		"`data` longtext NOT NULL, `created_at` bigint NOT NULL);" +
+		"CREATE INDEX `{{prefix}}object_history_object_idx` ON `{{object_history}}` (`object_type`, `object_name`);" +
+		"CREATE INDEX `{{prefix}}object_history_created_at_idx` ON `{{object_history}}` (`created_at`);"
+	mysqlV30DownSQL = "DROP TABLE `{{object_history}}` CASCADE;"
+	mysqlV31SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `updated_at` bigint DEFAULT 0 NOT NULL; " +
+		"ALTER TABLE `{{events_actions}}` ADD COLUMN `updated_at` bigint DEFAULT 0 NOT NULL; "
+	mysqlV31DownSQL = "ALTER TABLE `{{events_actions}}` DROP COLUMN `updated_at`; " +
+		"ALTER TABLE `{{folders}}` DROP COLUMN `updated_at`; "
 )
 
 // MySQLProvider defines the auth provider for MySQL/MariaDB database
@@ -243,8 +260,9 @@ func initializeMySQLProvider() error {
 	dbHandle.SetConnMaxLifetime(240 * time.Second)
 	dbHandle.SetConnMaxIdleTime(120 * time.Second)
 	provider = &MySQLProvider{dbHandle: dbHandle}
-	return nil
+	return initializeSQLReadReplicas("mysql")
 }
+
 func getMySQLConnectionString(redactedPwd bool) (string, error) {
 	var connectionString string
 	if config.ConnectionString == "" {
@@ -306,6 +324,7 @@ func registerMySQLCustomTLSConfig() error {
 }
 
 func (p *MySQLProvider) checkAvailability() error {
+	readReplicas.checkAvailability()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
@@ -317,8 +336,8 @@ func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCer
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, ip, isSSHCert, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -350,21 +369,38 @@ func (p *MySQLProvider) userExists(username, role string) (User, error) {
 }
 
 func (p *MySQLProvider) addUser(user *User) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonAddUser(user, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateUser(user *User) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonUpdateUser(user, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateUsers(users []*User) error {
+	defer readReplicas.onUsersWrite(users)
+	return sqlCommonUpdateUsers(users, p.dbHandle)
+}
+
 func (p *MySQLProvider) deleteUser(user User, softDelete bool) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateUserPassword(username, password string) error {
+	defer readReplicas.onWrite(actionObjectUser, username)
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateUserPublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdateUserPublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	return sqlCommonUpdateUserCryptFsReEncryption(username, expectedRunID, state, p.dbHandle)
+}
+
 func (p *MySQLProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -373,8 +409,10 @@ func (p *MySQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *MySQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *MySQLProvider) getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error) {
+	return readFromReplica(readReplicas, p.dbHandle, usersReplicaKeys, func(dbHandle sqlQuerier) ([]User, error) {
+		return sqlCommonGetUsers(limit, offset, order, filters, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -385,8 +423,10 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *MySQLProvider) getFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
+	return readFromReplica(readReplicas, p.dbHandle, foldersReplicaKeys, func(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+		return sqlCommonGetFolders(limit, offset, order, minimal, filters, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -396,14 +436,22 @@ func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 }
 
 func (p *MySQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonAddFolder(folder, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonUpdateFolder(folder, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateFolders(folders []*vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
+	return sqlCommonUpdateFolders(folders, p.dbHandle)
+}
+
 func (p *MySQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonDeleteFolder(folder, p.dbHandle)
 }
 
@@ -415,8 +463,10 @@ func (p *MySQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *MySQLProvider) getGroups(limit, offset int, order string, minimal bool, filters SearchFilters) ([]Group, error) {
+	return readFromReplica(readReplicas, p.dbHandle, groupsReplicaKeys, func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroups(limit, offset, order, minimal, filters, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -432,14 +482,22 @@ func (p *MySQLProvider) groupExists(name string) (Group, error) {
 }
 
 func (p *MySQLProvider) addGroup(group *Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonAddGroup(group, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateGroup(group *Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonUpdateGroup(group, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateGroups(groups []*Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
+	return sqlCommonUpdateGroups(groups, p.dbHandle)
+}
+
 func (p *MySQLProvider) deleteGroup(group Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonDeleteGroup(group, p.dbHandle)
 }
 
@@ -452,14 +510,17 @@ func (p *MySQLProvider) adminExists(username string) (Admin, error) {
 }
 
 func (p *MySQLProvider) addAdmin(admin *Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonAddAdmin(admin, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateAdmin(admin *Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonUpdateAdmin(admin, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteAdmin(admin Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
@@ -508,19 +569,24 @@ func (p *MySQLProvider) shareExists(shareID, username string) (Share, error) {
 }
 
 func (p *MySQLProvider) addShare(share *Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonAddShare(share, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateShare(share *Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonUpdateShare(share, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteShare(share Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonDeleteShare(share, p.dbHandle)
 }
 
-func (p *MySQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+func (p *MySQLProvider) getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error) {
+	return readFromReplica(readReplicas, p.dbHandle, sharesReplicaKeys, func(dbHandle sqlQuerier) ([]Share, error) {
+		return sqlCommonGetShares(limit, offset, order, username, filters, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpShares() ([]Share, error) {
@@ -595,12 +661,18 @@ func (p *MySQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *MySQLProvider) getSharedSessionsUpdatedAfter(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessionsUpdatedAfter(sessionType, after, p.dbHandle)
+}
+
 func (p *MySQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *MySQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, eventActionsReplicaKeys, func(dbHandle sqlQuerier) ([]BaseEventAction, error) {
+		return sqlCommonGetEventActions(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -612,19 +684,24 @@ func (p *MySQLProvider) eventActionExists(name string) (BaseEventAction, error)
 }
 
 func (p *MySQLProvider) addEventAction(action *BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonAddEventAction(action, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateEventAction(action *BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonUpdateEventAction(action, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteEventAction(action BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonDeleteEventAction(action, p.dbHandle)
 }
 
 func (p *MySQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, eventRulesReplicaKeys, func(dbHandle sqlQuerier) ([]EventRule, error) {
+		return sqlCommonGetEventRules(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -632,7 +709,7 @@ func (p *MySQLProvider) dumpEventRules() ([]EventRule, error) {
 }
 
 func (p *MySQLProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
-	return sqlCommonGetRecentlyUpdatedRules(after, p.dbHandle)
+	return sqlCommonGetRecentlyUpdatedRulesWithReplicas(after, p.dbHandle)
 }
 
 func (p *MySQLProvider) eventRuleExists(name string) (EventRule, error) {
@@ -640,14 +717,17 @@ func (p *MySQLProvider) eventRuleExists(name string) (EventRule, error) {
 }
 
 func (p *MySQLProvider) addEventRule(rule *EventRule) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonAddEventRule(rule, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateEventRule(rule *EventRule) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonUpdateEventRule(rule, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteEventRule(rule EventRule, softDelete bool) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonDeleteEventRule(rule, softDelete, p.dbHandle)
 }
 
@@ -687,24 +767,65 @@ func (p *MySQLProvider) cleanupNodes() error {
 	return sqlCommonCleanupNodes(p.dbHandle)
 }
 
+func (p *MySQLProvider) addChangeNotification(notification *ChangeNotification) error {
+	return sqlCommonAddChangeNotification(notification, p.dbHandle)
+}
+
+func (p *MySQLProvider) getChangeNotifications(after int64) ([]ChangeNotification, error) {
+	return sqlCommonGetChangeNotifications(after, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupChangeNotifications(before int64) error {
+	return sqlCommonCleanupChangeNotifications(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) restoreTimestamps(timestamps *objectTimestamps) error {
+	return sqlCommonRestoreTimestamps(timestamps, p.dbHandle)
+}
+
+func (p *MySQLProvider) addObjectVersion(version *ObjectVersion) error {
+	return sqlCommonAddObjectVersion(version, p.dbHandle)
+}
+
+func (p *MySQLProvider) getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error) {
+	return sqlCommonGetObjectVersions(objectType, objectName, limit, offset, order, p.dbHandle)
+}
+
+func (p *MySQLProvider) getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, false, p.dbHandle)
+}
+
+func (p *MySQLProvider) getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, true, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupObjectHistory(before int64) error {
+	return sqlCommonCleanupObjectHistory(before, p.dbHandle)
+}
+
 func (p *MySQLProvider) roleExists(name string) (Role, error) {
 	return sqlCommonGetRoleByName(name, p.dbHandle)
 }
 
 func (p *MySQLProvider) addRole(role *Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonAddRole(role, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateRole(role *Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonUpdateRole(role, p.dbHandle)
 }
 
 func (p *MySQLProvider) deleteRole(role Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonDeleteRole(role, p.dbHandle)
 }
 
 func (p *MySQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, rolesReplicaKeys, func(dbHandle sqlQuerier) ([]Role, error) {
+		return sqlCommonGetRoles(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *MySQLProvider) dumpRoles() ([]Role, error) {
@@ -747,6 +868,10 @@ func (p *MySQLProvider) getListEntriesForIP(ip string, listType IPListType) ([]I
 	return sqlCommonGetListEntriesForIP(ip, listType, p.dbHandle)
 }
 
+func (p *MySQLProvider) dumpPage(scope, after string, limit int) (BackupData, error) {
+	return sqlCommonDumpPage(scope, after, limit, p.dbHandle)
+}
+
 func (p *MySQLProvider) getConfigs() (Configs, error) {
 	return sqlCommonGetConfigs(p.dbHandle)
 }
@@ -755,6 +880,11 @@ func (p *MySQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *MySQLProvider) restore(plan *restorePlan) error {
+	defer readReplicas.onRestore(plan.results)
+	return sqlCommonRestore(plan, p.dbHandle)
+}
+
 func (p *MySQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -812,6 +942,12 @@ func (p *MySQLProvider) migrateDatabase() error { //nolint:dupl
 		return updateMySQLDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updateMySQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateMySQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateMySQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateMySQLDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -844,6 +980,12 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 		return downgradeMySQLDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradeMySQLDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradeMySQLDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradeMySQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeMySQLDatabaseFromV31(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -883,7 +1025,28 @@ func updateMySQLDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updateMySQLDatabaseFromV27(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom27To28(dbHandle)
+	if err := updateMySQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV28(dbHandle)
+}
+
+func updateMySQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV29(dbHandle)
+}
+
+func updateMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV30(dbHandle)
+}
+
+func updateMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom30To31(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV24(dbHandle *sql.DB) error {
@@ -918,6 +1081,27 @@ func downgradeMySQLDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFromV27(dbHandle)
 }
 
+func downgradeMySQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV28(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV29(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV30(dbHandle)
+}
+
 func updateMySQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -957,6 +1141,30 @@ func updateMySQLDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, true)
 }
 
+func updateMySQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(mysqlV29SQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, true)
+}
+
+func updateMySQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(mysqlV30SQL, "{{object_history}}", sqlTableObjectHistory)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, true)
+}
+
+func updateMySQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(mysqlV31SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 31, true)
+}
+
 func downgradeMySQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -994,3 +1202,25 @@ func downgradeMySQLDatabaseFrom28To27(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(mysqlV28DownSQL, "{{configs}}", sqlTableConfigs)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 27, false)
 }
+
+func downgradeMySQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(mysqlV29DownSQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 28, false)
+}
+
+func downgradeMySQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(mysqlV30DownSQL, "{{object_history}}", sqlTableObjectHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 29, false)
+}
+
+func downgradeMySQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(mysqlV31DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 30, false)
+}
@@ -263,6 +263,36 @@ func (n *Node) SendDeleteRequest(username, role, relativeURL string) error {
 	return nil
 }
 
+// SendPostRequest sends an HTTP POST request to this node.
+// The body is serialized as JSON
+func (n *Node) SendPostRequest(username, role, relativeURL string, body any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeReqTimeout)
+	defer cancel()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := n.prepareRequest(ctx, username, role, relativeURL, http.MethodPost, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := httpclient.GetHTTPClient()
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to send HTTP POST to node %s: %w", n.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // AuthenticateNodeToken check the validity of the provided token
 func AuthenticateNodeToken(token string) (string, string, error) {
 	if currentNode == nil {
@@ -21,11 +21,13 @@ import (
 	"context"
 	"crypto/x509"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -60,6 +62,8 @@ DROP TABLE IF EXISTS "{{nodes}}" CASCADE;
 DROP TABLE IF EXISTS "{{roles}}" CASCADE;
 DROP TABLE IF EXISTS "{{ip_lists}}" CASCADE;
 DROP TABLE IF EXISTS "{{configs}}" CASCADE;
+DROP TABLE IF EXISTS "{{change_notifications}}" CASCADE;
+DROP TABLE IF EXISTS "{{object_history}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
 `
 	pgsqlInitial = `CREATE TABLE "{{schema_version}}" ("id" serial NOT NULL PRIMARY KEY, "version" integer NOT NULL);
@@ -223,6 +227,25 @@ CREATE INDEX "{{prefix}}ip_lists_first_last_idx" ON "{{ip_lists}}" ("first", "la
 INSERT INTO {{configs}} (configs) VALUES ('{}');
 `
 	pgsqlV28DownSQL = `DROP TABLE "{{configs}}" CASCADE;`
+	pgsqlV29SQL     = `CREATE TABLE "{{change_notifications}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"instance" varchar(50) NOT NULL, "payload" text NOT NULL, "created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}change_notifications_created_at_idx" ON "{{change_notifications}}" ("created_at");
+`
+	pgsqlV29DownSQL = `DROP TABLE "{{change_notifications}}" CASCADE;`
+	pgsqlV30SQL     = `CREATE TABLE "{{object_history}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL, "action" varchar(20) NOT NULL,
+"executor" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "role" varchar(255) NOT NULL, "data" text NOT NULL,
+"created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}object_history_object_idx" ON "{{object_history}}" ("object_type", "object_name");
+CREATE INDEX "{{prefix}}object_history_created_at_idx" ON "{{object_history}}" ("created_at");
+`
+	pgsqlV30DownSQL = `DROP TABLE "{{object_history}}" CASCADE;`
+	pgsqlV31SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{events_actions}}" ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL;
+`
+	pgsqlV31DownSQL = `ALTER TABLE "{{events_actions}}" DROP COLUMN "updated_at" CASCADE;
+ALTER TABLE "{{folders}}" DROP COLUMN "updated_at" CASCADE;
+`
 )
 
 // PGSQLProvider defines the auth provider for PostgreSQL database
@@ -264,7 +287,7 @@ func initializePGSQLProvider() error {
 	dbHandle.SetConnMaxLifetime(240 * time.Second)
 	dbHandle.SetConnMaxIdleTime(120 * time.Second)
 	provider = &PGSQLProvider{dbHandle: dbHandle}
-	return nil
+	return initializeSQLReadReplicas("pgx")
 }
 
 func getPGSQLHostsAndPorts(configHost string, configPort int) (string, string) {
@@ -321,6 +344,7 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 }
 
 func (p *PGSQLProvider) checkAvailability() error {
+	readReplicas.checkAvailability()
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
@@ -332,8 +356,8 @@ func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCer
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, ip, isSSHCert, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -365,21 +389,38 @@ func (p *PGSQLProvider) userExists(username, role string) (User, error) {
 }
 
 func (p *PGSQLProvider) addUser(user *User) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonAddUser(user, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateUser(user *User) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonUpdateUser(user, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateUsers(users []*User) error {
+	defer readReplicas.onUsersWrite(users)
+	return sqlCommonUpdateUsers(users, p.dbHandle)
+}
+
 func (p *PGSQLProvider) deleteUser(user User, softDelete bool) error {
+	defer readReplicas.onWrite(actionObjectUser, user.Username)
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateUserPassword(username, password string) error {
+	defer readReplicas.onWrite(actionObjectUser, username)
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateUserPublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdateUserPublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	return sqlCommonUpdateUserCryptFsReEncryption(username, expectedRunID, state, p.dbHandle)
+}
+
 func (p *PGSQLProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -388,8 +429,10 @@ func (p *PGSQLProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *PGSQLProvider) getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error) {
+	return readFromReplica(readReplicas, p.dbHandle, usersReplicaKeys, func(dbHandle sqlQuerier) ([]User, error) {
+		return sqlCommonGetUsers(limit, offset, order, filters, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -400,8 +443,10 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *PGSQLProvider) getFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
+	return readFromReplica(readReplicas, p.dbHandle, foldersReplicaKeys, func(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+		return sqlCommonGetFolders(limit, offset, order, minimal, filters, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -411,14 +456,22 @@ func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, err
 }
 
 func (p *PGSQLProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonAddFolder(folder, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonUpdateFolder(folder, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateFolders(folders []*vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
+	return sqlCommonUpdateFolders(folders, p.dbHandle)
+}
+
 func (p *PGSQLProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
+	defer readReplicas.onWrite(actionObjectFolder)
 	return sqlCommonDeleteFolder(folder, p.dbHandle)
 }
 
@@ -430,8 +483,10 @@ func (p *PGSQLProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *PGSQLProvider) getGroups(limit, offset int, order string, minimal bool, filters SearchFilters) ([]Group, error) {
+	return readFromReplica(readReplicas, p.dbHandle, groupsReplicaKeys, func(dbHandle sqlQuerier) ([]Group, error) {
+		return sqlCommonGetGroups(limit, offset, order, minimal, filters, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -447,14 +502,22 @@ func (p *PGSQLProvider) groupExists(name string) (Group, error) {
 }
 
 func (p *PGSQLProvider) addGroup(group *Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonAddGroup(group, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateGroup(group *Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonUpdateGroup(group, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateGroups(groups []*Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
+	return sqlCommonUpdateGroups(groups, p.dbHandle)
+}
+
 func (p *PGSQLProvider) deleteGroup(group Group) error {
+	defer readReplicas.onWrite(actionObjectGroup)
 	return sqlCommonDeleteGroup(group, p.dbHandle)
 }
 
@@ -467,14 +530,17 @@ func (p *PGSQLProvider) adminExists(username string) (Admin, error) {
 }
 
 func (p *PGSQLProvider) addAdmin(admin *Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonAddAdmin(admin, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateAdmin(admin *Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonUpdateAdmin(admin, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteAdmin(admin Admin) error {
+	defer readReplicas.onWrite(actionObjectAdmin)
 	return sqlCommonDeleteAdmin(admin, p.dbHandle)
 }
 
@@ -523,19 +589,24 @@ func (p *PGSQLProvider) shareExists(shareID, username string) (Share, error) {
 }
 
 func (p *PGSQLProvider) addShare(share *Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonAddShare(share, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateShare(share *Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonUpdateShare(share, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteShare(share Share) error {
+	defer readReplicas.onWrite(actionObjectShare)
 	return sqlCommonDeleteShare(share, p.dbHandle)
 }
 
-func (p *PGSQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+func (p *PGSQLProvider) getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error) {
+	return readFromReplica(readReplicas, p.dbHandle, sharesReplicaKeys, func(dbHandle sqlQuerier) ([]Share, error) {
+		return sqlCommonGetShares(limit, offset, order, username, filters, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpShares() ([]Share, error) {
@@ -610,12 +681,18 @@ func (p *PGSQLProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getSharedSessionsUpdatedAfter(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessionsUpdatedAfter(sessionType, after, p.dbHandle)
+}
+
 func (p *PGSQLProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getEventActions(limit, offset int, order string, minimal bool) ([]BaseEventAction, error) {
-	return sqlCommonGetEventActions(limit, offset, order, minimal, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, eventActionsReplicaKeys, func(dbHandle sqlQuerier) ([]BaseEventAction, error) {
+		return sqlCommonGetEventActions(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpEventActions() ([]BaseEventAction, error) {
@@ -627,19 +704,24 @@ func (p *PGSQLProvider) eventActionExists(name string) (BaseEventAction, error)
 }
 
 func (p *PGSQLProvider) addEventAction(action *BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonAddEventAction(action, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateEventAction(action *BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonUpdateEventAction(action, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteEventAction(action BaseEventAction) error {
+	defer readReplicas.onWrite(actionObjectEventAction)
 	return sqlCommonDeleteEventAction(action, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getEventRules(limit, offset int, order string) ([]EventRule, error) {
-	return sqlCommonGetEventRules(limit, offset, order, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, eventRulesReplicaKeys, func(dbHandle sqlQuerier) ([]EventRule, error) {
+		return sqlCommonGetEventRules(limit, offset, order, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpEventRules() ([]EventRule, error) {
@@ -647,7 +729,7 @@ func (p *PGSQLProvider) dumpEventRules() ([]EventRule, error) {
 }
 
 func (p *PGSQLProvider) getRecentlyUpdatedRules(after int64) ([]EventRule, error) {
-	return sqlCommonGetRecentlyUpdatedRules(after, p.dbHandle)
+	return sqlCommonGetRecentlyUpdatedRulesWithReplicas(after, p.dbHandle)
 }
 
 func (p *PGSQLProvider) eventRuleExists(name string) (EventRule, error) {
@@ -655,14 +737,17 @@ func (p *PGSQLProvider) eventRuleExists(name string) (EventRule, error) {
 }
 
 func (p *PGSQLProvider) addEventRule(rule *EventRule) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonAddEventRule(rule, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateEventRule(rule *EventRule) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonUpdateEventRule(rule, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteEventRule(rule EventRule, softDelete bool) error {
+	defer readReplicas.onWrite(actionObjectEventRule)
 	return sqlCommonDeleteEventRule(rule, softDelete, p.dbHandle)
 }
 
@@ -702,24 +787,65 @@ func (p *PGSQLProvider) cleanupNodes() error {
 	return sqlCommonCleanupNodes(p.dbHandle)
 }
 
+func (p *PGSQLProvider) addChangeNotification(notification *ChangeNotification) error {
+	return sqlCommonAddChangeNotification(notification, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getChangeNotifications(after int64) ([]ChangeNotification, error) {
+	return sqlCommonGetChangeNotifications(after, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupChangeNotifications(before int64) error {
+	return sqlCommonCleanupChangeNotifications(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) restoreTimestamps(timestamps *objectTimestamps) error {
+	return sqlCommonRestoreTimestamps(timestamps, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addObjectVersion(version *ObjectVersion) error {
+	return sqlCommonAddObjectVersion(version, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error) {
+	return sqlCommonGetObjectVersions(objectType, objectName, limit, offset, order, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, false, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, true, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupObjectHistory(before int64) error {
+	return sqlCommonCleanupObjectHistory(before, p.dbHandle)
+}
+
 func (p *PGSQLProvider) roleExists(name string) (Role, error) {
 	return sqlCommonGetRoleByName(name, p.dbHandle)
 }
 
 func (p *PGSQLProvider) addRole(role *Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonAddRole(role, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateRole(role *Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonUpdateRole(role, p.dbHandle)
 }
 
 func (p *PGSQLProvider) deleteRole(role Role) error {
+	defer readReplicas.onWrite(actionObjectRole)
 	return sqlCommonDeleteRole(role, p.dbHandle)
 }
 
 func (p *PGSQLProvider) getRoles(limit int, offset int, order string, minimal bool) ([]Role, error) {
-	return sqlCommonGetRoles(limit, offset, order, minimal, p.dbHandle)
+	return readFromReplica(readReplicas, p.dbHandle, rolesReplicaKeys, func(dbHandle sqlQuerier) ([]Role, error) {
+		return sqlCommonGetRoles(limit, offset, order, minimal, dbHandle)
+	})
 }
 
 func (p *PGSQLProvider) dumpRoles() ([]Role, error) {
@@ -762,6 +888,10 @@ func (p *PGSQLProvider) getListEntriesForIP(ip string, listType IPListType) ([]I
 	return sqlCommonGetListEntriesForIP(ip, listType, p.dbHandle)
 }
 
+func (p *PGSQLProvider) dumpPage(scope, after string, limit int) (BackupData, error) {
+	return sqlCommonDumpPage(scope, after, limit, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getConfigs() (Configs, error) {
 	return sqlCommonGetConfigs(p.dbHandle)
 }
@@ -770,6 +900,11 @@ func (p *PGSQLProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *PGSQLProvider) restore(plan *restorePlan) error {
+	defer readReplicas.onRestore(plan.results)
+	return sqlCommonRestore(plan, p.dbHandle)
+}
+
 func (p *PGSQLProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -827,6 +962,12 @@ func (p *PGSQLProvider) migrateDatabase() error { //nolint:dupl
 		return updatePgSQLDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updatePgSQLDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updatePgSQLDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updatePgSQLDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updatePgSQLDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -859,6 +1000,12 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 		return downgradePgSQLDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradePgSQLDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradePgSQLDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradePgSQLDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradePgSQLDatabaseFromV31(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -898,7 +1045,28 @@ func updatePgSQLDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updatePgSQLDatabaseFromV27(dbHandle *sql.DB) error {
-	return updatePgSQLDatabaseFrom27To28(dbHandle)
+	if err := updatePgSQLDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV28(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV29(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updatePgSQLDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updatePgSQLDatabaseFromV30(dbHandle)
+}
+
+func updatePgSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	return updatePgSQLDatabaseFrom30To31(dbHandle)
 }
 
 func downgradePgSQLDatabaseFromV24(dbHandle *sql.DB) error {
@@ -933,6 +1101,27 @@ func downgradePgSQLDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradePgSQLDatabaseFromV27(dbHandle)
 }
 
+func downgradePgSQLDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV28(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV29(dbHandle)
+}
+
+func downgradePgSQLDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradePgSQLDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradePgSQLDatabaseFromV30(dbHandle)
+}
+
 func updatePgSQLDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -984,6 +1173,30 @@ func updatePgSQLDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
 }
 
+func updatePgSQLDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(pgsqlV29SQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updatePgSQLDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(pgsqlV30SQL, "{{object_history}}", sqlTableObjectHistory)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updatePgSQLDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(pgsqlV31SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
 func downgradePgSQLDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -1021,3 +1234,131 @@ func downgradePgSQLDatabaseFrom28To27(dbHandle *sql.DB) error {
 	sql := strings.ReplaceAll(pgsqlV28DownSQL, "{{configs}}", sqlTableConfigs)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
 }
+
+func downgradePgSQLDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(pgsqlV29DownSQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradePgSQLDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(pgsqlV30DownSQL, "{{object_history}}", sqlTableObjectHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradePgSQLDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(pgsqlV31DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
+// pgsqlChangeNotificationsTransport stores the change notifications in the outbox table,
+// like the outbox transport, and uses PostgreSQL LISTEN/NOTIFY to wake up the other
+// instances instead of polling the table
+type pgsqlChangeNotificationsTransport struct {
+	changeNotificationsReader
+	channel string
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newPGSQLChangeNotificationsTransport() (changeNotificationsTransport, error) {
+	return &pgsqlChangeNotificationsTransport{
+		channel: config.SQLTablesPrefix + "sftpgo_changes",
+	}, nil
+}
+
+func (*pgsqlChangeNotificationsTransport) name() string {
+	return "pgsql"
+}
+
+func (*pgsqlChangeNotificationsTransport) hasOutbox() bool {
+	return true
+}
+
+// publish adds the notification to the outbox table and notifies the listening instances
+// within the same transaction, NOTIFY is delivered on commit so the listeners will find
+// the added notification
+func (t *pgsqlChangeNotificationsTransport) publish(notification *ChangeNotification) error {
+	p, ok := provider.(*PGSQLProvider)
+	if !ok {
+		return errors.New("the data provider is not PostgreSQL")
+	}
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, p.dbHandle, func(tx *sql.Tx) error {
+		q := getAddChangeNotificationQuery()
+		_, err := tx.ExecContext(ctx, q, notification.Instance, string(payload), notification.Timestamp)
+		if err != nil {
+			return err
+		}
+		// the payload only identifies the sender, the notifications are read from the table
+		_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", t.channel, notification.Instance)
+		return err
+	})
+}
+
+func (t *pgsqlChangeNotificationsTransport) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.changeNotificationsReader = newChangeNotificationsReader()
+	t.wg.Add(1)
+
+	go func() {
+		defer t.wg.Done()
+
+		for {
+			err := t.listen(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			providerLog(logger.LevelError, "change notifications listener error: %v, retrying in 5 seconds", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+}
+
+func (t *pgsqlChangeNotificationsTransport) stop() {
+	t.cancel()
+	t.wg.Wait()
+}
+
+// listen uses a dedicated connection to wait for notifications. After listening the
+// notifications added while disconnected are read from the outbox table
+func (t *pgsqlChangeNotificationsTransport) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, getPGSQLConnectionString(false))
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{t.channel}.Sanitize()); err != nil {
+		return err
+	}
+	providerLog(logger.LevelDebug, "listening for change notifications on channel %q", t.channel)
+	t.check() //nolint:errcheck
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if n.Payload == changeNotificationsInstanceID {
+			continue
+		}
+		t.check() //nolint:errcheck
+	}
+}
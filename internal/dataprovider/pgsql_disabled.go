@@ -30,3 +30,7 @@ func init() {
 func initializePGSQLProvider() error {
 	return errors.New("PostgreSQL disabled at build time")
 }
+
+func newPGSQLChangeNotificationsTransport() (changeNotificationsTransport, error) {
+	return nil, errors.New("PostgreSQL disabled at build time")
+}
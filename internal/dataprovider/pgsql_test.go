@@ -0,0 +1,88 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nopgsql
+// +build !nopgsql
+
+package dataprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGSQLChangeNotifications(t *testing.T) {
+	conf := getTestProviderConf(t)
+	if conf.Driver != PGSQLDataProviderName {
+		t.Skip("this test requires PostgreSQL")
+	}
+	conf.IsShared = 1
+	conf.ChangeNotifications.Mode = ChangeNotificationsModeProvider
+	initializeTestProvider(t, conf)
+
+	m := changeNotifications.Load()
+	require.NotNil(t, m)
+	require.Equal(t, "pgsql", m.transport.name())
+	require.True(t, m.transport.hasOutbox())
+	transport, ok := m.transport.(*pgsqlChangeNotificationsTransport)
+	require.True(t, ok)
+
+	otherInstance := xid.New().String()
+	admin1 := "cn_admin1_" + xid.New().String()
+	admin2 := "cn_admin2_" + xid.New().String()
+	admin3 := "cn_admin3_" + xid.New().String()
+	admin4 := "cn_admin4_" + xid.New().String()
+	cacheTestAdminPassword(admin1)
+	cacheTestAdminPassword(admin2)
+	cacheTestAdminPassword(admin3)
+	cacheTestAdminPassword(admin4)
+	// wait for the listener, the notifications are read from the table after listening
+	// so the notification is handled even if published before
+	err := transport.publish(newTestChangeNotification(otherInstance, admin1))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !isTestAdminPasswordCached(admin1)
+	}, 5*time.Second, 50*time.Millisecond)
+	// the notifications are handled in order so when admin3 is refreshed the
+	// other notifications have been processed too
+	cacheTestAdminPassword(admin1)
+	p, ok := provider.(*PGSQLProvider)
+	require.True(t, ok)
+	_, err = p.dbHandle.Exec("SELECT pg_notify($1, $2)", transport.channel, otherInstance)
+	require.NoError(t, err)
+	err = transport.publish(newTestChangeNotification(changeNotificationsInstanceID, admin2))
+	require.NoError(t, err)
+	err = transport.publish(newTestChangeNotification(otherInstance, admin3))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !isTestAdminPasswordCached(admin3)
+	}, 5*time.Second, 50*time.Millisecond)
+	// already handled
+	assert.True(t, isTestAdminPasswordCached(admin1))
+	// published by this instance
+	assert.True(t, isTestAdminPasswordCached(admin2))
+	// the notifications published on the same table without NOTIFY are handled on reconnect
+	err = provider.addChangeNotification(newTestChangeNotification(otherInstance, admin4))
+	require.NoError(t, err)
+	assert.True(t, isTestAdminPasswordCached(admin4))
+	_, err = p.dbHandle.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE query LIKE 'LISTEN%' AND pid <> pg_backend_pid()")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return !isTestAdminPasswordCached(admin4)
+	}, 10*time.Second, 100*time.Millisecond)
+}
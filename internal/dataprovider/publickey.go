@@ -0,0 +1,208 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// Supported public key scopes
+const (
+	// the key can only be used for the SFTP subsystem
+	PublicKeyScopeSFTP = "sftp"
+	// the key can only be used for SSH commands, SCP included
+	PublicKeyScopeSSHCommands = "ssh-commands"
+)
+
+const (
+	pubKeyOptionFrom       = "from"
+	pubKeyOptionExpiryTime = "expiry-time"
+	pubKeyOptionScope      = "scope"
+)
+
+var (
+	pubKeyExpiryTimeFormats = []string{"20060102", "200601021504", "20060102150405"}
+	validPublicKeyScopes    = []string{PublicKeyScopeSFTP, PublicKeyScopeSSHCommands}
+)
+
+// PublicKey defines an SSH public key with its options.
+// Options are defined using the OpenSSH authorized_keys format, for example:
+//
+//	from="192.168.1.0/24",expiry-time="20251231",scope="sftp" ssh-ed25519 AAAA... laptop
+type PublicKey struct {
+	// SHA256 fingerprint
+	Fingerprint string `json:"fingerprint"`
+	// Key type, for example ssh-ed25519
+	Type string `json:"type"`
+	// Key comment, it can be used to identify the key
+	Comment string `json:"comment,omitempty"`
+	// Expiration date as unix timestamp in milliseconds. 0 means no expiration
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// The client IP must match one of these patterns, CIDR networks are supported.
+	// Patterns prefixed with "!" are negated
+	From []string `json:"from,omitempty"`
+	// Restrict the key usage to SFTP or SSH commands. Empty means no restrictions
+	Scope string `json:"scope,omitempty"`
+	// Last use as unix timestamp in milliseconds
+	LastUseAt int64 `json:"last_use_at,omitempty"`
+	key       ssh.PublicKey
+}
+
+// ParsePublicKey parses a public key in the OpenSSH authorized_keys format
+func ParsePublicKey(authorizedKey string) (PublicKey, error) {
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return PublicKey{}, err
+	}
+	pk := PublicKey{
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Type:        key.Type(),
+		Comment:     comment,
+		key:         key,
+	}
+	for _, option := range options {
+		name, value, _ := strings.Cut(option, "=")
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(name) {
+		case pubKeyOptionFrom:
+			if err := pk.setFrom(value); err != nil {
+				return pk, err
+			}
+		case pubKeyOptionExpiryTime:
+			if err := pk.setExpiryTime(value); err != nil {
+				return pk, err
+			}
+		case pubKeyOptionScope:
+			scope := strings.ToLower(value)
+			if !util.Contains(validPublicKeyScopes, scope) {
+				return pk, fmt.Errorf("invalid scope %q", value)
+			}
+			pk.Scope = scope
+		}
+		// other OpenSSH options are ignored
+	}
+	return pk, nil
+}
+
+func (k *PublicKey) setFrom(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		p := strings.TrimPrefix(pattern, "!")
+		if p == "" {
+			return fmt.Errorf("invalid from pattern %q", value)
+		}
+		if strings.Contains(p, "/") {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fmt.Errorf("invalid from network %q: %w", p, err)
+			}
+		} else if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid from pattern %q: %w", p, err)
+		}
+		k.From = append(k.From, pattern)
+	}
+	return nil
+}
+
+func (k *PublicKey) setExpiryTime(value string) error {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		loc = time.UTC
+		value = value[:len(value)-1]
+	}
+	for _, format := range pubKeyExpiryTimeFormats {
+		if len(value) != len(format) {
+			continue
+		}
+		t, err := time.ParseInLocation(format, value, loc)
+		if err != nil {
+			break
+		}
+		k.ExpiresAt = util.GetTimeAsMsSinceEpoch(t)
+		return nil
+	}
+	return fmt.Errorf("invalid expiry time %q", value)
+}
+
+// IsExpired returns true if the key is expired
+func (k *PublicKey) IsExpired() bool {
+	return k.ExpiresAt > 0 && k.ExpiresAt < util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// IsAllowedFrom returns true if the key can be used from the specified IP address
+func (k *PublicKey) IsAllowedFrom(ip string) bool {
+	if len(k.From) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	allowed := false
+	for _, pattern := range k.From {
+		p, negated := strings.CutPrefix(pattern, "!")
+		if matchPublicKeyFromPattern(p, ip, parsedIP) {
+			if negated {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// IsSFTPAllowed returns true if the key can be used for the SFTP subsystem
+func (k *PublicKey) IsSFTPAllowed() bool {
+	return k.Scope == "" || k.Scope == PublicKeyScopeSFTP
+}
+
+// IsSSHCommandAllowed returns true if the key can be used for SSH commands
+func (k *PublicKey) IsSSHCommandAllowed() bool {
+	return k.Scope == "" || k.Scope == PublicKeyScopeSSHCommands
+}
+
+// GetID returns an identifier for this key suitable for logging
+func (k *PublicKey) GetID() string {
+	return fmt.Sprintf("%s:%s", k.Fingerprint, k.Comment)
+}
+
+// GetExpiresAtAsString returns the expiration date as string
+func (k *PublicKey) GetExpiresAtAsString() string {
+	if k.ExpiresAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(k.ExpiresAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+// GetLastUseAtAsString returns the last use as string
+func (k *PublicKey) GetLastUseAtAsString() string {
+	if k.LastUseAt > 0 {
+		return util.GetTimeFromMsecSinceEpoch(k.LastUseAt).UTC().Format(iso8601UTCFormat)
+	}
+	return ""
+}
+
+func matchPublicKeyFromPattern(pattern, ip string, parsedIP net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, ipNet, err := net.ParseCIDR(pattern)
+		return err == nil && parsedIP != nil && ipNet.Contains(parsedIP)
+	}
+	match, err := path.Match(pattern, ip)
+	return err == nil && match
+}
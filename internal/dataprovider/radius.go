@@ -0,0 +1,246 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/radius"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// maximum number of consecutive Access-Challenge we accept for a single authentication
+	radiusMaxChallenges = 10
+)
+
+var (
+	radiusAuth atomic.Pointer[radiusAuthenticator]
+	// ErrRADIUSNotEnabled is returned if RADIUS authentication is not enabled for the requested protocol
+	ErrRADIUSNotEnabled = errors.New("radius authentication is not enabled")
+)
+
+// RADIUSChallengeError is returned if the RADIUS server requires a reply to an
+// Access-Challenge to complete the authentication
+type RADIUSChallengeError struct {
+	// Message to show to the user
+	Message string
+	// State to send back, with the reply, to the RADIUS server
+	State []byte
+}
+
+func (e *RADIUSChallengeError) Error() string {
+	return "radius: access challenge"
+}
+
+// GetPrompt returns the prompt to show to the user
+func (e *RADIUSChallengeError) GetPrompt() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "Response: "
+}
+
+type radiusAuthenticator struct {
+	client    *radius.Client
+	mode      int
+	protocols []string
+}
+
+func (a *radiusAuthenticator) authenticate(username, password string, state []byte) error {
+	resp, err := a.client.Authenticate(context.Background(), username, password, state)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to authenticate user %q using RADIUS: %v", username, err)
+		return err
+	}
+	switch resp.Result {
+	case radius.ResultAccept:
+		providerLog(logger.LevelDebug, "RADIUS authentication accepted for user %q", username)
+		return nil
+	case radius.ResultChallenge:
+		providerLog(logger.LevelDebug, "RADIUS challenge received for user %q", username)
+		return &RADIUSChallengeError{
+			Message: resp.Message,
+			State:   resp.State,
+		}
+	default:
+		providerLog(logger.LevelDebug, "RADIUS authentication rejected for user %q", username)
+		return ErrInvalidCredentials
+	}
+}
+
+// handleKeyboardInteractiveChallenges asks the client to reply to the RADIUS challenges,
+// if any, until the server accepts or rejects the authentication
+func (a *radiusAuthenticator) handleKeyboardInteractiveChallenges(user *User, client ssh.KeyboardInteractiveChallenge,
+	authErr error,
+) error {
+	for i := 0; i < radiusMaxChallenges; i++ {
+		var challengeErr *RADIUSChallengeError
+		if !errors.As(authErr, &challengeErr) {
+			return authErr
+		}
+		answers, err := client("", "", []string{challengeErr.GetPrompt()}, []bool{false})
+		if err != nil {
+			return err
+		}
+		if len(answers) != 1 {
+			return fmt.Errorf("unexpected number of answers: %v", len(answers))
+		}
+		authErr = a.authenticate(user.Username, answers[0], challengeErr.State)
+	}
+	providerLog(logger.LevelWarn, "too many RADIUS challenges for user %q", user.Username)
+	return ErrInvalidCredentials
+}
+
+func setRADIUSConfig(c *RADIUSConfigs) error {
+	if c == nil || c.isEmpty() {
+		radiusAuth.Store(nil)
+		return nil
+	}
+	if err := c.Secret.TryDecrypt(); err != nil {
+		radiusAuth.Store(nil)
+		return fmt.Errorf("unable to decrypt radius secret: %w", err)
+	}
+	client, err := radius.NewClient(radius.Config{
+		Servers:       c.Servers,
+		Secret:        c.Secret.GetPayload(),
+		AuthType:      c.AuthType,
+		Timeout:       time.Duration(c.Timeout) * time.Second,
+		Retries:       c.Retries,
+		NASIdentifier: c.NASIdentifier,
+	})
+	if err != nil {
+		radiusAuth.Store(nil)
+		return err
+	}
+	radiusAuth.Store(&radiusAuthenticator{
+		client:    client,
+		mode:      c.Mode,
+		protocols: c.Protocols,
+	})
+	providerLog(logger.LevelDebug, "RADIUS authentication configured, servers: %v, mode: %d, protocols: %v",
+		c.Servers, c.Mode, c.Protocols)
+	return nil
+}
+
+// getRADIUSAuthenticator returns the RADIUS authenticator if RADIUS authentication
+// is enabled for the specified mode and protocol, nil otherwise
+func getRADIUSAuthenticator(mode int, protocol string) *radiusAuthenticator {
+	a := radiusAuth.Load()
+	if a == nil || a.mode != mode || !util.Contains(a.protocols, protocol) {
+		return nil
+	}
+	return a
+}
+
+// getRADIUSPasswordAuthenticator returns the RADIUS authenticator if the password of the
+// specified user must be validated using RADIUS. Users with a password stored inside the
+// data provider are always authenticated using it
+func getRADIUSPasswordAuthenticator(user *User, protocol string) *radiusAuthenticator {
+	if user.Password != "" {
+		return nil
+	}
+	return getRADIUSAuthenticator(RADIUSModePassword, protocol)
+}
+
+// IsRADIUSSecondFactorEnabled returns true if RADIUS is configured as second
+// authentication factor for the specified protocol
+func IsRADIUSSecondFactorEnabled(protocol string) bool {
+	return getRADIUSAuthenticator(RADIUSModeSecondFactor, protocol) != nil
+}
+
+// MustUseRADIUSSecondFactor returns true if the user must authenticate using the
+// RADIUS second factor for the specified protocol. RADIUS is used as second factor
+// only for the users that require two-factor authentication for the protocol
+func (u *User) MustUseRADIUSSecondFactor(protocol string) bool {
+	if u.Filters.IsAnonymous || !util.Contains(u.Filters.TwoFactorAuthProtocols, protocol) {
+		return false
+	}
+	return IsRADIUSSecondFactorEnabled(protocol)
+}
+
+// GetSSHRADIUSFirstFactorMethod returns the login method used as first authentication
+// factor if only the RADIUS second factor is missing to complete an SSH multi-step
+// authentication, an empty string otherwise
+func (u *User) GetSSHRADIUSFirstFactorMethod(partialSuccessMethods []string) string {
+	if !u.MustUseRADIUSSecondFactor(protocolSSH) {
+		return ""
+	}
+	switch len(partialSuccessMethods) {
+	case 1:
+		switch partialSuccessMethods[0] {
+		case LoginMethodPassword:
+			return LoginMethodPassword
+		case SSHLoginMethodPublicKey:
+			// if public key authentication is the first step of a multi-step authentication
+			// the second step will ask for the RADIUS passcode too
+			if !u.IsPartialAuth(SSHLoginMethodPublicKey) {
+				return SSHLoginMethodPublicKey
+			}
+		}
+	case 2:
+		if partialSuccessMethods[0] == SSHLoginMethodPublicKey && partialSuccessMethods[1] == LoginMethodPassword {
+			return SSHLoginMethodKeyAndPassword
+		}
+	}
+	return ""
+}
+
+// CheckRADIUSSecondFactor asks the RADIUS passcode, using the keyboard interactive
+// authentication, to a user already authenticated using the first factor
+func CheckRADIUSSecondFactor(user *User, client ssh.KeyboardInteractiveChallenge) error {
+	a := getRADIUSAuthenticator(RADIUSModeSecondFactor, protocolSSH)
+	if a == nil {
+		return ErrRADIUSNotEnabled
+	}
+	return a.doKeyboardInteractiveAuth(user, client)
+}
+
+// doKeyboardInteractiveAuth asks the RADIUS passcode and replies to the challenges, if any
+func (a *radiusAuthenticator) doKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractiveChallenge) error {
+	answers, err := client("", "", []string{"Passcode: "}, []bool{false})
+	if err != nil {
+		return err
+	}
+	if len(answers) != 1 {
+		return fmt.Errorf("unexpected number of answers: %v", len(answers))
+	}
+	err = a.authenticate(user.Username, answers[0], nil)
+	if err != nil {
+		return a.handleKeyboardInteractiveChallenges(user, client, err)
+	}
+	return nil
+}
+
+// CheckRADIUSPasscode validates the specified passcode for the given username using
+// the configured RADIUS servers. State must be set to reply to a previous challenge.
+// A *RADIUSChallengeError is returned if the server requires a reply to a challenge
+func CheckRADIUSPasscode(username, passcode, protocol string, state []byte) error {
+	a := radiusAuth.Load()
+	if a == nil || !util.Contains(a.protocols, protocol) {
+		return ErrRADIUSNotEnabled
+	}
+	if passcode == "" {
+		return ErrInvalidCredentials
+	}
+	return a.authenticate(config.convertName(username), passcode, state)
+}
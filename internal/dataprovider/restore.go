@@ -0,0 +1,1028 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// Restore statuses for the single objects
+const (
+	RestoreStatusAdded   = "added"
+	RestoreStatusUpdated = "updated"
+	// the object already exists and the restore mode does not allow to update it
+	RestoreStatusSkipped = "skipped"
+	RestoreStatusFailed  = "failed"
+)
+
+// RestoreOptions defines the options to restore a backup
+type RestoreOptions struct {
+	// 1 means that existing objects are not updated
+	Mode int
+	// If true the backup is validated but nothing is saved
+	ValidateOnly bool
+}
+
+// RestoreResult defines the restore outcome for a single object
+type RestoreResult struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RestoreResults defines the outcome of a restore.
+// If the changes were not applied the statuses describe the planned changes
+type RestoreResults struct {
+	ValidateOnly bool `json:"validate_only"`
+	// true if all the changes were saved
+	Applied bool            `json:"applied"`
+	Results []RestoreResult `json:"results"`
+}
+
+// HasFailures returns true if at least one object failed
+func (r *RestoreResults) HasFailures() bool {
+	for _, result := range r.Results {
+		if result.Status == RestoreStatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUsers returns the usernames of the users with the specified statuses
+func (r *RestoreResults) GetUsers(statuses ...string) []string {
+	return r.getNames(actionObjectUser, statuses)
+}
+
+// GetFolders returns the names of the virtual folders with the specified statuses
+func (r *RestoreResults) GetFolders(statuses ...string) []string {
+	return r.getNames(actionObjectFolder, statuses)
+}
+
+// GetErrors returns the errors for the failed objects
+func (r *RestoreResults) GetErrors() []string {
+	var errs []string
+	for _, result := range r.Results {
+		if result.Status == RestoreStatusFailed {
+			errs = append(errs, fmt.Sprintf("%s %q: %s", getRestoreObjectLabel(result.Type), result.Name, result.Error))
+		}
+	}
+	return errs
+}
+
+func (r *RestoreResults) getNames(objectType string, statuses []string) []string {
+	var names []string
+	for _, result := range r.Results {
+		if result.Type == objectType && util.Contains(statuses, result.Status) {
+			names = append(names, result.Name)
+		}
+	}
+	return names
+}
+
+// restoreError is returned by the providers if an object cannot be restored
+type restoreError struct {
+	objectType string
+	name       string
+	resultIdx  int
+	err        error
+}
+
+func (e *restoreError) Error() string {
+	return fmt.Sprintf("unable to restore %s %q: %v", getRestoreObjectLabel(e.objectType), e.name, e.err)
+}
+
+func (e *restoreError) Unwrap() error {
+	return e.err
+}
+
+// restoreApplier defines the methods used to save the restored objects.
+// The Provider interface already implements them
+type restoreApplier interface {
+	setConfigs(configs *Configs) error
+	addIPListEntry(entry *IPListEntry) error
+	updateIPListEntry(entry *IPListEntry) error
+	addRole(role *Role) error
+	updateRole(role *Role) error
+	addFolder(folder *vfs.BaseVirtualFolder) error
+	updateFolder(folder *vfs.BaseVirtualFolder) error
+	addGroup(group *Group) error
+	updateGroup(group *Group) error
+	addUser(user *User) error
+	updateUser(user *User) error
+	addAdmin(admin *Admin) error
+	updateAdmin(admin *Admin) error
+	addAPIKey(apiKey *APIKey) error
+	updateAPIKey(apiKey *APIKey) error
+	addShare(share *Share) error
+	updateShare(share *Share) error
+	addEventAction(action *BaseEventAction) error
+	updateEventAction(action *BaseEventAction) error
+	addEventRule(rule *EventRule) error
+	updateEventRule(rule *EventRule) error
+}
+
+type restoreItem[T any] struct {
+	objectType string
+	name       string
+	object     *T
+	// true if an object with the same name already exists or is included
+	// more than once in the backup
+	update bool
+	// the stored object, if any
+	existing  *T
+	resultIdx int
+}
+
+func (i *restoreItem[T]) getOperation() string {
+	if i.update {
+		return operationUpdate
+	}
+	return operationAdd
+}
+
+type restoreReference struct {
+	objectType string
+	name       string
+}
+
+type restorePlan struct {
+	mode          int
+	dumpVersion   int
+	results       *RestoreResults
+	configs       *restoreItem[Configs]
+	ipListEntries []*restoreItem[IPListEntry]
+	roles         []*restoreItem[Role]
+	folders       []*restoreItem[vfs.BaseVirtualFolder]
+	groups        []*restoreItem[Group]
+	users         []*restoreItem[User]
+	admins        []*restoreItem[Admin]
+	apiKeys       []*restoreItem[APIKey]
+	shares        []*restoreItem[Share]
+	eventActions  []*restoreItem[BaseEventAction]
+	eventRules    []*restoreItem[EventRule]
+	// names of the objects that will exist after the restore, by object type
+	names map[string]map[string]bool
+}
+
+func newRestorePlan(dump *BackupData, mode int, results *RestoreResults) (*restorePlan, error) {
+	p := &restorePlan{
+		mode:        mode,
+		dumpVersion: dump.Version,
+		results:     results,
+		names:       make(map[string]map[string]bool),
+	}
+	if err := p.planConfigs(dump.Configs); err != nil {
+		return p, err
+	}
+	var err error
+	p.ipListEntries, err = planRestoreItems(p, actionObjectIPListEntry, dump.IPLists,
+		func(entry *IPListEntry) string {
+			return entry.getName()
+		},
+		func(entry *IPListEntry) (IPListEntry, error) {
+			return provider.ipListEntryExists(entry.IPOrNet, entry.Type)
+		}, nil)
+	if err != nil {
+		return p, err
+	}
+	p.roles, err = planRestoreItems(p, actionObjectRole, dump.Roles,
+		func(role *Role) string {
+			role.Name = config.convertName(role.Name)
+			return role.Name
+		},
+		func(role *Role) (Role, error) {
+			return provider.roleExists(role.Name)
+		},
+		func(item *restoreItem[Role]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.folders, err = planRestoreItems(p, actionObjectFolder, dump.Folders,
+		func(folder *vfs.BaseVirtualFolder) string {
+			folder.Name = config.convertName(folder.Name)
+			return folder.Name
+		},
+		func(folder *vfs.BaseVirtualFolder) (vfs.BaseVirtualFolder, error) {
+			return provider.getFolderByName(folder.Name)
+		},
+		func(item *restoreItem[vfs.BaseVirtualFolder]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+			if !item.update {
+				item.object.Users = nil
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.groups, err = planRestoreItems(p, actionObjectGroup, dump.Groups,
+		func(group *Group) string {
+			group.Name = config.convertName(group.Name)
+			return group.Name
+		},
+		func(group *Group) (Group, error) {
+			return provider.groupExists(group.Name)
+		},
+		func(item *restoreItem[Group]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.users, err = planRestoreItems(p, actionObjectUser, dump.Users,
+		func(user *User) string {
+			user.Username = config.convertName(user.Username)
+			return user.Username
+		},
+		func(user *User) (User, error) {
+			return provider.userExists(user.Username, "")
+		},
+		func(item *restoreItem[User]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.admins, err = planRestoreItems(p, actionObjectAdmin, dump.Admins,
+		func(admin *Admin) string {
+			admin.Username = config.convertName(admin.Username)
+			return admin.Username
+		},
+		func(admin *Admin) (Admin, error) {
+			return provider.adminExists(admin.Username)
+		},
+		func(item *restoreItem[Admin]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+			if !item.update {
+				item.object.Filters.RecoveryCodes = nil
+				item.object.Filters.PasswordHistory = nil
+				item.object.Filters.TOTPConfig = AdminTOTPConfig{
+					Enabled: false,
+				}
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.apiKeys, err = planRestoreItems(p, actionObjectAPIKey, dump.APIKeys,
+		func(apiKey *APIKey) string {
+			return apiKey.KeyID
+		},
+		func(apiKey *APIKey) (APIKey, error) {
+			if apiKey.KeyID == "" {
+				return APIKey{}, util.NewRecordNotFoundError("empty API key ID")
+			}
+			return provider.apiKeyExists(apiKey.KeyID)
+		},
+		func(item *restoreItem[APIKey]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.shares, err = planRestoreItems(p, actionObjectShare, dump.Shares,
+		func(share *Share) string {
+			return share.ShareID
+		},
+		func(share *Share) (Share, error) {
+			if share.ShareID == "" {
+				return Share{}, util.NewRecordNotFoundError("empty share ID")
+			}
+			return provider.shareExists(share.ShareID, "")
+		},
+		func(item *restoreItem[Share]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+			item.object.IsRestore = true
+		})
+	if err != nil {
+		return p, err
+	}
+	p.eventActions, err = planRestoreItems(p, actionObjectEventAction, dump.EventActions,
+		func(action *BaseEventAction) string {
+			action.Name = config.convertName(action.Name)
+			return action.Name
+		},
+		func(action *BaseEventAction) (BaseEventAction, error) {
+			return provider.eventActionExists(action.Name)
+		},
+		func(item *restoreItem[BaseEventAction]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+		})
+	if err != nil {
+		return p, err
+	}
+	p.eventRules, err = planRestoreItems(p, actionObjectEventRule, dump.EventRules,
+		func(rule *EventRule) string {
+			rule.Name = config.convertName(rule.Name)
+			return rule.Name
+		},
+		func(rule *EventRule) (EventRule, error) {
+			return provider.eventRuleExists(rule.Name)
+		},
+		func(item *restoreItem[EventRule]) {
+			if item.existing != nil {
+				item.object.ID = item.existing.ID
+			}
+			if p.dumpVersion < 15 {
+				item.object.Status = 1
+			}
+		})
+	return p, err
+}
+
+func (p *restorePlan) planConfigs(configs *Configs) error {
+	if configs == nil {
+		return nil
+	}
+	existing, err := provider.getConfigs()
+	if err != nil {
+		return fmt.Errorf("unable to restore configs, error loading existing from db: %w", err)
+	}
+	result := RestoreResult{
+		Type:   actionObjectConfigs,
+		Name:   actionObjectConfigs,
+		Status: RestoreStatusAdded,
+	}
+	if existing.UpdatedAt > 0 {
+		if p.mode == 1 {
+			result.Status = RestoreStatusSkipped
+			p.results.Results = append(p.results.Results, result)
+			return nil
+		}
+		result.Status = RestoreStatusUpdated
+	}
+	object := *configs
+	object.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.configs = &restoreItem[Configs]{
+		objectType: actionObjectConfigs,
+		name:       actionObjectConfigs,
+		object:     &object,
+		update:     existing.UpdatedAt > 0,
+		existing:   &existing,
+		resultIdx:  len(p.results.Results),
+	}
+	p.results.Results = append(p.results.Results, result)
+	return nil
+}
+
+func planRestoreItems[T any](p *restorePlan, objectType string, objects []T, getName func(*T) string,
+	exists func(*T) (T, error), prepare func(*restoreItem[T]),
+) ([]*restoreItem[T], error) {
+	if p.names[objectType] == nil {
+		p.names[objectType] = make(map[string]bool)
+	}
+	items := make([]*restoreItem[T], 0, len(objects))
+	for idx := range objects {
+		object := objects[idx]
+		item := &restoreItem[T]{
+			objectType: objectType,
+			name:       getName(&object),
+			object:     &object,
+			resultIdx:  len(p.results.Results),
+		}
+		result := RestoreResult{
+			Type:   objectType,
+			Name:   item.name,
+			Status: RestoreStatusAdded,
+		}
+		if p.names[objectType][item.name] {
+			// the object is included more than once in the backup, the last
+			// definition wins as in a sequential restore
+			item.update = true
+		} else {
+			existing, err := exists(&object)
+			if err == nil {
+				item.update = true
+				item.existing = &existing
+			} else if !errors.Is(err, util.ErrNotFound) {
+				return items, fmt.Errorf("unable to restore %s %q: %w", getRestoreObjectLabel(objectType),
+					item.name, err)
+			}
+			p.names[objectType][item.name] = true
+		}
+		if item.update {
+			if p.mode == 1 {
+				result.Status = RestoreStatusSkipped
+				p.results.Results = append(p.results.Results, result)
+				continue
+			}
+			result.Status = RestoreStatusUpdated
+		}
+		if prepare != nil {
+			prepare(item)
+		}
+		p.results.Results = append(p.results.Results, result)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *restorePlan) setFailed(resultIdx int, err error) {
+	result := &p.results.Results[resultIdx]
+	result.Status = RestoreStatusFailed
+	result.Error = err.Error()
+}
+
+func (p *restorePlan) isFailed(resultIdx int) bool {
+	return p.results.Results[resultIdx].Status == RestoreStatusFailed
+}
+
+// validate validates all the objects to restore and checks that the referenced
+// objects exist or are included in the backup. Validation errors are reported
+// within the results
+func (p *restorePlan) validate() error {
+	if p.configs != nil {
+		if err := p.configs.object.validate(); err != nil {
+			p.setFailed(p.configs.resultIdx, err)
+		}
+	}
+	validateRestoreItems(p, p.ipListEntries, func(entry *IPListEntry) error {
+		return entry.validate()
+	})
+	validateRestoreItems(p, p.roles, func(role *Role) error {
+		return role.validate()
+	})
+	validateRestoreItems(p, p.folders, ValidateFolder)
+	validateRestoreItems(p, p.groups, func(group *Group) error {
+		p.resolveVirtualFolders(group.VirtualFolders)
+		return group.validate()
+	})
+	validateRestoreItems(p, p.users, func(user *User) error {
+		p.resolveVirtualFolders(user.VirtualFolders)
+		return ValidateUser(user)
+	})
+	validateRestoreItems(p, p.admins, func(admin *Admin) error {
+		return admin.validate()
+	})
+	validateRestoreItems(p, p.apiKeys, func(apiKey *APIKey) error {
+		if apiKey.Key == "" {
+			return util.NewValidationError("cannot restore an empty API key")
+		}
+		return apiKey.validateAttributes()
+	})
+	validateRestoreItems(p, p.shares, func(share *Share) error {
+		return share.validate()
+	})
+	validateRestoreItems(p, p.eventActions, func(action *BaseEventAction) error {
+		return action.validate()
+	})
+	validateRestoreItems(p, p.eventRules, func(rule *EventRule) error {
+		return rule.validate()
+	})
+	return p.checkReferences()
+}
+
+// resolveVirtualFolders populates the virtual folders defined using only the
+// name with the matching folders included in the backup, they are not saved
+// yet so getVirtualFolderIfInvalid cannot find them
+func (p *restorePlan) resolveVirtualFolders(vfolders []vfs.VirtualFolder) {
+	for idx := range vfolders {
+		folder := &vfolders[idx].BaseVirtualFolder
+		if folder.Name == "" || folder.MappedPath != "" || folder.FsConfig.Provider != sdk.LocalFilesystemProvider {
+			continue
+		}
+		if err := ValidateFolder(folder); err == nil {
+			continue
+		}
+		for i := len(p.folders) - 1; i >= 0; i-- {
+			if p.folders[i].name == folder.Name {
+				*folder = p.folders[i].object.GetACopy()
+				break
+			}
+		}
+	}
+}
+
+func validateRestoreItems[T any](p *restorePlan, items []*restoreItem[T], validate func(*T) error) {
+	for _, item := range items {
+		if err := validate(item.object); err != nil {
+			p.setFailed(item.resultIdx, err)
+		}
+	}
+}
+
+func (p *restorePlan) checkReferences() error {
+	err := checkRestoreItemsReferences(p, p.users, func(user *User) []restoreReference {
+		var refs []restoreReference
+		if user.Role != "" {
+			refs = append(refs, restoreReference{objectType: actionObjectRole, name: user.Role})
+		}
+		for _, group := range user.Groups {
+			refs = append(refs, restoreReference{objectType: actionObjectGroup, name: group.Name})
+		}
+		return refs
+	})
+	if err != nil {
+		return err
+	}
+	err = checkRestoreItemsReferences(p, p.admins, func(admin *Admin) []restoreReference {
+		var refs []restoreReference
+		if admin.Role != "" {
+			refs = append(refs, restoreReference{objectType: actionObjectRole, name: admin.Role})
+		}
+		for _, group := range admin.Groups {
+			refs = append(refs, restoreReference{objectType: actionObjectGroup, name: group.Name})
+		}
+		return refs
+	})
+	if err != nil {
+		return err
+	}
+	err = checkRestoreItemsReferences(p, p.apiKeys, func(apiKey *APIKey) []restoreReference {
+		if apiKey.User != "" {
+			return []restoreReference{{objectType: actionObjectUser, name: apiKey.User}}
+		}
+		if apiKey.Admin != "" {
+			return []restoreReference{{objectType: actionObjectAdmin, name: apiKey.Admin}}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = checkRestoreItemsReferences(p, p.shares, func(share *Share) []restoreReference {
+		return []restoreReference{{objectType: actionObjectUser, name: share.Username}}
+	})
+	if err != nil {
+		return err
+	}
+	return checkRestoreItemsReferences(p, p.eventRules, func(rule *EventRule) []restoreReference {
+		refs := make([]restoreReference, 0, len(rule.Actions))
+		for _, action := range rule.Actions {
+			refs = append(refs, restoreReference{objectType: actionObjectEventAction, name: action.Name})
+		}
+		return refs
+	})
+}
+
+func checkRestoreItemsReferences[T any](p *restorePlan, items []*restoreItem[T],
+	getReferences func(*T) []restoreReference,
+) error {
+	for _, item := range items {
+		if p.isFailed(item.resultIdx) {
+			continue
+		}
+		for _, ref := range getReferences(item.object) {
+			found, err := p.referenceExists(ref)
+			if err != nil {
+				return err
+			}
+			if !found {
+				p.setFailed(item.resultIdx, util.NewValidationError(fmt.Sprintf("%s %q does not exist",
+					getRestoreObjectLabel(ref.objectType), ref.name)))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (p *restorePlan) referenceExists(ref restoreReference) (bool, error) {
+	if p.names[ref.objectType][ref.name] {
+		return true, nil
+	}
+	var err error
+	switch ref.objectType {
+	case actionObjectRole:
+		_, err = provider.roleExists(ref.name)
+	case actionObjectGroup:
+		_, err = provider.groupExists(ref.name)
+	case actionObjectUser:
+		_, err = provider.userExists(ref.name, "")
+	case actionObjectAdmin:
+		_, err = provider.adminExists(ref.name)
+	case actionObjectEventAction:
+		_, err = provider.eventActionExists(ref.name)
+	default:
+		return false, fmt.Errorf("unsupported reference type %q", ref.objectType)
+	}
+	if err == nil {
+		p.names[ref.objectType][ref.name] = true
+		return true, nil
+	}
+	if errors.Is(err, util.ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// apply saves the objects to restore, in dependency order, using the specified applier
+func (p *restorePlan) apply(applier restoreApplier) error {
+	if p.configs != nil {
+		if err := applier.setConfigs(p.configs.object); err != nil {
+			return &restoreError{
+				objectType: p.configs.objectType,
+				name:       p.configs.name,
+				resultIdx:  p.configs.resultIdx,
+				err:        err,
+			}
+		}
+	}
+	if err := applyRestoreItems(p.ipListEntries, applier.addIPListEntry, applier.updateIPListEntry); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.roles, applier.addRole, applier.updateRole); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.folders, applier.addFolder, applier.updateFolder); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.groups, applier.addGroup, applier.updateGroup); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.users, applier.addUser, applier.updateUser); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.admins, applier.addAdmin, applier.updateAdmin); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.apiKeys, applier.addAPIKey, applier.updateAPIKey); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.shares, applier.addShare, applier.updateShare); err != nil {
+		return err
+	}
+	if err := applyRestoreItems(p.eventActions, applier.addEventAction, applier.updateEventAction); err != nil {
+		return err
+	}
+	return applyRestoreItems(p.eventRules, applier.addEventRule, applier.updateEventRule)
+}
+
+func applyRestoreItems[T any](items []*restoreItem[T], add, update func(*T) error) error {
+	for _, item := range items {
+		save := add
+		if item.update {
+			save = update
+		}
+		if err := save(item.object); err != nil {
+			return &restoreError{
+				objectType: item.objectType,
+				name:       item.name,
+				resultIdx:  item.resultIdx,
+				err:        err,
+			}
+		}
+	}
+	return nil
+}
+
+// applyWithRollback saves the objects to restore one at a time and reverts the
+// already saved ones if an object cannot be saved. It is used by the data
+// providers without transactions support
+func (p *restorePlan) applyWithRollback(dbProvider Provider) error {
+	restorer := &stagedRestorer{
+		p: dbProvider,
+	}
+	err := p.apply(restorer)
+	if err != nil {
+		providerLog(logger.LevelWarn, "restore failed, reverting %d saved objects: %v", len(restorer.undo), err)
+		restorer.rollback()
+	}
+	return err
+}
+
+// notify executes the actions for the restored objects and updates the caches
+func (p *restorePlan) notify(executor, ipAddress, role string) {
+	if p.configs != nil {
+		configs := p.configs.object
+		var radiusConfigs *RADIUSConfigs
+		if configs.RADIUS != nil {
+			radiusConfigs = configs.RADIUS.getACopy()
+		}
+		if err := setRADIUSConfig(radiusConfigs); err != nil {
+			providerLog(logger.LevelError, "unable to apply RADIUS settings: %v", err)
+		}
+		setRevokedUserCerts(configs.RevokedUserCerts)
+		executeAction(operationUpdate, executor, ipAddress, actionObjectConfigs, "configs", role, configs)
+	}
+	for _, item := range p.ipListEntries {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectIPListEntry, item.name, role, item.object)
+		for _, l := range inMemoryLists {
+			if item.update {
+				l.updateEntry(item.object)
+			} else {
+				l.addEntry(item.object)
+			}
+		}
+	}
+	for _, item := range p.roles {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectRole, item.name, role, item.object)
+	}
+	for _, item := range p.folders {
+		if !item.update {
+			executeAction(operationAdd, executor, ipAddress, actionObjectFolder, item.name, role,
+				&wrappedFolder{Folder: *item.object})
+			continue
+		}
+		var users, groups []string
+		if item.existing != nil {
+			users = item.existing.Users
+			groups = item.existing.Groups
+		}
+		onFolderUpdated(item.object, users, groups, executor, ipAddress, role)
+	}
+	for _, item := range p.groups {
+		if !item.update {
+			executeAction(operationAdd, executor, ipAddress, actionObjectGroup, item.name, role, item.object)
+			continue
+		}
+		var users []string
+		if item.existing != nil {
+			users = item.existing.Users
+		}
+		onGroupUpdated(item.object, users, executor, ipAddress, role)
+	}
+	for _, item := range p.users {
+		if !item.update {
+			executeAction(operationAdd, executor, ipAddress, actionObjectUser, item.name, role, item.object)
+			continue
+		}
+		onUserUpdated(item.object, executor, ipAddress, role)
+	}
+	for _, item := range p.admins {
+		if !item.update {
+			isAdminCreated.Store(true)
+		}
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectAdmin, item.name, role, item.object)
+	}
+	for _, item := range p.apiKeys {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectAPIKey, item.name, role, item.object)
+	}
+	for _, item := range p.shares {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectShare, item.name, role, item.object)
+	}
+	for _, item := range p.eventActions {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectEventAction, item.name, role, item.object)
+	}
+	for _, item := range p.eventRules {
+		executeAction(item.getOperation(), executor, ipAddress, actionObjectEventRule, item.name, role, item.object)
+	}
+	if len(p.eventActions) > 0 || len(p.eventRules) > 0 {
+		if fnReloadRules != nil {
+			fnReloadRules()
+		}
+	}
+}
+
+// stagedRestorer saves the restored objects using the provider methods and
+// keeps track of how to revert each saved change
+type stagedRestorer struct {
+	p    Provider
+	undo []func() error
+}
+
+func (r *stagedRestorer) rollback() {
+	for idx := len(r.undo) - 1; idx >= 0; idx-- {
+		if err := r.undo[idx](); err != nil {
+			providerLog(logger.LevelError, "unable to revert restored object: %v", err)
+		}
+	}
+	r.undo = nil
+}
+
+func stageRestoredAdd[T any](r *stagedRestorer, object *T, add func(*T) error, load func() (T, error),
+	remove func(T) error,
+) error {
+	if err := add(object); err != nil {
+		return err
+	}
+	r.undo = append(r.undo, func() error {
+		stored, err := load()
+		if err != nil {
+			return err
+		}
+		return remove(stored)
+	})
+	return nil
+}
+
+func stageRestoredUpdate[T any](r *stagedRestorer, object *T, update func(*T) error, load func() (T, error)) error {
+	previous, err := load()
+	if err != nil {
+		return err
+	}
+	if err := update(object); err != nil {
+		return err
+	}
+	r.undo = append(r.undo, func() error {
+		return update(&previous)
+	})
+	return nil
+}
+
+func (r *stagedRestorer) setConfigs(configs *Configs) error {
+	return stageRestoredUpdate(r, configs, r.p.setConfigs, r.p.getConfigs)
+}
+
+func (r *stagedRestorer) addIPListEntry(entry *IPListEntry) error {
+	return stageRestoredAdd(r, entry, r.p.addIPListEntry, func() (IPListEntry, error) {
+		return r.p.ipListEntryExists(entry.IPOrNet, entry.Type)
+	}, func(stored IPListEntry) error {
+		return r.p.deleteIPListEntry(stored, false)
+	})
+}
+
+func (r *stagedRestorer) updateIPListEntry(entry *IPListEntry) error {
+	return stageRestoredUpdate(r, entry, r.p.updateIPListEntry, func() (IPListEntry, error) {
+		return r.p.ipListEntryExists(entry.IPOrNet, entry.Type)
+	})
+}
+
+func (r *stagedRestorer) addRole(role *Role) error {
+	return stageRestoredAdd(r, role, r.p.addRole, func() (Role, error) {
+		return r.p.roleExists(role.Name)
+	}, r.p.deleteRole)
+}
+
+func (r *stagedRestorer) updateRole(role *Role) error {
+	return stageRestoredUpdate(r, role, r.p.updateRole, func() (Role, error) {
+		return r.p.roleExists(role.Name)
+	})
+}
+
+func (r *stagedRestorer) addFolder(folder *vfs.BaseVirtualFolder) error {
+	return stageRestoredAdd(r, folder, r.p.addFolder, func() (vfs.BaseVirtualFolder, error) {
+		return r.p.getFolderByName(folder.Name)
+	}, r.p.deleteFolder)
+}
+
+func (r *stagedRestorer) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	return stageRestoredUpdate(r, folder, r.p.updateFolder, func() (vfs.BaseVirtualFolder, error) {
+		return r.p.getFolderByName(folder.Name)
+	})
+}
+
+func (r *stagedRestorer) addGroup(group *Group) error {
+	return stageRestoredAdd(r, group, r.p.addGroup, func() (Group, error) {
+		return r.p.groupExists(group.Name)
+	}, r.p.deleteGroup)
+}
+
+func (r *stagedRestorer) updateGroup(group *Group) error {
+	return stageRestoredUpdate(r, group, r.p.updateGroup, func() (Group, error) {
+		return r.p.groupExists(group.Name)
+	})
+}
+
+func (r *stagedRestorer) addUser(user *User) error {
+	return stageRestoredAdd(r, user, r.p.addUser, func() (User, error) {
+		return r.p.userExists(user.Username, "")
+	}, func(stored User) error {
+		return r.p.deleteUser(stored, false)
+	})
+}
+
+func (r *stagedRestorer) updateUser(user *User) error {
+	return stageRestoredUpdate(r, user, r.p.updateUser, func() (User, error) {
+		return r.p.userExists(user.Username, "")
+	})
+}
+
+func (r *stagedRestorer) addAdmin(admin *Admin) error {
+	return stageRestoredAdd(r, admin, r.p.addAdmin, func() (Admin, error) {
+		return r.p.adminExists(admin.Username)
+	}, r.p.deleteAdmin)
+}
+
+func (r *stagedRestorer) updateAdmin(admin *Admin) error {
+	return stageRestoredUpdate(r, admin, r.p.updateAdmin, func() (Admin, error) {
+		return r.p.adminExists(admin.Username)
+	})
+}
+
+func (r *stagedRestorer) addAPIKey(apiKey *APIKey) error {
+	return stageRestoredAdd(r, apiKey, r.p.addAPIKey, func() (APIKey, error) {
+		return r.p.apiKeyExists(apiKey.KeyID)
+	}, r.p.deleteAPIKey)
+}
+
+func (r *stagedRestorer) updateAPIKey(apiKey *APIKey) error {
+	return stageRestoredUpdate(r, apiKey, r.p.updateAPIKey, func() (APIKey, error) {
+		return r.p.apiKeyExists(apiKey.KeyID)
+	})
+}
+
+func (r *stagedRestorer) addShare(share *Share) error {
+	return stageRestoredAdd(r, share, r.p.addShare, func() (Share, error) {
+		return r.p.shareExists(share.ShareID, "")
+	}, r.p.deleteShare)
+}
+
+func (r *stagedRestorer) updateShare(share *Share) error {
+	return stageRestoredUpdate(r, share, r.p.updateShare, func() (Share, error) {
+		previous, err := r.p.shareExists(share.ShareID, "")
+		previous.IsRestore = true
+		return previous, err
+	})
+}
+
+func (r *stagedRestorer) addEventAction(action *BaseEventAction) error {
+	return stageRestoredAdd(r, action, r.p.addEventAction, func() (BaseEventAction, error) {
+		return r.p.eventActionExists(action.Name)
+	}, r.p.deleteEventAction)
+}
+
+func (r *stagedRestorer) updateEventAction(action *BaseEventAction) error {
+	return stageRestoredUpdate(r, action, r.p.updateEventAction, func() (BaseEventAction, error) {
+		return r.p.eventActionExists(action.Name)
+	})
+}
+
+func (r *stagedRestorer) addEventRule(rule *EventRule) error {
+	return stageRestoredAdd(r, rule, r.p.addEventRule, func() (EventRule, error) {
+		return r.p.eventRuleExists(rule.Name)
+	}, func(stored EventRule) error {
+		return r.p.deleteEventRule(stored, false)
+	})
+}
+
+func (r *stagedRestorer) updateEventRule(rule *EventRule) error {
+	return stageRestoredUpdate(r, rule, r.p.updateEventRule, func() (EventRule, error) {
+		return r.p.eventRuleExists(rule.Name)
+	})
+}
+
+func getRestoreObjectLabel(objectType string) string {
+	switch objectType {
+	case actionObjectAPIKey:
+		return "API key"
+	case actionObjectIPListEntry:
+		return "IP list entry"
+	default:
+		return strings.ReplaceAll(objectType, "_", " ")
+	}
+}
+
+// RestoreBackup restores the objects included in the specified backup.
+// The objects are validated before saving any change and nothing is saved if
+// an object is not valid. The changes are saved within a single transaction if
+// supported by the data provider, otherwise the already saved changes are
+// reverted if an object cannot be saved
+func RestoreBackup(dump *BackupData, options RestoreOptions, executor, ipAddress, role string) (RestoreResults, error) {
+	results := RestoreResults{
+		ValidateOnly: options.ValidateOnly,
+	}
+	plan, err := newRestorePlan(dump, options.Mode, &results)
+	if err != nil {
+		return results, err
+	}
+	if err := plan.validate(); err != nil {
+		return results, err
+	}
+	if results.HasFailures() || options.ValidateOnly {
+		return results, nil
+	}
+	if err := provider.restore(plan); err != nil {
+		var restoreErr *restoreError
+		if !errors.As(err, &restoreErr) {
+			return results, err
+		}
+		providerLog(logger.LevelWarn, "restore failed, no changes applied: %v", err)
+		plan.setFailed(restoreErr.resultIdx, restoreErr.err)
+		return results, nil
+	}
+	plan.notify(executor, ipAddress, role)
+	results.Applied = true
+	providerLog(logger.LevelDebug, "backup restored, objects: %d", len(results.Results))
+	return results, nil
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+var (
+	revokedUserCerts atomic.Pointer[[]RevokedUserCert]
+	// serialize read-modify-write operations on the revoked certificates stored in configs
+	revokedUserCertsMu sync.Mutex
+)
+
+// RevokedUserCert defines a revoked SSH user certificate.
+// A certificate is revoked if the fingerprint of its key matches or if it is signed
+// by the specified CA, or by any CA if no CA is set, and the serial or the key ID match
+type RevokedUserCert struct {
+	// Unique identifier, generated on add
+	ID string `json:"id"`
+	// SHA256 fingerprint of the certified key
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// CA public key in authorized_keys format. Empty means any CA
+	CAKey string `json:"ca_key,omitempty"`
+	// Revoked serials range, inclusive. Certificates with serial 0 are never
+	// revoked by serial, it is the default if the CA does not specify a serial
+	SerialFrom uint64 `json:"serial_from,omitempty"`
+	SerialTo   uint64 `json:"serial_to,omitempty"`
+	// Revoked key ID
+	KeyID       string `json:"key_id,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	caKey     []byte
+}
+
+func (c *RevokedUserCert) validate() error {
+	c.Fingerprint = strings.TrimSpace(c.Fingerprint)
+	c.CAKey = strings.TrimSpace(c.CAKey)
+	c.KeyID = strings.TrimSpace(c.KeyID)
+	if c.SerialTo == 0 {
+		c.SerialTo = c.SerialFrom
+	}
+	if c.Fingerprint == "" && c.SerialFrom == 0 && c.KeyID == "" {
+		return util.NewValidationError("a fingerprint, a serial or a key ID is required")
+	}
+	if c.Fingerprint != "" && !strings.HasPrefix(c.Fingerprint, "SHA256:") {
+		return util.NewValidationError(fmt.Sprintf("invalid fingerprint %q, only SHA256 fingerprints are supported",
+			c.Fingerprint))
+	}
+	if c.SerialTo < c.SerialFrom {
+		return util.NewValidationError(fmt.Sprintf("invalid serial range %d-%d", c.SerialFrom, c.SerialTo))
+	}
+	if c.CAKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.CAKey)); err != nil { //nolint:dogsled
+			return util.NewValidationError(fmt.Sprintf("invalid CA key: %v", err))
+		}
+	}
+	return nil
+}
+
+func (c *RevokedUserCert) loadCAKey() {
+	if c.CAKey == "" {
+		return
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.CAKey)) //nolint:dogsled
+	if err != nil {
+		providerLog(logger.LevelError, "unable to parse CA key for revoked certificate %q: %v", c.ID, err)
+		return
+	}
+	c.caKey = key.Marshal()
+}
+
+// IsRevoked returns true if the specified certificate matches this revocation
+func (c *RevokedUserCert) IsRevoked(cert *ssh.Certificate) bool {
+	if c.Fingerprint != "" && c.Fingerprint == ssh.FingerprintSHA256(cert.Key) {
+		return true
+	}
+	if c.CAKey != "" && (len(c.caKey) == 0 || !bytes.Equal(c.caKey, cert.SignatureKey.Marshal())) {
+		return false
+	}
+	if c.KeyID != "" && c.KeyID == cert.KeyId {
+		return true
+	}
+	return c.SerialFrom > 0 && cert.Serial >= c.SerialFrom && cert.Serial <= c.SerialTo
+}
+
+func setRevokedUserCerts(certs []RevokedUserCert) {
+	result := make([]RevokedUserCert, 0, len(certs))
+	for _, c := range certs {
+		c.loadCAKey()
+		result = append(result, c)
+	}
+	revokedUserCerts.Store(&result)
+}
+
+// IsUserCertRevoked returns true if the specified SSH user certificate is revoked
+// by a revocation stored within the data provider
+func IsUserCertRevoked(cert *ssh.Certificate) bool {
+	certs := revokedUserCerts.Load()
+	if certs == nil {
+		return false
+	}
+	for idx := range *certs {
+		if (*certs)[idx].IsRevoked(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRevokedUserCerts returns the SSH user certificates revocations stored within the data provider
+func GetRevokedUserCerts() ([]RevokedUserCert, error) {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]RevokedUserCert, 0, len(configs.RevokedUserCerts))
+	return append(result, configs.RevokedUserCerts...), nil
+}
+
+// AddRevokedUserCert adds a new SSH user certificate revocation
+func AddRevokedUserCert(cert *RevokedUserCert, executor, ipAddress, role string) error {
+	if err := cert.validate(); err != nil {
+		return err
+	}
+	revokedUserCertsMu.Lock()
+	defer revokedUserCertsMu.Unlock()
+
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	cert.ID = util.GenerateUniqueID()
+	cert.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	configs.RevokedUserCerts = append(configs.RevokedUserCerts, *cert)
+	return UpdateConfigs(&configs, executor, ipAddress, role)
+}
+
+// DeleteRevokedUserCert deletes the SSH user certificate revocation with the specified ID
+func DeleteRevokedUserCert(id, executor, ipAddress, role string) error {
+	revokedUserCertsMu.Lock()
+	defer revokedUserCertsMu.Unlock()
+
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return err
+	}
+	for idx, c := range configs.RevokedUserCerts {
+		if c.ID == id {
+			configs.RevokedUserCerts = append(configs.RevokedUserCerts[:idx], configs.RevokedUserCerts[idx+1:]...)
+			return UpdateConfigs(&configs, executor, ipAddress, role)
+		}
+	}
+	return util.NewRecordNotFoundError(fmt.Sprintf("revoked certificate %q does not exist", id))
+}
@@ -39,6 +39,8 @@ type Role struct {
 	Admins []string `json:"admins,omitempty"`
 	// list of usernames associated with this role
 	Users []string `json:"users,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // RenderAsJSON implements the renderer interface used within plugins
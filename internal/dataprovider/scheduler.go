@@ -55,6 +55,16 @@ func startScheduler() error {
 	if err != nil {
 		return err
 	}
+	if config.IsShared == 1 {
+		_, err = scheduler.AddFunc(fmt.Sprintf("@every %ds", config.MetadataCacheSyncInterval), syncMetadataCaches)
+		if err != nil {
+			return fmt.Errorf("unable to schedule metadata caches sync: %w", err)
+		}
+	}
+	_, err = scheduler.AddFunc("@every 10m", removeIdleMetadataCaches)
+	if err != nil {
+		return fmt.Errorf("unable to schedule idle metadata caches removal: %w", err)
+	}
 	if currentNode != nil {
 		_, err = scheduler.AddFunc("@every 30m", func() {
 			err := provider.cleanupNodes()
@@ -68,6 +78,12 @@ func startScheduler() error {
 	if err != nil {
 		return fmt.Errorf("unable to schedule nodes cleanup: %w", err)
 	}
+	if config.ObjectHistory.Enabled && config.ObjectHistory.Retention > 0 {
+		_, err = scheduler.AddFunc("@every 1h", cleanupObjectHistory)
+		if err != nil {
+			return fmt.Errorf("unable to schedule object history cleanup: %w", err)
+		}
+	}
 	scheduler.Start()
 	return nil
 }
@@ -75,10 +91,16 @@ func startScheduler() error {
 func addScheduledCacheUpdates() error {
 	lastUserCacheUpdate.Store(util.GetTimeAsMsSinceEpoch(time.Now()))
 	lastIPListsCacheUpdate.Store(util.GetTimeAsMsSinceEpoch(time.Now()))
-	_, err := scheduler.AddFunc("@every 10m", checkCacheUpdates)
+	_, err := scheduler.AddFunc(GetCacheCheckInterval(), checkCacheUpdates)
 	if err != nil {
 		return fmt.Errorf("unable to schedule cache updates: %w", err)
 	}
+	if m := changeNotifications.Load(); m != nil && m.transport.hasOutbox() {
+		_, err = scheduler.AddFunc("@every 30m", cleanupChangeNotifications)
+		if err != nil {
+			return fmt.Errorf("unable to schedule change notifications cleanup: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -95,11 +117,15 @@ func checkDataprovider() {
 		providerLog(logger.LevelError, "check availability error: %v", err)
 	}
 	metric.UpdateDataProviderAvailability(err)
+	checkKMSMasterKey()
 }
 
 func checkCacheUpdates() {
 	checkUserCache()
 	checkIPListEntryCache()
+	if config.IsShared == 1 {
+		loadConfigs()
+	}
 	cachedUserPasswords.cleanup()
 	cachedAdminPasswords.cleanup()
 	cachedAPIKeys.cleanup()
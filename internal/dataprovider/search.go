@@ -0,0 +1,283 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+// the escape character used for SQL LIKE patterns, it is supported by all the
+// SQL providers without additional configuration
+const sqlLikeEscapeChar = "!"
+
+var sqlLikeReplacer = strings.NewReplacer(sqlLikeEscapeChar, sqlLikeEscapeChar+sqlLikeEscapeChar,
+	"%", sqlLikeEscapeChar+"%", "_", sqlLikeEscapeChar+"_")
+
+// SearchFilters defines the name based filters supported when listing objects.
+// The matches are case insensitive, empty values mean no filter
+type SearchFilters struct {
+	// Substring to search within the object name
+	Search string `json:"search,omitempty"`
+	// The object name must start with this prefix
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// IsEmpty returns true if no filter is set
+func (f *SearchFilters) IsEmpty() bool {
+	return f.Search == "" && f.Prefix == ""
+}
+
+func (f *SearchFilters) match(name string) bool {
+	name = strings.ToLower(name)
+	if f.Search != "" && !strings.Contains(name, strings.ToLower(f.Search)) {
+		return false
+	}
+	if f.Prefix != "" && !strings.HasPrefix(name, strings.ToLower(f.Prefix)) {
+		return false
+	}
+	return true
+}
+
+func (f *SearchFilters) getSQLConditions(field string, argsOffset int) ([]string, []any) {
+	var conditions []string
+	var args []any
+	if f.Search != "" {
+		conditions = append(conditions, getSQLLikeCondition(field, sqlPlaceholders[argsOffset+len(args)]))
+		args = append(args, "%"+sqlLikeReplacer.Replace(strings.ToLower(f.Search))+"%")
+	}
+	if f.Prefix != "" {
+		conditions = append(conditions, getSQLLikeCondition(field, sqlPlaceholders[argsOffset+len(args)]))
+		args = append(args, sqlLikeReplacer.Replace(strings.ToLower(f.Prefix))+"%")
+	}
+	return conditions, args
+}
+
+// FolderSearchFilters defines the supported filters when listing virtual folders
+type FolderSearchFilters struct {
+	SearchFilters
+	// Filesystem provider, nil means any provider
+	FsProvider *sdk.FilesystemProvider `json:"fs_provider,omitempty"`
+}
+
+func (f *FolderSearchFilters) match(folder *vfs.BaseVirtualFolder) bool {
+	if !f.SearchFilters.match(folder.Name) {
+		return false
+	}
+	if f.FsProvider != nil && folder.FsConfig.Provider != *f.FsProvider {
+		return false
+	}
+	return true
+}
+
+func (f *FolderSearchFilters) getSQLConditions(argsOffset int) ([]string, []any) {
+	conditions, args := f.SearchFilters.getSQLConditions("name", argsOffset)
+	if f.FsProvider != nil {
+		conditions = append(conditions, getSQLFsProviderCondition("filesystem", sqlPlaceholders[argsOffset+len(args)],
+			*f.FsProvider))
+		args = append(args, int(*f.FsProvider))
+	}
+	return conditions, args
+}
+
+// UserSearchFilters defines the supported filters when listing users.
+// The time based filters are expressed as unix timestamps in milliseconds,
+// 0 means no limit
+type UserSearchFilters struct {
+	SearchFilters
+	// 1 enabled, 0 disabled, nil means any status
+	Status *int `json:"status,omitempty"`
+	// Only users that are members of this group, any membership type
+	Group string `json:"group,omitempty"`
+	// Only users with this role
+	Role string `json:"role,omitempty"`
+	// Filesystem provider, nil means any provider
+	FsProvider *sdk.FilesystemProvider `json:"fs_provider,omitempty"`
+	// Users without an expiration date are excluded if an expiration limit is set
+	ExpirationFrom int64 `json:"expiration_from,omitempty"`
+	ExpirationTo   int64 `json:"expiration_to,omitempty"`
+	// Users that have never logged in have 0 as last login
+	LastLoginFrom int64 `json:"last_login_from,omitempty"`
+	LastLoginTo   int64 `json:"last_login_to,omitempty"`
+	// Only users whose quota usage, size or files, is greater than or equal to the
+	// specified percentage of their own quota limit. 0 means no filter
+	QuotaUsage int `json:"quota_usage,omitempty"`
+}
+
+// Validate returns an error if the filters are not valid
+func (f *UserSearchFilters) Validate() error {
+	if f.Status != nil && *f.Status != 0 && *f.Status != 1 {
+		return util.NewValidationError(fmt.Sprintf("invalid status filter: %d", *f.Status))
+	}
+	if f.FsProvider != nil && (*f.FsProvider < sdk.LocalFilesystemProvider || *f.FsProvider > vfs.OverlayFilesystemProvider) {
+		return util.NewValidationError(fmt.Sprintf("invalid filesystem provider filter: %d", *f.FsProvider))
+	}
+	if f.ExpirationFrom < 0 || f.ExpirationTo < 0 || f.LastLoginFrom < 0 || f.LastLoginTo < 0 {
+		return util.NewValidationError("time filters cannot be negative")
+	}
+	if f.ExpirationTo > 0 && f.ExpirationFrom > f.ExpirationTo {
+		return util.NewValidationError("invalid expiration range")
+	}
+	if f.LastLoginTo > 0 && f.LastLoginFrom > f.LastLoginTo {
+		return util.NewValidationError("invalid last login range")
+	}
+	if f.QuotaUsage < 0 {
+		return util.NewValidationError("quota usage filter cannot be negative")
+	}
+	return nil
+}
+
+func (f *UserSearchFilters) match(user *User) bool {
+	if !f.SearchFilters.match(user.Username) {
+		return false
+	}
+	if f.Status != nil && user.Status != *f.Status {
+		return false
+	}
+	if f.Role != "" && user.Role != f.Role {
+		return false
+	}
+	if f.FsProvider != nil && user.FsConfig.Provider != *f.FsProvider {
+		return false
+	}
+	if f.Group != "" && !f.matchGroup(user) {
+		return false
+	}
+	if f.ExpirationFrom > 0 || f.ExpirationTo > 0 {
+		if user.ExpirationDate == 0 || !isInTimeRange(user.ExpirationDate, f.ExpirationFrom, f.ExpirationTo) {
+			return false
+		}
+	}
+	if !isInTimeRange(user.LastLogin, f.LastLoginFrom, f.LastLoginTo) {
+		return false
+	}
+	if f.QuotaUsage > 0 {
+		return isQuotaUsageOver(user.UsedQuotaSize, user.QuotaSize, f.QuotaUsage) ||
+			isQuotaUsageOver(int64(user.UsedQuotaFiles), int64(user.QuotaFiles), f.QuotaUsage)
+	}
+	return true
+}
+
+func (f *UserSearchFilters) matchGroup(user *User) bool {
+	for _, g := range user.Groups {
+		if g.Name == f.Group {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *UserSearchFilters) getSQLConditions(argsOffset int) ([]string, []any) {
+	conditions, args := f.SearchFilters.getSQLConditions("u.username", argsOffset)
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, 0, len(values))
+		for range values {
+			placeholders = append(placeholders, sqlPlaceholders[argsOffset+len(args)+len(placeholders)])
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+		args = append(args, values...)
+	}
+	if f.Status != nil {
+		addCondition("u.status = %s", *f.Status)
+	}
+	if f.Role != "" {
+		addCondition("u.role_id IS NOT NULL AND r.name = %s", f.Role)
+	}
+	if f.FsProvider != nil {
+		conditions = append(conditions, getSQLFsProviderCondition("u.filesystem", sqlPlaceholders[argsOffset+len(args)],
+			*f.FsProvider))
+		args = append(args, int(*f.FsProvider))
+	}
+	if f.Group != "" {
+		addCondition(fmt.Sprintf("u.id IN (SELECT ug.user_id FROM %s ug INNER JOIN %s g ON g.id = ug.group_id WHERE g.name = %%s)",
+			sqlTableUsersGroupsMapping, getSQLQuotedName(sqlTableGroups)), f.Group)
+	}
+	if f.ExpirationFrom > 0 || f.ExpirationTo > 0 {
+		conditions = append(conditions, "u.expiration_date > 0")
+	}
+	if f.ExpirationFrom > 0 {
+		addCondition("u.expiration_date >= %s", f.ExpirationFrom)
+	}
+	if f.ExpirationTo > 0 {
+		addCondition("u.expiration_date <= %s", f.ExpirationTo)
+	}
+	if f.LastLoginFrom > 0 {
+		addCondition("u.last_login >= %s", f.LastLoginFrom)
+	}
+	if f.LastLoginTo > 0 {
+		addCondition("u.last_login <= %s", f.LastLoginTo)
+	}
+	if f.QuotaUsage > 0 {
+		addCondition("((u.quota_size > 0 AND u.used_quota_size * 100 >= u.quota_size * %s) OR "+
+			"(u.quota_files > 0 AND u.used_quota_files * 100 >= u.quota_files * %s))", f.QuotaUsage, f.QuotaUsage)
+	}
+	return conditions, args
+}
+
+func isInTimeRange(value, from, to int64) bool {
+	if from > 0 && value < from {
+		return false
+	}
+	if to > 0 && value > to {
+		return false
+	}
+	return true
+}
+
+func isQuotaUsageOver(used, limit int64, percentage int) bool {
+	if limit <= 0 {
+		return false
+	}
+	return used*100 >= limit*int64(percentage)
+}
+
+func getSQLLikeCondition(field, placeholder string) string {
+	return fmt.Sprintf("LOWER(%s) LIKE %s ESCAPE '%s'", field, placeholder, sqlLikeEscapeChar)
+}
+
+// the filesystem configuration is stored as JSON, the provider is extracted using
+// the JSON functions of each database. A missing configuration means local filesystem
+func getSQLFsProviderCondition(field, placeholder string, provider sdk.FilesystemProvider) string {
+	providerField := getSQLJSONIntField(field, "provider")
+	if provider == sdk.LocalFilesystemProvider {
+		return fmt.Sprintf("(%s IS NULL OR %s = %s)", field, providerField, placeholder)
+	}
+	return fmt.Sprintf("%s = %s", providerField, placeholder)
+}
+
+// getSQLJSONIntField returns the expression to extract the specified integer key
+// from a field containing a JSON object
+func getSQLJSONIntField(field, key string) string {
+	switch config.Driver {
+	case PGSQLDataProviderName, CockroachDataProviderName:
+		return fmt.Sprintf("CAST(CAST(%s AS JSONB)->>'%s' AS INTEGER)", field, key)
+	case MySQLDataProviderName:
+		return fmt.Sprintf("CAST(JSON_EXTRACT(%s, '$.%s') AS SIGNED)", field, key)
+	default:
+		return fmt.Sprintf("json_extract(%s, '$.%s')", field, key)
+	}
+}
+
+func getSQLWhereClause(conditions []string, prefix string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return prefix + strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,306 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// SecretsRotationResult defines the number of objects updated re-encrypting
+// their secrets using a new master key
+type SecretsRotationResult struct {
+	Users        int `json:"users"`
+	Folders      int `json:"folders"`
+	Groups       int `json:"groups"`
+	Admins       int `json:"admins"`
+	EventActions int `json:"event_actions"`
+	Configs      int `json:"configs"`
+}
+
+const (
+	kmsMasterKeySessionKey = "kms_master_key"
+)
+
+// kmsMasterKeySession signals to the other instances sharing the same data
+// provider that a master key rotation is in progress. Only the fingerprint of
+// the new master key is stored, the master key itself must be distributed to
+// each instance out-of-band
+type kmsMasterKeySession struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// RotateKMSMasterKey saves the specified master key, signals the rotation to
+// the other instances sharing the same data provider and then re-encrypts all
+// the stored secrets using it. The previous master key is kept to decrypt the
+// secrets not yet rotated, so the stored secrets can be decrypted even if the
+// rotation is interrupted and it can be safely repeated. The previous master
+// key is removed once all the secrets are re-encrypted
+func RotateKMSMasterKey(masterKey, executor, ipAddress string) (SecretsRotationResult, error) {
+	if err := kms.CanRotateMasterKey(); err != nil {
+		return SecretsRotationResult{}, util.NewValidationError(err.Error())
+	}
+	if err := publishKMSMasterKeyRotation(masterKey); err != nil {
+		return SecretsRotationResult{}, fmt.Errorf("unable to signal the master key rotation: %w", err)
+	}
+	if err := kms.RotateMasterKey(masterKey); err != nil {
+		return SecretsRotationResult{}, err
+	}
+	notifyChange(operationUpdate, changeNotificationObjectKMSMasterKey, "", nil)
+	result, err := ReEncryptSecrets(masterKey, executor, ipAddress)
+	if err != nil {
+		return result, err
+	}
+	if config.IsShared == 1 {
+		if err := provider.deleteSharedSession(kmsMasterKeySessionKey); err != nil && !errors.Is(err, util.ErrNotFound) {
+			return result, fmt.Errorf("unable to complete the master key rotation: %w", err)
+		}
+	}
+	if err := kms.ForgetPreviousMasterKeys(); err != nil {
+		return result, err
+	}
+	notifyChange(operationUpdate, changeNotificationObjectKMSMasterKey, "", nil)
+	return result, nil
+}
+
+func publishKMSMasterKeyRotation(masterKey string) error {
+	if config.IsShared != 1 {
+		return nil
+	}
+	return provider.addSharedSession(Session{
+		Key: kmsMasterKeySessionKey,
+		Data: kmsMasterKeySession{
+			Fingerprint: kms.GetMasterKeyFingerprint(masterKey),
+		},
+		Type:      SessionTypeKMSMasterKey,
+		Timestamp: util.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+}
+
+// checkKMSMasterKey applies the master key rotated by another instance sharing
+// the same data provider, reading it from the configured master key path, and
+// removes the previous master key once the rotation completes
+func checkKMSMasterKey() {
+	if config.IsShared != 1 || !kms.IsLocalProvider() {
+		return
+	}
+	session, err := provider.getSharedSession(kmsMasterKeySessionKey)
+	if err != nil {
+		if !errors.Is(err, util.ErrNotFound) && !errors.Is(err, sql.ErrNoRows) {
+			providerLog(logger.LevelError, "unable to check for a KMS master key rotation: %v", err)
+			return
+		}
+		// no rotation in progress, all the secrets use the current master key
+		if err := kms.ForgetPreviousMasterKeys(); err != nil {
+			providerLog(logger.LevelError, "unable to remove the previous KMS master key: %v", err)
+		}
+		return
+	}
+	var data kmsMasterKeySession
+	if err := unmarshalSessionData(session.Data, &data); err != nil || data.Fingerprint == "" {
+		providerLog(logger.LevelError, "unable to parse the KMS master key rotation: %v", err)
+		return
+	}
+	if data.Fingerprint == kms.GetCurrentMasterKeyFingerprint() {
+		return
+	}
+	if err := kms.ReloadMasterKey(data.Fingerprint); err != nil {
+		providerLog(logger.LevelError, "KMS master key rotated by another instance, unable to load it: %v. "+
+			"Copy the new master key to the configured master key path", err)
+		return
+	}
+	providerLog(logger.LevelInfo, "KMS master key rotated by another instance")
+}
+
+func unmarshalSessionData(data any, result any) error {
+	switch v := data.(type) {
+	case []byte:
+		return json.Unmarshal(v, result)
+	case string:
+		return json.Unmarshal([]byte(v), result)
+	default:
+		return fmt.Errorf("unexpected session data type %T", data)
+	}
+}
+
+// ReEncryptSecrets decrypts all the secrets stored within the data provider
+// and encrypts them again using the specified master key.
+// Objects are updated one at a time and the secrets already encrypted using the
+// new master key are skipped, so an interrupted rotation can be safely repeated.
+// The master key is not saved, use RotateKMSMasterKey to rotate it.
+// Cluster nodes data are not rotated, they are refreshed on each startup
+func ReEncryptSecrets(masterKey, executor, ipAddress string) (SecretsRotationResult, error) {
+	var result SecretsRotationResult
+
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return result, fmt.Errorf("unable to get users: %w", err)
+	}
+	for idx := range users {
+		user := &users[idx]
+		updated, err := reEncryptSecrets(masterKey, user.getSecrets())
+		if err != nil {
+			return result, fmt.Errorf("unable to re-encrypt secrets for user %q: %w", user.Username, err)
+		}
+		if updated {
+			if err := UpdateUser(user, executor, ipAddress, ""); err != nil {
+				return result, fmt.Errorf("unable to update user %q: %w", user.Username, err)
+			}
+			result.Users++
+		}
+	}
+	folders, err := provider.dumpFolders()
+	if err != nil {
+		return result, fmt.Errorf("unable to get folders: %w", err)
+	}
+	for idx := range folders {
+		folder := &folders[idx]
+		updated, err := reEncryptSecrets(masterKey, folder.FsConfig.GetSecrets())
+		if err != nil {
+			return result, fmt.Errorf("unable to re-encrypt secrets for folder %q: %w", folder.Name, err)
+		}
+		if updated {
+			if err := UpdateFolder(folder, folder.Users, folder.Groups, executor, ipAddress, ""); err != nil {
+				return result, fmt.Errorf("unable to update folder %q: %w", folder.Name, err)
+			}
+			result.Folders++
+		}
+	}
+	groups, err := provider.dumpGroups()
+	if err != nil {
+		return result, fmt.Errorf("unable to get groups: %w", err)
+	}
+	for idx := range groups {
+		group := &groups[idx]
+		updated, err := reEncryptSecrets(masterKey, group.UserSettings.FsConfig.GetSecrets())
+		if err != nil {
+			return result, fmt.Errorf("unable to re-encrypt secrets for group %q: %w", group.Name, err)
+		}
+		if updated {
+			if err := UpdateGroup(group, group.Users, executor, ipAddress, ""); err != nil {
+				return result, fmt.Errorf("unable to update group %q: %w", group.Name, err)
+			}
+			result.Groups++
+		}
+	}
+	if err := reEncryptAdminsSecrets(masterKey, executor, ipAddress, &result); err != nil {
+		return result, err
+	}
+	if err := reEncryptEventActionsSecrets(masterKey, executor, ipAddress, &result); err != nil {
+		return result, err
+	}
+	if err := reEncryptConfigsSecrets(masterKey, executor, ipAddress, &result); err != nil {
+		return result, err
+	}
+	providerLog(logger.LevelInfo, "secrets re-encrypted using the new master key: %+v", result)
+	return result, nil
+}
+
+func reEncryptAdminsSecrets(masterKey, executor, ipAddress string, result *SecretsRotationResult) error {
+	admins, err := provider.dumpAdmins()
+	if err != nil {
+		return fmt.Errorf("unable to get admins: %w", err)
+	}
+	for idx := range admins {
+		admin := &admins[idx]
+		secrets := []*kms.Secret{admin.Filters.TOTPConfig.Secret}
+		for _, code := range admin.Filters.RecoveryCodes {
+			secrets = append(secrets, code.Secret)
+		}
+		updated, err := reEncryptSecrets(masterKey, secrets)
+		if err != nil {
+			return fmt.Errorf("unable to re-encrypt secrets for admin %q: %w", admin.Username, err)
+		}
+		if updated {
+			if err := UpdateAdmin(admin, executor, ipAddress, ""); err != nil {
+				return fmt.Errorf("unable to update admin %q: %w", admin.Username, err)
+			}
+			result.Admins++
+		}
+	}
+	return nil
+}
+
+func reEncryptEventActionsSecrets(masterKey, executor, ipAddress string, result *SecretsRotationResult) error {
+	actions, err := provider.dumpEventActions()
+	if err != nil {
+		return fmt.Errorf("unable to get event actions: %w", err)
+	}
+	for idx := range actions {
+		action := &actions[idx]
+		updated, err := reEncryptSecrets(masterKey, []*kms.Secret{action.Options.HTTPConfig.Password,
+			action.Options.BackupConfig.Passphrase})
+		if err != nil {
+			return fmt.Errorf("unable to re-encrypt secrets for event action %q: %w", action.Name, err)
+		}
+		if updated {
+			if err := UpdateEventAction(action, executor, ipAddress, ""); err != nil {
+				return fmt.Errorf("unable to update event action %q: %w", action.Name, err)
+			}
+			result.EventActions++
+		}
+	}
+	return nil
+}
+
+func reEncryptConfigsSecrets(masterKey, executor, ipAddress string, result *SecretsRotationResult) error {
+	configs, err := provider.getConfigs()
+	if err != nil {
+		return fmt.Errorf("unable to get configs: %w", err)
+	}
+	var secrets []*kms.Secret
+	if configs.SMTP != nil {
+		secrets = append(secrets, configs.SMTP.Password)
+	}
+	if configs.RADIUS != nil {
+		secrets = append(secrets, configs.RADIUS.Secret)
+	}
+	updated, err := reEncryptSecrets(masterKey, secrets)
+	if err != nil {
+		return fmt.Errorf("unable to re-encrypt secrets for configs: %w", err)
+	}
+	if updated {
+		if err := UpdateConfigs(&configs, executor, ipAddress, ""); err != nil {
+			return fmt.Errorf("unable to update configs: %w", err)
+		}
+		result.Configs++
+	}
+	return nil
+}
+
+// reEncryptSecrets returns true if at least one of the given secrets was re-encrypted
+func reEncryptSecrets(masterKey string, secrets []*kms.Secret) (bool, error) {
+	var updated bool
+	for _, secret := range secrets {
+		if secret == nil {
+			continue
+		}
+		changed, err := secret.ReEncrypt(masterKey)
+		if err != nil {
+			return false, err
+		}
+		if changed {
+			updated = true
+		}
+	}
+	return updated, nil
+}
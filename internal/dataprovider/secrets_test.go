@@ -0,0 +1,97 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+func TestKMSMasterKeyRotationFromAnotherInstance(t *testing.T) {
+	conf := getTestProviderConf(t)
+	initializeTestProvider(t, conf)
+	config.IsShared = 1
+	masterKeyPath := filepath.Join(t.TempDir(), "master_key")
+	err := os.WriteFile(masterKeyPath, []byte("old master key"), 0600)
+	require.NoError(t, err)
+	kmsConfig := kms.Configuration{
+		Secrets: kms.Secrets{
+			MasterKeyPath: masterKeyPath,
+		},
+	}
+	err = kmsConfig.Initialize()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		config.IsShared = 0
+		kmsConfig := kms.Configuration{}
+		err := kmsConfig.Initialize()
+		assert.NoError(t, err)
+	})
+
+	secret := kms.NewPlainSecret("payload")
+	err = secret.Encrypt()
+	require.NoError(t, err)
+	secretAsJSON, err := json.Marshal(secret)
+	require.NoError(t, err)
+	decryptSecret := func() error {
+		var s kms.Secret
+		if err := json.Unmarshal(secretAsJSON, &s); err != nil {
+			return err
+		}
+		return s.Decrypt()
+	}
+	// another instance starts the rotation, only the fingerprint of the new
+	// master key is stored within the data provider
+	err = publishKMSMasterKeyRotation("new master key")
+	require.NoError(t, err)
+	session, err := provider.getSharedSession(kmsMasterKeySessionKey)
+	require.NoError(t, err)
+	assert.NotContains(t, string(session.Data.([]byte)), "new master key")
+	var data kmsMasterKeySession
+	err = unmarshalSessionData(session.Data, &data)
+	require.NoError(t, err)
+	assert.Equal(t, kms.GetMasterKeyFingerprint("new master key"), data.Fingerprint)
+	// the new master key is not yet available on this instance
+	checkKMSMasterKey()
+	assert.Equal(t, kms.GetMasterKeyFingerprint("old master key"), kms.GetCurrentMasterKeyFingerprint())
+	assert.NoFileExists(t, masterKeyPath+".previous")
+	// a different master key is refused
+	err = os.WriteFile(masterKeyPath, []byte("wrong master key"), 0600)
+	require.NoError(t, err)
+	checkKMSMasterKey()
+	assert.Equal(t, kms.GetMasterKeyFingerprint("old master key"), kms.GetCurrentMasterKeyFingerprint())
+	// the new master key is distributed out-of-band
+	err = os.WriteFile(masterKeyPath, []byte("new master key"), 0600)
+	require.NoError(t, err)
+	checkKMSMasterKey()
+	assert.Equal(t, kms.GetMasterKeyFingerprint("new master key"), kms.GetCurrentMasterKeyFingerprint())
+	content, err := os.ReadFile(masterKeyPath + ".previous")
+	require.NoError(t, err)
+	assert.Equal(t, "old master key", string(content))
+	assert.NoError(t, decryptSecret())
+	// the rotation completes, the previous master key is removed
+	err = provider.deleteSharedSession(kmsMasterKeySessionKey)
+	require.NoError(t, err)
+	checkKMSMasterKey()
+	assert.NoFileExists(t, masterKeyPath+".previous")
+	assert.Error(t, decryptSecret())
+}
@@ -73,6 +73,8 @@ type Share struct {
 	// otherwise we fail to restore existing shares and we have to insert
 	// all the previous values with no modifications
 	IsRestore bool `json:"-"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // GetScopeAsString returns the share's scope as string.
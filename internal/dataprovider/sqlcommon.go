@@ -35,7 +35,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 28
+	sqlDatabaseVersion     = 31
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -82,6 +82,8 @@ func sqlReplaceAll(sql string) string {
 	sql = strings.ReplaceAll(sql, "{{roles}}", sqlTableRoles)
 	sql = strings.ReplaceAll(sql, "{{ip_lists}}", sqlTableIPLists)
 	sql = strings.ReplaceAll(sql, "{{configs}}", sqlTableConfigs)
+	sql = strings.ReplaceAll(sql, "{{change_notifications}}", sqlTableChangeNotifications)
+	sql = strings.ReplaceAll(sql, "{{object_history}}", sqlTableObjectHistory)
 	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
 	return sql
 }
@@ -109,7 +111,14 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 		return err
 	}
 
-	user, err := provider.userExists(share.Username, "")
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonAddShareWithQuerier(ctx, share, dbHandle)
+}
+
+func sqlCommonAddShareWithQuerier(ctx context.Context, share *Share, dbHandle sqlQuerier) error {
+	user, err := sqlCommonGetUserByUsername(share.Username, "", dbHandle)
 	if err != nil {
 		return util.NewGenericError(fmt.Sprintf("unable to validate user %q", share.Username))
 	}
@@ -126,9 +135,6 @@ func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
 	q := getAddShareQuery()
 	usedTokens := 0
 	createdAt := util.GetTimeAsMsSinceEpoch(time.Now())
@@ -156,6 +162,15 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateShareInTx(ctx, share, tx)
+	})
+}
+
+func sqlCommonUpdateShareInTx(ctx context.Context, share *Share, tx *sql.Tx) error {
 	paths, err := json.Marshal(share.Paths)
 	if err != nil {
 		return err
@@ -169,14 +184,11 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		}
 	}
 
-	user, err := provider.userExists(share.Username, "")
+	user, err := sqlCommonGetUserByUsername(share.Username, "", tx)
 	if err != nil {
 		return util.NewGenericError(fmt.Sprintf("unable to validate user %q", share.Username))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
 	var q string
 	if share.IsRestore {
 		q = getUpdateShareRestoreQuery()
@@ -184,6 +196,11 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		q = getUpdateShareQuery()
 	}
 
+	version, err := sqlCommonCheckObjectVersion(ctx, share.ExpectedVersion, sqlTableShares, []string{"share_id"}, tx,
+		share.ShareID)
+	if err != nil {
+		return err
+	}
 	var res sql.Result
 	if share.IsRestore {
 		if share.CreatedAt == 0 {
@@ -192,12 +209,12 @@ func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
 		if share.UpdatedAt == 0 {
 			share.UpdatedAt = share.CreatedAt
 		}
-		res, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
+		res, err = tx.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
 			share.CreatedAt, share.UpdatedAt, share.LastUseAt, share.ExpiresAt, share.Password, share.MaxTokens,
 			share.UsedTokens, allowFrom, user.ID, share.ShareID)
 	} else {
-		res, err = dbHandle.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
-			util.GetTimeAsMsSinceEpoch(time.Now()), share.ExpiresAt, share.Password, share.MaxTokens,
+		res, err = tx.ExecContext(ctx, q, share.Name, share.Description, share.Scope, paths,
+			version, share.ExpiresAt, share.Password, share.MaxTokens,
 			allowFrom, user.ID, share.ShareID)
 	}
 	if err != nil {
@@ -210,21 +227,33 @@ func sqlCommonDeleteShare(share Share, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteShareQuery()
-	res, err := dbHandle.ExecContext(ctx, q, share.ShareID)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, share.ExpectedVersion, sqlTableShares, []string{"share_id"}, tx,
+			share.ShareID)
+		if err != nil {
+			return err
+		}
+		q := getDeleteShareQuery()
+		res, err := tx.ExecContext(ctx, q, share.ShareID)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
-func sqlCommonGetShares(limit, offset int, order, username string, dbHandle sqlQuerier) ([]Share, error) {
+func sqlCommonGetShares(limit, offset int, order, username string, filters SearchFilters, dbHandle sqlQuerier) ([]Share, error) {
 	shares := make([]Share, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getSharesQuery(order)
-	rows, err := dbHandle.QueryContext(ctx, q, username, limit, offset)
+	conditions, filterArgs := filters.getSQLConditions("s.name", 1)
+	q := getSharesQuery(order, conditions, len(filterArgs))
+	args := make([]any, 0, len(filterArgs)+3)
+	args = append(args, username)
+	args = append(args, filterArgs...)
+	args = append(args, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return shares, err
 	}
@@ -243,12 +272,15 @@ func sqlCommonGetShares(limit, offset int, order, username string, dbHandle sqlQ
 }
 
 func sqlCommonDumpShares(dbHandle sqlQuerier) ([]Share, error) {
-	shares := make([]Share, 0, 30)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpSharesQuery()
-	rows, err := dbHandle.QueryContext(ctx, q)
+	return sqlCommonQueryDumpShares(ctx, dbHandle, getDumpSharesQuery())
+}
+
+func sqlCommonQueryDumpShares(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return shares, err
 	}
@@ -285,14 +317,18 @@ func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 		return err
 	}
 
-	userID, adminID, err := sqlCommonGetAPIKeyRelatedIDs(apiKey)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonAddAPIKeyWithQuerier(ctx, apiKey, dbHandle)
+}
+
+func sqlCommonAddAPIKeyWithQuerier(ctx context.Context, apiKey *APIKey, dbHandle sqlQuerier) error {
+	userID, adminID, err := sqlCommonGetAPIKeyRelatedIDs(apiKey, dbHandle)
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
 	q := getAddAPIKeyQuery()
 	_, err = dbHandle.ExecContext(ctx, q, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope,
 		util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.LastUseAt,
@@ -306,17 +342,27 @@ func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
 		return err
 	}
 
-	userID, adminID, err := sqlCommonGetAPIKeyRelatedIDs(apiKey)
-	if err != nil {
-		return err
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateAPIKeyInTx(ctx, apiKey, tx)
+	})
+}
+
+func sqlCommonUpdateAPIKeyInTx(ctx context.Context, apiKey *APIKey, tx *sql.Tx) error {
+	userID, adminID, err := sqlCommonGetAPIKeyRelatedIDs(apiKey, tx)
+	if err != nil {
+		return err
+	}
+	version, err := sqlCommonCheckObjectVersion(ctx, apiKey.ExpectedVersion, sqlTableAPIKeys, []string{"key_id"}, tx,
+		apiKey.KeyID)
+	if err != nil {
+		return err
+	}
 	q := getUpdateAPIKeyQuery()
-	res, err := dbHandle.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
-		apiKey.Description, util.GetTimeAsMsSinceEpoch(time.Now()), apiKey.KeyID)
+	res, err := tx.ExecContext(ctx, q, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, userID, adminID,
+		apiKey.Description, version, apiKey.KeyID)
 	if err != nil {
 		return err
 	}
@@ -327,12 +373,19 @@ func sqlCommonDeleteAPIKey(apiKey APIKey, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteAPIKeyQuery()
-	res, err := dbHandle.ExecContext(ctx, q, apiKey.KeyID)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, apiKey.ExpectedVersion, sqlTableAPIKeys, []string{"key_id"}, tx,
+			apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		q := getDeleteAPIKeyQuery()
+		res, err := tx.ExecContext(ctx, q, apiKey.KeyID)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
 func sqlCommonGetAPIKeys(limit, offset int, order string, dbHandle sqlQuerier) ([]APIKey, error) {
@@ -368,12 +421,15 @@ func sqlCommonGetAPIKeys(limit, offset int, order string, dbHandle sqlQuerier) (
 }
 
 func sqlCommonDumpAPIKeys(dbHandle sqlQuerier) ([]APIKey, error) {
-	apiKeys := make([]APIKey, 0, 30)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpAPIKeysQuery()
-	rows, err := dbHandle.QueryContext(ctx, q)
+	return sqlCommonQueryDumpAPIKeys(ctx, dbHandle, getDumpAPIKeysQuery())
+}
+
+func sqlCommonQueryDumpAPIKeys(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return apiKeys, err
 	}
@@ -428,6 +484,15 @@ func sqlCommonAddAdmin(admin *Admin, dbHandle *sql.DB) error {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonAddAdminInTx(ctx, admin, tx)
+	})
+}
+
+func sqlCommonAddAdminInTx(ctx context.Context, admin *Admin, tx *sql.Tx) error {
 	perms, err := json.Marshal(admin.Permissions)
 	if err != nil {
 		return err
@@ -438,63 +503,74 @@ func sqlCommonAddAdmin(admin *Admin, dbHandle *sql.DB) error {
 		return err
 	}
 
+	q := getAddAdminQuery(admin.Role)
+	_, err = tx.ExecContext(ctx, q, admin.Username, admin.Password, admin.Status, admin.Email, perms,
+		filters, admin.AdditionalInfo, admin.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
+		util.GetTimeAsMsSinceEpoch(time.Now()), admin.Role)
+	if err != nil {
+		return err
+	}
+	return generateAdminGroupMapping(ctx, admin, tx)
+}
+
+func sqlCommonUpdateAdmin(admin *Admin, dbHandle *sql.DB) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getAddAdminQuery(admin.Role)
-		_, err = tx.ExecContext(ctx, q, admin.Username, admin.Password, admin.Status, admin.Email, perms,
-			filters, admin.AdditionalInfo, admin.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), admin.Role)
-		if err != nil {
-			return err
-		}
-		return generateAdminGroupMapping(ctx, admin, tx)
+		return sqlCommonUpdateAdminInTx(ctx, admin, tx)
 	})
 }
 
-func sqlCommonUpdateAdmin(admin *Admin, dbHandle *sql.DB) error {
-	err := admin.validate()
+func sqlCommonUpdateAdminInTx(ctx context.Context, admin *Admin, tx *sql.Tx) error {
+	perms, err := json.Marshal(admin.Permissions)
 	if err != nil {
 		return err
 	}
 
-	perms, err := json.Marshal(admin.Permissions)
+	filters, err := json.Marshal(admin.Filters)
 	if err != nil {
 		return err
 	}
 
-	filters, err := json.Marshal(admin.Filters)
+	version, err := sqlCommonCheckObjectVersion(ctx, admin.ExpectedVersion, sqlTableAdmins, []string{"username"}, tx,
+		admin.Username)
+	if err != nil {
+		return err
+	}
+	q := getUpdateAdminQuery(admin.Role)
+	_, err = tx.ExecContext(ctx, q, admin.Password, admin.Status, admin.Email, perms, filters,
+		admin.AdditionalInfo, admin.Description, version, admin.Role, admin.Username)
 	if err != nil {
 		return err
 	}
+	return generateAdminGroupMapping(ctx, admin, tx)
+}
 
+func sqlCommonDeleteAdmin(admin Admin, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getUpdateAdminQuery(admin.Role)
-		_, err = tx.ExecContext(ctx, q, admin.Password, admin.Status, admin.Email, perms, filters,
-			admin.AdditionalInfo, admin.Description, util.GetTimeAsMsSinceEpoch(time.Now()), admin.Role, admin.Username)
+		_, err := sqlCommonCheckObjectVersion(ctx, admin.ExpectedVersion, sqlTableAdmins, []string{"username"}, tx,
+			admin.Username)
 		if err != nil {
 			return err
 		}
-		return generateAdminGroupMapping(ctx, admin, tx)
+		q := getDeleteAdminQuery()
+		res, err := tx.ExecContext(ctx, q, admin.Username)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
 	})
 }
 
-func sqlCommonDeleteAdmin(admin Admin, dbHandle *sql.DB) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
-	q := getDeleteAdminQuery()
-	res, err := dbHandle.ExecContext(ctx, q, admin.Username)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
-}
-
 func sqlCommonGetAdmins(limit, offset int, order string, dbHandle sqlQuerier) ([]Admin, error) {
 	admins := make([]Admin, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
@@ -523,12 +599,15 @@ func sqlCommonGetAdmins(limit, offset int, order string, dbHandle sqlQuerier) ([
 }
 
 func sqlCommonDumpAdmins(dbHandle sqlQuerier) ([]Admin, error) {
-	admins := make([]Admin, 0, 30)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpAdminsQuery()
-	rows, err := dbHandle.QueryContext(ctx, q)
+	return sqlCommonQueryDumpAdmins(ctx, dbHandle, getDumpAdminsQuery())
+}
+
+func sqlCommonQueryDumpAdmins(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return admins, err
 	}
@@ -566,13 +645,15 @@ func sqlCommonDumpIPListEntries(dbHandle *sql.DB) ([]IPListEntry, error) {
 		providerLog(logger.LevelInfo, "IP lists excluded from dump, too many entries: %d", count)
 		return nil, nil
 	}
-	entries := make([]IPListEntry, 0, 100)
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpListEntriesQuery()
+	return sqlCommonQueryDumpIPListEntries(ctx, dbHandle, getDumpListEntriesQuery())
+}
 
-	rows, err := dbHandle.QueryContext(ctx, q)
+func sqlCommonQueryDumpIPListEntries(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]IPListEntry, error) {
+	entries := make([]IPListEntry, 0, 100)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return entries, err
 	}
@@ -712,6 +793,15 @@ func sqlCommonAddIPListEntry(entry *IPListEntry, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	if config.IsShared == 1 {
+		return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+			return sqlCommonAddIPListEntryWithQuerier(ctx, entry, tx)
+		})
+	}
+	return sqlCommonAddIPListEntryWithQuerier(ctx, entry, dbHandle)
+}
+
+func sqlCommonAddIPListEntryWithQuerier(ctx context.Context, entry *IPListEntry, dbHandle sqlQuerier) error {
 	var err error
 	q := getAddIPListEntryQuery()
 	first := entry.getFirst()
@@ -723,22 +813,10 @@ func sqlCommonAddIPListEntry(entry *IPListEntry, dbHandle *sql.DB) error {
 		netType = ipTypeV6
 	}
 	if config.IsShared == 1 {
-		return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-			_, err := tx.ExecContext(ctx, getRemoveSoftDeletedIPListEntryQuery(), entry.Type, entry.IPOrNet)
-			if err != nil {
-				return err
-			}
-			if config.Driver == PGSQLDataProviderName || config.Driver == CockroachDataProviderName {
-				_, err = tx.ExecContext(ctx, q, entry.Type, entry.IPOrNet, first.String(), last.String(),
-					netType, entry.Protocols, entry.Description, entry.Mode, util.GetTimeAsMsSinceEpoch(time.Now()),
-					util.GetTimeAsMsSinceEpoch(time.Now()))
-			} else {
-				_, err = tx.ExecContext(ctx, q, entry.Type, entry.IPOrNet, entry.First, entry.Last,
-					netType, entry.Protocols, entry.Description, entry.Mode, util.GetTimeAsMsSinceEpoch(time.Now()),
-					util.GetTimeAsMsSinceEpoch(time.Now()))
-			}
+		_, err = dbHandle.ExecContext(ctx, getRemoveSoftDeletedIPListEntryQuery(), entry.Type, entry.IPOrNet)
+		if err != nil {
 			return err
-		})
+		}
 	}
 	if config.Driver == PGSQLDataProviderName || config.Driver == CockroachDataProviderName {
 		_, err = dbHandle.ExecContext(ctx, q, entry.Type, entry.IPOrNet, first.String(), last.String(),
@@ -759,9 +837,20 @@ func sqlCommonUpdateIPListEntry(entry *IPListEntry, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateIPListEntryInTx(ctx, entry, tx)
+	})
+}
+
+func sqlCommonUpdateIPListEntryInTx(ctx context.Context, entry *IPListEntry, tx *sql.Tx) error {
+	version, err := sqlCommonCheckObjectVersion(ctx, entry.ExpectedVersion, sqlTableIPLists, []string{"type", "ipornet"}, tx,
+		entry.Type, entry.IPOrNet)
+	if err != nil {
+		return err
+	}
 	q := getUpdateIPListEntryQuery()
-	res, err := dbHandle.ExecContext(ctx, q, entry.Mode, entry.Protocols, entry.Description,
-		util.GetTimeAsMsSinceEpoch(time.Now()), entry.Type, entry.IPOrNet)
+	res, err := tx.ExecContext(ctx, q, entry.Mode, entry.Protocols, entry.Description,
+		version, entry.Type, entry.IPOrNet)
 	if err != nil {
 		return err
 	}
@@ -772,18 +861,25 @@ func sqlCommonDeleteIPListEntry(entry IPListEntry, softDelete bool, dbHandle *sq
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteIPListEntryQuery(softDelete)
-	var args []any
-	if softDelete {
-		ts := util.GetTimeAsMsSinceEpoch(time.Now())
-		args = append(args, ts, ts)
-	}
-	args = append(args, entry.Type, entry.IPOrNet)
-	res, err := dbHandle.ExecContext(ctx, q, args...)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, entry.ExpectedVersion, sqlTableIPLists, []string{"type", "ipornet"}, tx,
+			entry.Type, entry.IPOrNet)
+		if err != nil {
+			return err
+		}
+		q := getDeleteIPListEntryQuery(softDelete)
+		var args []any
+		if softDelete {
+			ts := util.GetTimeAsMsSinceEpoch(time.Now())
+			args = append(args, ts, ts)
+		}
+		args = append(args, entry.Type, entry.IPOrNet)
+		res, err := tx.ExecContext(ctx, q, args...)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
 func sqlCommonGetRoleByName(name string, dbHandle sqlQuerier) (Role, error) {
@@ -872,6 +968,10 @@ func sqlCommonAddRole(role *Role, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	return sqlCommonAddRoleWithQuerier(ctx, role, dbHandle)
+}
+
+func sqlCommonAddRoleWithQuerier(ctx context.Context, role *Role, dbHandle sqlQuerier) error {
 	q := getAddRoleQuery()
 	_, err := dbHandle.ExecContext(ctx, q, role.Name, role.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
 		util.GetTimeAsMsSinceEpoch(time.Now()))
@@ -885,8 +985,19 @@ func sqlCommonUpdateRole(role *Role, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateRoleInTx(ctx, role, tx)
+	})
+}
+
+func sqlCommonUpdateRoleInTx(ctx context.Context, role *Role, tx *sql.Tx) error {
+	version, err := sqlCommonCheckObjectVersion(ctx, role.ExpectedVersion, sqlTableRoles, []string{"name"}, tx,
+		role.Name)
+	if err != nil {
+		return err
+	}
 	q := getUpdateRoleQuery()
-	res, err := dbHandle.ExecContext(ctx, q, role.Description, util.GetTimeAsMsSinceEpoch(time.Now()), role.Name)
+	res, err := tx.ExecContext(ctx, q, role.Description, version, role.Name)
 	if err != nil {
 		return err
 	}
@@ -897,12 +1008,19 @@ func sqlCommonDeleteRole(role Role, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteRoleQuery()
-	res, err := dbHandle.ExecContext(ctx, q, role.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, role.ExpectedVersion, sqlTableRoles, []string{"name"}, tx,
+			role.Name)
+		if err != nil {
+			return err
+		}
+		q := getDeleteRoleQuery()
+		res, err := tx.ExecContext(ctx, q, role.Name)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
 func sqlCommonGetGroupByName(name string, dbHandle sqlQuerier) (Group, error) {
@@ -928,13 +1046,15 @@ func sqlCommonGetGroupByName(name string, dbHandle sqlQuerier) (Group, error) {
 }
 
 func sqlCommonDumpGroups(dbHandle sqlQuerier) ([]Group, error) {
-	groups := make([]Group, 0, 50)
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpGroupsQuery()
+	return sqlCommonQueryDumpGroups(ctx, dbHandle, getDumpGroupsQuery())
+}
 
-	rows, err := dbHandle.QueryContext(ctx, q)
+func sqlCommonQueryDumpGroups(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]Group, error) {
+	groups := make([]Group, 0, 50)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return groups, err
 	}
@@ -1018,14 +1138,17 @@ func sqlCommonGetGroupsWithNames(names []string, dbHandle sqlQuerier) ([]Group,
 	return getGroupsWithVirtualFolders(ctx, groups, dbHandle)
 }
 
-func sqlCommonGetGroups(limit int, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]Group, error) {
+func sqlCommonGetGroups(limit int, offset int, order string, minimal bool, filters SearchFilters,
+	dbHandle sqlQuerier,
+) ([]Group, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getGroupsQuery(order, minimal)
+	conditions, args := filters.getSQLConditions("name", 0)
+	q := getGroupsQuery(order, minimal, conditions, len(args))
 
 	groups := make([]Group, 0, limit)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	rows, err := dbHandle.QueryContext(ctx, q, append(args, limit, offset)...)
 	if err != nil {
 		return groups, err
 	}
@@ -1072,66 +1195,105 @@ func sqlCommonAddGroup(group *Group, dbHandle *sql.DB) error {
 	if err := group.validate(); err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonAddGroupInTx(ctx, group, tx)
+	})
+}
+
+func sqlCommonAddGroupInTx(ctx context.Context, group *Group, tx *sql.Tx) error {
 	settings, err := json.Marshal(group.UserSettings)
 	if err != nil {
 		return err
 	}
+	q := getAddGroupQuery()
+	_, err = tx.ExecContext(ctx, q, group.Name, group.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
+		util.GetTimeAsMsSinceEpoch(time.Now()), settings)
+	if err != nil {
+		return err
+	}
+	return generateGroupVirtualFoldersMapping(ctx, group, tx)
+}
+
+func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
+	if err := group.validate(); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getAddGroupQuery()
-		_, err := tx.ExecContext(ctx, q, group.Name, group.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), settings)
-		if err != nil {
-			return err
-		}
-		return generateGroupVirtualFoldersMapping(ctx, group, tx)
+		return sqlCommonUpdateGroupInTx(ctx, group, tx)
 	})
 }
 
-func sqlCommonUpdateGroup(group *Group, dbHandle *sql.DB) error {
-	if err := group.validate(); err != nil {
-		return err
+func sqlCommonUpdateGroups(groups []*Group, dbHandle *sql.DB) error {
+	for _, group := range groups {
+		if err := group.validate(); err != nil {
+			return newBulkUpdateError(group.Name, err)
+		}
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		for _, group := range groups {
+			if err := sqlCommonUpdateGroupInTx(ctx, group, tx); err != nil {
+				return newBulkUpdateError(group.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonUpdateGroupInTx(ctx context.Context, group *Group, tx *sql.Tx) error {
 	settings, err := json.Marshal(group.UserSettings)
 	if err != nil {
 		return err
 	}
+	version, err := sqlCommonCheckObjectVersion(ctx, group.ExpectedVersion, sqlTableGroups, []string{"name"}, tx, group.Name)
+	if err != nil {
+		return err
+	}
+	q := getUpdateGroupQuery()
+	_, err = tx.ExecContext(ctx, q, group.Description, settings, version, group.Name)
+	if err != nil {
+		return err
+	}
+	return generateGroupVirtualFoldersMapping(ctx, group, tx)
+}
+
+func sqlCommonDeleteGroup(group Group, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getUpdateGroupQuery()
-		_, err := tx.ExecContext(ctx, q, group.Description, settings, util.GetTimeAsMsSinceEpoch(time.Now()), group.Name)
+		_, err := sqlCommonCheckObjectVersion(ctx, group.ExpectedVersion, sqlTableGroups, []string{"name"}, tx,
+			group.Name)
+		if err != nil {
+			return err
+		}
+		q := getDeleteGroupQuery()
+		res, err := tx.ExecContext(ctx, q, group.Name)
 		if err != nil {
 			return err
 		}
-		return generateGroupVirtualFoldersMapping(ctx, group, tx)
+		return sqlCommonRequireRowAffected(res)
 	})
 }
 
-func sqlCommonDeleteGroup(group Group, dbHandle *sql.DB) error {
+func sqlCommonGetUserByUsername(username, role string, dbHandle sqlQuerier) (User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteGroupQuery()
-	res, err := dbHandle.ExecContext(ctx, q, group.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
-}
-
-func sqlCommonGetUserByUsername(username, role string, dbHandle sqlQuerier) (User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
-	q := getUserByUsernameQuery(role)
-	args := []any{username}
-	if role != "" {
-		args = append(args, role)
+	q := getUserByUsernameQuery(role)
+	args := []any{username}
+	if role != "" {
+		args = append(args, role)
 	}
 	row := dbHandle.QueryRowContext(ctx, q, args...)
 	user, err := getUserFromDbRow(row)
@@ -1167,17 +1329,17 @@ func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *
 	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 }
 
-func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bool, dbHandle *sql.DB) (User, string, error) {
+func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, ip string, isSSHCert bool, dbHandle *sql.DB) (User, PublicKey, error) {
 	var user User
 	if len(pubKey) == 0 {
-		return user, "", errors.New("credentials cannot be null or empty")
+		return user, PublicKey{}, errors.New("credentials cannot be null or empty")
 	}
 	user, err := sqlCommonGetUserByUsername(username, "", dbHandle)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error authenticating user %q: %v", username, err)
-		return user, "", err
+		return user, PublicKey{}, err
 	}
-	return checkUserAndPubKey(&user, pubKey, isSSHCert)
+	return checkUserAndPubKey(&user, pubKey, ip, isSSHCert)
 }
 
 func sqlCommonCheckAvailability(dbHandle *sql.DB) (err error) {
@@ -1339,6 +1501,15 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonAddUserInTx(ctx, user, tx)
+	})
+}
+
+func sqlCommonAddUserInTx(ctx context.Context, user *User, tx *sql.Tx) error {
 	permissions, err := user.GetPermissionsAsJSON()
 	if err != nil {
 		return err
@@ -1355,42 +1526,108 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	if config.IsShared == 1 {
+		_, err := tx.ExecContext(ctx, getRemoveSoftDeletedUserQuery(), user.Username)
+		if err != nil {
+			return err
+		}
+	}
+	q := getAddUserQuery(user.Role)
+	_, err = tx.ExecContext(ctx, q, user.Username, user.Password, publicKeys, user.HomeDir, user.UID, user.GID,
+		user.MaxSessions, user.QuotaSize, user.QuotaFiles, permissions, user.UploadBandwidth,
+		user.DownloadBandwidth, user.Status, user.ExpirationDate, filters, fsConfig, user.AdditionalInfo,
+		user.Description, user.Email, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()),
+		user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer, user.Role, user.LastPasswordChange)
+	if err != nil {
+		return err
+	}
+	if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
+		return err
+	}
+	return generateUserGroupMapping(ctx, user, tx)
+}
+
+func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		if config.IsShared == 1 {
-			_, err := tx.ExecContext(ctx, getRemoveSoftDeletedUserQuery(), user.Username)
-			if err != nil {
-				return err
-			}
+	q := getUpdateUserPasswordQuery()
+	res, err := dbHandle.ExecContext(ctx, q, password, username)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
+func sqlCommonUpdateUserPublicKeyLastUse(username, fingerprint string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	err := sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		filters, err := sqlCommonGetUserFiltersForUpdate(ctx, username, tx)
+		if err != nil {
+			return err
 		}
-		q := getAddUserQuery(user.Role)
-		_, err := tx.ExecContext(ctx, q, user.Username, user.Password, publicKeys, user.HomeDir, user.UID, user.GID,
-			user.MaxSessions, user.QuotaSize, user.QuotaFiles, permissions, user.UploadBandwidth,
-			user.DownloadBandwidth, user.Status, user.ExpirationDate, filters, fsConfig, user.AdditionalInfo,
-			user.Description, user.Email, util.GetTimeAsMsSinceEpoch(time.Now()), util.GetTimeAsMsSinceEpoch(time.Now()),
-			user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer, user.Role, user.LastPasswordChange)
+		if filters.PublicKeysLastUse == nil {
+			filters.PublicKeysLastUse = make(map[string]int64)
+		}
+		filters.PublicKeysLastUse[fingerprint] = util.GetTimeAsMsSinceEpoch(time.Now())
+		rawFilters, err := json.Marshal(filters)
 		if err != nil {
 			return err
 		}
-		if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
+		res, err := tx.ExecContext(ctx, getUpdateUserFiltersQuery(), string(rawFilters), username)
+		if err != nil {
 			return err
 		}
-		return generateUserGroupMapping(ctx, user, tx)
+		return sqlCommonRequireRowAffected(res)
 	})
+	if err == nil {
+		providerLog(logger.LevelDebug, "last use updated for public key %q, user %q", fingerprint, username)
+	}
+	return err
 }
 
-func sqlCommonUpdateUserPassword(username, password string, dbHandle *sql.DB) error {
+func sqlCommonUpdateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState,
+	dbHandle *sql.DB,
+) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUpdateUserPasswordQuery()
-	res, err := dbHandle.ExecContext(ctx, q, password, username)
-	if err != nil {
-		return err
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		filters, err := sqlCommonGetUserFiltersForUpdate(ctx, username, tx)
+		if err != nil {
+			return err
+		}
+		if err := checkCryptFsReEncryptionRunID(filters.CryptFsReEncryption, expectedRunID); err != nil {
+			return err
+		}
+		filters.CryptFsReEncryption = state
+		rawFilters, err := json.Marshal(filters)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, getUpdateUserFiltersQuery(), string(rawFilters), username)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
+}
+
+// sqlCommonGetUserFiltersForUpdate returns the stored user filters and locks the user row
+// until the transaction ends
+func sqlCommonGetUserFiltersForUpdate(ctx context.Context, username string, tx *sql.Tx) (UserFilters, error) {
+	var filters UserFilters
+	var rawFilters []byte
+	if err := tx.QueryRowContext(ctx, getUserFiltersForUpdateQuery(), username).Scan(&rawFilters); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return filters, util.NewRecordNotFoundError(fmt.Sprintf("username %q does not exist", username))
+		}
+		return filters, err
 	}
-	return sqlCommonRequireRowAffected(res)
+	err := json.Unmarshal(rawFilters, &filters)
+	return filters, err
 }
 
 func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
@@ -1399,6 +1636,43 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 		return err
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateUserInTx(ctx, user, tx)
+	})
+}
+
+func sqlCommonUpdateUsers(users []*User, dbHandle *sql.DB) error {
+	for _, user := range users {
+		if err := ValidateUser(user); err != nil {
+			return newBulkUpdateError(user.Username, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		for _, user := range users {
+			if err := sqlCommonUpdateUserInTx(ctx, user, tx); err != nil {
+				return newBulkUpdateError(user.Username, err)
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonUpdateUserInTx(ctx context.Context, user *User, tx *sql.Tx) error {
+	// the public keys last use and the CryptFs re-encryption state are updated without
+	// changing the user version, lock the row and keep the stored values
+	storedFilters, err := sqlCommonGetUserFiltersForUpdate(ctx, user.Username, tx)
+	if err != nil {
+		return err
+	}
+	user.mergePublicKeysLastUse(storedFilters.PublicKeysLastUse)
+	user.Filters.CryptFsReEncryption = storedFilters.CryptFsReEncryption
 	permissions, err := user.GetPermissionsAsJSON()
 	if err != nil {
 		return err
@@ -1415,27 +1689,26 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
-	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getUpdateUserQuery(user.Role)
-		res, err := tx.ExecContext(ctx, q, user.Password, publicKeys, user.HomeDir, user.UID, user.GID, user.MaxSessions,
-			user.QuotaSize, user.QuotaFiles, permissions, user.UploadBandwidth, user.DownloadBandwidth, user.Status,
-			user.ExpirationDate, filters, fsConfig, user.AdditionalInfo, user.Description, user.Email,
-			util.GetTimeAsMsSinceEpoch(time.Now()), user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer,
-			user.Role, user.LastPasswordChange, user.Username)
-		if err != nil {
-			return err
-		}
-		if err := sqlCommonRequireRowAffected(res); err != nil {
-			return err
-		}
-		if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
-			return err
-		}
-		return generateUserGroupMapping(ctx, user, tx)
-	})
+	version, err := sqlCommonCheckObjectVersion(ctx, user.ExpectedVersion, sqlTableUsers, []string{"username"}, tx, user.Username)
+	if err != nil {
+		return err
+	}
+	q := getUpdateUserQuery(user.Role)
+	res, err := tx.ExecContext(ctx, q, user.Password, publicKeys, user.HomeDir, user.UID, user.GID, user.MaxSessions,
+		user.QuotaSize, user.QuotaFiles, permissions, user.UploadBandwidth, user.DownloadBandwidth, user.Status,
+		user.ExpirationDate, filters, fsConfig, user.AdditionalInfo, user.Description, user.Email,
+		version, user.UploadDataTransfer, user.DownloadDataTransfer, user.TotalDataTransfer,
+		user.Role, user.LastPasswordChange, user.Username)
+	if err != nil {
+		return err
+	}
+	if err := sqlCommonRequireRowAffected(res); err != nil {
+		return err
+	}
+	if err := generateUserVirtualFoldersMapping(ctx, user, tx); err != nil {
+		return err
+	}
+	return generateUserGroupMapping(ctx, user, tx)
 }
 
 func sqlCommonDeleteUser(user User, softDelete bool, dbHandle *sql.DB) error {
@@ -1443,8 +1716,13 @@ func sqlCommonDeleteUser(user User, softDelete bool, dbHandle *sql.DB) error {
 	defer cancel()
 
 	q := getDeleteUserQuery(softDelete)
-	if softDelete {
-		return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, user.ExpectedVersion, sqlTableUsers, []string{"username"}, tx,
+			user.Username)
+		if err != nil {
+			return err
+		}
+		if softDelete {
 			if err := sqlCommonClearUserFolderMapping(ctx, &user, tx); err != nil {
 				return err
 			}
@@ -1457,22 +1735,56 @@ func sqlCommonDeleteUser(user User, softDelete bool, dbHandle *sql.DB) error {
 				return err
 			}
 			return sqlCommonRequireRowAffected(res)
-		})
-	}
-	res, err := dbHandle.ExecContext(ctx, q, user.Username)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+		}
+		res, err := tx.ExecContext(ctx, q, user.Username)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
 func sqlCommonDumpUsers(dbHandle sqlQuerier) ([]User, error) {
-	users := make([]User, 0, 100)
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpUsersQuery()
-	rows, err := dbHandle.QueryContext(ctx, q)
+	return sqlCommonQueryDumpUsers(ctx, dbHandle, getDumpUsersQuery())
+}
+
+func sqlCommonDumpPage(scope, after string, limit int, dbHandle sqlQuerier) (BackupData, error) {
+	data := BackupData{
+		Version: DumpVersion,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getDumpPageQuery(scope)
+	var err error
+	switch scope {
+	case DumpScopeUsers:
+		data.Users, err = sqlCommonQueryDumpUsers(ctx, dbHandle, q, after, limit)
+	case DumpScopeFolders:
+		data.Folders, err = sqlCommonQueryDumpFolders(ctx, dbHandle, q, after, limit)
+	case DumpScopeGroups:
+		data.Groups, err = sqlCommonQueryDumpGroups(ctx, dbHandle, q, after, limit)
+	case DumpScopeAdmins:
+		data.Admins, err = sqlCommonQueryDumpAdmins(ctx, dbHandle, q, after, limit)
+	case DumpScopeAPIKeys:
+		data.APIKeys, err = sqlCommonQueryDumpAPIKeys(ctx, dbHandle, q, after, limit)
+	case DumpScopeShares:
+		data.Shares, err = sqlCommonQueryDumpShares(ctx, dbHandle, q, after, limit)
+	case DumpScopeIPLists:
+		listType, ipOrNet := getIPListEntryFromKey(after)
+		data.IPLists, err = sqlCommonQueryDumpIPListEntries(ctx, dbHandle, q, listType, listType, ipOrNet, limit)
+	default:
+		err = fmt.Errorf("unsupported dump scope %q", scope)
+	}
+	return data, err
+}
+
+func sqlCommonQueryDumpUsers(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]User, error) {
+	users := make([]User, 0, 100)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return users, err
 	}
@@ -1729,19 +2041,14 @@ func sqlCommonGetActiveTransfers(from time.Time, dbHandle sqlQuerier) ([]ActiveT
 	return transfers, rows.Err()
 }
 
-func sqlCommonGetUsers(limit int, offset int, order, role string, dbHandle sqlQuerier) ([]User, error) {
+func sqlCommonGetUsers(limit int, offset int, order string, filters UserSearchFilters, dbHandle sqlQuerier) ([]User, error) {
 	users := make([]User, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getUsersQuery(order, role)
-	var args []any
-	if role == "" {
-		args = append(args, limit, offset)
-	} else {
-		args = append(args, role, limit, offset)
-	}
-	rows, err := dbHandle.QueryContext(ctx, q, args...)
+	conditions, args := filters.getSQLConditions(0)
+	q := getUsersQuery(order, conditions, len(args))
+	rows, err := dbHandle.QueryContext(ctx, q, append(args, limit, offset)...)
 	if err != nil {
 		return users, err
 	}
@@ -2086,7 +2393,7 @@ func getEventActionFromDbRow(row sqlScanner) (BaseEventAction, error) {
 	var description sql.NullString
 	var options []byte
 
-	err := row.Scan(&action.ID, &action.Name, &description, &action.Type, &options)
+	err := row.Scan(&action.ID, &action.Name, &description, &action.Type, &options, &action.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return action, util.NewRecordNotFoundError(err.Error())
@@ -2259,7 +2566,7 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	var mappedPath, description sql.NullString
 	var fsConfig []byte
 	err := row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &folder.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return folder, util.NewRecordNotFoundError(err.Error())
@@ -2311,7 +2618,7 @@ func sqlCommonAddOrUpdateFolder(ctx context.Context, baseFolder *vfs.BaseVirtual
 	}
 	q := getUpsertFolderQuery()
 	_, err = dbHandle.ExecContext(ctx, q, baseFolder.MappedPath, usedQuotaSize, usedQuotaFiles,
-		lastQuotaUpdate, baseFolder.Name, baseFolder.Description, fsConfig)
+		lastQuotaUpdate, baseFolder.Name, baseFolder.Description, fsConfig, util.GetTimeAsMsSinceEpoch(time.Now()))
 	return err
 }
 
@@ -2320,58 +2627,104 @@ func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) erro
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonAddFolderWithQuerier(ctx, folder, dbHandle)
+}
+
+func sqlCommonAddFolderWithQuerier(ctx context.Context, folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
 	fsConfig, err := json.Marshal(folder.FsConfig)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
 	q := getAddFolderQuery()
 	_, err = dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
-		folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig)
+		folder.LastQuotaUpdate, folder.Name, folder.Description, fsConfig, util.GetTimeAsMsSinceEpoch(time.Now()))
 	return err
 }
 
-func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
+func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle *sql.DB) error {
 	err := ValidateFolder(folder)
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateFolderWithQuerier(ctx, folder, tx)
+	})
+}
+
+func sqlCommonUpdateFolders(folders []*vfs.BaseVirtualFolder, dbHandle *sql.DB) error {
+	for _, folder := range folders {
+		if err := ValidateFolder(folder); err != nil {
+			return newBulkUpdateError(folder.Name, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		for _, folder := range folders {
+			if err := sqlCommonUpdateFolderWithQuerier(ctx, folder, tx); err != nil {
+				return newBulkUpdateError(folder.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonUpdateFolderWithQuerier(ctx context.Context, folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
 	fsConfig, err := json.Marshal(folder.FsConfig)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
+	version, err := sqlCommonCheckObjectVersion(ctx, folder.ExpectedVersion, sqlTableFolders, []string{"name"}, dbHandle,
+		folder.Name)
+	if err != nil {
+		return err
+	}
 	q := getUpdateFolderQuery()
-	res, err := dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.Description, fsConfig, folder.Name)
+	res, err := dbHandle.ExecContext(ctx, q, folder.MappedPath, folder.Description, fsConfig,
+		version, folder.Name)
 	if err != nil {
 		return err
 	}
 	return sqlCommonRequireRowAffected(res)
 }
 
-func sqlCommonDeleteFolder(folder vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
+func sqlCommonDeleteFolder(folder vfs.BaseVirtualFolder, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getDeleteFolderQuery()
-	res, err := dbHandle.ExecContext(ctx, q, folder.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, folder.ExpectedVersion, sqlTableFolders, []string{"name"}, tx,
+			folder.Name)
+		if err != nil {
+			return err
+		}
+		q := getDeleteFolderQuery()
+		res, err := tx.ExecContext(ctx, q, folder.Name)
+		if err != nil {
+			return err
+		}
+		return sqlCommonRequireRowAffected(res)
+	})
 }
 
 func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
-	folders := make([]vfs.BaseVirtualFolder, 0, 50)
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
 
-	q := getDumpFoldersQuery()
-	rows, err := dbHandle.QueryContext(ctx, q)
+	return sqlCommonQueryDumpFolders(ctx, dbHandle, getDumpFoldersQuery())
+}
+
+func sqlCommonQueryDumpFolders(ctx context.Context, dbHandle sqlQuerier, q string, args ...any) ([]vfs.BaseVirtualFolder, error) {
+	folders := make([]vfs.BaseVirtualFolder, 0, 50)
+	rows, err := dbHandle.QueryContext(ctx, q, args...)
 	if err != nil {
 		return folders, err
 	}
@@ -2381,7 +2734,7 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 		var mappedPath, description sql.NullString
 		var fsConfig []byte
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &folder.UpdatedAt)
 		if err != nil {
 			return folders, err
 		}
@@ -2401,13 +2754,16 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	return folders, rows.Err()
 }
 
-func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error) {
+func sqlCommonGetFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters,
+	dbHandle sqlQuerier,
+) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
-	q := getFoldersQuery(order, minimal)
-	rows, err := dbHandle.QueryContext(ctx, q, limit, offset)
+	conditions, args := filters.getSQLConditions(0)
+	q := getFoldersQuery(order, minimal, conditions, len(args))
+	rows, err := dbHandle.QueryContext(ctx, q, append(args, limit, offset)...)
 	if err != nil {
 		return folders, err
 	}
@@ -2423,7 +2779,7 @@ func sqlCommonGetFolders(limit, offset int, order string, minimal bool, dbHandle
 			var mappedPath, description sql.NullString
 			var fsConfig []byte
 			err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+				&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &folder.UpdatedAt)
 			if err != nil {
 				return folders, err
 			}
@@ -3188,10 +3544,10 @@ func getRelatedValuesForAPIKeys(ctx context.Context, apiKeys []APIKey, dbHandle
 	return apiKeys, nil
 }
 
-func sqlCommonGetAPIKeyRelatedIDs(apiKey *APIKey) (sql.NullInt64, sql.NullInt64, error) {
+func sqlCommonGetAPIKeyRelatedIDs(apiKey *APIKey, dbHandle sqlQuerier) (sql.NullInt64, sql.NullInt64, error) {
 	var userID, adminID sql.NullInt64
 	if apiKey.User != "" {
-		u, err := provider.userExists(apiKey.User, "")
+		u, err := sqlCommonGetUserByUsername(apiKey.User, "", dbHandle)
 		if err != nil {
 			return userID, adminID, util.NewGenericError(fmt.Sprintf("unable to validate user %v", apiKey.User))
 		}
@@ -3199,7 +3555,7 @@ func sqlCommonGetAPIKeyRelatedIDs(apiKey *APIKey) (sql.NullInt64, sql.NullInt64,
 		userID.Int64 = u.ID
 	}
 	if apiKey.Admin != "" {
-		a, err := provider.adminExists(apiKey.Admin)
+		a, err := sqlCommonGetAdminByUsername(apiKey.Admin, dbHandle)
 		if err != nil {
 			return userID, adminID, util.NewValidationError(fmt.Sprintf("unable to validate admin %v", apiKey.Admin))
 		}
@@ -3240,6 +3596,30 @@ func sqlCommonGetSession(key string, dbHandle sqlQuerier) (Session, error) {
 	return session, nil
 }
 
+func sqlCommonGetSessionsUpdatedAfter(sessionType SessionType, after int64, dbHandle sqlQuerier) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getSessionsUpdatedAfterQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, sessionType, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		var data []byte
+		if err := rows.Scan(&session.Key, &data, &session.Type, &session.Timestamp); err != nil {
+			return nil, err
+		}
+		session.Data = data
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
 func sqlCommonDeleteSession(key string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3461,12 +3841,17 @@ func sqlCommonAddEventAction(action *BaseEventAction, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
+	return sqlCommonAddEventActionWithQuerier(ctx, action, dbHandle)
+}
+
+func sqlCommonAddEventActionWithQuerier(ctx context.Context, action *BaseEventAction, dbHandle sqlQuerier) error {
 	q := getAddEventActionQuery()
 	options, err := json.Marshal(action.Options)
 	if err != nil {
 		return err
 	}
-	_, err = dbHandle.ExecContext(ctx, q, action.Name, action.Description, action.Type, options)
+	_, err = dbHandle.ExecContext(ctx, q, action.Name, action.Description, action.Type, options,
+		util.GetTimeAsMsSinceEpoch(time.Now()))
 	return err
 }
 
@@ -3474,40 +3859,57 @@ func sqlCommonUpdateEventAction(action *BaseEventAction, dbHandle *sql.DB) error
 	if err := action.validate(); err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return sqlCommonUpdateEventActionInTx(ctx, action, tx)
+	})
+}
+
+func sqlCommonUpdateEventActionInTx(ctx context.Context, action *BaseEventAction, tx *sql.Tx) error {
 	options, err := json.Marshal(action.Options)
 	if err != nil {
 		return err
 	}
+	version, err := sqlCommonCheckObjectVersion(ctx, action.ExpectedVersion, sqlTableEventsActions, []string{"name"}, tx,
+		action.Name)
+	if err != nil {
+		return err
+	}
+	q := getUpdateEventActionQuery()
+	res, err := tx.ExecContext(ctx, q, action.Description, action.Type, options,
+		version, action.Name)
+	if err != nil {
+		return err
+	}
+	if err := sqlCommonRequireRowAffected(res); err != nil {
+		return err
+	}
+	q = getUpdateRulesTimestampQuery()
+	_, err = tx.ExecContext(ctx, q, util.GetTimeAsMsSinceEpoch(time.Now()), action.Name)
+	return err
+}
+
+func sqlCommonDeleteEventAction(action BaseEventAction, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getUpdateEventActionQuery()
-		res, err := tx.ExecContext(ctx, q, action.Description, action.Type, options, action.Name)
+		_, err := sqlCommonCheckObjectVersion(ctx, action.ExpectedVersion, sqlTableEventsActions, []string{"name"}, tx,
+			action.Name)
 		if err != nil {
 			return err
 		}
-		if err := sqlCommonRequireRowAffected(res); err != nil {
+		q := getDeleteEventActionQuery()
+		res, err := tx.ExecContext(ctx, q, action.Name)
+		if err != nil {
 			return err
 		}
-		q = getUpdateRulesTimestampQuery()
-		_, err = tx.ExecContext(ctx, q, util.GetTimeAsMsSinceEpoch(time.Now()), action.Name)
-		return err
+		return sqlCommonRequireRowAffected(res)
 	})
 }
 
-func sqlCommonDeleteEventAction(action BaseEventAction, dbHandle *sql.DB) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
-	q := getDeleteEventActionQuery()
-	res, err := dbHandle.ExecContext(ctx, q, action.Name)
-	if err != nil {
-		return err
-	}
-	return sqlCommonRequireRowAffected(res)
-}
-
 func sqlCommonGetEventRuleByName(name string, dbHandle sqlQuerier) (EventRule, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3618,57 +4020,75 @@ func sqlCommonAddEventRule(rule *EventRule, dbHandle *sql.DB) error {
 	if err := rule.validate(); err != nil {
 		return err
 	}
-	conditions, err := json.Marshal(rule.Conditions)
-	if err != nil {
-		return err
-	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		if config.IsShared == 1 {
-			_, err := tx.ExecContext(ctx, getRemoveSoftDeletedRuleQuery(), rule.Name)
-			if err != nil {
-				return err
-			}
-		}
-		q := getAddEventRuleQuery()
-		_, err := tx.ExecContext(ctx, q, rule.Name, rule.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			util.GetTimeAsMsSinceEpoch(time.Now()), rule.Trigger, conditions, rule.Status)
+		return sqlCommonAddEventRuleInTx(ctx, rule, tx)
+	})
+}
+
+func sqlCommonAddEventRuleInTx(ctx context.Context, rule *EventRule, tx *sql.Tx) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return err
+	}
+	if config.IsShared == 1 {
+		_, err := tx.ExecContext(ctx, getRemoveSoftDeletedRuleQuery(), rule.Name)
 		if err != nil {
 			return err
 		}
-		return generateEventRuleActionsMapping(ctx, rule, tx)
-	})
+	}
+	q := getAddEventRuleQuery()
+	_, err = tx.ExecContext(ctx, q, rule.Name, rule.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
+		util.GetTimeAsMsSinceEpoch(time.Now()), rule.Trigger, conditions, rule.Status)
+	if err != nil {
+		return err
+	}
+	return generateEventRuleActionsMapping(ctx, rule, tx)
 }
 
 func sqlCommonUpdateEventRule(rule *EventRule, dbHandle *sql.DB) error {
 	if err := rule.validate(); err != nil {
 		return err
 	}
-	conditions, err := json.Marshal(rule.Conditions)
-	if err != nil {
-		return err
-	}
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
-		q := getUpdateEventRuleQuery()
-		_, err := tx.ExecContext(ctx, q, rule.Description, util.GetTimeAsMsSinceEpoch(time.Now()),
-			rule.Trigger, conditions, rule.Status, rule.Name)
-		if err != nil {
-			return err
-		}
-		return generateEventRuleActionsMapping(ctx, rule, tx)
+		return sqlCommonUpdateEventRuleInTx(ctx, rule, tx)
 	})
 }
 
+func sqlCommonUpdateEventRuleInTx(ctx context.Context, rule *EventRule, tx *sql.Tx) error {
+	conditions, err := json.Marshal(rule.Conditions)
+	if err != nil {
+		return err
+	}
+	version, err := sqlCommonCheckObjectVersion(ctx, rule.ExpectedVersion, sqlTableEventsRules, []string{"name"}, tx,
+		rule.Name)
+	if err != nil {
+		return err
+	}
+	q := getUpdateEventRuleQuery()
+	_, err = tx.ExecContext(ctx, q, rule.Description, version,
+		rule.Trigger, conditions, rule.Status, rule.Name)
+	if err != nil {
+		return err
+	}
+	return generateEventRuleActionsMapping(ctx, rule, tx)
+}
+
 func sqlCommonDeleteEventRule(rule EventRule, softDelete bool, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 
 	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		_, err := sqlCommonCheckObjectVersion(ctx, rule.ExpectedVersion, sqlTableEventsRules, []string{"name"}, tx,
+			rule.Name)
+		if err != nil {
+			return err
+		}
 		if softDelete {
 			q := getClearRuleActionMappingQuery()
 			_, err := tx.ExecContext(ctx, q, rule.Name)
@@ -3851,6 +4271,172 @@ func sqlCommonCleanupNodes(dbHandle *sql.DB) error {
 	return err
 }
 
+func sqlCommonAddChangeNotification(notification *ChangeNotification, dbHandle *sql.DB) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getAddChangeNotificationQuery()
+	_, err = dbHandle.ExecContext(ctx, q, notification.Instance, string(payload), notification.Timestamp)
+	return err
+}
+
+func sqlCommonGetChangeNotifications(after int64, dbHandle *sql.DB) ([]ChangeNotification, error) {
+	var notifications []ChangeNotification
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getChangeNotificationsQuery()
+	rows, err := dbHandle.QueryContext(ctx, q, after, changeNotificationsInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var notification ChangeNotification
+		var id int64
+		var payload []byte
+
+		if err := rows.Scan(&id, &payload); err != nil {
+			return notifications, err
+		}
+		if err := json.Unmarshal(payload, &notification); err != nil {
+			providerLog(logger.LevelWarn, "unable to decode change notification with id %d: %v", id, err)
+			continue
+		}
+		notification.ID = id
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+func sqlCommonCleanupChangeNotifications(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupChangeNotificationsQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonAddObjectVersion(version *ObjectVersion, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getAddObjectVersionQuery()
+		_, err := tx.ExecContext(ctx, q, version.ObjectType, version.ObjectName, version.Action, version.Executor,
+			version.IP, version.Role, string(version.Data), version.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if config.ObjectHistory.MaxVersions <= 0 {
+			return nil
+		}
+		var maxID int64
+		q = getObjectVersionsLimitQuery()
+		err = tx.QueryRowContext(ctx, q, version.ObjectType, version.ObjectName, config.ObjectHistory.MaxVersions).Scan(&maxID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		q = getDeleteObjectVersionsQuery()
+		_, err = tx.ExecContext(ctx, q, version.ObjectType, version.ObjectName, maxID)
+		return err
+	})
+}
+
+func sqlCommonGetObjectVersions(objectType, objectName string, limit, offset int, order string,
+	dbHandle sqlQuerier,
+) ([]ObjectVersion, error) {
+	versions := make([]ObjectVersion, 0, limit)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getObjectVersionsQuery(order)
+	rows, err := dbHandle.QueryContext(ctx, q, objectType, objectName, limit, offset)
+	if err != nil {
+		return versions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version ObjectVersion
+		err = rows.Scan(&version.ID, &version.ObjectType, &version.ObjectName, &version.Action, &version.Executor,
+			&version.IP, &version.Role, &version.CreatedAt)
+		if err != nil {
+			return versions, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func sqlCommonGetObjectVersion(objectType, objectName string, id int64, previous bool,
+	dbHandle sqlQuerier,
+) (ObjectVersion, error) {
+	var version ObjectVersion
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getObjectVersionQuery()
+	if previous {
+		q = getPreviousObjectVersionQuery()
+	}
+	var data []byte
+	err := dbHandle.QueryRowContext(ctx, q, id, objectType, objectName).Scan(&version.ID, &version.ObjectType,
+		&version.ObjectName, &version.Action, &version.Executor, &version.IP, &version.Role, &version.CreatedAt, &data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return version, util.NewRecordNotFoundError(fmt.Sprintf("version %d for %s %q not found", id,
+				objectType, objectName))
+		}
+		return version, err
+	}
+	version.Data = data
+	return version, nil
+}
+
+func sqlCommonCleanupObjectHistory(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	q := getCleanupObjectHistoryQuery()
+	_, err := dbHandle.ExecContext(ctx, q, before)
+	return err
+}
+
+func sqlCommonRestoreTimestamps(timestamps *objectTimestamps, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	fields, values := timestamps.getFields()
+	q, err := getRestoreTimestampsQuery(timestamps.ObjectType, fields)
+	if err != nil {
+		return err
+	}
+	args := make([]any, 0, len(values)+2)
+	for _, val := range values {
+		args = append(args, val)
+	}
+	if timestamps.ObjectType == actionObjectIPListEntry {
+		args = append(args, timestamps.ListType)
+	}
+	args = append(args, timestamps.Name)
+	res, err := dbHandle.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	return sqlCommonRequireRowAffected(res)
+}
+
 func sqlCommonGetConfigs(dbHandle sqlQuerier) (Configs, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -3870,13 +4456,17 @@ func sqlCommonSetConfigs(configs *Configs, dbHandle *sql.DB) error {
 	if err := configs.validate(); err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonSetConfigsWithQuerier(ctx, configs, dbHandle)
+}
+
+func sqlCommonSetConfigsWithQuerier(ctx context.Context, configs *Configs, dbHandle sqlQuerier) error {
 	asJSON, err := json.Marshal(configs)
 	if err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
-	defer cancel()
-
 	q := getUpdateConfigsQuery()
 	res, err := dbHandle.ExecContext(ctx, q, asJSON)
 	if err != nil {
@@ -3913,6 +4503,39 @@ func sqlCommonRequireRowAffected(res sql.Result) error {
 	return nil
 }
 
+// sqlCommonCheckObjectVersion compares and bumps the object version, the update
+// time, within a single statement. The updated row stays locked until the
+// transaction ends, so concurrent updates based on the same version fail.
+// It returns the update time to set for the object
+func sqlCommonCheckObjectVersion(ctx context.Context, expected int64, table string, keyFields []string,
+	tx sqlQuerier, keys ...any,
+) (int64, error) {
+	version := getNextObjectVersion(expected)
+	if expected <= 0 {
+		return version, nil
+	}
+	q := getCompareAndBumpObjectVersionQuery(table, keyFields)
+	args := append([]any{version, expected}, keys...)
+	res, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return version, err
+	}
+	if err := sqlCommonRequireRowAffected(res); err == nil {
+		return version, nil
+	}
+	// no row updated, the object does not exist or its version changed
+	var updatedAt int64
+	q = getObjectUpdatedAtQuery(table, keyFields)
+	err = tx.QueryRowContext(ctx, q, keys...).Scan(&updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return version, util.NewRecordNotFoundError(err.Error())
+		}
+		return version, err
+	}
+	return version, ErrVersionConflict
+}
+
 func sqlCommonUpdateDatabaseVersion(ctx context.Context, dbHandle sqlQuerier, version int) error {
 	q := getUpdateDBVersionQuery()
 	_, err := dbHandle.ExecContext(ctx, q, version)
@@ -4007,6 +4630,109 @@ func sqlReleaseLock(dbHandle *sql.DB) {
 	}
 }
 
+func sqlCommonRestore(plan *restorePlan, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		return plan.apply(&sqlRestorer{
+			ctx: ctx,
+			tx:  tx,
+		})
+	})
+}
+
+// sqlRestorer saves the restored objects within the specified transaction.
+// The objects are already validated
+type sqlRestorer struct {
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+func (r *sqlRestorer) setConfigs(configs *Configs) error {
+	return sqlCommonSetConfigsWithQuerier(r.ctx, configs, r.tx)
+}
+
+func (r *sqlRestorer) addIPListEntry(entry *IPListEntry) error {
+	return sqlCommonAddIPListEntryWithQuerier(r.ctx, entry, r.tx)
+}
+
+func (r *sqlRestorer) updateIPListEntry(entry *IPListEntry) error {
+	return sqlCommonUpdateIPListEntryInTx(r.ctx, entry, r.tx)
+}
+
+func (r *sqlRestorer) addRole(role *Role) error {
+	return sqlCommonAddRoleWithQuerier(r.ctx, role, r.tx)
+}
+
+func (r *sqlRestorer) updateRole(role *Role) error {
+	return sqlCommonUpdateRoleInTx(r.ctx, role, r.tx)
+}
+
+func (r *sqlRestorer) addFolder(folder *vfs.BaseVirtualFolder) error {
+	return sqlCommonAddFolderWithQuerier(r.ctx, folder, r.tx)
+}
+
+func (r *sqlRestorer) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	return sqlCommonUpdateFolderWithQuerier(r.ctx, folder, r.tx)
+}
+
+func (r *sqlRestorer) addGroup(group *Group) error {
+	return sqlCommonAddGroupInTx(r.ctx, group, r.tx)
+}
+
+func (r *sqlRestorer) updateGroup(group *Group) error {
+	return sqlCommonUpdateGroupInTx(r.ctx, group, r.tx)
+}
+
+func (r *sqlRestorer) addUser(user *User) error {
+	return sqlCommonAddUserInTx(r.ctx, user, r.tx)
+}
+
+func (r *sqlRestorer) updateUser(user *User) error {
+	return sqlCommonUpdateUserInTx(r.ctx, user, r.tx)
+}
+
+func (r *sqlRestorer) addAdmin(admin *Admin) error {
+	return sqlCommonAddAdminInTx(r.ctx, admin, r.tx)
+}
+
+func (r *sqlRestorer) updateAdmin(admin *Admin) error {
+	return sqlCommonUpdateAdminInTx(r.ctx, admin, r.tx)
+}
+
+func (r *sqlRestorer) addAPIKey(apiKey *APIKey) error {
+	return sqlCommonAddAPIKeyWithQuerier(r.ctx, apiKey, r.tx)
+}
+
+func (r *sqlRestorer) updateAPIKey(apiKey *APIKey) error {
+	return sqlCommonUpdateAPIKeyInTx(r.ctx, apiKey, r.tx)
+}
+
+func (r *sqlRestorer) addShare(share *Share) error {
+	return sqlCommonAddShareWithQuerier(r.ctx, share, r.tx)
+}
+
+func (r *sqlRestorer) updateShare(share *Share) error {
+	return sqlCommonUpdateShareInTx(r.ctx, share, r.tx)
+}
+
+func (r *sqlRestorer) addEventAction(action *BaseEventAction) error {
+	return sqlCommonAddEventActionWithQuerier(r.ctx, action, r.tx)
+}
+
+func (r *sqlRestorer) updateEventAction(action *BaseEventAction) error {
+	return sqlCommonUpdateEventActionInTx(r.ctx, action, r.tx)
+}
+
+func (r *sqlRestorer) addEventRule(rule *EventRule) error {
+	return sqlCommonAddEventRuleInTx(r.ctx, rule, r.tx)
+}
+
+func (r *sqlRestorer) updateEventRule(rule *EventRule) error {
+	return sqlCommonUpdateEventRuleInTx(r.ctx, rule, r.tx)
+}
+
 func sqlCommonExecuteTx(ctx context.Context, dbHandle *sql.DB, txFn func(*sql.Tx) error) error {
 	if config.Driver == CockroachDataProviderName {
 		return crdb.ExecuteTx(ctx, dbHandle, nil, txFn)
@@ -59,6 +59,8 @@ DROP TABLE IF EXISTS "{{tasks}}";
 DROP TABLE IF EXISTS "{{roles}}";
 DROP TABLE IF EXISTS "{{ip_lists}}";
 DROP TABLE IF EXISTS "{{configs}}";
+DROP TABLE IF EXISTS "{{change_notifications}}";
+DROP TABLE IF EXISTS "{{object_history}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
 `
 	sqliteInitialSQL = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "version" integer NOT NULL);
@@ -198,6 +200,25 @@ CREATE INDEX "{{prefix}}ip_lists_first_last_idx" ON "{{ip_lists}}" ("first", "la
 INSERT INTO {{configs}} (configs) VALUES ('{}');
 `
 	sqliteV28DownSQL = `DROP TABLE "{{configs}}";`
+	sqliteV29SQL     = `CREATE TABLE "{{change_notifications}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"instance" varchar(50) NOT NULL, "payload" text NOT NULL, "created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}change_notifications_created_at_idx" ON "{{change_notifications}}" ("created_at");
+`
+	sqliteV29DownSQL = `DROP TABLE "{{change_notifications}}";`
+	sqliteV30SQL     = `CREATE TABLE "{{object_history}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"object_type" varchar(50) NOT NULL, "object_name" varchar(255) NOT NULL, "action" varchar(20) NOT NULL,
+"executor" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL, "role" varchar(255) NOT NULL, "data" text NOT NULL,
+"created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}object_history_object_idx" ON "{{object_history}}" ("object_type", "object_name");
+CREATE INDEX "{{prefix}}object_history_created_at_idx" ON "{{object_history}}" ("created_at");
+`
+	sqliteV30DownSQL = `DROP TABLE "{{object_history}}";`
+	sqliteV31SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{events_actions}}" ADD COLUMN "updated_at" bigint DEFAULT 0 NOT NULL;
+`
+	sqliteV31DownSQL = `ALTER TABLE "{{events_actions}}" DROP COLUMN "updated_at";
+ALTER TABLE "{{folders}}" DROP COLUMN "updated_at";
+`
 )
 
 // SQLiteProvider defines the auth provider for SQLite database
@@ -248,8 +269,8 @@ func (p *SQLiteProvider) validateUserAndTLSCert(username, protocol string, tlsCe
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *SQLiteProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
+func (p *SQLiteProvider) validateUserAndPubKey(username string, publicKey []byte, ip string, isSSHCert bool) (User, PublicKey, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, ip, isSSHCert, p.dbHandle)
 }
 
 func (p *SQLiteProvider) updateTransferQuota(username string, uploadSize, downloadSize int64, reset bool) error {
@@ -288,6 +309,10 @@ func (p *SQLiteProvider) updateUser(user *User) error {
 	return sqlCommonUpdateUser(user, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateUsers(users []*User) error {
+	return sqlCommonUpdateUsers(users, p.dbHandle)
+}
+
 func (p *SQLiteProvider) deleteUser(user User, softDelete bool) error {
 	return sqlCommonDeleteUser(user, softDelete, p.dbHandle)
 }
@@ -296,6 +321,14 @@ func (p *SQLiteProvider) updateUserPassword(username, password string) error {
 	return sqlCommonUpdateUserPassword(username, password, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateUserPublicKeyLastUse(username, fingerprint string) error {
+	return sqlCommonUpdateUserPublicKeyLastUse(username, fingerprint, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateUserCryptFsReEncryption(username, expectedRunID string, state *CryptFsReEncryptionState) error {
+	return sqlCommonUpdateUserCryptFsReEncryption(username, expectedRunID, state, p.dbHandle)
+}
+
 func (p *SQLiteProvider) dumpUsers() ([]User, error) {
 	return sqlCommonDumpUsers(p.dbHandle)
 }
@@ -304,8 +337,8 @@ func (p *SQLiteProvider) getRecentlyUpdatedUsers(after int64) ([]User, error) {
 	return sqlCommonGetRecentlyUpdatedUsers(after, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getUsers(limit int, offset int, order, role string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, role, p.dbHandle)
+func (p *SQLiteProvider) getUsers(limit int, offset int, order string, filters UserSearchFilters) ([]User, error) {
+	return sqlCommonGetUsers(limit, offset, order, filters, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getUsersForQuotaCheck(toFetch map[string]bool) ([]User, error) {
@@ -316,8 +349,8 @@ func (p *SQLiteProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
 
-func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, minimal, p.dbHandle)
+func (p *SQLiteProvider) getFolders(limit, offset int, order string, minimal bool, filters FolderSearchFilters) ([]vfs.BaseVirtualFolder, error) {
+	return sqlCommonGetFolders(limit, offset, order, minimal, filters, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -334,6 +367,10 @@ func (p *SQLiteProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	return sqlCommonUpdateFolder(folder, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateFolders(folders []*vfs.BaseVirtualFolder) error {
+	return sqlCommonUpdateFolders(folders, p.dbHandle)
+}
+
 func (p *SQLiteProvider) deleteFolder(folder vfs.BaseVirtualFolder) error {
 	return sqlCommonDeleteFolder(folder, p.dbHandle)
 }
@@ -346,8 +383,8 @@ func (p *SQLiteProvider) getUsedFolderQuota(name string) (int, int64, error) {
 	return sqlCommonGetFolderUsedQuota(name, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool) ([]Group, error) {
-	return sqlCommonGetGroups(limit, offset, order, minimal, p.dbHandle)
+func (p *SQLiteProvider) getGroups(limit, offset int, order string, minimal bool, filters SearchFilters) ([]Group, error) {
+	return sqlCommonGetGroups(limit, offset, order, minimal, filters, p.dbHandle)
 }
 
 func (p *SQLiteProvider) getGroupsWithNames(names []string) ([]Group, error) {
@@ -370,6 +407,10 @@ func (p *SQLiteProvider) updateGroup(group *Group) error {
 	return sqlCommonUpdateGroup(group, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateGroups(groups []*Group) error {
+	return sqlCommonUpdateGroups(groups, p.dbHandle)
+}
+
 func (p *SQLiteProvider) deleteGroup(group Group) error {
 	return sqlCommonDeleteGroup(group, p.dbHandle)
 }
@@ -450,8 +491,8 @@ func (p *SQLiteProvider) deleteShare(share Share) error {
 	return sqlCommonDeleteShare(share, p.dbHandle)
 }
 
-func (p *SQLiteProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
-	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+func (p *SQLiteProvider) getShares(limit int, offset int, order, username string, filters SearchFilters) ([]Share, error) {
+	return sqlCommonGetShares(limit, offset, order, username, filters, p.dbHandle)
 }
 
 func (p *SQLiteProvider) dumpShares() ([]Share, error) {
@@ -526,6 +567,10 @@ func (p *SQLiteProvider) getSharedSession(key string) (Session, error) {
 	return sqlCommonGetSession(key, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getSharedSessionsUpdatedAfter(sessionType SessionType, after int64) ([]Session, error) {
+	return sqlCommonGetSessionsUpdatedAfter(sessionType, after, p.dbHandle)
+}
+
 func (p *SQLiteProvider) cleanupSharedSessions(sessionType SessionType, before int64) error {
 	return sqlCommonCleanupSessions(sessionType, before, p.dbHandle)
 }
@@ -618,6 +663,42 @@ func (*SQLiteProvider) cleanupNodes() error {
 	return ErrNotImplemented
 }
 
+func (p *SQLiteProvider) addChangeNotification(notification *ChangeNotification) error {
+	return sqlCommonAddChangeNotification(notification, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getChangeNotifications(after int64) ([]ChangeNotification, error) {
+	return sqlCommonGetChangeNotifications(after, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupChangeNotifications(before int64) error {
+	return sqlCommonCleanupChangeNotifications(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) restoreTimestamps(timestamps *objectTimestamps) error {
+	return sqlCommonRestoreTimestamps(timestamps, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addObjectVersion(version *ObjectVersion) error {
+	return sqlCommonAddObjectVersion(version, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getObjectVersions(objectType, objectName string, limit, offset int, order string) ([]ObjectVersion, error) {
+	return sqlCommonGetObjectVersions(objectType, objectName, limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, false, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getPreviousObjectVersion(objectType, objectName string, id int64) (ObjectVersion, error) {
+	return sqlCommonGetObjectVersion(objectType, objectName, id, true, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupObjectHistory(before int64) error {
+	return sqlCommonCleanupObjectHistory(before, p.dbHandle)
+}
+
 func (p *SQLiteProvider) roleExists(name string) (Role, error) {
 	return sqlCommonGetRoleByName(name, p.dbHandle)
 }
@@ -678,6 +759,10 @@ func (p *SQLiteProvider) getListEntriesForIP(ip string, listType IPListType) ([]
 	return sqlCommonGetListEntriesForIP(ip, listType, p.dbHandle)
 }
 
+func (p *SQLiteProvider) dumpPage(scope, after string, limit int) (BackupData, error) {
+	return sqlCommonDumpPage(scope, after, limit, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getConfigs() (Configs, error) {
 	return sqlCommonGetConfigs(p.dbHandle)
 }
@@ -686,6 +771,10 @@ func (p *SQLiteProvider) setConfigs(configs *Configs) error {
 	return sqlCommonSetConfigs(configs, p.dbHandle)
 }
 
+func (p *SQLiteProvider) restore(plan *restorePlan) error {
+	return sqlCommonRestore(plan, p.dbHandle)
+}
+
 func (p *SQLiteProvider) setFirstDownloadTimestamp(username string) error {
 	return sqlCommonSetFirstDownloadTimestamp(username, p.dbHandle)
 }
@@ -742,6 +831,12 @@ func (p *SQLiteProvider) migrateDatabase() error { //nolint:dupl
 		return updateSQLiteDatabaseFromV26(p.dbHandle)
 	case version == 27:
 		return updateSQLiteDatabaseFromV27(p.dbHandle)
+	case version == 28:
+		return updateSQLiteDatabaseFromV28(p.dbHandle)
+	case version == 29:
+		return updateSQLiteDatabaseFromV29(p.dbHandle)
+	case version == 30:
+		return updateSQLiteDatabaseFromV30(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database schema version %d is newer than the supported one: %d", version,
@@ -774,6 +869,12 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 		return downgradeSQLiteDatabaseFromV27(p.dbHandle)
 	case 28:
 		return downgradeSQLiteDatabaseFromV28(p.dbHandle)
+	case 29:
+		return downgradeSQLiteDatabaseFromV29(p.dbHandle)
+	case 30:
+		return downgradeSQLiteDatabaseFromV30(p.dbHandle)
+	case 31:
+		return downgradeSQLiteDatabaseFromV31(p.dbHandle)
 	default:
 		return fmt.Errorf("database schema version not handled: %d", dbVersion.Version)
 	}
@@ -813,7 +914,28 @@ func updateSQLiteDatabaseFromV26(dbHandle *sql.DB) error {
 }
 
 func updateSQLiteDatabaseFromV27(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom27To28(dbHandle)
+	if err := updateSQLiteDatabaseFrom27To28(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV28(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV28(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom28To29(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV29(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom29To30(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV30(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom30To31(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV24(dbHandle *sql.DB) error {
@@ -848,6 +970,27 @@ func downgradeSQLiteDatabaseFromV28(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFromV27(dbHandle)
 }
 
+func downgradeSQLiteDatabaseFromV29(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom29To28(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV28(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV30(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom30To29(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV29(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV31(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom31To30(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV30(dbHandle)
+}
+
 func updateSQLiteDatabaseFrom23To24(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database schema version: 23 -> 24")
 	providerLog(logger.LevelInfo, "updating database schema version: 23 -> 24")
@@ -887,6 +1030,30 @@ func updateSQLiteDatabaseFrom27To28(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, true)
 }
 
+func updateSQLiteDatabaseFrom28To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 28 -> 29")
+	providerLog(logger.LevelInfo, "updating database schema version: 28 -> 29")
+	sql := strings.ReplaceAll(sqliteV29SQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, true)
+}
+
+func updateSQLiteDatabaseFrom29To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 29 -> 30")
+	providerLog(logger.LevelInfo, "updating database schema version: 29 -> 30")
+	sql := strings.ReplaceAll(sqliteV30SQL, "{{object_history}}", sqlTableObjectHistory)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, true)
+}
+
+func updateSQLiteDatabaseFrom30To31(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database schema version: 30 -> 31")
+	providerLog(logger.LevelInfo, "updating database schema version: 30 -> 31")
+	sql := strings.ReplaceAll(sqliteV31SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 31, true)
+}
+
 func downgradeSQLiteDatabaseFrom24To23(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database schema version: 24 -> 23")
 	providerLog(logger.LevelInfo, "downgrading database schema version: 24 -> 23")
@@ -925,6 +1092,28 @@ func downgradeSQLiteDatabaseFrom28To27(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 27, false)
 }
 
+func downgradeSQLiteDatabaseFrom29To28(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 29 -> 28")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 29 -> 28")
+	sql := strings.ReplaceAll(sqliteV29DownSQL, "{{change_notifications}}", sqlTableChangeNotifications)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 28, false)
+}
+
+func downgradeSQLiteDatabaseFrom30To29(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 30 -> 29")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 30 -> 29")
+	sql := strings.ReplaceAll(sqliteV30DownSQL, "{{object_history}}", sqlTableObjectHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 29, false)
+}
+
+func downgradeSQLiteDatabaseFrom31To30(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database schema version: 31 -> 30")
+	providerLog(logger.LevelInfo, "downgrading database schema version: 31 -> 30")
+	sql := strings.ReplaceAll(sqliteV31DownSQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{events_actions}}", sqlTableEventsActions)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 30, false)
+}
+
 /*func setPragmaFK(dbHandle *sql.DB, value string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
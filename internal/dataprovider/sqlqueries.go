@@ -28,16 +28,17 @@ const (
 		"u.expiration_date,u.last_login,u.status,u.filters,u.filesystem,u.additional_info,u.description,u.email,u.created_at," +
 		"u.updated_at,u.upload_data_transfer,u.download_data_transfer,u.total_data_transfer," +
 		"u.used_upload_data_transfer,u.used_download_data_transfer,u.deleted_at,u.first_download,u.first_upload,r.name,u.last_password_change"
-	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
+	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,updated_at"
 	selectAdminFields  = "a.id,a.username,a.password,a.status,a.email,a.permissions,a.filters,a.additional_info,a.description,a.created_at,a.updated_at,a.last_login,r.name"
 	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
 		"s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
-	selectGroupFields       = "id,name,description,created_at,updated_at,user_settings"
-	selectEventActionFields = "id,name,description,type,options"
-	selectRoleFields        = "id,name,description,created_at,updated_at"
-	selectIPListEntryFields = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
-	selectMinimalFields     = "id,name"
+	selectGroupFields         = "id,name,description,created_at,updated_at,user_settings"
+	selectEventActionFields   = "id,name,description,type,options,updated_at"
+	selectRoleFields          = "id,name,description,created_at,updated_at"
+	selectIPListEntryFields   = "type,ipornet,mode,protocols,description,created_at,updated_at,deleted_at"
+	selectMinimalFields       = "id,name"
+	selectObjectVersionFields = "id,object_type,object_name,action,executor,ip,role,created_at"
 )
 
 func getSQLPlaceholders() []string {
@@ -102,6 +103,15 @@ func getSessionQuery() string {
 		sqlPlaceholders[0])
 }
 
+func getSessionsUpdatedAfterQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("SELECT `key`,`data`,`type`,`timestamp` FROM %s WHERE `type` = %s AND `timestamp` > %s",
+			sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+	}
+	return fmt.Sprintf(`SELECT key,data,type,timestamp FROM %s WHERE type = %s AND timestamp > %s`,
+		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getCleanupSessionsQuery() string {
 	return fmt.Sprintf(`DELETE from %s WHERE type = %s AND timestamp < %s`,
 		sqlTableSharedSessions, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -356,15 +366,16 @@ func getGroupByNameQuery() string {
 		sqlPlaceholders[0])
 }
 
-func getGroupsQuery(order string, minimal bool) string {
+func getGroupsQuery(order string, minimal bool, conditions []string, numArgs int) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
 		fieldSelection = selectGroupFields
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
-		getSQLQuotedName(sqlTableGroups), order, sqlPlaceholders[0], sqlPlaceholders[1])
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection,
+		getSQLQuotedName(sqlTableGroups), getSQLWhereClause(conditions, " WHERE "), order, sqlPlaceholders[numArgs],
+		sqlPlaceholders[numArgs+1])
 }
 
 func getGroupsWithNamesQuery(numArgs int) string {
@@ -467,9 +478,10 @@ func getShareByIDQuery(filterUser bool) string {
 		selectShareFields, sqlTableShares, sqlTableUsers, sqlPlaceholders[0])
 }
 
-func getSharesQuery(order string) string {
-	return fmt.Sprintf(`SELECT %s FROM %s s INNER JOIN %s u ON s.user_id = u.id WHERE u.username = %s ORDER BY s.share_id %s LIMIT %s OFFSET %s`,
-		selectShareFields, sqlTableShares, sqlTableUsers, sqlPlaceholders[0], order, sqlPlaceholders[1], sqlPlaceholders[2])
+func getSharesQuery(order string, conditions []string, numArgs int) string {
+	return fmt.Sprintf(`SELECT %s FROM %s s INNER JOIN %s u ON s.user_id = u.id WHERE u.username = %s%s ORDER BY s.share_id %s LIMIT %s OFFSET %s`,
+		selectShareFields, sqlTableShares, sqlTableUsers, sqlPlaceholders[0], getSQLWhereClause(conditions, " AND "),
+		order, sqlPlaceholders[numArgs+1], sqlPlaceholders[numArgs+2])
 }
 
 func getDumpSharesQuery() string {
@@ -588,15 +600,11 @@ func getUserByUsernameQuery(role string) string {
 		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
-func getUsersQuery(order, role string) string {
-	if role == "" {
-		return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-			u.deleted_at = 0 ORDER BY u.username %s LIMIT %s OFFSET %s`,
-			selectUserFields, sqlTableUsers, sqlTableRoles, order, sqlPlaceholders[0], sqlPlaceholders[1])
-	}
+func getUsersQuery(order string, conditions []string, numArgs int) string {
 	return fmt.Sprintf(`SELECT %s FROM %s u LEFT JOIN %s r on r.id = u.role_id WHERE
-		u.deleted_at = 0 AND u.role_id is NOT NULL AND r.name = %s ORDER BY u.username %s LIMIT %s OFFSET %s`,
-		selectUserFields, sqlTableUsers, sqlTableRoles, sqlPlaceholders[0], order, sqlPlaceholders[1], sqlPlaceholders[2])
+		u.deleted_at = 0%s ORDER BY u.username %s LIMIT %s OFFSET %s`,
+		selectUserFields, sqlTableUsers, sqlTableRoles, getSQLWhereClause(conditions, " AND "), order,
+		sqlPlaceholders[numArgs], sqlPlaceholders[numArgs+1])
 }
 
 func getUsersForQuotaCheckQuery(numArgs int) string {
@@ -631,6 +639,36 @@ func getDumpFoldersQuery() string {
 	return fmt.Sprintf(`SELECT %s FROM %s`, selectFolderFields, sqlTableFolders)
 }
 
+// getDumpPageQuery returns the query to dump the objects for the specified scope
+// ordered by their key and with a key greater than the specified one
+func getDumpPageQuery(scope string) string {
+	switch scope {
+	case DumpScopeUsers:
+		return fmt.Sprintf(`%s AND u.username > %s ORDER BY u.username LIMIT %s`, getDumpUsersQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeFolders:
+		return fmt.Sprintf(`%s WHERE name > %s ORDER BY name LIMIT %s`, getDumpFoldersQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeGroups:
+		return fmt.Sprintf(`%s WHERE name > %s ORDER BY name LIMIT %s`, getDumpGroupsQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeAdmins:
+		return fmt.Sprintf(`%s WHERE a.username > %s ORDER BY a.username LIMIT %s`, getDumpAdminsQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeAPIKeys:
+		return fmt.Sprintf(`%s WHERE key_id > %s ORDER BY key_id LIMIT %s`, getDumpAPIKeysQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeShares:
+		return fmt.Sprintf(`%s WHERE s.share_id > %s ORDER BY s.share_id LIMIT %s`, getDumpSharesQuery(),
+			sqlPlaceholders[0], sqlPlaceholders[1])
+	case DumpScopeIPLists:
+		return fmt.Sprintf(`%s AND (type > %s OR (type = %s AND ipornet > %s)) ORDER BY type,ipornet LIMIT %s`,
+			getDumpListEntriesQuery(), sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	default:
+		return ""
+	}
+}
+
 func getUpdateTransferQuotaQuery(reset bool) string {
 	if reset {
 		return fmt.Sprintf(`UPDATE %s SET used_upload_data_transfer = %s,used_download_data_transfer = %s,last_quota_update = %s
@@ -715,6 +753,21 @@ func getUpdateUserQuery(role string) string {
 		sqlPlaceholders[23], sqlPlaceholders[24])
 }
 
+// getUserFiltersForUpdateQuery returns the query to read the user filters locking the row,
+// it must be executed within a transaction
+func getUserFiltersForUpdateQuery() string {
+	q := fmt.Sprintf(`SELECT filters FROM %s WHERE username = %s`, sqlTableUsers, sqlPlaceholders[0])
+	if config.Driver != SQLiteDataProviderName {
+		q += " FOR UPDATE"
+	}
+	return q
+}
+
+func getUpdateUserFiltersQuery() string {
+	return fmt.Sprintf(`UPDATE %s SET filters=%s WHERE username = %s`,
+		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
 func getUpdateUserPasswordQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET password=%s WHERE username = %s`,
 		sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
@@ -737,14 +790,14 @@ func getFolderByNameQuery() string {
 }
 
 func getAddFolderQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,
+		updated_at) VALUES (%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 }
 
 func getUpdateFolderQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s WHERE name = %s`, sqlTableFolders, sqlPlaceholders[0],
-		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %s SET path=%s,description=%s,filesystem=%s,updated_at=%s WHERE name = %s`, sqlTableFolders,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
 }
 
 func getDeleteFolderQuery() string {
@@ -754,15 +807,17 @@ func getDeleteFolderQuery() string {
 func getUpsertFolderQuery() string {
 	if config.Driver == MySQLDataProviderName {
 		return fmt.Sprintf("INSERT INTO %s (`path`,`used_quota_size`,`used_quota_files`,`last_quota_update`,`name`,"+
-			"`description`,`filesystem`) VALUES (%s,%s,%s,%s,%s,%s,%s) ON DUPLICATE KEY UPDATE "+
-			"`path`=VALUES(`path`),`description`=VALUES(`description`),`filesystem`=VALUES(`filesystem`)",
+			"`description`,`filesystem`,`updated_at`) VALUES (%s,%s,%s,%s,%s,%s,%s,%s) ON DUPLICATE KEY UPDATE "+
+			"`path`=VALUES(`path`),`description`=VALUES(`description`),`filesystem`=VALUES(`filesystem`),"+
+			"`updated_at`=VALUES(`updated_at`)",
 			sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
-			sqlPlaceholders[5], sqlPlaceholders[6])
+			sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 	}
-	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%s,%s,%s,%s,%s,%s,%s) ON CONFLICT (name) DO UPDATE SET path = EXCLUDED.path,description=EXCLUDED.description,
-		filesystem=EXCLUDED.filesystem`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %s (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,
+		updated_at) VALUES (%s,%s,%s,%s,%s,%s,%s,%s) ON CONFLICT (name) DO UPDATE SET path = EXCLUDED.path,
+		description=EXCLUDED.description,filesystem=EXCLUDED.filesystem,updated_at=EXCLUDED.updated_at`, sqlTableFolders,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
+		sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 }
 
 func getClearUserGroupMappingQuery() string {
@@ -813,15 +868,15 @@ func getAddUserFolderMappingQuery() string {
 		sqlPlaceholders[3], sqlTableUsers, sqlPlaceholders[4])
 }
 
-func getFoldersQuery(order string, minimal bool) string {
+func getFoldersQuery(order string, minimal bool, conditions []string, numArgs int) string {
 	var fieldSelection string
 	if minimal {
 		fieldSelection = selectMinimalFields
 	} else {
 		fieldSelection = selectFolderFields
 	}
-	return fmt.Sprintf(`SELECT %s FROM %s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
-		order, sqlPlaceholders[0], sqlPlaceholders[1])
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY name %s LIMIT %s OFFSET %s`, fieldSelection, sqlTableFolders,
+		getSQLWhereClause(conditions, " WHERE "), order, sqlPlaceholders[numArgs], sqlPlaceholders[numArgs+1])
 }
 
 func getUpdateFolderQuotaQuery(reset bool) string {
@@ -1043,13 +1098,15 @@ func getEventActionByNameQuery() string {
 }
 
 func getAddEventActionQuery() string {
-	return fmt.Sprintf(`INSERT INTO %s (name,description,type,options) VALUES (%s,%s,%s,%s)`,
-		sqlTableEventsActions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`INSERT INTO %s (name,description,type,options,updated_at) VALUES (%s,%s,%s,%s,%s)`,
+		sqlTableEventsActions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
 }
 
 func getUpdateEventActionQuery() string {
-	return fmt.Sprintf(`UPDATE %s SET description=%s,type=%s,options=%s WHERE name = %s`, sqlTableEventsActions,
-		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %s SET description=%s,type=%s,options=%s,updated_at=%s WHERE name = %s`,
+		sqlTableEventsActions, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
 }
 
 func getDeleteEventActionQuery() string {
@@ -1189,6 +1246,96 @@ func getCleanupNodesQuery() string {
 	return fmt.Sprintf(`DELETE FROM %s WHERE updated_at < %s`, sqlTableNodes, sqlPlaceholders[0])
 }
 
+func getAddChangeNotificationQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (instance,payload,created_at) VALUES (%s,%s,%s)`,
+		sqlTableChangeNotifications, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getChangeNotificationsQuery() string {
+	return fmt.Sprintf(`SELECT id,payload FROM %s WHERE created_at > %s AND instance != %s ORDER BY id ASC`,
+		sqlTableChangeNotifications, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getCleanupChangeNotificationsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE created_at < %s`, sqlTableChangeNotifications, sqlPlaceholders[0])
+}
+
+func getAddObjectVersionQuery() string {
+	return fmt.Sprintf(`INSERT INTO %s (object_type,object_name,action,executor,ip,role,data,created_at)
+		VALUES (%s,%s,%s,%s,%s,%s,%s,%s)`, sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7])
+}
+
+func getObjectVersionsQuery(order string) string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE object_type = %s AND object_name = %s ORDER BY id %s LIMIT %s OFFSET %s`,
+		selectObjectVersionFields, sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1], order,
+		sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getObjectVersionQuery() string {
+	return fmt.Sprintf(`SELECT %s,data FROM %s WHERE id = %s AND object_type = %s AND object_name = %s`,
+		selectObjectVersionFields, sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getPreviousObjectVersionQuery() string {
+	return fmt.Sprintf(`SELECT %s,data FROM %s WHERE id < %s AND object_type = %s AND object_name = %s ORDER BY id DESC LIMIT 1`,
+		selectObjectVersionFields, sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getObjectVersionsLimitQuery() string {
+	return fmt.Sprintf(`SELECT id FROM %s WHERE object_type = %s AND object_name = %s ORDER BY id DESC LIMIT 1 OFFSET %s`,
+		sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeleteObjectVersionsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE object_type = %s AND object_name = %s AND id <= %s`,
+		sqlTableObjectHistory, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getCleanupObjectHistoryQuery() string {
+	return fmt.Sprintf(`DELETE FROM %s WHERE created_at < %s`, sqlTableObjectHistory, sqlPlaceholders[0])
+}
+
+func getRestoreTimestampsQuery(objectType string, fields []string) (string, error) {
+	var table, where string
+	switch objectType {
+	case actionObjectUser:
+		table, where = sqlTableUsers, "username"
+	case actionObjectAdmin:
+		table, where = sqlTableAdmins, "username"
+	case actionObjectAPIKey:
+		table, where = sqlTableAPIKeys, "key_id"
+	case actionObjectGroup:
+		table, where = sqlTableGroups, "name"
+	case actionObjectFolder:
+		table, where = sqlTableFolders, "name"
+	case actionObjectEventAction:
+		table, where = sqlTableEventsActions, "name"
+	case actionObjectEventRule:
+		table, where = sqlTableEventsRules, "name"
+	case actionObjectRole:
+		table, where = sqlTableRoles, "name"
+	case actionObjectIPListEntry:
+		table, where = sqlTableIPLists, "ipornet"
+	default:
+		return "", fmt.Errorf("unable to restore timestamps, unsupported object type %q", objectType)
+	}
+	idx := 0
+	set := make([]string, 0, len(fields))
+	for _, field := range fields {
+		set = append(set, fmt.Sprintf("%s = %s", field, sqlPlaceholders[idx]))
+		idx++
+	}
+	conditions := ""
+	if objectType == actionObjectIPListEntry {
+		conditions = fmt.Sprintf("type = %s AND ", sqlPlaceholders[idx])
+		idx++
+	}
+	conditions += fmt.Sprintf("%s = %s", where, sqlPlaceholders[idx])
+	return fmt.Sprintf(`UPDATE %s SET %s WHERE %s`, table, strings.Join(set, ","), conditions), nil
+}
+
 func getDatabaseVersionQuery() string {
 	return fmt.Sprintf("SELECT version from %s LIMIT 1", sqlTableSchemaVersion)
 }
@@ -1196,3 +1343,38 @@ func getDatabaseVersionQuery() string {
 func getUpdateDBVersionQuery() string {
 	return fmt.Sprintf(`UPDATE %s SET version=%s`, sqlTableSchemaVersion, sqlPlaceholders[0])
 }
+
+func getObjectUpdatedAtQuery(table string, keyFields []string) string {
+	var sb strings.Builder
+
+	sb.WriteString("SELECT updated_at FROM ")
+	sb.WriteString(table)
+	sb.WriteString(" WHERE ")
+	for idx, field := range keyFields {
+		if idx > 0 {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(field)
+		sb.WriteString(" = ")
+		sb.WriteString(sqlPlaceholders[idx])
+	}
+	return sb.String()
+}
+
+func getCompareAndBumpObjectVersionQuery(table string, keyFields []string) string {
+	var sb strings.Builder
+
+	sb.WriteString("UPDATE ")
+	sb.WriteString(table)
+	sb.WriteString(" SET updated_at = ")
+	sb.WriteString(sqlPlaceholders[0])
+	sb.WriteString(" WHERE updated_at = ")
+	sb.WriteString(sqlPlaceholders[1])
+	for idx, field := range keyFields {
+		sb.WriteString(" AND ")
+		sb.WriteString(field)
+		sb.WriteString(" = ")
+		sb.WriteString(sqlPlaceholders[idx+2])
+	}
+	return sb.String()
+}
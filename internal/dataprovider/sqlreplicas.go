@@ -0,0 +1,378 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultReadReplicasMaxLag = 5
+)
+
+var (
+	// read replicas for the SQL providers, nil if not configured
+	readReplicas *sqlReplicas
+	// objects the user listings depend on, users are returned with their groups and folders
+	usersReplicaKeys = []string{replicaTypeKey(actionObjectUser), replicaTypeKey(actionObjectGroup),
+		replicaTypeKey(actionObjectFolder)}
+	groupsReplicaKeys       = []string{replicaTypeKey(actionObjectGroup), replicaTypeKey(actionObjectFolder)}
+	foldersReplicaKeys      = []string{replicaTypeKey(actionObjectFolder)}
+	sharesReplicaKeys       = []string{replicaTypeKey(actionObjectShare)}
+	eventActionsReplicaKeys = []string{replicaTypeKey(actionObjectEventAction)}
+	eventRulesReplicaKeys   = []string{replicaTypeKey(actionObjectEventRule), replicaTypeKey(actionObjectEventAction)}
+	rolesReplicaKeys        = []string{replicaTypeKey(actionObjectRole)}
+)
+
+// ReadReplicasConfig defines the read replicas for the MySQL, PostgreSQL and
+// CockroachDB data providers
+type ReadReplicasConfig struct {
+	// Connection strings for the read replicas, in the same format used for the
+	// "connection_string" setting of the configured driver.
+	// Read-only hot paths, such as the listings and the event rules loading, are
+	// served from the replicas. Authentication and credentials are always read from
+	// the primary database, a replica could return a changed password or a revoked
+	// key. Replicas are used in round-robin, the primary database is used as fallback
+	ConnectionStrings []string `json:"connection_strings" mapstructure:"connection_strings"`
+	// Maximum expected replication lag, in seconds. After an update the modified
+	// objects are read from the primary database for this interval, the event
+	// rules reloads served from a replica look back for this interval too.
+	// 0 means the default: 5
+	MaxLag int `json:"max_lag" mapstructure:"max_lag"`
+}
+
+func (c *ReadReplicasConfig) validate() error {
+	if c.MaxLag < 0 {
+		return fmt.Errorf("invalid read replicas max lag: %d", c.MaxLag)
+	}
+	for idx, connectionString := range c.ConnectionStrings {
+		if connectionString == "" {
+			return fmt.Errorf("invalid read replica at position %d: empty connection string", idx)
+		}
+	}
+	return nil
+}
+
+func (c *ReadReplicasConfig) getMaxLag() time.Duration {
+	if c.MaxLag == 0 {
+		return defaultReadReplicasMaxLag * time.Second
+	}
+	return time.Duration(c.MaxLag) * time.Second
+}
+
+// ReadReplicaStatus defines the status of a read replica
+type ReadReplicaStatus struct {
+	// Replica position in the configured connection strings, starting from 0
+	ID       int    `json:"id"`
+	IsActive bool   `json:"is_active"`
+	Error    string `json:"error,omitempty"`
+}
+
+type sqlReplica struct {
+	id       int
+	dbHandle *sql.DB
+	healthy  atomic.Bool
+	mu       sync.RWMutex
+	lastErr  error
+}
+
+func (r *sqlReplica) setHealthy(err error) {
+	wasHealthy := r.healthy.Swap(err == nil)
+	r.mu.Lock()
+	r.lastErr = err
+	r.mu.Unlock()
+
+	if err != nil && wasHealthy {
+		providerLog(logger.LevelWarn, "read replica %d marked as unavailable: %v", r.id, err)
+	} else if err == nil && !wasHealthy {
+		providerLog(logger.LevelInfo, "read replica %d is available", r.id)
+	}
+}
+
+func (r *sqlReplica) getStatus() ReadReplicaStatus {
+	status := ReadReplicaStatus{
+		ID:       r.id,
+		IsActive: r.healthy.Load(),
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.lastErr != nil {
+		status.Error = r.lastErr.Error()
+	}
+	return status
+}
+
+type sqlReplicas struct {
+	replicas []*sqlReplica
+	maxLag   time.Duration
+	next     atomic.Uint32
+	mu       sync.RWMutex
+	// expiration, as unix timestamp in milliseconds, of the read-your-writes
+	// window for the modified objects and object types
+	writes map[string]int64
+}
+
+func replicaTypeKey(objectType string) string {
+	return objectType
+}
+
+func replicaObjectKey(objectType, objectName string) string {
+	return objectType + "/" + objectName
+}
+
+func initializeSQLReadReplicas(driverName string) error {
+	replicasConfig := config.ReadReplicas
+	if len(replicasConfig.ConnectionStrings) == 0 {
+		return nil
+	}
+	if err := replicasConfig.validate(); err != nil {
+		return err
+	}
+	r := &sqlReplicas{
+		maxLag: replicasConfig.getMaxLag(),
+		writes: make(map[string]int64),
+	}
+	for idx, connectionString := range replicasConfig.ConnectionStrings {
+		dbHandle, err := sql.Open(driverName, connectionString)
+		if err != nil {
+			providerLog(logger.LevelError, "error creating database handler for read replica %d: %v", idx, err)
+			r.close()
+			return fmt.Errorf("unable to create the database handler for read replica %d: %w", idx, err)
+		}
+		dbHandle.SetMaxOpenConns(config.PoolSize)
+		if config.PoolSize > 0 {
+			dbHandle.SetMaxIdleConns(config.PoolSize)
+		} else {
+			dbHandle.SetMaxIdleConns(2)
+		}
+		dbHandle.SetConnMaxLifetime(240 * time.Second)
+		dbHandle.SetConnMaxIdleTime(120 * time.Second)
+		replica := &sqlReplica{
+			id:       idx,
+			dbHandle: dbHandle,
+		}
+		replica.setHealthy(sqlCommonCheckAvailability(dbHandle))
+		r.replicas = append(r.replicas, replica)
+	}
+	providerLog(logger.LevelDebug, "read replicas initialized, count: %d, max lag: %s", len(r.replicas), r.maxLag)
+	readReplicas = r
+	r.updateMetrics()
+	return nil
+}
+
+func closeSQLReadReplicas() {
+	if readReplicas != nil {
+		readReplicas.close()
+		readReplicas = nil
+	}
+}
+
+func (r *sqlReplicas) close() {
+	for _, replica := range r.replicas {
+		if err := replica.dbHandle.Close(); err != nil {
+			providerLog(logger.LevelError, "unable to close read replica %d: %v", replica.id, err)
+		}
+	}
+}
+
+// onWrite starts the read-your-writes window for the specified object.
+// Reads depending on this object will be served from the primary database
+// until the max replication lag elapses
+func (r *sqlReplicas) onWrite(objectType string, objectNames ...string) {
+	if r == nil {
+		return
+	}
+	expiration := util.GetTimeAsMsSinceEpoch(time.Now().Add(r.maxLag))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.writes[replicaTypeKey(objectType)] = expiration
+	for _, name := range objectNames {
+		r.writes[replicaObjectKey(objectType, name)] = expiration
+	}
+}
+
+func (r *sqlReplicas) onUsersWrite(users []*User) {
+	if r == nil {
+		return
+	}
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	r.onWrite(actionObjectUser, usernames...)
+}
+
+func (r *sqlReplicas) onRestore(results *RestoreResults) {
+	if r == nil {
+		return
+	}
+	for _, result := range results.Results {
+		if result.Status == RestoreStatusAdded || result.Status == RestoreStatusUpdated {
+			r.onWrite(result.Type, result.Name)
+		}
+	}
+}
+
+func (r *sqlReplicas) isRecentlyWritten(keys []string) bool {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range keys {
+		if expiration, ok := r.writes[key]; ok && expiration > now {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sqlReplicas) removeExpiredWrites() {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, expiration := range r.writes {
+		if expiration <= now {
+			delete(r.writes, key)
+		}
+	}
+}
+
+// getReplica returns a healthy replica, in round-robin, or nil if no replica is
+// available or if any of the specified objects was recently written.
+// The load is evenly distributed among the healthy replicas
+func (r *sqlReplicas) getReplica(keys []string) *sqlReplica {
+	if r == nil || r.isRecentlyWritten(keys) {
+		return nil
+	}
+	healthy := 0
+	for _, replica := range r.replicas {
+		if replica.healthy.Load() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return nil
+	}
+	pos := int(r.next.Add(1) % uint32(healthy))
+	for _, replica := range r.replicas {
+		if !replica.healthy.Load() {
+			continue
+		}
+		if pos == 0 {
+			return replica
+		}
+		pos--
+	}
+	// a replica was marked as unavailable in the meantime
+	return nil
+}
+
+func (r *sqlReplicas) checkAvailability() {
+	if r == nil {
+		return
+	}
+	for _, replica := range r.replicas {
+		replica.setHealthy(sqlCommonCheckAvailability(replica.dbHandle))
+	}
+	r.removeExpiredWrites()
+	r.updateMetrics()
+}
+
+func (r *sqlReplicas) getStatus() []ReadReplicaStatus {
+	if r == nil {
+		return nil
+	}
+	status := make([]ReadReplicaStatus, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		status = append(status, replica.getStatus())
+	}
+	return status
+}
+
+func (r *sqlReplicas) updateMetrics() {
+	healthy := 0
+	for _, replica := range r.replicas {
+		if replica.healthy.Load() {
+			healthy++
+		}
+	}
+	metric.UpdateDataProviderReadReplicas(len(r.replicas), healthy)
+}
+
+// readFromReplica executes the specified read only query using a read replica,
+// if available, and falls back to the primary database on errors. A replica
+// could lag behind the primary, so a not found error is retried on the primary
+// too. keys identify the objects the query depends on, the primary database is
+// used directly if any of them was recently written
+func readFromReplica[T any](r *sqlReplicas, primary *sql.DB, keys []string, fn func(dbHandle sqlQuerier) (T, error)) (T, error) {
+	replica := r.getReplica(keys)
+	if replica == nil {
+		return fn(primary)
+	}
+	res, err := fn(replica.dbHandle)
+	if err == nil {
+		metric.AddDataProviderReplicaRead(false)
+		return res, nil
+	}
+	var errNotFound *util.RecordNotFoundError
+	if !errors.As(err, &errNotFound) {
+		providerLog(logger.LevelWarn, "read replica %d query error, falling back to the primary database: %v",
+			replica.id, err)
+		replica.setHealthy(err)
+		r.updateMetrics()
+	}
+	metric.AddDataProviderReplicaRead(true)
+	return fn(primary)
+}
+
+// sqlCommonGetRecentlyUpdatedRulesWithReplicas returns the recently updated event rules.
+// If a read replica is used, the rules updated within the max replication lag
+// before the specified time are returned too, rules are applied idempotently
+func sqlCommonGetRecentlyUpdatedRulesWithReplicas(after int64, dbHandle *sql.DB) ([]EventRule, error) {
+	replica := readReplicas.getReplica(eventRulesReplicaKeys)
+	if replica == nil {
+		return sqlCommonGetRecentlyUpdatedRules(after, dbHandle)
+	}
+	lookBack := after - readReplicas.maxLag.Milliseconds()
+	if lookBack < 0 {
+		lookBack = 0
+	}
+	rules, err := sqlCommonGetRecentlyUpdatedRules(lookBack, replica.dbHandle)
+	if err == nil {
+		metric.AddDataProviderReplicaRead(false)
+		return rules, nil
+	}
+	providerLog(logger.LevelWarn, "read replica %d query error, falling back to the primary database: %v",
+		replica.id, err)
+	replica.setHealthy(err)
+	readReplicas.updateMetrics()
+	metric.AddDataProviderReplicaRead(true)
+	return sqlCommonGetRecentlyUpdatedRules(after, dbHandle)
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !nopgsql && !nomysql
+// +build !nopgsql,!nomysql
+
+package dataprovider
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReplicasProvider(t *testing.T) {
+	conf := getTestProviderConf(t)
+	var driverName string
+	switch conf.Driver {
+	case PGSQLDataProviderName, CockroachDataProviderName:
+		driverName = "pgx"
+	case MySQLDataProviderName:
+		driverName = "mysql"
+	default:
+		t.Skip("this test requires a data provider supporting read replicas")
+	}
+	initializeTestProvider(t, conf)
+	// use the primary database as read replica
+	var connectionString string
+	var err error
+	if driverName == "pgx" {
+		connectionString = getPGSQLConnectionString(false)
+	} else {
+		connectionString, err = getMySQLConnectionString(false)
+		require.NoError(t, err)
+	}
+	config.ReadReplicas.ConnectionStrings = []string{connectionString}
+	config.ReadReplicas.MaxLag = 1
+	err = initializeSQLReadReplicas(driverName)
+	require.NoError(t, err)
+	defer closeSQLReadReplicas()
+	require.NotNil(t, readReplicas)
+	replica := readReplicas.replicas[0]
+	require.True(t, replica.healthy.Load())
+
+	user := User{
+		BaseUser: sdk.BaseUser{
+			Username: "replica_user_" + xid.New().String(),
+			Password: "password",
+			HomeDir:  filepath.Join(t.TempDir(), "user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+	}
+	err = AddUser(&user, "", "", "")
+	require.NoError(t, err)
+	// read your writes
+	assert.Nil(t, readReplicas.getReplica(usersReplicaKeys))
+	assert.Eventually(t, func() bool {
+		return readReplicas.getReplica(usersReplicaKeys) != nil
+	}, 3*time.Second, 100*time.Millisecond)
+	users, err := provider.getUsers(1000, 0, OrderASC, UserSearchFilters{})
+	require.NoError(t, err)
+	found := false
+	for _, u := range users {
+		if u.Username == user.Username {
+			found = true
+		}
+	}
+	assert.True(t, found)
+	// replace the replica with one that cannot be reached
+	err = replica.dbHandle.Close()
+	require.NoError(t, err)
+	if driverName == "pgx" {
+		replica.dbHandle, err = sql.Open(driverName, "host=127.0.0.1 port=1 user=sftpgo dbname=sftpgo connect_timeout=2")
+	} else {
+		replica.dbHandle, err = sql.Open(driverName, "sftpgo:sftpgo@tcp(127.0.0.1:1)/sftpgo?timeout=2s")
+	}
+	require.NoError(t, err)
+	replica.healthy.Store(true)
+	// authentication and credentials are always read from the primary database
+	_, err = provider.validateUserAndPass(user.Username, "password", "127.0.0.1", protocolSSH)
+	assert.NoError(t, err)
+	assert.True(t, replica.healthy.Load())
+	// the listings fall back to the primary database and the replica is excluded
+	users, err = provider.getUsers(1000, 0, OrderASC, UserSearchFilters{})
+	require.NoError(t, err)
+	assert.Greater(t, len(users), 0)
+	assert.False(t, replica.healthy.Load())
+	status := readReplicas.getStatus()
+	require.Len(t, status, 1)
+	assert.False(t, status[0].IsActive)
+	assert.NotEmpty(t, status[0].Error)
+	readReplicas.checkAvailability()
+	assert.False(t, replica.healthy.Load())
+
+	err = DeleteUser(user.Username, "", "", "")
+	assert.NoError(t, err)
+}
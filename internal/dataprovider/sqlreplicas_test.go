@@ -0,0 +1,217 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package dataprovider
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// newTestReplicas returns read replicas whose handles are only used to identify the
+// database used by the read functions
+func newTestReplicas(count int, maxLag time.Duration) *sqlReplicas {
+	r := &sqlReplicas{
+		maxLag: maxLag,
+		writes: make(map[string]int64),
+	}
+	for idx := 0; idx < count; idx++ {
+		replica := &sqlReplica{
+			id:       idx,
+			dbHandle: &sql.DB{},
+		}
+		replica.healthy.Store(true)
+		r.replicas = append(r.replicas, replica)
+	}
+	return r
+}
+
+func TestReadReplicasConfig(t *testing.T) {
+	c := ReadReplicasConfig{}
+	assert.NoError(t, c.validate())
+	assert.Equal(t, defaultReadReplicasMaxLag*time.Second, c.getMaxLag())
+	c.MaxLag = 10
+	assert.Equal(t, 10*time.Second, c.getMaxLag())
+	c.MaxLag = -1
+	assert.Error(t, c.validate())
+	c.MaxLag = 0
+	c.ConnectionStrings = []string{"replica", ""}
+	assert.Error(t, c.validate())
+}
+
+func TestReadReplicasSelection(t *testing.T) {
+	var r *sqlReplicas
+	assert.Nil(t, r.getReplica(nil))
+	assert.Nil(t, r.getStatus())
+
+	r = newTestReplicas(3, time.Minute)
+	selected := make(map[int]int)
+	for idx := 0; idx < 6; idx++ {
+		replica := r.getReplica(nil)
+		require.NotNil(t, replica)
+		selected[replica.id]++
+	}
+	// round-robin
+	assert.Equal(t, map[int]int{0: 2, 1: 2, 2: 2}, selected)
+	// unavailable replicas are skipped
+	r.replicas[1].setHealthy(errors.New("unavailable"))
+	selected = make(map[int]int)
+	for idx := 0; idx < 6; idx++ {
+		replica := r.getReplica(nil)
+		require.NotNil(t, replica)
+		selected[replica.id]++
+	}
+	assert.Equal(t, map[int]int{0: 3, 2: 3}, selected)
+	status := r.getStatus()
+	require.Len(t, status, 3)
+	assert.True(t, status[0].IsActive)
+	assert.False(t, status[1].IsActive)
+	assert.Equal(t, "unavailable", status[1].Error)
+	r.replicas[1].setHealthy(nil)
+	status = r.getStatus()
+	assert.True(t, status[1].IsActive)
+	assert.Empty(t, status[1].Error)
+	// no replica available, the primary database is used
+	for _, replica := range r.replicas {
+		replica.setHealthy(errors.New("unavailable"))
+	}
+	assert.Nil(t, r.getReplica(nil))
+	// the availability check marks the replicas that cannot be reached as unavailable
+	r = newTestReplicas(2, time.Minute)
+	r.checkAvailability()
+	for _, s := range r.getStatus() {
+		assert.False(t, s.IsActive)
+		assert.NotEmpty(t, s.Error)
+	}
+}
+
+func TestReadReplicasFallback(t *testing.T) {
+	primary := &sql.DB{}
+	r := newTestReplicas(1, time.Minute)
+	replica := r.replicas[0]
+
+	read := func(replicaErr error) (*sql.DB, error) {
+		return readFromReplica(r, primary, nil, func(dbHandle sqlQuerier) (*sql.DB, error) {
+			h := dbHandle.(*sql.DB)
+			if h == replica.dbHandle && replicaErr != nil {
+				return nil, replicaErr
+			}
+			return h, nil
+		})
+	}
+
+	h, err := read(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, replica.dbHandle, h)
+	// a replica could lag behind, not found errors are retried on the primary database
+	// but the replica is still used
+	h, err = read(util.NewRecordNotFoundError("not found"))
+	assert.NoError(t, err)
+	assert.Equal(t, primary, h)
+	assert.True(t, replica.healthy.Load())
+	// on errors the primary database is used and the replica is excluded
+	h, err = read(errors.New("replica error"))
+	assert.NoError(t, err)
+	assert.Equal(t, primary, h)
+	assert.False(t, replica.healthy.Load())
+	h, err = read(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, primary, h)
+	// errors from the primary database are returned
+	_, err = readFromReplica(r, primary, nil, func(_ sqlQuerier) (*sql.DB, error) {
+		return nil, errors.New("primary error")
+	})
+	assert.EqualError(t, err, "primary error")
+	// no read replicas configured
+	var noReplicas *sqlReplicas
+	h, err = readFromReplica(noReplicas, primary, nil, func(dbHandle sqlQuerier) (*sql.DB, error) {
+		return dbHandle.(*sql.DB), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, primary, h)
+}
+
+func TestReadReplicasReadYourWrites(t *testing.T) {
+	var noReplicas *sqlReplicas
+	noReplicas.onWrite(actionObjectUser, "user")
+	noReplicas.onUsersWrite(nil)
+	noReplicas.onRestore(&RestoreResults{})
+	noReplicas.checkAvailability()
+
+	r := newTestReplicas(1, 200*time.Millisecond)
+	userKeys := func(username string) []string {
+		return []string{replicaObjectKey(actionObjectUser, username), replicaTypeKey(actionObjectGroup),
+			replicaTypeKey(actionObjectFolder)}
+	}
+	r.onWrite(actionObjectUser, "user1")
+	assert.Nil(t, r.getReplica(userKeys("user1")))
+	assert.NotNil(t, r.getReplica(userKeys("user2")))
+	// the users listings depend on any user, group or folder
+	assert.Nil(t, r.getReplica(usersReplicaKeys))
+	assert.NotNil(t, r.getReplica(groupsReplicaKeys))
+	r.onWrite(actionObjectFolder)
+	assert.Nil(t, r.getReplica(groupsReplicaKeys))
+	assert.Nil(t, r.getReplica(userKeys("user2")))
+	assert.NotNil(t, r.getReplica(eventRulesReplicaKeys))
+	r.onWrite(actionObjectEventAction)
+	assert.Nil(t, r.getReplica(eventRulesReplicaKeys))
+	// after the max lag the replicas are used again
+	assert.Eventually(t, func() bool {
+		return r.getReplica(usersReplicaKeys) != nil
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.NotNil(t, r.getReplica(userKeys("user1")))
+	assert.NotNil(t, r.getReplica(eventRulesReplicaKeys))
+	r.removeExpiredWrites()
+	assert.Len(t, r.writes, 0)
+
+	r.onUsersWrite([]*User{{BaseUser: sdk.BaseUser{Username: "user3"}}})
+	assert.Nil(t, r.getReplica(userKeys("user3")))
+	r.onRestore(&RestoreResults{
+		Results: []RestoreResult{
+			{
+				Type:   actionObjectRole,
+				Name:   "role1",
+				Status: RestoreStatusUpdated,
+			},
+			{
+				Type:   actionObjectShare,
+				Name:   "share1",
+				Status: RestoreStatusSkipped,
+			},
+		},
+	})
+	assert.Nil(t, r.getReplica(rolesReplicaKeys))
+	assert.NotNil(t, r.getReplica(sharesReplicaKeys))
+	// the objects changed by other instances are read from the primary database too
+	readReplicas = r
+	defer func() {
+		readReplicas = nil
+	}()
+	HandleChangeNotification(&ChangeNotification{
+		Instance:   xid.New().String(),
+		Operation:  operationUpdate,
+		ObjectType: actionObjectShare,
+		ObjectName: "share1",
+	})
+	assert.Nil(t, r.getReplica(sharesReplicaKeys))
+}
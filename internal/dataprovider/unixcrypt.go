@@ -37,3 +37,7 @@ func compareYescryptPassword(hashedPwd, plainPwd string) (bool, error) {
 	}
 	return pwd == hashedPwd, nil
 }
+
+func isYescryptSupported() bool {
+	return true
+}
@@ -30,3 +30,7 @@ func init() {
 func compareYescryptPassword(_, _ string) (bool, error) {
 	return false, errors.New("yescrypt hash format is not supported or disabled")
 }
+
+func isYescryptSupported() bool {
+	return false
+}
@@ -127,6 +127,16 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// Number of previous passwords that cannot be reused.
+	// 0 means using the value defined in the primary group, if any, or the global one
+	PasswordHistorySize int `json:"password_history_size,omitempty"`
+	// Hashes of the last used passwords, the current one included
+	PasswordHistory []string `json:"password_history,omitempty"`
+	// Last use, as unix timestamp in milliseconds, for the public keys.
+	// The key is the public key fingerprint
+	PublicKeysLastUse map[string]int64 `json:"public_keys_last_use,omitempty"`
+	// State of the CryptFs re-encryption, if any
+	CryptFsReEncryption *CryptFsReEncryptionState `json:"cryptfs_reencryption,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -140,12 +150,16 @@ type User struct {
 	FsConfig vfs.Filesystem `json:"filesystem"`
 	// groups associated with this user
 	Groups []sdk.GroupMapping `json:"groups,omitempty"`
+	// Parsed public keys, they are populated when the user is rendered and cleared before saving
+	PublicKeysInfo []PublicKey `json:"public_keys_info,omitempty"`
 	// we store the filesystem here using the base path as key.
 	fsCache map[string]vfs.Fs `json:"-"`
 	// true if group settings are already applied for this user
 	groupSettingsApplied bool `json:"-"`
 	// in multi node setups we mark the user as deleted to be able to update the webdav cache
 	DeletedAt int64 `json:"-"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // GetFilesystem returns the base filesystem for this user
@@ -156,11 +170,14 @@ func (u *User) GetFilesystem(connectionID string) (fs vfs.Fs, err error) {
 func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 	switch u.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
-		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)
+		return u.FsConfig.S3Config.Encryption.WrapFs(
+			vfs.WithDiskCache(vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)))
 	case sdk.GCSFilesystemProvider:
-		return vfs.NewGCSFs(connectionID, u.GetHomeDir(), "", u.FsConfig.GCSConfig)
+		return u.FsConfig.GCSConfig.Encryption.WrapFs(
+			vfs.WithDiskCache(vfs.NewGCSFs(connectionID, u.GetHomeDir(), "", u.FsConfig.GCSConfig)))
 	case sdk.AzureBlobFilesystemProvider:
-		return vfs.NewAzBlobFs(connectionID, u.GetHomeDir(), "", u.FsConfig.AzBlobConfig)
+		return u.FsConfig.AzBlobConfig.Encryption.WrapFs(
+			vfs.WithDiskCache(vfs.NewAzBlobFs(connectionID, u.GetHomeDir(), "", u.FsConfig.AzBlobConfig)))
 	case sdk.CryptedFilesystemProvider:
 		return vfs.NewCryptFs(connectionID, u.GetHomeDir(), "", u.FsConfig.CryptConfig)
 	case sdk.SFTPFilesystemProvider:
@@ -169,14 +186,75 @@ func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
 			return nil, err
 		}
 		forbiddenSelfUsers = append(forbiddenSelfUsers, u.Username)
-		return vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
+		return u.FsConfig.SFTPConfig.Encryption.WrapFs(
+			vfs.WithDiskCache(vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)))
 	case sdk.HTTPFilesystemProvider:
 		return vfs.NewHTTPFs(connectionID, u.GetHomeDir(), "", u.FsConfig.HTTPConfig)
+	case vfs.WebDAVFilesystemProvider:
+		return vfs.WithDiskCache(vfs.NewWebDAVFs(connectionID, u.GetHomeDir(), "", u.FsConfig.WebDAVConfig))
+	case vfs.FTPFilesystemProvider:
+		return vfs.WithDiskCache(vfs.NewFTPFs(connectionID, u.GetHomeDir(), "", u.FsConfig.FTPConfig))
+	case vfs.SMBFilesystemProvider:
+		return vfs.WithDiskCache(vfs.NewSMBFs(connectionID, u.GetHomeDir(), "", u.FsConfig.SMBConfig))
+	case vfs.OverlayFilesystemProvider:
+		return u.getOverlayFs(connectionID)
 	default:
 		return vfs.NewOsFs(connectionID, u.GetHomeDir(), ""), nil
 	}
 }
 
+// getOverlayFs returns the overlay filesystem. The layers are the configured
+// virtual folders, loaded from the data provider. The home directory is the
+// writable layer if no virtual folder is configured for it
+func (u *User) getOverlayFs(connectionID string) (vfs.Fs, error) {
+	var layers []vfs.Fs
+	closeLayers := func() {
+		for _, layer := range layers {
+			layer.Close()
+		}
+	}
+	replacer := u.getGroupPlacehodersReplacer()
+	for _, name := range u.FsConfig.OverlayConfig.Layers {
+		fs, err := u.getOverlayLayer(name, connectionID, replacer)
+		if err != nil {
+			closeLayers()
+			return nil, err
+		}
+		layers = append(layers, fs)
+	}
+	upper := vfs.NewOsFs(connectionID, u.GetHomeDir(), "")
+	if name := u.FsConfig.OverlayConfig.Upper; name != "" {
+		fs, err := u.getOverlayLayer(name, connectionID, replacer)
+		if err != nil {
+			closeLayers()
+			return nil, err
+		}
+		upper = fs
+	}
+	return vfs.NewOverlayFs(upper, layers), nil
+}
+
+func (u *User) getOverlayLayer(name, connectionID string, replacer *strings.Replacer) (vfs.Fs, error) {
+	folder, err := provider.getFolderByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get overlay layer %q: %w", name, err)
+	}
+	if folder.FsConfig.Provider == vfs.OverlayFilesystemProvider {
+		return nil, fmt.Errorf("overlay layer %q cannot be an overlay filesystem", name)
+	}
+	folder.MappedPath = u.replacePlaceholder(folder.MappedPath, replacer)
+	folder.FsConfig = u.replaceFsConfigPlaceholders(folder.FsConfig, replacer)
+	vfolder := vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/",
+	}
+	fs, err := vfolder.GetFilesystem(connectionID, []string{u.Username})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create overlay layer %q: %w", name, err)
+	}
+	return fs, nil
+}
+
 func (u *User) checkDirWithParents(virtualDirPath, connectionID string) error {
 	dirs := util.GetDirsForVirtualPath(virtualDirPath)
 	for idx := len(dirs) - 1; idx >= 0; idx-- {
@@ -217,10 +295,13 @@ func (u *User) checkLocalHomeDir(connectionID string) {
 	switch u.FsConfig.Provider {
 	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
 		return
-	default:
-		osFs := vfs.NewOsFs(connectionID, u.GetHomeDir(), "")
-		osFs.CheckRootPath(u.Username, u.GetUID(), u.GetGID())
+	case vfs.OverlayFilesystemProvider:
+		if u.FsConfig.OverlayConfig.Upper == "" {
+			return
+		}
 	}
+	osFs := vfs.NewOsFs(connectionID, u.GetHomeDir(), "")
+	osFs.CheckRootPath(u.Username, u.GetUID(), u.GetGID())
 }
 
 func (u *User) checkRootPath(connectionID string) error {
@@ -342,13 +423,18 @@ func (u *User) CheckLoginConditions() error {
 		return fmt.Errorf("user %q is expired, expiration timestamp: %v current timestamp: %v", u.Username,
 			u.ExpirationDate, util.GetTimeAsMsSinceEpoch(time.Now()))
 	}
+	if u.Filters.CryptFsReEncryption != nil {
+		return fmt.Errorf("user %q: %w", u.Username, ErrCryptFsReEncryptionInProgress)
+	}
 	return nil
 }
 
 // hideConfidentialData hides user confidential data
 func (u *User) hideConfidentialData() {
 	u.Password = ""
+	u.Filters.PasswordHistory = nil
 	u.FsConfig.HideConfidentialData()
+	u.Filters.CryptFsReEncryption.hideConfidentialData()
 	if u.Filters.TOTPConfig.Secret != nil {
 		u.Filters.TOTPConfig.Secret.Hide()
 	}
@@ -359,6 +445,16 @@ func (u *User) hideConfidentialData() {
 	}
 }
 
+// getSecrets returns the secrets stored for this user
+func (u *User) getSecrets() []*kms.Secret {
+	secrets := u.FsConfig.GetSecrets()
+	secrets = append(secrets, u.Filters.TOTPConfig.Secret)
+	for _, code := range u.Filters.RecoveryCodes {
+		secrets = append(secrets, code.Secret)
+	}
+	return secrets
+}
+
 // GetSubDirPermissions returns permissions for sub directories
 func (u *User) GetSubDirPermissions() []sdk.DirectoryPermissions {
 	var result []sdk.DirectoryPermissions
@@ -409,6 +505,7 @@ func (u *User) RenderAsJSON(reload bool) ([]byte, error) {
 // so they are not serialized
 func (u *User) PrepareForRendering() {
 	u.hideConfidentialData()
+	u.PublicKeysInfo = u.GetPublicKeysInfo()
 	u.FsConfig.SetNilSecretsIfEmpty()
 	for idx := range u.VirtualFolders {
 		folder := &u.VirtualFolders[idx]
@@ -624,6 +721,53 @@ func (u *User) CheckMetadataConsistency() error {
 	return nil
 }
 
+// CleanupAbandonedUploads removes the incomplete uploads, not updated after the
+// specified time, for the user home dir and virtual folders, releases the quota
+// charged for them and returns the number of removed uploads
+func (u *User) CleanupAbandonedUploads(olderThan time.Time) (int, error) {
+	fs, err := u.getRootFs(xid.New().String())
+	if err != nil {
+		return 0, err
+	}
+	defer fs.Close()
+
+	result, err := vfs.CleanupAbandonedUploads(fs, olderThan)
+	u.releaseAbandonedUploadsQuota(nil, result)
+	removed := result.Removed
+	if err != nil {
+		return removed, err
+	}
+	for idx := range u.VirtualFolders {
+		v := &u.VirtualFolders[idx]
+		result, err := v.CleanupAbandonedUploads(olderThan)
+		u.releaseAbandonedUploadsQuota(v, result)
+		removed += result.Removed
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (u *User) releaseAbandonedUploadsQuota(folder *vfs.VirtualFolder, result vfs.AbandonedUploadsCleanupResult) {
+	if result.QuotaFiles == 0 && result.QuotaSize == 0 {
+		return
+	}
+	if folder != nil {
+		if err := UpdateVirtualFolderQuota(&folder.BaseVirtualFolder, -result.QuotaFiles, -result.QuotaSize, false); err != nil {
+			providerLog(logger.LevelError, "unable to release the quota for abandoned uploads, folder %q: %v",
+				folder.Name, err)
+		}
+		if !folder.IsIncludedInUserQuota() {
+			return
+		}
+	}
+	if err := UpdateUserQuota(u, -result.QuotaFiles, -result.QuotaSize, false); err != nil {
+		providerLog(logger.LevelError, "unable to release the quota for abandoned uploads, user %q: %v",
+			u.Username, err)
+	}
+}
+
 // ScanQuota scans the user home dir and virtual folders, included in its quota,
 // and returns the number of files and their size
 func (u *User) ScanQuota() (int, int64, error) {
@@ -699,7 +843,27 @@ func (u *User) hasVirtualDirs() bool {
 	return numFolders > 0
 }
 
-// FilterListDir adds virtual folders and remove hidden items from the given files list
+// GetVirtualFoldersInfo returns the virtual folders, not hidden by the file patterns
+// filters, inside the specified virtual path as directory entries
+func (u *User) GetVirtualFoldersInfo(virtualPath string) []os.FileInfo {
+	if !u.hasVirtualDirs() {
+		return nil
+	}
+	filter := u.getPatternsFilterForPath(virtualPath)
+	vdirs := u.GetVirtualFoldersInPath(virtualPath)
+	result := make([]os.FileInfo, 0, len(vdirs))
+	for dir := range vdirs {
+		dirName := path.Base(dir)
+		if filter.DenyPolicy == sdk.DenyPolicyHide && !filter.CheckAllowed(dirName) {
+			continue
+		}
+		result = append(result, vfs.NewFileInfo(dirName, true, 0, time.Unix(0, 0), false))
+	}
+	return result
+}
+
+// FilterListDir removes hidden items and items overridden by virtual folders from the
+// given files list. Virtual folders are returned by GetVirtualFoldersInfo
 func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os.FileInfo {
 	filter := u.getPatternsFilterForPath(virtualPath)
 	if !u.hasVirtualDirs() && filter.DenyPolicy != sdk.DenyPolicyHide {
@@ -708,46 +872,24 @@ func (u *User) FilterListDir(dirContents []os.FileInfo, virtualPath string) []os
 
 	vdirs := make(map[string]bool)
 	for dir := range u.GetVirtualFoldersInPath(virtualPath) {
-		dirName := path.Base(dir)
-		if filter.DenyPolicy == sdk.DenyPolicyHide {
-			if !filter.CheckAllowed(dirName) {
-				continue
-			}
-		}
-		vdirs[dirName] = true
+		vdirs[path.Base(dir)] = true
 	}
 
 	validIdx := 0
-	for index, fi := range dirContents {
-		for dir := range vdirs {
-			if fi.Name() == dir {
-				if !fi.IsDir() {
-					fi = vfs.NewFileInfo(dir, true, 0, time.Unix(0, 0), false)
-					dirContents[index] = fi
-				}
-				delete(vdirs, dir)
-			}
-		}
-		if filter.DenyPolicy == sdk.DenyPolicyHide {
-			if filter.CheckAllowed(fi.Name()) {
-				dirContents[validIdx] = fi
-				validIdx++
-			}
+	for _, fi := range dirContents {
+		if vdirs[fi.Name()] {
+			continue
 		}
-	}
-
-	if filter.DenyPolicy == sdk.DenyPolicyHide {
-		for idx := validIdx; idx < len(dirContents); idx++ {
-			dirContents[idx] = nil
+		if filter.DenyPolicy == sdk.DenyPolicyHide && !filter.CheckAllowed(fi.Name()) {
+			continue
 		}
-		dirContents = dirContents[:validIdx]
+		dirContents[validIdx] = fi
+		validIdx++
 	}
-
-	for dir := range vdirs {
-		fi := vfs.NewFileInfo(dir, true, 0, time.Unix(0, 0), false)
-		dirContents = append(dirContents, fi)
+	for idx := validIdx; idx < len(dirContents); idx++ {
+		dirContents[idx] = nil
 	}
-	return dirContents
+	return dirContents[:validIdx]
 }
 
 // IsMappedPath returns true if the specified filesystem path has a virtual folder mapping.
@@ -920,6 +1062,10 @@ func (u *User) IsLoginMethodAllowed(loginMethod, protocol string, partialSuccess
 // can continue for multi-step authentication
 func (u *User) GetNextAuthMethods(partialSuccessMethods []string, isPasswordAuthEnabled bool) []string {
 	var methods []string
+	if u.GetSSHRADIUSFirstFactorMethod(partialSuccessMethods) != "" {
+		// only the RADIUS second factor is missing, it is asked using keyboard interactive authentication
+		return []string{SSHLoginMethodKeyboardInteractive}
+	}
 	if len(partialSuccessMethods) != 1 {
 		return methods
 	}
@@ -1020,6 +1166,51 @@ func (u *User) getMinPasswordEntropy() float64 {
 	return config.PasswordValidation.Users.MinEntropy
 }
 
+// GetPublicKeysInfo returns the parsed public keys, in the same order as the PublicKeys field.
+// Keys that cannot be parsed are returned with an empty fingerprint
+func (u *User) GetPublicKeysInfo() []PublicKey {
+	if len(u.PublicKeys) == 0 {
+		return nil
+	}
+	result := make([]PublicKey, 0, len(u.PublicKeys))
+	for _, k := range u.PublicKeys {
+		pk, err := ParsePublicKey(k)
+		if err != nil {
+			result = append(result, PublicKey{})
+			continue
+		}
+		pk.LastUseAt = u.Filters.PublicKeysLastUse[pk.Fingerprint]
+		result = append(result, pk)
+	}
+	return result
+}
+
+// mergePublicKeysLastUse merges the stored last use for the user public keys.
+// The last use is updated outside of the user update, so the most recent value wins
+func (u *User) mergePublicKeysLastUse(stored map[string]int64) {
+	if len(stored) == 0 {
+		return
+	}
+	for _, pk := range u.GetPublicKeysInfo() {
+		if pk.Fingerprint == "" {
+			continue
+		}
+		if val, ok := stored[pk.Fingerprint]; ok && val > pk.LastUseAt {
+			if u.Filters.PublicKeysLastUse == nil {
+				u.Filters.PublicKeysLastUse = make(map[string]int64)
+			}
+			u.Filters.PublicKeysLastUse[pk.Fingerprint] = val
+		}
+	}
+}
+
+func (u *User) getPasswordHistorySize() int {
+	if u.Filters.PasswordHistorySize > 0 {
+		return u.Filters.PasswordHistorySize
+	}
+	return config.PasswordValidation.Users.HistorySize
+}
+
 // IsFileAllowed returns true if the specified file is allowed by the file restrictions filters.
 // The second parameter returned is the deny policy
 func (u *User) IsFileAllowed(virtualPath string) (bool, int) {
@@ -1144,15 +1335,10 @@ func (u *User) MustChangePassword() bool {
 
 // MustSetSecondFactor returns true if the user must set a second factor authentication
 func (u *User) MustSetSecondFactor() bool {
-	if len(u.Filters.TwoFactorAuthProtocols) > 0 {
-		if !u.Filters.TOTPConfig.Enabled {
+	for _, p := range u.Filters.TwoFactorAuthProtocols {
+		if u.MustSetSecondFactorForProtocol(p) {
 			return true
 		}
-		for _, p := range u.Filters.TwoFactorAuthProtocols {
-			if !util.Contains(u.Filters.TOTPConfig.Protocols, p) {
-				return true
-			}
-		}
 	}
 	return false
 }
@@ -1161,6 +1347,9 @@ func (u *User) MustSetSecondFactor() bool {
 // for the specified protocol
 func (u *User) MustSetSecondFactorForProtocol(protocol string) bool {
 	if util.Contains(u.Filters.TwoFactorAuthProtocols, protocol) {
+		if u.MustUseRADIUSSecondFactor(protocol) {
+			return false
+		}
 		if !u.Filters.TOTPConfig.Enabled {
 			return true
 		}
@@ -1455,6 +1644,17 @@ func (u *User) GetStorageDescrition() string {
 		return fmt.Sprintf("SFTP: %v", u.FsConfig.SFTPConfig.Endpoint)
 	case sdk.HTTPFilesystemProvider:
 		return fmt.Sprintf("HTTP: %v", u.FsConfig.HTTPConfig.Endpoint)
+	case vfs.WebDAVFilesystemProvider:
+		return fmt.Sprintf("WebDAV: %v", u.FsConfig.WebDAVConfig.Endpoint)
+	case vfs.FTPFilesystemProvider:
+		return fmt.Sprintf("FTP: %v", u.FsConfig.FTPConfig.Endpoint)
+	case vfs.SMBFilesystemProvider:
+		return fmt.Sprintf("SMB: %v/%v", u.FsConfig.SMBConfig.Endpoint, u.FsConfig.SMBConfig.Share)
+	case vfs.OverlayFilesystemProvider:
+		if u.FsConfig.OverlayConfig.Upper != "" {
+			return fmt.Sprintf("Overlay: %v", u.FsConfig.OverlayConfig.Upper)
+		}
+		return fmt.Sprintf("Overlay: %v", u.GetHomeDir())
 	default:
 		return ""
 	}
@@ -1714,6 +1914,14 @@ func (u *User) replaceFsConfigPlaceholders(fsConfig vfs.Filesystem, replacer *st
 		fsConfig.SFTPConfig.Prefix = u.replacePlaceholder(fsConfig.SFTPConfig.Prefix, replacer)
 	case sdk.HTTPFilesystemProvider:
 		fsConfig.HTTPConfig.Username = u.replacePlaceholder(fsConfig.HTTPConfig.Username, replacer)
+	case vfs.WebDAVFilesystemProvider:
+		fsConfig.WebDAVConfig.Username = u.replacePlaceholder(fsConfig.WebDAVConfig.Username, replacer)
+	case vfs.FTPFilesystemProvider:
+		fsConfig.FTPConfig.Username = u.replacePlaceholder(fsConfig.FTPConfig.Username, replacer)
+		fsConfig.FTPConfig.Prefix = u.replacePlaceholder(fsConfig.FTPConfig.Prefix, replacer)
+	case vfs.SMBFilesystemProvider:
+		fsConfig.SMBConfig.Username = u.replacePlaceholder(fsConfig.SMBConfig.Username, replacer)
+		fsConfig.SMBConfig.Prefix = u.replacePlaceholder(fsConfig.SMBConfig.Prefix, replacer)
 	}
 	return fsConfig
 }
@@ -1748,6 +1956,9 @@ func (u *User) mergeWithPrimaryGroup(group Group, replacer *strings.Replacer) {
 	if u.ExpirationDate == 0 && group.UserSettings.ExpiresIn > 0 {
 		u.ExpirationDate = u.CreatedAt + int64(group.UserSettings.ExpiresIn)*86400000
 	}
+	if u.Filters.PasswordHistorySize == 0 {
+		u.Filters.PasswordHistorySize = group.UserSettings.PasswordHistorySize
+	}
 	u.mergePrimaryGroupFilters(group.UserSettings.Filters, replacer)
 	u.mergeAdditiveProperties(group, sdk.GroupTypePrimary, replacer)
 }
@@ -1924,6 +2135,18 @@ func (u *User) getACopy() User {
 			Used:   code.Used,
 		})
 	}
+	filters.PasswordHistorySize = u.Filters.PasswordHistorySize
+	if len(u.Filters.PublicKeysLastUse) > 0 {
+		filters.PublicKeysLastUse = make(map[string]int64, len(u.Filters.PublicKeysLastUse))
+		for k, v := range u.Filters.PublicKeysLastUse {
+			filters.PublicKeysLastUse[k] = v
+		}
+	}
+	filters.CryptFsReEncryption = u.Filters.CryptFsReEncryption.getACopy()
+	if len(u.Filters.PasswordHistory) > 0 {
+		filters.PasswordHistory = make([]string, len(u.Filters.PasswordHistory))
+		copy(filters.PasswordHistory, u.Filters.PasswordHistory)
+	}
 
 	return User{
 		BaseUser: sdk.BaseUser{
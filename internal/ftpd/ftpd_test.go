@@ -820,6 +820,54 @@ func TestListDirWithWildcards(t *testing.T) {
 	}
 }
 
+func TestListDirPages(t *testing.T) {
+	u := getTestUser()
+	u.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/",
+			DeniedPatterns:  []string{"*.denied"},
+			DenyPolicy:      sdk.DenyPolicyHide,
+			AllowedPatterns: []string{},
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// more entries than the ones read in a single page
+	numFiles := 250
+	for i := 0; i < numFiles; i++ {
+		err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), fmt.Sprintf("file%d.dat", i)), []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	err = os.WriteFile(filepath.Join(user.GetHomeDir(), "file.denied"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	for _, disableMLSD := range []bool{false, true} {
+		client, err := getFTPClient(user, true, nil, ftp.DialWithDisabledMLSD(disableMLSD))
+		if assert.NoError(t, err) {
+			entries, err := client.List("/")
+			assert.NoError(t, err)
+			assert.Len(t, entries, numFiles)
+			names, err := client.NameList("/")
+			assert.NoError(t, err)
+			assert.Len(t, names, numFiles)
+			if disableMLSD {
+				// wildcards are supported for LIST and NLST
+				entries, err = client.List("/file1*")
+				assert.NoError(t, err)
+				assert.Len(t, entries, 111)
+			}
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestStartDirectory(t *testing.T) {
 	startDir := "/start/dir"
 	u := getTestUser()
@@ -2168,6 +2216,159 @@ func TestResume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestFTPFs(t *testing.T) {
+	u := getTestUser()
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	folderName := "ftpfolder"
+	vdirPath := "/vdir"
+	mappedPath := filepath.Join(os.TempDir(), folderName)
+	u = getTestFTPFsUser()
+	u.QuotaFiles = 100
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+			FsConfig: vfs.Filesystem{
+				Provider: vfs.FTPFilesystemProvider,
+				FTPConfig: vfs.FTPFsConfig{
+					Endpoint:      ftpSrvAddrTLS,
+					Username:      defaultUsername,
+					Password:      kms.NewPlainSecret(defaultPassword),
+					TLSMode:       vfs.FTPTLSModeImplicit,
+					SkipTLSVerify: true,
+					DisableEPSV:   true,
+					DisableMLSD:   true,
+					Prefix:        "/sub",
+				},
+			},
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	err = os.MkdirAll(filepath.Join(localUser.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	ftpFsUser, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	client, err := getFTPClient(ftpFsUser, true, nil)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		data := []byte("test data")
+		err = os.WriteFile(testFilePath, data, os.ModePerm)
+		assert.NoError(t, err)
+		err = ftpUploadFile(testFilePath, testFileName, int64(len(data)), client, 0)
+		assert.NoError(t, err)
+		// an offset other than the remote file size is not supported
+		err = ftpUploadFile(testFilePath, testFileName, int64(len(data)+5), client, 5)
+		assert.Error(t, err)
+		// resume the upload, the remote server receives a REST offset
+		err = ftpUploadFile(testFilePath, testFileName, int64(2*len(data)), client, uint64(len(data)))
+		assert.NoError(t, err)
+		readed, err := os.ReadFile(filepath.Join(localUser.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, "test datatest data", string(readed))
+		localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+		err = ftpDownloadFile(testFileName, localDownloadPath, int64(2*len(data)-5), client, 5)
+		assert.NoError(t, err)
+		readed, err = os.ReadFile(localDownloadPath)
+		assert.NoError(t, err)
+		assert.Equal(t, "datatest data", string(readed))
+		err = ftpDownloadFile(testFileName, localDownloadPath, int64(2*len(data)), client, 0)
+		assert.NoError(t, err)
+		// set the modification time
+		mtime := time.Date(2020, 10, 11, 12, 13, 14, 0, time.UTC)
+		code, response, err := client.SendCustomCommand(fmt.Sprintf("MFMT %s %s", mtime.Format("20060102150405"), testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFile, code, response)
+		info, err := os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, mtime.Unix(), info.ModTime().Unix())
+		}
+		code, response, err = client.SendCustomCommand("AVBL")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFile, code, response)
+		// the virtual folder uses implicit TLS, PASV and LIST
+		err = ftpUploadFile(testFilePath, path.Join(vdirPath, testFileName), int64(len(data)), client, 0)
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), "sub", testFileName))
+		assert.NoError(t, err)
+		err = ftpDownloadFile(path.Join(vdirPath, testFileName), localDownloadPath, int64(len(data)), client, 0)
+		assert.NoError(t, err)
+		entries, err := client.List(vdirPath)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, testFileName, entries[0].Name)
+			assert.Equal(t, uint64(len(data)), entries[0].Size)
+		}
+
+		testDir := "tdir"
+		err = client.MakeDir(testDir)
+		assert.NoError(t, err)
+		err = client.MakeDir(path.Join(testDir, testDir))
+		assert.NoError(t, err)
+		err = client.MakeDir(testDir)
+		assert.Error(t, err)
+		err = client.Rename(testDir, testDir+"_renamed")
+		assert.NoError(t, err)
+		info, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testDir+"_renamed", testDir))
+		if assert.NoError(t, err) {
+			assert.True(t, info.IsDir())
+		}
+		err = client.Rename(testFileName, path.Join(testDir+"_renamed", testFileName))
+		assert.NoError(t, err)
+		entries, err = client.List("/")
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 3) {
+			for _, entry := range entries {
+				switch entry.Name {
+				case "sub", "vdir", testDir + "_renamed":
+					assert.Equal(t, ftp.EntryTypeFolder, entry.Type)
+				default:
+					t.Errorf("unexpected file/dir %q", entry.Name)
+				}
+			}
+		}
+		user, _, err := httpdtest.GetUserByUsername(ftpFsUser.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, int64(3*len(data)), user.UsedQuotaSize)
+
+		err = client.Delete(path.Join(testDir+"_renamed", testFileName))
+		assert.NoError(t, err)
+		err = client.RemoveDir(path.Join(testDir+"_renamed", testDir))
+		assert.NoError(t, err)
+		err = client.RemoveDir(testDir + "_renamed")
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testDir+"_renamed"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		err = client.Delete(testFileName)
+		assert.Error(t, err)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+		err = os.Remove(localDownloadPath)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(ftpFsUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(ftpFsUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 //nolint:dupl
 func TestDeniedLoginMethod(t *testing.T) {
 	u := getTestUser()
@@ -3918,6 +4119,19 @@ func getTestSFTPUser() dataprovider.User {
 	return u
 }
 
+func getTestFTPFsUser() dataprovider.User {
+	u := getTestUser()
+	u.Username = u.Username + "_ftpfs"
+	u.HomeDir = filepath.Join(os.TempDir(), u.Username)
+	u.FsConfig.Provider = vfs.FTPFilesystemProvider
+	u.FsConfig.FTPConfig.Endpoint = ftpServerAddr
+	u.FsConfig.FTPConfig.Username = defaultUsername
+	u.FsConfig.FTPConfig.Password = kms.NewPlainSecret(defaultPassword)
+	u.FsConfig.FTPConfig.TLSMode = vfs.FTPTLSModeExplicit
+	u.FsConfig.FTPConfig.SkipTLSVerify = true
+	return u
+}
+
 func getTestUserWithHTTPFs() dataprovider.User {
 	u := getTestUser()
 	u.FsConfig.Provider = sdk.HTTPFilesystemProvider
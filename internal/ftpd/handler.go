@@ -290,10 +290,14 @@ func (c *Connection) Symlink(oldname, newname string) error {
 	return c.BaseConnection.CreateSymlink(oldname, newname)
 }
 
-// ReadDir implements ClientDriverExtensionFilelist
+// ReadDir implements ClientDriverExtensionFilelist.
+// The FTP server library requires the full directory listing, the entries are
+// read and filtered page by page
 func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 	c.UpdateLastActivity()
 
+	var lister vfs.DirLister
+	var err error
 	if c.doWildcardListDir {
 		c.doWildcardListDir = false
 		baseName := path.Base(name)
@@ -302,10 +306,14 @@ func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 		// - dir*/*.xml is not supported
 		name = path.Dir(name)
 		c.clientContext.SetListPath(name)
-		return c.getListDirWithWildcards(name, baseName)
+		lister, err = c.getListDirWithWildcards(name, baseName)
+	} else {
+		lister, err = c.ListDir(name)
 	}
-
-	return c.ListDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return vfs.ReadAllDirEntries(lister)
 }
 
 // GetHandle implements ClientDriverExtentionFileTransfer
@@ -373,6 +381,13 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 	}
 
 	stat, statErr := fs.Lstat(fsPath)
+	if fs.IsNotExist(statErr) {
+		// interrupted cloud uploads are not visible and are included in the quota,
+		// a new upload resumes or replaces them
+		if info, err := vfs.GetPendingUploadInfo(fs, fsPath); err == nil {
+			stat, statErr = info, nil
+		}
+	}
 	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(ftpPath)) {
 			return nil, fmt.Errorf("%w, no upload permission", ftpserver.ErrFileNameNotAllowed)
@@ -506,29 +521,42 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	return t, nil
 }
 
-func (c *Connection) getListDirWithWildcards(dirName, pattern string) ([]os.FileInfo, error) {
-	files, err := c.ListDir(dirName)
+func (c *Connection) getListDirWithWildcards(dirName, pattern string) (vfs.DirLister, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	lister, err := c.ListDir(dirName)
 	if err != nil {
-		return files, err
+		return nil, err
 	}
-	validIdx := 0
 	var relativeBase string
 	if c.clientContext.GetLastCommand() != "NLST" {
 		relativeBase = getPathRelativeTo(c.clientContext.Path(), dirName)
 	}
+	return &patternDirLister{
+		DirListerAt:  lister,
+		pattern:      pattern,
+		relativeBase: relativeBase,
+	}, nil
+}
+
+// patternDirLister returns the directory entries matching a pattern
+type patternDirLister struct {
+	*common.DirListerAt
+	pattern      string
+	relativeBase string
+}
+
+func (l *patternDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.DirListerAt.Next(limit)
+	result := make([]os.FileInfo, 0, len(files))
 	for _, fi := range files {
-		match, err := path.Match(pattern, fi.Name())
-		if err != nil {
-			return files, err
-		}
-		if match {
-			files[validIdx] = vfs.NewFileInfo(path.Join(relativeBase, fi.Name()), fi.IsDir(), fi.Size(),
-				fi.ModTime(), true)
-			validIdx++
+		if match, _ := path.Match(l.pattern, fi.Name()); match {
+			result = append(result, vfs.NewFileInfo(path.Join(l.relativeBase, fi.Name()), fi.IsDir(), fi.Size(),
+				fi.ModTime(), true))
 		}
 	}
-
-	return files[:validIdx], nil
+	return result, err
 }
 
 func (c *Connection) isListDirWithWildcards(name string) bool {
@@ -19,6 +19,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
 	"os"
@@ -880,6 +881,29 @@ func TestTransferErrors(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, common.ErrOpUnsupported.Error())
 	}
+	// resumed upload to a cloud backend
+	r, w, err = pipeat.Pipe()
+	assert.NoError(t, err)
+	pipeWriter = vfs.NewPipeWriterAtOffset(w, 10)
+	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testfile, testfile, testfile,
+		common.TransferUpload, 10, 10, 0, 0, false, fs, dataprovider.TransferQuota{})
+	tr = newTransfer(baseTransfer, pipeWriter, nil, 0)
+	offset, err := tr.Seek(10, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), offset)
+	_, err = pipeWriter.WriteAt([]byte("a"), 9)
+	assert.Error(t, err)
+	_, err = tr.Seek(5, io.SeekStart)
+	assert.ErrorIs(t, err, common.ErrOpUnsupported)
+	err = r.Close()
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pipeWriter.Done(nil)
+	}()
+	err = tr.closeIO()
+	assert.NoError(t, err)
+
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
 }
@@ -106,6 +106,10 @@ func (t *transfer) Seek(offset int64, whence int) (int64, error) {
 	if t.reader != nil && t.expectedOffset == offset && whence == io.SeekStart {
 		return offset, nil
 	}
+	if t.writer != nil && t.MinWriteOffset == offset && whence == io.SeekStart {
+		// resumed upload to a cloud backend, the writer already starts at the requested offset
+		return offset, nil
+	}
 	t.TransferError(errors.New("seek is unsupported for this transfer"))
 	return 0, common.ErrOpUnsupported
 }
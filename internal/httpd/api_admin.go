@@ -57,6 +57,7 @@ func renderAdmin(w http.ResponseWriter, r *http.Request, username string, status
 		return
 	}
 	admin.HideConfidentialData()
+	setETagHeader(w, admin.UpdatedAt)
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusCreated)
 		render.JSON(w, r.WithContext(ctx), admin)
@@ -113,6 +114,11 @@ func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
 
 func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	username := getURLParam(r, "username")
 	admin, err := dataprovider.AdminExists(username)
 	if err != nil {
@@ -158,6 +164,8 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
+	updatedAdmin.Filters.PasswordHistory = admin.Filters.PasswordHistory
+	updatedAdmin.ExpectedVersion = version
 	err = dataprovider.UpdateAdmin(&updatedAdmin, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -168,6 +176,11 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 
 func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	username := getURLParam(r, "username")
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -179,7 +192,7 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = dataprovider.DeleteAdmin(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteAdminIfVersion(username, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
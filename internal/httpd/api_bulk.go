@@ -0,0 +1,131 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type bulkUpdateRequest struct {
+	// Names of the objects to update, mutually exclusive with filters
+	Names []string `json:"names,omitempty"`
+	// Filters to select the objects to update, the supported filters depend on
+	// the object type
+	Filters json.RawMessage `json:"filters,omitempty"`
+	dataprovider.BulkUpdateOptions
+}
+
+func (r *bulkUpdateRequest) hasFilters() bool {
+	filters := bytes.TrimSpace(r.Filters)
+	return len(filters) > 0 && !bytes.Equal(filters, []byte("null"))
+}
+
+func (r *bulkUpdateRequest) decodeFilters(filters any) error {
+	if err := json.Unmarshal(r.Filters, filters); err != nil {
+		return util.NewValidationError(fmt.Sprintf("invalid filters: %v", err))
+	}
+	return nil
+}
+
+func decodeBulkUpdateRequest(w http.ResponseWriter, r *http.Request) (bulkUpdateRequest, *jwtTokenClaims, bool) {
+	var req bulkUpdateRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return req, nil, false
+	}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return req, nil, false
+	}
+	return req, &claims, true
+}
+
+func renderBulkUpdateResults(w http.ResponseWriter, r *http.Request, results dataprovider.BulkUpdateResults, err error) {
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	status := http.StatusOK
+	if results.HasFailures() {
+		status = http.StatusBadRequest
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+	render.JSON(w, r.WithContext(ctx), results)
+}
+
+func bulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	req, claims, ok := decodeBulkUpdateRequest(w, r)
+	if !ok {
+		return
+	}
+	var filters *dataprovider.UserSearchFilters
+	if req.hasFilters() {
+		filters = &dataprovider.UserSearchFilters{}
+		if err := req.decodeFilters(filters); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := dataprovider.BulkUpdateUsers(req.Names, filters, req.BulkUpdateOptions, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	renderBulkUpdateResults(w, r, results, err)
+}
+
+func bulkUpdateGroups(w http.ResponseWriter, r *http.Request) {
+	req, claims, ok := decodeBulkUpdateRequest(w, r)
+	if !ok {
+		return
+	}
+	var filters *dataprovider.SearchFilters
+	if req.hasFilters() {
+		filters = &dataprovider.SearchFilters{}
+		if err := req.decodeFilters(filters); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := dataprovider.BulkUpdateGroups(req.Names, filters, req.BulkUpdateOptions, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	renderBulkUpdateResults(w, r, results, err)
+}
+
+func bulkUpdateFolders(w http.ResponseWriter, r *http.Request) {
+	req, claims, ok := decodeBulkUpdateRequest(w, r)
+	if !ok {
+		return
+	}
+	var filters *dataprovider.FolderSearchFilters
+	if req.hasFilters() {
+		filters = &dataprovider.FolderSearchFilters{}
+		if err := req.decodeFilters(filters); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := dataprovider.BulkUpdateFolders(req.Names, filters, req.BulkUpdateOptions, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	renderBulkUpdateResults(w, r, results, err)
+}
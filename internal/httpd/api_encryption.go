@@ -0,0 +1,145 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/common"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+type kmsRotationRequest struct {
+	MasterKey string `json:"master_key"`
+}
+
+type cryptFsReEncryptionRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func rotateKMSMasterKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if err := kms.CanRotateMasterKey(); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	var req kmsRotationRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if req.MasterKey == "" {
+		sendAPIResponse(w, r, nil, "The new master key is mandatory", http.StatusBadRequest)
+		return
+	}
+	result, err := dataprovider.RotateKMSMasterKey(req.MasterKey, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+func getCryptFsReEncryptions(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	render.JSON(w, r, common.CryptFsReEncryptions.Get(claims.Role))
+}
+
+func startCryptFsReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req cryptFsReEncryptionRequest
+	err = render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if req.Passphrase == "" {
+		sendAPIResponse(w, r, nil, "The new passphrase is mandatory", http.StatusBadRequest)
+		return
+	}
+	// the user's own filesystem is re-encrypted, group settings are ignored
+	user, err := dataprovider.UserExists(getURLParam(r, "username"), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if user.FsConfig.Provider != sdk.CryptedFilesystemProvider {
+		sendAPIResponse(w, r, errors.New("unsupported filesystem"),
+			fmt.Sprintf("User %q does not use a local encrypted filesystem", user.Username), http.StatusBadRequest)
+		return
+	}
+	if err := user.FsConfig.CryptConfig.Passphrase.TryDecrypt(); err != nil {
+		sendAPIResponse(w, r, err, "Unable to decrypt the current passphrase", http.StatusInternalServerError)
+		return
+	}
+	err = common.StartCryptFsReEncryption(user, req.Passphrase, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getCryptFsReEncryptionRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Re-encryption started", http.StatusAccepted)
+}
+
+func rollbackCryptFsReEncryption(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(getURLParam(r, "username"), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	err = common.RollbackCryptFsReEncryption(user, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
+		claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getCryptFsReEncryptionRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Re-encryption rollback started", http.StatusAccepted)
+}
+
+func getCryptFsReEncryptionRespStatus(err error) int {
+	if errors.Is(err, common.ErrCryptFsReEncryptionConflict) {
+		return http.StatusConflict
+	}
+	return getRespStatus(err)
+}
@@ -51,6 +51,7 @@ func renderEventAction(w http.ResponseWriter, r *http.Request, name string, clai
 	if hideConfidentialData(claims, r) {
 		action.PrepareForRendering()
 	}
+	setETagHeader(w, action.UpdatedAt)
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), action)
@@ -96,6 +97,11 @@ func addEventAction(w http.ResponseWriter, r *http.Request) {
 
 func updateEventAction(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -124,8 +130,13 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+	case dataprovider.ActionTypeBackup:
+		if updatedAction.Options.BackupConfig.Passphrase.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.BackupConfig.Passphrase = action.Options.BackupConfig.Passphrase
+		}
 	}
 
+	updatedAction.ExpectedVersion = version
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -136,13 +147,18 @@ func updateEventAction(w http.ResponseWriter, r *http.Request) {
 
 func deleteEventAction(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
 	name := getURLParam(r, "name")
-	err = dataprovider.DeleteEventAction(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteEventActionIfVersion(name, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -174,6 +190,7 @@ func renderEventRule(w http.ResponseWriter, r *http.Request, name string, claims
 	if hideConfidentialData(claims, r) {
 		rule.PrepareForRendering()
 	}
+	setETagHeader(w, rule.UpdatedAt)
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), rule)
@@ -218,6 +235,11 @@ func addEventRule(w http.ResponseWriter, r *http.Request) {
 
 func updateEventRule(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -239,6 +261,7 @@ func updateEventRule(w http.ResponseWriter, r *http.Request) {
 	updatedRule.ID = rule.ID
 	updatedRule.Name = rule.Name
 
+	updatedRule.ExpectedVersion = version
 	err = dataprovider.UpdateEventRule(&updatedRule, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -249,13 +272,18 @@ func updateEventRule(w http.ResponseWriter, r *http.Request) {
 
 func deleteEventRule(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
 	name := getURLParam(r, "name")
-	err = dataprovider.DeleteEventRule(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteEventRuleIfVersion(name, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -33,7 +33,7 @@ func getGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	groups, err := dataprovider.GetGroups(limit, offset, order, false)
+	groups, err := dataprovider.GetGroups(limit, offset, order, false, getNameSearchFilters(r.URL.Query()))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
 		return
@@ -66,6 +66,11 @@ func addGroup(w http.ResponseWriter, r *http.Request) {
 
 func updateGroup(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -89,6 +94,10 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	currentSFTPKeyPassphrase := group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase
 	currentHTTPPassword := group.UserSettings.FsConfig.HTTPConfig.Password
 	currentHTTPAPIKey := group.UserSettings.FsConfig.HTTPConfig.APIKey
+	currentWebDAVPassword := group.UserSettings.FsConfig.WebDAVConfig.Password
+	currentFTPPassword := group.UserSettings.FsConfig.FTPConfig.Password
+	currentSMBPassword := group.UserSettings.FsConfig.SMBConfig.Password
+	currentEncryptionPassphrase := group.UserSettings.FsConfig.GetEncryptionPassphrase()
 
 	var updatedGroup dataprovider.Group
 	err = render.DecodeJSON(r.Body, &updatedGroup)
@@ -101,7 +110,9 @@ func updateGroup(w http.ResponseWriter, r *http.Request) {
 	updatedGroup.UserSettings.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedGroup.UserSettings.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
 		currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-		currentHTTPPassword, currentHTTPAPIKey)
+		currentHTTPPassword, currentHTTPAPIKey, currentWebDAVPassword, currentFTPPassword, currentSMBPassword,
+		currentEncryptionPassphrase)
+	updatedGroup.ExpectedVersion = version
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
 		claims.Role)
 	if err != nil {
@@ -120,6 +131,7 @@ func renderGroup(w http.ResponseWriter, r *http.Request, name string, claims *jw
 	if hideConfidentialData(claims, r) {
 		group.PrepareForRendering()
 	}
+	setETagHeader(w, group.UpdatedAt)
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), group)
@@ -141,13 +153,18 @@ func getGroupByName(w http.ResponseWriter, r *http.Request) {
 
 func deleteGroup(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
 	name := getURLParam(r, "name")
-	err = dataprovider.DeleteGroup(name, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteGroupIfVersion(name, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
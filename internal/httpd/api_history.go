@@ -0,0 +1,169 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+// getHistoryPermission returns the admin permission required to view or
+// restore the versions of the specified object type
+func getHistoryPermission(objectType string, restore bool) (string, error) {
+	switch objectType {
+	case "user", "folder":
+		if restore {
+			return dataprovider.PermAdminChangeUsers, nil
+		}
+		return dataprovider.PermAdminViewUsers, nil
+	case "group":
+		return dataprovider.PermAdminManageGroups, nil
+	case "admin":
+		return dataprovider.PermAdminManageAdmins, nil
+	case "event_rule":
+		return dataprovider.PermAdminManageEventRules, nil
+	case "role":
+		return dataprovider.PermAdminManageRoles, nil
+	default:
+		return "", util.NewValidationError(fmt.Sprintf("unsupported object type %q", objectType))
+	}
+}
+
+// checkHistoryRequest returns the token claims if the admin has the permission
+// required for the requested object type, otherwise it sends an error response
+func checkHistoryRequest(w http.ResponseWriter, r *http.Request, restore bool) (*jwtTokenClaims, bool) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return nil, false
+	}
+	perm, err := getHistoryPermission(getURLParam(r, "objecttype"), restore)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return nil, false
+	}
+	if !claims.hasPerm(perm) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil, false
+	}
+	return &claims, true
+}
+
+func getVersionIDFromRequest(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(getURLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		sendAPIResponse(w, r, err, "Invalid version ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func getObjectHistory(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, ok := checkHistoryRequest(w, r, false)
+	if !ok {
+		return
+	}
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	versions, err := dataprovider.GetObjectHistory(getURLParam(r, "objecttype"), getURLParam(r, "name"),
+		limit, offset, order, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, versions)
+}
+
+func getObjectVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, ok := checkHistoryRequest(w, r, false)
+	if !ok {
+		return
+	}
+	id, ok := getVersionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	version, err := dataprovider.GetObjectVersion(getURLParam(r, "objecttype"), getURLParam(r, "name"), id,
+		claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, version)
+}
+
+func getObjectVersionDiff(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, ok := checkHistoryRequest(w, r, false)
+	if !ok {
+		return
+	}
+	id, ok := getVersionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var compareTo int64
+	if _, ok := r.URL.Query()["compare_to"]; ok {
+		val, err := strconv.ParseInt(r.URL.Query().Get("compare_to"), 10, 64)
+		if err != nil || val <= 0 {
+			sendAPIResponse(w, r, err, "Invalid compare_to version ID", http.StatusBadRequest)
+			return
+		}
+		compareTo = val
+	}
+	diff, err := dataprovider.GetObjectVersionDiff(getURLParam(r, "objecttype"), getURLParam(r, "name"), id,
+		compareTo, claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, diff)
+}
+
+func restoreObjectVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, ok := checkHistoryRequest(w, r, true)
+	if !ok {
+		return
+	}
+	id, ok := getVersionIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	objectType := getURLParam(r, "objecttype")
+	name := getURLParam(r, "name")
+	if objectType == "admin" && dataprovider.ConvertName(name) == claims.Username {
+		sendAPIResponse(w, r, errors.New("you cannot restore a previous version of yourself"), "", http.StatusBadRequest)
+		return
+	}
+	err := dataprovider.RestoreObjectVersion(objectType, name, id, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Version restored", http.StatusOK)
+}
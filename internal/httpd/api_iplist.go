@@ -62,6 +62,7 @@ func getIPListEntry(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	setETagHeader(w, entry.UpdatedAt)
 	render.JSON(w, r, entry)
 }
 
@@ -90,6 +91,11 @@ func addIPListEntry(w http.ResponseWriter, r *http.Request) {
 
 func updateIPListEntry(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -114,6 +120,7 @@ func updateIPListEntry(w http.ResponseWriter, r *http.Request) {
 	}
 	updatedEntry.Type = entry.Type
 	updatedEntry.IPOrNet = entry.IPOrNet
+	updatedEntry.ExpectedVersion = version
 	err = dataprovider.UpdateIPListEntry(&updatedEntry, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -124,6 +131,11 @@ func updateIPListEntry(w http.ResponseWriter, r *http.Request) {
 
 func deleteIPListEntry(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -135,8 +147,8 @@ func deleteIPListEntry(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	err = dataprovider.DeleteIPListEntry(ipOrNet, listType, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr),
-		claims.Role)
+	err = dataprovider.DeleteIPListEntryIfVersion(ipOrNet, listType, version, claims.Username,
+		util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
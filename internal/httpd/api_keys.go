@@ -51,6 +51,7 @@ func getAPIKeyByID(w http.ResponseWriter, r *http.Request) {
 	}
 	apiKey.HideConfidentialData()
 
+	setETagHeader(w, apiKey.UpdatedAt)
 	render.JSON(w, r, apiKey)
 }
 
@@ -87,6 +88,11 @@ func addAPIKey(w http.ResponseWriter, r *http.Request) {
 
 func updateAPIKey(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -108,6 +114,7 @@ func updateAPIKey(w http.ResponseWriter, r *http.Request) {
 
 	updatedAPIKey.KeyID = keyID
 	updatedAPIKey.Key = apiKey.Key
+	updatedAPIKey.ExpectedVersion = version
 	err = dataprovider.UpdateAPIKey(&updatedAPIKey, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -118,6 +125,11 @@ func updateAPIKey(w http.ResponseWriter, r *http.Request) {
 
 func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	keyID := getURLParam(r, "id")
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -125,7 +137,7 @@ func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = dataprovider.DeleteAPIKey(keyID, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteAPIKeyIfVersion(keyID, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
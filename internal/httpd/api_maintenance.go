@@ -15,6 +15,7 @@
 package httpd
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -120,7 +121,7 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
-	_, scanQuota, mode, err := getLoaddataOptions(r)
+	_, scanQuota, mode, validateOnly, err := getLoaddataOptions(r)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
@@ -134,11 +135,9 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if err := restoreBackup(content, "", scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
-		return
-	}
-	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
+	results, err := restoreBackup(content, r.Header.Get(backupKeyHeader), "", scanQuota, mode, validateOnly,
+		claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	renderRestoreResults(w, r, results, err)
 }
 
 func loadData(w http.ResponseWriter, r *http.Request) {
@@ -148,7 +147,7 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
-	inputFile, scanQuota, mode, err := getLoaddataOptions(r)
+	inputFile, scanQuota, mode, validateOnly, err := getLoaddataOptions(r)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
@@ -174,72 +173,114 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if err := restoreBackup(content, inputFile, scanQuota, mode, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role); err != nil {
+	results, err := restoreBackup(content, r.Header.Get(backupKeyHeader), inputFile, scanQuota, mode, validateOnly,
+		claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	renderRestoreResults(w, r, results, err)
+}
+
+func renderRestoreResults(w http.ResponseWriter, r *http.Request, results dataprovider.RestoreResults, err error) {
+	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
+	status := http.StatusOK
+	if results.HasFailures() {
+		status = http.StatusBadRequest
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+	render.JSON(w, r.WithContext(ctx), results)
 }
 
-func restoreBackup(content []byte, inputFile string, scanQuota, mode int, executor, ipAddress, role string) error {
-	dump, err := dataprovider.ParseDumpData(content)
-	if err != nil {
-		return util.NewValidationError(fmt.Sprintf("unable to parse backup content: %v", err))
+func importUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 3*dataprovider.MaxImportUsersSize+maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
 	}
-
-	if err = RestoreConfigs(dump.Configs, mode, executor, ipAddress, role); err != nil {
-		return err
+	var opts dataprovider.ImportUsersOptions
+	if err := render.DecodeJSON(r.Body, &opts); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
 	}
-
-	if err = RestoreIPListEntries(dump.IPLists, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+	results, err := dataprovider.ImportUsers(opts, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
 	}
+	render.JSON(w, r, results)
+}
 
-	if err = RestoreRoles(dump.Roles, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+func restoreBackup(content []byte, backupKey, inputFile string, scanQuota, mode int, validateOnly bool,
+	executor, ipAddress, role string,
+) (dataprovider.RestoreResults, error) {
+	results := dataprovider.RestoreResults{
+		ValidateOnly: validateOnly,
 	}
-
-	if err = RestoreFolders(dump.Folders, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
-		return err
+	content, err := dataprovider.DecodeBackup(content, backupKey, MaxRestoreSize)
+	if err != nil {
+		return results, err
 	}
-
-	if err = RestoreGroups(dump.Groups, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+	dump, err := dataprovider.ParseDumpData(content)
+	if err != nil {
+		return results, util.NewValidationError(fmt.Sprintf("unable to parse backup content: %v", err))
 	}
 
-	if err = RestoreUsers(dump.Users, inputFile, mode, scanQuota, executor, ipAddress, role); err != nil {
-		return err
+	results, err = dataprovider.RestoreBackup(&dump, dataprovider.RestoreOptions{
+		Mode:         mode,
+		ValidateOnly: validateOnly,
+	}, executor, ipAddress, role)
+	if err != nil || !results.Applied {
+		logger.Debug(logSender, "", "backup not restored, dump file: %q, validate only: %t, failures: %t, error: %v",
+			inputFile, validateOnly, results.HasFailures(), err)
+		return results, err
 	}
-
-	if err = RestoreAdmins(dump.Admins, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+	if mode == 2 {
+		for _, username := range results.GetUsers(dataprovider.RestoreStatusUpdated) {
+			disconnectUser(username, executor, role)
+		}
 	}
-
-	if err = RestoreAPIKeys(dump.APIKeys, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+	if scanQuota >= 1 {
+		startRestoredQuotaScans(&results, scanQuota)
 	}
+	logger.Debug(logSender, "", "backup restored, dump file: %q", inputFile)
 
-	if err = RestoreShares(dump.Shares, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
-	}
+	return results, nil
+}
 
-	if err = RestoreEventActions(dump.EventActions, inputFile, mode, executor, ipAddress, role); err != nil {
-		return err
+func startRestoredQuotaScans(results *dataprovider.RestoreResults, scanQuota int) {
+	for _, name := range results.GetFolders(dataprovider.RestoreStatusAdded, dataprovider.RestoreStatusUpdated) {
+		folder, err := dataprovider.GetFolderByName(name)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to get restored folder %q: %v", name, err)
+			continue
+		}
+		if common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
+			logger.Debug(logSender, "", "starting quota scan for restored folder: %q", folder.Name)
+			go doFolderQuotaScan(folder) //nolint:errcheck
+		}
 	}
-
-	if err = RestoreEventRules(dump.EventRules, inputFile, mode, executor, ipAddress, role, dump.Version); err != nil {
-		return err
+	for _, username := range results.GetUsers(dataprovider.RestoreStatusAdded, dataprovider.RestoreStatusUpdated) {
+		user, err := dataprovider.UserExists(username, "")
+		if err != nil {
+			logger.Warn(logSender, "", "unable to get restored user %q: %v", username, err)
+			continue
+		}
+		if scanQuota == 1 || (scanQuota == 2 && user.HasQuotaRestrictions()) {
+			if common.QuotaScans.AddUserQuotaScan(user.Username, user.Role) {
+				logger.Debug(logSender, "", "starting quota scan for restored user: %q", user.Username)
+				go doUserQuotaScan(user) //nolint:errcheck
+			}
+		}
 	}
-	logger.Debug(logSender, "", "backup restored")
-
-	return nil
 }
 
-func getLoaddataOptions(r *http.Request) (string, int, int, error) {
+func getLoaddataOptions(r *http.Request) (string, int, int, bool, error) {
 	var inputFile string
 	var err error
 	scanQuota := 0
 	restoreMode := 0
+	validateOnly := false
 	if _, ok := r.URL.Query()["input-file"]; ok {
 		inputFile = strings.TrimSpace(r.URL.Query().Get("input-file"))
 	}
@@ -247,17 +288,24 @@ func getLoaddataOptions(r *http.Request) (string, int, int, error) {
 		scanQuota, err = strconv.Atoi(r.URL.Query().Get("scan-quota"))
 		if err != nil {
 			err = fmt.Errorf("invalid scan_quota: %v", err)
-			return inputFile, scanQuota, restoreMode, err
+			return inputFile, scanQuota, restoreMode, validateOnly, err
 		}
 	}
 	if _, ok := r.URL.Query()["mode"]; ok {
 		restoreMode, err = strconv.Atoi(r.URL.Query().Get("mode"))
 		if err != nil {
 			err = fmt.Errorf("invalid mode: %v", err)
-			return inputFile, scanQuota, restoreMode, err
+			return inputFile, scanQuota, restoreMode, validateOnly, err
+		}
+	}
+	if _, ok := r.URL.Query()["validate-only"]; ok {
+		validateOnly, err = strconv.ParseBool(r.URL.Query().Get("validate-only"))
+		if err != nil {
+			err = fmt.Errorf("invalid validate-only: %v", err)
+			return inputFile, scanQuota, restoreMode, validateOnly, err
 		}
 	}
-	return inputFile, scanQuota, restoreMode, err
+	return inputFile, scanQuota, restoreMode, validateOnly, err
 }
 
 // RestoreFolders restores the specified folders
@@ -48,7 +48,7 @@ func getShares(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shares, err := dataprovider.GetShares(limit, offset, order, claims.Username)
+	shares, err := dataprovider.GetShares(limit, offset, order, claims.Username, getNameSearchFilters(r.URL.Query()))
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -71,6 +71,7 @@ func getShareByID(w http.ResponseWriter, r *http.Request) {
 	}
 	share.HideConfidentialData()
 
+	setETagHeader(w, share.UpdatedAt)
 	render.JSON(w, r, share)
 }
 
@@ -121,6 +122,11 @@ func addShare(w http.ResponseWriter, r *http.Request) {
 
 func updateShare(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -152,6 +158,7 @@ func updateShare(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	updatedShare.ExpectedVersion = version
 	err = dataprovider.UpdateShare(&updatedShare, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -162,6 +169,11 @@ func updateShare(w http.ResponseWriter, r *http.Request) {
 
 func deleteShare(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	shareID := getURLParam(r, "id")
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -169,7 +181,7 @@ func deleteShare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = dataprovider.DeleteShare(shareID, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteShareIfVersion(shareID, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -200,12 +212,12 @@ func (s *httpdServer) readBrowsableShareContents(w http.ResponseWriter, r *http.
 	}
 	defer common.Connections.Remove(connection.GetID())
 
-	contents, err := connection.ReadDir(name)
+	lister, err := connection.ReadDir(name)
 	if err != nil {
 		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
 		return
 	}
-	renderAPIDirContents(w, r, contents, true)
+	renderAPIDirContents(w, r, lister, true)
 }
 
 func (s *httpdServer) downloadBrowsableSharedFile(w http.ResponseWriter, r *http.Request) {
@@ -46,7 +46,16 @@ func getUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order, claims.Role)
+	filters, err := getUserSearchFilters(r.URL.Query(), parseTimestampFilter)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if claims.Role != "" {
+		filters.Role = claims.Role
+	}
+
+	users, err := dataprovider.GetUsers(limit, offset, order, filters)
 	if err == nil {
 		render.JSON(w, r, users)
 	} else {
@@ -74,6 +83,7 @@ func renderUser(w http.ResponseWriter, r *http.Request, username string, claims
 	if hideConfidentialData(claims, r) {
 		user.PrepareForRendering()
 	}
+	setETagHeader(w, user.UpdatedAt)
 	if status != http.StatusOK {
 		ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
 		render.JSON(w, r.WithContext(ctx), user)
@@ -109,6 +119,8 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 	}
 	user.LastPasswordChange = 0
 	user.Filters.RecoveryCodes = nil
+	user.Filters.PasswordHistory = nil
+	user.Filters.PublicKeysLastUse = nil
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
@@ -147,6 +159,11 @@ func disableUser2FA(w http.ResponseWriter, r *http.Request) {
 
 func updateUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
@@ -180,15 +197,19 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.PasswordHistory = user.Filters.PasswordHistory
+	updatedUser.Filters.PublicKeysLastUse = user.Filters.PublicKeysLastUse
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
 		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.SFTPConfig.KeyPassphrase,
-		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey)
+		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey, user.FsConfig.WebDAVConfig.Password,
+		user.FsConfig.FTPConfig.Password, user.FsConfig.SMBConfig.Password, user.FsConfig.GetEncryptionPassphrase())
 	if claims.Role != "" {
 		updatedUser.Role = claims.Role
 	}
+	updatedUser.ExpectedVersion = version
 	err = dataprovider.UpdateUser(&updatedUser, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -202,13 +223,18 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 
 func deleteUser(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	version, err := getIfMatchVersion(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
 		return
 	}
 	username := getURLParam(r, "username")
-	err = dataprovider.DeleteUser(username, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
+	err = dataprovider.DeleteUserIfVersion(username, version, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr), claims.Role)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
@@ -273,7 +299,12 @@ func disconnectUser(username, admin, role string) {
 
 func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, currentAzAccountKey, currentAzSASUrl,
 	currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase,
-	currentHTTPPassword, currentHTTPAPIKey *kms.Secret) {
+	currentHTTPPassword, currentHTTPAPIKey, currentWebDAVPassword, currentFTPPassword, currentSMBPassword,
+	currentEncryptionPassphrase *kms.Secret,
+) {
+	if config := fsConfig.GetEncryptionConfig(); config != nil && config.Passphrase.IsNotPlainAndNotEmpty() {
+		config.Passphrase = currentEncryptionPassphrase
+	}
 	// we use the new access secret if plain or empty, otherwise the old value
 	switch fsConfig.Provider {
 	case sdk.S3FilesystemProvider:
@@ -301,6 +332,18 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, cur
 		updateSFTPFsEncryptedSecrets(fsConfig, currentSFTPPassword, currentSFTPKey, currentSFTPKeyPassphrase)
 	case sdk.HTTPFilesystemProvider:
 		updateHTTPFsEncryptedSecrets(fsConfig, currentHTTPPassword, currentHTTPAPIKey)
+	case vfs.WebDAVFilesystemProvider:
+		if fsConfig.WebDAVConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.WebDAVConfig.Password = currentWebDAVPassword
+		}
+	case vfs.FTPFilesystemProvider:
+		if fsConfig.FTPConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.FTPConfig.Password = currentFTPPassword
+		}
+	case vfs.SMBFilesystemProvider:
+		if fsConfig.SMBConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.SMBConfig.Password = currentSMBPassword
+		}
 	}
 }
 
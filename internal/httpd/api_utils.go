@@ -17,6 +17,7 @@ package httpd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +36,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"github.com/klauspost/compress/zip"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
 	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
@@ -43,6 +45,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/plugin"
 	"github.com/drakkan/sftpgo/v2/internal/smtp"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 )
 
 type pwdChange struct {
@@ -68,6 +71,8 @@ type adminProfile struct {
 type userProfile struct {
 	baseProfile
 	PublicKeys []string `json:"public_keys,omitempty"`
+	// read only, it is ignored on update
+	PublicKeysInfo []dataprovider.PublicKey `json:"public_keys_info,omitempty"`
 }
 
 func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message string, code int) {
@@ -95,6 +100,9 @@ func getRespStatus(err error) int {
 	if errors.Is(err, util.ErrNotFound) {
 		return http.StatusNotFound
 	}
+	if errors.Is(err, dataprovider.ErrVersionConflict) {
+		return http.StatusPreconditionFailed
+	}
 	if errors.Is(err, fs.ErrNotExist) {
 		return http.StatusBadRequest
 	}
@@ -157,6 +165,32 @@ func getBoolQueryParam(r *http.Request, param string) bool {
 	return r.URL.Query().Get(param) == "true"
 }
 
+// setETagHeader sets the ETag response header using the object version
+func setETagHeader(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// getIfMatchVersion returns the object version from the If-Match request header.
+// 0 means no check, the header is missing or "*" is used
+func getIfMatchVersion(r *http.Request) (int64, error) {
+	val := strings.TrimSpace(r.Header.Get("If-Match"))
+	if val == "" || val == "*" {
+		return 0, nil
+	}
+	if strings.Contains(val, ",") {
+		return 0, util.NewValidationError("only a single entity tag is supported in the If-Match header")
+	}
+	val = strings.TrimPrefix(val, "W/")
+	if len(val) < 2 || !strings.HasPrefix(val, `"`) || !strings.HasSuffix(val, `"`) {
+		return 0, dataprovider.ErrVersionConflict
+	}
+	version, err := strconv.ParseInt(val[1:len(val)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, dataprovider.ErrVersionConflict
+	}
+	return version, nil
+}
+
 func getActiveConnections(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	claims, err := getTokenClaims(r)
@@ -240,6 +274,23 @@ func getNodesConnections(admin, role string) []common.ConnectionStatus {
 	return results
 }
 
+// handleNodeChangeNotification handles the change notifications sent by the other cluster nodes
+func handleNodeChangeNotification(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if _, _, err := dataprovider.AuthenticateNodeToken(getNodeToken(r)); err != nil {
+		logger.Debug(logSender, "", "unable to authenticate node token for change notification: %v", err)
+		sendAPIResponse(w, r, errors.New("the provided token cannot be authenticated"), "", http.StatusUnauthorized)
+		return
+	}
+	var notification dataprovider.ChangeNotification
+	if err := render.DecodeJSON(r.Body, &notification); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	dataprovider.HandleChangeNotification(&notification)
+	sendAPIResponse(w, r, nil, "Notification handled", http.StatusOK)
+}
+
 func getSearchFilters(w http.ResponseWriter, r *http.Request) (int, int, string, error) {
 	var err error
 	limit := 100
@@ -276,11 +327,103 @@ func getSearchFilters(w http.ResponseWriter, r *http.Request) (int, int, string,
 	return limit, offset, order, err
 }
 
-func renderAPIDirContents(w http.ResponseWriter, r *http.Request, contents []os.FileInfo, omitNonRegularFiles bool) {
-	results := make([]map[string]any, 0, len(contents))
-	for _, info := range contents {
+func getNameSearchFilters(query url.Values) dataprovider.SearchFilters {
+	return dataprovider.SearchFilters{
+		Search: strings.TrimSpace(query.Get("search")),
+		Prefix: strings.TrimSpace(query.Get("prefix")),
+	}
+}
+
+func getFsProviderSearchFilter(query url.Values) (*sdk.FilesystemProvider, error) {
+	value := strings.TrimSpace(query.Get("fs_provider"))
+	if value == "" {
+		return nil, nil
+	}
+	provider := vfs.GetProviderByName(value)
+	if provider == sdk.LocalFilesystemProvider && value != "0" && value != sdk.LocalFilesystemProvider.Name() {
+		return nil, util.NewValidationError(fmt.Sprintf("invalid filesystem provider filter: %q", value))
+	}
+	return &provider, nil
+}
+
+func getFolderSearchFilters(query url.Values) (dataprovider.FolderSearchFilters, error) {
+	provider, err := getFsProviderSearchFilter(query)
+	if err != nil {
+		return dataprovider.FolderSearchFilters{}, err
+	}
+	return dataprovider.FolderSearchFilters{
+		SearchFilters: getNameSearchFilters(query),
+		FsProvider:    provider,
+	}, nil
+}
+
+// parseTimestampFilter parses a time filter expressed as unix timestamp in milliseconds
+func parseTimestampFilter(value string, _ bool) (int64, error) {
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseDateFilter parses a time filter expressed as YYYY-MM-DD, the end of the
+// day is used for the upper bound of a range
+func parseDateFilter(value string, isRangeEnd bool) (int64, error) {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, err
+	}
+	if isRangeEnd {
+		t = t.Add(24*time.Hour - time.Millisecond)
+	}
+	return util.GetTimeAsMsSinceEpoch(t), nil
+}
+
+func getUserSearchFilters(query url.Values, parseTime func(string, bool) (int64, error)) (dataprovider.UserSearchFilters, error) {
+	filters := dataprovider.UserSearchFilters{
+		SearchFilters: getNameSearchFilters(query),
+		Group:         strings.TrimSpace(query.Get("group")),
+		Role:          strings.TrimSpace(query.Get("role")),
+	}
+	var err error
+	if value := query.Get("status"); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid status filter: %q", value))
+		}
+		filters.Status = &status
+	}
+	filters.FsProvider, err = getFsProviderSearchFilter(query)
+	if err != nil {
+		return filters, err
+	}
+	timeFilters := []struct {
+		name       string
+		isRangeEnd bool
+		dest       *int64
+	}{
+		{"expiration_from", false, &filters.ExpirationFrom},
+		{"expiration_to", true, &filters.ExpirationTo},
+		{"last_login_from", false, &filters.LastLoginFrom},
+		{"last_login_to", true, &filters.LastLoginTo},
+	}
+	for _, f := range timeFilters {
+		if value := query.Get(f.name); value != "" {
+			*f.dest, err = parseTime(value, f.isRangeEnd)
+			if err != nil {
+				return filters, util.NewValidationError(fmt.Sprintf("invalid %s filter: %q", f.name, value))
+			}
+		}
+	}
+	if value := query.Get("quota_usage"); value != "" {
+		filters.QuotaUsage, err = strconv.Atoi(value)
+		if err != nil {
+			return filters, util.NewValidationError(fmt.Sprintf("invalid quota usage filter: %q", value))
+		}
+	}
+	return filters, filters.Validate()
+}
+
+func renderAPIDirContents(w http.ResponseWriter, r *http.Request, lister vfs.DirLister, omitNonRegularFiles bool) {
+	streamDirContents(w, r, lister, func(info os.FileInfo) map[string]any {
 		if omitNonRegularFiles && !info.Mode().IsDir() && !info.Mode().IsRegular() {
-			continue
+			return nil
 		}
 		res := make(map[string]any)
 		res["name"] = info.Name()
@@ -289,10 +432,60 @@ func renderAPIDirContents(w http.ResponseWriter, r *http.Request, contents []os.
 		}
 		res["mode"] = info.Mode()
 		res["last_modified"] = info.ModTime().UTC().Format(time.RFC3339)
-		results = append(results, res)
-	}
+		return res
+	})
+}
 
-	render.JSON(w, r, results)
+// streamDirContents writes the directory entries returned by the specified lister
+// as a JSON array, one batch at a time. The entries are converted using the
+// specified function, nil results are skipped. The lister is always closed
+func streamDirContents(w http.ResponseWriter, r *http.Request, lister vfs.DirLister,
+	converter func(info os.FileInfo) map[string]any,
+) {
+	defer lister.Close()
+
+	contents, err := lister.Next(vfs.ListerBatchSize)
+	finished := errors.Is(err, io.EOF)
+	if err != nil && !finished {
+		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("[")) //nolint:errcheck
+	isFirst := true
+	for {
+		for _, info := range contents {
+			res := converter(info)
+			if res == nil {
+				continue
+			}
+			data, err := json.Marshal(res)
+			if err != nil {
+				logger.Error(logSender, "", "unable to marshal directory entry %q: %v", info.Name(), err)
+				panic(http.ErrAbortHandler)
+			}
+			if !isFirst {
+				w.Write([]byte(",")) //nolint:errcheck
+			}
+			isFirst = false
+			if _, err := w.Write(data); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if finished {
+			break
+		}
+		contents, err = lister.Next(vfs.ListerBatchSize)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Debug(logSender, "", "unable to get directory contents: %v", err)
+				panic(http.ErrAbortHandler)
+			}
+			finished = true
+		}
+	}
+	w.Write([]byte("]")) //nolint:errcheck
 }
 
 func getCompressedFileName(username string, files []string) string {
@@ -353,18 +546,30 @@ func addZipEntry(wr *zip.Writer, conn *Connection, entryPath, baseDir string) er
 			conn.Log(logger.LevelError, "unable to create zip entry %q: %v", entryPath, err)
 			return err
 		}
-		contents, err := conn.ReadDir(entryPath)
+		lister, err := conn.ReadDir(entryPath)
 		if err != nil {
 			conn.Log(logger.LevelDebug, "unable to add zip entry %q, read dir error: %v", entryPath, err)
 			return err
 		}
-		for _, info := range contents {
-			fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
-			if err := addZipEntry(wr, conn, fullPath, baseDir); err != nil {
+		defer lister.Close()
+
+		for {
+			contents, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				conn.Log(logger.LevelDebug, "unable to add zip entry %q, read dir error: %v", entryPath, err)
 				return err
 			}
+			for _, info := range contents {
+				fullPath := util.CleanPath(path.Join(entryPath, info.Name()))
+				if err := addZipEntry(wr, conn, fullPath, baseDir); err != nil {
+					return err
+				}
+			}
+			if finished {
+				return nil
+			}
 		}
-		return nil
 	}
 	if !info.Mode().IsRegular() {
 		// we only allow regular files
@@ -59,6 +59,7 @@ const (
 	claimMustSetSecondFactorKey     = "2fa_required"
 	claimRequiredTwoFactorProtocols = "2fa_protos"
 	claimHideUserPageSection        = "hus"
+	claimRADIUSState                = "radius_state"
 	basicRealm                      = "Basic realm=\"SFTPGo\""
 	jwtCookieKey                    = "jwt"
 )
@@ -85,6 +86,7 @@ type jwtTokenClaims struct {
 	MustChangePassword         bool
 	RequiredTwoFactorProtocols []string
 	HideUserPageSections       int
+	RADIUSState                string
 }
 
 func (c *jwtTokenClaims) hasUserAudience() bool {
@@ -124,6 +126,9 @@ func (c *jwtTokenClaims) asMap() map[string]any {
 	if c.HideUserPageSections > 0 {
 		claims[claimHideUserPageSection] = c.HideUserPageSections
 	}
+	if c.RADIUSState != "" {
+		claims[claimRADIUSState] = c.RADIUSState
+	}
 
 	return claims
 }
@@ -208,6 +213,10 @@ func (c *jwtTokenClaims) Decode(token map[string]any) {
 			c.HideUserPageSections = int(v)
 		}
 	}
+
+	if val, ok := token[claimRADIUSState]; ok {
+		c.RADIUSState = c.decodeString(val)
+	}
 }
 
 func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
@@ -87,8 +87,8 @@ func (c *Connection) Stat(name string, mode int) (os.FileInfo, error) {
 	return fi, err
 }
 
-// ReadDir returns a list of directory entries
-func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
+// ReadDir returns a lister for the directory entries, the caller must close it
+func (c *Connection) ReadDir(name string) (*common.DirListerAt, error) {
 	c.UpdateLastActivity()
 
 	return c.ListDir(name)
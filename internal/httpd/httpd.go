@@ -43,6 +43,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/internal/mfa"
 	"github.com/drakkan/sftpgo/v2/internal/sftpd"
 	"github.com/drakkan/sftpgo/v2/internal/util"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
 	"github.com/drakkan/sftpgo/v2/internal/webdavd"
 )
 
@@ -58,9 +59,12 @@ const (
 	versionPath                           = "/api/v2/version"
 	folderPath                            = "/api/v2/folders"
 	groupPath                             = "/api/v2/groups"
+	bulkUpdatePath                        = "/api/v2/bulk"
+	historyPath                           = "/api/v2/history"
 	serverStatusPath                      = "/api/v2/status"
 	dumpDataPath                          = "/api/v2/dumpdata"
 	loadDataPath                          = "/api/v2/loaddata"
+	importUsersPath                       = "/api/v2/importusers"
 	defenderHosts                         = "/api/v2/defender/hosts"
 	adminPath                             = "/api/v2/admins"
 	adminPwdPath                          = "/api/v2/admin/changepwd"
@@ -96,6 +100,10 @@ const (
 	eventRulesPath                        = "/api/v2/eventrules"
 	rolesPath                             = "/api/v2/roles"
 	ipListsPath                           = "/api/v2/iplists"
+	revokedUserCertsPath                  = "/api/v2/revokedusercerts"
+	kmsRotatePath                         = "/api/v2/kms/rotate"
+	cryptFsBasePath                       = "/api/v2/cryptfs/users"
+	cryptFsReEncryptionsPath              = "/api/v2/cryptfs/users/reencryptions"
 	healthzPath                           = "/healthz"
 	robotsTxtPath                         = "/robots.txt"
 	webRootPathDefault                    = "/"
@@ -135,6 +143,7 @@ const (
 	webAdminEventActionPathDefault        = "/web/admin/eventaction"
 	webAdminRolesPathDefault              = "/web/admin/roles"
 	webAdminRolePathDefault               = "/web/admin/role"
+	webAdminHistoryPathDefault            = "/web/admin/history"
 	webAdminTOTPGeneratePathDefault       = "/web/admin/totp/generate"
 	webAdminTOTPValidatePathDefault       = "/web/admin/totp/validate"
 	webAdminTOTPSavePathDefault           = "/web/admin/totp/save"
@@ -185,6 +194,7 @@ const (
 	osWindows            = "windows"
 	otpHeaderCode        = "X-SFTPGO-OTP"
 	mTimeHeader          = "X-SFTPGO-MTIME"
+	backupKeyHeader      = "X-SFTPGO-BACKUP-KEY"
 	acmeChallengeURI     = "/.well-known/acme-challenge/"
 )
 
@@ -193,6 +203,7 @@ var (
 	cleanupTicker                  *time.Ticker
 	cleanupDone                    chan bool
 	invalidatedJWTTokens           sync.Map
+	oidcBearerTokens               sync.Map
 	csrfTokenAuth                  *jwtauth.JWTAuth
 	webRootPath                    string
 	webBasePath                    string
@@ -228,6 +239,7 @@ var (
 	webAdminEventActionPath        string
 	webAdminRolesPath              string
 	webAdminRolePath               string
+	webAdminHistoryPath            string
 	webAdminTOTPGeneratePath       string
 	webAdminTOTPValidatePath       string
 	webAdminTOTPSavePath           string
@@ -696,6 +708,7 @@ type ServicesStatus struct {
 	MFA          mfa.ServiceStatus           `json:"mfa"`
 	AllowList    allowListStatus             `json:"allow_list"`
 	RateLimiters rateLimiters                `json:"rate_limiters"`
+	DiskCache    vfs.DiskCacheStatus         `json:"disk_cache"`
 }
 
 // SetupConfig defines the configuration parameters for the initial web admin setup
@@ -1035,6 +1048,7 @@ func getServicesStatus() *ServicesStatus {
 			IsActive:  rtlEnabled,
 			Protocols: rtlProtocols,
 		},
+		DiskCache: vfs.GetDiskCacheStatus(),
 	}
 	return status
 }
@@ -1129,6 +1143,7 @@ func updateWebAdminURLs(baseURL string) {
 	webAdminEventActionPath = path.Join(baseURL, webAdminEventActionPathDefault)
 	webAdminRolesPath = path.Join(baseURL, webAdminRolesPathDefault)
 	webAdminRolePath = path.Join(baseURL, webAdminRolePathDefault)
+	webAdminHistoryPath = path.Join(baseURL, webAdminHistoryPathDefault)
 	webAdminTOTPGeneratePath = path.Join(baseURL, webAdminTOTPGeneratePathDefault)
 	webAdminTOTPValidatePath = path.Join(baseURL, webAdminTOTPValidatePathDefault)
 	webAdminTOTPSavePath = path.Join(baseURL, webAdminTOTPSavePathDefault)
@@ -1169,6 +1184,7 @@ func startCleanupTicker(duration time.Duration) {
 			case <-cleanupTicker.C:
 				counter++
 				cleanupExpiredJWTTokens()
+				cleanupExpiredOIDCBearerTokens()
 				resetCodesMgr.Cleanup()
 				if counter%2 == 0 {
 					oidcMgr.cleanup()
@@ -320,14 +320,11 @@ func verifyCSRFHeader(next http.Handler) http.Handler {
 func checkNodeToken(tokenAuth *jwtauth.JWTAuth) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := r.Header.Get(dataprovider.NodeTokenHeader)
+			token := getNodeToken(r)
 			if token == "" {
 				next.ServeHTTP(w, r)
 				return
 			}
-			if len(token) > 7 && strings.ToUpper(token[0:6]) == "BEARER" {
-				token = token[7:]
-			}
 			admin, role, err := dataprovider.AuthenticateNodeToken(token)
 			if err != nil {
 				logger.Debug(logSender, "", "unable to authenticate node token %q: %v", token, err)
@@ -352,6 +349,14 @@ func checkNodeToken(tokenAuth *jwtauth.JWTAuth) func(next http.Handler) http.Han
 	}
 }
 
+func getNodeToken(r *http.Request) string {
+	token := r.Header.Get(dataprovider.NodeTokenHeader)
+	if len(token) > 7 && strings.ToUpper(token[0:6]) == "BEARER" {
+		token = token[7:]
+	}
+	return token
+}
+
 func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -436,6 +441,10 @@ func forbidAPIKeyAuthentication(next http.Handler) http.Handler {
 			sendAPIResponse(w, r, nil, "API key authentication is not allowed", http.StatusForbidden)
 			return
 		}
+		if isOIDCBearerAuth(r) {
+			sendAPIResponse(w, r, nil, "OpenID bearer token authentication is not allowed", http.StatusForbidden)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -16,6 +16,8 @@ package httpd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -24,7 +26,9 @@ import (
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/jwtauth/v5"
 	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
 	"golang.org/x/oauth2"
 
 	"github.com/drakkan/sftpgo/v2/internal/common"
@@ -45,6 +49,7 @@ const (
 var (
 	oidcTokenKey       = &contextKey{"OIDC token key"}
 	oidcGeneratedToken = &contextKey{"OIDC generated token"}
+	oidcBearerTokenKey = &contextKey{"OIDC bearer token"}
 )
 
 // OAuth2Config defines an interface for OAuth2 methods, so we can mock them
@@ -90,6 +95,13 @@ type OIDC struct {
 	Scopes []string `json:"scopes" mapstructure:"scopes"`
 	// Custom token claims fields to pass to the pre-login hook
 	CustomFields []string `json:"custom_fields" mapstructure:"custom_fields"`
+	// APIAudiences defines the accepted audiences for the access tokens issued by the OpenID
+	// provider and used as bearer tokens to authenticate REST API requests.
+	// If empty, REST API authentication with OpenID bearer tokens is disabled
+	APIAudiences []string `json:"api_audiences" mapstructure:"api_audiences"`
+	// Optional bearer token claims field to map to the SFTPGo username.
+	// If empty the "username_field" is used
+	APIUsernameField string `json:"api_username_field" mapstructure:"api_username_field"`
 	// InsecureSkipSignatureCheck causes SFTPGo to skip JWT signature validation.
 	// It's intended for special cases where providers, such as Azure, use the "none"
 	// algorithm. Skipping the signature validation can cause security issues
@@ -99,6 +111,7 @@ type OIDC struct {
 	Debug             bool `json:"debug" mapstructure:"debug"`
 	provider          *oidc.Provider
 	verifier          OIDCTokenVerifier
+	apiVerifier       OIDCTokenVerifier
 	providerLogoutURL string
 	oauth2Config      OAuth2Config
 }
@@ -111,11 +124,22 @@ func (o *OIDC) hasRoles() bool {
 	return o.isEnabled() && (o.RoleField != "" || o.ImplicitRoles)
 }
 
+func (o *OIDC) isAPIAuthEnabled() bool {
+	return o.isEnabled() && len(o.APIAudiences) > 0
+}
+
+func (o *OIDC) getAPIUsernameField() string {
+	if o.APIUsernameField != "" {
+		return o.APIUsernameField
+	}
+	return o.UsernameField
+}
+
 func (o *OIDC) getForcedRole(audience string) string {
 	if !o.ImplicitRoles {
 		return ""
 	}
-	if audience == tokenAudienceWebAdmin {
+	if audience == tokenAudienceWebAdmin || audience == tokenAudienceAPI {
 		return adminRoleFieldValue
 	}
 	return ""
@@ -167,6 +191,13 @@ func (o *OIDC) initialize() error {
 		ClientID:                   o.ClientID,
 		InsecureSkipSignatureCheck: o.InsecureSkipSignatureCheck,
 	})
+	if len(o.APIAudiences) > 0 {
+		// the audience is checked after verification, we accept multiple audiences.
+		// The signature is always verified for bearer tokens
+		o.apiVerifier = provider.Verifier(&oidc.Config{
+			SkipClientIDCheck: true,
+		})
+	}
 	o.oauth2Config = &oauth2.Config{
 		ClientID:     o.ClientID,
 		ClientSecret: o.ClientSecret,
@@ -541,6 +572,292 @@ func (s *httpdServer) oidcTokenAuthenticator(audience tokenAudience) func(next h
 	}
 }
 
+func (o *OIDC) verifyAPIToken(ctx context.Context, rawToken string) (*oidc.IDToken, error) {
+	token, err := o.apiVerifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, aud := range token.Audience {
+		if util.Contains(o.APIAudiences, aud) {
+			return token, nil
+		}
+	}
+	return nil, fmt.Errorf("oidc: token audience %v is not accepted", token.Audience)
+}
+
+// errOIDCBearerTokenOutdated is returned if the permissions or the role included
+// in a cached SFTPGo token do not match the current account ones
+var errOIDCBearerTokenOutdated = errors.New("the cached token permissions or role are outdated")
+
+// oidcBearerCachedToken is the SFTPGo token issued for a validated OpenID bearer token.
+// The permissions and role included in the SFTPGo token are cached too
+type oidcBearerCachedToken struct {
+	username    string
+	token       string
+	permissions []string
+	role        string
+	expiresAt   time.Time
+}
+
+func getOIDCBearerCacheKey(rawToken string, audience tokenAudience, ipAddr string) string {
+	h := sha256.New()
+	h.Write([]byte(rawToken))
+	h.Write([]byte(audience))
+	h.Write([]byte(ipAddr))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func getCachedOIDCBearerToken(key string) (oidcBearerCachedToken, bool) {
+	val, ok := oidcBearerTokens.Load(key)
+	if !ok {
+		return oidcBearerCachedToken{}, false
+	}
+	cached, ok := val.(oidcBearerCachedToken)
+	if !ok || cached.expiresAt.Before(time.Now()) {
+		oidcBearerTokens.Delete(key)
+		return oidcBearerCachedToken{}, false
+	}
+	return cached, true
+}
+
+// cacheOIDCBearerToken caches the SFTPGo token until the OpenID token expires.
+// The cache time never exceeds the refresh threshold so the cached SFTPGo token
+// is always valid
+func cacheOIDCBearerToken(key string, claims jwtTokenClaims, token string, expiry time.Time) {
+	expiresAt := time.Now().Add(tokenRefreshThreshold)
+	if !expiry.IsZero() && expiry.Before(expiresAt) {
+		expiresAt = expiry
+	}
+	oidcBearerTokens.Store(key, oidcBearerCachedToken{
+		username:    claims.Username,
+		token:       token,
+		permissions: claims.Permissions,
+		role:        claims.Role,
+		expiresAt:   expiresAt,
+	})
+}
+
+// checkCachedOIDCBearerAccount verifies that the account associated with a cached
+// token can still login, so disabled or deleted accounts are rejected without
+// waiting for the cache entry to expire. errOIDCBearerTokenOutdated is returned
+// if the account permissions or role changed, a new token must be issued
+func checkCachedOIDCBearerAccount(cached oidcBearerCachedToken, audience tokenAudience, ipAddr string) error {
+	var permissions []string
+	var role string
+	if audience == tokenAudienceAPI {
+		admin, err := dataprovider.AdminExists(cached.username)
+		if err != nil {
+			return err
+		}
+		if err := admin.CanLogin(ipAddr); err != nil {
+			return err
+		}
+		permissions = admin.Permissions
+		role = admin.Role
+	} else {
+		user, err := dataprovider.GetUserWithGroupSettings(cached.username, "")
+		if err != nil {
+			return err
+		}
+		if err := user.CheckLoginConditions(); err != nil {
+			return err
+		}
+		permissions = user.Filters.WebClient
+		role = user.Role
+	}
+	if role != cached.role || len(permissions) != len(cached.permissions) {
+		return errOIDCBearerTokenOutdated
+	}
+	for _, perm := range permissions {
+		if !util.Contains(cached.permissions, perm) {
+			return errOIDCBearerTokenOutdated
+		}
+	}
+	return nil
+}
+
+func cleanupExpiredOIDCBearerTokens() {
+	oidcBearerTokens.Range(func(key, value any) bool {
+		cached, ok := value.(oidcBearerCachedToken)
+		if !ok || cached.expiresAt.Before(time.Now()) {
+			oidcBearerTokens.Delete(key)
+		}
+		return true
+	})
+}
+
+// oidcBearerAuthenticator authenticates REST API requests using access tokens issued
+// by the OpenID provider. Tokens issued by SFTPGo are validated by the JWT authenticator.
+// The SFTPGo tokens issued for validated bearer tokens are cached until the bearer
+// tokens expire, the account status, permissions and role are checked again for
+// cached tokens
+func (s *httpdServer) oidcBearerAuthenticator(audience tokenAudience) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.binding.OIDC.isAPIAuthEnabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rawToken := jwtauth.TokenFromHeader(r)
+			if rawToken == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+			cacheKey := getOIDCBearerCacheKey(rawToken, audience, ipAddr)
+			if cached, ok := getCachedOIDCBearerToken(cacheKey); ok {
+				err := checkCachedOIDCBearerAccount(cached, audience, ipAddr)
+				switch {
+				case errors.Is(err, errOIDCBearerTokenOutdated):
+					// the bearer token is validated again and a new token is issued
+					logger.Debug(logSender, "", "cached oidc bearer token for %q is outdated", cached.username)
+					oidcBearerTokens.Delete(cacheKey)
+				case err != nil:
+					logger.Debug(logSender, "", "cached oidc bearer token for %q is no longer valid: %v", cached.username, err)
+					oidcBearerTokens.Delete(cacheKey)
+					handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+					sendAPIResponse(w, r, errors.New("the account associated with the provided token cannot be authenticated"),
+						"", http.StatusUnauthorized)
+					return
+				default:
+					ctx := context.WithValue(r.Context(), oidcBearerTokenKey, cached.username)
+					r = r.WithContext(ctx)
+					r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", cached.token))
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if _, err := s.tokenAuth.Decode(rawToken); err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			idToken, err := s.binding.OIDC.verifyAPIToken(r.Context(), rawToken)
+			if err != nil {
+				// the JWT authenticator will reject the request
+				logger.Debug(logSender, "", "unable to verify oidc bearer token: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims := make(map[string]any)
+			if err := idToken.Claims(&claims); err != nil {
+				logger.Debug(logSender, "", "unable to get oidc bearer token claims: %v", err)
+				handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+				sendAPIResponse(w, r, nil, "Invalid token claims", http.StatusUnauthorized)
+				return
+			}
+			s.debugTokenClaims(claims, rawToken)
+			token := oidcToken{}
+			err = token.parseClaims(claims, s.binding.OIDC.getAPIUsernameField(), s.binding.OIDC.RoleField, nil,
+				s.binding.OIDC.getForcedRole(audience))
+			if err != nil {
+				handleDefenderEventLoginFailed(ipAddr, err) //nolint:errcheck
+				sendAPIResponse(w, r, nil, "Invalid token claims", http.StatusUnauthorized)
+				return
+			}
+			if token.isAdmin() != (audience == tokenAudienceAPI) {
+				logger.Debug(logSender, "", "oidc bearer token for %q is not valid for audience %q", token.Username, audience)
+				handleDefenderEventLoginFailed(ipAddr, dataprovider.ErrInvalidCredentials) //nolint:errcheck
+				sendAPIResponse(w, r, nil, "Your token audience is not valid", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), oidcBearerTokenKey, token.Username)
+			r = r.WithContext(ctx)
+			tokenClaims, sftpgoToken, err := token.authenticateAPIRequest(s.tokenAuth, audience, r)
+			if err != nil {
+				logger.Debug(logSender, "", "unable to authenticate %q using an oidc bearer token: %v", token.Username, err)
+				code := http.StatusUnauthorized
+				if errors.Is(err, common.ErrInternalFailure) {
+					code = http.StatusInternalServerError
+				}
+				sendAPIResponse(w, r, errors.New("the account associated with the provided token cannot be authenticated"),
+					"", code)
+				return
+			}
+			cacheOIDCBearerToken(cacheKey, tokenClaims, sftpgoToken, idToken.Expiry)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticateAPIRequest checks the admin or user associated with an OpenID bearer token
+// and replaces the bearer token with an SFTPGo token. The SFTPGo token and its claims
+// are returned.
+// Failed logins are registered with the defender for both admins and users
+func (t *oidcToken) authenticateAPIRequest(tokenAuth *jwtauth.JWTAuth, audience tokenAudience, r *http.Request) (jwtTokenClaims, string, error) {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if audience == tokenAudienceAPI {
+		admin, err := dataprovider.AdminExists(t.Username)
+		if err != nil {
+			return jwtTokenClaims{}, "", handleDefenderEventLoginFailed(ipAddr, err)
+		}
+		if err := admin.CanLogin(ipAddr); err != nil {
+			return jwtTokenClaims{}, "", handleDefenderEventLoginFailed(ipAddr, err)
+		}
+		c := jwtTokenClaims{
+			Username:    admin.Username,
+			Permissions: admin.Permissions,
+			Role:        admin.Role,
+		}
+		resp, err := c.createTokenResponse(tokenAuth, audience, ipAddr)
+		if err != nil {
+			return jwtTokenClaims{}, "", err
+		}
+		accessToken, ok := resp["access_token"].(string)
+		if !ok {
+			return jwtTokenClaims{}, "", errors.New("unable to get the access token from the token response")
+		}
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", accessToken))
+		dataprovider.UpdateAdminLastLogin(&admin)
+		return c, accessToken, nil
+	}
+	// for users the defender events are added by updateLoginMetrics
+	user, err := dataprovider.GetUserWithGroupSettings(t.Username, "")
+	if err != nil {
+		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: t.Username}},
+			dataprovider.LoginMethodIDP, ipAddr, err)
+		return jwtTokenClaims{}, "", err
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolOIDC); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, err)
+		return jwtTokenClaims{}, "", fmt.Errorf("access denied: %w", err)
+	}
+	if err := user.CheckLoginConditions(); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, err)
+		return jwtTokenClaims{}, "", err
+	}
+	connectionID := fmt.Sprintf("%s_%s", common.ProtocolOIDC, xid.New().String())
+	if err := checkHTTPClientUser(&user, r, connectionID, true); err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, err)
+		return jwtTokenClaims{}, "", err
+	}
+	defer user.CloseFs() //nolint:errcheck
+	if err := user.CheckFsRoot(connectionID); err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, common.ErrInternalFailure)
+		return jwtTokenClaims{}, "", common.ErrInternalFailure
+	}
+	c := jwtTokenClaims{
+		Username:    user.Username,
+		Permissions: user.Filters.WebClient,
+		Role:        user.Role,
+	}
+	resp, err := c.createTokenResponse(tokenAuth, audience, ipAddr)
+	if err != nil {
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, common.ErrInternalFailure)
+		return jwtTokenClaims{}, "", err
+	}
+	accessToken, ok := resp["access_token"].(string)
+	if !ok {
+		updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, common.ErrInternalFailure)
+		return jwtTokenClaims{}, "", errors.New("unable to get the access token from the token response")
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", accessToken))
+	dataprovider.UpdateLastLogin(&user)
+	updateLoginMetrics(&user, dataprovider.LoginMethodIDP, ipAddr, nil)
+	return c, accessToken, nil
+}
+
 func (s *httpdServer) handleWebAdminOIDCLogin(w http.ResponseWriter, r *http.Request) {
 	s.oidcLoginRedirect(w, r, tokenAudienceWebAdmin)
 }
@@ -769,6 +1086,13 @@ func canSkipOIDCValidation(r *http.Request) bool {
 
 func isLoggedInWithOIDC(r *http.Request) bool {
 	_, ok := r.Context().Value(oidcTokenKey).(string)
+	return ok || isOIDCBearerAuth(r)
+}
+
+// isOIDCBearerAuth returns true if the REST API request is authenticated
+// using an access token issued by the OpenID provider
+func isOIDCBearerAuth(r *http.Request) bool {
+	_, ok := r.Context().Value(oidcBearerTokenKey).(string)
 	return ok
 }
 
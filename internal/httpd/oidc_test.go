@@ -1066,6 +1066,203 @@ func TestOIDCImplicitRoles(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestOIDCBearerAuth(t *testing.T) {
+	server := getTestOIDCServer()
+	server.binding.OIDC.APIAudiences = []string{"sftpgo-api"}
+	server.binding.OIDC.APIUsernameField = "client_id"
+	err := server.binding.OIDC.initialize()
+	assert.NoError(t, err)
+	assert.True(t, server.binding.OIDC.isAPIAuthEnabled())
+	assert.NotNil(t, server.binding.OIDC.apiVerifier)
+	server.enableRESTAPI = true
+	server.initializeRouter()
+
+	doRequest := func(method, path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest(method, path, nil)
+		assert.NoError(t, err)
+		r.RemoteAddr = "127.0.0.1:4567"
+		r.Header.Set("Authorization", "Bearer "+token)
+		server.router.ServeHTTP(rr, r)
+		return rr
+	}
+	// verification error
+	server.binding.OIDC.apiVerifier = &mockOIDCVerifier{
+		err: common.ErrGenericFailure,
+	}
+	rr := doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// audience not accepted
+	idToken := &oidc.IDToken{
+		Audience: []string{"sftpgo-client"},
+		Expiry:   time.Now().Add(5 * time.Minute),
+	}
+	setIDTokenClaims(idToken, []byte(`{"client_id":"admin","sftpgo_role":"admin"}`))
+	server.binding.OIDC.apiVerifier = &mockOIDCVerifier{
+		token: idToken,
+	}
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	idToken.Audience = []string{"other", "sftpgo-api"}
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	// credentials management is not allowed with bearer tokens
+	rr = doRequest(http.MethodGet, adminProfilePath, "bearer_token")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	// an admin token is not valid for user APIs
+	rr = doRequest(http.MethodGet, userDirsPath, "bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// the validated token is cached, the verifier is not called again
+	server.binding.OIDC.apiVerifier = &mockOIDCVerifier{
+		err: common.ErrGenericFailure,
+	}
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	// the cache is per client IP
+	rr = httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, versionPath, nil)
+	assert.NoError(t, err)
+	r.RemoteAddr = "127.0.0.2:4567"
+	r.Header.Set("Authorization", "Bearer bearer_token")
+	server.router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// a cached token with outdated permissions or role is not used, the bearer token is validated again
+	adminCacheKey := getOIDCBearerCacheKey("bearer_token", tokenAudienceAPI, "127.0.0.1")
+	cached, ok := getCachedOIDCBearerToken(adminCacheKey)
+	require.True(t, ok)
+	assert.Equal(t, []string{dataprovider.PermAdminAny}, cached.permissions)
+	cacheOIDCBearerToken(adminCacheKey, jwtTokenClaims{
+		Username:    defaultAdminUsername,
+		Permissions: []string{dataprovider.PermAdminViewUsers},
+	}, cached.token, time.Now().Add(5*time.Minute))
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	_, ok = getCachedOIDCBearerToken(adminCacheKey)
+	assert.False(t, ok)
+	cacheOIDCBearerToken(adminCacheKey, jwtTokenClaims{
+		Username:    defaultAdminUsername,
+		Permissions: []string{dataprovider.PermAdminAny},
+		Role:        "role",
+	}, cached.token, time.Now().Add(5*time.Minute))
+	server.binding.OIDC.apiVerifier = &mockOIDCVerifier{
+		token: idToken,
+	}
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	cached, ok = getCachedOIDCBearerToken(adminCacheKey)
+	require.True(t, ok)
+	assert.Equal(t, []string{dataprovider.PermAdminAny}, cached.permissions)
+	assert.Empty(t, cached.role)
+	// missing username
+	setIDTokenClaims(idToken, []byte(`{"preferred_username":"admin","sftpgo_role":"admin"}`))
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token_no_username")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// missing admin
+	setIDTokenClaims(idToken, []byte(`{"client_id":"missing admin","sftpgo_role":"admin"}`))
+	rr = doRequest(http.MethodGet, versionPath, "bearer_token_missing_admin")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// tokens issued by SFTPGo still work
+	admin, err := dataprovider.AdminExists(defaultAdminUsername)
+	assert.NoError(t, err)
+	c := jwtTokenClaims{
+		Username:    admin.Username,
+		Permissions: admin.Permissions,
+	}
+	resp, err := c.createTokenResponse(server.tokenAuth, tokenAudienceAPI, "127.0.0.1")
+	assert.NoError(t, err)
+	rr = doRequest(http.MethodGet, versionPath, resp["access_token"].(string))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	username := "test_oidc_bearer_user"
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: "pwd",
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+	}
+	err = dataprovider.AddUser(&user, "", "", "")
+	assert.NoError(t, err)
+	setIDTokenClaims(idToken, []byte(`{"client_id":"test_oidc_bearer_user"}`))
+	rr = doRequest(http.MethodGet, userDirsPath, "user_bearer_token")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	user, err = dataprovider.UserExists(username, "")
+	assert.NoError(t, err)
+	lastLogin := user.LastLogin
+	assert.Greater(t, lastLogin, int64(0))
+	rr = doRequest(http.MethodGet, userProfilePath, "user_bearer_token")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	// a user token is not valid for admin APIs
+	rr = doRequest(http.MethodGet, versionPath, "user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// implicit roles
+	server.binding.OIDC.ImplicitRoles = true
+	rr = doRequest(http.MethodGet, versionPath, "user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	server.binding.OIDC.ImplicitRoles = false
+	// the account status is checked for cached tokens
+	cacheKey := getOIDCBearerCacheKey("user_bearer_token", tokenAudienceAPIUser, "127.0.0.1")
+	_, ok = getCachedOIDCBearerToken(cacheKey)
+	assert.True(t, ok)
+	user.Status = 0
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	rr = doRequest(http.MethodGet, userDirsPath, "user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	_, ok = oidcBearerTokens.Load(cacheKey)
+	assert.False(t, ok)
+	user.Status = 1
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	rr = doRequest(http.MethodGet, userDirsPath, "user_bearer_token")
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	// the cached token expires with the bearer token
+	_, ok = getCachedOIDCBearerToken(cacheKey)
+	assert.True(t, ok)
+	cacheOIDCBearerToken(cacheKey, jwtTokenClaims{Username: username}, "", time.Now().Add(-1*time.Second))
+	_, ok = getCachedOIDCBearerToken(cacheKey)
+	assert.False(t, ok)
+	cacheOIDCBearerToken(cacheKey, jwtTokenClaims{Username: username}, "", time.Now().Add(-1*time.Second))
+	cleanupExpiredOIDCBearerTokens()
+	_, ok = oidcBearerTokens.Load(cacheKey)
+	assert.False(t, ok)
+	// disabled user
+	user.Status = 0
+	err = dataprovider.UpdateUser(&user, "", "", "")
+	assert.NoError(t, err)
+	rr = doRequest(http.MethodGet, userDirsPath, "user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// failed logins using user tokens are registered with the defender
+	oldConfig := common.Config
+	cfg := common.Config
+	cfg.DefenderConfig.Enabled = true
+	cfg.DefenderConfig.Driver = common.DefenderDriverMemory
+	cfg.DefenderConfig.Threshold = 100
+	cfg.DefenderConfig.ScoreValid = 1
+	cfg.DefenderConfig.ScoreInvalid = 2
+	err = common.Initialize(cfg, 0)
+	assert.NoError(t, err)
+	rr = doRequest(http.MethodGet, userDirsPath, "user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	setIDTokenClaims(idToken, []byte(`{"client_id":"missing_oidc_bearer_user"}`))
+	rr = doRequest(http.MethodGet, userDirsPath, "missing_user_bearer_token")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	score, err := common.GetDefenderScore("127.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, score)
+	err = common.Initialize(oldConfig, 0)
+	assert.NoError(t, err)
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = dataprovider.DeleteUser(username, "", "", "")
+	assert.NoError(t, err)
+}
+
 func TestMemoryOIDCManager(t *testing.T) {
 	oidcMgr, ok := oidcMgr.(*memoryOIDCManager)
 	require.True(t, ok)
@@ -18,6 +18,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
@@ -253,7 +254,8 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 	}
 
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, protocol)
-	if err != nil {
+	var radiusChallenge *dataprovider.RADIUSChallengeError
+	if err != nil && !errors.As(err, &radiusChallenge) {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, err)
 		s.renderClientLoginPage(w, dataprovider.ErrInvalidCredentials.Error(), ipAddr)
 		return
@@ -273,6 +275,10 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		s.renderClientLoginPage(w, err.Error(), ipAddr)
 		return
 	}
+	if radiusChallenge != nil {
+		s.handleWebClientRADIUSChallenge(w, r, &user, radiusChallenge, ipAddr, s.renderClientLoginPage)
+		return
+	}
 	s.loginUser(w, r, &user, connectionID, ipAddr, false, s.renderClientLoginPage)
 }
 
@@ -344,6 +350,10 @@ func (s *httpdServer) handleWebClientTwoFactorRecoveryPost(w http.ResponseWriter
 		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
 		return
 	}
+	if userMerged.MustUseRADIUSSecondFactor(common.ProtocolHTTP) {
+		s.renderClientRADIUSTwoFactorPage(w, "Recovery codes cannot replace the RADIUS passcode", ipAddr)
+		return
+	}
 	for idx, code := range user.Filters.RecoveryCodes {
 		if err := code.Secret.Decrypt(); err != nil {
 			s.renderClientInternalServerErrorPage(w, r, fmt.Errorf("unable to decrypt recovery code: %w", err))
@@ -404,6 +414,10 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 		s.renderClientTwoFactorPage(w, "Invalid credentials", ipAddr)
 		return
 	}
+	if claims.RADIUSState != "" || user.MustUseRADIUSSecondFactor(common.ProtocolHTTP) {
+		s.checkWebClientRADIUSPasscode(w, r, &user, claims.RADIUSState, passcode, ipAddr)
+		return
+	}
 	if !user.Filters.TOTPConfig.Enabled || !util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
 		updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
 		s.renderClientTwoFactorPage(w, "Two factory authentication is not enabled", ipAddr)
@@ -426,6 +440,63 @@ func (s *httpdServer) handleWebClientTwoFactorPost(w http.ResponseWriter, r *htt
 	s.loginUser(w, r, &user, connectionID, ipAddr, true, s.renderClientTwoFactorPage)
 }
 
+func (s *httpdServer) checkWebClientRADIUSPasscode(w http.ResponseWriter, r *http.Request, user *dataprovider.User,
+	radiusState, passcode, ipAddr string,
+) {
+	state, err := base64.StdEncoding.DecodeString(radiusState)
+	if err != nil {
+		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		s.renderClientRADIUSTwoFactorPage(w, "Invalid RADIUS state", ipAddr)
+		return
+	}
+	err = dataprovider.CheckRADIUSPasscode(user.Username, passcode, common.ProtocolHTTP, state)
+	if err != nil {
+		var radiusChallenge *dataprovider.RADIUSChallengeError
+		if errors.As(err, &radiusChallenge) {
+			invalidateToken(r)
+			s.handleWebClientRADIUSChallenge(w, r, user, radiusChallenge, ipAddr, s.renderClientRADIUSTwoFactorPage)
+			return
+		}
+		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+		s.renderClientRADIUSTwoFactorPage(w, "Invalid authentication code", ipAddr)
+		return
+	}
+	connectionID := fmt.Sprintf("%s_%s", getProtocolFromRequest(r), xid.New().String())
+	if !user.MustUseRADIUSSecondFactor(common.ProtocolHTTP) {
+		// the challenge was part of the password authentication, a second factor could be still required
+		invalidateToken(r)
+		s.loginUser(w, r, user, connectionID, ipAddr, false, s.renderClientTwoFactorPage)
+		return
+	}
+	s.loginUser(w, r, user, connectionID, ipAddr, true, s.renderClientRADIUSTwoFactorPage)
+}
+
+// handleWebClientRADIUSChallenge sets a partial token that includes the RADIUS state
+// and asks the user to reply to the challenge
+func (s *httpdServer) handleWebClientRADIUSChallenge(w http.ResponseWriter, r *http.Request, user *dataprovider.User,
+	challenge *dataprovider.RADIUSChallengeError, ipAddr string, errorFunc func(w http.ResponseWriter, error, ip string),
+) {
+	if len(challenge.State) == 0 {
+		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		errorFunc(w, "Unsupported RADIUS challenge without state", ipAddr)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:    user.Username,
+		Permissions: user.Filters.WebClient,
+		Signature:   user.GetSignature(),
+		Role:        user.Role,
+		RADIUSState: base64.StdEncoding.EncodeToString(challenge.State),
+	}
+	if err := c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClientPartial, ipAddr); err != nil {
+		logger.Warn(logSender, "", "unable to set user login cookie %v", err)
+		updateLoginMetrics(user, dataprovider.LoginMethodPassword, ipAddr, common.ErrInternalFailure)
+		errorFunc(w, err.Error(), ipAddr)
+		return
+	}
+	s.renderClientRADIUSChallengePage(w, challenge.GetPrompt(), ipAddr)
+}
+
 func (s *httpdServer) handleWebAdminTwoFactorRecoveryPost(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 
@@ -719,6 +790,9 @@ func (s *httpdServer) loginUser(
 		user.CanManageMFA() && !isSecondFactorAuth {
 		audience = tokenAudienceWebClientPartial
 	}
+	if user.MustUseRADIUSSecondFactor(common.ProtocolHTTP) && !isSecondFactorAuth {
+		audience = tokenAudienceWebClientPartial
+	}
 
 	err := c.createAndSetCookie(w, r, s.tokenAuth, audience, ipAddr)
 	if err != nil {
@@ -824,7 +898,17 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
+	if user.MustUseRADIUSSecondFactor(common.ProtocolHTTP) {
+		err = dataprovider.CheckRADIUSPasscode(user.Username, r.Header.Get(otpHeaderCode), protocol, nil)
+		if err != nil {
+			logger.Debug(logSender, "", "unable to validate RADIUS passcode for user %q: %v", user.Username, err)
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			updateLoginMetrics(&user, dataprovider.LoginMethodPassword, ipAddr, dataprovider.ErrInvalidCredentials)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return
+		}
+	} else if user.Filters.TOTPConfig.Enabled && util.Contains(user.Filters.TOTPConfig.Protocols, common.ProtocolHTTP) {
 		passcode := r.Header.Get(otpHeaderCode)
 		if passcode == "" {
 			logger.Debug(logSender, "", "TOTP enabled for user %q and not passcode provided, authentication refused", user.Username)
@@ -1237,6 +1321,7 @@ func (s *httpdServer) initializeRouter() {
 		s.router.Get(sharesPath+"/{id}/files", s.downloadBrowsableSharedFile)
 
 		s.router.Get(tokenPath, s.getToken)
+		s.router.Post(dataprovider.NodeChangeNotificationsPath, handleNodeChangeNotification)
 		s.router.Post(adminPath+"/{username}/forgot-password", forgotAdminPassword)
 		s.router.Post(adminPath+"/{username}/reset-password", resetAdminPassword)
 		s.router.Post(userPath+"/{username}/forgot-password", forgotUserPassword)
@@ -1245,6 +1330,7 @@ func (s *httpdServer) initializeRouter() {
 		s.router.Group(func(router chi.Router) {
 			router.Use(checkNodeToken(s.tokenAuth))
 			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeAdmin))
+			router.Use(s.oidcBearerAuthenticator(tokenAudienceAPI))
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticatorAPI)
 
@@ -1294,9 +1380,17 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(groupPath, addGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Put(groupPath+"/{name}", updateGroup)
 			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Delete(groupPath+"/{name}", deleteGroup)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(bulkUpdatePath+"/users", bulkUpdateUsers)
+			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Post(bulkUpdatePath+"/folders", bulkUpdateFolders)
+			router.With(s.checkPerm(dataprovider.PermAdminManageGroups)).Post(bulkUpdatePath+"/groups", bulkUpdateGroups)
+			router.Get(historyPath+"/{objecttype}/{name}", getObjectHistory)
+			router.Get(historyPath+"/{objecttype}/{name}/{id}", getObjectVersion)
+			router.Get(historyPath+"/{objecttype}/{name}/{id}/diff", getObjectVersionDiff)
+			router.Post(historyPath+"/{objecttype}/{name}/{id}/restore", restoreObjectVersion)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(importUsersPath, importUsers)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/usage",
 				updateUserQuotaUsage)
 			router.With(s.checkPerm(dataprovider.PermAdminChangeUsers)).Put(quotasBasePath+"/users/{username}/transfer-usage",
@@ -1353,12 +1447,22 @@ func (s *httpdServer) initializeRouter() {
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Get(ipListsPath+"/{type}/{ipornet}", getIPListEntry)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Put(ipListsPath+"/{type}/{ipornet}", updateIPListEntry)
 			router.With(s.checkPerm(dataprovider.PermAdminManageIPLists)).Delete(ipListsPath+"/{type}/{ipornet}", deleteIPListEntry)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(revokedUserCertsPath, getRevokedUserCerts)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(revokedUserCertsPath, addRevokedUserCert)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(revokedUserCertsPath+"/{id}", deleteRevokedUserCert)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(kmsRotatePath, rotateKMSMasterKey)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Get(cryptFsReEncryptionsPath, getCryptFsReEncryptions)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Post(cryptFsBasePath+"/{username}/reencrypt",
+				startCryptFsReEncryption)
+			router.With(s.checkPerm(dataprovider.PermAdminManageSystem)).Delete(cryptFsBasePath+"/{username}/reencrypt",
+				rollbackCryptFsReEncryption)
 		})
 
 		s.router.Get(userTokenPath, s.getUserToken)
 
 		s.router.Group(func(router chi.Router) {
 			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeUser))
+			router.Use(s.oidcBearerAuthenticator(tokenAudienceAPIUser))
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticatorAPIUser)
 
@@ -1718,6 +1822,9 @@ func (s *httpdServer) setupWebAdminRoutes() {
 				s.handleWebUpdateRolePost)
 			router.With(s.checkPerm(dataprovider.PermAdminManageRoles), verifyCSRFHeader).
 				Delete(webAdminRolePath+"/{name}", deleteRole)
+			router.With(s.refreshCookie).Get(webAdminHistoryPath+"/{objecttype}/{name}", s.handleWebGetObjectHistory)
+			router.With(verifyCSRFHeader).Post(webAdminHistoryPath+"/{objecttype}/{name}/{id}/restore",
+				restoreObjectVersion)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), s.refreshCookie).Get(webEventsPath,
 				s.handleWebGetEvents)
 			router.With(s.checkPerm(dataprovider.PermAdminViewEvents), compressor.Handler, s.refreshCookie).
@@ -56,6 +56,7 @@ type twoFactorPage struct {
 	CurrentURL  string
 	Version     string
 	Error       string
+	Message     string
 	CSRFToken   string
 	StaticURL   string
 	RecoveryURL string
@@ -89,6 +89,7 @@ const (
 	templateEventAction      = "eventaction.html"
 	templateRoles            = "roles.html"
 	templateRole             = "role.html"
+	templateHistory          = "history.html"
 	templateEvents           = "events.html"
 	templateMessage          = "message.html"
 	templateStatus           = "status.html"
@@ -111,6 +112,7 @@ const (
 	pageEventRulesTitle      = "Event rules"
 	pageEventActionsTitle    = "Event actions"
 	pageRolesTitle           = "Roles"
+	pageHistoryTitle         = "History"
 	pageProfileTitle         = "My profile"
 	pageChangePwdTitle       = "Change password"
 	pageMaintenanceTitle     = "Maintenance"
@@ -159,6 +161,7 @@ type basePage struct {
 	EventActionURL      string
 	RolesURL            string
 	RoleURL             string
+	HistoryURL          string
 	FolderQuotaScanURL  string
 	StatusURL           string
 	MaintenanceURL      string
@@ -185,13 +188,20 @@ type basePage struct {
 	HasDefender         bool
 	HasSearcher         bool
 	HasExternalLogin    bool
+	HasObjectHistory    bool
 	LoggedAdmin         *dataprovider.Admin
 	Branding            UIBranding
 }
 
 type usersPage struct {
 	basePage
-	Users []dataprovider.User
+	Users   []dataprovider.User
+	Filters url.Values
+	Error   string
+	// the users are paginated server side, empty URLs mean no previous or next page
+	Page        int
+	PrevPageURL string
+	NextPageURL string
 }
 
 type adminsPage struct {
@@ -202,11 +212,14 @@ type adminsPage struct {
 type foldersPage struct {
 	basePage
 	Folders []vfs.BaseVirtualFolder
+	Filters url.Values
+	Error   string
 }
 
 type groupsPage struct {
 	basePage
-	Groups []dataprovider.Group
+	Groups  []dataprovider.Group
+	Filters url.Values
 }
 
 type rolesPage struct {
@@ -214,6 +227,16 @@ type rolesPage struct {
 	Roles []dataprovider.Role
 }
 
+type objectHistoryPage struct {
+	basePage
+	ObjectType string
+	ObjectName string
+	Versions   []dataprovider.ObjectVersion
+	Diff       *dataprovider.ObjectVersionDiff
+	HistoryURL string
+	CanRestore bool
+}
+
 type eventRulesPage struct {
 	basePage
 	Rules []dataprovider.EventRule
@@ -564,6 +587,11 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateRole),
 	}
+	historyPaths := []string{
+		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateHistory),
+	}
 	eventsPaths := []string{
 		filepath.Join(templatesPath, templateCommonDir, templateCommonCSS),
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
@@ -579,12 +607,15 @@ func loadAdminTemplates(templatesPath string) {
 		"ListFSProviders": func() []sdk.FilesystemProvider {
 			return []sdk.FilesystemProvider{sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider,
 				sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider,
-				sdk.SFTPFilesystemProvider, sdk.HTTPFilesystemProvider,
+				sdk.SFTPFilesystemProvider, sdk.HTTPFilesystemProvider, vfs.WebDAVFilesystemProvider,
+				vfs.FTPFilesystemProvider, vfs.SMBFilesystemProvider, vfs.OverlayFilesystemProvider,
 			}
 		},
-		"HumanizeBytes": util.ByteCountSI,
+		"FSProviderName":      vfs.GetProviderName,
+		"FSProviderShortInfo": vfs.GetProviderShortInfo,
+		"HumanizeBytes":       util.ByteCountSI,
 	})
-	usersTmpl := util.LoadTemplate(nil, usersPaths...)
+	usersTmpl := util.LoadTemplate(fsBaseTpl, usersPaths...)
 	userTmpl := util.LoadTemplate(fsBaseTpl, userPaths...)
 	adminsTmpl := util.LoadTemplate(nil, adminsPaths...)
 	adminTmpl := util.LoadTemplate(nil, adminPaths...)
@@ -592,7 +623,7 @@ func loadAdminTemplates(templatesPath string) {
 	messageTmpl := util.LoadTemplate(nil, messagePaths...)
 	groupsTmpl := util.LoadTemplate(nil, groupsPaths...)
 	groupTmpl := util.LoadTemplate(fsBaseTpl, groupPaths...)
-	foldersTmpl := util.LoadTemplate(nil, foldersPaths...)
+	foldersTmpl := util.LoadTemplate(fsBaseTpl, foldersPaths...)
 	folderTmpl := util.LoadTemplate(fsBaseTpl, folderPaths...)
 	eventRulesTmpl := util.LoadTemplate(nil, eventRulesPaths...)
 	eventRuleTmpl := util.LoadTemplate(fsBaseTpl, eventRulePaths...)
@@ -614,6 +645,7 @@ func loadAdminTemplates(templatesPath string) {
 	resetPwdTmpl := util.LoadTemplate(nil, resetPwdPaths...)
 	rolesTmpl := util.LoadTemplate(nil, rolesPaths...)
 	roleTmpl := util.LoadTemplate(nil, rolePaths...)
+	historyTmpl := util.LoadTemplate(nil, historyPaths...)
 	eventsTmpl := util.LoadTemplate(nil, eventsPaths...)
 	configsTmpl := util.LoadTemplate(nil, configsPaths...)
 
@@ -647,6 +679,7 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateResetPassword] = resetPwdTmpl
 	adminTemplates[templateRoles] = rolesTmpl
 	adminTemplates[templateRole] = roleTmpl
+	adminTemplates[templateHistory] = historyTmpl
 	adminTemplates[templateEvents] = eventsTmpl
 	adminTemplates[templateConfigs] = configsTmpl
 }
@@ -718,6 +751,7 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 		EventActionURL:      webAdminEventActionPath,
 		RolesURL:            webAdminRolesPath,
 		RoleURL:             webAdminRolePath,
+		HistoryURL:          webAdminHistoryPath,
 		QuotaScanURL:        webQuotaScanPath,
 		ConnectionsURL:      webConnectionsPath,
 		StatusURL:           webStatusPath,
@@ -746,6 +780,7 @@ func (s *httpdServer) getBasePageData(title, currentURL string, r *http.Request)
 		HasDefender:         common.Config.DefenderConfig.Enabled,
 		HasSearcher:         plugin.Handler.HasSearcher(),
 		HasExternalLogin:    isLoggedInWithOIDC(r),
+		HasObjectHistory:    dataprovider.HasObjectHistory(),
 		CSRFToken:           csrfToken,
 		Branding:            s.binding.Branding.WebAdmin,
 	}
@@ -933,7 +968,7 @@ func (s *httpdServer) renderAdminSetupPage(w http.ResponseWriter, r *http.Reques
 
 func (s *httpdServer) renderAddUpdateAdminPage(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin,
 	error string, isAdd bool) {
-	groups, err := s.getWebGroups(w, r, defaultQueryLimit, true)
+	groups, err := s.getWebGroups(w, r, defaultQueryLimit, true, dataprovider.SearchFilters{})
 	if err != nil {
 		return
 	}
@@ -989,6 +1024,7 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 		}
 	}
 	user.FsConfig.RedactedSecret = redactedSecret
+	user.PublicKeysInfo = user.GetPublicKeysInfo()
 	basePage := s.getBasePageData(title, currentURL, r)
 	if (mode == userPageModeAdd || mode == userPageModeTemplate) && len(user.Groups) == 0 && admin != nil {
 		for _, group := range admin.Groups {
@@ -1006,11 +1042,11 @@ func (s *httpdServer) renderUserPage(w http.ResponseWriter, r *http.Request, use
 			return
 		}
 	}
-	folders, err := s.getWebVirtualFolders(w, r, defaultQueryLimit, true)
+	folders, err := s.getWebVirtualFolders(w, r, defaultQueryLimit, true, dataprovider.FolderSearchFilters{})
 	if err != nil {
 		return
 	}
-	groups, err := s.getWebGroups(w, r, defaultQueryLimit, true)
+	groups, err := s.getWebGroups(w, r, defaultQueryLimit, true, dataprovider.SearchFilters{})
 	if err != nil {
 		return
 	}
@@ -1086,7 +1122,7 @@ func (s *httpdServer) renderRolePage(w http.ResponseWriter, r *http.Request, rol
 func (s *httpdServer) renderGroupPage(w http.ResponseWriter, r *http.Request, group dataprovider.Group,
 	mode genericPageMode, error string,
 ) {
-	folders, err := s.getWebVirtualFolders(w, r, defaultQueryLimit, true)
+	folders, err := s.getWebVirtualFolders(w, r, defaultQueryLimit, true, dataprovider.FolderSearchFilters{})
 	if err != nil {
 		return
 	}
@@ -1632,9 +1668,27 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	if err != nil {
 		return config, fmt.Errorf("invalid s3 upload part max time: %w", err)
 	}
+	config.MetadataCache = getMetadataCacheConfig(r, "s3")
+	config.Encryption = getEncryptionConfig(r, "s3")
 	return config, nil
 }
 
+func getMetadataCacheConfig(r *http.Request, prefix string) vfs.MetadataCacheConfig {
+	config := vfs.MetadataCacheConfig{}
+	ttl, err := strconv.Atoi(r.Form.Get(prefix + "_metadata_cache_ttl"))
+	if err == nil {
+		config.TTL = ttl
+	}
+	config.Shared = r.Form.Get(prefix+"_metadata_cache_shared") != ""
+	return config
+}
+
+func getEncryptionConfig(r *http.Request, prefix string) vfs.EncryptionConfig {
+	return vfs.EncryptionConfig{
+		Passphrase: getSecretFromFormField(r, prefix+"_encryption_passphrase"),
+	}
+}
+
 func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	var err error
 	config := vfs.GCSFsConfig{}
@@ -1643,6 +1697,8 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	config.StorageClass = strings.TrimSpace(r.Form.Get("gcs_storage_class"))
 	config.ACL = strings.TrimSpace(r.Form.Get("gcs_acl"))
 	config.KeyPrefix = r.Form.Get("gcs_key_prefix")
+	config.MetadataCache = getMetadataCacheConfig(r, "gcs")
+	config.Encryption = getEncryptionConfig(r, "gcs")
 	uploadPartSize, err := strconv.ParseInt(r.Form.Get("gcs_upload_part_size"), 10, 64)
 	if err == nil {
 		config.UploadPartSize = uploadPartSize
@@ -1689,6 +1745,7 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	config.Fingerprints = getSliceFromDelimitedValues(fingerprintsFormValue, "\n")
 	config.Prefix = r.Form.Get("sftp_prefix")
 	config.DisableCouncurrentReads = r.Form.Get("sftp_disable_concurrent_reads") != ""
+	config.Encryption = getEncryptionConfig(r, "sftp")
 	config.BufferSize, err = strconv.ParseInt(r.Form.Get("sftp_buffer_size"), 10, 64)
 	if r.Form.Get("sftp_equality_check_mode") != "" {
 		config.EqualityCheckMode = 1
@@ -1716,6 +1773,58 @@ func getHTTPFsConfig(r *http.Request) vfs.HTTPFsConfig {
 	return config
 }
 
+func getWebDAVFsConfig(r *http.Request) vfs.WebDAVFsConfig {
+	config := vfs.WebDAVFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("webdav_endpoint"))
+	config.Username = r.Form.Get("webdav_username")
+	config.SkipTLSVerify = r.Form.Get("webdav_skip_tls_verify") != ""
+	config.Password = getSecretFromFormField(r, "webdav_password")
+	if r.Form.Get("webdav_equality_check_mode") != "" {
+		config.EqualityCheckMode = 1
+	} else {
+		config.EqualityCheckMode = 0
+	}
+	return config
+}
+
+func getFTPFsConfig(r *http.Request) (vfs.FTPFsConfig, error) {
+	var err error
+	config := vfs.FTPFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("ftp_endpoint"))
+	config.Username = r.Form.Get("ftp_username")
+	config.Password = getSecretFromFormField(r, "ftp_password")
+	config.SkipTLSVerify = r.Form.Get("ftp_skip_tls_verify") != ""
+	config.DisableEPSV = r.Form.Get("ftp_disable_epsv") != ""
+	config.DisableMLSD = r.Form.Get("ftp_disable_mlsd") != ""
+	config.Prefix = r.Form.Get("ftp_prefix")
+	if r.Form.Get("ftp_equality_check_mode") != "" {
+		config.EqualityCheckMode = 1
+	} else {
+		config.EqualityCheckMode = 0
+	}
+	config.TLSMode, err = strconv.Atoi(r.Form.Get("ftp_tls_mode"))
+	if err != nil {
+		return config, fmt.Errorf("invalid FTP TLS mode: %w", err)
+	}
+	return config, nil
+}
+
+func getSMBFsConfig(r *http.Request) vfs.SMBFsConfig {
+	config := vfs.SMBFsConfig{}
+	config.Endpoint = strings.TrimSpace(r.Form.Get("smb_endpoint"))
+	config.Username = r.Form.Get("smb_username")
+	config.Password = getSecretFromFormField(r, "smb_password")
+	config.Domain = strings.TrimSpace(r.Form.Get("smb_domain"))
+	config.Share = strings.TrimSpace(r.Form.Get("smb_share"))
+	config.Prefix = r.Form.Get("smb_prefix")
+	if r.Form.Get("smb_equality_check_mode") != "" {
+		config.EqualityCheckMode = 1
+	} else {
+		config.EqualityCheckMode = 0
+	}
+	return config
+}
+
 func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	var err error
 	config := vfs.AzBlobFsConfig{}
@@ -1727,6 +1836,8 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.KeyPrefix = r.Form.Get("az_key_prefix")
 	config.AccessTier = strings.TrimSpace(r.Form.Get("az_access_tier"))
 	config.UseEmulator = r.Form.Get("az_use_emulator") != ""
+	config.MetadataCache = getMetadataCacheConfig(r, "az")
+	config.Encryption = getEncryptionConfig(r, "az")
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("az_upload_part_size"), 10, 64)
 	if err != nil {
 		return config, fmt.Errorf("invalid azure upload part size: %w", err)
@@ -1748,7 +1859,7 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 
 func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 	var fs vfs.Filesystem
-	fs.Provider = sdk.GetProviderByName(r.Form.Get("fs_provider"))
+	fs.Provider = vfs.GetProviderByName(r.Form.Get("fs_provider"))
 	switch fs.Provider {
 	case sdk.S3FilesystemProvider:
 		config, err := getS3Config(r)
@@ -1778,6 +1889,19 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 		fs.SFTPConfig = config
 	case sdk.HTTPFilesystemProvider:
 		fs.HTTPConfig = getHTTPFsConfig(r)
+	case vfs.WebDAVFilesystemProvider:
+		fs.WebDAVConfig = getWebDAVFsConfig(r)
+	case vfs.FTPFilesystemProvider:
+		config, err := getFTPFsConfig(r)
+		if err != nil {
+			return fs, err
+		}
+		fs.FTPConfig = config
+	case vfs.SMBFilesystemProvider:
+		fs.SMBConfig = getSMBFsConfig(r)
+	case vfs.OverlayFilesystemProvider:
+		fs.OverlayConfig.Layers = getSliceFromDelimitedValues(r.Form.Get("overlay_layers"), ",")
+		fs.OverlayConfig.Upper = strings.TrimSpace(r.Form.Get("overlay_upper"))
 	}
 	return fs, nil
 }
@@ -1887,6 +2011,12 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 		folder.FsConfig.SFTPConfig = getSFTPFsFromTemplate(folder.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		folder.FsConfig.HTTPConfig = getHTTPFsFromTemplate(folder.FsConfig.HTTPConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		folder.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(folder.FsConfig.WebDAVConfig, replacements)
+	case vfs.FTPFilesystemProvider:
+		folder.FsConfig.FTPConfig = getFTPFsFromTemplate(folder.FsConfig.FTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		folder.FsConfig.SMBConfig = getSMBFsFromTemplate(folder.FsConfig.SMBConfig, replacements)
 	}
 
 	return folder
@@ -1942,6 +2072,31 @@ func getHTTPFsFromTemplate(fsConfig vfs.HTTPFsConfig, replacements map[string]st
 	return fsConfig
 }
 
+func getWebDAVFsFromTemplate(fsConfig vfs.WebDAVFsConfig, replacements map[string]string) vfs.WebDAVFsConfig {
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	return fsConfig
+}
+
+func getFTPFsFromTemplate(fsConfig vfs.FTPFsConfig, replacements map[string]string) vfs.FTPFsConfig {
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	if fsConfig.Password != nil && fsConfig.Password.IsPlain() {
+		payload := replacePlaceholders(fsConfig.Password.GetPayload(), replacements)
+		fsConfig.Password = kms.NewPlainSecret(payload)
+	}
+	return fsConfig
+}
+
+func getSMBFsFromTemplate(fsConfig vfs.SMBFsConfig, replacements map[string]string) vfs.SMBFsConfig {
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	if fsConfig.Password != nil && fsConfig.Password.IsPlain() {
+		payload := replacePlaceholders(fsConfig.Password.GetPayload(), replacements)
+		fsConfig.Password = kms.NewPlainSecret(payload)
+	}
+	return fsConfig
+}
+
 func getUserFromTemplate(user dataprovider.User, template userTemplateFields) dataprovider.User {
 	user.Username = template.Username
 	user.Password = template.Password
@@ -1978,6 +2133,12 @@ func getUserFromTemplate(user dataprovider.User, template userTemplateFields) da
 		user.FsConfig.SFTPConfig = getSFTPFsFromTemplate(user.FsConfig.SFTPConfig, replacements)
 	case sdk.HTTPFilesystemProvider:
 		user.FsConfig.HTTPConfig = getHTTPFsFromTemplate(user.FsConfig.HTTPConfig, replacements)
+	case vfs.WebDAVFilesystemProvider:
+		user.FsConfig.WebDAVConfig = getWebDAVFsFromTemplate(user.FsConfig.WebDAVConfig, replacements)
+	case vfs.FTPFilesystemProvider:
+		user.FsConfig.FTPConfig = getFTPFsFromTemplate(user.FsConfig.FTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		user.FsConfig.SMBConfig = getSMBFsFromTemplate(user.FsConfig.SMBConfig, replacements)
 	}
 
 	return user
@@ -2067,6 +2228,10 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 	if err != nil {
 		return user, err
 	}
+	passwordHistorySize, err := getPasswordHistorySizeFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
 	user = dataprovider.User{
 		BaseUser: sdk.BaseUser{
 			Username:             r.Form.Get("username"),
@@ -2094,6 +2259,7 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		Filters: dataprovider.UserFilters{
 			BaseUserFilters:       filters,
 			RequirePasswordChange: r.Form.Get("require_password_change") != "",
+			PasswordHistorySize:   passwordHistorySize,
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 		FsConfig:       fsConfig,
@@ -2142,6 +2308,10 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 	if err != nil {
 		return group, err
 	}
+	passwordHistorySize, err := getPasswordHistorySizeFromPostFields(r)
+	if err != nil {
+		return group, err
+	}
 	group = dataprovider.Group{
 		BaseGroup: sdk.BaseGroup{
 			Name:        r.Form.Get("name"),
@@ -2162,13 +2332,25 @@ func getGroupFromPostFields(r *http.Request) (dataprovider.Group, error) {
 				ExpiresIn:            expiresIn,
 				Filters:              filters,
 			},
-			FsConfig: fsConfig,
+			FsConfig:            fsConfig,
+			PasswordHistorySize: passwordHistorySize,
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 	}
 	return group, nil
 }
 
+func getPasswordHistorySizeFromPostFields(r *http.Request) (int, error) {
+	if r.Form.Get("password_history_size") == "" {
+		return 0, nil
+	}
+	passwordHistorySize, err := strconv.Atoi(r.Form.Get("password_history_size"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid password history size: %w", err)
+	}
+	return passwordHistorySize, nil
+}
+
 func getKeyValsFromPostFields(r *http.Request, key, val string) []dataprovider.KeyValue {
 	var res []dataprovider.KeyValue
 	for k := range r.Form {
@@ -2284,6 +2466,13 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 	if r.Form.Get("idp_mode") == "1" {
 		idpMode = 1
 	}
+	backupRetention := 0
+	if r.Form.Get("backup_retention") != "" {
+		backupRetention, err = strconv.Atoi(r.Form.Get("backup_retention"))
+		if err != nil {
+			return dataprovider.BaseEventActionOptions{}, fmt.Errorf("invalid backup retention: %w", err)
+		}
+	}
 	options := dataprovider.BaseEventActionOptions{
 		HTTPConfig: dataprovider.EventActionHTTPConfig{
 			Endpoint:        r.Form.Get("http_endpoint"),
@@ -2332,6 +2521,14 @@ func getEventActionOptionsFromPostFields(r *http.Request) (dataprovider.BaseEven
 			TemplateUser:  strings.TrimSpace(r.Form.Get("idp_user")),
 			TemplateAdmin: strings.TrimSpace(r.Form.Get("idp_admin")),
 		},
+		BackupConfig: dataprovider.EventActionBackupConfig{
+			Compression: r.Form.Get("backup_compression"),
+			Passphrase:  getSecretFromFormField(r, "backup_passphrase"),
+			Recipients:  getSliceFromDelimitedValues(r.Form.Get("backup_recipients"), "\n"),
+			Folder:      strings.TrimSpace(r.Form.Get("backup_folder")),
+			Path:        strings.TrimSpace(r.Form.Get("backup_path")),
+			Retention:   backupRetention,
+		},
 	}
 	return options, nil
 }
@@ -2522,6 +2719,16 @@ func getEventRuleFromPostFields(r *http.Request) (dataprovider.EventRule, error)
 	return rule, nil
 }
 
+// getVersionFromPostFields returns the version of the edited object, submitted
+// as hidden form field. 0 means no check
+func getVersionFromPostFields(r *http.Request) int64 {
+	version, err := strconv.ParseInt(r.Form.Get("version"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
 func getRoleFromPostFields(r *http.Request) (dataprovider.Role, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -2624,6 +2831,31 @@ func getSMTPConfigsFromPostFields(r *http.Request) *dataprovider.SMTPConfigs {
 	}
 }
 
+func getRADIUSConfigsFromPostFields(r *http.Request) *dataprovider.RADIUSConfigs {
+	timeout, err := strconv.Atoi(r.Form.Get("radius_timeout"))
+	if err != nil {
+		timeout = 0
+	}
+	retries, err := strconv.Atoi(r.Form.Get("radius_retries"))
+	if err != nil {
+		retries = 0
+	}
+	mode, err := strconv.Atoi(r.Form.Get("radius_mode"))
+	if err != nil {
+		mode = dataprovider.RADIUSModePassword
+	}
+	return &dataprovider.RADIUSConfigs{
+		Servers:       getSliceFromDelimitedValues(r.Form.Get("radius_servers"), ","),
+		Secret:        getSecretFromFormField(r, "radius_secret"),
+		AuthType:      r.Form.Get("radius_auth_type"),
+		Timeout:       timeout,
+		Retries:       retries,
+		NASIdentifier: strings.TrimSpace(r.Form.Get("radius_nas_identifier")),
+		Mode:          mode,
+		Protocols:     r.Form["radius_protocols"],
+	}
+}
+
 func (s *httpdServer) handleWebAdminForgotPwd(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	if !smtp.IsEnabled() {
@@ -2776,10 +3008,17 @@ func (s *httpdServer) handleWebRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := restoreBackup(backupContent, "", scanQuota, restoreMode, claims.Username, ipAddr, claims.Role); err != nil {
+	results, err := restoreBackup(backupContent, r.Form.Get("backup_key"), "", scanQuota, restoreMode, false,
+		claims.Username, ipAddr, claims.Role)
+	if err != nil {
 		s.renderMaintenancePage(w, r, err.Error())
 		return
 	}
+	if !results.Applied {
+		s.renderMaintenancePage(w, r, "Unable to restore the backup, no changes applied: "+
+			strings.Join(results.GetErrors(), ", "))
+		return
+	}
 
 	s.renderMessagePage(w, r, "Data restored", "", http.StatusOK, nil, "Your backup was successfully restored")
 }
@@ -2902,6 +3141,7 @@ func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Re
 	}
 	updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
 	updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
+	updatedAdmin.Filters.PasswordHistory = admin.Filters.PasswordHistory
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		s.renderAddUpdateAdminPage(w, r, &updatedAdmin, "Invalid token claims", false)
@@ -2921,6 +3161,8 @@ func (s *httpdServer) handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Re
 			return
 		}
 	}
+	updatedAdmin.UpdatedAt = getVersionFromPostFields(r)
+	updatedAdmin.ExpectedVersion = updatedAdmin.UpdatedAt
 	err = dataprovider.UpdateAdmin(&updatedAdmin, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderAddUpdateAdminPage(w, r, &updatedAdmin, err.Error(), false)
@@ -2946,35 +3188,59 @@ func (s *httpdServer) handleGetWebUsers(w http.ResponseWriter, r *http.Request)
 		s.renderBadRequestPage(w, r, errors.New("invalid token claims"))
 		return
 	}
-	var limit int
-	if _, ok := r.URL.Query()["qlimit"]; ok {
-		var err error
-		limit, err = strconv.Atoi(r.URL.Query().Get("qlimit"))
-		if err != nil {
-			limit = defaultQueryLimit
-		}
-	} else {
-		limit = defaultQueryLimit
-	}
-	users := make([]dataprovider.User, 0, limit)
-	for {
-		u, err := dataprovider.GetUsers(limit, len(users), dataprovider.OrderASC, claims.Role)
-		if err != nil {
-			s.renderInternalServerErrorPage(w, r, err)
-			return
-		}
-		users = append(users, u...)
-		if len(u) < limit {
-			break
-		}
-	}
+	limit, page := getWebPagination(r)
 	data := usersPage{
 		basePage: s.getBasePageData(pageUsersTitle, webUsersPath, r),
-		Users:    users,
+		Filters:  r.URL.Query(),
+		Page:     page,
+	}
+	filters, err := getUserSearchFilters(r.URL.Query(), parseDateFilter)
+	if err != nil {
+		data.Error = err.Error()
+		renderAdminTemplate(w, templateUsers, data)
+		return
+	}
+	if claims.Role != "" {
+		filters.Role = claims.Role
+	}
+	// an additional user is requested to find out if there is a next page
+	users, err := dataprovider.GetUsers(limit+1, (page-1)*limit, dataprovider.OrderASC, filters)
+	if err != nil {
+		s.renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	if len(users) > limit {
+		users = users[:limit]
+		data.NextPageURL = getWebPageURL(r, page+1)
+	}
+	if page > 1 {
+		data.PrevPageURL = getWebPageURL(r, page-1)
 	}
+	data.Users = users
 	renderAdminTemplate(w, templateUsers, data)
 }
 
+// getWebPagination returns the page size, from the "qlimit" query parameter, and the
+// requested page, starting from 1
+func getWebPagination(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("qlimit"))
+	if err != nil || limit <= 0 || limit > defaultQueryLimit {
+		limit = defaultQueryLimit
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return limit, page
+}
+
+// getWebPageURL returns the current URL, including the filters, for the specified page
+func getWebPageURL(r *http.Request, page int) string {
+	q := r.URL.Query()
+	q.Set("page", strconv.Itoa(page))
+	return r.URL.Path + "?" + q.Encode()
+}
+
 func (s *httpdServer) handleWebTemplateFolderGet(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 	if r.URL.Query().Get("from") != "" {
@@ -3218,6 +3484,8 @@ func (s *httpdServer) handleWebAddUserPost(w http.ResponseWriter, r *http.Reques
 		user.Role = claims.Role
 	}
 	user.Filters.RecoveryCodes = nil
+	user.Filters.PasswordHistory = nil
+	user.Filters.PublicKeysLastUse = nil
 	user.Filters.TOTPConfig = dataprovider.UserTOTPConfig{
 		Enabled: false,
 	}
@@ -3259,6 +3527,8 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updatedUser.Username = user.Username
 	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
 	updatedUser.Filters.TOTPConfig = user.Filters.TOTPConfig
+	updatedUser.Filters.PasswordHistory = user.Filters.PasswordHistory
+	updatedUser.Filters.PublicKeysLastUse = user.Filters.PublicKeysLastUse
 	updatedUser.LastPasswordChange = user.LastPasswordChange
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
@@ -3267,7 +3537,8 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
 		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.SFTPConfig.KeyPassphrase,
-		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey)
+		user.FsConfig.HTTPConfig.Password, user.FsConfig.HTTPConfig.APIKey, user.FsConfig.WebDAVConfig.Password,
+		user.FsConfig.FTPConfig.Password, user.FsConfig.SMBConfig.Password, user.FsConfig.GetEncryptionPassphrase())
 
 	updatedUser = getUserFromTemplate(updatedUser, userTemplateFields{
 		Username:   updatedUser.Username,
@@ -3278,6 +3549,8 @@ func (s *httpdServer) handleWebUpdateUserPost(w http.ResponseWriter, r *http.Req
 		updatedUser.Role = claims.Role
 	}
 
+	updatedUser.UpdatedAt = getVersionFromPostFields(r)
+	updatedUser.ExpectedVersion = updatedUser.UpdatedAt
 	err = dataprovider.UpdateUser(&updatedUser, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderUserPage(w, r, &updatedUser, userPageModeUpdate, err.Error(), nil)
@@ -3416,10 +3689,13 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
 		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
 		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.SFTPConfig.KeyPassphrase,
-		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey)
+		folder.FsConfig.HTTPConfig.Password, folder.FsConfig.HTTPConfig.APIKey, folder.FsConfig.WebDAVConfig.Password,
+		folder.FsConfig.FTPConfig.Password, folder.FsConfig.SMBConfig.Password, folder.FsConfig.GetEncryptionPassphrase())
 
 	updatedFolder = getFolderFromTemplate(updatedFolder, updatedFolder.Name)
 
+	updatedFolder.UpdatedAt = getVersionFromPostFields(r)
+	updatedFolder.ExpectedVersion = updatedFolder.UpdatedAt
 	err = dataprovider.UpdateFolder(&updatedFolder, folder.Users, folder.Groups, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderFolderPage(w, r, updatedFolder, folderPageModeUpdate, err.Error())
@@ -3428,10 +3704,12 @@ func (s *httpdServer) handleWebUpdateFolderPost(w http.ResponseWriter, r *http.R
 	http.Redirect(w, r, webFoldersPath, http.StatusSeeOther)
 }
 
-func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]vfs.BaseVirtualFolder, error) {
+func (s *httpdServer) getWebVirtualFolders(w http.ResponseWriter, r *http.Request, limit int, minimal bool,
+	filters dataprovider.FolderSearchFilters,
+) ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, limit)
 	for {
-		f, err := dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal)
+		f, err := dataprovider.GetFolders(limit, len(folders), dataprovider.OrderASC, minimal, filters)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return folders, err
@@ -3454,22 +3732,30 @@ func (s *httpdServer) handleWebGetFolders(w http.ResponseWriter, r *http.Request
 			limit = defaultQueryLimit
 		}
 	}
-	folders, err := s.getWebVirtualFolders(w, r, limit, false)
+	data := foldersPage{
+		basePage: s.getBasePageData(pageFoldersTitle, webFoldersPath, r),
+		Filters:  r.URL.Query(),
+	}
+	filters, err := getFolderSearchFilters(r.URL.Query())
 	if err != nil {
+		data.Error = err.Error()
+		renderAdminTemplate(w, templateFolders, data)
 		return
 	}
-
-	data := foldersPage{
-		basePage: s.getBasePageData(pageFoldersTitle, webFoldersPath, r),
-		Folders:  folders,
+	folders, err := s.getWebVirtualFolders(w, r, limit, false, filters)
+	if err != nil {
+		return
 	}
+	data.Folders = folders
 	renderAdminTemplate(w, templateFolders, data)
 }
 
-func (s *httpdServer) getWebGroups(w http.ResponseWriter, r *http.Request, limit int, minimal bool) ([]dataprovider.Group, error) {
+func (s *httpdServer) getWebGroups(w http.ResponseWriter, r *http.Request, limit int, minimal bool,
+	filters dataprovider.SearchFilters,
+) ([]dataprovider.Group, error) {
 	groups := make([]dataprovider.Group, 0, limit)
 	for {
-		f, err := dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal)
+		f, err := dataprovider.GetGroups(limit, len(groups), dataprovider.OrderASC, minimal, filters)
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return groups, err
@@ -3492,7 +3778,7 @@ func (s *httpdServer) handleWebGetGroups(w http.ResponseWriter, r *http.Request)
 			limit = defaultQueryLimit
 		}
 	}
-	groups, err := s.getWebGroups(w, r, limit, false)
+	groups, err := s.getWebGroups(w, r, limit, false, getNameSearchFilters(r.URL.Query()))
 	if err != nil {
 		return
 	}
@@ -3500,6 +3786,7 @@ func (s *httpdServer) handleWebGetGroups(w http.ResponseWriter, r *http.Request)
 	data := groupsPage{
 		basePage: s.getBasePageData(pageGroupsTitle, webGroupsPath, r),
 		Groups:   groups,
+		Filters:  r.URL.Query(),
 	}
 	renderAdminTemplate(w, templateGroups, data)
 }
@@ -3582,8 +3869,12 @@ func (s *httpdServer) handleWebUpdateGroupPost(w http.ResponseWriter, r *http.Re
 		group.UserSettings.FsConfig.GCSConfig.Credentials, group.UserSettings.FsConfig.CryptConfig.Passphrase,
 		group.UserSettings.FsConfig.SFTPConfig.Password, group.UserSettings.FsConfig.SFTPConfig.PrivateKey,
 		group.UserSettings.FsConfig.SFTPConfig.KeyPassphrase, group.UserSettings.FsConfig.HTTPConfig.Password,
-		group.UserSettings.FsConfig.HTTPConfig.APIKey)
+		group.UserSettings.FsConfig.HTTPConfig.APIKey, group.UserSettings.FsConfig.WebDAVConfig.Password,
+		group.UserSettings.FsConfig.FTPConfig.Password, group.UserSettings.FsConfig.SMBConfig.Password,
+		group.UserSettings.FsConfig.GetEncryptionPassphrase())
 
+	updatedGroup.UpdatedAt = getVersionFromPostFields(r)
+	updatedGroup.ExpectedVersion = updatedGroup.UpdatedAt
 	err = dataprovider.UpdateGroup(&updatedGroup, group.Users, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderGroupPage(w, r, updatedGroup, genericPageModeUpdate, err.Error())
@@ -3710,7 +4001,13 @@ func (s *httpdServer) handleWebUpdateEventActionPost(w http.ResponseWriter, r *h
 		if updatedAction.Options.HTTPConfig.Password.IsNotPlainAndNotEmpty() {
 			updatedAction.Options.HTTPConfig.Password = action.Options.HTTPConfig.Password
 		}
+	case dataprovider.ActionTypeBackup:
+		if updatedAction.Options.BackupConfig.Passphrase.IsNotPlainAndNotEmpty() {
+			updatedAction.Options.BackupConfig.Passphrase = action.Options.BackupConfig.Passphrase
+		}
 	}
+	updatedAction.UpdatedAt = getVersionFromPostFields(r)
+	updatedAction.ExpectedVersion = updatedAction.UpdatedAt
 	err = dataprovider.UpdateEventAction(&updatedAction, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderEventActionPage(w, r, updatedAction, genericPageModeUpdate, err.Error())
@@ -3822,6 +4119,8 @@ func (s *httpdServer) handleWebUpdateEventRulePost(w http.ResponseWriter, r *htt
 	}
 	updatedRule.ID = rule.ID
 	updatedRule.Name = rule.Name
+	updatedRule.UpdatedAt = getVersionFromPostFields(r)
+	updatedRule.ExpectedVersion = updatedRule.UpdatedAt
 	err = dataprovider.UpdateEventRule(&updatedRule, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderEventRulePage(w, r, updatedRule, genericPageModeUpdate, err.Error())
@@ -3929,6 +4228,8 @@ func (s *httpdServer) handleWebUpdateRolePost(w http.ResponseWriter, r *http.Req
 	}
 	updatedRole.ID = role.ID
 	updatedRole.Name = role.Name
+	updatedRole.UpdatedAt = getVersionFromPostFields(r)
+	updatedRole.ExpectedVersion = updatedRole.UpdatedAt
 	err = dataprovider.UpdateRole(&updatedRole, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderRolePage(w, r, updatedRole, genericPageModeUpdate, err.Error())
@@ -3937,6 +4238,58 @@ func (s *httpdServer) handleWebUpdateRolePost(w http.ResponseWriter, r *http.Req
 	http.Redirect(w, r, webAdminRolesPath, http.StatusSeeOther)
 }
 
+func (s *httpdServer) handleWebGetObjectHistory(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		s.renderBadRequestPage(w, r, errors.New("invalid token claims"))
+		return
+	}
+	objectType := getURLParam(r, "objecttype")
+	objectName := getURLParam(r, "name")
+	perm, err := getHistoryPermission(objectType, false)
+	if err != nil {
+		s.renderBadRequestPage(w, r, err)
+		return
+	}
+	if !claims.hasPerm(perm) {
+		s.renderForbiddenPage(w, r, "You don't have permission for this action.")
+		return
+	}
+	restorePerm, _ := getHistoryPermission(objectType, true)
+	versions, err := dataprovider.GetObjectHistory(objectType, objectName, defaultQueryLimit, 0,
+		dataprovider.OrderDESC, claims.Role)
+	if err != nil {
+		s.renderMessagePage(w, r, "Unable to get the object history", "", getRespStatus(err), err, "")
+		return
+	}
+	data := objectHistoryPage{
+		basePage:   s.getBasePageData(pageHistoryTitle, webAdminHistoryPath, r),
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Versions:   versions,
+		HistoryURL: fmt.Sprintf("%s/%s/%s", webAdminHistoryPath, objectType, url.PathEscape(objectName)),
+		CanRestore: claims.hasPerm(restorePerm),
+	}
+	if len(versions) > 0 {
+		versionID := versions[0].ID
+		if val := r.URL.Query().Get("version"); val != "" {
+			versionID, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				s.renderBadRequestPage(w, r, fmt.Errorf("invalid version %q", val))
+				return
+			}
+		}
+		diff, err := dataprovider.GetObjectVersionDiff(objectType, objectName, versionID, 0, claims.Role)
+		if err != nil {
+			s.renderMessagePage(w, r, "Unable to get the version changes", "", getRespStatus(err), err, "")
+			return
+		}
+		data.Diff = &diff
+	}
+	renderAdminTemplate(w, templateHistory, data)
+}
+
 func (s *httpdServer) handleWebGetEvents(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
@@ -4051,6 +4404,8 @@ func (s *httpdServer) handleWebUpdateIPListEntryPost(w http.ResponseWriter, r *h
 	}
 	updatedEntry.Type = listType
 	updatedEntry.IPOrNet = ipOrNet
+	updatedEntry.UpdatedAt = getVersionFromPostFields(r)
+	updatedEntry.ExpectedVersion = updatedEntry.UpdatedAt
 	err = dataprovider.UpdateIPListEntry(&updatedEntry, claims.Username, ipAddr, claims.Role)
 	if err != nil {
 		s.renderIPListPage(w, r, entry, genericPageModeUpdate, err.Error())
@@ -4112,6 +4467,13 @@ func (s *httpdServer) handleWebConfigsPost(w http.ResponseWriter, r *http.Reques
 			smtpConfigs.Password = configs.SMTP.Password
 		}
 		configs.SMTP = smtpConfigs
+	case "radius_submit":
+		configSection = 4
+		radiusConfigs := getRADIUSConfigsFromPostFields(r)
+		if radiusConfigs.Secret.IsNotPlainAndNotEmpty() && configs.RADIUS != nil {
+			radiusConfigs.Secret = configs.RADIUS.Secret
+		}
+		configs.RADIUS = radiusConfigs
 	default:
 		s.renderBadRequestPage(w, r, errors.New("unsupported form action"))
 		return
@@ -30,7 +30,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/go-chi/render"
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
 
@@ -193,6 +192,7 @@ type clientMessagePage struct {
 type clientProfilePage struct {
 	baseClientPage
 	PublicKeys      []string
+	PublicKeysInfo  []dataprovider.PublicKey
 	CanSubmit       bool
 	AllowAPIKeyAuth bool
 	Email           string
@@ -480,6 +480,32 @@ func (s *httpdServer) renderClientTwoFactorPage(w http.ResponseWriter, error, ip
 	renderClientTemplate(w, templateTwoFactor, data)
 }
 
+// renderClientRADIUSTwoFactorPage renders the two-factor page, without the recovery codes
+// link, for the users that must authenticate using the RADIUS passcode
+func (s *httpdServer) renderClientRADIUSTwoFactorPage(w http.ResponseWriter, error, ip string) {
+	data := twoFactorPage{
+		CurrentURL: webClientTwoFactorPath,
+		Version:    version.Get().Version,
+		Error:      error,
+		CSRFToken:  createCSRFToken(ip),
+		StaticURL:  webStaticFilesPath,
+		Branding:   s.binding.Branding.WebClient,
+	}
+	renderClientTemplate(w, templateTwoFactor, data)
+}
+
+func (s *httpdServer) renderClientRADIUSChallengePage(w http.ResponseWriter, message, ip string) {
+	data := twoFactorPage{
+		CurrentURL: webClientTwoFactorPath,
+		Version:    version.Get().Version,
+		Message:    message,
+		CSRFToken:  createCSRFToken(ip),
+		StaticURL:  webStaticFilesPath,
+		Branding:   s.binding.Branding.WebClient,
+	}
+	renderClientTemplate(w, templateTwoFactor, data)
+}
+
 func (s *httpdServer) renderClientTwoFactorRecoveryPage(w http.ResponseWriter, error, ip string) {
 	data := twoFactorPage{
 		CurrentURL: webClientTwoFactorRecoveryPath,
@@ -627,6 +653,7 @@ func (s *httpdServer) renderClientProfilePage(w http.ResponseWriter, r *http.Req
 		return
 	}
 	data.PublicKeys = user.PublicKeys
+	data.PublicKeysInfo = user.GetPublicKeysInfo()
 	data.AllowAPIKeyAuth = user.Filters.AllowAPIKeyAuth
 	data.Email = user.Email
 	data.Description = user.Description
@@ -754,17 +781,16 @@ func (s *httpdServer) handleShareGetDirContents(w http.ResponseWriter, r *http.R
 	}
 	defer common.Connections.Remove(connection.GetID())
 
-	contents, err := connection.ReadDir(name)
+	lister, err := connection.ReadDir(name)
 	if err != nil {
 		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
 		return
 	}
-	results := make([]map[string]string, 0, len(contents))
-	for _, info := range contents {
+	streamDirContents(w, r, lister, func(info os.FileInfo) map[string]any {
 		if !info.Mode().IsDir() && !info.Mode().IsRegular() {
-			continue
+			return nil
 		}
-		res := make(map[string]string)
+		res := make(map[string]any)
 		if info.IsDir() {
 			res["type"] = "1"
 			res["size"] = ""
@@ -777,10 +803,8 @@ func (s *httpdServer) handleShareGetDirContents(w http.ResponseWriter, r *http.R
 		res["url"] = getFileObjectURL(share.GetRelativePath(name), info.Name(),
 			path.Join(webClientPubSharesPath, share.ShareID, "browse"))
 		res["last_modified"] = getFileObjectModTime(info.ModTime())
-		results = append(results, res)
-	}
-
-	render.JSON(w, r, results)
+		return res
+	})
 }
 
 func (s *httpdServer) handleClientUploadToShare(w http.ResponseWriter, r *http.Request) {
@@ -876,16 +900,16 @@ func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.
 	defer common.Connections.Remove(connection.GetID())
 
 	name := connection.User.GetCleanedPath(r.URL.Query().Get("path"))
-	contents, err := connection.ReadDir(name)
+	lister, err := connection.ReadDir(name)
 	if err != nil {
 		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
 		return
 	}
 
-	results := make([]map[string]string, 0, len(contents))
-	for _, info := range contents {
-		res := make(map[string]string)
-		res["url"] = getFileObjectURL(name, info.Name(), webClientFilesPath)
+	streamDirContents(w, r, lister, func(info os.FileInfo) map[string]any {
+		res := make(map[string]any)
+		fileURL := getFileObjectURL(name, info.Name(), webClientFilesPath)
+		res["url"] = fileURL
 		if info.IsDir() {
 			res["type"] = "1"
 			res["size"] = ""
@@ -896,7 +920,7 @@ func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.
 			} else {
 				res["size"] = util.ByteCountIEC(info.Size())
 				if info.Size() < httpdMaxEditFileSize {
-					res["edit_url"] = strings.Replace(res["url"], webClientFilesPath, webClientEditFilePath, 1)
+					res["edit_url"] = strings.Replace(fileURL, webClientFilesPath, webClientEditFilePath, 1)
 				}
 				if len(s.binding.WebClientIntegrations) > 0 {
 					extension := path.Ext(info.Name())
@@ -914,10 +938,8 @@ func (s *httpdServer) handleClientGetDirContents(w http.ResponseWriter, r *http.
 		res["meta"] = fmt.Sprintf("%v_%v", res["type"], info.Name())
 		res["name"] = info.Name()
 		res["last_modified"] = getFileObjectModTime(info.ModTime())
-		results = append(results, res)
-	}
-
-	render.JSON(w, r, results)
+		return res
+	})
 }
 
 func (s *httpdServer) handleClientGetFiles(w http.ResponseWriter, r *http.Request) {
@@ -1204,7 +1226,8 @@ func (s *httpdServer) handleClientGetShares(w http.ResponseWriter, r *http.Reque
 	}
 	shares := make([]dataprovider.Share, 0, limit)
 	for {
-		sh, err := dataprovider.GetShares(limit, len(shares), dataprovider.OrderASC, claims.Username)
+		sh, err := dataprovider.GetShares(limit, len(shares), dataprovider.OrderASC, claims.Username,
+			dataprovider.SearchFilters{})
 		if err != nil {
 			s.renderInternalServerErrorPage(w, r, err)
 			return
@@ -1284,7 +1307,19 @@ func (s *httpdServer) handleWebClientMFA(w http.ResponseWriter, r *http.Request)
 
 func (s *httpdServer) handleWebClientTwoFactor(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-	s.renderClientTwoFactorPage(w, "", util.GetIPFromRemoteAddress(r.RemoteAddr))
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	if claims, err := getTokenClaims(r); err == nil {
+		if claims.RADIUSState != "" {
+			s.renderClientRADIUSTwoFactorPage(w, "", ipAddr)
+			return
+		}
+		user, err := dataprovider.GetUserWithGroupSettings(claims.Username, "")
+		if err == nil && user.MustUseRADIUSSecondFactor(common.ProtocolHTTP) {
+			s.renderClientRADIUSTwoFactorPage(w, "", ipAddr)
+			return
+		}
+	}
+	s.renderClientTwoFactorPage(w, "", ipAddr)
 }
 
 func (s *httpdServer) handleWebClientTwoFactorRecovery(w http.ResponseWriter, r *http.Request) {
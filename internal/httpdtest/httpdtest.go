@@ -64,6 +64,7 @@ const (
 	eventRulesPath        = "/api/v2/eventrules"
 	rolesPath             = "/api/v2/roles"
 	ipListsPath           = "/api/v2/iplists"
+	revokedUserCertsPath  = "/api/v2/revokedusercerts"
 )
 
 const (
@@ -547,6 +548,65 @@ func RemoveIPListEntry(entry dataprovider.IPListEntry, expectedStatusCode int) (
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// AddRevokedUserCert adds a new SSH user certificates revocation and checks the received HTTP Status code against expectedStatusCode.
+func AddRevokedUserCert(cert dataprovider.RevokedUserCert, expectedStatusCode int) (dataprovider.RevokedUserCert, []byte, error) {
+	var newCert dataprovider.RevokedUserCert
+	var body []byte
+
+	asJSON, _ := json.Marshal(cert)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(revokedUserCertsPath),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return newCert, body, err
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusCreated {
+		body, _ = getResponseBody(resp)
+		return newCert, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newCert)
+	}
+	if err == nil && newCert.ID == "" {
+		err = errors.New("revoked certificate ID must be set")
+	}
+	return newCert, body, err
+}
+
+// RemoveRevokedUserCert removes the SSH user certificates revocation with the specified ID
+// and checks the received HTTP Status code against expectedStatusCode.
+func RemoveRevokedUserCert(id string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(revokedUserCertsPath, url.PathEscape(id)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetRevokedUserCerts returns the SSH user certificates revocations and checks the received HTTP Status code against expectedStatusCode.
+func GetRevokedUserCerts(expectedStatusCode int) ([]dataprovider.RevokedUserCert, []byte, error) {
+	var certs []dataprovider.RevokedUserCert
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(revokedUserCertsPath), nil, "", getDefaultToken())
+	if err != nil {
+		return certs, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &certs)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return certs, body, err
+}
+
 // GetIPListEntry returns an IP list entry matching the specified parameters, if exists,
 // and checks the received HTTP Status code against expectedStatusCode.
 func GetIPListEntry(ipOrNet string, listType dataprovider.IPListType, expectedStatusCode int,
@@ -1602,6 +1662,9 @@ func checkEventAction(expected, actual dataprovider.BaseEventAction) error {
 	if err := compareEventActionIDPConfigFields(expected.Options.IDPConfig, actual.Options.IDPConfig); err != nil {
 		return err
 	}
+	if err := compareEventActionBackupConfigFields(expected.Options.BackupConfig, actual.Options.BackupConfig); err != nil {
+		return err
+	}
 	if err := compareEventActionCmdConfigFields(expected.Options.CmdConfig, actual.Options.CmdConfig); err != nil {
 		return err
 	}
@@ -1850,6 +1913,9 @@ func checkGroup(expected, actual dataprovider.Group) error {
 		actual.UserSettings.BaseGroupUserSettings); err != nil {
 		return err
 	}
+	if expected.UserSettings.PasswordHistorySize != actual.UserSettings.PasswordHistorySize {
+		return errors.New("password_history_size mismatch")
+	}
 	if err := compareVirtualFolders(expected.VirtualFolders, actual.VirtualFolders); err != nil {
 		return err
 	}
@@ -2019,6 +2085,9 @@ func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if expected.Filters.RequirePasswordChange != actual.Filters.RequirePasswordChange {
 		return errors.New("require_password_change mismatch")
 	}
+	if expected.Filters.PasswordHistorySize != actual.Filters.PasswordHistorySize {
+		return errors.New("password_history_size mismatch")
+	}
 	if err := compareUserPermissions(expected.Permissions, actual.Permissions); err != nil {
 		return err
 	}
@@ -2141,10 +2210,25 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if err := checkEncryptedSecret(expected.CryptConfig.Passphrase, actual.CryptConfig.Passphrase); err != nil {
 		return err
 	}
+	if err := compareEncryptionConfigs(expected, actual); err != nil {
+		return err
+	}
 	if err := compareSFTPFsConfig(expected, actual); err != nil {
 		return err
 	}
-	return compareHTTPFsConfig(expected, actual)
+	if err := compareHTTPFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareWebDAVFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareFTPFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareSMBFsConfig(expected, actual); err != nil {
+		return err
+	}
+	return compareOverlayFsConfig(expected, actual)
 }
 
 func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { //nolint:gocyclo
@@ -2193,6 +2277,9 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error { /
 	if expected.S3Config.UploadPartMaxTime != actual.S3Config.UploadPartMaxTime {
 		return errors.New("fs S3 upload part max time mismatch")
 	}
+	if expected.S3Config.MetadataCache != actual.S3Config.MetadataCache {
+		return errors.New("fs S3 metadata cache mismatch")
+	}
 	if expected.S3Config.KeyPrefix != actual.S3Config.KeyPrefix &&
 		expected.S3Config.KeyPrefix+"/" != actual.S3Config.KeyPrefix {
 		return errors.New("fs S3 key prefix mismatch")
@@ -2223,6 +2310,9 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.UploadPartMaxTime != actual.GCSConfig.UploadPartMaxTime {
 		return errors.New("GCS upload part max time mismatch")
 	}
+	if expected.GCSConfig.MetadataCache != actual.GCSConfig.MetadataCache {
+		return errors.New("GCS metadata cache mismatch")
+	}
 	return nil
 }
 
@@ -2248,6 +2338,100 @@ func compareHTTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	return nil
 }
 
+func compareWebDAVFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.WebDAVConfig.Endpoint != actual.WebDAVConfig.Endpoint {
+		return errors.New("WebDAVFs endpoint mismatch")
+	}
+	if expected.WebDAVConfig.Username != actual.WebDAVConfig.Username {
+		return errors.New("WebDAVFs username mismatch")
+	}
+	if expected.WebDAVConfig.SkipTLSVerify != actual.WebDAVConfig.SkipTLSVerify {
+		return errors.New("WebDAVFs skip_tls_verify mismatch")
+	}
+	if expected.WebDAVConfig.EqualityCheckMode != actual.WebDAVConfig.EqualityCheckMode {
+		return errors.New("WebDAVFs equality_check_mode mismatch")
+	}
+	if err := checkEncryptedSecret(expected.WebDAVConfig.Password, actual.WebDAVConfig.Password); err != nil {
+		return fmt.Errorf("WebDAVFs password mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.FTPConfig.Endpoint != actual.FTPConfig.Endpoint {
+		return errors.New("FTPFs endpoint mismatch")
+	}
+	if expected.FTPConfig.Username != actual.FTPConfig.Username {
+		return errors.New("FTPFs username mismatch")
+	}
+	if expected.FTPConfig.TLSMode != actual.FTPConfig.TLSMode {
+		return errors.New("FTPFs tls_mode mismatch")
+	}
+	if expected.FTPConfig.SkipTLSVerify != actual.FTPConfig.SkipTLSVerify {
+		return errors.New("FTPFs skip_tls_verify mismatch")
+	}
+	if expected.FTPConfig.DisableEPSV != actual.FTPConfig.DisableEPSV {
+		return errors.New("FTPFs disable_epsv mismatch")
+	}
+	if expected.FTPConfig.DisableMLSD != actual.FTPConfig.DisableMLSD {
+		return errors.New("FTPFs disable_mlsd mismatch")
+	}
+	if expected.FTPConfig.EqualityCheckMode != actual.FTPConfig.EqualityCheckMode {
+		return errors.New("FTPFs equality_check_mode mismatch")
+	}
+	if expected.FTPConfig.Prefix != actual.FTPConfig.Prefix {
+		if expected.FTPConfig.Prefix != "" && actual.FTPConfig.Prefix != "/" {
+			return errors.New("FTPFs prefix mismatch")
+		}
+	}
+	if err := checkEncryptedSecret(expected.FTPConfig.Password, actual.FTPConfig.Password); err != nil {
+		return fmt.Errorf("FTPFs password mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareSMBFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.SMBConfig.Endpoint != actual.SMBConfig.Endpoint {
+		return errors.New("SMBFs endpoint mismatch")
+	}
+	if expected.SMBConfig.Username != actual.SMBConfig.Username {
+		return errors.New("SMBFs username mismatch")
+	}
+	if expected.SMBConfig.Domain != actual.SMBConfig.Domain {
+		return errors.New("SMBFs domain mismatch")
+	}
+	if expected.SMBConfig.Share != actual.SMBConfig.Share {
+		return errors.New("SMBFs share mismatch")
+	}
+	if expected.SMBConfig.EqualityCheckMode != actual.SMBConfig.EqualityCheckMode {
+		return errors.New("SMBFs equality_check_mode mismatch")
+	}
+	if expected.SMBConfig.Prefix != actual.SMBConfig.Prefix {
+		if expected.SMBConfig.Prefix != "" && actual.SMBConfig.Prefix != "/" {
+			return errors.New("SMBFs prefix mismatch")
+		}
+	}
+	if err := checkEncryptedSecret(expected.SMBConfig.Password, actual.SMBConfig.Password); err != nil {
+		return fmt.Errorf("SMBFs password mismatch: %v", err)
+	}
+	return nil
+}
+
+func compareOverlayFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.OverlayConfig.Upper != actual.OverlayConfig.Upper {
+		return errors.New("OverlayFs upper mismatch")
+	}
+	if len(expected.OverlayConfig.Layers) != len(actual.OverlayConfig.Layers) {
+		return errors.New("OverlayFs layers mismatch")
+	}
+	for idx, layer := range expected.OverlayConfig.Layers {
+		if actual.OverlayConfig.Layers[idx] != layer {
+			return errors.New("OverlayFs layers mismatch")
+		}
+	}
+	return nil
+}
+
 func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.SFTPConfig.Endpoint != actual.SFTPConfig.Endpoint {
 		return errors.New("SFTPFs endpoint mismatch")
@@ -2327,6 +2511,9 @@ func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.AzBlobConfig.AccessTier != actual.AzBlobConfig.AccessTier {
 		return errors.New("azure Blob access tier mismatch")
 	}
+	if expected.AzBlobConfig.MetadataCache != actual.AzBlobConfig.MetadataCache {
+		return errors.New("azure Blob metadata cache mismatch")
+	}
 	return nil
 }
 
@@ -2343,6 +2530,22 @@ func areSecretEquals(expected, actual *kms.Secret) bool {
 	return false
 }
 
+func compareEncryptionConfigs(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if err := checkEncryptedSecret(expected.S3Config.Encryption.Passphrase, actual.S3Config.Encryption.Passphrase); err != nil {
+		return fmt.Errorf("fs S3 encryption passphrase: %w", err)
+	}
+	if err := checkEncryptedSecret(expected.GCSConfig.Encryption.Passphrase, actual.GCSConfig.Encryption.Passphrase); err != nil {
+		return fmt.Errorf("fs GCS encryption passphrase: %w", err)
+	}
+	if err := checkEncryptedSecret(expected.AzBlobConfig.Encryption.Passphrase, actual.AzBlobConfig.Encryption.Passphrase); err != nil {
+		return fmt.Errorf("fs Azure Blob encryption passphrase: %w", err)
+	}
+	if err := checkEncryptedSecret(expected.SFTPConfig.Encryption.Passphrase, actual.SFTPConfig.Encryption.Passphrase); err != nil {
+		return fmt.Errorf("fs SFTP encryption passphrase: %w", err)
+	}
+	return nil
+}
+
 func checkEncryptedSecret(expected, actual *kms.Secret) error {
 	if areSecretEquals(expected, actual) {
 		return nil
@@ -2729,6 +2932,37 @@ func compareEventActionIDPConfigFields(expected, actual dataprovider.EventAction
 	return nil
 }
 
+func compareEventActionBackupConfigFields(expected, actual dataprovider.EventActionBackupConfig) error {
+	if expected.Compression != actual.Compression {
+		return errors.New("backup compression mismatch")
+	}
+	if expected.Folder != actual.Folder {
+		return errors.New("backup folder mismatch")
+	}
+	expectedPath := expected.Path
+	if expectedPath != "" {
+		expectedPath = strings.TrimPrefix(util.CleanPath(expectedPath), "/")
+	}
+	if expectedPath != actual.Path {
+		return errors.New("backup path mismatch")
+	}
+	if expected.Retention != actual.Retention {
+		return errors.New("backup retention mismatch")
+	}
+	if len(expected.Recipients) != len(actual.Recipients) {
+		return errors.New("backup recipients mismatch")
+	}
+	for _, v := range expected.Recipients {
+		if !util.Contains(actual.Recipients, v) {
+			return errors.New("backup recipients content mismatch")
+		}
+	}
+	if err := checkEncryptedSecret(expected.Passphrase, actual.Passphrase); err != nil {
+		return fmt.Errorf("backup passphrase mismatch: %w", err)
+	}
+	return nil
+}
+
 func compareEventActionCmdConfigFields(expected, actual dataprovider.EventActionCommandConfig) error {
 	if expected.Cmd != actual.Cmd {
 		return errors.New("command mismatch")
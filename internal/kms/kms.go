@@ -16,8 +16,12 @@
 package kms
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 	"sync"
@@ -59,6 +63,8 @@ type Secrets struct {
 	MasterKeyPath   string `json:"master_key_path" mapstructure:"master_key_path"`
 	MasterKeyString string `json:"master_key" mapstructure:"master_key"`
 	masterKey       string
+	// previous master keys, only used to decrypt the secrets not yet rotated
+	previousMasterKeys []string
 }
 
 type registeredSecretProvider struct {
@@ -75,6 +81,7 @@ var (
 	validSecretStatuses = []string{sdkkms.SecretStatusPlain, sdkkms.SecretStatusAES256GCM, sdkkms.SecretStatusSecretBox,
 		sdkkms.SecretStatusVaultTransit, sdkkms.SecretStatusAWS, sdkkms.SecretStatusGCP, sdkkms.SecretStatusRedacted}
 	config          Configuration
+	configMu        sync.RWMutex
 	secretProviders = make(map[string]registeredSecretProvider)
 )
 
@@ -90,7 +97,8 @@ func RegisterSecretProvider(scheme string, encryptedStatus sdkkms.SecretStatus,
 
 // NewSecret builds a new Secret using the provided arguments
 func NewSecret(status sdkkms.SecretStatus, payload, key, data string) *Secret {
-	return config.newSecret(status, payload, key, data)
+	c := getConfig()
+	return c.newSecret(status, payload, key, data)
 }
 
 // NewEmptySecret returns an empty secret
@@ -114,11 +122,21 @@ func (c *Configuration) Initialize() error {
 			return err
 		}
 		c.Secrets.masterKey = strings.TrimSpace(string(mKey))
+		// the previous master key is saved while rotating it and is required
+		// until all the secrets are encrypted using the new one
+		mKey, err = os.ReadFile(getPreviousMasterKeyPath(c.Secrets.MasterKeyPath))
+		if err == nil {
+			c.Secrets.previousMasterKeys = []string{strings.TrimSpace(string(mKey))}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
+	configMu.Lock()
 	config = *c
 	if config.Secrets.URL == "" {
 		config.Secrets.URL = sdkkms.SchemeLocal + "://"
 	}
+	configMu.Unlock()
 	for k, v := range secretProviders {
 		logger.Info(logSender, "", "secret provider registered for scheme: %q, encrypted status: %q",
 			k, v.encryptedStatus)
@@ -126,6 +144,148 @@ func (c *Configuration) Initialize() error {
 	return nil
 }
 
+// IsLocalProvider returns true if the configured secrets provider is the local one
+func IsLocalProvider() bool {
+	c := getConfig()
+	return strings.HasPrefix(c.Secrets.URL, sdkkms.SchemeLocal)
+}
+
+// CanRotateMasterKey returns an error if the master key cannot be rotated
+// at runtime. The local provider is required and the master key must be read
+// from a file, so the new one can be persisted
+func CanRotateMasterKey() error {
+	c := getConfig()
+	if !strings.HasPrefix(c.Secrets.URL, sdkkms.SchemeLocal) {
+		return errors.New("master key rotation is only supported for the local KMS provider")
+	}
+	if c.Secrets.MasterKeyPath == "" || c.Secrets.MasterKeyString != "" || c.Secrets.masterKey == "" {
+		return errors.New("master key rotation requires a master key read from a file")
+	}
+	return nil
+}
+
+// RotateMasterKey saves the specified master key to the configured master key
+// path and then uses it for new secrets and for the secrets loaded from now on.
+// The current master key is saved too, it is still used to decrypt the secrets
+// not yet rotated
+func RotateMasterKey(masterKey string) error {
+	if err := CanRotateMasterKey(); err != nil {
+		return err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if masterKey == config.Secrets.masterKey {
+		return nil
+	}
+	keyPath := config.Secrets.MasterKeyPath
+	if err := writeMasterKey(getPreviousMasterKeyPath(keyPath), config.Secrets.masterKey); err != nil {
+		return fmt.Errorf("unable to save the previous master key: %w", err)
+	}
+	if err := writeMasterKey(keyPath, masterKey); err != nil {
+		return fmt.Errorf("unable to save the new master key: %w", err)
+	}
+	config.Secrets.setMasterKey(masterKey)
+	return nil
+}
+
+// ReloadMasterKey reads the master key from the configured master key path
+// and uses it for new secrets and for the secrets loaded from now on. The
+// master key must match the specified fingerprint, it is distributed to the
+// instances sharing the same data provider out-of-band. The current master key
+// is saved to the previous master key path, it is still used to decrypt the
+// secrets not yet rotated
+func ReloadMasterKey(fingerprint string) error {
+	if err := CanRotateMasterKey(); err != nil {
+		return err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	keyPath := config.Secrets.MasterKeyPath
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("unable to read the master key: %w", err)
+	}
+	masterKey := strings.TrimSpace(string(content))
+	if masterKey == config.Secrets.masterKey {
+		return nil
+	}
+	if GetMasterKeyFingerprint(masterKey) != fingerprint {
+		return fmt.Errorf("the master key read from %q does not match the rotated one", keyPath)
+	}
+	if err := writeMasterKey(getPreviousMasterKeyPath(keyPath), config.Secrets.masterKey); err != nil {
+		return fmt.Errorf("unable to save the previous master key: %w", err)
+	}
+	config.Secrets.setMasterKey(masterKey)
+	return nil
+}
+
+// ForgetPreviousMasterKeys removes the master keys used before a rotation,
+// from memory and from the disk, once all the secrets are encrypted using the
+// current master key
+func ForgetPreviousMasterKeys() error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if len(config.Secrets.previousMasterKeys) == 0 {
+		return nil
+	}
+	if config.Secrets.MasterKeyPath != "" {
+		err := os.Remove(getPreviousMasterKeyPath(config.Secrets.MasterKeyPath))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unable to remove the previous master key: %w", err)
+		}
+	}
+	config.Secrets.previousMasterKeys = nil
+	return nil
+}
+
+// GetCurrentMasterKeyFingerprint returns the fingerprint for the current master key
+func GetCurrentMasterKeyFingerprint() string {
+	c := getConfig()
+	return GetMasterKeyFingerprint(c.Secrets.masterKey)
+}
+
+// GetMasterKeyFingerprint returns the fingerprint for the specified master key
+func GetMasterKeyFingerprint(masterKey string) string {
+	sum := sha256.Sum256([]byte(masterKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Secrets) setMasterKey(masterKey string) {
+	if masterKey == s.masterKey {
+		return
+	}
+	previous := []string{s.masterKey}
+	for _, key := range s.previousMasterKeys {
+		if key != masterKey && key != s.masterKey {
+			previous = append(previous, key)
+		}
+	}
+	s.masterKey = masterKey
+	s.previousMasterKeys = previous
+}
+
+func getPreviousMasterKeyPath(keyPath string) string {
+	return keyPath + ".previous"
+}
+
+func writeMasterKey(name, masterKey string) error {
+	tmpName := name + ".tmp"
+	if err := os.WriteFile(tmpName, []byte(masterKey), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
+
+func getConfig() Configuration {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return config
+}
+
 func (c *Configuration) newSecret(status sdkkms.SecretStatus, payload, key, data string) *Secret {
 	base := BaseSecret{
 		Status:         status,
@@ -179,24 +339,35 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
+	c := getConfig()
 	if baseSecret.isEmpty() {
-		s.provider = config.getSecretProvider(baseSecret)
+		s.provider = c.getSecretProvider(baseSecret)
 		return nil
 	}
 
 	if baseSecret.Status == sdkkms.SecretStatusPlain || baseSecret.Status == sdkkms.SecretStatusRedacted {
-		s.provider = config.getSecretProvider(baseSecret)
+		s.provider = c.getSecretProvider(baseSecret)
 		return nil
 	}
 
+	provider := c.getEncryptedSecretProvider(baseSecret, c.Secrets.masterKey)
+	if provider == nil {
+		logger.Error(logSender, "", "no provider registered for status %q", baseSecret.Status)
+		return ErrInvalidSecret
+	}
+	s.provider = provider
+	return nil
+}
+
+// getEncryptedSecretProvider returns the provider registered for the status of
+// the given encrypted secret, nil if no provider is registered for this status
+func (c *Configuration) getEncryptedSecretProvider(base BaseSecret, masterKey string) SecretProvider {
 	for _, v := range secretProviders {
-		if v.encryptedStatus == baseSecret.Status {
-			s.provider = v.newFn(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
-			return nil
+		if v.encryptedStatus == base.Status {
+			return v.newFn(base, c.Secrets.URL, masterKey)
 		}
 	}
-	logger.Error(logSender, "", "no provider registered for status %q", baseSecret.Status)
-	return ErrInvalidSecret
+	return nil
 }
 
 // IsEqual returns true if all the secrets fields are equal
@@ -407,7 +578,85 @@ func (s *Secret) Decrypt() error {
 	s.Lock()
 	defer s.Unlock()
 
-	return s.provider.Decrypt()
+	return s.decrypt()
+}
+
+// decrypt decrypts the secret using the previous master keys too,
+// if the current one fails
+func (s *Secret) decrypt() error {
+	base := s.getBase()
+	err := s.provider.Decrypt()
+	if err == nil {
+		return nil
+	}
+	if base.Status != sdkkms.SecretStatusSecretBox || base.Mode == 0 {
+		return err
+	}
+	c := getConfig()
+	for _, masterKey := range c.Secrets.previousMasterKeys {
+		if masterKey == "" {
+			continue
+		}
+		provider := c.getEncryptedSecretProvider(base, masterKey)
+		if provider != nil && provider.Decrypt() == nil {
+			s.provider = provider
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *Secret) getBase() BaseSecret {
+	return BaseSecret{
+		Status:         s.provider.GetStatus(),
+		Payload:        s.provider.GetPayload(),
+		Key:            s.provider.GetKey(),
+		AdditionalData: s.provider.GetAdditionalData(),
+		Mode:           s.provider.GetMode(),
+	}
+}
+
+// ReEncrypt decrypts an encrypted secret and encrypts it again using the
+// configured provider and the specified master key. The additional data are
+// preserved. It returns false if the secret is not encrypted or if it is
+// already encrypted using the specified master key, so an interrupted
+// rotation can be safely repeated
+func (s *Secret) ReEncrypt(masterKey string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.provider.IsEncrypted() {
+		return false, nil
+	}
+	c := getConfig()
+	base := s.getBase()
+	if base.Status == sdkkms.SecretStatusSecretBox {
+		// mode 0 means that the secret was encrypted without a master key
+		if masterKey == "" && base.Mode == 0 {
+			return false, nil
+		}
+		if masterKey != "" && base.Mode != 0 {
+			rotated := c.getEncryptedSecretProvider(base, masterKey)
+			if rotated != nil && rotated.Decrypt() == nil {
+				return false, nil
+			}
+		}
+	}
+	current := &Secret{provider: s.provider.Clone()}
+	if err := current.decrypt(); err != nil {
+		return false, err
+	}
+	c.Secrets.masterKey = masterKey
+	provider := c.getSecretProvider(BaseSecret{
+		Status:         sdkkms.SecretStatusPlain,
+		Payload:        current.provider.GetPayload(),
+		AdditionalData: base.AdditionalData,
+	})
+	if err := provider.Encrypt(); err != nil {
+		return false, err
+	}
+	s.provider = provider
+	return true, nil
 }
 
 // TryDecrypt decrypts a Secret object if encrypted.
@@ -417,7 +666,7 @@ func (s *Secret) TryDecrypt() error {
 	defer s.Unlock()
 
 	if s.provider.IsEncrypted() {
-		return s.provider.Decrypt()
+		return s.decrypt()
 	}
 	return nil
 }
@@ -48,6 +48,31 @@ var (
 		Help: "Availability for the configured data provider, 1 means OK, 0 KO",
 	})
 
+	// dataproviderReadReplicas is the metric that reports the number of configured read replicas
+	dataproviderReadReplicas = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_read_replicas",
+		Help: "The number of configured read replicas for the data provider",
+	})
+
+	// dataproviderReadReplicasAvailable is the metric that reports the number of available read replicas
+	dataproviderReadReplicasAvailable = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sftpgo_dataprovider_read_replicas_available",
+		Help: "The number of available read replicas for the data provider",
+	})
+
+	// totalDataproviderReplicaReads is the metric that reports the total number of queries served from read replicas
+	totalDataproviderReplicaReads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_replica_reads_total",
+		Help: "The total number of queries served from the data provider read replicas",
+	})
+
+	// totalDataproviderReplicaFallbacks is the metric that reports the total number of read replica
+	// queries retried on the primary database
+	totalDataproviderReplicaFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_replica_fallbacks_total",
+		Help: "The total number of read replica queries retried on the primary database",
+	})
+
 	// activeConnections is the metric that reports the total number of active connections
 	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "sftpgo_active_connections",
@@ -638,6 +663,138 @@ var (
 		Name: "sftpgo_httpfs_download_size",
 		Help: "The total HTTPFs download size as bytes, partial downloads are included",
 	})
+
+	// totalWebDAVFsUploads is the metric that reports the total number of successful WebDAVFs uploads
+	totalWebDAVFsUploads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_uploads_total",
+		Help: "The total number of successful WebDAVFs uploads",
+	})
+
+	// totalWebDAVFsDownloads is the metric that reports the total number of successful WebDAVFs downloads
+	totalWebDAVFsDownloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_downloads_total",
+		Help: "The total number of successful WebDAVFs downloads",
+	})
+
+	// totalWebDAVFsUploadErrors is the metric that reports the total number of WebDAVFs upload errors
+	totalWebDAVFsUploadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_upload_errors_total",
+		Help: "The total number of WebDAVFs upload errors",
+	})
+
+	// totalWebDAVFsDownloadErrors is the metric that reports the total number of WebDAVFs download errors
+	totalWebDAVFsDownloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_download_errors_total",
+		Help: "The total number of WebDAVFs download errors",
+	})
+
+	// totalWebDAVFsUploadSize is the metric that reports the total WebDAVFs uploads size as bytes
+	totalWebDAVFsUploadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_upload_size",
+		Help: "The total WebDAVFs upload size as bytes, partial uploads are included",
+	})
+
+	// totalWebDAVFsDownloadSize is the metric that reports the total WebDAVFs downloads size as bytes
+	totalWebDAVFsDownloadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_webdavfs_download_size",
+		Help: "The total WebDAVFs download size as bytes, partial downloads are included",
+	})
+
+	// totalFTPFsUploads is the metric that reports the total number of successful FTPFs uploads
+	totalFTPFsUploads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_uploads_total",
+		Help: "The total number of successful FTPFs uploads",
+	})
+
+	// totalFTPFsDownloads is the metric that reports the total number of successful FTPFs downloads
+	totalFTPFsDownloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_downloads_total",
+		Help: "The total number of successful FTPFs downloads",
+	})
+
+	// totalFTPFsUploadErrors is the metric that reports the total number of FTPFs upload errors
+	totalFTPFsUploadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_upload_errors_total",
+		Help: "The total number of FTPFs upload errors",
+	})
+
+	// totalFTPFsDownloadErrors is the metric that reports the total number of FTPFs download errors
+	totalFTPFsDownloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_download_errors_total",
+		Help: "The total number of FTPFs download errors",
+	})
+
+	// totalFTPFsUploadSize is the metric that reports the total FTPFs uploads size as bytes
+	totalFTPFsUploadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_upload_size",
+		Help: "The total FTPFs upload size as bytes, partial uploads are included",
+	})
+
+	// totalFTPFsDownloadSize is the metric that reports the total FTPFs downloads size as bytes
+	totalFTPFsDownloadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_ftpfs_download_size",
+		Help: "The total FTPFs download size as bytes, partial downloads are included",
+	})
+
+	// totalSMBFsUploads is the metric that reports the total number of successful SMBFs uploads
+	totalSMBFsUploads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_uploads_total",
+		Help: "The total number of successful SMBFs uploads",
+	})
+
+	// totalSMBFsDownloads is the metric that reports the total number of successful SMBFs downloads
+	totalSMBFsDownloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_downloads_total",
+		Help: "The total number of successful SMBFs downloads",
+	})
+
+	// totalSMBFsUploadErrors is the metric that reports the total number of SMBFs upload errors
+	totalSMBFsUploadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_upload_errors_total",
+		Help: "The total number of SMBFs upload errors",
+	})
+
+	// totalSMBFsDownloadErrors is the metric that reports the total number of SMBFs download errors
+	totalSMBFsDownloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_download_errors_total",
+		Help: "The total number of SMBFs download errors",
+	})
+
+	// totalSMBFsUploadSize is the metric that reports the total SMBFs uploads size as bytes
+	totalSMBFsUploadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_upload_size",
+		Help: "The total SMBFs upload size as bytes, partial uploads are included",
+	})
+
+	// totalSMBFsDownloadSize is the metric that reports the total SMBFs downloads size as bytes
+	totalSMBFsDownloadSize = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_smbfs_download_size",
+		Help: "The total SMBFs download size as bytes, partial downloads are included",
+	})
+
+	// totalMetadataCacheStatHits is the metric that reports the total stat requests served from the metadata cache
+	totalMetadataCacheStatHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_metadata_cache_stat_hits_total",
+		Help: "The total number of stat requests served from the cloud storage metadata cache",
+	})
+
+	// totalMetadataCacheStatMisses is the metric that reports the total stat requests not found in the metadata cache
+	totalMetadataCacheStatMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_metadata_cache_stat_misses_total",
+		Help: "The total number of stat requests not found in the cloud storage metadata cache",
+	})
+
+	// totalMetadataCacheListingHits is the metric that reports the total directory listings served from the metadata cache
+	totalMetadataCacheListingHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_metadata_cache_listing_hits_total",
+		Help: "The total number of directory listings served from the cloud storage metadata cache",
+	})
+
+	// totalMetadataCacheListingMisses is the metric that reports the total directory listings not found in the metadata cache
+	totalMetadataCacheListingMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sftpgo_metadata_cache_listing_misses_total",
+		Help: "The total number of directory listings not found in the cloud storage metadata cache",
+	})
 )
 
 // AddMetricsEndpoint publishes metrics to the specified endpoint
@@ -769,6 +926,24 @@ func GCSCopyObjectCompleted(err error) {
 	}
 }
 
+// MetadataCacheStatCompleted updates metrics after a metadata cache lookup for a stat request
+func MetadataCacheStatCompleted(hit bool) {
+	if hit {
+		totalMetadataCacheStatHits.Inc()
+	} else {
+		totalMetadataCacheStatMisses.Inc()
+	}
+}
+
+// MetadataCacheListingCompleted updates metrics after a metadata cache lookup for a directory listing
+func MetadataCacheListingCompleted(hit bool) {
+	if hit {
+		totalMetadataCacheListingHits.Inc()
+	} else {
+		totalMetadataCacheListingMisses.Inc()
+	}
+}
+
 // GCSDeleteObjectCompleted updates metrics after a GCS delete object request terminates
 func GCSDeleteObjectCompleted(err error) {
 	if err == nil {
@@ -890,6 +1065,69 @@ func HTTPFsTransferCompleted(bytes int64, transferKind int, err error) {
 	}
 }
 
+// WebDAVFsTransferCompleted updates metrics after a WebDAVFs upload or a download
+func WebDAVFsTransferCompleted(bytes int64, transferKind int, err error) {
+	if transferKind == 0 {
+		// upload
+		if err == nil {
+			totalWebDAVFsUploads.Inc()
+		} else {
+			totalWebDAVFsUploadErrors.Inc()
+		}
+		totalWebDAVFsUploadSize.Add(float64(bytes))
+	} else {
+		// download
+		if err == nil {
+			totalWebDAVFsDownloads.Inc()
+		} else {
+			totalWebDAVFsDownloadErrors.Inc()
+		}
+		totalWebDAVFsDownloadSize.Add(float64(bytes))
+	}
+}
+
+// FTPFsTransferCompleted updates metrics after an FTPFs upload or a download
+func FTPFsTransferCompleted(bytes int64, transferKind int, err error) {
+	if transferKind == 0 {
+		// upload
+		if err == nil {
+			totalFTPFsUploads.Inc()
+		} else {
+			totalFTPFsUploadErrors.Inc()
+		}
+		totalFTPFsUploadSize.Add(float64(bytes))
+	} else {
+		// download
+		if err == nil {
+			totalFTPFsDownloads.Inc()
+		} else {
+			totalFTPFsDownloadErrors.Inc()
+		}
+		totalFTPFsDownloadSize.Add(float64(bytes))
+	}
+}
+
+// SMBFsTransferCompleted updates metrics after an SMBFs upload or a download
+func SMBFsTransferCompleted(bytes int64, transferKind int, err error) {
+	if transferKind == 0 {
+		// upload
+		if err == nil {
+			totalSMBFsUploads.Inc()
+		} else {
+			totalSMBFsUploadErrors.Inc()
+		}
+		totalSMBFsUploadSize.Add(float64(bytes))
+	} else {
+		// download
+		if err == nil {
+			totalSMBFsDownloads.Inc()
+		} else {
+			totalSMBFsDownloadErrors.Inc()
+		}
+		totalSMBFsDownloadSize.Add(float64(bytes))
+	}
+}
+
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(err error) {
 	if err == nil {
@@ -908,6 +1146,21 @@ func UpdateDataProviderAvailability(err error) {
 	}
 }
 
+// UpdateDataProviderReadReplicas updates the metrics for the data provider read replicas
+func UpdateDataProviderReadReplicas(total, available int) {
+	dataproviderReadReplicas.Set(float64(total))
+	dataproviderReadReplicasAvailable.Set(float64(available))
+}
+
+// AddDataProviderReplicaRead increments the metrics for queries sent to the read replicas
+func AddDataProviderReplicaRead(fallback bool) {
+	if fallback {
+		totalDataproviderReplicaFallbacks.Inc()
+	} else {
+		totalDataproviderReplicaReads.Inc()
+	}
+}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {
 	totalLoginAttempts.Inc()
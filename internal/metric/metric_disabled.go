@@ -43,6 +43,12 @@ func GCSListObjectsCompleted(_ error) {}
 // GCSCopyObjectCompleted updates metrics after a GCS copy object request terminates
 func GCSCopyObjectCompleted(_ error) {}
 
+// MetadataCacheStatCompleted updates metrics after a metadata cache lookup for a stat request
+func MetadataCacheStatCompleted(_ bool) {}
+
+// MetadataCacheListingCompleted updates metrics after a metadata cache lookup for a directory listing
+func MetadataCacheListingCompleted(_ bool) {}
+
 // GCSDeleteObjectCompleted updates metrics after a GCS delete object request terminates
 func GCSDeleteObjectCompleted(_ error) {}
 
@@ -52,12 +58,27 @@ func GCSHeadBucketCompleted(_ error) {}
 // HTTPFsTransferCompleted updates metrics after an HTTPFs upload or a download
 func HTTPFsTransferCompleted(_ int64, _ int, _ error) {}
 
+// WebDAVFsTransferCompleted updates metrics after a WebDAVFs upload or a download
+func WebDAVFsTransferCompleted(_ int64, _ int, _ error) {}
+
+// FTPFsTransferCompleted updates metrics after an FTPFs upload or a download
+func FTPFsTransferCompleted(_ int64, _ int, _ error) {}
+
+// SMBFsTransferCompleted updates metrics after an SMBFs upload or a download
+func SMBFsTransferCompleted(_ int64, _ int, _ error) {}
+
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(_ error) {}
 
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(_ error) {}
 
+// UpdateDataProviderReadReplicas updates the metrics for the data provider read replicas
+func UpdateDataProviderReadReplicas(_, _ int) {}
+
+// AddDataProviderReplicaRead increments the metrics for queries sent to the read replicas
+func AddDataProviderReplicaRead(_ bool) {}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(_ string) {}
 
@@ -0,0 +1,233 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package radius provides a RADIUS client to authenticate users against
+// one or more RADIUS servers
+package radius
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// Supported authentication types
+const (
+	AuthTypePAP  = "pap"
+	AuthTypeCHAP = "chap"
+)
+
+const (
+	logSender      = "radius"
+	defaultPort    = "1812"
+	defaultTimeout = 3 * time.Second
+)
+
+// Result defines the outcome of an authentication request
+type Result int
+
+// Supported authentication results
+const (
+	ResultReject Result = iota
+	ResultAccept
+	ResultChallenge
+)
+
+// ErrNoServerAvailable is returned if no configured server replied
+var ErrNoServerAvailable = errors.New("radius: no server available")
+
+// Config defines the RADIUS client configuration
+type Config struct {
+	// Servers to query, in order, as host:port. If the port is omitted 1812 is used.
+	// The next server is queried only if the previous one does not reply
+	Servers []string
+	// Shared secret
+	Secret string
+	// Authentication type, pap or chap
+	AuthType string
+	// Maximum time to wait for a reply from each server
+	Timeout time.Duration
+	// Number of retransmissions, for each server, before giving up
+	Retries int
+	// NAS-Identifier attribute to send, if not empty
+	NASIdentifier string
+}
+
+// Response defines the response from a RADIUS server
+type Response struct {
+	Result Result
+	// Reply-Message attributes, if any, joined using a new line
+	Message string
+	// State attribute, it must be sent back to reply to an Access-Challenge
+	State []byte
+}
+
+// Client is a RADIUS client that supports multiple servers with failover
+type Client struct {
+	servers       []string
+	secret        []byte
+	authType      string
+	timeout       time.Duration
+	retries       int
+	nasIdentifier string
+}
+
+// NewClient returns a new RADIUS client from the given configuration
+func NewClient(c Config) (*Client, error) {
+	if len(c.Servers) == 0 {
+		return nil, errors.New("radius: at least a server is required")
+	}
+	if c.Secret == "" {
+		return nil, errors.New("radius: the shared secret is mandatory")
+	}
+	authType := strings.ToLower(c.AuthType)
+	if authType == "" {
+		authType = AuthTypePAP
+	}
+	if authType != AuthTypePAP && authType != AuthTypeCHAP {
+		return nil, fmt.Errorf("radius: unsupported authentication type %q", c.AuthType)
+	}
+	if c.Retries < 0 {
+		return nil, fmt.Errorf("radius: invalid retries %d", c.Retries)
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := &Client{
+		secret:        []byte(c.Secret),
+		authType:      authType,
+		timeout:       timeout,
+		retries:       c.Retries,
+		nasIdentifier: c.NASIdentifier,
+	}
+	for _, server := range c.Servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, defaultPort)
+		}
+		client.servers = append(client.servers, server)
+	}
+	if len(client.servers) == 0 {
+		return nil, errors.New("radius: at least a server is required")
+	}
+	return client, nil
+}
+
+// Authenticate sends an Access-Request for the specified credentials.
+// To reply to an Access-Challenge set the state returned within the previous response.
+// Servers are tried in order, the first server that replies is authoritative
+func (c *Client) Authenticate(ctx context.Context, username, password string, state []byte) (Response, error) {
+	packet, err := c.newAccessRequest(username, password, state)
+	if err != nil {
+		return Response{}, err
+	}
+	exchanger := &radius.Client{
+		Retry:           c.timeout / time.Duration(c.retries+1),
+		MaxPacketErrors: 10,
+	}
+	for _, server := range c.servers {
+		reqCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		reply, err := exchanger.Exchange(reqCtx, packet, server)
+		cancel()
+		if err != nil {
+			logger.Debug(logSender, "", "unable to authenticate user %q using server %q: %v", username, server, err)
+			if ctx.Err() != nil {
+				return Response{}, ctx.Err()
+			}
+			continue
+		}
+		logger.Debug(logSender, "", "authentication reply for user %q from server %q: %v", username, server, reply.Code)
+		return getResponse(reply), nil
+	}
+	return Response{}, ErrNoServerAvailable
+}
+
+func (c *Client) newAccessRequest(username, password string, state []byte) (*radius.Packet, error) {
+	packet := radius.New(radius.CodeAccessRequest, c.secret)
+	if err := rfc2865.UserName_SetString(packet, username); err != nil {
+		return nil, err
+	}
+	switch c.authType {
+	case AuthTypeCHAP:
+		if err := setCHAPPassword(packet, password); err != nil {
+			return nil, err
+		}
+	default:
+		if err := rfc2865.UserPassword_SetString(packet, password); err != nil {
+			return nil, err
+		}
+	}
+	if c.nasIdentifier != "" {
+		if err := rfc2865.NASIdentifier_SetString(packet, c.nasIdentifier); err != nil {
+			return nil, err
+		}
+	}
+	if len(state) > 0 {
+		if err := rfc2865.State_Set(packet, state); err != nil {
+			return nil, err
+		}
+	}
+	return packet, nil
+}
+
+func setCHAPPassword(packet *radius.Packet, password string) error {
+	challenge := make([]byte, 16)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	ident := make([]byte, 1)
+	if _, err := rand.Read(ident); err != nil {
+		return err
+	}
+	// RFC 1994, the response is MD5(identifier + secret + challenge)
+	h := md5.New()
+	h.Write(ident)
+	h.Write([]byte(password))
+	h.Write(challenge)
+	if err := rfc2865.CHAPPassword_Set(packet, append(ident, h.Sum(nil)...)); err != nil {
+		return err
+	}
+	return rfc2865.CHAPChallenge_Set(packet, challenge)
+}
+
+func getResponse(reply *radius.Packet) Response {
+	var resp Response
+	switch reply.Code {
+	case radius.CodeAccessAccept:
+		resp.Result = ResultAccept
+	case radius.CodeAccessChallenge:
+		resp.Result = ResultChallenge
+		resp.State = rfc2865.State_Get(reply)
+	default:
+		resp.Result = ResultReject
+	}
+	if messages, err := rfc2865.ReplyMessage_GetStrings(reply); err == nil {
+		resp.Message = strings.Join(messages, "\n")
+	}
+	return resp
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package radius
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const (
+	testSecret   = "radius secret"
+	testUsername = "radius_user"
+	testPassword = "radius_pwd"
+	testOTP      = "123456"
+	testState    = "otp_state"
+)
+
+func checkTestPassword(r *radius.Request) bool {
+	if chapPwd := rfc2865.CHAPPassword_Get(r.Packet); len(chapPwd) > 0 {
+		h := md5.New()
+		h.Write(chapPwd[:1])
+		if state := rfc2865.State_Get(r.Packet); len(state) > 0 {
+			h.Write([]byte(testOTP))
+		} else {
+			h.Write([]byte(testPassword))
+		}
+		h.Write(rfc2865.CHAPChallenge_Get(r.Packet))
+		return bytes.Equal(h.Sum(nil), chapPwd[1:])
+	}
+	password := rfc2865.UserPassword_GetString(r.Packet)
+	if state := rfc2865.State_Get(r.Packet); len(state) > 0 {
+		return password == testOTP
+	}
+	return password == testPassword
+}
+
+func startTestServer(t *testing.T) (*radius.PacketServer, string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(testSecret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			if rfc2865.UserName_GetString(r.Packet) != testUsername || !checkTestPassword(r) {
+				w.Write(r.Response(radius.CodeAccessReject)) //nolint:errcheck
+				return
+			}
+			if rfc2865.NASIdentifier_GetString(r.Packet) == "otp" && len(rfc2865.State_Get(r.Packet)) == 0 {
+				resp := r.Response(radius.CodeAccessChallenge)
+				rfc2865.State_SetString(resp, testState)           //nolint:errcheck
+				rfc2865.ReplyMessage_SetString(resp, "Token code") //nolint:errcheck
+				w.Write(resp)                                      //nolint:errcheck
+				return
+			}
+			w.Write(r.Response(radius.CodeAccessAccept)) //nolint:errcheck
+		}),
+	}
+	go func() {
+		server.Serve(conn) //nolint:errcheck
+	}()
+	return server, conn.LocalAddr().String()
+}
+
+func getUnusedAddress(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	err = conn.Close()
+	require.NoError(t, err)
+	return addr
+}
+
+func TestClientConfig(t *testing.T) {
+	_, err := NewClient(Config{})
+	assert.Error(t, err)
+	_, err = NewClient(Config{Servers: []string{" "}, Secret: testSecret})
+	assert.Error(t, err)
+	_, err = NewClient(Config{Servers: []string{"127.0.0.1"}})
+	assert.Error(t, err)
+	_, err = NewClient(Config{Servers: []string{"127.0.0.1"}, Secret: testSecret, AuthType: "mschap"})
+	assert.Error(t, err)
+	_, err = NewClient(Config{Servers: []string{"127.0.0.1"}, Secret: testSecret, Retries: -1})
+	assert.Error(t, err)
+	c, err := NewClient(Config{Servers: []string{"127.0.0.1", "[::1]:1645"}, Secret: testSecret})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:1812", "[::1]:1645"}, c.servers)
+	assert.Equal(t, AuthTypePAP, c.authType)
+	assert.Equal(t, defaultTimeout, c.timeout)
+}
+
+func TestAuthenticate(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Shutdown(context.Background()) //nolint:errcheck
+
+	for _, authType := range []string{AuthTypePAP, AuthTypeCHAP} {
+		c, err := NewClient(Config{
+			Servers:  []string{addr},
+			Secret:   testSecret,
+			AuthType: authType,
+			Timeout:  time.Second,
+		})
+		require.NoError(t, err)
+		resp, err := c.Authenticate(context.Background(), testUsername, testPassword, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultAccept, resp.Result)
+		resp, err = c.Authenticate(context.Background(), testUsername, "wrong", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultReject, resp.Result)
+		resp, err = c.Authenticate(context.Background(), "missing user", testPassword, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultReject, resp.Result)
+	}
+	// wrong secret, the reply cannot be verified
+	c, err := NewClient(Config{
+		Servers: []string{addr},
+		Secret:  "wrong secret",
+		Timeout: 500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	_, err = c.Authenticate(context.Background(), testUsername, testPassword, nil)
+	assert.ErrorIs(t, err, ErrNoServerAvailable)
+}
+
+func TestChallenge(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Shutdown(context.Background()) //nolint:errcheck
+
+	for _, authType := range []string{AuthTypePAP, AuthTypeCHAP} {
+		c, err := NewClient(Config{
+			Servers:       []string{addr},
+			Secret:        testSecret,
+			AuthType:      authType,
+			NASIdentifier: "otp",
+		})
+		require.NoError(t, err)
+		resp, err := c.Authenticate(context.Background(), testUsername, testPassword, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultChallenge, resp.Result)
+		assert.Equal(t, "Token code", resp.Message)
+		assert.Equal(t, []byte(testState), resp.State)
+		challengeResp, err := c.Authenticate(context.Background(), testUsername, "654321", resp.State)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultReject, challengeResp.Result)
+		challengeResp, err = c.Authenticate(context.Background(), testUsername, testOTP, resp.State)
+		assert.NoError(t, err)
+		assert.Equal(t, ResultAccept, challengeResp.Result)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	server, addr := startTestServer(t)
+	defer server.Shutdown(context.Background()) //nolint:errcheck
+
+	c, err := NewClient(Config{
+		Servers: []string{getUnusedAddress(t), addr},
+		Secret:  testSecret,
+		Timeout: 300 * time.Millisecond,
+		Retries: 1,
+	})
+	require.NoError(t, err)
+	resp, err := c.Authenticate(context.Background(), testUsername, testPassword, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ResultAccept, resp.Result)
+
+	c, err = NewClient(Config{
+		Servers: []string{getUnusedAddress(t)},
+		Secret:  testSecret,
+		Timeout: 300 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	_, err = c.Authenticate(context.Background(), testUsername, testPassword, nil)
+	assert.ErrorIs(t, err, ErrNoServerAvailable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Authenticate(ctx, testUsername, testPassword, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
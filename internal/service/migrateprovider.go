@@ -0,0 +1,467 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/httpd"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	migrationStateFileName = "sftpgo_migration_state.json"
+	migrationBatchSize     = 100
+	// step to restore the folders metadata once all the objects referencing them are restored
+	migrationStepFoldersMetadata = "folders_metadata"
+)
+
+// the order matters, the referenced objects must be restored first
+var migrationScopes = []string{dataprovider.DumpScopeConfigs, dataprovider.DumpScopeIPLists, dataprovider.DumpScopeRoles,
+	dataprovider.DumpScopeFolders, dataprovider.DumpScopeGroups, dataprovider.DumpScopeUsers, dataprovider.DumpScopeAdmins,
+	dataprovider.DumpScopeAPIKeys, dataprovider.DumpScopeShares, dataprovider.DumpScopeActions, dataprovider.DumpScopeRules}
+
+// scopes read in pages, the other ones have few objects and are read at once
+var migrationPagedScopes = []string{dataprovider.DumpScopeIPLists, dataprovider.DumpScopeFolders,
+	dataprovider.DumpScopeGroups, dataprovider.DumpScopeUsers, dataprovider.DumpScopeAdmins,
+	dataprovider.DumpScopeAPIKeys, dataprovider.DumpScopeShares}
+
+// ProviderMigration defines the configuration to copy all the objects from a
+// data provider to another one
+type ProviderMigration struct {
+	Source         dataprovider.Config
+	SourceBasePath string
+	Target         dataprovider.Config
+	TargetBasePath string
+	// WorkDir is the directory used to store the source data and the migration progress
+	WorkDir string
+	// Resume allows to continue a previously interrupted migration
+	Resume bool
+	// BatchSize is the maximum number of objects to read and restore at once.
+	// 0 means the default
+	BatchSize int
+}
+
+type providerMigrationState struct {
+	SourceDriver string                               `json:"source_driver"`
+	TargetDriver string                               `json:"target_driver"`
+	Checksums    map[string]dataprovider.DumpChecksum `json:"checksums"`
+	// number of batches saved for each scope
+	Batches map[string]int `json:"batches"`
+	// number of batches restored for each step
+	Restored  map[string]int `json:"restored"`
+	Completed bool           `json:"completed"`
+}
+
+type migrationStep struct {
+	name    string
+	scope   string
+	restore func(batch *dataprovider.BackupData, inputFile string) error
+}
+
+// Run executes the migration. The source data are read in batches and saved within
+// the work dir before starting to copy them, so an interrupted migration can be
+// resumed without reading the source provider again
+func (m *ProviderMigration) Run() error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	state, err := m.loadState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state, err = m.saveSourceData()
+		if err != nil {
+			return err
+		}
+	} else if state.Completed {
+		logger.InfoToConsole("The migration from %q to %q is already completed", state.SourceDriver, state.TargetDriver)
+		return nil
+	}
+	if err := m.initializeTarget(state); err != nil {
+		return err
+	}
+	defer dataprovider.Close() //nolint:errcheck
+
+	for _, step := range m.getSteps() {
+		if err := m.executeStep(step, state); err != nil {
+			return err
+		}
+	}
+	return m.verify(state)
+}
+
+func (m *ProviderMigration) validate() error {
+	if m.Source.Driver == dataprovider.MemoryDataProviderName || m.Target.Driver == dataprovider.MemoryDataProviderName {
+		return errors.New("the memory provider is not supported")
+	}
+	if m.Source.Driver == m.Target.Driver && m.Source.Name == m.Target.Name && m.Source.Host == m.Target.Host &&
+		m.Source.Port == m.Target.Port && m.Source.SQLTablesPrefix == m.Target.SQLTablesPrefix {
+		// relative paths for embedded providers are resolved using the base path
+		isEmbedded := m.Source.Driver == dataprovider.SQLiteDataProviderName || m.Source.Driver == dataprovider.BoltDataProviderName
+		if !isEmbedded || m.SourceBasePath == m.TargetBasePath {
+			return errors.New("source and target data providers cannot be the same")
+		}
+	}
+	if !filepath.IsAbs(m.WorkDir) {
+		return fmt.Errorf("invalid work dir %q, it must be an absolute path", m.WorkDir)
+	}
+	if m.BatchSize <= 0 {
+		m.BatchSize = migrationBatchSize
+	}
+	return os.MkdirAll(m.WorkDir, 0700)
+}
+
+func (m *ProviderMigration) getStatePath() string {
+	return filepath.Join(m.WorkDir, migrationStateFileName)
+}
+
+func (m *ProviderMigration) getBatchPath(scope string, idx int) string {
+	return filepath.Join(m.WorkDir, fmt.Sprintf("sftpgo_migration_%s_%06d.json", scope, idx))
+}
+
+func (m *ProviderMigration) loadState() (*providerMigrationState, error) {
+	content, err := os.ReadFile(m.getStatePath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read the migration state: %w", err)
+	}
+	if !m.Resume {
+		return nil, fmt.Errorf("a previous migration state was found in %q, resume it or remove the work dir content to "+
+			"start a new migration", m.WorkDir)
+	}
+	var state providerMigrationState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("unable to parse the migration state: %w", err)
+	}
+	if state.SourceDriver != m.Source.Driver || state.TargetDriver != m.Target.Driver {
+		return nil, fmt.Errorf("the migration state refers to a migration from %q to %q, cannot resume it",
+			state.SourceDriver, state.TargetDriver)
+	}
+	if state.Restored == nil {
+		state.Restored = make(map[string]int)
+	}
+	logger.InfoToConsole("Resuming the migration from %q to %q", state.SourceDriver, state.TargetDriver)
+	return &state, nil
+}
+
+func (m *ProviderMigration) saveState(state *providerMigrationState) error {
+	return writeMigrationFile(m.getStatePath(), state)
+}
+
+// readData reads the data from the configured provider in batches, one scope at a time
+func (m *ProviderMigration) readData(fn func(scope string, batch *dataprovider.BackupData) error) error {
+	for _, scope := range migrationScopes {
+		if !util.Contains(migrationPagedScopes, scope) {
+			data, err := dataprovider.DumpData([]string{scope})
+			if err != nil {
+				return fmt.Errorf("unable to read %s: %w", scope, err)
+			}
+			if err := fn(scope, &data); err != nil {
+				return err
+			}
+			continue
+		}
+		after := ""
+		for {
+			data, last, err := dataprovider.DumpDataPage(scope, after, m.BatchSize)
+			if err != nil {
+				return fmt.Errorf("unable to read %s: %w", scope, err)
+			}
+			if err := fn(scope, &data); err != nil {
+				return err
+			}
+			if last == "" {
+				break
+			}
+			after = last
+		}
+	}
+	return nil
+}
+
+func (m *ProviderMigration) saveSourceData() (*providerMigrationState, error) {
+	logger.InfoToConsole("Reading data from the source provider %q", m.Source.Driver)
+	if err := dataprovider.OpenDatabase(m.Source, m.SourceBasePath); err != nil {
+		return nil, fmt.Errorf("unable to open the source data provider: %w", err)
+	}
+	defer dataprovider.Close() //nolint:errcheck
+
+	checksums := dataprovider.NewDumpChecksums()
+	batches := make(map[string]int)
+	err := m.readData(func(scope string, batch *dataprovider.BackupData) error {
+		if err := checksums.Add(batch); err != nil {
+			return fmt.Errorf("unable to compute the source data checksums: %w", err)
+		}
+		if getBatchLen(scope, batch) == 0 {
+			return nil
+		}
+		if err := writeMigrationFile(m.getBatchPath(scope, batches[scope]), batch); err != nil {
+			return fmt.Errorf("unable to save the source data: %w", err)
+		}
+		batches[scope]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	state := &providerMigrationState{
+		SourceDriver: m.Source.Driver,
+		TargetDriver: m.Target.Driver,
+		Checksums:    checksums.Get(),
+		Batches:      batches,
+		Restored:     make(map[string]int),
+	}
+	if err := m.saveState(state); err != nil {
+		return nil, fmt.Errorf("unable to save the migration state: %w", err)
+	}
+	for _, scope := range migrationScopes {
+		logger.InfoToConsole("Source %s: %d", scope, state.Checksums[scope].Count)
+	}
+	return state, nil
+}
+
+func (m *ProviderMigration) initializeTarget(state *providerMigrationState) error {
+	logger.InfoToConsole("Initializing the target provider %q", m.Target.Driver)
+	err := dataprovider.InitializeDatabase(m.Target, m.TargetBasePath)
+	if err != nil && !errors.Is(err, dataprovider.ErrNoInitRequired) {
+		return fmt.Errorf("unable to initialize the target data provider: %w", err)
+	}
+	if len(state.Restored) > 0 {
+		return nil
+	}
+	// a new migration requires an empty target provider, otherwise the checksums cannot match
+	for _, scope := range migrationPagedScopes {
+		data, _, err := dataprovider.DumpDataPage(scope, "", 1)
+		if err != nil {
+			return fmt.Errorf("unable to read data from the target provider: %w", err)
+		}
+		if getBatchLen(scope, &data) > 0 {
+			return errors.New("the target data provider is not empty")
+		}
+	}
+	dump, err := dataprovider.DumpData([]string{dataprovider.DumpScopeRoles, dataprovider.DumpScopeActions,
+		dataprovider.DumpScopeRules})
+	if err != nil {
+		return fmt.Errorf("unable to read data from the target provider: %w", err)
+	}
+	if len(dump.Roles) > 0 || len(dump.EventActions) > 0 || len(dump.EventRules) > 0 {
+		return errors.New("the target data provider is not empty")
+	}
+	return nil
+}
+
+func (m *ProviderMigration) executeStep(step migrationStep, state *providerMigrationState) error {
+	count := state.Batches[step.scope]
+	start := state.Restored[step.name]
+	if start > 0 && start >= count {
+		logger.InfoToConsole("Skipping %s, already migrated", step.name)
+		return nil
+	}
+	for idx := start; idx < count; idx++ {
+		inputFile := m.getBatchPath(step.scope, idx)
+		content, err := os.ReadFile(inputFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the source data: %w", err)
+		}
+		batch, err := dataprovider.ParseDumpData(content)
+		if err != nil {
+			return fmt.Errorf("unable to parse the source data from %q: %w", inputFile, err)
+		}
+		if err := step.restore(&batch, inputFile); err != nil {
+			return fmt.Errorf("unable to migrate %s: %w", step.name, err)
+		}
+		state.Restored[step.name] = idx + 1
+		if err := m.saveState(state); err != nil {
+			return fmt.Errorf("unable to save the migration state: %w", err)
+		}
+	}
+	logger.InfoToConsole("Migrated %s: %d", step.name, state.Checksums[step.scope].Count)
+	return nil
+}
+
+func (m *ProviderMigration) verify(state *providerMigrationState) error {
+	checksums := dataprovider.NewDumpChecksums()
+	err := m.readData(func(_ string, batch *dataprovider.BackupData) error {
+		if err := checksums.Add(batch); err != nil {
+			return fmt.Errorf("unable to compute the target data checksums: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	targetChecksums := checksums.Get()
+	var mismatches []string
+	for _, scope := range migrationScopes {
+		source := state.Checksums[scope]
+		target := targetChecksums[scope]
+		if source.Count != target.Count {
+			mismatches = append(mismatches, fmt.Sprintf("%s count %d/%d", scope, target.Count, source.Count))
+		} else if source.Checksum != target.Checksum {
+			mismatches = append(mismatches, fmt.Sprintf("%s checksum", scope))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("verification failed, mismatches: %s", strings.Join(mismatches, ", "))
+	}
+	state.Completed = true
+	if err := m.saveState(state); err != nil {
+		return fmt.Errorf("unable to save the migration state: %w", err)
+	}
+	logger.InfoToConsole("Migration from %q to %q completed and verified", state.SourceDriver, state.TargetDriver)
+	return nil
+}
+
+func (m *ProviderMigration) getSteps() []migrationStep {
+	executor := dataprovider.ActionExecutorSystem
+
+	var steps []migrationStep
+	for _, scope := range migrationScopes {
+		step := migrationStep{name: scope, scope: scope}
+		switch scope {
+		case dataprovider.DumpScopeConfigs:
+			step.restore = func(batch *dataprovider.BackupData, _ string) error {
+				return httpd.RestoreConfigs(batch.Configs, 0, executor, "", "")
+			}
+		case dataprovider.DumpScopeIPLists:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreIPListEntries(batch.IPLists, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{IPLists: batch.IPLists})
+			}
+		case dataprovider.DumpScopeRoles:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreRoles(batch.Roles, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{Roles: batch.Roles})
+			}
+		case dataprovider.DumpScopeFolders:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				return httpd.RestoreFolders(batch.Folders, inputFile, 0, 0, executor, "", "")
+			}
+		case dataprovider.DumpScopeGroups:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreGroups(batch.Groups, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{Groups: batch.Groups})
+			}
+		case dataprovider.DumpScopeUsers:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreUsers(batch.Users, inputFile, 0, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{Users: batch.Users})
+			}
+		case dataprovider.DumpScopeAdmins:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreAdmins(batch.Admins, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{Admins: batch.Admins})
+			}
+		case dataprovider.DumpScopeAPIKeys:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreAPIKeys(batch.APIKeys, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{APIKeys: batch.APIKeys})
+			}
+		case dataprovider.DumpScopeShares:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				return httpd.RestoreShares(batch.Shares, inputFile, 0, executor, "", "")
+			}
+		case dataprovider.DumpScopeActions:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreEventActions(batch.EventActions, inputFile, 0, executor, "", ""); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{EventActions: batch.EventActions})
+			}
+		case dataprovider.DumpScopeRules:
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if err := httpd.RestoreEventRules(batch.EventRules, inputFile, 0, executor, "", "", batch.Version); err != nil {
+					return err
+				}
+				return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{EventRules: batch.EventRules})
+			}
+		}
+		steps = append(steps, step)
+	}
+	// restoring the users and groups relations updates the referenced folders
+	return append(steps, migrationStep{
+		name:  migrationStepFoldersMetadata,
+		scope: dataprovider.DumpScopeFolders,
+		restore: func(batch *dataprovider.BackupData, _ string) error {
+			return dataprovider.RestoreMigratedMetadata(&dataprovider.BackupData{Folders: batch.Folders})
+		},
+	})
+}
+
+// getBatchLen returns the number of objects for the specified scope
+func getBatchLen(scope string, batch *dataprovider.BackupData) int {
+	switch scope {
+	case dataprovider.DumpScopeUsers:
+		return len(batch.Users)
+	case dataprovider.DumpScopeFolders:
+		return len(batch.Folders)
+	case dataprovider.DumpScopeGroups:
+		return len(batch.Groups)
+	case dataprovider.DumpScopeAdmins:
+		return len(batch.Admins)
+	case dataprovider.DumpScopeAPIKeys:
+		return len(batch.APIKeys)
+	case dataprovider.DumpScopeShares:
+		return len(batch.Shares)
+	case dataprovider.DumpScopeActions:
+		return len(batch.EventActions)
+	case dataprovider.DumpScopeRules:
+		return len(batch.EventRules)
+	case dataprovider.DumpScopeRoles:
+		return len(batch.Roles)
+	case dataprovider.DumpScopeIPLists:
+		return len(batch.IPLists)
+	case dataprovider.DumpScopeConfigs:
+		if batch.Configs != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+func writeMigrationFile(name string, data any) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmpName := name + ".tmp"
+	if err := os.WriteFile(tmpName, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
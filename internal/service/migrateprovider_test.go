@@ -0,0 +1,378 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/internal/config"
+	"github.com/drakkan/sftpgo/v2/internal/dataprovider"
+	"github.com/drakkan/sftpgo/v2/internal/vfs"
+)
+
+const (
+	configDir = "../.."
+)
+
+func getMigrationTestProviderConf(t *testing.T, driver, name string) dataprovider.Config {
+	err := config.LoadConfig(configDir, "")
+	require.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.Driver = driver
+	providerConf.Name = name
+	providerConf.CreateDefaultAdmin = false
+	providerConf.Actions.ExecuteOn = nil
+	providerConf.Actions.Hook = ""
+	providerConf.BackupsPath = ""
+	return providerConf
+}
+
+func populateMigrationSource(t *testing.T, conf dataprovider.Config, basePath string) {
+	err := dataprovider.InitializeDatabase(conf, basePath)
+	require.NoError(t, err)
+	defer dataprovider.Close() //nolint:errcheck
+
+	folder := vfs.BaseVirtualFolder{
+		Name:       "migration_folder",
+		MappedPath: filepath.Join(basePath, "folder"),
+	}
+	err = dataprovider.AddFolder(&folder, "", "", "")
+	require.NoError(t, err)
+	err = dataprovider.UpdateVirtualFolderQuota(&folder, 10, 100, true)
+	require.NoError(t, err)
+	action := dataprovider.BaseEventAction{
+		Name: "migration_action",
+		Type: dataprovider.ActionTypeFolderQuotaReset,
+	}
+	err = dataprovider.AddEventAction(&action, "", "", "")
+	require.NoError(t, err)
+	rule := dataprovider.EventRule{
+		Name:    "migration_rule",
+		Status:  1,
+		Trigger: dataprovider.EventTriggerFsEvent,
+		Conditions: dataprovider.EventConditions{
+			FsEvents: []string{"upload"},
+		},
+		Actions: []dataprovider.EventAction{
+			{
+				BaseEventAction: dataprovider.BaseEventAction{
+					Name: action.Name,
+				},
+				Order: 1,
+			},
+		},
+	}
+	err = dataprovider.AddEventRule(&rule, "", "", "")
+	require.NoError(t, err)
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "migration_user",
+			Password: "password",
+			HomeDir:  filepath.Join(basePath, "user"),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {dataprovider.PermAny},
+			},
+		},
+		VirtualFolders: []vfs.VirtualFolder{
+			{
+				BaseVirtualFolder: vfs.BaseVirtualFolder{
+					Name: folder.Name,
+				},
+				VirtualPath: "/vdir",
+			},
+		},
+	}
+	// make sure the relations are added after the objects timestamps
+	time.Sleep(10 * time.Millisecond)
+	err = dataprovider.AddUser(&user, "", "", "")
+	require.NoError(t, err)
+}
+
+func TestMigrateProviderFoldersAndActions(t *testing.T) {
+	drivers := [][]string{
+		{dataprovider.SQLiteDataProviderName, dataprovider.SQLiteDataProviderName},
+		{dataprovider.SQLiteDataProviderName, dataprovider.BoltDataProviderName},
+		{dataprovider.BoltDataProviderName, dataprovider.SQLiteDataProviderName},
+	}
+	for _, pair := range drivers {
+		sourceDir := t.TempDir()
+		targetDir := t.TempDir()
+		source := getMigrationTestProviderConf(t, pair[0], "source.db")
+		target := getMigrationTestProviderConf(t, pair[1], "target.db")
+		populateMigrationSource(t, source, sourceDir)
+
+		migration := ProviderMigration{
+			Source:         source,
+			SourceBasePath: sourceDir,
+			Target:         target,
+			TargetBasePath: targetDir,
+			WorkDir:        t.TempDir(),
+		}
+		err := migration.Run()
+		require.NoError(t, err, "source driver %q, target driver %q", pair[0], pair[1])
+		// a completed migration is not repeated
+		migration.Resume = true
+		err = migration.Run()
+		assert.NoError(t, err)
+
+		err = dataprovider.OpenDatabase(target, targetDir)
+		require.NoError(t, err)
+		folder, err := dataprovider.GetFolderByName("migration_folder")
+		assert.NoError(t, err)
+		assert.Equal(t, 10, folder.UsedQuotaFiles)
+		assert.Equal(t, int64(100), folder.UsedQuotaSize)
+		assert.Greater(t, folder.UpdatedAt, int64(0))
+		action, err := dataprovider.EventActionExists("migration_action")
+		assert.NoError(t, err)
+		assert.Greater(t, action.UpdatedAt, int64(0))
+		err = dataprovider.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func populateMigrationSourceBatches(t *testing.T, conf dataprovider.Config, basePath string, count int) {
+	err := dataprovider.InitializeDatabase(conf, basePath)
+	require.NoError(t, err)
+	defer dataprovider.Close() //nolint:errcheck
+
+	for i := 0; i < count; i++ {
+		folder := vfs.BaseVirtualFolder{
+			Name:       fmt.Sprintf("batch_folder%d", i),
+			MappedPath: filepath.Join(basePath, fmt.Sprintf("folder%d", i)),
+		}
+		err = dataprovider.AddFolder(&folder, "", "", "")
+		require.NoError(t, err)
+		err = dataprovider.UpdateVirtualFolderQuota(&folder, i, int64(i*10), true)
+		require.NoError(t, err)
+		entry := dataprovider.IPListEntry{
+			IPOrNet: fmt.Sprintf("172.16.%d.0/24", i),
+			Type:    dataprovider.IPListTypeAllowList,
+			Mode:    1,
+		}
+		err = dataprovider.AddIPListEntry(&entry, "", "", "")
+		require.NoError(t, err)
+	}
+	// make sure the relations are added after the objects timestamps
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < count; i++ {
+		user := dataprovider.User{
+			BaseUser: sdk.BaseUser{
+				Username: fmt.Sprintf("batch_user%d", i),
+				Password: "password",
+				HomeDir:  filepath.Join(basePath, fmt.Sprintf("user%d", i)),
+				Status:   1,
+				Permissions: map[string][]string{
+					"/": {dataprovider.PermAny},
+				},
+			},
+			VirtualFolders: []vfs.VirtualFolder{
+				{
+					BaseVirtualFolder: vfs.BaseVirtualFolder{
+						Name: fmt.Sprintf("batch_folder%d", i),
+					},
+					VirtualPath: "/vdir",
+				},
+			},
+		}
+		err = dataprovider.AddUser(&user, "", "", "")
+		require.NoError(t, err)
+	}
+}
+
+func TestMigrateProviderBatches(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	workDir := t.TempDir()
+	source := getMigrationTestProviderConf(t, dataprovider.SQLiteDataProviderName, "source.db")
+	target := getMigrationTestProviderConf(t, dataprovider.BoltDataProviderName, "target.db")
+	populateMigrationSourceBatches(t, source, sourceDir, 5)
+
+	migration := ProviderMigration{
+		Source:         source,
+		SourceBasePath: sourceDir,
+		Target:         target,
+		TargetBasePath: targetDir,
+		WorkDir:        workDir,
+		BatchSize:      2,
+	}
+	err := migration.Run()
+	require.NoError(t, err)
+
+	state, err := migration.loadState()
+	assert.Error(t, err)
+	assert.Nil(t, state)
+	migration.Resume = true
+	state, err = migration.loadState()
+	require.NoError(t, err)
+	assert.True(t, state.Completed)
+	for _, scope := range []string{dataprovider.DumpScopeUsers, dataprovider.DumpScopeFolders, dataprovider.DumpScopeIPLists} {
+		assert.Equal(t, 3, state.Batches[scope], scope)
+		assert.Equal(t, 3, state.Restored[scope], scope)
+		assert.Equal(t, 5, state.Checksums[scope].Count, scope)
+		assert.FileExists(t, migration.getBatchPath(scope, 2))
+		assert.NoFileExists(t, migration.getBatchPath(scope, 3))
+	}
+	assert.Equal(t, 3, state.Restored[migrationStepFoldersMetadata])
+	assert.Equal(t, 0, state.Batches[dataprovider.DumpScopeGroups])
+
+	err = dataprovider.OpenDatabase(target, targetDir)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		user, err := dataprovider.UserExists(fmt.Sprintf("batch_user%d", i), "")
+		assert.NoError(t, err)
+		if assert.Len(t, user.VirtualFolders, 1) {
+			assert.Equal(t, fmt.Sprintf("batch_folder%d", i), user.VirtualFolders[0].Name)
+		}
+		folder, err := dataprovider.GetFolderByName(fmt.Sprintf("batch_folder%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, i, folder.UsedQuotaFiles)
+		assert.Equal(t, int64(i*10), folder.UsedQuotaSize)
+		assert.Len(t, folder.Users, 1)
+	}
+	entries, err := dataprovider.GetIPListEntries(dataprovider.IPListTypeAllowList, "", "", dataprovider.OrderASC, 0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+}
+
+func TestMigrateProviderResume(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	source := getMigrationTestProviderConf(t, dataprovider.BoltDataProviderName, "source.db")
+	target := getMigrationTestProviderConf(t, dataprovider.SQLiteDataProviderName, "target.db")
+	populateMigrationSourceBatches(t, source, sourceDir, 3)
+
+	migration := ProviderMigration{
+		Source:         source,
+		SourceBasePath: sourceDir,
+		Target:         target,
+		TargetBasePath: targetDir,
+		WorkDir:        t.TempDir(),
+		BatchSize:      1,
+	}
+	err := migration.validate()
+	require.NoError(t, err)
+	state, err := migration.saveSourceData()
+	require.NoError(t, err)
+	err = migration.initializeTarget(state)
+	require.NoError(t, err)
+	// simulate an interruption after the first users batch
+	for _, step := range migration.getSteps() {
+		if step.name == dataprovider.DumpScopeUsers {
+			restore := step.restore
+			step.restore = func(batch *dataprovider.BackupData, inputFile string) error {
+				if state.Restored[step.name] > 0 {
+					return errors.New("interrupted")
+				}
+				return restore(batch, inputFile)
+			}
+		}
+		err = migration.executeStep(step, state)
+		if err != nil {
+			break
+		}
+	}
+	assert.ErrorContains(t, err, "interrupted")
+	err = dataprovider.Close()
+	require.NoError(t, err)
+	// the target is not empty, a new migration is not allowed
+	err = migration.Run()
+	assert.ErrorContains(t, err, "a previous migration state was found")
+	err = os.Remove(migration.getStatePath())
+	require.NoError(t, err)
+	err = migration.Run()
+	assert.ErrorContains(t, err, "the target data provider is not empty")
+	// restore the saved state and resume the migration
+	err = migration.saveState(state)
+	require.NoError(t, err)
+	migration.Resume = true
+	err = migration.Run()
+	require.NoError(t, err)
+
+	err = dataprovider.OpenDatabase(target, targetDir)
+	require.NoError(t, err)
+	users, err := dataprovider.GetUsers(100, 0, dataprovider.OrderASC, dataprovider.UserSearchFilters{})
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	// resuming using different providers is not allowed
+	migration.Source.Driver = dataprovider.SQLiteDataProviderName
+	err = migration.Run()
+	assert.ErrorContains(t, err, "cannot resume it")
+}
+
+func TestMigrateProviderValidation(t *testing.T) {
+	source := getMigrationTestProviderConf(t, dataprovider.SQLiteDataProviderName, "sftpgo.db")
+	migration := ProviderMigration{
+		Source:         source,
+		SourceBasePath: t.TempDir(),
+		Target:         getMigrationTestProviderConf(t, dataprovider.MemoryDataProviderName, ""),
+		WorkDir:        t.TempDir(),
+	}
+	err := migration.Run()
+	assert.ErrorContains(t, err, "memory provider is not supported")
+	migration.Target = source
+	migration.TargetBasePath = migration.SourceBasePath
+	err = migration.Run()
+	assert.ErrorContains(t, err, "cannot be the same")
+	migration.TargetBasePath = t.TempDir()
+	migration.WorkDir = "relative"
+	err = migration.Run()
+	assert.ErrorContains(t, err, "it must be an absolute path")
+	migration.WorkDir = t.TempDir()
+	err = migration.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, migrationBatchSize, migration.BatchSize)
+}
+
+func TestDumpChecksumsOrder(t *testing.T) {
+	users := []dataprovider.User{
+		{BaseUser: sdk.BaseUser{Username: "user1", HomeDir: "/tmp/user1"}},
+		{BaseUser: sdk.BaseUser{Username: "user2", HomeDir: "/tmp/user2"}},
+		{BaseUser: sdk.BaseUser{Username: "user3", HomeDir: "/tmp/user3"}},
+	}
+	checksums := dataprovider.NewDumpChecksums()
+	err := checksums.Add(&dataprovider.BackupData{Users: users[:2]})
+	require.NoError(t, err)
+	err = checksums.Add(&dataprovider.BackupData{Users: users[2:]})
+	require.NoError(t, err)
+	reversed := dataprovider.NewDumpChecksums()
+	err = reversed.Add(&dataprovider.BackupData{Users: []dataprovider.User{users[2], users[0]}})
+	require.NoError(t, err)
+	err = reversed.Add(&dataprovider.BackupData{Users: users[1:2]})
+	require.NoError(t, err)
+	assert.Equal(t, checksums.Get(), reversed.Get())
+	assert.Equal(t, 3, checksums.Get()[dataprovider.DumpScopeUsers].Count)
+	all, err := dataprovider.GetDumpChecksums(&dataprovider.BackupData{Users: users})
+	require.NoError(t, err)
+	assert.Equal(t, checksums.Get(), all)
+
+	users[1].HomeDir = "/tmp/changed"
+	changed, err := dataprovider.GetDumpChecksums(&dataprovider.BackupData{Users: users})
+	require.NoError(t, err)
+	assert.NotEqual(t, all[dataprovider.DumpScopeUsers].Checksum, changed[dataprovider.DumpScopeUsers].Checksum)
+}
@@ -60,6 +60,7 @@ type Service struct {
 	LoadDataFrom      string
 	LoadDataMode      int
 	LoadDataQuotaScan int
+	LoadDataKey       string
 	Shutdown          chan bool
 	Error             error
 }
@@ -327,6 +328,10 @@ func (s *Service) LoadInitialData() error {
 	if err != nil {
 		return fmt.Errorf("unable to read input file %q: %w", s.LoadDataFrom, err)
 	}
+	content, err = dataprovider.DecodeBackup(content, s.LoadDataKey, httpd.MaxRestoreSize)
+	if err != nil {
+		return fmt.Errorf("unable to decode file to restore %q: %w", s.LoadDataFrom, err)
+	}
 	dump, err := dataprovider.ParseDumpData(content)
 	if err != nil {
 		return fmt.Errorf("unable to parse file to restore %q: %w", s.LoadDataFrom, err)
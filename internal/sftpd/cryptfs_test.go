@@ -495,6 +495,106 @@ func TestSCPRecursiveCryptFs(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSFTPFsEncryption(t *testing.T) {
+	usePubKey := false
+	localUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+	u := getTestSFTPUser(usePubKey)
+	u.QuotaFiles = 100
+	u.HomeDir = filepath.Join(os.TempDir(), u.Username)
+	u.FsConfig.SFTPConfig.Encryption.Passphrase = kms.NewPlainSecret(testPassphrase)
+	for _, bufferSize := range []int64{0, 2} {
+		u.FsConfig.SFTPConfig.BufferSize = bufferSize
+		sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+		assert.NoError(t, err)
+		conn, client, err := getSftpClient(sftpUser, usePubKey)
+		if assert.NoError(t, err) {
+			testFilePath := filepath.Join(homeBasePath, testFileName)
+			testFileSize := int64(200000)
+			encryptedFileSize, err := getEncryptedFileSize(testFileSize)
+			assert.NoError(t, err)
+			err = createTestFile(testFilePath, testFileSize)
+			assert.NoError(t, err)
+			err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+			assert.NoError(t, err)
+			info, err := os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName))
+			if assert.NoError(t, err) {
+				assert.Equal(t, encryptedFileSize, info.Size())
+			}
+			info, err = client.Stat(testFileName)
+			if assert.NoError(t, err) {
+				assert.Equal(t, testFileSize, info.Size())
+			}
+			localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+			err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+			assert.NoError(t, err)
+			initialHash, err := computeHashForFile(sha256.New(), testFilePath)
+			assert.NoError(t, err)
+			downloadedFileHash, err := computeHashForFile(sha256.New(), localDownloadPath)
+			assert.NoError(t, err)
+			assert.Equal(t, initialHash, downloadedFileHash)
+			// resuming uploads is not supported
+			err = appendToTestFile(testFilePath, 65535)
+			assert.NoError(t, err)
+			err = sftpUploadResumeFile(testFilePath, testFileName, testFileSize+65535, false, client)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "SSH_FX_OP_UNSUPPORTED")
+			}
+			// empty files are supported
+			err = createTestFile(testFilePath, 0)
+			assert.NoError(t, err)
+			err = sftpUploadFile(testFilePath, testFileName+"_empty", 0, client)
+			assert.NoError(t, err)
+			err = sftpDownloadFile(testFileName+"_empty", localDownloadPath, 0, client)
+			assert.NoError(t, err)
+			err = client.Rename(testFileName, testFileName+"_renamed")
+			assert.NoError(t, err)
+			result, err := client.ReadDir(".")
+			assert.NoError(t, err)
+			if assert.Len(t, result, 2) {
+				for _, fi := range result {
+					switch fi.Name() {
+					case testFileName + "_renamed":
+						assert.Equal(t, testFileSize, fi.Size())
+					case testFileName + "_empty":
+						assert.Equal(t, int64(0), fi.Size())
+					default:
+						t.Errorf("unexpected file %q", fi.Name())
+					}
+				}
+			}
+			user, _, err := httpdtest.GetUserByUsername(sftpUser.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, user.UsedQuotaFiles)
+			assert.Equal(t, testFileSize, user.UsedQuotaSize)
+			err = client.Remove(testFileName + "_renamed")
+			assert.NoError(t, err)
+			err = client.Remove(testFileName + "_empty")
+			assert.NoError(t, err)
+			user, _, err = httpdtest.GetUserByUsername(sftpUser.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, user.UsedQuotaFiles)
+			assert.Equal(t, int64(0), user.UsedQuotaSize)
+			err = os.Remove(testFilePath)
+			assert.NoError(t, err)
+			err = os.Remove(localDownloadPath)
+			assert.NoError(t, err)
+			conn.Close()
+			client.Close()
+		}
+		_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(sftpUser.GetHomeDir())
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func getEncryptedFileSize(size int64) (int64, error) {
 	encSize, err := sio.EncryptedSize(uint64(size))
 	return int64(encSize) + 33, err
@@ -152,6 +152,13 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 	}
 
 	stat, statErr := fs.Lstat(p)
+	if fs.IsNotExist(statErr) {
+		// interrupted cloud uploads are not visible and are included in the quota,
+		// a new upload resumes or replaces them
+		if info, err := vfs.GetPendingUploadInfo(fs, p); err == nil {
+			stat, statErr = info, nil
+		}
+	}
 	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(request.Filepath)) {
 			return nil, sftp.ErrSSHFxPermissionDenied
@@ -216,16 +223,16 @@ func (c *Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 
 	switch request.Method {
 	case "List":
-		files, err := c.ListDir(request.Filepath)
+		lister, err := c.ListDir(request.Filepath)
 		if err != nil {
 			return nil, err
 		}
 		modTime := time.Unix(0, 0)
 		if request.Filepath != "/" || c.folderPrefix != "" {
-			files = util.PrependFileInfo(files, vfs.NewFileInfo("..", true, 0, modTime, false))
+			lister.Prepend(vfs.NewFileInfo("..", true, 0, modTime, false))
 		}
-		files = util.PrependFileInfo(files, vfs.NewFileInfo(".", true, 0, modTime, false))
-		return listerAt(files), nil
+		lister.Prepend(vfs.NewFileInfo(".", true, 0, modTime, false))
+		return lister, nil
 	case "Stat":
 		if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
 			return nil, sftp.ErrSSHFxPermissionDenied
@@ -475,8 +482,10 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	if isResume {
 		c.Log(logger.LevelDebug, "resuming upload requested, file path %q initial size: %d, has append flag %t",
 			filePath, fileSize, pflags.Append)
-		// enforce min write offset only if the client passed the APPEND flag
-		if pflags.Append {
+		// enforce min write offset only if the client passed the APPEND flag or if
+		// the upload is resumed on a cloud backend, writes before the file size are
+		// not possible in this case
+		if pflags.Append || file == nil {
 			minWriteOffset = fileSize
 		}
 		initialSize = fileSize
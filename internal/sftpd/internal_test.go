@@ -16,6 +16,12 @@ package sftpd
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -44,6 +50,10 @@ import (
 
 const (
 	osWindows = "windows"
+	// generated using "ssh-keygen -k", it revokes, for the CA used in sftpd tests, the serials 10-20, 100,
+	// 200, 202, 204, 206 and the key ID "revoked_key_id". It also revokes the key krlRevokedKey
+	testKRL       = "U1NIS1JMCgAAAAABAAAAAAAAAAAAAAAAatTcqAAAAAAAAAAAAAAAAAAAAAABAAAB6AAAAZcAAAAHc3NoLXJzYQAAAAMBAAEAAAGBAMXl9zBkeLKLGacToiU5kmlmFZeiHraA37Jp0ADQYnnT1IARplUs8M/xLlGwTyZSKRHfDHKdWyHEd6oyGuRL5GU1uFKU5cN02D3jJOur/EXxn8+ApEie95/viTmLtsAjK3NruMRHMUn+6NMTLfnftPmTkRhAnXllAa6/PKdJ2/7qj31KMjiMWmXJA5nZBxhsQCaEebkaBCUiIQUb9GUO0uSw66UpnE5jeo/M/QDJDG1klef/m8bjRpb0tNvDEImpaWCuQVcyoABUJu5TliynCGJeYq3U+yV2JfDbeiWhrhxoIo3WPNsWIa5k1cRTYRvHski+NAI9pRjAuMRuREPEOo3++bBmoG4piK4b0Rp/H6cVJCSvtBhvlv6ZP7/UgUeeZ5EaffzvfWQGq0fu2nML+36yhFf2nYe0kz70xiFuU7Y6pNI8ZOXGKFZSTKJEF6SkCFqIeV3XpOwb4Dds4keuiMZxf7mDqgZqsoYsAxzKQvVf6tmpP33cyjp3Znurjcw5cQAAAAAiAAAADgAAAAAAAAAKAAAAAgf/IAAAAAgAAAAAAAAAZCIAAAANAAAAAAAAAMgAAAABVSMAAAASAAAADnJldm9rZWRfa2V5X2lkAgAAADcAAAAzAAAAC3NzaC1lZDI1NTE5AAAAIH8XD9NdnQ65aUhrarEn2bMVGw+0rv4uiaq9V2y8cOFU"
+	krlRevokedKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIH8XD9NdnQ65aUhrarEn2bMVGw+0rv4uiaq9V2y8cOFU revoked"
 )
 
 var (
@@ -2201,6 +2211,203 @@ func TestLoadRevokedUserCertsFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func appendKRLString(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func getKRLHeader(formatVersion uint32) []byte {
+	b := []byte(krlMagic)
+	b = binary.BigEndian.AppendUint32(b, formatVersion)
+	b = binary.BigEndian.AppendUint64(b, 1) // version
+	b = binary.BigEndian.AppendUint64(b, 0) // generated date
+	b = binary.BigEndian.AppendUint64(b, 0) // flags
+	b = appendKRLString(b, nil)             // reserved
+	return appendKRLString(b, []byte("comment"))
+}
+
+func getTestCertificate(t *testing.T, caKey ssh.PublicKey, serial uint64, keyID string) *ssh.Certificate {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return &ssh.Certificate{
+		Key:          key,
+		Serial:       serial,
+		KeyId:        keyID,
+		SignatureKey: caKey,
+	}
+}
+
+func TestParseKRL(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(testKRL)
+	require.NoError(t, err)
+	require.True(t, isKRL(data))
+	parsed, err := parseKRL(data)
+	require.NoError(t, err)
+	require.Len(t, parsed.certificates, 1)
+	caKey, err := ssh.ParsePublicKey(parsed.certificates[0].caKey)
+	require.NoError(t, err)
+	otherCAKey, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	require.NoError(t, err)
+
+	for _, serial := range []uint64{10, 15, 20, 100, 200, 202, 204, 206} {
+		assert.True(t, parsed.isCertRevoked(getTestCertificate(t, caKey, serial, "")), "serial %d", serial)
+		assert.False(t, parsed.isCertRevoked(getTestCertificate(t, otherCAKey, serial, "")), "serial %d", serial)
+	}
+	for _, serial := range []uint64{0, 1, 9, 21, 99, 101, 201, 203, 207, 1000} {
+		assert.False(t, parsed.isCertRevoked(getTestCertificate(t, caKey, serial, "")), "serial %d", serial)
+	}
+	assert.True(t, parsed.isCertRevoked(getTestCertificate(t, caKey, 0, "revoked_key_id")))
+	assert.False(t, parsed.isCertRevoked(getTestCertificate(t, otherCAKey, 0, "revoked_key_id")))
+	assert.False(t, parsed.isCertRevoked(getTestCertificate(t, caKey, 0, "key_id")))
+	// explicitly revoked key
+	revokedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(krlRevokedKey)) //nolint:dogsled
+	require.NoError(t, err)
+	assert.True(t, parsed.isKeyRevoked(revokedKey))
+	cert := getTestCertificate(t, otherCAKey, 0, "")
+	cert.Key = revokedKey
+	assert.True(t, parsed.isCertRevoked(cert))
+	assert.True(t, parsed.isCertRevoked(getTestCertificate(t, revokedKey, 0, "")))
+	// fingerprints
+	blob := revokedKey.Marshal()
+	sha1Hash := sha1.Sum(blob)
+	sha256Hash := sha256.Sum256(blob)
+	for _, section := range []struct {
+		sectionType byte
+		hash        []byte
+	}{
+		{krlSectionFingerprintSHA1, sha1Hash[:]},
+		{krlSectionFingerprintSHA256, sha256Hash[:]},
+	} {
+		b := append(getKRLHeader(krlFormatVersion), section.sectionType)
+		b = appendKRLString(b, appendKRLString(nil, section.hash))
+		// signatures are ignored
+		b = append(b, krlSectionSignature)
+		b = appendKRLString(b, []byte("signature"))
+		parsed, err = parseKRL(b)
+		require.NoError(t, err)
+		assert.True(t, parsed.isKeyRevoked(revokedKey))
+		assert.False(t, parsed.isKeyRevoked(caKey))
+		// invalid hash length
+		b = append(getKRLHeader(krlFormatVersion), section.sectionType)
+		b = appendKRLString(b, appendKRLString(nil, []byte("hash")))
+		_, err = parseKRL(b)
+		assert.Error(t, err)
+	}
+	// certificates for any CA
+	section := appendKRLString(nil, nil)
+	section = appendKRLString(section, nil)
+	section = append(section, krlSectionCertSerialRange)
+	rangeData := binary.BigEndian.AppendUint64(nil, 5)
+	rangeData = binary.BigEndian.AppendUint64(rangeData, 6)
+	section = appendKRLString(section, rangeData)
+	b := append(getKRLHeader(krlFormatVersion), krlSectionCertificates)
+	b = appendKRLString(b, section)
+	parsed, err = parseKRL(b)
+	require.NoError(t, err)
+	assert.True(t, parsed.isCertRevoked(getTestCertificate(t, caKey, 5, "")))
+	assert.True(t, parsed.isCertRevoked(getTestCertificate(t, otherCAKey, 6, "")))
+	assert.False(t, parsed.isCertRevoked(getTestCertificate(t, caKey, 7, "")))
+}
+
+func TestParseKRLErrors(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(testKRL)
+	require.NoError(t, err)
+	for i := 0; i < len(data)-1; i++ {
+		// truncating at a section boundary results in a valid KRL, we only check that we never panic
+		assert.NotPanics(t, func() {
+			parseKRL(data[:i]) //nolint:errcheck
+		})
+	}
+	for _, size := range []int{10, 20, 30, 40, 50, 300, 500, len(data) - 1} {
+		_, err = parseKRL(data[:size])
+		assert.Error(t, err, "truncated at %d", size)
+	}
+	_, err = parseKRL(getKRLHeader(2))
+	assert.Error(t, err)
+	_, err = parseKRL([]byte("[]"))
+	assert.Error(t, err)
+	// unsupported section
+	b := append(getKRLHeader(krlFormatVersion), 99)
+	b = appendKRLString(b, nil)
+	_, err = parseKRL(b)
+	assert.Error(t, err)
+	// invalid CA key
+	section := appendKRLString(nil, []byte("invalid key"))
+	section = appendKRLString(section, nil)
+	b = append(getKRLHeader(krlFormatVersion), krlSectionCertificates)
+	b = appendKRLString(b, section)
+	_, err = parseKRL(b)
+	assert.Error(t, err)
+	// invalid serial range
+	section = appendKRLString(nil, nil)
+	section = appendKRLString(section, nil)
+	section = append(section, krlSectionCertSerialRange)
+	rangeData := binary.BigEndian.AppendUint64(nil, 6)
+	rangeData = binary.BigEndian.AppendUint64(rangeData, 5)
+	section = appendKRLString(section, rangeData)
+	b = append(getKRLHeader(krlFormatVersion), krlSectionCertificates)
+	b = appendKRLString(b, section)
+	_, err = parseKRL(b)
+	assert.Error(t, err)
+	// negative bitmap
+	section = appendKRLString(nil, nil)
+	section = appendKRLString(section, nil)
+	section = append(section, krlSectionCertSerialBitmap)
+	bitmapData := binary.BigEndian.AppendUint64(nil, 1)
+	bitmapData = appendKRLString(bitmapData, []byte{0x80})
+	section = appendKRLString(section, bitmapData)
+	b = append(getKRLHeader(krlFormatVersion), krlSectionCertificates)
+	b = appendKRLString(b, section)
+	_, err = parseKRL(b)
+	assert.Error(t, err)
+	// unsupported certificate section
+	section = appendKRLString(nil, nil)
+	section = appendKRLString(section, nil)
+	section = append(section, 0x30)
+	section = appendKRLString(section, nil)
+	b = append(getKRLHeader(krlFormatVersion), krlSectionCertificates)
+	b = appendKRLString(b, section)
+	_, err = parseKRL(b)
+	assert.Error(t, err)
+}
+
+func TestLoadRevokedUserCertsKRL(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(testKRL)
+	require.NoError(t, err)
+	r := revokedCertificates{
+		certs:    map[string]bool{},
+		filePath: filepath.Join(os.TempDir(), "test.krl"),
+	}
+	err = os.WriteFile(r.filePath, data, 0644)
+	assert.NoError(t, err)
+	err = r.load()
+	assert.NoError(t, err)
+	require.NotNil(t, r.krl)
+	caKey, err := ssh.ParsePublicKey(r.krl.certificates[0].caKey)
+	require.NoError(t, err)
+	assert.True(t, r.isRevoked(getTestCertificate(t, caKey, 100, "")))
+	assert.False(t, r.isRevoked(getTestCertificate(t, caKey, 101, "")))
+	// an invalid KRL must not replace the loaded one
+	err = os.WriteFile(r.filePath, data[:100], 0644)
+	assert.NoError(t, err)
+	err = r.load()
+	assert.Error(t, err)
+	assert.True(t, r.isRevoked(getTestCertificate(t, caKey, 100, "")))
+	// switch to a JSON list
+	cert := getTestCertificate(t, caKey, 1, "")
+	err = os.WriteFile(r.filePath, []byte(fmt.Sprintf(`["%s"]`, ssh.FingerprintSHA256(cert.Key))), 0644)
+	assert.NoError(t, err)
+	err = r.load()
+	assert.NoError(t, err)
+	assert.Nil(t, r.krl)
+	assert.True(t, r.isRevoked(cert))
+	assert.False(t, r.isRevoked(getTestCertificate(t, caKey, 100, "")))
+	err = os.Remove(r.filePath)
+	assert.NoError(t, err)
+}
+
 func TestMaxUserSessions(t *testing.T) {
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(xid.New().String(), common.ProtocolSFTP, "", "", dataprovider.User{
@@ -0,0 +1,364 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package sftpd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH Key Revocation List, see PROTOCOL.krl in the OpenSSH sources
+const (
+	krlMagic         = "SSHKRL\n\x00"
+	krlFormatVersion = 1
+
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlSectionCertSerialList   = 0x20
+	krlSectionCertSerialRange  = 0x21
+	krlSectionCertSerialBitmap = 0x22
+	krlSectionCertKeyID        = 0x23
+)
+
+var errKRLTruncated = errors.New("krl: truncated data")
+
+type krlSerialRange struct {
+	min uint64
+	max uint64
+}
+
+// krlSerialBitmap defines a serial bitmap, bit N set means serial offset+N is revoked
+type krlSerialBitmap struct {
+	offset uint64
+	bitmap *big.Int
+}
+
+func (b *krlSerialBitmap) contains(serial uint64) bool {
+	if serial < b.offset || serial-b.offset >= uint64(b.bitmap.BitLen()) {
+		return false
+	}
+	return b.bitmap.Bit(int(serial-b.offset)) == 1
+}
+
+// krlCertificates defines the certificates revoked for a CA
+type krlCertificates struct {
+	// marshaled CA key, empty means any CA
+	caKey   []byte
+	serials map[uint64]bool
+	ranges  []krlSerialRange
+	bitmaps []krlSerialBitmap
+	keyIDs  map[string]bool
+}
+
+func (c *krlCertificates) isRevoked(cert *ssh.Certificate) bool {
+	if len(c.caKey) > 0 && !bytes.Equal(c.caKey, cert.SignatureKey.Marshal()) {
+		return false
+	}
+	if c.keyIDs[cert.KeyId] {
+		return true
+	}
+	// zero serial numbers are ignored, it is the default when the CA doesn't specify one
+	if cert.Serial == 0 {
+		return false
+	}
+	if c.serials[cert.Serial] {
+		return true
+	}
+	for _, r := range c.ranges {
+		if cert.Serial >= r.min && cert.Serial <= r.max {
+			return true
+		}
+	}
+	for idx := range c.bitmaps {
+		if c.bitmaps[idx].contains(cert.Serial) {
+			return true
+		}
+	}
+	return false
+}
+
+// krl defines a parsed OpenSSH Key Revocation List.
+// Signatures, if any, are not verified
+type krl struct {
+	version      uint64
+	certificates []*krlCertificates
+	keys         map[string]bool
+	sha1Hashes   map[string]bool
+	sha256Hashes map[string]bool
+}
+
+// isKRL returns true if data looks like a binary KRL
+func isKRL(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(krlMagic))
+}
+
+// isKeyRevoked returns true if the specified plain key is revoked
+func (k *krl) isKeyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	if k.keys[string(blob)] {
+		return true
+	}
+	sha1Hash := sha1.Sum(blob)
+	if k.sha1Hashes[string(sha1Hash[:])] {
+		return true
+	}
+	sha256Hash := sha256.Sum256(blob)
+	return k.sha256Hashes[string(sha256Hash[:])]
+}
+
+// isCertRevoked returns true if the specified certificate, the certified key or the CA key are revoked
+func (k *krl) isCertRevoked(cert *ssh.Certificate) bool {
+	for _, c := range k.certificates {
+		if c.isRevoked(cert) {
+			return true
+		}
+	}
+	return k.isKeyRevoked(cert.Key) || k.isKeyRevoked(cert.SignatureKey)
+}
+
+type krlReader struct {
+	data []byte
+}
+
+func (r *krlReader) len() int {
+	return len(r.data)
+}
+
+func (r *krlReader) readByte() (byte, error) {
+	if len(r.data) < 1 {
+		return 0, errKRLTruncated
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
+
+func (r *krlReader) readUint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errKRLTruncated
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *krlReader) readUint64() (uint64, error) {
+	if len(r.data) < 8 {
+		return 0, errKRLTruncated
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v, nil
+}
+
+func (r *krlReader) readString() ([]byte, error) {
+	length, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(r.data)) < length {
+		return nil, errKRLTruncated
+	}
+	s := r.data[:length]
+	r.data = r.data[length:]
+	return s, nil
+}
+
+func (r *krlReader) readSection() (byte, *krlReader, error) {
+	sectionType, err := r.readByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := r.readString()
+	if err != nil {
+		return 0, nil, err
+	}
+	return sectionType, &krlReader{data: data}, nil
+}
+
+// parseKRL parses a binary OpenSSH Key Revocation List as generated by "ssh-keygen -k"
+func parseKRL(data []byte) (*krl, error) {
+	if !isKRL(data) {
+		return nil, errors.New("krl: invalid magic")
+	}
+	r := &krlReader{data: data[len(krlMagic):]}
+	formatVersion, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if formatVersion != krlFormatVersion {
+		return nil, fmt.Errorf("krl: unsupported format version %d", formatVersion)
+	}
+	result := &krl{
+		keys:         make(map[string]bool),
+		sha1Hashes:   make(map[string]bool),
+		sha256Hashes: make(map[string]bool),
+	}
+	if result.version, err = r.readUint64(); err != nil {
+		return nil, err
+	}
+	// generated date and flags
+	if _, err = r.readUint64(); err != nil {
+		return nil, err
+	}
+	if _, err = r.readUint64(); err != nil {
+		return nil, err
+	}
+	// reserved and comment
+	if _, err = r.readString(); err != nil {
+		return nil, err
+	}
+	if _, err = r.readString(); err != nil {
+		return nil, err
+	}
+	for r.len() > 0 {
+		sectionType, section, err := r.readSection()
+		if err != nil {
+			return nil, err
+		}
+		switch sectionType {
+		case krlSectionCertificates:
+			certs, err := parseKRLCertificates(section)
+			if err != nil {
+				return nil, err
+			}
+			result.certificates = append(result.certificates, certs)
+		case krlSectionExplicitKey:
+			if err := parseKRLStrings(section, result.keys, 0); err != nil {
+				return nil, err
+			}
+		case krlSectionFingerprintSHA1:
+			if err := parseKRLStrings(section, result.sha1Hashes, sha1.Size); err != nil {
+				return nil, err
+			}
+		case krlSectionFingerprintSHA256:
+			if err := parseKRLStrings(section, result.sha256Hashes, sha256.Size); err != nil {
+				return nil, err
+			}
+		case krlSectionSignature:
+			// signatures are always at the end of the KRL and we don't verify them
+			return result, nil
+		default:
+			return nil, fmt.Errorf("krl: unsupported section type %d", sectionType)
+		}
+	}
+	return result, nil
+}
+
+func parseKRLStrings(r *krlReader, values map[string]bool, size int) error {
+	for r.len() > 0 {
+		s, err := r.readString()
+		if err != nil {
+			return err
+		}
+		if size > 0 && len(s) != size {
+			return fmt.Errorf("krl: invalid hash length %d, expected %d", len(s), size)
+		}
+		values[string(s)] = true
+	}
+	return nil
+}
+
+func parseKRLCertificates(r *krlReader) (*krlCertificates, error) {
+	caKey, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+	if len(caKey) > 0 {
+		if _, err := ssh.ParsePublicKey(caKey); err != nil {
+			return nil, fmt.Errorf("krl: invalid CA key: %w", err)
+		}
+	}
+	// reserved
+	if _, err := r.readString(); err != nil {
+		return nil, err
+	}
+	result := &krlCertificates{
+		caKey:   caKey,
+		serials: make(map[uint64]bool),
+		keyIDs:  make(map[string]bool),
+	}
+	for r.len() > 0 {
+		sectionType, section, err := r.readSection()
+		if err != nil {
+			return nil, err
+		}
+		switch sectionType {
+		case krlSectionCertSerialList:
+			for section.len() > 0 {
+				serial, err := section.readUint64()
+				if err != nil {
+					return nil, err
+				}
+				result.serials[serial] = true
+			}
+		case krlSectionCertSerialRange:
+			min, err := section.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			max, err := section.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			if min > max {
+				return nil, fmt.Errorf("krl: invalid serial range %d-%d", min, max)
+			}
+			result.ranges = append(result.ranges, krlSerialRange{min: min, max: max})
+		case krlSectionCertSerialBitmap:
+			if err := result.parseSerialBitmap(section); err != nil {
+				return nil, err
+			}
+		case krlSectionCertKeyID:
+			if err := parseKRLStrings(section, result.keyIDs, 0); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("krl: unsupported certificate section type %d", sectionType)
+		}
+	}
+	return result, nil
+}
+
+func (c *krlCertificates) parseSerialBitmap(r *krlReader) error {
+	offset, err := r.readUint64()
+	if err != nil {
+		return err
+	}
+	// the bitmap is encoded as mpint
+	data, err := r.readString()
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		return errors.New("krl: invalid negative serial bitmap")
+	}
+	c.bitmaps = append(c.bitmaps, krlSerialBitmap{
+		offset: offset,
+		bitmap: new(big.Int).SetBytes(data),
+	})
+	return nil
+}
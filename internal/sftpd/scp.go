@@ -374,6 +374,22 @@ func (c *scpCommand) sendDownloadProtocolMessages(virtualDirPath string, stat os
 	return err
 }
 
+// downloadDirEntries sends the files inside dirPath and returns the directories,
+// appended to dirs, to send after all the files
+func (c *scpCommand) downloadDirEntries(fs vfs.Fs, dirPath string, files []os.FileInfo, dirs []string) ([]string, error) {
+	for _, file := range files {
+		filePath := fs.GetRelativePath(fs.Join(dirPath, file.Name()))
+		if file.Mode().IsRegular() || file.Mode()&os.ModeSymlink != 0 {
+			if err := c.handleDownload(filePath); err != nil {
+				return dirs, err
+			}
+		} else if file.IsDir() {
+			dirs = append(dirs, filePath)
+		}
+	}
+	return dirs, nil
+}
+
 // We send first all the files in the root directory and then the directories.
 // For each directory we recursively call this method again
 func (c *scpCommand) handleRecursiveDownload(fs vfs.Fs, dirPath, virtualPath string, stat os.FileInfo) error {
@@ -384,28 +400,37 @@ func (c *scpCommand) handleRecursiveDownload(fs vfs.Fs, dirPath, virtualPath str
 		if err != nil {
 			return err
 		}
-		files, err := fs.ReadDir(dirPath)
+		lister, err := fs.ReadDir(dirPath)
 		if err != nil {
 			c.sendErrorMessage(fs, err)
 			return err
 		}
-		files = c.connection.User.FilterListDir(files, fs.GetRelativePath(dirPath))
-		var dirs []string
-		for _, file := range files {
-			filePath := fs.GetRelativePath(fs.Join(dirPath, file.Name()))
-			if file.Mode().IsRegular() || file.Mode()&os.ModeSymlink != 0 {
-				err = c.handleDownload(filePath)
-				if err != nil {
-					break
-				}
-			} else if file.IsDir() {
-				dirs = append(dirs, filePath)
-			}
-		}
+		defer lister.Close()
+
+		vdirs := c.connection.User.GetVirtualFoldersInfo(fs.GetRelativePath(dirPath))
+		dirs, err := c.downloadDirEntries(fs, dirPath, vdirs, nil)
 		if err != nil {
 			c.sendErrorMessage(fs, err)
 			return err
 		}
+		for {
+			files, err := lister.Next(vfs.ListerBatchSize)
+			finished := errors.Is(err, io.EOF)
+			if err != nil && !finished {
+				c.sendErrorMessage(fs, err)
+				return err
+			}
+			files = c.connection.User.FilterListDir(files, fs.GetRelativePath(dirPath))
+			dirs, err = c.downloadDirEntries(fs, dirPath, files, dirs)
+			if err != nil {
+				c.sendErrorMessage(fs, err)
+				return err
+			}
+			if finished {
+				lister.Close()
+				break
+			}
+		}
 		for _, dir := range dirs {
 			err = c.handleDownload(dir)
 			if err != nil {
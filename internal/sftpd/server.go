@@ -108,6 +108,10 @@ var (
 		certs: map[string]bool{},
 	}
 
+	radiusFirstFactors = radiusFirstFactorStore{
+		entries: make(map[string]map[string]radiusFirstFactor),
+	}
+
 	sftpAuthError = newAuthenticationError(nil, "")
 )
 
@@ -293,6 +297,9 @@ func (c *Configuration) getServerConfig() *ssh.ServerConfig {
 	if c.PasswordAuthentication {
 		serverConfig.PasswordCallback = func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
 			sp, err := c.validatePasswordCredentials(conn, pass)
+			if err == ssh.ErrPartialSuccess {
+				return sp, err
+			}
 			if err != nil {
 				return nil, newAuthenticationError(fmt.Errorf("could not validate password credentials: %w", err),
 					dataprovider.SSHLoginMethodPassword)
@@ -609,6 +616,8 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
 	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	// the authentication is completed
+	radiusFirstFactors.remove(conn.RemoteAddr(), conn.LocalAddr())
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection from ip %q: %v", ipAddr, err)
 		checkAuthError(ipAddr, err)
@@ -625,6 +634,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	json.Unmarshal([]byte(sconn.Permissions.Extensions["sftpgo_user"]), &user) //nolint:errcheck
 
 	loginType := sconn.Permissions.Extensions["sftpgo_login_method"]
+	keyScope := sconn.Permissions.Extensions["sftpgo_key_scope"]
 	connectionID := hex.EncodeToString(sconn.SessionID())
 
 	defer user.CloseFs() //nolint:errcheck
@@ -673,6 +683,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 				switch req.Type {
 				case "subsystem":
+					if keyScope == dataprovider.PublicKeyScopeSSHCommands {
+						logger.Info(logSender, connID, "subsystem request rejected, the public key used to "+
+							"authenticate user %q is restricted to scope %q", user.Username, keyScope)
+						break
+					}
 					if string(req.Payload[4:]) == "sftp" {
 						ok = true
 						connection := &Connection{
@@ -687,6 +702,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						go c.handleSftpConnection(channel, connection)
 					}
 				case "exec":
+					if keyScope == dataprovider.PublicKeyScopeSFTP {
+						logger.Info(logSender, connID, "exec request rejected, the public key used to "+
+							"authenticate user %q is restricted to scope %q", user.Username, keyScope)
+						break
+					}
 					// protocol will be set later inside processSSHCommand it could be SSH or SCP
 					connection := Connection{
 						BaseConnection: common.NewBaseConnection(connID, "sshd_exec", conn.LocalAddr().String(),
@@ -1116,7 +1136,6 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
-	var keyID string
 	var sshPerm *ssh.Permissions
 	var certPerm *ssh.Permissions
 
@@ -1145,7 +1164,7 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
-		if revokedCertManager.isRevoked(certFingerprint) {
+		if revokedCertManager.isRevoked(cert) {
 			err = fmt.Errorf("ssh: certificate %s is revoked", certFingerprint)
 			user.Username = conn.User()
 			updateLoginMetrics(&user, ipAddr, method, err)
@@ -1158,25 +1177,46 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 		}
 		certPerm = &cert.Permissions
 	}
-	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH, ok); err == nil {
+	var pk dataprovider.PublicKey
+	if user, pk, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH, ok); err == nil {
+		keyID := pk.GetID()
 		if ok {
 			keyID = fmt.Sprintf("%s: ID: %s, serial: %v, CA %s %s", certFingerprint,
 				cert.KeyId, cert.Serial, cert.Type(), ssh.FingerprintSHA256(cert.SignatureKey))
 		}
 		if user.IsPartialAuth(method) {
+			if pk.Scope != "" {
+				// the permissions returned here are not available after the next authentication step
+				err = fmt.Errorf("public key %q with scope %q cannot be used for multi-step authentication",
+					keyID, pk.Scope)
+				user.Username = conn.User()
+				updateLoginMetrics(&user, ipAddr, method, err)
+				return nil, err
+			}
 			logger.Debug(logSender, connectionID, "user %q authenticated with partial success", conn.User())
 			return certPerm, ssh.ErrPartialSuccess
 		}
 		sshPerm, err = loginUser(&user, method, keyID, conn)
-		if err == nil && certPerm != nil {
-			// if we have a SSH user cert we need to merge certificate permissions with our ones
-			// we only set Extensions, so CriticalOptions are always the ones from the certificate
-			sshPerm.CriticalOptions = certPerm.CriticalOptions
-			if certPerm.Extensions != nil {
-				for k, v := range certPerm.Extensions {
-					sshPerm.Extensions[k] = v
-				}
+		if err == nil && user.MustUseRADIUSSecondFactor(common.ProtocolSSH) {
+			if pk.Scope != "" {
+				err = fmt.Errorf("public key %q with scope %q cannot be used with RADIUS second factor authentication",
+					keyID, pk.Scope)
+				user.Username = conn.User()
+				updateLoginMetrics(&user, ipAddr, method, err)
+				return nil, err
 			}
+			logger.Debug(logSender, connectionID, "user %q authenticated with partial success, RADIUS second factor required",
+				conn.User())
+			radiusFirstFactors.add(conn, pubKey, radiusFirstFactor{
+				certPerm: certPerm,
+				pk:       pk,
+				keyID:    keyID,
+				isCert:   ok,
+			})
+			return certPerm, ssh.ErrPartialSuccess
+		}
+		if err == nil {
+			setPublicKeyPermissions(&user, sshPerm, certPerm, &pk, ok)
 		}
 	}
 	user.Username = conn.User()
@@ -1196,6 +1236,11 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
+		if err == nil && user.MustUseRADIUSSecondFactor(common.ProtocolSSH) {
+			logger.Debug(logSender, hex.EncodeToString(conn.SessionID()),
+				"user %q authenticated with partial success, RADIUS second factor required", conn.User())
+			return nil, ssh.ErrPartialSuccess
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
@@ -1212,6 +1257,14 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
 	}
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if len(conn.PartialSuccessMethods()) > 0 {
+		user, err = dataprovider.GetUserWithGroupSettings(conn.User(), "")
+		if err == nil {
+			if firstFactorMethod := user.GetSSHRADIUSFirstFactorMethod(conn.PartialSuccessMethods()); firstFactorMethod != "" {
+				return validateRADIUSSecondFactor(conn, client, &user, firstFactorMethod, ipAddr)
+			}
+		}
+	}
 	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
 		ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
@@ -1221,6 +1274,58 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 	return sshPerm, err
 }
 
+// setPublicKeyPermissions completes the permissions for a user authenticated
+// using a public key or a certificate
+func setPublicKeyPermissions(user *dataprovider.User, sshPerm, certPerm *ssh.Permissions, pk *dataprovider.PublicKey,
+	isCert bool,
+) {
+	sshPerm.Extensions["sftpgo_key_scope"] = pk.Scope
+	if !isCert {
+		dataprovider.UpdatePublicKeyLastUse(user, pk)
+	}
+	if certPerm != nil {
+		// if we have a SSH user cert we need to merge certificate permissions with our ones
+		// we only set Extensions, so CriticalOptions are always the ones from the certificate
+		sshPerm.CriticalOptions = certPerm.CriticalOptions
+		if certPerm.Extensions != nil {
+			for k, v := range certPerm.Extensions {
+				sshPerm.Extensions[k] = v
+			}
+		}
+	}
+}
+
+// validateRADIUSSecondFactor completes a multi-step authentication asking the RADIUS
+// passcode to a user already authenticated using the specified first factor method
+func validateRADIUSSecondFactor(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge,
+	user *dataprovider.User, firstFactorMethod, ipAddr string,
+) (*ssh.Permissions, error) {
+	var sshPerm *ssh.Permissions
+	var firstFactor radiusFirstFactor
+	var err error
+	if firstFactorMethod == dataprovider.SSHLoginMethodPublicKey {
+		// the permissions returned with the partial success are not available here
+		firstFactor, err = radiusFirstFactors.get(conn)
+	}
+	if err == nil {
+		err = dataprovider.CheckRADIUSSecondFactor(user, client)
+	}
+	if err == nil {
+		sshPerm, err = loginUser(user, firstFactorMethod, firstFactor.keyID, conn)
+		if err == nil && firstFactorMethod == dataprovider.SSHLoginMethodPublicKey {
+			setPublicKeyPermissions(user, sshPerm, firstFactor.certPerm, &firstFactor.pk, firstFactor.isCert)
+		}
+	}
+	method := firstFactorMethod
+	if method == dataprovider.SSHLoginMethodPublicKey {
+		// public key errors are not added to the defender in updateLoginMetrics
+		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
+	}
+	user.Username = conn.User()
+	updateLoginMetrics(user, ipAddr, method, err)
+	return sshPerm, err
+}
+
 func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 	metric.AddLoginAttempt(method)
 	if err != nil {
@@ -1240,10 +1345,68 @@ func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 	dataprovider.ExecutePostLoginHook(user, method, ip, common.ProtocolSSH, err)
 }
 
+// radiusFirstFactor defines a public key first factor waiting for the RADIUS
+// second factor
+type radiusFirstFactor struct {
+	certPerm *ssh.Permissions
+	pk       dataprovider.PublicKey
+	keyID    string
+	isCert   bool
+}
+
+// radiusFirstFactorStore keeps the public key first factors, for each connection,
+// until the RADIUS second factor is completed. The SSH library does not return
+// the permissions of a partial success to the next authentication step
+type radiusFirstFactorStore struct {
+	mu      sync.Mutex
+	entries map[string]map[string]radiusFirstFactor
+}
+
+func getRADIUSFirstFactorKey(remoteAddr, localAddr net.Addr) string {
+	return remoteAddr.String() + " " + localAddr.String()
+}
+
+func (s *radiusFirstFactorStore) add(conn ssh.ConnMetadata, pubKey ssh.PublicKey, factor radiusFirstFactor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := getRADIUSFirstFactorKey(conn.RemoteAddr(), conn.LocalAddr())
+	if _, ok := s.entries[key]; !ok {
+		s.entries[key] = make(map[string]radiusFirstFactor)
+	}
+	s.entries[key][string(pubKey.Marshal())] = factor
+}
+
+// get returns the first factor for the specified connection. The SSH library
+// does not report which accepted key was used, if the client had more than one
+// key accepted we cannot know the key to use so an error is returned
+func (s *radiusFirstFactorStore) get(conn ssh.ConnMetadata) (radiusFirstFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	factors := s.entries[getRADIUSFirstFactorKey(conn.RemoteAddr(), conn.LocalAddr())]
+	if len(factors) != 1 {
+		return radiusFirstFactor{}, fmt.Errorf("unable to find the public key used as first factor, accepted keys: %d",
+			len(factors))
+	}
+	for _, factor := range factors {
+		return factor, nil
+	}
+	return radiusFirstFactor{}, nil
+}
+
+func (s *radiusFirstFactorStore) remove(remoteAddr, localAddr net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, getRADIUSFirstFactorKey(remoteAddr, localAddr))
+}
+
 type revokedCertificates struct {
 	filePath string
 	mu       sync.RWMutex
 	certs    map[string]bool
+	krl      *krl
 }
 
 func (r *revokedCertificates) load() error {
@@ -1264,6 +1427,21 @@ func (r *revokedCertificates) load() error {
 	if err != nil {
 		return fmt.Errorf("unable to read revoked user certificate file %q: %w", r.filePath, err)
 	}
+	if isKRL(content) {
+		parsedKRL, err := parseKRL(content)
+		if err != nil {
+			return fmt.Errorf("unable to parse revoked user certificate KRL file %q: %w", r.filePath, err)
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.certs = map[string]bool{}
+		r.krl = parsedKRL
+		logger.Debug(logSender, "", "revoked user certificate KRL file %q loaded, version: %d", r.filePath,
+			parsedKRL.version)
+		return nil
+	}
 	var certs []string
 	err = json.Unmarshal(content, &certs)
 	if err != nil {
@@ -1274,6 +1452,7 @@ func (r *revokedCertificates) load() error {
 	defer r.mu.Unlock()
 
 	r.certs = map[string]bool{}
+	r.krl = nil
 	for _, fp := range certs {
 		r.certs[fp] = true
 	}
@@ -1281,11 +1460,18 @@ func (r *revokedCertificates) load() error {
 	return nil
 }
 
-func (r *revokedCertificates) isRevoked(fp string) bool {
+func (r *revokedCertificates) isRevoked(cert *ssh.Certificate) bool {
+	if dataprovider.IsUserCertRevoked(cert) {
+		return true
+	}
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	return r.certs[fp]
+	if r.certs[ssh.FingerprintSHA256(cert.Key)] {
+		return true
+	}
+	return r.krl != nil && r.krl.isCertRevoked(cert)
 }
 
 // Reload reloads the list of revoked user certificates
@@ -0,0 +1,476 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPatchOperation defines a single RFC 6902 JSON Patch operation
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONDiffEntry defines a difference between two JSON documents
+type JSONDiffEntry struct {
+	// JSON Pointer to the changed value
+	Path string `json:"path"`
+	// Previous value, empty if the value was added
+	OldValue json.RawMessage `json:"old_value,omitempty"`
+	// New value, empty if the value was removed
+	NewValue json.RawMessage `json:"new_value,omitempty"`
+}
+
+// ApplyJSONPatch applies the RFC 6902 JSON Patch operations to the given JSON document
+func ApplyJSONPatch(doc []byte, operations []JSONPatchOperation) ([]byte, error) {
+	root, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	for idx, operation := range operations {
+		root, err = applyJSONPatchOperation(root, operation)
+		if err != nil {
+			return nil, NewValidationError(fmt.Sprintf("patch operation %d (%s %q) failed: %v",
+				idx, operation.Op, operation.Path, err))
+		}
+	}
+	return json.Marshal(root)
+}
+
+// ApplyJSONMergePatch applies the RFC 7386 JSON Merge Patch to the given JSON document
+func ApplyJSONMergePatch(doc, patch []byte) ([]byte, error) {
+	root, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, NewValidationError(fmt.Sprintf("invalid merge patch: %v", err))
+	}
+	return json.Marshal(mergeJSONPatch(root, p))
+}
+
+// IsJSONEqual returns true if the given JSON documents are semantically equal
+func IsJSONEqual(doc1, doc2 []byte) bool {
+	v1, err := decodeJSONValue(doc1)
+	if err != nil {
+		return false
+	}
+	v2, err := decodeJSONValue(doc2)
+	if err != nil {
+		return false
+	}
+	return isJSONValueEqual(v1, v2)
+}
+
+// DiffJSON returns the differences between two JSON documents sorted by path.
+// Objects are compared field by field, arrays and scalar values as a whole
+func DiffJSON(doc1, doc2 []byte) ([]JSONDiffEntry, error) {
+	v1, err := decodeJSONValue(doc1)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := decodeJSONValue(doc2)
+	if err != nil {
+		return nil, err
+	}
+	var entries []JSONDiffEntry
+	if err := diffJSONValues("", v1, v2, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func diffJSONValues(path string, v1, v2 any, entries *[]JSONDiffEntry) error {
+	m1, ok1 := v1.(map[string]any)
+	m2, ok2 := v2.(map[string]any)
+	if ok1 && ok2 {
+		keys := make([]string, 0, len(m1)+len(m2))
+		for key := range m1 {
+			keys = append(keys, key)
+		}
+		for key := range m2 {
+			if _, ok := m1[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+			val1, has1 := m1[key]
+			val2, has2 := m2[key]
+			var err error
+			switch {
+			case !has1:
+				err = addJSONDiffEntry(childPath, nil, val2, false, true, entries)
+			case !has2:
+				err = addJSONDiffEntry(childPath, val1, nil, true, false, entries)
+			default:
+				err = diffJSONValues(childPath, val1, val2, entries)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if isJSONValueEqual(v1, v2) {
+		return nil
+	}
+	return addJSONDiffEntry(path, v1, v2, true, true, entries)
+}
+
+func addJSONDiffEntry(path string, v1, v2 any, hasOld, hasNew bool, entries *[]JSONDiffEntry) error {
+	entry := JSONDiffEntry{
+		Path: path,
+	}
+	if hasOld {
+		data, err := json.Marshal(v1)
+		if err != nil {
+			return err
+		}
+		entry.OldValue = data
+	}
+	if hasNew {
+		data, err := json.Marshal(v2)
+		if err != nil {
+			return err
+		}
+		entry.NewValue = data
+	}
+	*entries = append(*entries, entry)
+	return nil
+}
+
+func decodeJSONValue(data []byte) (any, error) {
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func mergeJSONPatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergeJSONPatch(t[k], v)
+		}
+	}
+	return t
+}
+
+func applyJSONPatchOperation(root any, operation JSONPatchOperation) (any, error) {
+	tokens, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, fmt.Errorf("missing value")
+		}
+		value, err := decodeJSONValue(operation.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value: %w", err)
+		}
+		switch operation.Op {
+		case "add":
+			return addJSONValue(root, tokens, value)
+		case "replace":
+			return replaceJSONValue(root, tokens, value)
+		default:
+			current, err := getJSONValue(root, tokens)
+			if err != nil {
+				return nil, err
+			}
+			if !isJSONValueEqual(current, value) {
+				return nil, fmt.Errorf("test failed")
+			}
+			return root, nil
+		}
+	case "remove":
+		return removeJSONValue(root, tokens)
+	case "move", "copy":
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+		value, err := getJSONValue(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if operation.Op == "copy" {
+			return addJSONValue(root, tokens, copyJSONValue(value))
+		}
+		if operation.From == operation.Path {
+			return root, nil
+		}
+		if strings.HasPrefix(operation.Path, operation.From+"/") {
+			return nil, fmt.Errorf("cannot move a value into one of its children")
+		}
+		root, err = removeJSONValue(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return addJSONValue(root, tokens, value)
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for idx := range tokens {
+		tokens[idx] = strings.ReplaceAll(strings.ReplaceAll(tokens[idx], "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func parseJSONArrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	maxIdx := length - 1
+	if allowEnd {
+		maxIdx = length
+	}
+	if idx > maxIdx {
+		return 0, fmt.Errorf("array index %d out of bounds", idx)
+	}
+	return idx, nil
+}
+
+func getJSONValue(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch container := node.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			node = value
+		case []any:
+			idx, err := parseJSONArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			node = container[idx]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return node, nil
+}
+
+// updateJSONContainer walks the path to the parent container of the last token
+// and replaces it with the one returned by the update function
+func updateJSONContainer(node any, tokens []string, update func(container any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return update(node, tokens[0])
+	}
+	switch container := node.(type) {
+	case map[string]any:
+		child, ok := container[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		updated, err := updateJSONContainer(child, tokens[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[tokens[0]] = updated
+		return container, nil
+	case []any:
+		idx, err := parseJSONArrayIndex(tokens[0], len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateJSONContainer(container[idx], tokens[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[idx] = updated
+		return container, nil
+	default:
+		return nil, fmt.Errorf("path not found")
+	}
+}
+
+func addJSONValue(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateJSONContainer(root, tokens, func(node any, token string) (any, error) {
+		switch container := node.(type) {
+		case map[string]any:
+			container[token] = value
+			return container, nil
+		case []any:
+			idx, err := parseJSONArrayIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[idx+1:], container[idx:])
+			container[idx] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+}
+
+func replaceJSONValue(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateJSONContainer(root, tokens, func(node any, token string) (any, error) {
+		switch container := node.(type) {
+		case map[string]any:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			container[token] = value
+			return container, nil
+		case []any:
+			idx, err := parseJSONArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			container[idx] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+}
+
+func removeJSONValue(root any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return updateJSONContainer(root, tokens, func(node any, token string) (any, error) {
+		switch container := node.(type) {
+		case map[string]any:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			delete(container, token)
+			return container, nil
+		case []any:
+			idx, err := parseJSONArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(container[:idx], container[idx+1:]...), nil
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	})
+}
+
+func copyJSONValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, val := range v {
+			result[key] = copyJSONValue(val)
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, val := range v {
+			result = append(result, copyJSONValue(val))
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func isJSONValueEqual(v1, v2 any) bool {
+	switch val1 := v1.(type) {
+	case map[string]any:
+		val2, ok := v2.(map[string]any)
+		if !ok || len(val1) != len(val2) {
+			return false
+		}
+		for key, value := range val1 {
+			other, ok := val2[key]
+			if !ok || !isJSONValueEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		val2, ok := v2.([]any)
+		if !ok || len(val1) != len(val2) {
+			return false
+		}
+		for idx := range val1 {
+			if !isJSONValueEqual(val1[idx], val2[idx]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		val2, ok := v2.(json.Number)
+		if !ok {
+			return false
+		}
+		n1, ok1 := new(big.Float).SetString(val1.String())
+		n2, ok2 := new(big.Float).SetString(val2.String())
+		if !ok1 || !ok2 {
+			return val1 == val2
+		}
+		return n1.Cmp(n2) == 0
+	default:
+		return v1 == v2
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package util
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func applyTestJSONPatch(t *testing.T, doc, patch string) (string, error) {
+	t.Helper()
+
+	var operations []JSONPatchOperation
+	require.NoError(t, json.Unmarshal([]byte(patch), &operations))
+	result, err := ApplyJSONPatch([]byte(doc), operations)
+	return string(result), err
+}
+
+func TestJSONPatchEscaping(t *testing.T) {
+	doc := `{"a/b":1,"m~n":2,"~1":3}`
+	result, err := applyTestJSONPatch(t, doc, `[
+		{"op":"replace","path":"/a~1b","value":10},
+		{"op":"replace","path":"/m~0n","value":20},
+		{"op":"test","path":"/~01","value":3},
+		{"op":"add","path":"/c~1d~0e","value":4}
+	]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a/b":10,"m~n":20,"~1":3,"c/d~e":4}`, result)
+	// "~01" is "~1" and not "/"
+	_, err = applyTestJSONPatch(t, `{"~1":1}`, `[{"op":"remove","path":"/~01"}]`)
+	assert.NoError(t, err)
+	_, err = applyTestJSONPatch(t, `{"/":1}`, `[{"op":"remove","path":"/~01"}]`)
+	assert.ErrorContains(t, err, "path not found")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"remove","path":"a~1b"}]`)
+	assert.ErrorContains(t, err, "invalid JSON pointer")
+}
+
+func TestJSONPatchArrays(t *testing.T) {
+	doc := `{"list":[1,2,3]}`
+	result, err := applyTestJSONPatch(t, doc, `[
+		{"op":"add","path":"/list/-","value":4},
+		{"op":"add","path":"/list/0","value":0},
+		{"op":"add","path":"/list/-","value":{"key":"val"}}
+	]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"list":[0,1,2,3,4,{"key":"val"}]}`, result)
+	// "-" is only valid for add
+	for _, op := range []string{"replace", "remove", "test"} {
+		_, err = applyTestJSONPatch(t, doc, `[{"op":"`+op+`","path":"/list/-","value":1}]`)
+		assert.ErrorContains(t, err, "invalid array index", op)
+	}
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"add","path":"/list/01","value":1}]`)
+	assert.ErrorContains(t, err, "invalid array index")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"add","path":"/list/4","value":1}]`)
+	assert.ErrorContains(t, err, "out of bounds")
+	result, err = applyTestJSONPatch(t, doc, `[{"op":"add","path":"/list/3","value":4}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"list":[1,2,3,4]}`, result)
+	result, err = applyTestJSONPatch(t, doc, `[{"op":"remove","path":"/list/1"}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"list":[1,3]}`, result)
+}
+
+func TestJSONPatchTest(t *testing.T) {
+	doc := `{"num":1.0,"str":"val","obj":{"a":[1,{"b":true}]}}`
+	_, err := applyTestJSONPatch(t, doc, `[
+		{"op":"test","path":"/num","value":1},
+		{"op":"test","path":"/str","value":"val"},
+		{"op":"test","path":"/obj","value":{"a":[1,{"b":true}]}},
+		{"op":"test","path":"/obj/a/1/b","value":true}
+	]`)
+	assert.NoError(t, err)
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"test","path":"/str","value":"other"}]`)
+	assert.ErrorContains(t, err, "test failed")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"test","path":"/obj/a","value":[{"b":true},1]}]`)
+	assert.ErrorContains(t, err, "test failed")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"test","path":"/missing","value":1}]`)
+	assert.ErrorContains(t, err, "path not found")
+	// a failed test makes the whole patch fail
+	_, err = applyTestJSONPatch(t, doc, `[
+		{"op":"replace","path":"/str","value":"new"},
+		{"op":"test","path":"/num","value":2}
+	]`)
+	assert.ErrorContains(t, err, "patch operation 1")
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+}
+
+func TestJSONPatchMoveCopy(t *testing.T) {
+	doc := `{"a":{"b":{"c":1}},"list":[1,2,3]}`
+	result, err := applyTestJSONPatch(t, doc, `[{"op":"move","from":"/a/b","path":"/d"}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":{},"d":{"c":1},"list":[1,2,3]}`, result)
+	result, err = applyTestJSONPatch(t, doc, `[{"op":"move","from":"/a","path":"/a"}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, doc, result)
+	// a value cannot be moved into one of its children
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"move","from":"/a","path":"/a/b/e"}]`)
+	assert.ErrorContains(t, err, "children")
+	// but it can be moved into a sibling with the same prefix
+	result, err = applyTestJSONPatch(t, `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ab":1}`, result)
+	result, err = applyTestJSONPatch(t, doc, `[{"op":"move","from":"/list/0","path":"/list/-"}]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":{"b":{"c":1}},"list":[2,3,1]}`, result)
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"move","from":"/missing","path":"/d"}]`)
+	assert.ErrorContains(t, err, "path not found")
+	// the copied value is independent from the source
+	result, err = applyTestJSONPatch(t, doc, `[
+		{"op":"copy","from":"/a/b","path":"/list/-"},
+		{"op":"replace","path":"/list/3/c","value":2}
+	]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":{"b":{"c":1}},"list":[1,2,3,{"c":2}]}`, result)
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"copy","from":"a","path":"/d"}]`)
+	assert.ErrorContains(t, err, "invalid from")
+}
+
+func TestJSONPatchNullValues(t *testing.T) {
+	doc := `{"a":1,"b":null}`
+	result, err := applyTestJSONPatch(t, doc, `[
+		{"op":"test","path":"/b","value":null},
+		{"op":"replace","path":"/a","value":null},
+		{"op":"add","path":"/c","value":null}
+	]`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":null,"b":null,"c":null}`, result)
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"test","path":"/a","value":null}]`)
+	assert.ErrorContains(t, err, "test failed")
+	// a missing value is not a null value
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"add","path":"/c"}]`)
+	assert.ErrorContains(t, err, "missing value")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"invalid","path":"/a"}]`)
+	assert.ErrorContains(t, err, "unsupported operation")
+	_, err = applyTestJSONPatch(t, doc, `[{"op":"remove","path":""}]`)
+	assert.ErrorContains(t, err, "whole document")
+}
+
+func TestJSONMergePatch(t *testing.T) {
+	doc := `{"a":1,"b":{"c":2,"d":3},"list":[1,2]}`
+	result, err := ApplyJSONMergePatch([]byte(doc), []byte(`{"a":null,"b":{"c":null,"e":4},"list":[3],"f":{"g":null}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"b":{"d":3,"e":4},"list":[3],"f":{}}`, string(result))
+	// removing a missing key is a no-op
+	result, err = ApplyJSONMergePatch([]byte(doc), []byte(`{"missing":null}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, doc, string(result))
+	// a non object patch replaces the whole document
+	result, err = ApplyJSONMergePatch([]byte(doc), []byte(`[1]`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[1]`, string(result))
+	_, err = ApplyJSONMergePatch([]byte(doc), []byte(`{`))
+	assert.ErrorContains(t, err, "invalid merge patch")
+}
+
+func TestIsJSONEqual(t *testing.T) {
+	assert.True(t, IsJSONEqual([]byte(`{"a":1,"b":[1,2]}`), []byte(`{"b":[1,2.0],"a":1e0}`)))
+	assert.False(t, IsJSONEqual([]byte(`{"a":1}`), []byte(`{"a":"1"}`)))
+	assert.False(t, IsJSONEqual([]byte(`{"a":null}`), []byte(`{}`)))
+	assert.False(t, IsJSONEqual([]byte(`{`), []byte(`{}`)))
+}
+
+func TestDiffJSON(t *testing.T) {
+	doc1 := `{"name":"user","status":1,"filters":{"allowed_ip":["192.168.1.0/24"],"hooks":{"pre_login":false},
+		"a/b~c":1},"permissions":{"/":["*"]},"removed":{"key":"val"},"num":1.0}`
+	doc2 := `{"name":"user","status":0,"filters":{"allowed_ip":["192.168.1.0/24","10.0.0.0/8"],"hooks":{"pre_login":true},
+		"a/b~c":2},"permissions":{"/":["*"]},"added":[1,{"k":null}],"num":1}`
+	entries, err := DiffJSON([]byte(doc1), []byte(doc2))
+	require.NoError(t, err)
+	// nested objects are compared field by field, arrays as a whole
+	expected := []JSONDiffEntry{
+		{Path: "/added", NewValue: json.RawMessage(`[1,{"k":null}]`)},
+		{Path: "/filters/a~1b~0c", OldValue: json.RawMessage(`1`), NewValue: json.RawMessage(`2`)},
+		{Path: "/filters/allowed_ip", OldValue: json.RawMessage(`["192.168.1.0/24"]`),
+			NewValue: json.RawMessage(`["192.168.1.0/24","10.0.0.0/8"]`)},
+		{Path: "/filters/hooks/pre_login", OldValue: json.RawMessage(`false`), NewValue: json.RawMessage(`true`)},
+		{Path: "/removed", OldValue: json.RawMessage(`{"key":"val"}`)},
+		{Path: "/status", OldValue: json.RawMessage(`1`), NewValue: json.RawMessage(`0`)},
+	}
+	assert.Equal(t, expected, entries)
+	// the diff paths can be used to patch the old document
+	var operations []JSONPatchOperation
+	for _, entry := range entries {
+		switch {
+		case entry.NewValue == nil:
+			operations = append(operations, JSONPatchOperation{Op: "remove", Path: entry.Path})
+		default:
+			operations = append(operations, JSONPatchOperation{Op: "add", Path: entry.Path, Value: entry.NewValue})
+		}
+	}
+	patched, err := ApplyJSONPatch([]byte(doc1), operations)
+	require.NoError(t, err)
+	assert.True(t, IsJSONEqual([]byte(doc2), patched))
+	// array order matters, a null value is different from a missing one
+	entries, err = DiffJSON([]byte(`{"a":[1,2],"b":null}`), []byte(`{"a":[2,1]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []JSONDiffEntry{
+		{Path: "/a", OldValue: json.RawMessage(`[1,2]`), NewValue: json.RawMessage(`[2,1]`)},
+		{Path: "/b", OldValue: json.RawMessage(`null`)},
+	}, entries)
+	// documents that are not objects are compared as a whole
+	entries, err = DiffJSON([]byte(`[{"a":1}]`), []byte(`[{"a":2}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []JSONDiffEntry{
+		{Path: "", OldValue: json.RawMessage(`[{"a":1}]`), NewValue: json.RawMessage(`[{"a":2}]`)},
+	}, entries)
+	entries, err = DiffJSON([]byte(doc1), []byte(doc1))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = DiffJSON([]byte(`{`), []byte(doc1))
+	assert.Error(t, err)
+	_, err = DiffJSON([]byte(doc1), []byte(`{`))
+	assert.Error(t, err)
+}
@@ -26,9 +26,11 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -66,6 +68,7 @@ type AzureBlobFs struct {
 	containerClient *container.Client
 	ctxTimeout      time.Duration
 	ctxLongTimeout  time.Duration
+	cache           *metadataCacheHandle
 }
 
 func init() {
@@ -98,6 +101,9 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 	}
 
 	fs.setConfigDefaults()
+	fs.cache = fs.config.MetadataCache.getHandle([]string{azBlobFsName, fs.config.Endpoint, fs.config.AccountName,
+		fs.config.Container, getAzSASURLResource(fs.config.SASURL.GetPayload()), fs.config.KeyPrefix},
+		fs.config.AccountKey.GetPayload(), fs.config.SASURL.GetPayload())
 
 	if fs.config.SASURL.GetPayload() != "" {
 		return fs.initFromSASURL()
@@ -176,15 +182,28 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
+	if info, ok := fs.cache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.statObject(name)
+	if err == nil {
+		fs.cache.setStat(name, info)
+	}
+	return info, err
+}
 
+func (fs *AzureBlobFs) statObject(name string) (os.FileInfo, error) {
 	attrs, err := fs.headObject(name)
 	if err == nil {
 		contentType := util.GetStringFromPointer(attrs.ContentType)
 		isDir := checkDirectoryMarkers(contentType, attrs.Metadata)
 		metric.AZListObjectsCompleted(nil)
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir,
-			util.GetIntFromPointer(attrs.ContentLength),
-			util.GetTimeFromPointer(attrs.LastModified), false))
+		info := NewFileInfo(name, isDir, util.GetIntFromPointer(attrs.ContentLength),
+			util.GetTimeFromPointer(attrs.LastModified), false)
+		if attrs.ETag != nil {
+			info.SetETag(string(*attrs.ETag))
+		}
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return nil, err
@@ -200,6 +219,22 @@ func (fs *AzureBlobFs) Stat(name string) (os.FileInfo, error) {
 	return nil, os.ErrNotExist
 }
 
+// getPendingUploadInfo returns a FileInfo describing the interrupted upload
+// for the specified blob, if any
+func (fs *AzureBlobFs) getPendingUploadInfo(name string) (os.FileInfo, error) {
+	if !cloudUploadResume {
+		return nil, os.ErrNotExist
+	}
+	upload, err := fs.getPendingUpload(name)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || upload.size == 0 {
+		return nil, os.ErrNotExist
+	}
+	return NewFileInfo(name, false, upload.size, upload.lastModified, false), nil
+}
+
 // Lstat returns a FileInfo describing the named file
 func (fs *AzureBlobFs) Lstat(name string) (os.FileInfo, error) {
 	return fs.Stat(name)
@@ -234,13 +269,25 @@ func (fs *AzureBlobFs) Create(name string, flag, checks int) (File, *PipeWriter,
 			return nil, nil, nil, err
 		}
 	}
+	var upload *azurePendingUpload
+	if cloudUploadResume && flag != -1 && checks&CheckResume != 0 {
+		var err error
+		upload, err = fs.getResumableUpload(name, flag)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	ctx, cancelFn := context.WithCancel(context.Background())
 
-	p := NewPipeWriter(w)
+	var offset int64
+	if upload != nil {
+		offset = upload.size
+	}
+	p := NewPipeWriterAtOffset(w, offset)
 	headers := blob.HTTPHeaders{}
 	var contentType string
 	var metadata map[string]*string
@@ -260,7 +307,8 @@ func (fs *AzureBlobFs) Create(name string, flag, checks int) (File, *PipeWriter,
 		defer cancelFn()
 
 		blockBlob := fs.containerClient.NewBlockBlobClient(name)
-		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers, metadata)
+		err := fs.handleMultipartUpload(ctx, r, blockBlob, &headers, metadata, upload)
+		fs.cache.invalidate(name)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %v, err: %+v", name, r.GetReadedBytes(), err)
@@ -283,6 +331,10 @@ func (fs *AzureBlobFs) Rename(source, target string) (int, int64, error) {
 	if err != nil {
 		return -1, -1, err
 	}
+	defer func() {
+		fs.cache.invalidateTree(source)
+		fs.cache.invalidateTree(target)
+	}()
 	return fs.renameInternal(source, target, fi)
 }
 
@@ -318,6 +370,7 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 		}
 	}
 	metric.AZDeleteObjectCompleted(err)
+	fs.cache.invalidate(name)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %q: %+v", name, errMetadata)
@@ -369,6 +422,7 @@ func (fs *AzureBlobFs) Chtimes(name string, _, mtime time.Time, isUploading bool
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.cache.invalidate(name)
 
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
@@ -381,19 +435,26 @@ func (*AzureBlobFs) Truncate(_ string, _ int64) error {
 	return ErrVfsUnsupported
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+// ReadDir returns a DirLister for the directory named by dirname.
+// Blobs are listed a page at a time while iterating
+func (fs *AzureBlobFs) ReadDir(dirname string) (DirLister, error) {
+	if lister, ok := fs.cache.getLister(dirname); ok {
+		return lister, nil
+	}
+	lister, err := fs.readDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.cache.wrapLister(dirname, lister), nil
+}
+
+func (fs *AzureBlobFs) readDir(dirname string) (DirLister, error) {
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
-
 	modTimes, err := getFolderModTimes(fs.getStorageID(), dirname)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	prefixes := make(map[string]bool)
-
 	pager := fs.containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
 		Include: container.ListBlobsInclude{
 			//Metadata: true,
@@ -401,64 +462,20 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 		Prefix: &prefix,
 	})
 
-	for pager.More() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-		defer cancelFn()
-
-		resp, err := pager.NextPage(ctx)
-		if err != nil {
-			metric.AZListObjectsCompleted(err)
-			return result, err
-		}
-		for _, blobPrefix := range resp.ListBlobsHierarchySegmentResponse.Segment.BlobPrefixes {
-			name := util.GetStringFromPointer(blobPrefix.Name)
-			// we don't support prefixes == "/" this will be sent if a key starts with "/"
-			if name == "" || name == "/" {
-				continue
-			}
-			// sometime we have duplicate prefixes, maybe an Azurite bug
-			name = strings.TrimPrefix(name, prefix)
-			if _, ok := prefixes[strings.TrimSuffix(name, "/")]; ok {
-				continue
-			}
-			result = append(result, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
-			prefixes[strings.TrimSuffix(name, "/")] = true
-		}
-
-		for _, blobItem := range resp.ListBlobsHierarchySegmentResponse.Segment.BlobItems {
-			name := util.GetStringFromPointer(blobItem.Name)
-			name = strings.TrimPrefix(name, prefix)
-			size := int64(0)
-			isDir := false
-			modTime := time.Unix(0, 0)
-			if blobItem.Properties != nil {
-				size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
-				modTime = util.GetTimeFromPointer(blobItem.Properties.LastModified)
-				contentType := util.GetStringFromPointer(blobItem.Properties.ContentType)
-				isDir = checkDirectoryMarkers(contentType, blobItem.Metadata)
-				if isDir {
-					// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
-					if _, ok := prefixes[name]; ok {
-						continue
-					}
-					prefixes[name] = true
-				}
-			}
-			if t, ok := modTimes[name]; ok {
-				modTime = util.GetTimeFromMsecSinceEpoch(t)
-			}
-			result = append(result, NewFileInfo(name, isDir, size, modTime, false))
-		}
-	}
-	metric.AZListObjectsCompleted(nil)
-
-	return result, nil
+	return &azureBlobDirLister{
+		pager:    pager,
+		timeout:  fs.ctxTimeout,
+		prefix:   prefix,
+		prefixes: make(map[string]bool),
+		modTimes: modTimes,
+	}, nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on Azure Blob
+// Resuming uploads is supported on Azure Blob only if enabled in the configuration,
+// the blocks staged by interrupted uploads are kept uncommitted and can be reused
 func (*AzureBlobFs) IsUploadResumeSupported() bool {
-	return false
+	return cloudUploadResume
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
@@ -713,6 +730,8 @@ func (fs *AzureBlobFs) ResolvePath(virtualPath string) (string, error) {
 
 // CopyFile implements the FsFileCopier interface
 func (fs *AzureBlobFs) CopyFile(source, target string, _ int64) error {
+	defer fs.cache.invalidate(target)
+
 	return fs.copyFileInternal(source, target)
 }
 
@@ -850,23 +869,35 @@ func (fs *AzureBlobFs) renameInternal(source, target string, fi os.FileInfo) (in
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			entries, err := fs.ReadDir(source)
+			lister, err := fs.readDir(source)
 			if err != nil {
 				return numFiles, filesSize, err
 			}
-			for _, info := range entries {
-				sourceEntry := fs.Join(source, info.Name())
-				targetEntry := fs.Join(target, info.Name())
-				files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
-				if err != nil {
-					if fs.IsNotExist(err) {
-						fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
-						continue
-					}
+			defer lister.Close()
+
+			for {
+				entries, err := lister.Next(ListerBatchSize)
+				finished := errors.Is(err, io.EOF)
+				if err != nil && !finished {
 					return numFiles, filesSize, err
 				}
-				numFiles += files
-				filesSize += size
+				for _, info := range entries {
+					sourceEntry := fs.Join(source, info.Name())
+					targetEntry := fs.Join(target, info.Name())
+					files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
+					if err != nil {
+						if fs.IsNotExist(err) {
+							fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
+							continue
+						}
+						return numFiles, filesSize, err
+					}
+					numFiles += files
+					filesSize += size
+				}
+				if finished {
+					break
+				}
 			}
 		}
 	} else {
@@ -1042,6 +1073,7 @@ func (fs *AzureBlobFs) handleMultipartDownload(ctx context.Context, blockBlob *b
 
 func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Reader,
 	blockBlob *blockblob.Client, httpHeaders *blob.HTTPHeaders, metadata map[string]*string,
+	upload *azurePendingUpload,
 ) error {
 	partSize := fs.config.UploadPartSize
 	guard := make(chan struct{}, fs.config.UploadConcurrency)
@@ -1052,6 +1084,9 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 	pool := newBufferAllocator(int(partSize))
 	finished := false
 	var blocks []string
+	if upload != nil {
+		blocks = append(blocks, upload.blocks...)
+	}
 	var wg sync.WaitGroup
 	var errOnce sync.Once
 	var hasError atomic.Bool
@@ -1077,15 +1112,22 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 			return err
 		}
 
-		// Block IDs are unique values to avoid issue if 2+ clients are uploading blocks
-		// at the same time causing CommitBlockList to get a mix of blocks from all the clients.
-		generatedUUID, err := uuid.NewRandom()
-		if err != nil {
-			pool.releaseBuffer(buf)
-			pool.free()
-			return fmt.Errorf("unable to generate block ID: %w", err)
+		var blockID string
+		if upload != nil {
+			// resumable uploads use sequential block IDs within an upload session,
+			// so the staged blocks can be found and reused if the upload is interrupted
+			blockID = upload.getBlockID(len(blocks))
+		} else {
+			// Block IDs are unique values to avoid issue if 2+ clients are uploading blocks
+			// at the same time causing CommitBlockList to get a mix of blocks from all the clients.
+			generatedUUID, err := uuid.NewRandom()
+			if err != nil {
+				pool.releaseBuffer(buf)
+				pool.free()
+				return fmt.Errorf("unable to generate block ID: %w", err)
+			}
+			blockID = base64.StdEncoding.EncodeToString([]byte(generatedUUID.String()))
 		}
-		blockID := base64.StdEncoding.EncodeToString([]byte(generatedUUID.String()))
 		blocks = append(blocks, blockID)
 
 		guard <- struct{}{}
@@ -1128,6 +1170,12 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 	if poolError != nil {
 		return poolError
 	}
+	if err := ctx.Err(); err != nil {
+		if upload != nil {
+			fsLog(fs, logger.LevelDebug, "upload interrupted, the staged blocks are kept to allow resuming it")
+		}
+		return err
+	}
 
 	commitOptions := blockblob.CommitBlockListOptions{
 		HTTPHeaders: httpHeaders,
@@ -1141,6 +1189,114 @@ func (fs *AzureBlobFs) handleMultipartUpload(ctx context.Context, reader io.Read
 	return err
 }
 
+// azurePendingUpload defines an upload session whose staged blocks can be
+// committed after resuming an interrupted upload
+type azurePendingUpload struct {
+	sessionID    string
+	blocks       []string
+	size         int64
+	lastModified time.Time
+}
+
+func (u *azurePendingUpload) getBlockID(index int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", u.sessionID, index)))
+}
+
+// parseResumableBlockID returns the session ID and the block index for a block ID
+// generated by getBlockID
+func parseResumableBlockID(blockID string) (string, int, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(blockID)
+	if err != nil || len(decoded) != 43 || decoded[36] != '-' {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(string(decoded[37:]))
+	if err != nil || index < 0 {
+		return "", 0, false
+	}
+	return string(decoded[:36]), index, true
+}
+
+func (fs *AzureBlobFs) getResumableUpload(name string, flag int) (*azurePendingUpload, error) {
+	if flag&os.O_TRUNC == 0 {
+		// only interrupted uploads can be resumed, we cannot append to existing blobs
+		_, err := fs.headObject(name)
+		if err == nil {
+			fsLog(fs, logger.LevelDebug, "unable to resume upload for %q, the blob already exists", name)
+			return nil, ErrVfsUnsupported
+		}
+		if !fs.IsNotExist(err) {
+			return nil, err
+		}
+		upload, err := fs.getPendingUpload(name)
+		if err != nil {
+			return nil, err
+		}
+		if upload != nil {
+			fsLog(fs, logger.LevelDebug, "resuming upload for %q, session id %q, offset %d",
+				name, upload.sessionID, upload.size)
+			return upload, nil
+		}
+	}
+	sessionID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate upload session ID: %w", err)
+	}
+	return &azurePendingUpload{
+		sessionID: sessionID.String(),
+	}, nil
+}
+
+// getPendingUpload returns the interrupted upload, for the specified blob, with
+// the most data or nil if there is no interrupted upload
+func (fs *AzureBlobFs) getPendingUpload(name string) (*azurePendingUpload, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.containerClient.NewBlockBlobClient(name).GetBlockList(ctx, blockblob.BlockListTypeUncommitted,
+		&blockblob.GetBlockListOptions{})
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil, nil
+		}
+		fsLog(fs, logger.LevelError, "unable to get the uncommitted blocks for %q: %+v", name, err)
+		return nil, err
+	}
+	sessions := make(map[string]map[int]*blockblob.Block)
+	for _, block := range resp.UncommittedBlocks {
+		if block == nil {
+			continue
+		}
+		sessionID, index, ok := parseResumableBlockID(util.GetStringFromPointer(block.Name))
+		if !ok {
+			continue
+		}
+		if _, ok := sessions[sessionID]; !ok {
+			sessions[sessionID] = make(map[int]*blockblob.Block)
+		}
+		sessions[sessionID][index] = block
+	}
+	var upload *azurePendingUpload
+	for sessionID, blocks := range sessions {
+		candidate := &azurePendingUpload{
+			sessionID:    sessionID,
+			lastModified: time.Now(),
+		}
+		// we can only reuse the blocks staged sequentially from the beginning
+		for index := 0; ; index++ {
+			block, ok := blocks[index]
+			if !ok {
+				break
+			}
+			candidate.blocks = append(candidate.blocks, util.GetStringFromPointer(block.Name))
+			candidate.size += util.GetIntFromPointer(block.Size)
+		}
+		if upload == nil || candidate.size > upload.size {
+			upload = candidate
+		}
+	}
+	return upload, nil
+}
+
 func (*AzureBlobFs) writeAtFull(w io.WriterAt, buf []byte, offset int64, count int) (int, error) {
 	written := 0
 	for written < count {
@@ -1171,6 +1327,11 @@ func (fs *AzureBlobFs) getCopyOptions() *blob.StartCopyFromURLOptions {
 	return copyOptions
 }
 
+func (fs *AzureBlobFs) getCacheNamespace() string {
+	return getHashedNamespace(azBlobFsName, fs.config.Endpoint, fs.config.AccountName, fs.config.Container,
+		fs.config.SASURL.GetPayload())
+}
+
 func (fs *AzureBlobFs) getStorageID() string {
 	if fs.config.Endpoint != "" {
 		if !strings.HasSuffix(fs.config.Endpoint, "/") {
@@ -1263,3 +1424,96 @@ func (b *bufferAllocator) free() {
 	b.available = nil
 	b.finalized = true
 }
+
+type azureBlobDirLister struct {
+	baseDirLister
+	pager         *runtime.Pager[container.ListBlobsHierarchyResponse]
+	timeout       time.Duration
+	prefix        string
+	prefixes      map[string]bool
+	modTimes      map[string]int64
+	metricUpdated bool
+}
+
+func (l *azureBlobDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if len(l.cache) >= limit {
+		return l.returnFromCache(limit), nil
+	}
+	if !l.pager.More() {
+		if !l.metricUpdated {
+			l.metricUpdated = true
+			metric.AZListObjectsCompleted(nil)
+		}
+		return l.returnFromCache(limit), io.EOF
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.timeout))
+	defer cancelFn()
+
+	resp, err := l.pager.NextPage(ctx)
+	if err != nil {
+		metric.AZListObjectsCompleted(err)
+		return nil, err
+	}
+	for _, blobPrefix := range resp.ListBlobsHierarchySegmentResponse.Segment.BlobPrefixes {
+		name := util.GetStringFromPointer(blobPrefix.Name)
+		// we don't support prefixes == "/" this will be sent if a key starts with "/"
+		if name == "" || name == "/" {
+			continue
+		}
+		// sometime we have duplicate prefixes, maybe an Azurite bug
+		name = strings.TrimPrefix(name, l.prefix)
+		if _, ok := l.prefixes[strings.TrimSuffix(name, "/")]; ok {
+			continue
+		}
+		l.cache = append(l.cache, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
+		l.prefixes[strings.TrimSuffix(name, "/")] = true
+	}
+
+	for _, blobItem := range resp.ListBlobsHierarchySegmentResponse.Segment.BlobItems {
+		name := util.GetStringFromPointer(blobItem.Name)
+		name = strings.TrimPrefix(name, l.prefix)
+		size := int64(0)
+		isDir := false
+		modTime := time.Unix(0, 0)
+		if blobItem.Properties != nil {
+			size = util.GetIntFromPointer(blobItem.Properties.ContentLength)
+			modTime = util.GetTimeFromPointer(blobItem.Properties.LastModified)
+			contentType := util.GetStringFromPointer(blobItem.Properties.ContentType)
+			isDir = checkDirectoryMarkers(contentType, blobItem.Metadata)
+			if isDir {
+				// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
+				if _, ok := l.prefixes[name]; ok {
+					continue
+				}
+				l.prefixes[name] = true
+			}
+		}
+		if t, ok := l.modTimes[name]; ok {
+			modTime = util.GetTimeFromMsecSinceEpoch(t)
+		}
+		l.cache = append(l.cache, NewFileInfo(name, isDir, size, modTime, false))
+	}
+	return l.returnFromCache(limit), nil
+}
+
+func (l *azureBlobDirLister) Close() error {
+	l.prefixes = nil
+	return l.baseDirLister.Close()
+}
+
+// getAzSASURLResource returns the SAS URL without the query string, so the
+// same resource accessed using different tokens can share the metadata cache
+func getAzSASURLResource(sasURL string) string {
+	if sasURL == "" {
+		return ""
+	}
+	u, err := url.Parse(sasURL)
+	if err != nil {
+		return sasURL
+	}
+	u.RawQuery = ""
+	return u.String()
+}
@@ -18,10 +18,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/eikenb/pipeat"
 	"github.com/minio/sio"
@@ -36,6 +39,8 @@ const (
 	version10     byte  = 0x10
 	nonceV10Size  int   = 32
 	headerV10Size int64 = 33 // 1 (version byte) + 32 (nonce size)
+	// prefix for the temporary files created while re-encrypting
+	cryptFsReEncryptPrefix = ".sftpgo-reencrypt."
 )
 
 // CryptFs is a Fs implementation that allows to encrypts/decrypts local files
@@ -161,18 +166,7 @@ func (fs *CryptFs) Create(name string, flag, _ int) (File, *PipeWriter, func(),
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	header := encryptedFileHeader{
-		version: version10,
-		nonce:   make([]byte, 32),
-	}
-	_, err = io.ReadFull(rand.Reader, header.nonce)
-	if err != nil {
-		f.Close()
-		return nil, nil, nil, err
-	}
-	var key [32]byte
-	kdf := hkdf.New(sha256.New, fs.masterKey, header.nonce, nil)
-	_, err = io.ReadFull(kdf, key[:])
+	header, key, err := newEncryptedFileHeader(fs.masterKey)
 	if err != nil {
 		f.Close()
 		return nil, nil, nil, err
@@ -210,23 +204,13 @@ func (*CryptFs) Truncate(_ string, _ int64) error {
 	return ErrVfsUnsupported
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *CryptFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+// ReadDir returns a DirLister for the directory named by dirname
+func (fs *CryptFs) ReadDir(dirname string) (DirLister, error) {
 	f, err := os.Open(dirname)
 	if err != nil {
 		return nil, err
 	}
-	list, err := f.Readdir(-1)
-	f.Close()
-	if err != nil {
-		return nil, err
-	}
-	result := make([]os.FileInfo, 0, len(list))
-	for _, info := range list {
-		result = append(result, fs.ConvertFileInfo(info))
-	}
-	return result, nil
+	return &cryptFsDirLister{osFsDirLister{f: f}, fs}, nil
 }
 
 // IsUploadResumeSupported returns false sio does not support random access writes
@@ -264,12 +248,168 @@ func (fs *CryptFs) GetMimeType(name string) (string, error) {
 	return ctype, err
 }
 
-func (fs *CryptFs) getSIOConfig(key [32]byte) sio.Config {
-	return sio.Config{
-		MinVersion: sio.Version20,
-		MaxVersion: sio.Version20,
-		Key:        key[:],
+// CryptFsReEncryptOptions defines the options for a CryptFs re-encryption
+type CryptFsReEncryptOptions struct {
+	// Passphrase to use for the re-encrypted files
+	Passphrase string
+	// ID of the re-encryption, the temporary files are named after it. Only the
+	// temporary files for this ID are removed if left over by an interrupted run
+	ID string
+	// Path, relative to the root directory, of the last processed file.
+	// If set the files visited before and including it are not processed again
+	ResumeAfter string
+	// Progress is invoked for each processed file, it receives the relative path,
+	// the encrypted file size and true if the file was already encrypted using
+	// the new passphrase. The re-encryption stops if it returns an error
+	Progress func(name string, size int64, skipped bool) error
+}
+
+// ReEncrypt re-encrypts all the files inside the root directory using a key
+// derived from the specified passphrase. Files already encrypted using the new
+// passphrase are skipped, so an interrupted re-encryption can be resumed.
+// The files are visited in lexical order
+func (fs *CryptFs) ReEncrypt(opts CryptFsReEncryptOptions) error {
+	if opts.Passphrase == "" {
+		return errors.New("the new passphrase cannot be empty")
+	}
+	if opts.ID == "" || strings.ContainsAny(opts.ID, `/\`) {
+		return fmt.Errorf("invalid re-encryption ID %q", opts.ID)
+	}
+	newMasterKey := []byte(opts.Passphrase)
+	tempPrefix := cryptFsReEncryptPrefix + opts.ID + "."
+	resumeAfter := filepath.ToSlash(opts.ResumeAfter)
+	return filepath.Walk(fs.rootDir, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// temporary file renamed after re-encryption
+				return nil
+			}
+			return err
+		}
+		relPath, err := filepath.Rel(fs.rootDir, walkedPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), tempPrefix) {
+			// leftover from an interrupted run of this re-encryption
+			if err := os.Remove(walkedPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		if resumeAfter != "" && relPath != "." && isWalkedBefore(relPath, resumeAfter) {
+			if !info.IsDir() {
+				return nil
+			}
+			if !strings.HasPrefix(resumeAfter, relPath+"/") {
+				return filepath.SkipDir
+			}
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".sftpgo-upload.") {
+			return nil
+		}
+		skipped, err := fs.reEncryptFile(walkedPath, tempPrefix, info, newMasterKey)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "unable to re-encrypt file %q: %v", walkedPath, err)
+			return fmt.Errorf("unable to re-encrypt file %q: %w", walkedPath, err)
+		}
+		return opts.Progress(relPath, info.Size(), skipped)
+	})
+}
+
+// isWalkedBefore returns true if filepath.Walk visits the relative path name
+// before, or at the same time of, the relative path other.
+// Walk visits the entries of each directory in lexical order and a directory
+// before its contents
+func isWalkedBefore(name, other string) bool {
+	nameParts := strings.Split(name, "/")
+	otherParts := strings.Split(other, "/")
+	for idx := 0; idx < len(nameParts) && idx < len(otherParts); idx++ {
+		if nameParts[idx] != otherParts[idx] {
+			return nameParts[idx] < otherParts[idx]
+		}
+	}
+	return len(nameParts) <= len(otherParts)
+}
+
+// reEncryptFile re-encrypts the named file using the specified master key.
+// It returns true if the file is already encrypted using the new master key
+func (fs *CryptFs) reEncryptFile(name, tempPrefix string, info os.FileInfo, newMasterKey []byte) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := encryptedFileHeader{}
+	if err := header.Load(f); err != nil {
+		return false, err
+	}
+	if info.Size() == headerV10Size {
+		// empty files can be decrypted using any key
+		return true, nil
+	}
+	newKey, err := header.deriveKey(newMasterKey)
+	if err != nil {
+		return false, err
+	}
+	if r, err := sio.DecryptReader(f, getSIOConfig(newKey)); err == nil {
+		if _, err := io.ReadFull(r, make([]byte, 1)); err == nil {
+			return true, nil
+		}
+	}
+	if _, err := f.Seek(headerV10Size, io.SeekStart); err != nil {
+		return false, err
+	}
+	key, err := header.deriveKey(fs.masterKey)
+	if err != nil {
+		return false, err
+	}
+	tempName := filepath.Join(filepath.Dir(name), tempPrefix+filepath.Base(name))
+	if err := fs.writeReEncryptedFile(tempName, f, key, newMasterKey, info.Mode().Perm()); err != nil {
+		os.Remove(tempName)
+		return false, err
+	}
+	if err := os.Chtimes(tempName, info.ModTime(), info.ModTime()); err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to preserve modification time for re-encrypted file %q: %v", name, err)
+	}
+	if err := os.Rename(tempName, name); err != nil {
+		os.Remove(tempName)
+		return false, err
+	}
+	return false, nil
+}
+
+func (fs *CryptFs) writeReEncryptedFile(name string, src io.Reader, key [32]byte, newMasterKey []byte,
+	mode os.FileMode,
+) error {
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	header, newKey, err := newEncryptedFileHeader(newMasterKey)
+	if err == nil {
+		err = header.Store(dst)
+	}
+	if err == nil {
+		var decrypted io.Reader
+		decrypted, err = sio.DecryptReader(src, getSIOConfig(key))
+		if err == nil {
+			_, err = sio.Encrypt(dst, decrypted, getSIOConfig(newKey))
+		}
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if errClose := dst.Close(); err == nil {
+		err = errClose
 	}
+	return err
+}
+
+func (fs *CryptFs) getSIOConfig(key [32]byte) sio.Config {
+	return getSIOConfig(key)
 }
 
 // ConvertFileInfo returns a FileInfo with the decrypted size
@@ -277,17 +417,7 @@ func (fs *CryptFs) ConvertFileInfo(info os.FileInfo) os.FileInfo {
 	if !info.Mode().IsRegular() {
 		return info
 	}
-	size := info.Size()
-	if size >= headerV10Size {
-		size -= headerV10Size
-		decryptedSize, err := sio.DecryptedSize(uint64(size))
-		if err == nil {
-			size = int64(decryptedSize)
-		}
-	} else {
-		size = 0
-	}
-	return NewFileInfo(info.Name(), info.IsDir(), size, info.ModTime(), false)
+	return NewFileInfo(info.Name(), info.IsDir(), getDecryptedSize(info.Size()), info.ModTime(), false)
 }
 
 func (fs *CryptFs) getFileAndEncryptionKey(name string) (*os.File, [32]byte, error) {
@@ -302,8 +432,7 @@ func (fs *CryptFs) getFileAndEncryptionKey(name string) (*os.File, [32]byte, err
 		f.Close()
 		return nil, key, err
 	}
-	kdf := hkdf.New(sha256.New, fs.masterKey, header.nonce, nil)
-	_, err = io.ReadFull(kdf, key[:])
+	key, err = header.deriveKey(fs.masterKey)
 	if err != nil {
 		f.Close()
 		return nil, key, err
@@ -331,20 +460,63 @@ func isZeroBytesDownload(f *os.File, offset int64) (bool, error) {
 	return false, nil
 }
 
+func getSIOConfig(key [32]byte) sio.Config {
+	return sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        key[:],
+	}
+}
+
+// getDecryptedSize returns the plaintext size for an encrypted file of the
+// specified size, header included
+func getDecryptedSize(size int64) int64 {
+	if size < headerV10Size {
+		return 0
+	}
+	decryptedSize, err := sio.DecryptedSize(uint64(size - headerV10Size))
+	if err != nil {
+		return size - headerV10Size
+	}
+	return int64(decryptedSize)
+}
+
 type encryptedFileHeader struct {
 	version byte
 	nonce   []byte
 }
 
-func (h *encryptedFileHeader) Store(f *os.File) error {
+// newEncryptedFileHeader returns a header with a random nonce and the file
+// encryption key derived from the master key and the nonce
+func newEncryptedFileHeader(masterKey []byte) (encryptedFileHeader, [32]byte, error) {
+	var key [32]byte
+	header := encryptedFileHeader{
+		version: version10,
+		nonce:   make([]byte, nonceV10Size),
+	}
+	if _, err := io.ReadFull(rand.Reader, header.nonce); err != nil {
+		return header, key, err
+	}
+	key, err := header.deriveKey(masterKey)
+	return header, key, err
+}
+
+func (h *encryptedFileHeader) deriveKey(masterKey []byte) ([32]byte, error) {
+	var key [32]byte
+	kdf := hkdf.New(sha256.New, masterKey, h.nonce, nil)
+	_, err := io.ReadFull(kdf, key[:])
+	return key, err
+}
+
+func (h *encryptedFileHeader) Store(w io.Writer) error {
 	buf := make([]byte, 0, headerV10Size)
 	buf = append(buf, version10)
 	buf = append(buf, h.nonce...)
-	_, err := f.Write(buf)
+	_, err := w.Write(buf)
 	return err
 }
 
-func (h *encryptedFileHeader) Load(f *os.File) error {
+func (h *encryptedFileHeader) Load(f io.Reader) error {
 	header := make([]byte, 1+nonceV10Size)
 	_, err := io.ReadFull(f, header)
 	if err != nil {
@@ -365,3 +537,16 @@ type cryptedFileWrapper struct {
 func (w *cryptedFileWrapper) ReadAt(p []byte, offset int64) (n int, err error) {
 	return w.File.ReadAt(p, offset+headerV10Size)
 }
+
+type cryptFsDirLister struct {
+	osFsDirLister
+	fs *CryptFs
+}
+
+func (l *cryptFsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.osFsDirLister.Next(limit)
+	for idx := range files {
+		files[idx] = l.fs.ConvertFileInfo(files[idx])
+	}
+	return files, err
+}
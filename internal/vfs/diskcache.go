@@ -0,0 +1,533 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	diskCacheLogSender     = "diskcache"
+	diskCacheDataDir       = "data"
+	diskCacheUploadsDir    = "uploads"
+	diskCacheUploadRetries = 5
+)
+
+var (
+	diskCache                     *diskCacheStore
+	errDiskCacheUploadInterrupted = errors.New("upload interrupted by a restart")
+)
+
+// SetDiskCache configures the local disk cache for remote filesystems.
+// maxSize and maxFileSize are expressed in bytes, an empty path or a
+// maxSize <= 0 disable the cache. The cached contents are not persisted
+// across restarts, the pending write-back uploads are instead resumed
+func SetDiskCache(basePath string, maxSize, maxFileSize int64, writeBack bool) error {
+	if basePath == "" || maxSize <= 0 {
+		diskCache = nil
+		return nil
+	}
+	if !filepath.IsAbs(basePath) {
+		return fmt.Errorf("invalid disk cache path %q, it must be an absolute path", basePath)
+	}
+	if maxFileSize <= 0 || maxFileSize > maxSize {
+		maxFileSize = maxSize
+	}
+	store := &diskCacheStore{
+		basePath:    basePath,
+		maxSize:     maxSize,
+		maxFileSize: maxFileSize,
+		writeBack:   writeBack,
+		entries:     make(map[string]*diskCacheEntry),
+		lru:         list.New(),
+		uploads:     make(map[string]*diskCachePendingUpload),
+	}
+	// we don't persist the cache index, so the previously cached files are useless
+	if err := os.RemoveAll(store.getDataDir()); err != nil {
+		return fmt.Errorf("unable to clean the disk cache data directory: %w", err)
+	}
+	for _, dir := range []string{store.getDataDir(), store.getUploadsDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("unable to create disk cache directory %q: %w", dir, err)
+		}
+	}
+	store.loadStaleUploads()
+	logger.Info(diskCacheLogSender, "", "disk cache initialized, path: %q, max size: %d, max file size: %d, write back: %t",
+		basePath, maxSize, maxFileSize, writeBack)
+	diskCache = store
+	return nil
+}
+
+// DiskCacheStatus defines the status and the statistics for the local disk cache
+type DiskCacheStatus struct {
+	IsActive       bool  `json:"is_active"`
+	WriteBack      bool  `json:"write_back,omitempty"`
+	MaxSize        int64 `json:"max_size,omitempty"`
+	Size           int64 `json:"size,omitempty"`
+	Files          int   `json:"files,omitempty"`
+	Hits           int64 `json:"hits,omitempty"`
+	Misses         int64 `json:"misses,omitempty"`
+	Evictions      int64 `json:"evictions,omitempty"`
+	PendingUploads int   `json:"pending_uploads,omitempty"`
+	FailedUploads  int64 `json:"failed_uploads,omitempty"`
+}
+
+// GetSizeAsString returns the used and the maximum size as human readable string
+func (s *DiskCacheStatus) GetSizeAsString() string {
+	return fmt.Sprintf("%s/%s", util.ByteCountIEC(s.Size), util.ByteCountIEC(s.MaxSize))
+}
+
+// GetDiskCacheStatus returns the status for the local disk cache
+func GetDiskCacheStatus() DiskCacheStatus {
+	if diskCache == nil {
+		return DiskCacheStatus{}
+	}
+	return diskCache.getStatus()
+}
+
+// GetDiskCachePendingUploads returns the number of write-back uploads
+// not yet completed
+func GetDiskCachePendingUploads() int {
+	if diskCache == nil {
+		return 0
+	}
+	return diskCache.getPendingUploads()
+}
+
+// getHashedNamespace returns an opaque identifier for the specified parts
+func getHashedNamespace(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(h[:])
+}
+
+type diskCacheEntry struct {
+	key     string
+	path    string
+	size    int64
+	modTime time.Time
+	etag    string
+	// number of readers for this entry, the file is removed when
+	// the entry is evicted and there are no readers
+	refs    int
+	removed bool
+	elem    *list.Element
+}
+
+func (e *diskCacheEntry) isValid(info os.FileInfo) bool {
+	if e.size != info.Size() || !e.modTime.Equal(info.ModTime()) {
+		return false
+	}
+	etag := getFileInfoETag(info)
+	return e.etag == "" || etag == "" || e.etag == etag
+}
+
+// diskCachePendingUpload is a file stored on the local disk and not yet uploaded
+// to the remote filesystem. Failed uploads are kept, and so they are still
+// visible, until they are retried successfully, overwritten or removed
+type diskCachePendingUpload struct {
+	key     string
+	name    string
+	path    string
+	size    int64
+	modTime time.Time
+	// the following fields are protected by the store lock.
+	// done is closed when the upload is not in progress
+	inProgress bool
+	done       chan struct{}
+	err        error
+}
+
+// diskCacheUploadInfo is saved next to each pending upload so that the upload
+// can be resumed if SFTPGo stops before completing it
+type diskCacheUploadInfo struct {
+	Namespace    string `json:"namespace"`
+	Filesystem   string `json:"filesystem"`
+	ConnectionID string `json:"connection_id"`
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+}
+
+type diskCacheStore struct {
+	sync.Mutex
+	basePath    string
+	maxSize     int64
+	maxFileSize int64
+	writeBack   bool
+	entries     map[string]*diskCacheEntry
+	lru         *list.List
+	size        int64
+	uploads     map[string]*diskCachePendingUpload
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	failed      atomic.Int64
+}
+
+func (c *diskCacheStore) getDataDir() string {
+	return filepath.Join(c.basePath, diskCacheDataDir)
+}
+
+func (c *diskCacheStore) getUploadsDir() string {
+	return filepath.Join(c.basePath, diskCacheUploadsDir)
+}
+
+func (c *diskCacheStore) isCacheable(size int64) bool {
+	return size <= c.maxFileSize
+}
+
+func (c *diskCacheStore) createFile(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, util.GenerateUniqueID()), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+}
+
+// loadStaleUploads loads the write-back uploads not completed before the last
+// shutdown, they are resumed when the related filesystem is used again
+func (c *diskCacheStore) loadStaleUploads() {
+	matches, err := filepath.Glob(filepath.Join(c.getUploadsDir(), "*.json"))
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	loaded := 0
+	for _, match := range matches {
+		upload, err := c.loadStaleUpload(match)
+		if err != nil {
+			logger.Warn(diskCacheLogSender, "", "unable to resume the write-back upload described in %q: %v", match, err)
+			continue
+		}
+		c.uploads[upload.key] = upload
+		loaded++
+	}
+	logger.Info(diskCacheLogSender, "", "found %d write-back uploads not completed, loaded: %d",
+		len(matches), loaded)
+}
+
+func (c *diskCacheStore) loadStaleUpload(infoPath string) (*diskCachePendingUpload, error) {
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+	var info diskCacheUploadInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	if info.Namespace == "" || info.Path == "" {
+		return nil, errors.New("missing namespace or path")
+	}
+	filePath := strings.TrimSuffix(infoPath, ".json")
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() != info.Size {
+		return nil, fmt.Errorf("size mismatch, expected: %d, actual: %d", info.Size, stat.Size())
+	}
+	done := make(chan struct{})
+	close(done)
+	return &diskCachePendingUpload{
+		key:     info.Namespace + ":" + info.Path,
+		name:    info.Path,
+		path:    filePath,
+		size:    info.Size,
+		modTime: stat.ModTime(),
+		done:    done,
+		err:     errDiskCacheUploadInterrupted,
+	}, nil
+}
+
+// get returns the cached entry for the specified key if it is still valid for the
+// given remote file info. The returned entry must be released after use
+func (c *diskCacheStore) get(key string, info os.FileInfo) *diskCacheEntry {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	if !e.isValid(info) {
+		c.removeEntry(e)
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	e.refs++
+	c.lru.MoveToFront(e.elem)
+	return e
+}
+
+func (c *diskCacheStore) release(e *diskCacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+
+	e.refs--
+	if e.removed && e.refs <= 0 {
+		os.Remove(e.path) //nolint:errcheck
+	}
+}
+
+// add adds a downloaded or uploaded file to the cache. The file is removed if
+// it cannot be cached
+func (c *diskCacheStore) add(key, filePath string, info os.FileInfo) {
+	if info.IsDir() || !c.isCacheable(info.Size()) {
+		os.Remove(filePath) //nolint:errcheck
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeEntry(e)
+	}
+	for c.size+info.Size() > c.maxSize && c.lru.Len() > 0 {
+		c.removeEntry(c.lru.Back().Value.(*diskCacheEntry))
+		c.evictions.Add(1)
+	}
+	e := &diskCacheEntry{
+		key:     key,
+		path:    filePath,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		etag:    getFileInfoETag(info),
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	c.size += e.size
+}
+
+// remove removes the entry for the specified key and, if tree is true,
+// the entries for all the keys starting with key + "/"
+func (c *diskCacheStore) remove(key string, tree bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeEntry(e)
+	}
+	if !tree {
+		return
+	}
+	prefix := key + "/"
+	for k, e := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			c.removeEntry(e)
+		}
+	}
+}
+
+func (c *diskCacheStore) removeEntry(e *diskCacheEntry) {
+	delete(c.entries, e.key)
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+	e.removed = true
+	if e.refs <= 0 {
+		os.Remove(e.path) //nolint:errcheck
+	}
+}
+
+func (c *diskCacheStore) addUpload(fs Fs, namespace, key, name, filePath string, size int64) (*diskCachePendingUpload, error) {
+	info := diskCacheUploadInfo{
+		Namespace:    namespace,
+		Filesystem:   fs.Name(),
+		ConnectionID: fs.ConnectionID(),
+		Path:         name,
+		Size:         size,
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filePath+".json", data, 0600); err != nil {
+		return nil, err
+	}
+	upload := &diskCachePendingUpload{
+		key:        key,
+		name:       name,
+		path:       filePath,
+		size:       size,
+		modTime:    time.Now(),
+		inProgress: true,
+		done:       make(chan struct{}),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if previous, ok := c.uploads[key]; ok && !previous.inProgress {
+		c.removeUploadFiles(previous)
+	}
+	c.uploads[key] = upload
+	return upload, nil
+}
+
+// startUpload marks a failed or interrupted upload as in progress. It returns
+// false if the upload is already in progress or it is no longer pending
+func (c *diskCacheStore) startUpload(upload *diskCachePendingUpload) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if upload.inProgress || c.uploads[upload.key] != upload {
+		return false
+	}
+	upload.inProgress = true
+	upload.err = nil
+	upload.done = make(chan struct{})
+	return true
+}
+
+// waitUpload waits for the upload, if in progress, and returns the error for
+// the last upload attempt. A nil error means the upload is completed
+func (c *diskCacheStore) waitUpload(upload *diskCachePendingUpload) error {
+	c.Lock()
+	done := upload.done
+	c.Unlock()
+
+	<-done
+
+	c.Lock()
+	defer c.Unlock()
+
+	return upload.err
+}
+
+// discardUpload removes a failed or interrupted upload and the related local
+// files. It returns false if the upload is in progress or it is no longer pending
+func (c *diskCacheStore) discardUpload(upload *diskCachePendingUpload) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if upload.inProgress || c.uploads[upload.key] != upload {
+		return false
+	}
+	delete(c.uploads, upload.key)
+	c.removeUploadFiles(upload)
+	return true
+}
+
+func (c *diskCacheStore) removeUploadFiles(upload *diskCachePendingUpload) {
+	os.Remove(upload.path + ".json") //nolint:errcheck
+	os.Remove(upload.path)           //nolint:errcheck
+	logger.Debug(diskCacheLogSender, "", "pending upload for %q discarded, local file %q", upload.name, upload.path)
+}
+
+func (c *diskCacheStore) getUpload(key string) *diskCachePendingUpload {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.uploads[key]
+}
+
+// getUploads returns the pending uploads for the specified key and, if tree is
+// true, the pending uploads for all the keys starting with key + "/"
+func (c *diskCacheStore) getUploads(key string, tree bool) []*diskCachePendingUpload {
+	c.Lock()
+	defer c.Unlock()
+
+	var result []*diskCachePendingUpload
+	prefix := key + "/"
+	for k, u := range c.uploads {
+		if k == key || (tree && strings.HasPrefix(k, prefix)) {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// getNamespaceUploads returns all the pending uploads for the specified namespace.
+// If onlyFailed is true the uploads in progress are not returned
+func (c *diskCacheStore) getNamespaceUploads(namespace string, onlyFailed bool) []*diskCachePendingUpload {
+	c.Lock()
+	defer c.Unlock()
+
+	var result []*diskCachePendingUpload
+	prefix := namespace + ":"
+	for k, u := range c.uploads {
+		if strings.HasPrefix(k, prefix) && (!onlyFailed || !u.inProgress) {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+func (c *diskCacheStore) getPendingUploads() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.uploads)
+}
+
+// uploadCompleted handles the end of an upload attempt. Successfully uploaded
+// files are removed from the pending uploads and moved to the cache. Failed
+// uploads are kept in the pending ones, so they are still visible, and they
+// are retried when the related filesystem is used again
+func (c *diskCacheStore) uploadCompleted(upload *diskCachePendingUpload, info os.FileInfo, err error) {
+	if err != nil {
+		c.failed.Add(1)
+		logger.Error(diskCacheLogSender, "", "unable to upload %q, the file is kept in %q and the upload "+
+			"will be retried: %v", upload.name, upload.path, err)
+	} else {
+		c.Lock()
+		if c.uploads[upload.key] == upload {
+			delete(c.uploads, upload.key)
+		}
+		c.Unlock()
+
+		os.Remove(upload.path + ".json") //nolint:errcheck
+		cachedPath := filepath.Join(c.getDataDir(), filepath.Base(upload.path))
+		if info != nil && os.Rename(upload.path, cachedPath) == nil {
+			c.add(upload.key, cachedPath, info)
+		} else {
+			os.Remove(upload.path) //nolint:errcheck
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	upload.inProgress = false
+	upload.err = err
+	close(upload.done)
+}
+
+func (c *diskCacheStore) getStatus() DiskCacheStatus {
+	c.Lock()
+	defer c.Unlock()
+
+	return DiskCacheStatus{
+		IsActive:       true,
+		WriteBack:      c.writeBack,
+		MaxSize:        c.maxSize,
+		Size:           c.size,
+		Files:          len(c.entries),
+		Hits:           c.hits.Load(),
+		Misses:         c.misses.Load(),
+		Evictions:      c.evictions.Load(),
+		PendingUploads: len(c.uploads),
+		FailedUploads:  c.failed.Load(),
+	}
+}
@@ -0,0 +1,573 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+)
+
+// diskCacheNamespacer is a Fs that can be wrapped by the local disk cache.
+// The returned namespace must identify the storage and the credentials used
+// to access it
+type diskCacheNamespacer interface {
+	Fs
+	getCacheNamespace() string
+}
+
+// WithDiskCache wraps the filesystem, as returned by one of the filesystem
+// constructors, with the local disk cache, if the cache is enabled and
+// supported for the filesystem
+func WithDiskCache(fs Fs, err error) (Fs, error) {
+	if err != nil || diskCache == nil {
+		return fs, err
+	}
+	namespacer, ok := fs.(diskCacheNamespacer)
+	if !ok {
+		return fs, nil
+	}
+	// unbuffered SFTP filesystems return random access files
+	if IsSFTPFs(fs) && fs.IsUploadResumeSupported() {
+		return fs, nil
+	}
+	cacheFs := &diskCacheFs{
+		Fs:        fs,
+		store:     diskCache,
+		namespace: namespacer.getCacheNamespace(),
+	}
+	cacheFs.resumeUploads()
+	switch fs.(type) {
+	case FsFileCopier:
+		return &diskCacheFileCopierFs{cacheFs}, nil
+	case FsRealPather:
+		return &diskCacheRealPatherFs{cacheFs}, nil
+	}
+	return cacheFs, nil
+}
+
+// diskCacheFs wraps a remote filesystem and stores the downloaded files on the
+// local disk. The cached files are validated against the remote size,
+// modification time and ETag before use. If write-back is enabled, uploads are
+// stored locally and then asynchronously uploaded to the remote filesystem
+type diskCacheFs struct {
+	Fs
+	store     *diskCacheStore
+	namespace string
+	uploads   sync.WaitGroup
+	pending   atomic.Int32
+}
+
+func (fs *diskCacheFs) getKey(name string) string {
+	return fs.namespace + ":" + name
+}
+
+// resumeUploads restarts the failed write-back uploads and the ones interrupted
+// by a restart for this filesystem
+func (fs *diskCacheFs) resumeUploads() {
+	for _, upload := range fs.store.getNamespaceUploads(fs.namespace, true) {
+		if fs.startUpload(upload) {
+			fsLog(fs, logger.LevelDebug, "resuming upload from the disk cache, path: %q, size: %d",
+				upload.name, upload.size)
+			go fs.upload(upload, diskCacheUploadRetries)
+		}
+	}
+}
+
+func (fs *diskCacheFs) startUpload(upload *diskCachePendingUpload) bool {
+	if !fs.store.startUpload(upload) {
+		return false
+	}
+	fs.uploads.Add(1)
+	fs.pending.Add(1)
+	return true
+}
+
+// waitUploads waits for the pending write-back uploads for the specified path
+// and, if tree is true, for its contents. Failed uploads are retried once, an
+// error is returned if they fail again
+func (fs *diskCacheFs) waitUploads(name string, tree bool) error {
+	for _, upload := range fs.store.getUploads(fs.getKey(name), tree) {
+		if err := fs.store.waitUpload(upload); err == nil {
+			continue
+		}
+		if fs.startUpload(upload) {
+			fs.upload(upload, 1)
+		}
+		if err := fs.store.waitUpload(upload); err != nil {
+			fsLog(fs, logger.LevelWarn, "upload from the disk cache not completed, path: %q: %v", upload.name, err)
+			return fmt.Errorf("upload of %q from the disk cache not completed: %w", upload.name, err)
+		}
+	}
+	return nil
+}
+
+// discardUpload waits for the pending write-back upload for the specified path,
+// if any, and discards it if it is failed. It returns true if an upload was discarded
+func (fs *diskCacheFs) discardUpload(name string) bool {
+	upload := fs.store.getUpload(fs.getKey(name))
+	if upload == nil {
+		return false
+	}
+	if err := fs.store.waitUpload(upload); err == nil {
+		return false
+	}
+	return fs.store.discardUpload(upload)
+}
+
+func (fs *diskCacheFs) isWriteBackAllowed(flag int) bool {
+	if !fs.store.writeBack || flag == -1 || flag&os.O_APPEND != 0 {
+		return false
+	}
+	// without O_TRUNC the upload may resume an interrupted one
+	return !fs.Fs.IsUploadResumeSupported() || flag&os.O_TRUNC != 0
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *diskCacheFs) Stat(name string) (os.FileInfo, error) {
+	if upload := fs.store.getUpload(fs.getKey(name)); upload != nil {
+		return NewFileInfo(name, false, upload.size, upload.modTime, false), nil
+	}
+	return fs.Fs.Stat(name)
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *diskCacheFs) Lstat(name string) (os.FileInfo, error) {
+	if upload := fs.store.getUpload(fs.getKey(name)); upload != nil {
+		return NewFileInfo(name, false, upload.size, upload.modTime, false), nil
+	}
+	return fs.Fs.Lstat(name)
+}
+
+// Open opens the named file for reading
+func (fs *diskCacheFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	key := fs.getKey(name)
+	if upload := fs.store.getUpload(key); upload != nil {
+		if f, err := os.Open(upload.path); err == nil {
+			return fs.openLocalFile(name, f, offset, nil)
+		}
+	}
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if info.IsDir() || !fs.store.isCacheable(info.Size()) {
+		return fs.Fs.Open(name, offset)
+	}
+	if entry := fs.store.get(key, info); entry != nil {
+		f, err := os.Open(entry.path)
+		if err == nil {
+			return fs.openLocalFile(name, f, offset, func() {
+				fs.store.release(entry)
+			})
+		}
+		fsLog(fs, logger.LevelWarn, "unable to open cached file for %q: %v", name, err)
+		fs.store.release(entry)
+		fs.store.remove(key, false)
+	}
+	if offset > 0 {
+		return fs.Fs.Open(name, offset)
+	}
+	return fs.openAndCache(name, key, info)
+}
+
+// openLocalFile returns the local file, so reads are served directly from the
+// disk, also with random access. Closing the returned file releases the cache
+// entry, if any
+func (fs *diskCacheFs) openLocalFile(name string, f *os.File, offset int64, releaseFn func(),
+) (File, *pipeat.PipeReaderAt, func(), error) {
+	file := &diskCacheFile{
+		File:      f,
+		name:      name,
+		releaseFn: releaseFn,
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, nil, nil, err
+		}
+	}
+	fsLog(fs, logger.LevelDebug, "serving %q from the disk cache, offset: %d", name, offset)
+	return file, nil, nil, nil
+}
+
+// openAndCache downloads the named file and stores it in the cache while it is
+// sent to the client. The cache is not populated if the client aborts the download
+func (fs *diskCacheFs) openAndCache(name, key string, info os.FileInfo) (File, *pipeat.PipeReaderAt, func(), error) {
+	f, reader, cancelFn, err := fs.Fs.Open(name, 0)
+	if err != nil || f != nil || reader == nil {
+		return f, reader, cancelFn, err
+	}
+	cached, err := fs.store.createFile(fs.store.getDataDir())
+	if err != nil {
+		fsLog(fs, logger.LevelWarn, "unable to create disk cache file for %q: %v", name, err)
+		return nil, reader, cancelFn, nil
+	}
+	r, w, err := pipeat.PipeInDir(fs.store.getDataDir())
+	if err != nil {
+		cached.Close()
+		os.Remove(cached.Name()) //nolint:errcheck
+		return nil, reader, cancelFn, nil
+	}
+
+	go func() {
+		n, err := io.Copy(io.MultiWriter(w, cached), reader)
+		reader.Close()
+		errClose := cached.Close()
+		if err == nil && errClose == nil && n == info.Size() {
+			fs.store.add(key, cached.Name(), info)
+		} else {
+			os.Remove(cached.Name()) //nolint:errcheck
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed and cached, path: %q size: %d, err: %v", name, n, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *diskCacheFs) Create(name string, flag, checks int) (File, *PipeWriter, func(), error) {
+	if fs.isWriteBackAllowed(flag) {
+		// a failed upload is overwritten by the new one
+		fs.discardUpload(name)
+	} else if err := fs.waitUploads(name, false); err != nil {
+		return nil, nil, nil, err
+	}
+	fs.store.remove(fs.getKey(name), false)
+	if fs.isWriteBackAllowed(flag) {
+		return fs.createWriteBack(name, checks)
+	}
+	return fs.Fs.Create(name, flag, checks)
+}
+
+// createWriteBack stores the uploaded file on the local disk, the client upload
+// completes as soon as the file is stored and then the file is uploaded to the
+// remote filesystem. The stored file is visible until it is uploaded and
+// failed uploads are retried, also after a restart
+func (fs *diskCacheFs) createWriteBack(name string, checks int) (File, *PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		if _, err := fs.Stat(path.Dir(name)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	staged, err := fs.store.createFile(fs.store.getUploadsDir())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.store.getDataDir())
+	if err != nil {
+		staged.Close()
+		os.Remove(staged.Name()) //nolint:errcheck
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		n, err := io.Copy(staged, &contextReader{ctx: ctx, r: r})
+		if errClose := staged.Close(); err == nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		if err == nil {
+			var upload *diskCachePendingUpload
+			upload, err = fs.store.addUpload(fs, fs.namespace, fs.getKey(name), name, staged.Name(), n)
+			if err == nil {
+				fs.uploads.Add(1)
+				fs.pending.Add(1)
+				p.Done(nil)
+				fsLog(fs, logger.LevelDebug, "upload stored in the disk cache, path: %q, size: %d", name, n)
+				fs.upload(upload, diskCacheUploadRetries)
+				return
+			}
+		}
+		os.Remove(staged.Name()) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "unable to store upload in the disk cache, path: %q, err: %v", name, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// upload uploads a file stored on the local disk to the remote filesystem
+func (fs *diskCacheFs) upload(upload *diskCachePendingUpload, attempts int) {
+	defer func() {
+		fs.pending.Add(-1)
+		fs.uploads.Done()
+	}()
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*attempt) * time.Second)
+		}
+		if err = fs.uploadFile(upload); err == nil {
+			break
+		}
+		fsLog(fs, logger.LevelWarn, "unable to upload %q from the disk cache, attempt %d: %v",
+			upload.name, attempt+1, err)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, _ = fs.Fs.Stat(upload.name)
+		fsLog(fs, logger.LevelDebug, "upload from the disk cache completed, path: %q, size: %d", upload.name, upload.size)
+	}
+	fs.store.uploadCompleted(upload, info, err)
+}
+
+func (fs *diskCacheFs) uploadFile(upload *diskCachePendingUpload) error {
+	src, err := os.Open(upload.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, w, cancelFn, err := fs.Fs.Create(upload.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	if f != nil {
+		_, err = io.Copy(f, src)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		return err
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		w.Close() //nolint:errcheck
+		return err
+	}
+	return w.Close()
+}
+
+// Rename renames (moves) source to target
+func (fs *diskCacheFs) Rename(source, target string) (int, int64, error) {
+	if err := fs.waitUploads(source, true); err != nil {
+		return -1, -1, err
+	}
+	if err := fs.waitUploads(target, true); err != nil {
+		return -1, -1, err
+	}
+	defer func() {
+		fs.store.remove(fs.getKey(source), true)
+		fs.store.remove(fs.getKey(target), true)
+	}()
+
+	return fs.Fs.Rename(source, target)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *diskCacheFs) Remove(name string, isDir bool) error {
+	if isDir {
+		if err := fs.waitUploads(name, true); err != nil {
+			return err
+		}
+	}
+	defer fs.store.remove(fs.getKey(name), isDir)
+
+	// a failed upload is discarded, the file may not exist on the remote filesystem
+	discarded := !isDir && fs.discardUpload(name)
+	err := fs.Fs.Remove(name, isDir)
+	if discarded && fs.Fs.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *diskCacheFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	if err := fs.waitUploads(name, false); err != nil {
+		return err
+	}
+	defer fs.store.remove(fs.getKey(name), false)
+
+	return fs.Fs.Chtimes(name, atime, mtime, isUploading)
+}
+
+// Truncate changes the size of the named file.
+func (fs *diskCacheFs) Truncate(name string, size int64) error {
+	if err := fs.waitUploads(name, false); err != nil {
+		return err
+	}
+	defer fs.store.remove(fs.getKey(name), false)
+
+	return fs.Fs.Truncate(name, size)
+}
+
+// ReadDir returns a DirLister for the specified directory, the files not yet
+// uploaded are included
+func (fs *diskCacheFs) ReadDir(dirname string) (DirLister, error) {
+	lister, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return lister, err
+	}
+	dirname = getDiskCacheDir(dirname)
+	uploads := make(map[string]*diskCachePendingUpload)
+	for _, upload := range fs.store.getNamespaceUploads(fs.namespace, false) {
+		if getDiskCacheDir(path.Dir(upload.name)) == dirname {
+			uploads[path.Base(upload.name)] = upload
+		}
+	}
+	if len(uploads) == 0 {
+		return lister, nil
+	}
+	return &diskCacheDirLister{
+		DirLister: lister,
+		uploads:   uploads,
+	}, nil
+}
+
+// GetMimeType returns the content type
+func (fs *diskCacheFs) GetMimeType(name string) (string, error) {
+	if upload := fs.store.getUpload(fs.getKey(name)); upload != nil {
+		return mime.TypeByExtension(path.Ext(name)), nil
+	}
+	return fs.Fs.GetMimeType(name)
+}
+
+func (fs *diskCacheFs) cleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	return CleanupAbandonedUploads(fs.Fs, olderThan)
+}
+
+// Close closes the fs. If there are pending uploads the wrapped
+// filesystem is closed after completing them
+func (fs *diskCacheFs) Close() error {
+	if fs.pending.Load() == 0 {
+		return fs.Fs.Close()
+	}
+	go func() {
+		fs.uploads.Wait()
+		fs.Fs.Close()
+	}()
+	return nil
+}
+
+// diskCacheFileCopierFs is a diskCacheFs wrapping a Fs that implements FsFileCopier
+type diskCacheFileCopierFs struct {
+	*diskCacheFs
+}
+
+// CopyFile implements the FsFileCopier interface
+func (fs *diskCacheFileCopierFs) CopyFile(source, target string, srcSize int64) error {
+	if err := fs.waitUploads(source, false); err != nil {
+		return err
+	}
+	if err := fs.waitUploads(target, false); err != nil {
+		return err
+	}
+	defer fs.store.remove(fs.getKey(target), false)
+
+	return fs.Fs.(FsFileCopier).CopyFile(source, target, srcSize)
+}
+
+// diskCacheRealPatherFs is a diskCacheFs wrapping a Fs that implements FsRealPather
+type diskCacheRealPatherFs struct {
+	*diskCacheFs
+}
+
+// RealPath implements the FsRealPather interface
+func (fs *diskCacheRealPatherFs) RealPath(p string) (string, error) {
+	return fs.Fs.(FsRealPather).RealPath(p)
+}
+
+// diskCacheDirLister adds the pending write-back uploads to a directory listing
+type diskCacheDirLister struct {
+	DirLister
+	uploads map[string]*diskCachePendingUpload
+}
+
+func (l *diskCacheDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.DirLister.Next(limit)
+	for idx, info := range files {
+		if upload, ok := l.uploads[info.Name()]; ok {
+			files[idx] = NewFileInfo(info.Name(), false, upload.size, upload.modTime, false)
+			delete(l.uploads, info.Name())
+		}
+	}
+	if err == io.EOF {
+		for name, upload := range l.uploads {
+			files = append(files, NewFileInfo(name, false, upload.size, upload.modTime, false))
+		}
+		l.uploads = nil
+	}
+	return files, err
+}
+
+// diskCacheFile is a local file served from the disk cache
+type diskCacheFile struct {
+	*os.File
+	name      string
+	releaseFn func()
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Name returns the name of the remote file
+func (f *diskCacheFile) Name() string {
+	return f.name
+}
+
+// Close closes the local file and releases the cache entry, so it can be evicted
+func (f *diskCacheFile) Close() error {
+	f.closeOnce.Do(func() {
+		f.closeErr = f.File.Close()
+		if f.releaseFn != nil {
+			f.releaseFn()
+		}
+	})
+	return f.closeErr
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func getDiskCacheDir(name string) string {
+	if name == "." || name == "" {
+		return ""
+	}
+	if name != "/" {
+		name = strings.TrimSuffix(name, "/")
+	}
+	return name
+}
@@ -0,0 +1,460 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/minio/sio"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// sio splits the data in packages with a 64KiB payload, each package
+	// has a 16 bytes header and a 16 bytes authentication tag
+	sioPayloadSize int64 = 64 * 1024
+	sioPackageSize int64 = sioPayloadSize + 32
+)
+
+// EncryptionConfig defines the configuration to encrypt the files stored on a
+// remote storage backend. Encryption is disabled if the passphrase is empty
+type EncryptionConfig struct {
+	Passphrase *kms.Secret `json:"passphrase,omitempty"`
+}
+
+// IsEnabled returns true if the encryption is enabled
+func (c *EncryptionConfig) IsEnabled() bool {
+	return c.Passphrase != nil && !c.Passphrase.IsEmpty()
+}
+
+// HideConfidentialData hides confidential data
+func (c *EncryptionConfig) HideConfidentialData() {
+	if c.Passphrase != nil {
+		c.Passphrase.Hide()
+	}
+}
+
+func (c *EncryptionConfig) setEmptySecretsIfNil() {
+	if c.Passphrase == nil {
+		c.Passphrase = kms.NewEmptySecret()
+	}
+}
+
+func (c *EncryptionConfig) setNilSecretsIfEmpty() {
+	if c.Passphrase != nil && c.Passphrase.IsEmpty() {
+		c.Passphrase = nil
+	}
+}
+
+func (c *EncryptionConfig) isEqual(other EncryptionConfig) bool {
+	c.setEmptySecretsIfNil()
+	other.setEmptySecretsIfNil()
+	return c.Passphrase.IsEqual(other.Passphrase)
+}
+
+func (c *EncryptionConfig) getACopy() EncryptionConfig {
+	c.setEmptySecretsIfNil()
+	return EncryptionConfig{
+		Passphrase: c.Passphrase.Clone(),
+	}
+}
+
+func (c *EncryptionConfig) validate() error {
+	c.setEmptySecretsIfNil()
+	if c.Passphrase.IsEmpty() {
+		return nil
+	}
+	if !c.Passphrase.IsValidInput() {
+		return errors.New("invalid encryption passphrase")
+	}
+	if c.Passphrase.IsEncrypted() && !c.Passphrase.IsValid() {
+		return errors.New("invalid encrypted encryption passphrase")
+	}
+	return nil
+}
+
+// validateAndEncrypt validates the configuration and encrypts the passphrase
+// if it is in plain text
+func (c *EncryptionConfig) validateAndEncrypt(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate encryption config: %v", err))
+	}
+	if c.Passphrase.IsPlain() {
+		c.Passphrase.SetAdditionalData(additionalData)
+		if err := c.Passphrase.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt the encryption passphrase: %v", err))
+		}
+	}
+	return nil
+}
+
+// WrapFs wraps the filesystem, as returned by one of the filesystem
+// constructors, with an encryption layer if a passphrase is configured.
+// The files are encrypted using the same format as CryptFs
+func (c *EncryptionConfig) WrapFs(fs Fs, err error) (Fs, error) {
+	if err != nil || !c.IsEnabled() {
+		return fs, err
+	}
+	if err := c.Passphrase.TryDecrypt(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	localTempDir := tempPath
+	if localTempDir == "" {
+		localTempDir = filepath.Clean(os.TempDir())
+	}
+	encFs := &encryptedFs{
+		Fs:           fs,
+		localTempDir: localTempDir,
+		masterKey:    []byte(c.Passphrase.GetPayload()),
+	}
+	switch fs.(type) {
+	case FsFileCopier:
+		return &encryptedFileCopierFs{encFs}, nil
+	case FsRealPather:
+		return &encryptedRealPatherFs{encFs}, nil
+	}
+	return encFs, nil
+}
+
+// IsEncryptedFs returns true if fs stores the files encrypted, locally or
+// on a remote storage backend
+func IsEncryptedFs(fs Fs) bool {
+	switch fs.(type) {
+	case *encryptedFs, *encryptedFileCopierFs, *encryptedRealPatherFs:
+		return true
+	}
+	return IsCryptOsFs(fs)
+}
+
+// encryptedFs wraps a remote filesystem and encrypts/decrypts the files
+// on-the-fly. The sizes reported by the wrapped filesystem are converted to
+// the plaintext sizes
+type encryptedFs struct {
+	Fs
+	localTempDir string
+	masterKey    []byte
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *encryptedFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return convertEncryptedFileInfo(info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *encryptedFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return convertEncryptedFileInfo(info), nil
+}
+
+// Open opens the named file for reading
+func (fs *encryptedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if offset == info.Size() {
+		return fs.openEmpty(name)
+	}
+	// decryption starts at the package containing the requested offset
+	seqNum := offset / sioPayloadSize
+	src, cancelFn, key, err := fs.openAtPackage(name, seqNum)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		cancelFn()
+		src.Close()
+		return nil, nil, nil, err
+	}
+
+	go func() {
+		config := getSIOConfig(key)
+		config.SequenceNumber = uint32(seqNum)
+		var n int64
+		decrypted, err := sio.DecryptReader(src, config)
+		if err == nil {
+			if _, err = io.CopyN(io.Discard, decrypted, offset%sioPayloadSize); err == nil {
+				n, err = io.Copy(w, decrypted)
+			}
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		src.Close()
+		fsLog(fs, logger.LevelDebug, "encrypted download completed, path: %q size: %v, err: %v", name, n, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+func (fs *encryptedFs) openEmpty(name string) (File, *pipeat.PipeReaderAt, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	go func() {
+		// CloseWithError waits for the reader to be attached
+		w.CloseWithError(nil) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "zero bytes download completed, path: %q", name)
+	}()
+	return nil, r, nil, nil
+}
+
+// Create creates or opens the named file for writing.
+// The existing files are always overwritten, appending is not supported
+func (fs *encryptedFs) Create(name string, flag, checks int) (File, *PipeWriter, func(), error) {
+	if flag == -1 {
+		return fs.Fs.Create(name, flag, checks)
+	}
+	header, key, err := newEncryptedFileHeader(fs.masterKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, dst, cancelFn, err := fs.Fs.Create(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, checks&^CheckResume)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var target io.WriteCloser = dst
+	if f != nil {
+		target = f
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		target.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		var n int64
+		err := header.Store(target)
+		if err == nil {
+			n, err = sio.Encrypt(target, r, getSIOConfig(key))
+		}
+		if err != nil && cancelFn != nil {
+			// abort the upload on the wrapped filesystem, if supported
+			cancelFn()
+		}
+		errClose := target.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "encrypted upload completed, path: %q, readed bytes: %v, err: %v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// The number of files and the size are not returned, the wrapped filesystem
+// reports the encrypted sizes, so the caller will get the plaintext ones
+func (fs *encryptedFs) Rename(source, target string) (int, int64, error) {
+	_, _, err := fs.Fs.Rename(source, target)
+	return -1, -1, err
+}
+
+// Truncate changes the size of the named file
+func (*encryptedFs) Truncate(_ string, _ int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir returns a DirLister for the directory named by dirname
+func (fs *encryptedFs) ReadDir(dirname string) (DirLister, error) {
+	lister, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return lister, err
+	}
+	return &encryptedFsDirLister{lister}, nil
+}
+
+// IsUploadResumeSupported returns false, sio does not support random access writes
+func (*encryptedFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their plaintext size
+func (fs *encryptedFs) ScanRootDirContents() (int, int64, error) {
+	root, err := fs.ResolvePath("/")
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.GetDirSize(root)
+}
+
+// GetDirSize returns the number of files and the plaintext size for a folder
+// including any subfolders
+func (fs *encryptedFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	err := fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info != nil && info.Mode().IsRegular() {
+			numFiles++
+			size += info.Size()
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The reported sizes are the plaintext ones
+func (fs *encryptedFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.Fs.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if info != nil {
+			info = convertEncryptedFileInfo(info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type detected from the decrypted contents
+func (fs *encryptedFs) GetMimeType(name string) (string, error) {
+	_, r, cancelFn, err := fs.Open(name, 0)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		r.Close()
+	}()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func (fs *encryptedFs) cleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	return CleanupAbandonedUploads(fs.Fs, olderThan)
+}
+
+// openRaw opens the named file, as stored on the wrapped filesystem, starting
+// from the specified offset
+func (fs *encryptedFs) openRaw(name string, offset int64) (io.ReadCloser, func(), error) {
+	f, r, cancelFn, err := fs.Fs.Open(name, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	if f != nil {
+		return f, cancelFn, nil
+	}
+	return r, cancelFn, nil
+}
+
+// openAtPackage reads the header for the named file and returns the raw
+// contents starting from the specified sio package and the encryption key
+func (fs *encryptedFs) openAtPackage(name string, seqNum int64) (io.ReadCloser, func(), [32]byte, error) {
+	var key [32]byte
+	src, cancelFn, err := fs.openRaw(name, 0)
+	if err != nil {
+		return nil, nil, key, err
+	}
+	header := encryptedFileHeader{}
+	err = header.Load(src)
+	if err == nil {
+		key, err = header.deriveKey(fs.masterKey)
+	}
+	if err != nil || seqNum > 0 {
+		cancelFn()
+		src.Close()
+	}
+	if err != nil || seqNum == 0 {
+		return src, cancelFn, key, err
+	}
+	src, cancelFn, err = fs.openRaw(name, headerV10Size+seqNum*sioPackageSize)
+	return src, cancelFn, key, err
+}
+
+// encryptedFileCopierFs is an encryptedFs wrapping a Fs that implements FsFileCopier
+type encryptedFileCopierFs struct {
+	*encryptedFs
+}
+
+// CopyFile implements the FsFileCopier interface. The copied file is
+// encrypted with the same key, it is stored along with the file
+func (fs *encryptedFileCopierFs) CopyFile(source, target string, _ int64) error {
+	info, err := fs.Fs.Stat(source)
+	if err != nil {
+		return err
+	}
+	return fs.Fs.(FsFileCopier).CopyFile(source, target, info.Size())
+}
+
+// encryptedRealPatherFs is an encryptedFs wrapping a Fs that implements FsRealPather
+type encryptedRealPatherFs struct {
+	*encryptedFs
+}
+
+// RealPath implements the FsRealPather interface
+func (fs *encryptedRealPatherFs) RealPath(p string) (string, error) {
+	return fs.Fs.(FsRealPather).RealPath(p)
+}
+
+type encryptedFsDirLister struct {
+	DirLister
+}
+
+func (l *encryptedFsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.DirLister.Next(limit)
+	for idx := range files {
+		files[idx] = convertEncryptedFileInfo(files[idx])
+	}
+	return files, err
+}
+
+// convertEncryptedFileInfo returns a FileInfo with the plaintext size,
+// the other attributes are preserved
+func convertEncryptedFileInfo(info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	fi := NewFileInfo(info.Name(), false, getDecryptedSize(info.Size()), info.ModTime(), true)
+	fi.SetMode(info.Mode())
+	fi.SetETag(getFileInfoETag(info))
+	return fi
+}
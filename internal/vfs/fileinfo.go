@@ -26,6 +26,7 @@ type FileInfo struct {
 	sizeInBytes int64
 	modTime     time.Time
 	mode        os.FileMode
+	etag        string
 }
 
 // NewFileInfo creates file info.
@@ -77,7 +78,25 @@ func (fi *FileInfo) SetMode(mode os.FileMode) {
 	fi.mode = mode
 }
 
+// ETag returns the entity tag for the object, if available
+func (fi *FileInfo) ETag() string {
+	return fi.etag
+}
+
+// SetETag sets the entity tag
+func (fi *FileInfo) SetETag(etag string) {
+	fi.etag = etag
+}
+
 // Sys provides the underlying data source (can return nil)
 func (fi *FileInfo) Sys() any {
 	return nil
 }
+
+// getFileInfoETag returns the entity tag for the specified FileInfo, if available
+func getFileInfoETag(info os.FileInfo) string {
+	if fi, ok := info.(interface{ ETag() string }); ok {
+		return fi.ETag()
+	}
+	return ""
+}
@@ -32,6 +32,10 @@ type Filesystem struct {
 	CryptConfig    CryptFsConfig          `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig           `json:"sftpconfig,omitempty"`
 	HTTPConfig     HTTPFsConfig           `json:"httpconfig,omitempty"`
+	WebDAVConfig   WebDAVFsConfig         `json:"webdavconfig,omitempty"`
+	FTPConfig      FTPFsConfig            `json:"ftpconfig,omitempty"`
+	SMBConfig      SMBFsConfig            `json:"smbconfig,omitempty"`
+	OverlayConfig  OverlayFsConfig        `json:"overlayconfig,omitempty"`
 }
 
 // SetEmptySecrets sets the secrets to empty
@@ -46,6 +50,13 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.SFTPConfig.KeyPassphrase = kms.NewEmptySecret()
 	f.HTTPConfig.Password = kms.NewEmptySecret()
 	f.HTTPConfig.APIKey = kms.NewEmptySecret()
+	f.WebDAVConfig.Password = kms.NewEmptySecret()
+	f.FTPConfig.Password = kms.NewEmptySecret()
+	f.SMBConfig.Password = kms.NewEmptySecret()
+	f.S3Config.Encryption.Passphrase = kms.NewEmptySecret()
+	f.GCSConfig.Encryption.Passphrase = kms.NewEmptySecret()
+	f.AzBlobConfig.Encryption.Passphrase = kms.NewEmptySecret()
+	f.SFTPConfig.Encryption.Passphrase = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -80,6 +91,19 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.HTTPConfig.APIKey == nil {
 		f.HTTPConfig.APIKey = kms.NewEmptySecret()
 	}
+	if f.WebDAVConfig.Password == nil {
+		f.WebDAVConfig.Password = kms.NewEmptySecret()
+	}
+	if f.FTPConfig.Password == nil {
+		f.FTPConfig.Password = kms.NewEmptySecret()
+	}
+	if f.SMBConfig.Password == nil {
+		f.SMBConfig.Password = kms.NewEmptySecret()
+	}
+	f.S3Config.Encryption.setEmptySecretsIfNil()
+	f.GCSConfig.Encryption.setEmptySecretsIfNil()
+	f.AzBlobConfig.Encryption.setEmptySecretsIfNil()
+	f.SFTPConfig.Encryption.setEmptySecretsIfNil()
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -101,8 +125,14 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.CryptConfig.Passphrase != nil && f.CryptConfig.Passphrase.IsEmpty() {
 		f.CryptConfig.Passphrase = nil
 	}
+	f.S3Config.Encryption.setNilSecretsIfEmpty()
+	f.GCSConfig.Encryption.setNilSecretsIfEmpty()
+	f.AzBlobConfig.Encryption.setNilSecretsIfEmpty()
 	f.SFTPConfig.setNilSecretsIfEmpty()
 	f.HTTPConfig.setNilSecretsIfEmpty()
+	f.WebDAVConfig.setNilSecretsIfEmpty()
+	f.FTPConfig.setNilSecretsIfEmpty()
+	f.SMBConfig.setNilSecretsIfEmpty()
 }
 
 // IsEqual returns true if the fs is equal to other
@@ -123,6 +153,14 @@ func (f *Filesystem) IsEqual(other Filesystem) bool {
 		return f.SFTPConfig.isEqual(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isEqual(other.HTTPConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isEqual(other.WebDAVConfig)
+	case FTPFilesystemProvider:
+		return f.FTPConfig.isEqual(other.FTPConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isEqual(other.SMBConfig)
+	case OverlayFilesystemProvider:
+		return f.OverlayConfig.isEqual(other.OverlayConfig)
 	default:
 		return true
 	}
@@ -146,6 +184,14 @@ func (f *Filesystem) IsSameResource(other Filesystem) bool {
 		return f.SFTPConfig.isSameResource(other.SFTPConfig)
 	case sdk.HTTPFilesystemProvider:
 		return f.HTTPConfig.isSameResource(other.HTTPConfig)
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.isSameResource(other.WebDAVConfig)
+	case FTPFilesystemProvider:
+		return f.FTPConfig.isSameResource(other.FTPConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isSameResource(other.SMBConfig)
+	case OverlayFilesystemProvider:
+		return f.OverlayConfig.isEqual(other.OverlayConfig)
 	default:
 		return true
 	}
@@ -154,7 +200,7 @@ func (f *Filesystem) IsSameResource(other Filesystem) bool {
 // GetPathSeparator returns the path separator
 func (f *Filesystem) GetPathSeparator() string {
 	switch f.Provider {
-	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
+	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider, OverlayFilesystemProvider:
 		return string(os.PathSeparator)
 	default:
 		return "/"
@@ -174,6 +220,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	case sdk.GCSFilesystemProvider:
 		if err := f.GCSConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -184,6 +234,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	case sdk.AzureBlobFilesystemProvider:
 		if err := f.AzBlobConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -194,6 +248,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	case sdk.CryptedFilesystemProvider:
 		if err := f.CryptConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -204,6 +262,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	case sdk.SFTPFilesystemProvider:
 		if err := f.SFTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -214,6 +276,10 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	case sdk.HTTPFilesystemProvider:
 		if err := f.HTTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
@@ -224,6 +290,66 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.AzBlobConfig = AzBlobFsConfig{}
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
+		return nil
+	case WebDAVFilesystemProvider:
+		if err := f.WebDAVConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
+		return nil
+	case FTPFilesystemProvider:
+		if err := f.FTPConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
+		return nil
+	case SMBFilesystemProvider:
+		if err := f.SMBConfig.ValidateAndEncryptCredentials(additionalData); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
+		return nil
+	case OverlayFilesystemProvider:
+		if err := f.OverlayConfig.Validate(); err != nil {
+			return err
+		}
+		f.S3Config = S3FsConfig{}
+		f.GCSConfig = GCSFsConfig{}
+		f.AzBlobConfig = AzBlobFsConfig{}
+		f.CryptConfig = CryptFsConfig{}
+		f.SFTPConfig = SFTPFsConfig{}
+		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
 		return nil
 	default:
 		f.Provider = sdk.LocalFilesystemProvider
@@ -233,12 +359,44 @@ func (f *Filesystem) Validate(additionalData string) error {
 		f.CryptConfig = CryptFsConfig{}
 		f.SFTPConfig = SFTPFsConfig{}
 		f.HTTPConfig = HTTPFsConfig{}
+		f.WebDAVConfig = WebDAVFsConfig{}
+		f.FTPConfig = FTPFsConfig{}
+		f.SMBConfig = SMBFsConfig{}
+		f.OverlayConfig = OverlayFsConfig{}
 		return nil
 	}
 }
 
+// GetEncryptionConfig returns the encryption configuration for the
+// configured provider or nil if encryption is not supported
+func (f *Filesystem) GetEncryptionConfig() *EncryptionConfig {
+	switch f.Provider {
+	case sdk.S3FilesystemProvider:
+		return &f.S3Config.Encryption
+	case sdk.GCSFilesystemProvider:
+		return &f.GCSConfig.Encryption
+	case sdk.AzureBlobFilesystemProvider:
+		return &f.AzBlobConfig.Encryption
+	case sdk.SFTPFilesystemProvider:
+		return &f.SFTPConfig.Encryption
+	}
+	return nil
+}
+
+// GetEncryptionPassphrase returns the encryption passphrase for the
+// configured provider, an empty secret if encryption is not supported
+func (f *Filesystem) GetEncryptionPassphrase() *kms.Secret {
+	if config := f.GetEncryptionConfig(); config != nil && config.Passphrase != nil {
+		return config.Passphrase
+	}
+	return kms.NewEmptySecret()
+}
+
 // HasRedactedSecret returns true if configured the filesystem configuration has a redacted secret
 func (f *Filesystem) HasRedactedSecret() bool {
+	if config := f.GetEncryptionConfig(); config != nil && config.Passphrase != nil && config.Passphrase.IsRedacted() {
+		return true
+	}
 	// TODO move vfs specific code into each *FsConfig struct
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
@@ -265,11 +423,52 @@ func (f *Filesystem) HasRedactedSecret() bool {
 			return true
 		}
 		return f.HTTPConfig.APIKey.IsRedacted()
+	case WebDAVFilesystemProvider:
+		return f.WebDAVConfig.Password.IsRedacted()
+	case FTPFilesystemProvider:
+		return f.FTPConfig.Password.IsRedacted()
+	case SMBFilesystemProvider:
+		return f.SMBConfig.Password.IsRedacted()
 	}
 
 	return false
 }
 
+// GetSecrets returns the secrets for the configured provider
+func (f *Filesystem) GetSecrets() []*kms.Secret {
+	var secrets []*kms.Secret
+	switch f.Provider {
+	case sdk.S3FilesystemProvider:
+		secrets = append(secrets, f.S3Config.AccessSecret)
+	case sdk.GCSFilesystemProvider:
+		secrets = append(secrets, f.GCSConfig.Credentials)
+	case sdk.AzureBlobFilesystemProvider:
+		secrets = append(secrets, f.AzBlobConfig.AccountKey, f.AzBlobConfig.SASURL)
+	case sdk.CryptedFilesystemProvider:
+		secrets = append(secrets, f.CryptConfig.Passphrase)
+	case sdk.SFTPFilesystemProvider:
+		secrets = append(secrets, f.SFTPConfig.Password, f.SFTPConfig.PrivateKey, f.SFTPConfig.KeyPassphrase)
+	case sdk.HTTPFilesystemProvider:
+		secrets = append(secrets, f.HTTPConfig.Password, f.HTTPConfig.APIKey)
+	case WebDAVFilesystemProvider:
+		secrets = append(secrets, f.WebDAVConfig.Password)
+	case FTPFilesystemProvider:
+		secrets = append(secrets, f.FTPConfig.Password)
+	case SMBFilesystemProvider:
+		secrets = append(secrets, f.SMBConfig.Password)
+	}
+	if config := f.GetEncryptionConfig(); config != nil {
+		secrets = append(secrets, config.Passphrase)
+	}
+	result := make([]*kms.Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != nil {
+			result = append(result, secret)
+		}
+	}
+	return result
+}
+
 // HideConfidentialData hides filesystem confidential data
 func (f *Filesystem) HideConfidentialData() {
 	switch f.Provider {
@@ -285,6 +484,12 @@ func (f *Filesystem) HideConfidentialData() {
 		f.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		f.HTTPConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		f.WebDAVConfig.HideConfidentialData()
+	case FTPFilesystemProvider:
+		f.FTPConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		f.SMBConfig.HideConfidentialData()
 	}
 }
 
@@ -311,7 +516,9 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartMaxTime:   f.S3Config.UploadPartMaxTime,
 				ForcePathStyle:      f.S3Config.ForcePathStyle,
 			},
-			AccessSecret: f.S3Config.AccessSecret.Clone(),
+			AccessSecret:  f.S3Config.AccessSecret.Clone(),
+			MetadataCache: f.S3Config.MetadataCache,
+			Encryption:    f.S3Config.Encryption.getACopy(),
 		},
 		GCSConfig: GCSFsConfig{
 			BaseGCSFsConfig: sdk.BaseGCSFsConfig{
@@ -323,7 +530,9 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UploadPartSize:       f.GCSConfig.UploadPartSize,
 				UploadPartMaxTime:    f.GCSConfig.UploadPartMaxTime,
 			},
-			Credentials: f.GCSConfig.Credentials.Clone(),
+			Credentials:   f.GCSConfig.Credentials.Clone(),
+			MetadataCache: f.GCSConfig.MetadataCache,
+			Encryption:    f.GCSConfig.Encryption.getACopy(),
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			BaseAzBlobFsConfig: sdk.BaseAzBlobFsConfig{
@@ -338,8 +547,10 @@ func (f *Filesystem) GetACopy() Filesystem {
 				UseEmulator:         f.AzBlobConfig.UseEmulator,
 				AccessTier:          f.AzBlobConfig.AccessTier,
 			},
-			AccountKey: f.AzBlobConfig.AccountKey.Clone(),
-			SASURL:     f.AzBlobConfig.SASURL.Clone(),
+			AccountKey:    f.AzBlobConfig.AccountKey.Clone(),
+			SASURL:        f.AzBlobConfig.SASURL.Clone(),
+			MetadataCache: f.AzBlobConfig.MetadataCache,
+			Encryption:    f.AzBlobConfig.Encryption.getACopy(),
 		},
 		CryptConfig: CryptFsConfig{
 			Passphrase: f.CryptConfig.Passphrase.Clone(),
@@ -356,6 +567,7 @@ func (f *Filesystem) GetACopy() Filesystem {
 			Password:      f.SFTPConfig.Password.Clone(),
 			PrivateKey:    f.SFTPConfig.PrivateKey.Clone(),
 			KeyPassphrase: f.SFTPConfig.KeyPassphrase.Clone(),
+			Encryption:    f.SFTPConfig.Encryption.getACopy(),
 		},
 		HTTPConfig: HTTPFsConfig{
 			BaseHTTPFsConfig: sdk.BaseHTTPFsConfig{
@@ -367,6 +579,34 @@ func (f *Filesystem) GetACopy() Filesystem {
 			Password: f.HTTPConfig.Password.Clone(),
 			APIKey:   f.HTTPConfig.APIKey.Clone(),
 		},
+		WebDAVConfig: WebDAVFsConfig{
+			Endpoint:          f.WebDAVConfig.Endpoint,
+			Username:          f.WebDAVConfig.Username,
+			SkipTLSVerify:     f.WebDAVConfig.SkipTLSVerify,
+			EqualityCheckMode: f.WebDAVConfig.EqualityCheckMode,
+			Password:          f.WebDAVConfig.Password.Clone(),
+		},
+		FTPConfig: FTPFsConfig{
+			Endpoint:          f.FTPConfig.Endpoint,
+			Username:          f.FTPConfig.Username,
+			Password:          f.FTPConfig.Password.Clone(),
+			TLSMode:           f.FTPConfig.TLSMode,
+			SkipTLSVerify:     f.FTPConfig.SkipTLSVerify,
+			DisableEPSV:       f.FTPConfig.DisableEPSV,
+			DisableMLSD:       f.FTPConfig.DisableMLSD,
+			Prefix:            f.FTPConfig.Prefix,
+			EqualityCheckMode: f.FTPConfig.EqualityCheckMode,
+		},
+		SMBConfig: SMBFsConfig{
+			Endpoint:          f.SMBConfig.Endpoint,
+			Username:          f.SMBConfig.Username,
+			Password:          f.SMBConfig.Password.Clone(),
+			Domain:            f.SMBConfig.Domain,
+			Share:             f.SMBConfig.Share,
+			Prefix:            f.SMBConfig.Prefix,
+			EqualityCheckMode: f.SMBConfig.EqualityCheckMode,
+		},
+		OverlayConfig: f.OverlayConfig.getACopy(),
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
@@ -374,3 +614,49 @@ func (f *Filesystem) GetACopy() Filesystem {
 	}
 	return fs
 }
+
+// GetProviderByName returns the FilesystemProvider matching a given name.
+// The providers not yet defined in the SDK are handled here
+func GetProviderByName(name string) sdk.FilesystemProvider {
+	switch name {
+	case "7", webDAVFsName:
+		return WebDAVFilesystemProvider
+	case "8", ftpFsName:
+		return FTPFilesystemProvider
+	case "9", smbFsName:
+		return SMBFilesystemProvider
+	case "10", overlayFsName:
+		return OverlayFilesystemProvider
+	}
+	return sdk.GetProviderByName(name)
+}
+
+// GetProviderName returns the unique name for the specified provider
+func GetProviderName(p sdk.FilesystemProvider) string {
+	switch p {
+	case WebDAVFilesystemProvider:
+		return webDAVFsName
+	case FTPFilesystemProvider:
+		return ftpFsName
+	case SMBFilesystemProvider:
+		return smbFsName
+	case OverlayFilesystemProvider:
+		return overlayFsName
+	}
+	return p.Name()
+}
+
+// GetProviderShortInfo returns the description for the specified provider
+func GetProviderShortInfo(p sdk.FilesystemProvider) string {
+	switch p {
+	case WebDAVFilesystemProvider:
+		return "WebDAV"
+	case FTPFilesystemProvider:
+		return "FTP/FTPS"
+	case SMBFilesystemProvider:
+		return "SMB/CIFS"
+	case OverlayFilesystemProvider:
+		return "Overlay"
+	}
+	return p.ShortInfo()
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/xid"
 	"github.com/sftpgo/sdk"
@@ -45,6 +46,10 @@ type BaseVirtualFolder struct {
 	Groups []string `json:"groups,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// last update time as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+	// expected version, as updated_at, for optimistic concurrency checks. 0 means no check
+	ExpectedVersion int64 `json:"-"`
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -69,6 +74,7 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		Users:           users,
 		Groups:          v.Groups,
 		FsConfig:        v.FsConfig.GetACopy(),
+		UpdatedAt:       v.UpdatedAt,
 	}
 }
 
@@ -117,6 +123,12 @@ func (v *BaseVirtualFolder) GetStorageDescrition() string {
 		return fmt.Sprintf("SFTP: %s", v.FsConfig.SFTPConfig.Endpoint)
 	case sdk.HTTPFilesystemProvider:
 		return fmt.Sprintf("HTTP: %s", v.FsConfig.HTTPConfig.Endpoint)
+	case WebDAVFilesystemProvider:
+		return fmt.Sprintf("WebDAV: %s", v.FsConfig.WebDAVConfig.Endpoint)
+	case FTPFilesystemProvider:
+		return fmt.Sprintf("FTP: %s", v.FsConfig.FTPConfig.Endpoint)
+	case SMBFilesystemProvider:
+		return fmt.Sprintf("SMB: %s/%s", v.FsConfig.SMBConfig.Endpoint, v.FsConfig.SMBConfig.Share)
 	default:
 		return ""
 	}
@@ -142,6 +154,12 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 		v.FsConfig.SFTPConfig.HideConfidentialData()
 	case sdk.HTTPFilesystemProvider:
 		v.FsConfig.HTTPConfig.HideConfidentialData()
+	case WebDAVFilesystemProvider:
+		v.FsConfig.WebDAVConfig.HideConfidentialData()
+	case FTPFilesystemProvider:
+		v.FsConfig.FTPConfig.HideConfidentialData()
+	case SMBFilesystemProvider:
+		v.FsConfig.SMBConfig.HideConfidentialData()
 	}
 }
 
@@ -170,6 +188,10 @@ func (v *BaseVirtualFolder) hasPathPlaceholder() bool {
 		return strings.Contains(v.FsConfig.AzBlobConfig.KeyPrefix, placeholder)
 	case sdk.SFTPFilesystemProvider:
 		return strings.Contains(v.FsConfig.SFTPConfig.Prefix, placeholder)
+	case FTPFilesystemProvider:
+		return strings.Contains(v.FsConfig.FTPConfig.Prefix, placeholder)
+	case SMBFilesystemProvider:
+		return strings.Contains(v.FsConfig.SMBConfig.Prefix, placeholder)
 	case sdk.LocalFilesystemProvider, sdk.CryptedFilesystemProvider:
 		return strings.Contains(v.MappedPath, placeholder)
 	}
@@ -195,17 +217,27 @@ type VirtualFolder struct {
 func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []string) (Fs, error) {
 	switch v.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
-		return NewS3Fs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.S3Config)
+		return v.FsConfig.S3Config.Encryption.WrapFs(
+			WithDiskCache(NewS3Fs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.S3Config)))
 	case sdk.GCSFilesystemProvider:
-		return NewGCSFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.GCSConfig)
+		return v.FsConfig.GCSConfig.Encryption.WrapFs(
+			WithDiskCache(NewGCSFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.GCSConfig)))
 	case sdk.AzureBlobFilesystemProvider:
-		return NewAzBlobFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.AzBlobConfig)
+		return v.FsConfig.AzBlobConfig.Encryption.WrapFs(
+			WithDiskCache(NewAzBlobFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.AzBlobConfig)))
 	case sdk.CryptedFilesystemProvider:
 		return NewCryptFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.CryptConfig)
 	case sdk.SFTPFilesystemProvider:
-		return NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
+		return v.FsConfig.SFTPConfig.Encryption.WrapFs(
+			WithDiskCache(NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)))
 	case sdk.HTTPFilesystemProvider:
 		return NewHTTPFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.HTTPConfig)
+	case WebDAVFilesystemProvider:
+		return WithDiskCache(NewWebDAVFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.WebDAVConfig))
+	case FTPFilesystemProvider:
+		return WithDiskCache(NewFTPFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.FTPConfig))
+	case SMBFilesystemProvider:
+		return WithDiskCache(NewSMBFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.SMBConfig))
 	default:
 		return NewOsFs(connectionID, v.MappedPath, v.VirtualPath), nil
 	}
@@ -223,6 +255,18 @@ func (v *VirtualFolder) CheckMetadataConsistency() error {
 	return fs.CheckMetadata()
 }
 
+// CleanupAbandonedUploads removes the incomplete uploads, not updated after the
+// specified time, and returns the removed uploads and the quota to release
+func (v *VirtualFolder) CleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	fs, err := v.GetFilesystem(xid.New().String(), nil)
+	if err != nil {
+		return AbandonedUploadsCleanupResult{}, err
+	}
+	defer fs.Close()
+
+	return CleanupAbandonedUploads(fs, olderThan)
+}
+
 // ScanQuota scans the folder and returns the number of files and their size
 func (v *VirtualFolder) ScanQuota() (int, int64, error) {
 	if v.hasPathPlaceholder() {
@@ -0,0 +1,1035 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// ftpFsName is the name for the FTP Fs implementation
+	ftpFsName = "ftpfs"
+	// FTPFilesystemProvider defines the FTP/FTPS storage provider.
+	// The SDK does not define it, so we use the first free value
+	FTPFilesystemProvider sdk.FilesystemProvider = 8
+)
+
+// Supported TLS modes for FTP based filesystems
+const (
+	FTPTLSModeDisabled = iota
+	FTPTLSModeExplicit
+	FTPTLSModeImplicit
+)
+
+const (
+	ftpMaxIdleConns     = 2
+	ftpConnCheckTimeout = 10 * time.Second
+	ftpMLSxTimeFormat   = "20060102150405"
+)
+
+var (
+	errFTPTransferAborted = errors.New("transfer aborted")
+)
+
+// FTPFsConfig defines the configuration for FTP/FTPS based filesystem
+type FTPFsConfig struct {
+	// FTP server address as host:port. If the port is omitted 21 is used, 990
+	// for implicit TLS
+	Endpoint string      `json:"endpoint,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	// TLSMode defines the TLS mode for the control and data connections.
+	// 0 means plain FTP, 1 explicit TLS (AUTH TLS), 2 implicit TLS
+	TLSMode int `json:"tls_mode,omitempty"`
+	// SkipTLSVerify if true, the FTP client accepts any TLS certificate
+	// presented by the server and any host name in that certificate.
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+	// Passive mode is always used. The extended passive mode (EPSV) is tried
+	// first, unless disabled, and PASV is used as fallback
+	DisableEPSV bool `json:"disable_epsv,omitempty"`
+	// MLSD and MLST are used, if supported by the server, to get file
+	// details and directory listings. If disabled LIST is used
+	DisableMLSD bool `json:"disable_mlsd,omitempty"`
+	// Prefix is the path prefix to strip from FTP resource paths.
+	Prefix string `json:"prefix,omitempty"`
+	// Defines how to check if this config points to the same
+	// server as another config. By default both the endpoint and
+	// the username must match. 1 means that only the endpoint must match.
+	// If different configs point to the same server the renaming
+	// between the fs configs is allowed.
+	EqualityCheckMode int `json:"equality_check_mode,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *FTPFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+func (c *FTPFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+func (c *FTPFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *FTPFsConfig) isEqual(other FTPFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.TLSMode != other.TLSMode {
+		return false
+	}
+	if c.SkipTLSVerify != other.SkipTLSVerify {
+		return false
+	}
+	if c.DisableEPSV != other.DisableEPSV {
+		return false
+	}
+	if c.DisableMLSD != other.DisableMLSD {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *FTPFsConfig) isSameResource(other FTPFsConfig) bool {
+	if c.EqualityCheckMode == 0 || other.EqualityCheckMode == 0 {
+		if c.Username != other.Username {
+			return false
+		}
+	}
+	return c.Endpoint == other.Endpoint
+}
+
+// validate returns an error if the configuration is not valid
+func (c *FTPFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("ftpfs: endpoint cannot be empty")
+	}
+	if c.TLSMode < FTPTLSModeDisabled || c.TLSMode > FTPTLSModeImplicit {
+		return errors.New("ftpfs: invalid tls_mode, valid values are 0, 1 and 2")
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		if c.TLSMode == FTPTLSModeImplicit {
+			c.Endpoint = net.JoinHostPort(c.Endpoint, "990")
+		} else {
+			c.Endpoint = net.JoinHostPort(c.Endpoint, "21")
+		}
+	}
+	host, _, err := net.SplitHostPort(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("ftpfs: invalid endpoint: %v", err)
+	}
+	if host == "" {
+		return errors.New("ftpfs: invalid endpoint: the host cannot be empty")
+	}
+	if c.Username == "" {
+		return errors.New("ftpfs: username cannot be empty")
+	}
+	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
+		return errors.New("invalid equality_check_mode")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("ftpfs: invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("ftpfs: invalid password")
+	}
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(c.Prefix)
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
+// ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
+func (c *FTPFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate FTP fs config: %v", err))
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt FTP fs password: %v", err))
+		}
+	}
+	return nil
+}
+
+// ftpConn is an FTP connection to the remote server.
+// A connection supports a single command or data transfer at a time
+type ftpConn struct {
+	*ftp.ServerConn
+	lastUsed time.Time
+}
+
+// FTPFs is a Fs implementation for FTP and FTPS servers.
+// Each transfer uses a dedicated connection, the other commands share a
+// small pool of idle connections
+type FTPFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	config       *FTPFsConfig
+	tlsConfig    *tls.Config
+	dialTimeout  time.Duration
+	mlstDisabled atomic.Bool
+	mu           sync.Mutex
+	idleConns    []*ftpConn
+	closed       bool
+}
+
+// NewFTPFs returns an FTPFs object that allows to interact with FTP/FTPS servers
+func NewFTPFs(connectionID, localTempDir, mountPath string, config FTPFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		if tempPath != "" {
+			localTempDir = tempPath
+		} else {
+			localTempDir = filepath.Clean(os.TempDir())
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if !config.Password.IsEmpty() {
+		if err := config.Password.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	fs := &FTPFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		dialTimeout:  30 * time.Second,
+	}
+	if config.TLSMode != FTPTLSModeDisabled {
+		host, _, _ := net.SplitHostPort(config.Endpoint)
+		fs.tlsConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: config.SkipTLSVerify,
+			MinVersion:         tls.VersionTLS12,
+			// many servers require TLS session resumption for data connections
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+	}
+	fs.mlstDisabled.Store(config.DisableMLSD)
+	// we open a connection now to check the configuration and the credentials
+	conn, err := fs.dial()
+	if err != nil {
+		fsLog(fs, logger.LevelError, "error opening connection: %v", err)
+		return nil, err
+	}
+	fs.releaseConn(conn, nil)
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *FTPFs) Name() string {
+	return fmt.Sprintf(`%s %q@%q`, ftpFsName, fs.config.Username, fs.config.Endpoint)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *FTPFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *FTPFs) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := fs.withConn(func(c *ftpConn) error {
+		var err error
+		info, err = fs.stat(c, name)
+		return err
+	})
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *FTPFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading.
+// Downloads are resumed using the REST command
+func (fs *FTPFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	c, err := fs.getConn()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resp, err := c.RetrFrom(name, uint64(offset))
+	if err != nil {
+		fs.releaseConn(c, err)
+		return nil, nil, nil, getFTPError(err)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		resp.Close()
+		fs.releaseConn(c, errFTPTransferAborted)
+		return nil, nil, nil, err
+	}
+	transfer := &ftpTransfer{
+		abort: func() {
+			resp.SetDeadline(time.Now()) //nolint:errcheck
+			c.Quit()                     //nolint:errcheck
+		},
+	}
+
+	go func() {
+		n, err := io.Copy(w, resp)
+		errClose := resp.Close()
+		if err == nil {
+			err = errClose
+		}
+		if transfer.finish() {
+			err = errFTPTransferAborted
+		} else {
+			fs.releaseConn(c, err)
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+		metric.FTPFsTransferCompleted(n, 1, err)
+	}()
+
+	return nil, r, transfer.cancel, nil
+}
+
+// Create creates or opens the named file for writing.
+// If the O_TRUNC flag is not set and the file exists, the upload is
+// resumed using the REST command
+func (fs *FTPFs) Create(name string, flag, checks int) (File, *PipeWriter, func(), error) {
+	c, err := fs.getConn()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if checks&CheckParentDir != 0 {
+		_, err := fs.stat(c, path.Dir(name))
+		if err != nil {
+			fs.releaseConn(c, err)
+			return nil, nil, nil, getFTPError(err)
+		}
+	}
+	var offset int64
+	if flag != 0 && flag != -1 && flag&os.O_TRUNC == 0 {
+		info, err := fs.stat(c, name)
+		if err == nil {
+			offset = info.Size()
+		} else if !fs.IsNotExist(getFTPError(err)) {
+			fs.releaseConn(c, err)
+			return nil, nil, nil, getFTPError(err)
+		}
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		fs.releaseConn(c, nil)
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriterAtOffset(w, offset)
+	var closeOnce sync.Once
+	closeReader := func(err error) {
+		closeOnce.Do(func() {
+			r.CloseWithError(err) //nolint:errcheck
+		})
+	}
+	cancelFn := func() {
+		// the data connection is closed and the server will reply, the
+		// control connection is still usable
+		closeReader(errFTPTransferAborted)
+	}
+
+	go func() {
+		var err error
+		if offset > 0 {
+			fsLog(fs, logger.LevelDebug, "resuming upload for path %q, offset: %d", name, offset)
+			err = c.StorFrom(name, r, uint64(offset))
+		} else {
+			err = c.Stor(name, r)
+		}
+		fs.releaseConn(c, err)
+		err = getFTPError(err)
+		closeReader(err)
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, offset: %d, readed bytes: %d, err: %+v",
+			name, offset, r.GetReadedBytes(), err)
+		metric.FTPFsTransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+func (fs *FTPFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	err := fs.withConn(func(c *ftpConn) error {
+		return c.Rename(source, target)
+	})
+	return -1, -1, err
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *FTPFs) Remove(name string, isDir bool) error {
+	return fs.withConn(func(c *ftpConn) error {
+		if isDir {
+			return c.RemoveDir(name)
+		}
+		return c.Delete(name)
+	})
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *FTPFs) Mkdir(name string) error {
+	return fs.withConn(func(c *ftpConn) error {
+		err := c.MakeDir(name)
+		if err != nil {
+			// FTP servers return the same error code if the directory already exists
+			if _, errStat := fs.stat(c, name); errStat == nil {
+				return fmt.Errorf("%w: %v", os.ErrExist, err)
+			}
+		}
+		return err
+	})
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*FTPFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*FTPFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*FTPFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*FTPFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time is set using the MFMT command, if supported
+func (fs *FTPFs) Chtimes(name string, _, mtime time.Time, _ bool) error {
+	return fs.withConn(func(c *ftpConn) error {
+		code, msg, err := c.SendCustomCommand(fmt.Sprintf("MFMT %s %s", mtime.UTC().Format(ftpMLSxTimeFormat), name))
+		if err != nil {
+			return err
+		}
+		if code != ftp.StatusFile {
+			return &textproto.Error{Code: code, Msg: msg}
+		}
+		return nil
+	})
+}
+
+// Truncate changes the size of the named file.
+// Only truncating to 0 bytes is supported
+func (fs *FTPFs) Truncate(name string, size int64) error {
+	if size != 0 {
+		return ErrVfsUnsupported
+	}
+	return fs.withConn(func(c *ftpConn) error {
+		return c.Stor(name, bytes.NewReader(nil))
+	})
+}
+
+// ReadDir returns a DirLister for the directory named by dirname.
+// The FTP client reads the whole directory before returning
+func (fs *FTPFs) ReadDir(dirname string) (DirLister, error) {
+	var files []os.FileInfo
+	err := fs.withConn(func(c *ftpConn) error {
+		var err error
+		files, err = fs.list(c, dirname)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceDirLister(files), nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*FTPFs) IsUploadResumeSupported() bool {
+	return true
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*FTPFs) IsAtomicUploadSupported() bool {
+	return true
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*FTPFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*FTPFs) IsPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*FTPFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *FTPFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	if err := fs.mkdirAll(fs.config.Prefix); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *FTPFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// CheckMetadata checks the metadata consistency
+func (*FTPFs) CheckMetadata() error {
+	return nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *FTPFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+				if numFiles%1000 == 0 {
+					fsLog(fs, logger.LevelDebug, "dirname %q scan in progress, files: %d, size: %d", dirname, numFiles, size)
+				}
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*FTPFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the ftp prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *FTPFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *FTPFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*FTPFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*FTPFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *FTPFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetMimeType returns the content type.
+// The file extension is used if known, otherwise the first bytes of the file
+// are downloaded to detect the content type
+func (fs *FTPFs) GetMimeType(name string) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	c, err := fs.getConn()
+	if err != nil {
+		return "", err
+	}
+	// the transfer is aborted after reading the first bytes, the connection
+	// state is undefined and so it will not be reused
+	defer c.Quit() //nolint:errcheck
+
+	resp, err := c.Retr(name)
+	if err != nil {
+		return "", getFTPError(err)
+	}
+	defer resp.Close()
+
+	var buf [512]byte
+	n, err := io.ReadFull(resp, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Close closes the fs
+func (fs *FTPFs) Close() error {
+	fs.mu.Lock()
+	fs.closed = true
+	conns := fs.idleConns
+	fs.idleConns = nil
+	fs.mu.Unlock()
+
+	for _, c := range conns {
+		c.Quit() //nolint:errcheck
+	}
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path.
+// The AVBL command is used, if supported by the server
+func (fs *FTPFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	var available int64
+	err := fs.withConn(func(c *ftpConn) error {
+		code, msg, err := c.SendCustomCommand("AVBL " + dirName)
+		if err != nil {
+			return err
+		}
+		if code != ftp.StatusFile {
+			fsLog(fs, logger.LevelDebug, "unable to get the available size for %q, code: %d, msg: %q",
+				dirName, code, msg)
+			return ErrStorageSizeUnavailable
+		}
+		available, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		if err != nil || available < 0 {
+			return ErrStorageSizeUnavailable
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bsize := uint64(4096)
+	blocks := uint64(available) / bsize
+	// these assumptions are wrong but still better than returning 0
+	files := blocks / 4
+	return &sftp.StatVFS{
+		Bsize:   bsize,
+		Frsize:  bsize,
+		Blocks:  blocks,
+		Bfree:   blocks,
+		Bavail:  blocks,
+		Files:   files,
+		Ffree:   files,
+		Favail:  files,
+		Namemax: 255,
+	}, nil
+}
+
+func (fs *FTPFs) getCacheNamespace() string {
+	return getHashedNamespace(ftpFsName, fs.config.Endpoint, fs.config.Username)
+}
+
+func (fs *FTPFs) dial() (*ftpConn, error) {
+	options := []ftp.DialOption{
+		ftp.DialWithTimeout(fs.dialTimeout),
+		ftp.DialWithDisabledEPSV(fs.config.DisableEPSV),
+		ftp.DialWithDisabledMLSD(fs.config.DisableMLSD),
+	}
+	switch fs.config.TLSMode {
+	case FTPTLSModeExplicit:
+		options = append(options, ftp.DialWithExplicitTLS(fs.tlsConfig))
+	case FTPTLSModeImplicit:
+		options = append(options, ftp.DialWithTLS(fs.tlsConfig))
+	}
+	conn, err := ftp.Dial(fs.config.Endpoint, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %q: %w", fs.config.Endpoint, err)
+	}
+	if err := conn.Login(fs.config.Username, fs.config.Password.GetPayload()); err != nil {
+		conn.Quit() //nolint:errcheck
+		return nil, fmt.Errorf("unable to login to %q: %w", fs.config.Endpoint, err)
+	}
+	return &ftpConn{
+		ServerConn: conn,
+		lastUsed:   time.Now(),
+	}, nil
+}
+
+// getConn returns an idle connection, if any, or a new one
+func (fs *FTPFs) getConn() (*ftpConn, error) {
+	for {
+		fs.mu.Lock()
+		if len(fs.idleConns) == 0 {
+			fs.mu.Unlock()
+			return fs.dial()
+		}
+		c := fs.idleConns[len(fs.idleConns)-1]
+		fs.idleConns = fs.idleConns[:len(fs.idleConns)-1]
+		fs.mu.Unlock()
+
+		if time.Since(c.lastUsed) < ftpConnCheckTimeout {
+			return c, nil
+		}
+		// the server could have closed the idle connection
+		if err := c.NoOp(); err == nil {
+			return c, nil
+		}
+		c.Quit() //nolint:errcheck
+	}
+}
+
+// releaseConn adds the connection to the idle pool. The connection is closed
+// after unexpected errors since its state is undefined
+func (fs *FTPFs) releaseConn(c *ftpConn, err error) {
+	if !isFTPConnReusable(err) {
+		c.Quit() //nolint:errcheck
+		return
+	}
+	c.lastUsed = time.Now()
+
+	fs.mu.Lock()
+	if !fs.closed && len(fs.idleConns) < ftpMaxIdleConns {
+		fs.idleConns = append(fs.idleConns, c)
+		fs.mu.Unlock()
+		return
+	}
+	fs.mu.Unlock()
+	c.Quit() //nolint:errcheck
+}
+
+// withConn executes fn using an idle connection and converts the returned error
+func (fs *FTPFs) withConn(fn func(c *ftpConn) error) error {
+	c, err := fs.getConn()
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	fs.releaseConn(c, err)
+	return getFTPError(err)
+}
+
+// stat returns the file details using MLST, if supported, or listing the
+// parent directory
+func (fs *FTPFs) stat(c *ftpConn, name string) (os.FileInfo, error) {
+	if !fs.mlstDisabled.Load() {
+		info, err := fs.mlst(c, name)
+		if err != ErrVfsUnsupported {
+			return info, err
+		}
+		fsLog(fs, logger.LevelDebug, "MLST not supported, directory listings will be used to get file details")
+		fs.mlstDisabled.Store(true)
+	}
+	if name == "/" {
+		return NewFileInfo(name, true, 0, time.Unix(0, 0), false), nil
+	}
+	baseName := path.Base(name)
+	files, err := fs.list(c, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range files {
+		if info.Name() == baseName {
+			return info, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (fs *FTPFs) mlst(c *ftpConn, name string) (os.FileInfo, error) {
+	code, msg, err := c.SendCustomCommand("MLST " + name)
+	if err != nil {
+		return nil, err
+	}
+	switch code {
+	case ftp.StatusRequestedFileActionOK:
+	case ftp.StatusBadCommand, ftp.StatusNotImplemented:
+		return nil, ErrVfsUnsupported
+	default:
+		return nil, &textproto.Error{Code: code, Msg: msg}
+	}
+	// the facts line starts with a space in the multiline response
+	for _, line := range strings.Split(msg, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		info, err := parseMLSxLine(strings.TrimSpace(line), path.Base(name))
+		if err != nil {
+			fsLog(fs, logger.LevelError, "unable to parse MLST response %q: %v", line, err)
+			return nil, err
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("unable to parse MLST response %q", msg)
+}
+
+// list returns the directory contents, "." and ".." are skipped
+func (fs *FTPFs) list(c *ftpConn, dirname string) ([]os.FileInfo, error) {
+	entries, err := c.List(dirname)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		isDir := entry.Type == ftp.EntryTypeFolder
+		var size int64
+		if !isDir {
+			size = int64(entry.Size)
+		}
+		files = append(files, NewFileInfo(entry.Name, isDir, size, entry.Time, false))
+	}
+	return files, nil
+}
+
+func (fs *FTPFs) mkdirAll(name string) error {
+	if name == "/" || name == "." {
+		return nil
+	}
+	info, err := fs.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", name)
+		}
+		return nil
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	if err := fs.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	return fs.Mkdir(name)
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *FTPFs) walk(filePath string, info fs.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	var files []os.FileInfo
+	if err == nil {
+		files, err = ReadAllDirEntries(lister)
+	}
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		objName := path.Join(filePath, fi.Name())
+		err = fs.walk(objName, fi, walkFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMLSxLine parses an RFC 3659 facts line, for example
+// "type=file;size=1024;modify=20230102150405; name"
+func parseMLSxLine(line, name string) (os.FileInfo, error) {
+	idx := strings.Index(line, " ")
+	if idx < 0 {
+		return nil, errors.New("invalid facts line")
+	}
+	var isDir bool
+	var size int64
+	var modTime time.Time
+	for _, fact := range strings.Split(line[:idx], ";") {
+		key, value, ok := strings.Cut(fact, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "type":
+			switch strings.ToLower(value) {
+			case "dir", "cdir", "pdir":
+				isDir = true
+			}
+		case "size":
+			size, _ = strconv.ParseInt(value, 10, 64)
+		case "modify":
+			// fractions of seconds are allowed
+			value, _, _ = strings.Cut(value, ".")
+			modTime, _ = time.ParseInLocation(ftpMLSxTimeFormat, value, time.UTC)
+		}
+	}
+	if isDir {
+		size = 0
+	}
+	return NewFileInfo(name, isDir, size, modTime, false), nil
+}
+
+// isFTPConnReusable returns true if the connection can be used after the
+// specified error, the FTP protocol errors do not affect the connection state
+func isFTPConnReusable(err error) bool {
+	if err == nil {
+		return true
+	}
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return true
+	}
+	return errors.Is(err, ErrVfsUnsupported) || errors.Is(err, ErrStorageSizeUnavailable) ||
+		errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrExist)
+}
+
+// getFTPError converts the FTP protocol errors to fs errors
+func getFTPError(err error) error {
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) {
+		return err
+	}
+	switch tpErr.Code {
+	case ftp.StatusNotLoggedIn, ftp.StatusStorNeedAccount, ftp.StatusBadFileName:
+		return fmt.Errorf("%w: %v", os.ErrPermission, err)
+	case ftp.StatusFileUnavailable, ftp.StatusFileActionIgnored:
+		// 550 is used for both missing files and denied permissions
+		msg := strings.ToLower(tpErr.Msg)
+		if strings.Contains(msg, "permission") || strings.Contains(msg, "denied") {
+			return fmt.Errorf("%w: %v", os.ErrPermission, err)
+		}
+		return fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	case ftp.StatusBadCommand, ftp.StatusNotImplemented, ftp.StatusNotImplementedParameter:
+		return ErrVfsUnsupported
+	}
+	return err
+}
+
+// ftpTransfer allows to abort a download once
+type ftpTransfer struct {
+	mu       sync.Mutex
+	finished bool
+	aborted  bool
+	abort    func()
+}
+
+func (t *ftpTransfer) cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.finished || t.aborted {
+		return
+	}
+	t.aborted = true
+	t.abort()
+}
+
+// finish marks the transfer as finished and returns true if it was aborted
+func (t *ftpTransfer) finish() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finished = true
+	return t.aborted
+}
@@ -19,6 +19,7 @@ package vfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -26,6 +27,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -45,6 +47,7 @@ import (
 
 const (
 	defaultGCSPageSize = 5000
+	gcsResumePrefix    = ".sftpgo-resume/"
 )
 
 var (
@@ -61,6 +64,7 @@ type GCSFs struct {
 	svc            *storage.Client
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	cache          *metadataCacheHandle
 }
 
 func init() {
@@ -99,6 +103,8 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 		}
 		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
 	}
+	fs.cache = fs.config.MetadataCache.getHandle([]string{gcsfsName, fs.config.Bucket, fs.config.KeyPrefix},
+		strconv.Itoa(fs.config.AutomaticCredentials), fs.config.Credentials.GetPayload())
 	return fs, err
 }
 
@@ -120,7 +126,14 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
-	return fs.getObjectStat(name)
+	if info, ok := fs.cache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.getObjectStat(name)
+	if err == nil {
+		fs.cache.setStat(name, info)
+	}
+	return info, err
 }
 
 // Lstat returns a FileInfo describing the named file
@@ -168,6 +181,17 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, *PipeWriter, func(
 			return nil, nil, nil, err
 		}
 	}
+	fs.cache.invalidate(name)
+	isResumable := cloudUploadResume && flag != -1 && checks&CheckResume != 0
+	if isResumable && flag&os.O_TRUNC == 0 {
+		attrs, err := fs.headObject(name)
+		if err == nil {
+			return fs.createAppend(name, attrs)
+		}
+		if !fs.IsNotExist(err) {
+			return nil, nil, nil, err
+		}
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -189,7 +213,12 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, *PipeWriter, func(
 	}
 
 	ctx, cancelFn := context.WithCancel(context.Background())
-	objectWriter := obj.NewWriter(ctx)
+	uploadCtx := ctx
+	if isResumable {
+		// interrupted uploads are saved as partial files so they can be resumed
+		uploadCtx = context.Background()
+	}
+	objectWriter := obj.NewWriter(uploadCtx)
 	if fs.config.UploadPartSize > 0 {
 		objectWriter.ChunkSize = int(fs.config.UploadPartSize) * 1024 * 1024
 	}
@@ -219,6 +248,7 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, *PipeWriter, func(
 		if err == nil {
 			err = closeErr
 		}
+		fs.cache.invalidate(name)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
@@ -228,6 +258,127 @@ func (fs *GCSFs) Create(name string, flag, checks int) (File, *PipeWriter, func(
 	return nil, p, cancelFn, nil
 }
 
+// createAppend uploads the received data to a temporary object and then appends
+// it to the existing object using a server side compose
+func (fs *GCSFs) createAppend(name string, attrs *storage.ObjectAttrs) (File, *PipeWriter, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fsLog(fs, logger.LevelDebug, "resuming upload for %q, generation %d, offset %d", name, attrs.Generation, attrs.Size)
+	p := NewPipeWriterAtOffset(w, attrs.Size)
+	tempName := fs.config.KeyPrefix + gcsResumePrefix + util.GenerateUniqueID()
+	tempObj := fs.svc.Bucket(fs.config.Bucket).Object(tempName).If(storage.Conditions{DoesNotExist: true})
+	_, cancelFn := context.WithCancel(context.Background())
+	// the data received before an interruption is appended anyway, so the upload can be resumed again
+	objectWriter := tempObj.NewWriter(context.Background())
+	if fs.config.UploadPartSize > 0 {
+		objectWriter.ChunkSize = int(fs.config.UploadPartSize) * 1024 * 1024
+	}
+	if fs.config.UploadPartMaxTime > 0 {
+		objectWriter.ChunkRetryDeadline = time.Duration(fs.config.UploadPartMaxTime) * time.Second
+	}
+	if fs.config.StorageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	}
+
+	go func() {
+		defer cancelFn()
+
+		n, err := io.Copy(objectWriter, r)
+		closeErr := objectWriter.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if closeErr == nil {
+			if n > 0 {
+				errCompose := fs.composeObjects(name, tempName, attrs)
+				if err == nil {
+					err = errCompose
+				}
+			}
+			if errDelete := fs.deleteObject(tempName); errDelete != nil {
+				fsLog(fs, logger.LevelWarn, "unable to delete temporary object %q: %+v", tempName, errDelete)
+			}
+		}
+		fs.cache.invalidate(name)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "append upload completed, path: %q, readed bytes: %v, err: %+v",
+			name, n, err)
+		metric.GCSTransferCompleted(n, 0, err)
+	}()
+	return nil, p, cancelFn, nil
+}
+
+func (fs *GCSFs) composeObjects(name, tempName string, attrs *storage.ObjectAttrs) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	bkt := fs.svc.Bucket(fs.config.Bucket)
+	dst := bkt.Object(name).If(storage.Conditions{GenerationMatch: attrs.Generation})
+	composer := dst.ComposerFrom(bkt.Object(name).Generation(attrs.Generation), bkt.Object(tempName))
+	composer.ContentType = attrs.ContentType
+	composer.Metadata = attrs.Metadata
+	if fs.config.StorageClass != "" {
+		composer.StorageClass = fs.config.StorageClass
+	}
+	if fs.config.ACL != "" {
+		composer.PredefinedACL = fs.config.ACL
+	}
+	_, err := composer.Run(ctx)
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to append %q to %q: %+v", tempName, name, err)
+	}
+	return err
+}
+
+func (fs *GCSFs) deleteObject(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	err := fs.svc.Bucket(fs.config.Bucket).Object(name).Delete(ctx)
+	metric.GCSDeleteObjectCompleted(err)
+	return err
+}
+
+// cleanupAbandonedUploads removes the temporary objects left by resumed
+// uploads interrupted before appending the received data
+func (fs *GCSFs) cleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	var result AbandonedUploadsCleanupResult
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	query := &storage.Query{Prefix: fs.config.KeyPrefix + gcsResumePrefix}
+	err := query.SetAttrSelection(gcsDefaultFieldsSelection)
+	if err != nil {
+		return result, err
+	}
+	it := fs.svc.Bucket(fs.config.Bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			metric.GCSListObjectsCompleted(err)
+			return result, err
+		}
+		if !attrs.Deleted.IsZero() || attrs.Updated.After(olderThan) {
+			continue
+		}
+		if err := fs.deleteObject(attrs.Name); err != nil {
+			fsLog(fs, logger.LevelError, "unable to delete abandoned object %q: %+v", attrs.Name, err)
+			return result, err
+		}
+		fsLog(fs, logger.LevelDebug, "abandoned object %q removed, last modified: %v", attrs.Name, attrs.Updated)
+		result.Removed++
+	}
+	metric.GCSListObjectsCompleted(nil)
+	return result, nil
+}
+
 // Rename renames (moves) source to target.
 func (fs *GCSFs) Rename(source, target string) (int, int64, error) {
 	if source == target {
@@ -241,6 +392,10 @@ func (fs *GCSFs) Rename(source, target string) (int, int64, error) {
 	if err != nil {
 		return -1, -1, err
 	}
+	defer func() {
+		fs.cache.invalidateTree(source)
+		fs.cache.invalidateTree(target)
+	}()
 	return fs.renameInternal(source, target, fi)
 }
 
@@ -276,6 +431,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 		err = fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")).Delete(ctx)
 	}
 	metric.GCSDeleteObjectCompleted(err)
+	fs.cache.invalidate(name)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %q: %+v", name, errMetadata)
@@ -327,6 +483,7 @@ func (fs *GCSFs) Chtimes(name string, _, mtime time.Time, isUploading bool) erro
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.cache.invalidate(name)
 
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
@@ -339,88 +496,54 @@ func (*GCSFs) Truncate(_ string, _ int64) error {
 	return ErrVfsUnsupported
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+// ReadDir returns a DirLister for the directory named by dirname.
+// Objects are listed a page at a time while iterating
+func (fs *GCSFs) ReadDir(dirname string) (DirLister, error) {
+	if lister, ok := fs.cache.getLister(dirname); ok {
+		return lister, nil
+	}
+	lister, err := fs.readDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.cache.wrapLister(dirname, lister), nil
+}
+
+func (fs *GCSFs) readDir(dirname string) (DirLister, error) {
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
-
 	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
 	err := query.SetAttrSelection(gcsDefaultFieldsSelection)
 	if err != nil {
 		return nil, err
 	}
-
 	modTimes, err := getFolderModTimes(fs.getStorageID(), dirname)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-
-	prefixes := make(map[string]bool)
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
-	defer cancelFn()
+	// the listing can take longer than the context timeouts used for other requests,
+	// the context is canceled when the lister is closed
+	ctx, cancelFn := context.WithCancel(context.Background())
 
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	it := bkt.Objects(ctx, query)
-	pager := iterator.NewPager(it, defaultGCSPageSize, "")
 
-	for {
-		var objects []*storage.ObjectAttrs
-		pageToken, err := pager.NextPage(&objects)
-		if err != nil {
-			metric.GCSListObjectsCompleted(err)
-			return result, err
-		}
-
-		for _, attrs := range objects {
-			if attrs.Prefix != "" {
-				name, _ := fs.resolve(attrs.Prefix, prefix, attrs.ContentType)
-				if name == "" {
-					continue
-				}
-				if _, ok := prefixes[name]; ok {
-					continue
-				}
-				result = append(result, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
-				prefixes[name] = true
-			} else {
-				name, isDir := fs.resolve(attrs.Name, prefix, attrs.ContentType)
-				if name == "" {
-					continue
-				}
-				if !attrs.Deleted.IsZero() {
-					continue
-				}
-				if isDir {
-					// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
-					if _, ok := prefixes[name]; ok {
-						continue
-					}
-					prefixes[name] = true
-				}
-				modTime := attrs.Updated
-				if t, ok := modTimes[name]; ok {
-					modTime = util.GetTimeFromMsecSinceEpoch(t)
-				}
-				result = append(result, NewFileInfo(name, isDir, attrs.Size, modTime, false))
-			}
-		}
-
-		objects = nil
-		if pageToken == "" {
-			break
-		}
-	}
-
-	metric.GCSListObjectsCompleted(nil)
-	return result, nil
+	return &gcsDirLister{
+		pager:    iterator.NewPager(it, defaultGCSPageSize, ""),
+		cancelFn: cancelFn,
+		prefix:   prefix,
+		prefixes: make(map[string]bool),
+		modTimes: modTimes,
+		resolve:  fs.resolve,
+	}, nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on GCS
+// Resuming uploads is supported on GCS only if enabled in the configuration,
+// interrupted uploads are saved as partial objects and the resumed data are
+// appended using a server side compose
 func (*GCSFs) IsUploadResumeSupported() bool {
-	return false
+	return cloudUploadResume
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
@@ -699,6 +822,8 @@ func (fs *GCSFs) ResolvePath(virtualPath string) (string, error) {
 
 // CopyFile implements the FsFileCopier interface
 func (fs *GCSFs) CopyFile(source, target string, _ int64) error {
+	defer fs.cache.invalidate(target)
+
 	return fs.copyFileInternal(source, target)
 }
 
@@ -721,7 +846,9 @@ func (fs *GCSFs) getObjectStat(name string) (os.FileInfo, error) {
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, objSize, objectModTime, false))
+		info := NewFileInfo(name, isDir, objSize, objectModTime, false)
+		info.SetETag(attrs.Etag)
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return nil, err
@@ -792,23 +919,35 @@ func (fs *GCSFs) renameInternal(source, target string, fi os.FileInfo) (int, int
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			entries, err := fs.ReadDir(source)
+			lister, err := fs.readDir(source)
 			if err != nil {
 				return numFiles, filesSize, err
 			}
-			for _, info := range entries {
-				sourceEntry := fs.Join(source, info.Name())
-				targetEntry := fs.Join(target, info.Name())
-				files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
-				if err != nil {
-					if fs.IsNotExist(err) {
-						fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
-						continue
-					}
+			defer lister.Close()
+
+			for {
+				entries, err := lister.Next(ListerBatchSize)
+				finished := errors.Is(err, io.EOF)
+				if err != nil && !finished {
 					return numFiles, filesSize, err
 				}
-				numFiles += files
-				filesSize += size
+				for _, info := range entries {
+					sourceEntry := fs.Join(source, info.Name())
+					targetEntry := fs.Join(target, info.Name())
+					files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
+					if err != nil {
+						if fs.IsNotExist(err) {
+							fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
+							continue
+						}
+						return numFiles, filesSize, err
+					}
+					numFiles += files
+					filesSize += size
+				}
+				if finished {
+					break
+				}
 			}
 		}
 	} else {
@@ -922,6 +1061,87 @@ func (*GCSFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
 	return nil, ErrStorageSizeUnavailable
 }
 
+func (fs *GCSFs) getCacheNamespace() string {
+	return getHashedNamespace(gcsfsName, fs.config.Bucket, strconv.Itoa(fs.config.AutomaticCredentials),
+		fs.config.Credentials.GetPayload())
+}
+
 func (fs *GCSFs) getStorageID() string {
 	return fmt.Sprintf("gs://%v", fs.config.Bucket)
 }
+
+type gcsDirLister struct {
+	baseDirLister
+	pager         *iterator.Pager
+	cancelFn      context.CancelFunc
+	prefix        string
+	prefixes      map[string]bool
+	modTimes      map[string]int64
+	resolve       func(name, prefix, contentType string) (string, bool)
+	finished      bool
+	metricUpdated bool
+}
+
+func (l *gcsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if len(l.cache) >= limit {
+		return l.returnFromCache(limit), nil
+	}
+	if l.finished {
+		if !l.metricUpdated {
+			l.metricUpdated = true
+			metric.GCSListObjectsCompleted(nil)
+		}
+		return l.returnFromCache(limit), io.EOF
+	}
+	var objects []*storage.ObjectAttrs
+	pageToken, err := l.pager.NextPage(&objects)
+	if err != nil {
+		metric.GCSListObjectsCompleted(err)
+		return nil, err
+	}
+	for _, attrs := range objects {
+		if attrs.Prefix != "" {
+			name, _ := l.resolve(attrs.Prefix, l.prefix, attrs.ContentType)
+			if name == "" {
+				continue
+			}
+			if _, ok := l.prefixes[name]; ok {
+				continue
+			}
+			l.cache = append(l.cache, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
+			l.prefixes[name] = true
+		} else {
+			name, isDir := l.resolve(attrs.Name, l.prefix, attrs.ContentType)
+			if name == "" {
+				continue
+			}
+			if !attrs.Deleted.IsZero() {
+				continue
+			}
+			if isDir {
+				// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
+				if _, ok := l.prefixes[name]; ok {
+					continue
+				}
+				l.prefixes[name] = true
+			}
+			modTime := attrs.Updated
+			if t, ok := l.modTimes[name]; ok {
+				modTime = util.GetTimeFromMsecSinceEpoch(t)
+			}
+			l.cache = append(l.cache, NewFileInfo(name, isDir, attrs.Size, modTime, false))
+		}
+	}
+	l.finished = pageToken == ""
+
+	return l.returnFromCache(limit), nil
+}
+
+func (l *gcsDirLister) Close() error {
+	l.cancelFn()
+	l.prefixes = nil
+	return l.baseDirLister.Close()
+}
@@ -466,28 +466,37 @@ func (fs *HTTPFs) Truncate(name string, size int64) error {
 	return nil
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *HTTPFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
+// ReadDir returns a DirLister for the directory named by dirname.
+// The JSON response is decoded while iterating, without loading the whole
+// directory listing in memory
+func (fs *HTTPFs) ReadDir(dirname string) (DirLister, error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
 
 	resp, err := fs.sendHTTPRequest(ctx, http.MethodGet, "readdir", dirname, "", "", nil)
 	if err != nil {
+		cancelFn()
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var response []statResponse
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	lister := &httpFsDirLister{
+		body:     resp.Body,
+		decoder:  json.NewDecoder(resp.Body),
+		cancelFn: cancelFn,
+	}
+	token, err := lister.decoder.Token()
 	if err != nil {
+		lister.Close()
 		return nil, err
 	}
-	result := make([]os.FileInfo, 0, len(response))
-	for _, stat := range response {
-		result = append(result, stat.getFileInfo())
+	switch token {
+	case nil:
+		// null is a valid empty listing
+		lister.finished = true
+	case json.Delim('['):
+	default:
+		lister.Close()
+		return nil, fmt.Errorf("unexpected readdir response, got token %v", token)
 	}
-	return result, nil
+	return lister, nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -686,7 +695,12 @@ func (fs *HTTPFs) walk(filePath string, info fs.FileInfo, walkFn filepath.WalkFu
 	if !info.IsDir() {
 		return walkFn(filePath, info, nil)
 	}
-	files, err := fs.ReadDir(filePath)
+	lister, err := fs.ReadDir(filePath)
+	var files []os.FileInfo
+	if err == nil {
+		// we don't want to keep the response open while walking the subdirectories
+		files, err = ReadAllDirEntries(lister)
+	}
 	err1 := walkFn(filePath, info, err)
 	if err != nil || err1 != nil {
 		return err1
@@ -782,3 +796,38 @@ func (s *statVFSResponse) toSFTPStatVFS() *sftp.StatVFS {
 		Namemax: s.Namemax,
 	}
 }
+
+type httpFsDirLister struct {
+	body     io.ReadCloser
+	decoder  *json.Decoder
+	cancelFn context.CancelFunc
+	finished bool
+}
+
+func (l *httpFsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if l.finished {
+		return nil, io.EOF
+	}
+	var result []os.FileInfo
+	for len(result) < limit {
+		if !l.decoder.More() {
+			l.finished = true
+			return result, io.EOF
+		}
+		var stat statResponse
+		if err := l.decoder.Decode(&stat); err != nil {
+			return nil, err
+		}
+		result = append(result, stat.getFileInfo())
+	}
+	return result, nil
+}
+
+func (l *httpFsDirLister) Close() error {
+	err := l.body.Close()
+	l.cancelFn()
+	return err
+}
@@ -0,0 +1,517 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	defaultMetadataCacheMaxEntries = 10000
+	maxMetadataCacheTTL            = 3600
+)
+
+var (
+	metadataCacheMaxEntries = defaultMetadataCacheMaxEntries
+	metadataCaches          = metadataCacheRegistry{
+		caches: make(map[string]*metadataCache),
+	}
+)
+
+// SetMetadataCacheMaxEntries sets the maximum number of entries, for each cache,
+// for the cloud storage metadata cache. 0 means the default
+func SetMetadataCacheMaxEntries(value int) {
+	if value <= 0 {
+		value = defaultMetadataCacheMaxEntries
+	}
+	metadataCacheMaxEntries = value
+}
+
+// MetadataCacheConfig defines the configuration for caching object metadata and
+// directory listings for cloud storage providers
+type MetadataCacheConfig struct {
+	// Time to live, in seconds, for the cached entries. 0 means disabled
+	TTL int `json:"ttl,omitempty"`
+	// If enabled, the cache is shared among all the users and virtual folders
+	// accessing the same bucket/container and key prefix. Otherwise the cache
+	// is shared only among the filesystems with the same credentials too
+	Shared bool `json:"shared,omitempty"`
+}
+
+func (c *MetadataCacheConfig) validate() error {
+	if c.TTL < 0 || c.TTL > maxMetadataCacheTTL {
+		return errors.New("the metadata cache TTL must be between 0 and 3600 seconds")
+	}
+	if c.TTL == 0 {
+		c.Shared = false
+	}
+	return nil
+}
+
+func (c *MetadataCacheConfig) isEqual(other MetadataCacheConfig) bool {
+	return c.TTL == other.TTL && c.Shared == other.Shared
+}
+
+// getHandle returns the cache handle for the specified resource or nil if
+// the cache is disabled. The private parts identify the credentials and are
+// not used for shared caches
+func (c *MetadataCacheConfig) getHandle(resource []string, private ...string) *metadataCacheHandle {
+	if c.TTL <= 0 {
+		return nil
+	}
+	parts := resource
+	if !c.Shared {
+		parts = append(parts, private...)
+	}
+	h := &metadataCacheHandle{
+		namespace: getHashedNamespace(parts...),
+		ttl:       time.Duration(c.TTL) * time.Second,
+	}
+	h.cache.Store(metadataCaches.get(h.namespace))
+	return h
+}
+
+type metadataCacheRegistry struct {
+	sync.RWMutex
+	caches map[string]*metadataCache
+}
+
+func (r *metadataCacheRegistry) get(namespace string) *metadataCache {
+	r.RLock()
+	c, ok := r.caches[namespace]
+	r.RUnlock()
+	if ok {
+		return c
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if c, ok := r.caches[namespace]; ok {
+		return c
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	c = &metadataCache{
+		namespace: namespace,
+		items:     make(map[string]metadataCacheItem),
+		listings:  make(map[string]metadataCacheListing),
+		// a removed cache could be created again while a listing is in progress,
+		// the invalidations received in the meantime are lost
+		invalidatedAt: now,
+	}
+	c.lastUsed.Store(now)
+	r.caches[namespace] = c
+	return c
+}
+
+// removeIdle removes the caches not used after the specified time
+func (r *metadataCacheRegistry) removeIdle(usedBefore int64) int {
+	r.Lock()
+	defer r.Unlock()
+
+	removed := 0
+	for namespace, c := range r.caches {
+		if c.lastUsed.Load() < usedBefore {
+			c.removed.Store(true)
+			delete(r.caches, namespace)
+			removed++
+		}
+	}
+	return removed
+}
+
+type metadataCacheItem struct {
+	info      os.FileInfo
+	expiresAt int64
+}
+
+type metadataCacheListing struct {
+	entries   []os.FileInfo
+	expiresAt int64
+}
+
+type metadataCache struct {
+	sync.RWMutex
+	namespace      string
+	items          map[string]metadataCacheItem
+	listings       map[string]metadataCacheListing
+	listingEntries int
+	// last invalidation, local or requested by other nodes, as unix
+	// timestamp in milliseconds
+	invalidatedAt int64
+	// last local change and last local change notified to other nodes
+	changedAt  int64
+	notifiedAt int64
+	// last access as unix timestamp in milliseconds
+	lastUsed atomic.Int64
+	// true if the cache was removed from the registry
+	removed atomic.Bool
+}
+
+func (c *metadataCache) getItem(key string) (os.FileInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, ok := c.items[key]
+	if !ok || item.expiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		return nil, false
+	}
+	return item.info, true
+}
+
+func (c *metadataCache) getListing(key string) ([]os.FileInfo, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	listing, ok := c.listings[key]
+	if !ok || listing.expiresAt < util.GetTimeAsMsSinceEpoch(time.Now()) {
+		return nil, false
+	}
+	entries := make([]os.FileInfo, len(listing.entries))
+	copy(entries, listing.entries)
+	return entries, true
+}
+
+func (c *metadataCache) setItem(key string, info os.FileInfo, expiresAt int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.setItemLocked(key, info, expiresAt)
+}
+
+func (c *metadataCache) setItemLocked(key string, info os.FileInfo, expiresAt int64) {
+	if _, ok := c.items[key]; !ok && len(c.items) >= metadataCacheMaxEntries {
+		c.evictItems()
+	}
+	c.items[key] = metadataCacheItem{
+		info:      info,
+		expiresAt: expiresAt,
+	}
+}
+
+func (c *metadataCache) setListing(key string, entries []os.FileInfo, expiresAt int64) {
+	if len(entries) > metadataCacheMaxEntries {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if old, ok := c.listings[key]; ok {
+		c.listingEntries -= len(old.entries)
+		delete(c.listings, key)
+	}
+	if c.listingEntries+len(entries) > metadataCacheMaxEntries {
+		c.evictListings(len(entries))
+	}
+	c.listings[key] = metadataCacheListing{
+		entries:   entries,
+		expiresAt: expiresAt,
+	}
+	c.listingEntries += len(entries)
+	for _, info := range entries {
+		c.setItemLocked(path.Join(key, info.Name()), info, expiresAt)
+	}
+}
+
+// evictItems removes the expired items, if there are no expired items
+// random entries are removed to make room for the new ones
+func (c *metadataCache) evictItems() {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for k, v := range c.items {
+		if v.expiresAt < now {
+			delete(c.items, k)
+		}
+	}
+	if len(c.items) < metadataCacheMaxEntries {
+		return
+	}
+	toRemove := len(c.items) - metadataCacheMaxEntries + metadataCacheMaxEntries/10 + 1
+	for k := range c.items {
+		if toRemove <= 0 {
+			break
+		}
+		delete(c.items, k)
+		toRemove--
+	}
+}
+
+func (c *metadataCache) evictListings(size int) {
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	for k, v := range c.listings {
+		if v.expiresAt < now {
+			c.listingEntries -= len(v.entries)
+			delete(c.listings, k)
+		}
+	}
+	for k, v := range c.listings {
+		if c.listingEntries+size <= metadataCacheMaxEntries {
+			break
+		}
+		c.listingEntries -= len(v.entries)
+		delete(c.listings, k)
+	}
+}
+
+// invalidate removes the cached info for the specified key and the listing
+// of its parent directory. If tree is true the cached contents for the
+// key, if it is a directory, are removed too
+func (c *metadataCache) invalidate(key string, tree bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.items, key)
+	c.removeListing(key)
+	if key != "" {
+		c.removeListing(getMetadataCacheKey(path.Dir(key)))
+	}
+	if tree {
+		prefix := key + "/"
+		if key == "" {
+			prefix = ""
+		}
+		for k := range c.items {
+			if strings.HasPrefix(k, prefix) {
+				delete(c.items, k)
+			}
+		}
+		for k := range c.listings {
+			if strings.HasPrefix(k, prefix) {
+				c.removeListing(k)
+			}
+		}
+	}
+	c.changedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	c.invalidatedAt = c.changedAt
+}
+
+func (c *metadataCache) removeListing(key string) {
+	if listing, ok := c.listings[key]; ok {
+		c.listingEntries -= len(listing.entries)
+		delete(c.listings, key)
+	}
+}
+
+func (c *metadataCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.items = make(map[string]metadataCacheItem)
+	c.listings = make(map[string]metadataCacheListing)
+	c.listingEntries = 0
+	c.invalidatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (c *metadataCache) getInvalidationTime() int64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.invalidatedAt
+}
+
+// getPendingChange returns the last local change not yet notified or 0
+func (c *metadataCache) getPendingChange() int64 {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.changedAt <= c.notifiedAt {
+		return 0
+	}
+	c.notifiedAt = c.changedAt
+	return c.changedAt
+}
+
+// metadataCacheHandle allows a filesystem to access a metadata cache.
+// A nil handle means that the cache is disabled
+type metadataCacheHandle struct {
+	namespace string
+	cache     atomic.Pointer[metadataCache]
+	ttl       time.Duration
+}
+
+// getCache returns the cache for this handle, the idle caches are removed from
+// the registry, so a removed cache is replaced with a new one
+func (h *metadataCacheHandle) getCache() *metadataCache {
+	c := h.cache.Load()
+	if c.removed.Load() {
+		c = metadataCaches.get(h.namespace)
+		h.cache.Store(c)
+	}
+	c.lastUsed.Store(util.GetTimeAsMsSinceEpoch(time.Now()))
+	return c
+}
+
+func (h *metadataCacheHandle) getExpiration() int64 {
+	return util.GetTimeAsMsSinceEpoch(time.Now().Add(h.ttl))
+}
+
+func (h *metadataCacheHandle) getStat(name string) (os.FileInfo, bool) {
+	if h == nil {
+		return nil, false
+	}
+	info, ok := h.getCache().getItem(getMetadataCacheKey(name))
+	metric.MetadataCacheStatCompleted(ok)
+	return info, ok
+}
+
+func (h *metadataCacheHandle) setStat(name string, info os.FileInfo) {
+	if h == nil || info == nil {
+		return
+	}
+	key := getMetadataCacheKey(name)
+	if key != "" {
+		// the name returned by Stat is the full path, entries are cached using
+		// the base name, as returned in directory listings
+		etag := getFileInfoETag(info)
+		fi := NewFileInfo(path.Base(key), info.IsDir(), info.Size(), info.ModTime(), false)
+		fi.SetETag(etag)
+		info = fi
+	}
+	h.getCache().setItem(key, info, h.getExpiration())
+}
+
+func (h *metadataCacheHandle) getLister(dirname string) (DirLister, bool) {
+	if h == nil {
+		return nil, false
+	}
+	entries, ok := h.getCache().getListing(getMetadataCacheKey(dirname))
+	metric.MetadataCacheListingCompleted(ok)
+	if !ok {
+		return nil, false
+	}
+	return &baseDirLister{cache: entries}, true
+}
+
+// wrapLister returns a DirLister that saves the listed entries in the cache
+// once the directory is fully read
+func (h *metadataCacheHandle) wrapLister(dirname string, lister DirLister) DirLister {
+	if h == nil {
+		return lister
+	}
+	return &metadataCacheDirLister{
+		DirLister: lister,
+		handle:    h,
+		key:       getMetadataCacheKey(dirname),
+		expiresAt: h.getExpiration(),
+		startedAt: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+}
+
+func (h *metadataCacheHandle) invalidate(name string) {
+	if h == nil {
+		return
+	}
+	h.getCache().invalidate(getMetadataCacheKey(name), false)
+}
+
+func (h *metadataCacheHandle) invalidateTree(name string) {
+	if h == nil {
+		return
+	}
+	h.getCache().invalidate(getMetadataCacheKey(name), true)
+}
+
+type metadataCacheDirLister struct {
+	DirLister
+	handle    *metadataCacheHandle
+	key       string
+	entries   []os.FileInfo
+	expiresAt int64
+	// listings invalidated while they are being read are not cached
+	startedAt int64
+	skipCache bool
+}
+
+func (l *metadataCacheDirLister) Next(limit int) ([]os.FileInfo, error) {
+	files, err := l.DirLister.Next(limit)
+	if l.skipCache {
+		return files, err
+	}
+	if err != nil && err != io.EOF {
+		l.skipCache = true
+		l.entries = nil
+		return files, err
+	}
+	if len(l.entries)+len(files) > metadataCacheMaxEntries {
+		l.skipCache = true
+		l.entries = nil
+		return files, err
+	}
+	for _, info := range files {
+		l.entries = append(l.entries, NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), false))
+	}
+	if err == io.EOF {
+		l.skipCache = true
+		if c := l.handle.getCache(); c.getInvalidationTime() < l.startedAt {
+			c.setListing(l.key, l.entries, l.expiresAt)
+		}
+		l.entries = nil
+	}
+	return files, err
+}
+
+func getMetadataCacheKey(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// GetMetadataCacheChanges returns the metadata caches with local changes not
+// yet notified to other nodes. The returned map has the cache namespace as key
+// and the change time, as unix timestamp in milliseconds, as value
+func GetMetadataCacheChanges() map[string]int64 {
+	metadataCaches.RLock()
+	defer metadataCaches.RUnlock()
+
+	result := make(map[string]int64)
+	for namespace, c := range metadataCaches.caches {
+		if changedAt := c.getPendingChange(); changedAt > 0 {
+			result[namespace] = changedAt
+		}
+	}
+	return result
+}
+
+// InvalidateMetadataCache removes all the cached entries for the metadata cache
+// with the specified namespace, for example after a change on another node.
+// It returns false if there is no cache with the specified namespace
+func InvalidateMetadataCache(namespace string) bool {
+	metadataCaches.RLock()
+	c, ok := metadataCaches.caches[namespace]
+	metadataCaches.RUnlock()
+
+	if ok {
+		c.clear()
+	}
+	return ok
+}
+
+// RemoveIdleMetadataCaches removes the metadata caches not used for the max
+// allowed TTL, their entries are expired anyway. It returns the number of
+// removed caches
+func RemoveIdleMetadataCaches() int {
+	return metadataCaches.removeIdle(util.GetTimeAsMsSinceEpoch(time.Now().Add(-maxMetadataCacheTTL * time.Second)))
+}
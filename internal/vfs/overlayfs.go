@@ -0,0 +1,936 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// overlayFsName is the name for the overlay Fs implementation
+	overlayFsName = "overlayfs"
+	// OverlayFilesystemProvider defines the overlay storage provider.
+	// The SDK does not define it, so we use the first free value
+	OverlayFilesystemProvider sdk.FilesystemProvider = 10
+	// a whiteout hides the file or directory with the same name in the lower layers
+	overlayWhiteoutPrefix = ".sftpgo-whiteout."
+	// an opaque marker hides the contents of the directory in the lower layers
+	overlayOpaqueMarker = ".sftpgo-opaque"
+)
+
+// OverlayFsConfig defines the configuration for the overlay filesystem.
+// The read-only lower layers are the specified virtual folders, the writable
+// layer is a virtual folder too or the local home directory if not set
+type OverlayFsConfig struct {
+	// Names of the virtual folders to use as read-only layers, ordered from
+	// the highest to the lowest priority
+	Layers []string `json:"layers,omitempty"`
+	// Name of the virtual folder to use as writable layer. If empty the
+	// local home directory is used
+	Upper string `json:"upper,omitempty"`
+}
+
+func (c *OverlayFsConfig) isEqual(other OverlayFsConfig) bool {
+	if c.Upper != other.Upper {
+		return false
+	}
+	if len(c.Layers) != len(other.Layers) {
+		return false
+	}
+	for idx := range c.Layers {
+		if c.Layers[idx] != other.Layers[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *OverlayFsConfig) getACopy() OverlayFsConfig {
+	layers := make([]string, len(c.Layers))
+	copy(layers, c.Layers)
+	return OverlayFsConfig{
+		Layers: layers,
+		Upper:  c.Upper,
+	}
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *OverlayFsConfig) Validate() error {
+	var layers []string
+	for _, name := range c.Layers {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if util.Contains(layers, name) {
+			return util.NewValidationError(fmt.Sprintf("overlay: duplicate layer %q", name))
+		}
+		layers = append(layers, name)
+	}
+	if len(layers) == 0 {
+		return util.NewValidationError("overlay: at least a layer is required")
+	}
+	c.Upper = strings.TrimSpace(c.Upper)
+	if util.Contains(layers, c.Upper) {
+		return util.NewValidationError(fmt.Sprintf("overlay: the writable layer %q cannot be a read-only layer", c.Upper))
+	}
+	c.Layers = layers
+	return nil
+}
+
+// OverlayFs is a Fs implementation that merges a writable filesystem with an
+// ordered list of read-only filesystems.
+// Reads are served from the highest layer containing the requested path and
+// writes always go to the writable layer. Deleting or renaming a path that
+// exists in a lower layer creates a whiteout file in the writable layer.
+// The filesystem paths are the ones of the writable layer
+type OverlayFs struct {
+	// writable layer
+	Fs
+	// lower layers, ordered from the highest to the lowest priority
+	layers []Fs
+}
+
+// NewOverlayFs returns an OverlayFs object using upper as writable layer.
+// The writable and the specified layers are closed when the returned Fs is
+// closed
+func NewOverlayFs(upper Fs, layers []Fs) Fs {
+	return &OverlayFs{
+		Fs:     upper,
+		layers: layers,
+	}
+}
+
+// Name returns the name for the Fs implementation
+func (*OverlayFs) Name() string {
+	return overlayFsName
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *OverlayFs) Stat(name string) (os.FileInfo, error) {
+	_, _, info, err := fs.findLayer(name, true)
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *OverlayFs) Lstat(name string) (os.FileInfo, error) {
+	_, _, info, err := fs.findLayer(name, false)
+	return info, err
+}
+
+// Open opens the named file for reading from the highest layer containing it
+func (fs *OverlayFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	layer, layerPath, _, err := fs.findLayer(name, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return layer.Open(layerPath, offset)
+}
+
+// Create creates or opens the named file for writing
+func (fs *OverlayFs) Create(name string, flag, checks int) (File, *PipeWriter, func(), error) {
+	if fs.isInRoot(name) {
+		if err := fs.prepareForWrite(name); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return fs.Fs.Create(name, flag, checks)
+}
+
+// Rename renames (moves) source to target
+func (fs *OverlayFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	if !fs.isInRoot(source) {
+		// atomic upload from the configured temporary directory
+		if err := fs.prepareForWrite(target); err != nil {
+			return -1, -1, err
+		}
+		return fs.Fs.Rename(source, target)
+	}
+	layer, layerPath, info, err := fs.findLayer(source, false)
+	if err != nil {
+		return -1, -1, err
+	}
+	if err := fs.prepareForWrite(target); err != nil {
+		return -1, -1, err
+	}
+	sourceInLower := fs.existsInLowerLayers(fs.GetRelativePath(source))
+	if info.IsDir() {
+		if sourceInLower {
+			fsLog(fs, logger.LevelDebug, "renaming directory %q is not supported, it exists in a lower layer", source)
+			return -1, -1, ErrVfsUnsupported
+		}
+		numFiles, size, err := fs.Fs.Rename(source, target)
+		if err != nil {
+			return numFiles, size, err
+		}
+		if fs.existsInAnyLowerLayer(fs.GetRelativePath(target)) {
+			if err := fs.setOpaque(target); err != nil {
+				return numFiles, size, err
+			}
+		}
+		return numFiles, size, nil
+	}
+	if layer == fs.Fs {
+		_, _, err = fs.Fs.Rename(source, target)
+	} else {
+		err = fs.copyUp(layer, layerPath, target, info)
+	}
+	if err != nil {
+		return -1, -1, err
+	}
+	if sourceInLower {
+		return -1, -1, fs.createWhiteout(source)
+	}
+	return -1, -1, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *OverlayFs) Remove(name string, isDir bool) error {
+	if fs.isMetadataFile(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	info, err := fs.Fs.Lstat(name)
+	isInTop := err == nil
+	if err != nil {
+		if !fs.IsNotExist(err) {
+			return err
+		}
+		if info, err = fs.Lstat(name); err != nil {
+			return err
+		}
+	}
+	if isDir || info.IsDir() {
+		isEmpty, err := fs.isDirEmpty(name)
+		if err != nil {
+			return err
+		}
+		if !isEmpty {
+			return fmt.Errorf("directory %q is not empty", name)
+		}
+		if isInTop {
+			err = fs.removeTopDir(name)
+		}
+		if err != nil {
+			return err
+		}
+	} else if isInTop {
+		if err := fs.Fs.Remove(name, false); err != nil {
+			return err
+		}
+	}
+	if fs.existsInLowerLayers(fs.GetRelativePath(name)) {
+		return fs.createWhiteout(name)
+	}
+	return nil
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *OverlayFs) Mkdir(name string) error {
+	if _, err := fs.Lstat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	hadWhiteout, err := fs.hasWhiteout(name)
+	if err != nil {
+		return err
+	}
+	if err := fs.prepareForWrite(name); err != nil {
+		return err
+	}
+	if err := fs.Fs.Mkdir(name); err != nil {
+		return err
+	}
+	if hadWhiteout {
+		return fs.setOpaque(name)
+	}
+	return nil
+}
+
+// Symlink creates source as a symbolic link to target.
+func (fs *OverlayFs) Symlink(source, target string) error {
+	if err := fs.prepareForWrite(target); err != nil {
+		return err
+	}
+	return fs.Fs.Symlink(source, target)
+}
+
+// Readlink returns the destination of the named symbolic link
+// as absolute virtual path
+func (fs *OverlayFs) Readlink(name string) (string, error) {
+	layer, layerPath, _, err := fs.findLayer(name, false)
+	if err != nil {
+		return "", err
+	}
+	return layer.Readlink(layerPath)
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (fs *OverlayFs) Chown(name string, uid int, gid int) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.Fs.Chown(name, uid, gid)
+}
+
+// Chmod changes the mode of the named file to mode
+func (fs *OverlayFs) Chmod(name string, mode os.FileMode) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.Fs.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file
+func (fs *OverlayFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.Fs.Chtimes(name, atime, mtime, isUploading)
+}
+
+// Truncate changes the size of the named file.
+// Truncating files stored in the lower layers is not supported
+func (fs *OverlayFs) Truncate(name string, size int64) error {
+	if _, err := fs.Fs.Lstat(name); err != nil {
+		if fs.IsNotExist(err) {
+			if _, err := fs.Lstat(name); err == nil {
+				return ErrVfsUnsupported
+			}
+		}
+		return err
+	}
+	return fs.Fs.Truncate(name, size)
+}
+
+// ReadDir returns a DirLister for the directory named by dirname.
+// The returned entries are the merged contents of all the layers
+func (fs *OverlayFs) ReadDir(dirname string) (DirLister, error) {
+	if _, err := fs.Stat(dirname); err != nil {
+		return nil, err
+	}
+	return fs.newDirLister(dirname), nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+// Resuming uploads is not supported since files stored in the lower layers
+// cannot be modified
+func (*OverlayFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size. Files stored in the lower layers are included
+func (fs *OverlayFs) ScanRootDirContents() (int, int64, error) {
+	rootDir, err := fs.Fs.ResolvePath("/")
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.GetDirSize(rootDir)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders and the files stored in the lower layers
+func (fs *OverlayFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// Walk walks the merged file tree rooted at root, calling walkFn for each
+// file or directory in the tree, including root
+func (fs *OverlayFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = fs.walk(root, info, walkFn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// GetMimeType returns the content type
+func (fs *OverlayFs) GetMimeType(name string) (string, error) {
+	layer, layerPath, _, err := fs.findLayer(name, true)
+	if err != nil {
+		return "", err
+	}
+	return layer.GetMimeType(layerPath)
+}
+
+// Close closes the writable and the lower layers
+func (fs *OverlayFs) Close() error {
+	err := fs.Fs.Close()
+	for _, layer := range fs.layers {
+		if errClose := layer.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+func (fs *OverlayFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	if err := walkFn(name, info, nil); err != nil {
+		return err
+	}
+	lister := fs.newDirLister(name)
+	defer lister.Close()
+
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return walkFn(name, info, err)
+		}
+		for _, entry := range entries {
+			err = fs.walk(fs.Join(name, entry.Name()), entry, walkFn)
+			if err != nil {
+				if !entry.IsDir() || err != filepath.SkipDir {
+					return err
+				}
+			}
+		}
+		if finished {
+			return nil
+		}
+	}
+}
+
+// findLayer returns the layer containing the named file, the path for the file
+// inside the layer and its FileInfo
+func (fs *OverlayFs) findLayer(name string, followSymlinks bool) (Fs, string, os.FileInfo, error) {
+	if fs.isMetadataFile(name) {
+		return nil, "", nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	var info os.FileInfo
+	var err error
+	if followSymlinks {
+		info, err = fs.Fs.Stat(name)
+	} else {
+		info, err = fs.Fs.Lstat(name)
+	}
+	if err == nil {
+		return fs.Fs, name, convertOverlayFileInfo(fs.Fs, info), nil
+	}
+	if !fs.IsNotExist(err) {
+		return nil, "", nil, err
+	}
+	virtualPath := fs.GetRelativePath(name)
+	if !fs.isLowerVisible(virtualPath) {
+		return nil, "", nil, err
+	}
+	for _, layer := range fs.layers {
+		layerPath, errLayer := layer.ResolvePath(virtualPath)
+		if errLayer != nil {
+			if layer.IsNotExist(errLayer) {
+				continue
+			}
+			return nil, "", nil, errLayer
+		}
+		if followSymlinks {
+			info, errLayer = layer.Stat(layerPath)
+		} else {
+			info, errLayer = layer.Lstat(layerPath)
+		}
+		if errLayer == nil {
+			return layer, layerPath, convertOverlayFileInfo(layer, info), nil
+		}
+		if !layer.IsNotExist(errLayer) {
+			return nil, "", nil, errLayer
+		}
+	}
+	return nil, "", nil, err
+}
+
+// isLowerVisible returns false if the specified virtual path, or one of its
+// parent directories, is hidden by a whiteout or an opaque directory
+func (fs *OverlayFs) isLowerVisible(virtualPath string) bool {
+	if virtualPath == "/" {
+		return true
+	}
+	if fs.existsInTop(getOverlayWhiteoutPath(virtualPath)) {
+		return false
+	}
+	for p := path.Dir(virtualPath); ; p = path.Dir(p) {
+		if fs.existsInTop(path.Join(p, overlayOpaqueMarker)) {
+			return false
+		}
+		if p == "/" {
+			return true
+		}
+		if fs.existsInTop(getOverlayWhiteoutPath(p)) {
+			return false
+		}
+	}
+}
+
+// existsInTop returns true if the specified virtual path exists in the
+// writable layer
+func (fs *OverlayFs) existsInTop(virtualPath string) bool {
+	fsPath, err := fs.Fs.ResolvePath(virtualPath)
+	if err != nil {
+		return false
+	}
+	_, err = fs.Fs.Lstat(fsPath)
+	return err == nil
+}
+
+// existsInLowerLayers returns true if the specified virtual path is visible
+// in at least a lower layer
+func (fs *OverlayFs) existsInLowerLayers(virtualPath string) bool {
+	if !fs.isLowerVisible(virtualPath) {
+		return false
+	}
+	return fs.existsInAnyLowerLayer(virtualPath)
+}
+
+// existsInAnyLowerLayer returns true if the specified virtual path exists in
+// at least a lower layer, whiteouts and opaque directories are ignored
+func (fs *OverlayFs) existsInAnyLowerLayer(virtualPath string) bool {
+	for _, layer := range fs.layers {
+		layerPath, err := layer.ResolvePath(virtualPath)
+		if err != nil {
+			continue
+		}
+		if _, err := layer.Lstat(layerPath); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *OverlayFs) newDirLister(dirname string) *overlayDirLister {
+	return &overlayDirLister{
+		fs:          fs,
+		dirname:     dirname,
+		virtualPath: fs.GetRelativePath(dirname),
+		names:       make(map[string]bool),
+	}
+}
+
+func (fs *OverlayFs) isDirEmpty(dirname string) (bool, error) {
+	lister := fs.newDirLister(dirname)
+	defer lister.Close()
+
+	entries, err := lister.Next(1)
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// removeTopDir removes the named directory from the writable layer. The
+// directory can only contain whiteouts and opaque markers here
+func (fs *OverlayFs) removeTopDir(dirname string) error {
+	lister, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			lister.Close()
+			return err
+		}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if finished {
+			break
+		}
+	}
+	lister.Close()
+
+	for _, name := range names {
+		if err := fs.Fs.Remove(fs.Join(dirname, name), false); err != nil && !fs.IsNotExist(err) {
+			return err
+		}
+	}
+	return fs.Fs.Remove(dirname, true)
+}
+
+func (fs *OverlayFs) openLayerDir(layer Fs, virtualPath string) (DirLister, error) {
+	layerPath, err := layer.ResolvePath(virtualPath)
+	if err != nil {
+		if layer.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info, err := layer.Stat(layerPath)
+	if err != nil {
+		if layer.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil
+	}
+	lister, err := layer.ReadDir(layerPath)
+	if err != nil {
+		if layer.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return lister, nil
+}
+
+// prepareForWrite creates the missing parent directories in the writable
+// layer and removes the whiteout, if any, for the named file
+func (fs *OverlayFs) prepareForWrite(name string) error {
+	if fs.isMetadataFile(name) {
+		return &os.PathError{Op: "create", Path: name, Err: os.ErrPermission}
+	}
+	virtualPath := fs.GetRelativePath(name)
+	if err := fs.copyUpDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	whiteoutPath, err := fs.Fs.ResolvePath(getOverlayWhiteoutPath(virtualPath))
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Fs.Lstat(whiteoutPath); err != nil {
+		if fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.Fs.Remove(whiteoutPath, false)
+}
+
+// copyUpDir creates the directory with the specified virtual path, and any
+// missing parent, in the writable layer if it exists in a lower layer
+func (fs *OverlayFs) copyUpDir(virtualPath string) error {
+	if virtualPath == "/" {
+		return nil
+	}
+	dirname, err := fs.Fs.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	info, err := fs.Fs.Stat(dirname)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", dirname)
+		}
+		return nil
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	_, _, info, err = fs.findLayer(dirname, true)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%q is not a directory", dirname)
+	}
+	if err := fs.copyUpDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	err = fs.Fs.Mkdir(dirname)
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// copyUpIfNeeded copies the named file or directory from the lower layers to
+// the writable one, if it does not exist there
+func (fs *OverlayFs) copyUpIfNeeded(name string) error {
+	if fs.isMetadataFile(name) {
+		return &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	if _, err := fs.Fs.Lstat(name); err == nil || !fs.IsNotExist(err) {
+		return err
+	}
+	layer, layerPath, info, err := fs.findLayer(name, false)
+	if err != nil {
+		return err
+	}
+	virtualPath := fs.GetRelativePath(name)
+	if info.IsDir() {
+		return fs.copyUpDir(virtualPath)
+	}
+	if err := fs.copyUpDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	return fs.copyUp(layer, layerPath, name, info)
+}
+
+// copyUp copies the specified file from a lower layer to the target path in
+// the writable layer
+func (fs *OverlayFs) copyUp(layer Fs, layerPath, target string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return ErrVfsUnsupported
+	}
+	f, r, cancelFn, err := layer.Open(layerPath, 0)
+	if err != nil {
+		return err
+	}
+	var src io.ReadCloser = r
+	if f != nil {
+		src = f
+	}
+	defer src.Close()
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+
+	if err := fs.writeFile(target, src); err != nil {
+		fsLog(fs, logger.LevelError, "unable to copy up %q to %q: %v", layerPath, target, err)
+		fs.Fs.Remove(target, false) //nolint:errcheck
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "file %q copied up to %q, size: %d", layerPath, target, info.Size())
+	err = fs.Fs.Chtimes(target, info.ModTime(), info.ModTime(), false)
+	if err != nil && fs.IsNotSupported(err) {
+		return nil
+	}
+	return err
+}
+
+// writeFile writes the contents of src to the named file in the writable layer
+func (fs *OverlayFs) writeFile(name string, src io.Reader) error {
+	f, w, cancelFn, err := fs.Fs.Create(name, 0, 0)
+	if err != nil {
+		return err
+	}
+	if cancelFn != nil {
+		defer cancelFn()
+	}
+	var dst io.WriteCloser = w
+	if f != nil {
+		dst = f
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil && cancelFn != nil {
+		// abort the upload, we don't want to store a partial file
+		cancelFn()
+	}
+	if errClose := dst.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (fs *OverlayFs) hasWhiteout(name string) (bool, error) {
+	whiteoutPath, err := fs.Fs.ResolvePath(getOverlayWhiteoutPath(fs.GetRelativePath(name)))
+	if err != nil {
+		return false, err
+	}
+	_, err = fs.Fs.Lstat(whiteoutPath)
+	if err == nil {
+		return true, nil
+	}
+	if fs.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (fs *OverlayFs) createWhiteout(name string) error {
+	virtualPath := fs.GetRelativePath(name)
+	if err := fs.copyUpDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	whiteoutPath, err := fs.Fs.ResolvePath(getOverlayWhiteoutPath(virtualPath))
+	if err != nil {
+		return err
+	}
+	return fs.writeFile(whiteoutPath, bytes.NewReader(nil))
+}
+
+func (fs *OverlayFs) setOpaque(dirname string) error {
+	return fs.writeFile(fs.Join(dirname, overlayOpaqueMarker), bytes.NewReader(nil))
+}
+
+// isInRoot returns false for the paths outside the writable layer, for
+// example the atomic uploads stored in the configured temporary directory
+func (fs *OverlayFs) isInRoot(name string) bool {
+	if !fs.IsAtomicUploadSupported() {
+		return true
+	}
+	fsPath, err := fs.Fs.ResolvePath(fs.GetRelativePath(name))
+	return err == nil && fsPath == name
+}
+
+func (fs *OverlayFs) isMetadataFile(name string) bool {
+	return isOverlayMetadataFile(path.Base(fs.GetRelativePath(name)))
+}
+
+// overlayDirLister merges the listings of all the layers. The entries in the
+// writable layer are returned first, so the whiteouts and the opaque marker
+// are known before reading the lower layers
+type overlayDirLister struct {
+	fs          *OverlayFs
+	dirname     string
+	virtualPath string
+	// index of the next layer to read, 0 is the writable layer
+	nextLayer int
+	lister    DirLister
+	// names already returned or hidden by a whiteout
+	names    map[string]bool
+	isOpaque bool
+}
+
+func (l *overlayDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	for {
+		if l.lister == nil {
+			lister, err := l.openNextLayer()
+			if err != nil {
+				return nil, err
+			}
+			if lister == nil {
+				continue
+			}
+			l.lister = lister
+		}
+		entries, err := l.lister.Next(limit)
+		finished := errors.Is(err, io.EOF)
+		if err != nil && !finished {
+			return nil, err
+		}
+		result := l.mergeEntries(entries)
+		if finished {
+			l.lister.Close()
+			l.lister = nil
+		}
+		if len(result) > 0 {
+			return result, nil
+		}
+	}
+}
+
+// openNextLayer returns the lister for the next layer, nil if the directory
+// does not exist in that layer and io.EOF if there are no more layers to read
+func (l *overlayDirLister) openNextLayer() (DirLister, error) {
+	idx := l.nextLayer
+	if idx > len(l.fs.layers) {
+		return nil, io.EOF
+	}
+	l.nextLayer++
+	if idx == 0 {
+		lister, err := l.fs.Fs.ReadDir(l.dirname)
+		if err != nil && l.fs.IsNotExist(err) {
+			return nil, nil
+		}
+		return lister, err
+	}
+	if idx == 1 && (l.isOpaque || !l.fs.isLowerVisible(l.virtualPath)) {
+		l.nextLayer = len(l.fs.layers) + 1
+		return nil, io.EOF
+	}
+	return l.fs.openLayerDir(l.fs.layers[idx-1], l.virtualPath)
+}
+
+// mergeEntries returns the entries, read from the current layer, not hidden by
+// the higher layers
+func (l *overlayDirLister) mergeEntries(entries []os.FileInfo) []os.FileInfo {
+	result := make([]os.FileInfo, 0, len(entries))
+	isTop := l.nextLayer == 1
+	for _, entry := range entries {
+		name := entry.Name()
+		if isTop {
+			switch {
+			case name == overlayOpaqueMarker:
+				l.isOpaque = true
+				continue
+			case strings.HasPrefix(name, overlayWhiteoutPrefix):
+				l.names[strings.TrimPrefix(name, overlayWhiteoutPrefix)] = true
+				continue
+			}
+		} else if l.names[name] {
+			continue
+		}
+		l.names[name] = true
+		result = append(result, entry)
+	}
+	return result
+}
+
+func (l *overlayDirLister) Close() error {
+	l.nextLayer = len(l.fs.layers) + 1
+	if l.lister != nil {
+		err := l.lister.Close()
+		l.lister = nil
+		return err
+	}
+	return nil
+}
+
+// convertOverlayFileInfo returns the FileInfo as seen by the users. The
+// callers cannot convert the FileInfo returned by an encrypted layer since
+// they only see the overlay filesystem
+func convertOverlayFileInfo(layer Fs, info os.FileInfo) os.FileInfo {
+	if IsCryptOsFs(layer) {
+		return layer.(*CryptFs).ConvertFileInfo(info)
+	}
+	return info
+}
+
+// getOverlayWhiteoutPath returns the virtual path for the whiteout of the
+// specified virtual path
+func getOverlayWhiteoutPath(virtualPath string) string {
+	return path.Join(path.Dir(virtualPath), overlayWhiteoutPrefix+path.Base(virtualPath))
+}
+
+func isOverlayMetadataFile(name string) bool {
+	return name == overlayOpaqueMarker || strings.HasPrefix(name, overlayWhiteoutPrefix)
+}
@@ -18,9 +18,11 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -72,6 +74,7 @@ type S3Fs struct {
 	config     *S3FsConfig
 	svc        *s3.Client
 	ctxTimeout time.Duration
+	cache      *metadataCacheHandle
 }
 
 func init() {
@@ -98,6 +101,8 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, s3Config S3FsConfig)
 	if err := fs.config.validate(); err != nil {
 		return fs, err
 	}
+	fs.cache = fs.config.MetadataCache.getHandle([]string{s3fsName, fs.config.Endpoint, fs.config.Region, fs.config.Bucket,
+		fs.config.KeyPrefix}, fs.config.AccessKey, fs.config.RoleARN)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -153,13 +158,24 @@ func (fs *S3Fs) ConnectionID() string {
 
 // Stat returns a FileInfo describing the named file
 func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
-	var result *FileInfo
 	if name == "" || name == "/" || name == "." {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
 	if fs.config.KeyPrefix == name+"/" {
 		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
 	}
+	if info, ok := fs.cache.getStat(name); ok {
+		return info, nil
+	}
+	info, err := fs.statObject(name)
+	if err == nil {
+		fs.cache.setStat(name, info)
+	}
+	return info, err
+}
+
+func (fs *S3Fs) statObject(name string) (os.FileInfo, error) {
+	var result *FileInfo
 	obj, err := fs.headObject(name)
 	if err == nil {
 		// Some S3 providers (like SeaweedFS) remove the trailing '/' from object keys.
@@ -169,8 +185,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 			_, err = fs.headObject(name + "/")
 			isDir = err == nil
 		}
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, obj.ContentLength,
-			util.GetTimeFromPointer(obj.LastModified), false))
+		info := NewFileInfo(name, isDir, obj.ContentLength, util.GetTimeFromPointer(obj.LastModified), false)
+		info.SetETag(util.GetStringFromPointer(obj.ETag))
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return result, err
@@ -189,6 +206,22 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 	return fs.getStatForDir(name)
 }
 
+// getPendingUploadInfo returns a FileInfo describing the interrupted upload
+// for the specified key, if any
+func (fs *S3Fs) getPendingUploadInfo(name string) (os.FileInfo, error) {
+	if !cloudUploadResume {
+		return nil, os.ErrNotExist
+	}
+	upload, err := fs.getPendingUpload(name)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || upload.size == 0 {
+		return nil, os.ErrNotExist
+	}
+	return NewFileInfo(name, false, upload.size, upload.lastModified, false), nil
+}
+
 func (fs *S3Fs) getStatForDir(name string) (os.FileInfo, error) {
 	var result *FileInfo
 	obj, err := fs.headObject(name + "/")
@@ -249,6 +282,10 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, *PipeWriter, func()
 			return nil, nil, nil, err
 		}
 	}
+	fs.cache.invalidate(name)
+	if cloudUploadResume && flag != -1 && checks&CheckResume != 0 {
+		return fs.createResumable(name, flag)
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -282,6 +319,7 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, *PipeWriter, func()
 			StorageClass: types.StorageClass(fs.config.StorageClass),
 			ContentType:  util.NilIfEmpty(contentType),
 		})
+		fs.cache.invalidate(name)
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
@@ -291,6 +329,53 @@ func (fs *S3Fs) Create(name string, flag, checks int) (File, *PipeWriter, func()
 	return nil, p, cancelFn, nil
 }
 
+func (fs *S3Fs) createResumable(name string, flag int) (File, *PipeWriter, func(), error) {
+	var upload *s3PendingUpload
+	if flag&os.O_TRUNC == 0 {
+		// only interrupted uploads can be resumed, we cannot append to existing objects
+		_, err := fs.headObject(name)
+		if err == nil {
+			fsLog(fs, logger.LevelDebug, "unable to resume upload for %q, the object already exists", name)
+			return nil, nil, nil, ErrVfsUnsupported
+		}
+		if !fs.IsNotExist(err) {
+			return nil, nil, nil, err
+		}
+		upload, err = fs.getPendingUpload(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		// a truncated upload replaces any interrupted one
+		fs.abortPendingUploads(name)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var offset int64
+	if upload != nil {
+		offset = upload.size
+		fsLog(fs, logger.LevelDebug, "resuming upload for %q, upload id %q, offset %d", name, upload.uploadID, offset)
+	}
+	p := NewPipeWriterAtOffset(w, offset)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	contentType := mime.TypeByExtension(path.Ext(name))
+
+	go func() {
+		defer cancelFn()
+
+		err := fs.handleResumableUpload(ctx, r, name, contentType, upload)
+		fs.cache.invalidate(name)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "resumable upload completed, path: %q, acl: %q, readed bytes: %v, err: %+v",
+			name, fs.config.ACL, r.GetReadedBytes(), err)
+		metric.S3TransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+	return nil, p, cancelFn, nil
+}
+
 // Rename renames (moves) source to target.
 func (fs *S3Fs) Rename(source, target string) (int, int64, error) {
 	if source == target {
@@ -304,6 +389,10 @@ func (fs *S3Fs) Rename(source, target string) (int, int64, error) {
 	if err != nil {
 		return -1, -1, err
 	}
+	defer func() {
+		fs.cache.invalidateTree(source)
+		fs.cache.invalidateTree(target)
+	}()
 	return fs.renameInternal(source, target, fi)
 }
 
@@ -329,6 +418,10 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		Key:    aws.String(name),
 	})
 	metric.S3DeleteObjectCompleted(err)
+	fs.cache.invalidate(name)
+	if cloudUploadResume && err == nil && !isDir {
+		fs.abortPendingUploads(name)
+	}
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %q: %+v", name, errMetadata)
@@ -380,6 +473,8 @@ func (fs *S3Fs) Chtimes(name string, _, mtime time.Time, isUploading bool) error
 			return ErrVfsUnsupported
 		}
 	}
+	defer fs.cache.invalidate(name)
+
 	return plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(name),
 		util.GetTimeAsMsSinceEpoch(mtime))
 }
@@ -391,74 +486,47 @@ func (*S3Fs) Truncate(_ string, _ int64) error {
 	return ErrVfsUnsupported
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+// ReadDir returns a DirLister for the directory named by dirname.
+// Objects are listed a page at a time while iterating
+func (fs *S3Fs) ReadDir(dirname string) (DirLister, error) {
+	if lister, ok := fs.cache.getLister(dirname); ok {
+		return lister, nil
+	}
+	lister, err := fs.readDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.cache.wrapLister(dirname, lister), nil
+}
+
+func (fs *S3Fs) readDir(dirname string) (DirLister, error) {
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
-
 	modTimes, err := getFolderModTimes(fs.getStorageID(), dirname)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	prefixes := make(map[string]bool)
-
 	paginator := s3.NewListObjectsV2Paginator(fs.svc, &s3.ListObjectsV2Input{
 		Bucket:    aws.String(fs.config.Bucket),
 		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"),
 	})
 
-	for paginator.HasMorePages() {
-		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-		defer cancelFn()
-
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			metric.S3ListObjectsCompleted(err)
-			return result, err
-		}
-		for _, p := range page.CommonPrefixes {
-			// prefixes have a trailing slash
-			name, _ := fs.resolve(p.Prefix, prefix)
-			if name == "" {
-				continue
-			}
-			if _, ok := prefixes[name]; ok {
-				continue
-			}
-			result = append(result, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
-			prefixes[name] = true
-		}
-		for _, fileObject := range page.Contents {
-			objectModTime := util.GetTimeFromPointer(fileObject.LastModified)
-			name, isDir := fs.resolve(fileObject.Key, prefix)
-			if name == "" || name == "/" {
-				continue
-			}
-			if isDir {
-				if _, ok := prefixes[name]; ok {
-					continue
-				}
-				prefixes[name] = true
-			}
-			if t, ok := modTimes[name]; ok {
-				objectModTime = util.GetTimeFromMsecSinceEpoch(t)
-			}
-			result = append(result, NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size,
-				objectModTime, false))
-		}
-	}
-
-	metric.S3ListObjectsCompleted(nil)
-	return result, nil
+	return &s3DirLister{
+		paginator: paginator,
+		timeout:   fs.ctxTimeout,
+		prefix:    prefix,
+		prefixes:  make(map[string]bool),
+		modTimes:  modTimes,
+		resolve:   fs.resolve,
+	}, nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on S3
+// Resuming uploads is supported on S3 only if enabled in the configuration,
+// interrupted multipart uploads are kept and can be resumed
 func (*S3Fs) IsUploadResumeSupported() bool {
-	return false
+	return cloudUploadResume
 }
 
 // IsAtomicUploadSupported returns true if atomic upload is supported.
@@ -692,6 +760,8 @@ func (fs *S3Fs) ResolvePath(virtualPath string) (string, error) {
 
 // CopyFile implements the FsFileCopier interface
 func (fs *S3Fs) CopyFile(source, target string, srcSize int64) error {
+	defer fs.cache.invalidate(target)
+
 	return fs.copyFileInternal(source, target, srcSize)
 }
 
@@ -772,23 +842,35 @@ func (fs *S3Fs) renameInternal(source, target string, fi os.FileInfo) (int, int6
 			return numFiles, filesSize, err
 		}
 		if renameMode == 1 {
-			entries, err := fs.ReadDir(source)
+			lister, err := fs.readDir(source)
 			if err != nil {
 				return numFiles, filesSize, err
 			}
-			for _, info := range entries {
-				sourceEntry := fs.Join(source, info.Name())
-				targetEntry := fs.Join(target, info.Name())
-				files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
-				if err != nil {
-					if fs.IsNotExist(err) {
-						fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
-						continue
-					}
+			defer lister.Close()
+
+			for {
+				entries, err := lister.Next(ListerBatchSize)
+				finished := errors.Is(err, io.EOF)
+				if err != nil && !finished {
 					return numFiles, filesSize, err
 				}
-				numFiles += files
-				filesSize += size
+				for _, info := range entries {
+					sourceEntry := fs.Join(source, info.Name())
+					targetEntry := fs.Join(target, info.Name())
+					files, size, err := fs.renameInternal(sourceEntry, targetEntry, info)
+					if err != nil {
+						if fs.IsNotExist(err) {
+							fsLog(fs, logger.LevelInfo, "skipping rename for %q: %v", sourceEntry, err)
+							continue
+						}
+						return numFiles, filesSize, err
+					}
+					numFiles += files
+					filesSize += size
+				}
+				if finished {
+					break
+				}
 			}
 		}
 	} else {
@@ -985,6 +1067,368 @@ func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int
 	return nil
 }
 
+// s3PendingUpload defines an interrupted multipart upload that can be resumed
+type s3PendingUpload struct {
+	uploadID     string
+	parts        []types.CompletedPart
+	size         int64
+	lastModified time.Time
+}
+
+func (fs *S3Fs) handleResumableUpload(ctx context.Context, reader io.Reader, name, contentType string,
+	upload *s3PendingUpload,
+) error {
+	pool := newBufferAllocator(int(fs.config.UploadPartSize))
+	defer pool.free()
+
+	var firstPart []byte
+	if upload == nil {
+		buf := pool.getBuffer()
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the file fits in a single part, a multipart upload is not required
+			err = fs.putObject(name, contentType, buf[:n])
+			pool.releaseBuffer(buf)
+			return err
+		}
+		if err == nil {
+			upload, err = fs.createMultipartUpload(ctx, name, contentType)
+		}
+		if err != nil {
+			pool.releaseBuffer(buf)
+			return err
+		}
+		firstPart = buf
+	}
+	var partOptions []func(*s3.Options)
+	if fs.config.UploadPartMaxTime > 0 {
+		httpClient := getAWSHTTPClient(fs.config.UploadPartMaxTime, 100*time.Millisecond)
+		partOptions = append(partOptions, func(o *s3.Options) {
+			o.HTTPClient = httpClient
+		})
+	}
+	guard := make(chan struct{}, fs.config.UploadConcurrency)
+	finished := false
+	completedParts := append([]types.CompletedPart(nil), upload.parts...)
+	var partMutex sync.Mutex
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var hasError atomic.Bool
+	var poolError, readError error
+
+	poolCtx, poolCancel := context.WithCancel(ctx)
+	defer poolCancel()
+
+	for partNumber := int32(len(upload.parts)) + 1; !finished; partNumber++ {
+		var buf []byte
+		var n int
+		var err error
+		if firstPart != nil {
+			// already read to decide if a multipart upload is required
+			buf, n = firstPart, len(firstPart)
+			firstPart = nil
+		} else {
+			buf = pool.getBuffer()
+			n, err = io.ReadFull(reader, buf)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			finished = true
+			if n == 0 {
+				pool.releaseBuffer(buf)
+				break
+			}
+		} else if err != nil {
+			pool.releaseBuffer(buf)
+			readError = err
+			break
+		}
+
+		guard <- struct{}{}
+		if hasError.Load() {
+			fsLog(fs, logger.LevelError, "pool error, upload for part %d not started", partNumber)
+			pool.releaseBuffer(buf)
+			break
+		}
+
+		wg.Add(1)
+		go func(partNum int32, buf []byte, bufSize int) {
+			defer func() {
+				pool.releaseBuffer(buf)
+				<-guard
+				wg.Done()
+			}()
+
+			res, err := fs.svc.UploadPart(poolCtx, &s3.UploadPartInput{
+				Bucket:        aws.String(fs.config.Bucket),
+				Key:           aws.String(name),
+				UploadId:      aws.String(upload.uploadID),
+				PartNumber:    partNum,
+				Body:          bytes.NewReader(buf[:bufSize]),
+				ContentLength: int64(bufSize),
+			}, partOptions...)
+			if err != nil {
+				errOnce.Do(func() {
+					fsLog(fs, logger.LevelDebug, "multipart upload error for part %d: %+v", partNum, err)
+					hasError.Store(true)
+					poolError = fmt.Errorf("multipart upload error: %w", err)
+					poolCancel()
+				})
+				return
+			}
+
+			partMutex.Lock()
+			completedParts = append(completedParts, types.CompletedPart{
+				ETag:       res.ETag,
+				PartNumber: partNum,
+			})
+			partMutex.Unlock()
+		}(partNumber, buf, n)
+	}
+
+	wg.Wait()
+	close(guard)
+
+	if poolError == nil {
+		poolError = readError
+	}
+	if poolError == nil {
+		poolError = ctx.Err()
+	}
+	if poolError != nil {
+		if len(completedParts) == 0 {
+			fs.abortUpload(name, upload.uploadID) //nolint:errcheck
+		} else {
+			fsLog(fs, logger.LevelDebug, "upload for %q interrupted, the uploaded parts are kept to allow resuming it",
+				name)
+		}
+		return poolError
+	}
+	if len(completedParts) == 0 {
+		// empty file, a multipart upload requires at least one part
+		fs.abortUpload(name, upload.uploadID) //nolint:errcheck
+		return fs.putObject(name, contentType, nil)
+	}
+	sort.Slice(completedParts, func(i, j int) bool {
+		return completedParts[i].PartNumber < completedParts[j].PartNumber
+	})
+
+	completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer completeCancelFn()
+
+	_, err := fs.svc.CompleteMultipartUpload(completeCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(upload.uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+func (fs *S3Fs) createMultipartUpload(ctx context.Context, name, contentType string) (*s3PendingUpload, error) {
+	createCtx, createCancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	defer createCancelFn()
+
+	res, err := fs.svc.CreateMultipartUpload(createCtx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(name),
+		StorageClass: types.StorageClass(fs.config.StorageClass),
+		ACL:          types.ObjectCannedACL(fs.config.ACL),
+		ContentType:  util.NilIfEmpty(contentType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create multipart upload request: %w", err)
+	}
+	uploadID := util.GetStringFromPointer(res.UploadId)
+	if uploadID == "" {
+		return nil, errors.New("unable to get multipart upload ID")
+	}
+	return &s3PendingUpload{
+		uploadID: uploadID,
+	}, nil
+}
+
+func (fs *S3Fs) putObject(name, contentType string, data []byte) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(fs.config.Bucket),
+		Key:          aws.String(name),
+		Body:         bytes.NewReader(data),
+		ACL:          types.ObjectCannedACL(fs.config.ACL),
+		StorageClass: types.StorageClass(fs.config.StorageClass),
+		ContentType:  util.NilIfEmpty(contentType),
+	})
+	return err
+}
+
+func (fs *S3Fs) listPendingUploads(prefix string) ([]types.MultipartUpload, error) {
+	paginator := s3.NewListMultipartUploadsPaginator(fs.svc, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: util.NilIfEmpty(prefix),
+	})
+	var uploads []types.MultipartUpload
+
+	for paginator.HasMorePages() {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "unable to list multipart uploads for prefix %q: %+v", prefix, err)
+			return nil, err
+		}
+		uploads = append(uploads, page.Uploads...)
+	}
+	return uploads, nil
+}
+
+// getPendingUpload returns the most recent interrupted upload for the specified
+// key or nil if there is no interrupted upload
+func (fs *S3Fs) getPendingUpload(name string) (*s3PendingUpload, error) {
+	uploads, err := fs.listPendingUploads(name)
+	if err != nil {
+		return nil, err
+	}
+	var upload *types.MultipartUpload
+	for idx := range uploads {
+		if util.GetStringFromPointer(uploads[idx].Key) != name {
+			continue
+		}
+		if upload == nil || util.GetTimeFromPointer(uploads[idx].Initiated).After(util.GetTimeFromPointer(upload.Initiated)) {
+			upload = &uploads[idx]
+		}
+	}
+	if upload == nil {
+		return nil, nil
+	}
+	return fs.getPendingUploadParts(name, util.GetStringFromPointer(upload.UploadId),
+		util.GetTimeFromPointer(upload.Initiated))
+}
+
+func (fs *S3Fs) getPendingUploadParts(name, uploadID string, initiated time.Time) (*s3PendingUpload, error) {
+	paginator := s3.NewListPartsPaginator(fs.svc, &s3.ListPartsInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(uploadID),
+	})
+	var parts []types.Part
+
+	for paginator.HasMorePages() {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			fsLog(fs, logger.LevelError, "unable to list parts for upload id %q, key %q: %+v", uploadID, name, err)
+			return nil, err
+		}
+		parts = append(parts, page.Parts...)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	upload := &s3PendingUpload{
+		uploadID:     uploadID,
+		lastModified: initiated,
+	}
+	isContiguous := true
+	for idx, part := range parts {
+		if lastModified := util.GetTimeFromPointer(part.LastModified); lastModified.After(upload.lastModified) {
+			upload.lastModified = lastModified
+		}
+		// we can only reuse the parts uploaded sequentially from the beginning,
+		// a part smaller than the minimum allowed size must be the last one
+		if !isContiguous || part.PartNumber != int32(idx+1) || part.Size < manager.MinUploadPartSize {
+			isContiguous = false
+			continue
+		}
+		upload.parts = append(upload.parts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: part.PartNumber,
+		})
+		upload.size += part.Size
+	}
+	return upload, nil
+}
+
+func (fs *S3Fs) abortUpload(name, uploadID string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to abort multipart upload id %q, key %q: %+v", uploadID, name, err)
+	}
+	return err
+}
+
+func (fs *S3Fs) abortPendingUploads(name string) {
+	uploads, err := fs.listPendingUploads(name)
+	if err != nil {
+		return
+	}
+	for _, upload := range uploads {
+		if util.GetStringFromPointer(upload.Key) == name {
+			fs.abortUpload(name, util.GetStringFromPointer(upload.UploadId)) //nolint:errcheck
+		}
+	}
+}
+
+func (fs *S3Fs) cleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	var result AbandonedUploadsCleanupResult
+
+	uploads, err := fs.listPendingUploads(fs.config.KeyPrefix)
+	if err != nil {
+		return result, err
+	}
+	for _, upload := range uploads {
+		if util.GetTimeFromPointer(upload.Initiated).After(olderThan) {
+			continue
+		}
+		key := util.GetStringFromPointer(upload.Key)
+		uploadID := util.GetStringFromPointer(upload.UploadId)
+		pending, err := fs.getPendingUploadParts(key, uploadID, util.GetTimeFromPointer(upload.Initiated))
+		if err != nil {
+			return result, err
+		}
+		if pending.lastModified.After(olderThan) {
+			continue
+		}
+		// the data that can be resumed is included in the quota if the upload
+		// was interrupted and there is no object with the same key
+		isCharged := false
+		if pending.size > 0 {
+			_, err = fs.headObject(key)
+			if err != nil && !fs.IsNotExist(err) {
+				return result, err
+			}
+			isCharged = err != nil
+		}
+		if err := fs.abortUpload(key, uploadID); err != nil {
+			return result, err
+		}
+		fs.cache.invalidate(key)
+		fsLog(fs, logger.LevelDebug, "abandoned upload id %q for key %q removed, last modified: %v, size: %d",
+			uploadID, key, pending.lastModified, pending.size)
+		result.Removed++
+		if isCharged {
+			result.QuotaFiles++
+			result.QuotaSize += pending.size
+		}
+	}
+	return result, nil
+}
+
 func (fs *S3Fs) getPrefix(name string) string {
 	prefix := ""
 	if name != "" && name != "." && name != "/" {
@@ -1027,6 +1471,11 @@ func (*S3Fs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
 	return nil, ErrStorageSizeUnavailable
 }
 
+func (fs *S3Fs) getCacheNamespace() string {
+	return getHashedNamespace(s3fsName, fs.config.Endpoint, fs.config.Region, fs.config.Bucket,
+		fs.config.AccessKey, fs.config.RoleARN)
+}
+
 func (fs *S3Fs) getStorageID() string {
 	if fs.config.Endpoint != "" {
 		if !strings.HasSuffix(fs.config.Endpoint, "/") {
@@ -1063,3 +1512,74 @@ func pathEscape(in string) string {
 	u.Path = in
 	return strings.ReplaceAll(u.String(), "+", "%2B")
 }
+
+type s3DirLister struct {
+	baseDirLister
+	paginator     *s3.ListObjectsV2Paginator
+	timeout       time.Duration
+	prefix        string
+	prefixes      map[string]bool
+	modTimes      map[string]int64
+	resolve       func(name *string, prefix string) (string, bool)
+	metricUpdated bool
+}
+
+func (l *s3DirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if len(l.cache) >= limit {
+		return l.returnFromCache(limit), nil
+	}
+	if !l.paginator.HasMorePages() {
+		if !l.metricUpdated {
+			l.metricUpdated = true
+			metric.S3ListObjectsCompleted(nil)
+		}
+		return l.returnFromCache(limit), io.EOF
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(l.timeout))
+	defer cancelFn()
+
+	page, err := l.paginator.NextPage(ctx)
+	if err != nil {
+		metric.S3ListObjectsCompleted(err)
+		return nil, err
+	}
+	for _, p := range page.CommonPrefixes {
+		// prefixes have a trailing slash
+		name, _ := l.resolve(p.Prefix, l.prefix)
+		if name == "" {
+			continue
+		}
+		if _, ok := l.prefixes[name]; ok {
+			continue
+		}
+		l.cache = append(l.cache, NewFileInfo(name, true, 0, time.Unix(0, 0), false))
+		l.prefixes[name] = true
+	}
+	for _, fileObject := range page.Contents {
+		objectModTime := util.GetTimeFromPointer(fileObject.LastModified)
+		name, isDir := l.resolve(fileObject.Key, l.prefix)
+		if name == "" || name == "/" {
+			continue
+		}
+		if isDir {
+			if _, ok := l.prefixes[name]; ok {
+				continue
+			}
+			l.prefixes[name] = true
+		}
+		if t, ok := l.modTimes[name]; ok {
+			objectModTime = util.GetTimeFromMsecSinceEpoch(t)
+		}
+		l.cache = append(l.cache, NewFileInfo(name, (isDir && fileObject.Size == 0), fileObject.Size,
+			objectModTime, false))
+	}
+	return l.returnFromCache(limit), nil
+}
+
+func (l *s3DirLister) Close() error {
+	l.prefixes = nil
+	return l.baseDirLister.Close()
+}
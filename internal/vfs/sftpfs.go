@@ -62,10 +62,11 @@ var (
 // SFTPFsConfig defines the configuration for SFTP based filesystem
 type SFTPFsConfig struct {
 	sdk.BaseSFTPFsConfig
-	Password               *kms.Secret `json:"password,omitempty"`
-	PrivateKey             *kms.Secret `json:"private_key,omitempty"`
-	KeyPassphrase          *kms.Secret `json:"key_passphrase,omitempty"`
-	forbiddenSelfUsernames []string    `json:"-"`
+	Password               *kms.Secret      `json:"password,omitempty"`
+	PrivateKey             *kms.Secret      `json:"private_key,omitempty"`
+	KeyPassphrase          *kms.Secret      `json:"key_passphrase,omitempty"`
+	Encryption             EncryptionConfig `json:"encryption"`
+	forbiddenSelfUsernames []string         `json:"-"`
 }
 
 // HideConfidentialData hides confidential data
@@ -79,6 +80,7 @@ func (c *SFTPFsConfig) HideConfidentialData() {
 	if c.KeyPassphrase != nil {
 		c.KeyPassphrase.Hide()
 	}
+	c.Encryption.HideConfidentialData()
 }
 
 func (c *SFTPFsConfig) setNilSecretsIfEmpty() {
@@ -91,6 +93,7 @@ func (c *SFTPFsConfig) setNilSecretsIfEmpty() {
 	if c.KeyPassphrase != nil && c.KeyPassphrase.IsEmpty() {
 		c.KeyPassphrase = nil
 	}
+	c.Encryption.setNilSecretsIfEmpty()
 }
 
 func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
@@ -125,6 +128,9 @@ func (c *SFTPFsConfig) isEqual(other SFTPFsConfig) bool {
 	if !c.KeyPassphrase.IsEqual(other.KeyPassphrase) {
 		return false
 	}
+	if !c.Encryption.isEqual(other.Encryption) {
+		return false
+	}
 	return c.PrivateKey.IsEqual(other.PrivateKey)
 }
 
@@ -138,6 +144,7 @@ func (c *SFTPFsConfig) setEmptyCredentialsIfNil() {
 	if c.KeyPassphrase == nil {
 		c.KeyPassphrase = kms.NewEmptySecret()
 	}
+	c.Encryption.setEmptySecretsIfNil()
 }
 
 func (c *SFTPFsConfig) isSameResource(other SFTPFsConfig) bool {
@@ -230,7 +237,7 @@ func (c *SFTPFsConfig) ValidateAndEncryptCredentials(additionalData string) erro
 			return util.NewValidationError(fmt.Sprintf("could not encrypt SFTP fs private key passphrase: %v", err))
 		}
 	}
-	return nil
+	return c.Encryption.validateAndEncrypt(additionalData)
 }
 
 // getUniqueID returns an hash of the settings used to connect to the SFTP server
@@ -527,14 +534,18 @@ func (fs *SFTPFs) Truncate(name string, size int64) error {
 	return client.Truncate(name, size)
 }
 
-// ReadDir reads the directory named by dirname and returns
-// a list of directory entries.
-func (fs *SFTPFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+// ReadDir returns a DirLister for the directory named by dirname.
+// The SFTP client reads the whole directory before returning
+func (fs *SFTPFs) ReadDir(dirname string) (DirLister, error) {
 	client, err := fs.conn.getClient()
 	if err != nil {
 		return nil, err
 	}
-	return client.ReadDir(dirname)
+	files, err := client.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return NewSliceDirLister(files), nil
 }
 
 // IsUploadResumeSupported returns true if resuming uploads is supported.
@@ -704,6 +715,10 @@ func (fs *SFTPFs) ResolvePath(virtualPath string) (string, error) {
 	return fsPath, nil
 }
 
+func (fs *SFTPFs) getCacheNamespace() string {
+	return getHashedNamespace(sftpFsName, fs.config.Endpoint, fs.config.Username)
+}
+
 // RealPath implements the FsRealPather interface
 func (fs *SFTPFs) RealPath(p string) (string, error) {
 	client, err := fs.conn.getClient()
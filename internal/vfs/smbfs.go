@@ -0,0 +1,1046 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/hirochachacha/go-smb2"
+	"github.com/pkg/sftp"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/xid"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// smbFsName is the name for the SMB Fs implementation
+	smbFsName = "smbfs"
+	// SMBFilesystemProvider defines the SMB2/3 storage provider.
+	// The SDK does not define it, so we use the first free value
+	SMBFilesystemProvider sdk.FilesystemProvider = 9
+)
+
+const (
+	logSenderSMBCache = "smbCache"
+	// SMB requests are multiplexed, a connection can be shared by more
+	// sessions than an SFTP one
+	smbMaxSessionsPerConnection = 10
+	smbTransferBufferSize       = 1024 * 1024
+	// timeout for connecting and for the requests not related to transfers
+	smbRequestTimeout = 30 * time.Second
+)
+
+// NTSTATUS codes returned if the SMB session is no longer valid
+const (
+	smbStatusNetworkNameDeleted    = 0xC00000C9
+	smbStatusUserSessionDeleted    = 0xC0000203
+	smbStatusNetworkSessionExpired = 0xC000035C
+)
+
+var (
+	smbConnsCache         = newSMBConnectionCache()
+	errSMBTransferAborted = errors.New("transfer aborted")
+)
+
+// SMBFsConfig defines the configuration for SMB2/3 based filesystem
+type SMBFsConfig struct {
+	// SMB server address as host:port. If the port is omitted 445 is used
+	Endpoint string `json:"endpoint,omitempty"`
+	// NTLM credentials
+	Username string      `json:"username,omitempty"`
+	Password *kms.Secret `json:"password,omitempty"`
+	Domain   string      `json:"domain,omitempty"`
+	// Share is the name of the share to mount
+	Share string `json:"share,omitempty"`
+	// Prefix is the base path, inside the share, to strip from resource
+	// paths
+	Prefix string `json:"prefix,omitempty"`
+	// Defines how to check if this config points to the same
+	// share as another config. By default the endpoint, the share and
+	// the username must match. 1 means that only the endpoint and the
+	// share must match. If different configs point to the same share the
+	// renaming between the fs configs is allowed.
+	EqualityCheckMode int `json:"equality_check_mode,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *SMBFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+func (c *SMBFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+func (c *SMBFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *SMBFsConfig) isEqual(other SMBFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.Domain != other.Domain {
+		return false
+	}
+	if c.Share != other.Share {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *SMBFsConfig) isSameResource(other SMBFsConfig) bool {
+	if c.EqualityCheckMode == 0 || other.EqualityCheckMode == 0 {
+		if c.Username != other.Username || c.Domain != other.Domain {
+			return false
+		}
+	}
+	return c.Endpoint == other.Endpoint && strings.EqualFold(c.Share, other.Share)
+}
+
+// validate returns an error if the configuration is not valid
+func (c *SMBFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("smbfs: endpoint cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		c.Endpoint = net.JoinHostPort(c.Endpoint, "445")
+	}
+	host, _, err := net.SplitHostPort(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("smbfs: invalid endpoint: %v", err)
+	}
+	if host == "" {
+		return errors.New("smbfs: invalid endpoint: the host cannot be empty")
+	}
+	c.Share = strings.Trim(strings.ReplaceAll(c.Share, `\`, "/"), "/")
+	if c.Share == "" {
+		return errors.New("smbfs: share cannot be empty")
+	}
+	if strings.Contains(c.Share, "/") {
+		return errors.New("smbfs: invalid share, use the prefix to set a base path inside the share")
+	}
+	if c.Username == "" {
+		return errors.New("smbfs: username cannot be empty")
+	}
+	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
+		return errors.New("invalid equality_check_mode")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("smbfs: invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("smbfs: invalid password")
+	}
+	if c.Prefix != "" {
+		c.Prefix = util.CleanPath(strings.ReplaceAll(c.Prefix, `\`, "/"))
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
+// ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
+func (c *SMBFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate SMB fs config: %v", err))
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt SMB fs password: %v", err))
+		}
+	}
+	return nil
+}
+
+// getUniqueID returns an hash of the settings used to connect to the SMB share
+func (c *SMBFsConfig) getUniqueID(partition int) uint64 {
+	h := fnv.New64a()
+	var b bytes.Buffer
+
+	b.WriteString(c.Endpoint)
+	b.WriteString(c.Username)
+	b.WriteString(c.Domain)
+	b.WriteString(c.Share)
+	b.WriteString(c.Password.GetPayload())
+	b.WriteString(strconv.Itoa(partition))
+
+	h.Write(b.Bytes())
+	return h.Sum64()
+}
+
+// SMBFs is a Fs implementation for SMB2/3 shares.
+// The SMB connections are shared between the sessions using the same
+// configuration
+type SMBFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath string
+	config    *SMBFsConfig
+	conn      *smbConnection
+}
+
+// NewSMBFs returns an SMBFs object that allows to interact with SMB shares
+func NewSMBFs(connectionID, localTempDir, mountPath string, config SMBFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		if tempPath != "" {
+			localTempDir = tempPath
+		} else {
+			localTempDir = filepath.Clean(os.TempDir())
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if !config.Password.IsEmpty() {
+		if err := config.Password.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	fs := &SMBFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    getMountPath(mountPath),
+		config:       &config,
+		conn:         smbConnsCache.Get(&config, connectionID),
+	}
+	// we connect now to check the configuration and the credentials
+	if _, err := fs.conn.getShare(); err != nil {
+		fsLog(fs, logger.LevelError, "error opening connection: %v", err)
+		fs.Close() //nolint:errcheck
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *SMBFs) Name() string {
+	return fmt.Sprintf(`%s %q@%q share %q`, smbFsName, fs.config.Username, fs.config.Endpoint, fs.config.Share)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *SMBFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *SMBFs) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := fs.withShare(func(share *smb2.Share) error {
+		fi, err := share.Stat(getSMBPath(name))
+		if err != nil {
+			return err
+		}
+		info = fs.toFileInfo(fi)
+		return nil
+	})
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *SMBFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading
+func (fs *SMBFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := share.Open(getSMBPath(name))
+	if err != nil {
+		fs.checkSessionError(share, err)
+		return nil, nil, nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	var closeOnce sync.Once
+	closeFile := func() error {
+		var err error
+		closeOnce.Do(func() {
+			err = f.Close()
+		})
+		return err
+	}
+	cancelFn := func() {
+		// the pending reads will fail
+		closeFile() //nolint:errcheck
+	}
+
+	go func() {
+		n, err := io.CopyBuffer(w, f, make([]byte, smbTransferBufferSize))
+		errClose := closeFile()
+		if err == nil {
+			err = errClose
+		}
+		fs.checkSessionError(share, err)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %q size: %v, err: %+v", name, n, err)
+		metric.SMBFsTransferCompleted(n, 1, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing.
+// If the O_TRUNC flag is not set and the file exists, the upload is
+// resumed appending data to the existing file
+func (fs *SMBFs) Create(name string, flag, checks int) (File, *PipeWriter, func(), error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if checks&CheckParentDir != 0 {
+		_, err := share.Stat(getSMBPath(path.Dir(name)))
+		if err != nil {
+			fs.checkSessionError(share, err)
+			return nil, nil, nil, err
+		}
+	}
+	openFlag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	resume := flag != 0 && flag != -1 && flag&os.O_TRUNC == 0
+	if resume {
+		openFlag = os.O_WRONLY | os.O_CREATE
+	}
+	f, err := share.OpenFile(getSMBPath(name), openFlag, 0)
+	if err != nil {
+		fs.checkSessionError(share, err)
+		return nil, nil, nil, err
+	}
+	var offset int64
+	if resume {
+		offset, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriterAtOffset(w, offset)
+	var closeOnce sync.Once
+	closeReader := func(err error) {
+		closeOnce.Do(func() {
+			r.CloseWithError(err) //nolint:errcheck
+		})
+	}
+	cancelFn := func() {
+		closeReader(errSMBTransferAborted)
+	}
+
+	go func() {
+		if offset > 0 {
+			fsLog(fs, logger.LevelDebug, "resuming upload for path %q, offset: %d", name, offset)
+		}
+		_, err := io.CopyBuffer(f, r, make([]byte, smbTransferBufferSize))
+		errClose := f.Close()
+		if err == nil {
+			err = errClose
+		}
+		fs.checkSessionError(share, err)
+		closeReader(err)
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, offset: %d, readed bytes: %d, err: %+v",
+			name, offset, r.GetReadedBytes(), err)
+		metric.SMBFsTransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+// An existing target file is replaced, as for the other providers
+func (fs *SMBFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	err := fs.withShare(func(share *smb2.Share) error {
+		smbSource := getSMBPath(source)
+		smbTarget := getSMBPath(target)
+		err := share.Rename(smbSource, smbTarget)
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		// the client does not allow to replace the target, remove it
+		// if it is a file and try again
+		info, errStat := share.Stat(smbTarget)
+		if errStat != nil || info.IsDir() {
+			return err
+		}
+		if err := share.Remove(smbTarget); err != nil {
+			return err
+		}
+		return share.Rename(smbSource, smbTarget)
+	})
+	return -1, -1, err
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *SMBFs) Remove(name string, _ bool) error {
+	return fs.withShare(func(share *smb2.Share) error {
+		return share.Remove(getSMBPath(name))
+	})
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *SMBFs) Mkdir(name string) error {
+	return fs.withShare(func(share *smb2.Share) error {
+		return share.Mkdir(getSMBPath(name), os.ModePerm)
+	})
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*SMBFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*SMBFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*SMBFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*SMBFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *SMBFs) Chtimes(name string, atime, mtime time.Time, _ bool) error {
+	return fs.withShare(func(share *smb2.Share) error {
+		return share.Chtimes(getSMBPath(name), atime, mtime)
+	})
+}
+
+// Truncate changes the size of the named file.
+func (fs *SMBFs) Truncate(name string, size int64) error {
+	return fs.withShare(func(share *smb2.Share) error {
+		return share.Truncate(getSMBPath(name), size)
+	})
+}
+
+// ReadDir returns a DirLister for the directory named by dirname.
+// The entries are read from the server in pages
+func (fs *SMBFs) ReadDir(dirname string) (DirLister, error) {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return nil, err
+	}
+	f, err := share.Open(getSMBPath(dirname))
+	if err != nil {
+		fs.checkSessionError(share, err)
+		return nil, err
+	}
+	return &smbDirLister{
+		fs:    fs,
+		share: share,
+		f:     f,
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*SMBFs) IsUploadResumeSupported() bool {
+	return true
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*SMBFs) IsAtomicUploadSupported() bool {
+	return true
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*SMBFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*SMBFs) IsPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*SMBFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *SMBFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	osFs.CheckRootPath(username, uid, gid)
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	if err := fs.mkdirAll(fs.config.Prefix); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %q for user %q: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *SMBFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize(fs.config.Prefix)
+}
+
+// CheckMetadata checks the metadata consistency
+func (*SMBFs) CheckMetadata() error {
+	return nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *SMBFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+				if numFiles%1000 == 0 {
+					fsLog(fs, logger.LevelDebug, "dirname %q scan in progress, files: %d, size: %d", dirname, numFiles, size)
+				}
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*SMBFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the share prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *SMBFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *SMBFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*SMBFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*SMBFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *SMBFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetMimeType returns the content type.
+// The file extension is used if known, otherwise the first bytes of the file
+// are read to detect the content type
+func (fs *SMBFs) GetMimeType(name string) (string, error) {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	var ctype string
+	err := fs.withShare(func(share *smb2.Share) error {
+		f, err := share.Open(getSMBPath(name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var buf [512]byte
+		n, err := io.ReadFull(f, buf[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		ctype = http.DetectContentType(buf[:n])
+		return nil
+	})
+	return ctype, err
+}
+
+// Close closes the fs
+func (fs *SMBFs) Close() error {
+	fs.conn.RemoveSession(fs.connectionID)
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path
+func (fs *SMBFs) GetAvailableDiskSize(_ string) (*sftp.StatVFS, error) {
+	var stat smb2.FileFsInfo
+	err := fs.withShare(func(share *smb2.Share) error {
+		var err error
+		stat, err = share.Statfs(getSMBPath(fs.config.Prefix))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stat.BlockSize() == 0 {
+		return nil, ErrStorageSizeUnavailable
+	}
+	// these assumptions are wrong but still better than returning 0
+	files := stat.FreeBlockCount() / 4
+	return &sftp.StatVFS{
+		Bsize:   stat.BlockSize(),
+		Frsize:  stat.FragmentSize(),
+		Blocks:  stat.TotalBlockCount(),
+		Bfree:   stat.FreeBlockCount(),
+		Bavail:  stat.AvailableBlockCount(),
+		Files:   files,
+		Ffree:   files,
+		Favail:  files,
+		Namemax: 255,
+	}, nil
+}
+
+func (fs *SMBFs) getCacheNamespace() string {
+	return getHashedNamespace(smbFsName, fs.config.Endpoint, fs.config.Share, fs.config.Username)
+}
+
+// withShare executes fn using the shared connection, the connection is
+// reset after errors that make the session unusable
+func (fs *SMBFs) withShare(fn func(share *smb2.Share) error) error {
+	share, err := fs.conn.getShare()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), smbRequestTimeout)
+	defer cancel()
+
+	err = fn(share.WithContext(ctx))
+	fs.checkSessionError(share, err)
+	return err
+}
+
+func (fs *SMBFs) checkSessionError(share *smb2.Share, err error) {
+	if isSMBSessionError(err) {
+		fsLog(fs, logger.LevelDebug, "the SMB session is no longer usable, it will be recreated: %v", err)
+		fs.conn.reset(share)
+	}
+}
+
+func (*SMBFs) toFileInfo(info os.FileInfo) os.FileInfo {
+	var size int64
+	if !info.IsDir() {
+		size = info.Size()
+	}
+	return NewFileInfo(info.Name(), info.IsDir(), size, info.ModTime(), false)
+}
+
+func (fs *SMBFs) mkdirAll(name string) error {
+	if name == "/" || name == "." {
+		return nil
+	}
+	info, err := fs.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%q is not a directory", name)
+		}
+		return nil
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	if err := fs.mkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	return fs.Mkdir(name)
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *SMBFs) walk(filePath string, info fs.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	var files []os.FileInfo
+	if err == nil {
+		files, err = ReadAllDirEntries(lister)
+	}
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		objName := path.Join(filePath, fi.Name())
+		err = fs.walk(objName, fi, walkFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSMBPath returns the path, relative to the share root, to use in SMB
+// requests. The SMB client does not accept paths with a leading separator
+func getSMBPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// isSMBSessionError returns true if the error makes the SMB session, or the
+// underlying connection, unusable
+func isSMBSessionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var transportErr *smb2.TransportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	var respErr *smb2.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.Code {
+		case smbStatusNetworkNameDeleted, smbStatusUserSessionDeleted, smbStatusNetworkSessionExpired:
+			return true
+		}
+	}
+	return false
+}
+
+type smbDirLister struct {
+	fs    *SMBFs
+	share *smb2.Share
+	f     *smb2.File
+}
+
+func (l *smbDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	entries, err := l.f.Readdir(limit)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		l.fs.checkSessionError(l.share, err)
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, l.fs.toFileInfo(entry))
+	}
+	return files, nil
+}
+
+func (l *smbDirLister) Close() error {
+	return l.f.Close()
+}
+
+// smbConnection is an SMB session, with the configured share mounted, shared
+// by multiple SFTPGo sessions
+type smbConnection struct {
+	config       *SMBFsConfig
+	logSender    string
+	mu           sync.RWMutex
+	netConn      net.Conn
+	session      *smb2.Session
+	share        *smb2.Share
+	sessions     map[string]bool
+	lastActivity time.Time
+}
+
+func newSMBConnection(config *SMBFsConfig, sessionID string) *smbConnection {
+	c := &smbConnection{
+		config:       config,
+		logSender:    fmt.Sprintf(`%s "%s@%s/%s"`, smbFsName, config.Username, config.Endpoint, config.Share),
+		sessions:     map[string]bool{},
+		lastActivity: time.Now().UTC(),
+	}
+	c.sessions[sessionID] = true
+	return c
+}
+
+// getShare returns the mounted share, a new session is established if
+// required
+func (c *smbConnection) getShare() (*smb2.Share, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != nil {
+		return c.share, nil
+	}
+
+	logger.Debug(c.logSender, "", "try to open a new connection")
+	netConn, err := net.DialTimeout("tcp", c.config.Endpoint, smbRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("smbfs: unable to connect to %q: %w", c.config.Endpoint, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), smbRequestTimeout)
+	defer cancel()
+
+	dialer := &smb2.Dialer{
+		// signing is required so guest and anonymous sessions are refused
+		Negotiator: smb2.Negotiator{
+			RequireMessageSigning: true,
+		},
+		Initiator: &smb2.NTLMInitiator{
+			User:     c.config.Username,
+			Password: c.config.Password.GetPayload(),
+			Domain:   c.config.Domain,
+		},
+	}
+	session, err := dialer.DialContext(ctx, netConn)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("smbfs: unable to connect to %q: %w", c.config.Endpoint, err)
+	}
+	share, err := session.WithContext(ctx).Mount(c.config.Share)
+	if err != nil {
+		session.WithContext(ctx).Logoff() //nolint:errcheck
+		netConn.Close()
+		return nil, fmt.Errorf("smbfs: %w", err)
+	}
+	c.netConn = netConn
+	c.session = session
+	c.share = share
+	return share, nil
+}
+
+// reset closes the session if it still uses the specified share, the next
+// request will establish a new one
+func (c *smbConnection) reset(share *smb2.Share) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.share == share {
+		c.closeNoLock()
+	}
+}
+
+func (c *smbConnection) closeNoLock() {
+	if c.session == nil {
+		return
+	}
+	logger.Debug(c.logSender, "", "closing connection")
+	ctx, cancel := context.WithTimeout(context.Background(), smbRequestTimeout)
+	defer cancel()
+
+	// the requests fail immediately if the connection is broken
+	c.share.WithContext(ctx).Umount()   //nolint:errcheck
+	c.session.WithContext(ctx).Logoff() //nolint:errcheck
+	c.netConn.Close()
+	c.netConn = nil
+	c.session = nil
+	c.share = nil
+}
+
+func (c *smbConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeNoLock()
+	return nil
+}
+
+func (c *smbConnection) AddSession(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sessions[sessionID] = true
+	logger.Debug(c.logSender, "", "added session %s, active sessions: %d", sessionID, len(c.sessions))
+}
+
+func (c *smbConnection) RemoveSession(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, sessionID)
+	logger.Debug(c.logSender, "", "removed session %s, active sessions: %d", sessionID, len(c.sessions))
+	if len(c.sessions) == 0 {
+		c.lastActivity = time.Now().UTC()
+	}
+}
+
+func (c *smbConnection) ActiveSessions() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.sessions)
+}
+
+func (c *smbConnection) GetLastActivity() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.sessions) > 0 {
+		return time.Now().UTC()
+	}
+	return c.lastActivity
+}
+
+type smbConnectionsCache struct {
+	scheduler *cron.Cron
+	sync.RWMutex
+	items map[uint64]*smbConnection
+}
+
+func newSMBConnectionCache() *smbConnectionsCache {
+	c := &smbConnectionsCache{
+		scheduler: cron.New(cron.WithLocation(time.UTC), cron.WithLogger(cron.DiscardLogger)),
+		items:     make(map[uint64]*smbConnection),
+	}
+	_, err := c.scheduler.AddFunc("@every 1m", c.Cleanup)
+	util.PanicOnError(err)
+	c.scheduler.Start()
+	return c
+}
+
+// Get returns a connection for the specified config. Connections are
+// partitioned so that each one is shared by a limited number of sessions
+func (c *smbConnectionsCache) Get(config *SMBFsConfig, sessionID string) *smbConnection {
+	c.Lock()
+	defer c.Unlock()
+
+	for partition := 0; ; partition++ {
+		key := config.getUniqueID(partition)
+		conn, ok := c.items[key]
+		if !ok {
+			conn = newSMBConnection(config, sessionID)
+			c.items[key] = conn
+			logger.Debug(logSenderSMBCache, "",
+				"adding new connection for session ID %q, partition: %d, key: %d, active connections: %d",
+				sessionID, partition, key, len(c.items))
+			return conn
+		}
+		if conn.ActiveSessions() < smbMaxSessionsPerConnection {
+			conn.AddSession(sessionID)
+			logger.Debug(logSenderSMBCache, "", "reusing connection for session ID %q, partition: %d, key: %d",
+				sessionID, partition, key)
+			return conn
+		}
+	}
+}
+
+// Cleanup closes the connections without active sessions
+func (c *smbConnectionsCache) Cleanup() {
+	var toClose []*smbConnection
+
+	c.Lock()
+	for key, conn := range c.items {
+		if conn.GetLastActivity().Before(time.Now().Add(-30 * time.Second)) {
+			delete(c.items, key)
+			toClose = append(toClose, conn)
+		}
+	}
+	logger.Debug(logSenderSMBCache, "", "removed %d inactive connections, active connections: %d",
+		len(toClose), len(c.items))
+	c.Unlock()
+
+	for _, conn := range toClose {
+		conn.Close() //nolint:errcheck
+	}
+}
@@ -0,0 +1,1081 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rs/xid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/md4" //nolint:staticcheck
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+)
+
+const (
+	smbTestUser      = "user"
+	smbTestPassword  = "password"
+	smbTestShare     = "share"
+	smbTestMaxIOSize = 65536
+	// number of directory entries returned for each QUERY_DIRECTORY request
+	smbTestDirPageSize = 3
+	smbHeaderSize      = 64
+)
+
+// SMB2 commands
+const (
+	smbCmdNegotiate      = 0x00
+	smbCmdSessionSetup   = 0x01
+	smbCmdLogoff         = 0x02
+	smbCmdTreeConnect    = 0x03
+	smbCmdTreeDisconnect = 0x04
+	smbCmdCreate         = 0x05
+	smbCmdClose          = 0x06
+	smbCmdRead           = 0x08
+	smbCmdWrite          = 0x09
+	smbCmdQueryDirectory = 0x0E
+	smbCmdQueryInfo      = 0x10
+	smbCmdSetInfo        = 0x11
+)
+
+// NTSTATUS codes
+const (
+	smbStatusSuccess                = 0x00000000
+	smbStatusNoMoreFiles            = 0x80000006
+	smbStatusInvalidParameter       = 0xC000000D
+	smbStatusEndOfFile              = 0xC0000011
+	smbStatusMoreProcessingRequired = 0xC0000016
+	smbStatusAccessDenied           = 0xC0000022
+	smbStatusObjectNameNotFound     = 0xC0000034
+	smbStatusObjectNameCollision    = 0xC0000035
+	smbStatusLogonFailure           = 0xC000006D
+	smbStatusFileIsADirectory       = 0xC00000BA
+	smbStatusNotSupported           = 0xC00000BB
+	smbStatusBadNetworkName         = 0xC00000CC
+	smbStatusDirectoryNotEmpty      = 0xC0000101
+	smbStatusNotADirectory          = 0xC0000103
+)
+
+const (
+	smbFlagServerToRedir = 0x00000001
+	smbFlagSigned        = 0x00000008
+
+	smbAccessWriteData    = 0x00000002
+	smbAccessGenericWrite = 0x40000000
+
+	smbDispositionOpen        = 1
+	smbDispositionCreate      = 2
+	smbDispositionOpenIf      = 3
+	smbDispositionOverwrite   = 4
+	smbDispositionOverwriteIf = 5
+
+	smbOptionDirectoryFile    = 0x00000001
+	smbOptionNonDirectoryFile = 0x00000040
+
+	smbAttributeDirectory = 0x00000010
+	smbAttributeNormal    = 0x00000080
+
+	smbInfoTypeFile       = 1
+	smbInfoTypeFilesystem = 2
+
+	smbFileBasicInformation       = 4
+	smbFileStandardInformation    = 5
+	smbFileRenameInformation      = 10
+	smbFileDispositionInformation = 13
+	smbFileEndOfFileInformation   = 20
+	smbFileFsFullSizeInformation  = 7
+
+	ntlmNegotiateKeyExch = 0x40000000
+)
+
+var (
+	ntlmSignature = []byte("NTLMSSP\x00")
+	ntlmOid       = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 311, 2, 2, 10})
+)
+
+// smbTestServer is a minimal in-process SMB 2.0.2 server, with NTLM
+// authentication and signing, used to test SMBFs. Files are served from
+// a temporary directory
+type smbTestServer struct {
+	root     string
+	listener net.Listener
+
+	mu sync.Mutex
+	// the status to return for the next CREATE request, if not zero
+	failNextCreate   uint32
+	conns            []net.Conn
+	acceptedConns    int
+	queryDirRequests int
+}
+
+func startSMBTestServer(t *testing.T) *smbTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &smbTestServer{
+		root:     t.TempDir(),
+		listener: listener,
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		srv.closeConnections()
+	})
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, c)
+			srv.acceptedConns++
+			srv.mu.Unlock()
+
+			sc := &smbTestServerConn{
+				srv:     srv,
+				netConn: c,
+				handles: make(map[uint64]*smbTestHandle),
+			}
+			go sc.serve()
+		}
+	}()
+	return srv
+}
+
+func (s *smbTestServer) getConfig(password string) SMBFsConfig {
+	return SMBFsConfig{
+		Endpoint: s.listener.Addr().String(),
+		Username: smbTestUser,
+		Password: kms.NewPlainSecret(password),
+		Share:    smbTestShare,
+	}
+}
+
+func (s *smbTestServer) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *smbTestServer) setFailNextCreate(status uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failNextCreate = status
+}
+
+func (s *smbTestServer) getFailNextCreate() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.failNextCreate
+	s.failNextCreate = 0
+	return status
+}
+
+func (s *smbTestServer) getAcceptedConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acceptedConns
+}
+
+func (s *smbTestServer) getQueryDirRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queryDirRequests
+}
+
+type smbTestHandle struct {
+	path          string
+	file          *os.File
+	isDir         bool
+	entries       []string
+	listed        bool
+	deleteOnClose bool
+}
+
+type smbTestServerConn struct {
+	srv        *smbTestServer
+	netConn    net.Conn
+	wmu        sync.Mutex
+	mu         sync.Mutex
+	challenge  []byte
+	sessionKey []byte
+	handles    map[uint64]*smbTestHandle
+	nextHandle uint64
+}
+
+func (c *smbTestServerConn) serve() {
+	defer c.netConn.Close()
+
+	var frameHdr [4]byte
+	for {
+		if _, err := io.ReadFull(c.netConn, frameHdr[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(frameHdr[:]))
+		if _, err := io.ReadFull(c.netConn, msg); err != nil {
+			return
+		}
+		if len(msg) < smbHeaderSize || string(msg[:4]) != "\xfeSMB" {
+			return
+		}
+		command := binary.LittleEndian.Uint16(msg[12:])
+		if sessionKey := c.getSessionKey(); sessionKey != nil && command != smbCmdSessionSetup {
+			if binary.LittleEndian.Uint32(msg[16:])&smbFlagSigned == 0 || !smbVerify(sessionKey, msg) {
+				return
+			}
+		}
+		// handle the requests concurrently, as a real server does
+		go c.handle(msg)
+	}
+}
+
+func (c *smbTestServerConn) getSessionKey() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sessionKey
+}
+
+func (c *smbTestServerConn) handle(msg []byte) { //nolint:gocyclo
+	var status uint32
+	var body []byte
+
+	command := binary.LittleEndian.Uint16(msg[12:])
+	sessionID := binary.LittleEndian.Uint64(msg[40:])
+	treeID := binary.LittleEndian.Uint32(msg[36:])
+
+	switch command {
+	case smbCmdNegotiate:
+		status, body = c.negotiate()
+	case smbCmdSessionSetup:
+		status, body = c.sessionSetup(msg)
+		if status == smbStatusMoreProcessingRequired {
+			sessionID = 0x1234
+		}
+	case smbCmdLogoff, smbCmdTreeDisconnect:
+		body = make([]byte, 4)
+		binary.LittleEndian.PutUint16(body, 4)
+	case smbCmdTreeConnect:
+		status, body = c.treeConnect(msg)
+		treeID = 1
+	case smbCmdCreate:
+		status, body = c.create(msg)
+	case smbCmdClose:
+		status, body = c.closeHandle(msg)
+	case smbCmdRead:
+		status, body = c.read(msg)
+	case smbCmdWrite:
+		status, body = c.write(msg)
+	case smbCmdQueryDirectory:
+		status, body = c.queryDirectory(msg)
+	case smbCmdQueryInfo:
+		status, body = c.queryInfo(msg)
+	case smbCmdSetInfo:
+		status, body = c.setInfo(msg)
+	default:
+		status = smbStatusNotSupported
+	}
+	if status != smbStatusSuccess && status != smbStatusMoreProcessingRequired {
+		body = make([]byte, 9)
+		binary.LittleEndian.PutUint16(body, 9)
+	}
+	credits := binary.LittleEndian.Uint16(msg[14:])
+	if credits == 0 {
+		credits = 1
+	}
+	out := make([]byte, smbHeaderSize, smbHeaderSize+len(body))
+	copy(out, msg[:smbHeaderSize])
+	binary.LittleEndian.PutUint32(out[8:], status)
+	binary.LittleEndian.PutUint16(out[14:], credits)
+	binary.LittleEndian.PutUint32(out[16:], smbFlagServerToRedir)
+	binary.LittleEndian.PutUint32(out[20:], 0)
+	binary.LittleEndian.PutUint32(out[36:], treeID)
+	binary.LittleEndian.PutUint64(out[40:], sessionID)
+	copy(out[48:], make([]byte, 16))
+	out = append(out, body...)
+	if sessionKey := c.getSessionKey(); sessionKey != nil && command != smbCmdSessionSetup {
+		smbSign(sessionKey, out)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	frame := make([]byte, 4, 4+len(out))
+	binary.BigEndian.PutUint32(frame, uint32(len(out)))
+	c.netConn.Write(append(frame, out...)) //nolint:errcheck
+}
+
+func (c *smbTestServerConn) negotiate() (uint32, []byte) {
+	body := make([]byte, 64)
+	binary.LittleEndian.PutUint16(body, 65)
+	// signing enabled and required
+	binary.LittleEndian.PutUint16(body[2:], 0x03)
+	// SMB 2.0.2
+	binary.LittleEndian.PutUint16(body[4:], 0x0202)
+	binary.LittleEndian.PutUint32(body[28:], smbTestMaxIOSize)
+	binary.LittleEndian.PutUint32(body[32:], smbTestMaxIOSize)
+	binary.LittleEndian.PutUint32(body[36:], smbTestMaxIOSize)
+	binary.LittleEndian.PutUint64(body[40:], smbToFiletime(time.Now()))
+	binary.LittleEndian.PutUint16(body[56:], smbHeaderSize+64)
+	return smbStatusSuccess, body
+}
+
+func (c *smbTestServerConn) sessionSetup(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	offset := int(binary.LittleEndian.Uint16(b[12:]))
+	length := int(binary.LittleEndian.Uint16(b[14:]))
+	if offset+length > len(msg) {
+		return smbStatusInvalidParameter, nil
+	}
+	token := msg[offset : offset+length]
+	idx := bytes.Index(token, ntlmSignature)
+	if idx < 0 {
+		return smbStatusInvalidParameter, nil
+	}
+	ntlmMsg := token[idx:]
+
+	switch binary.LittleEndian.Uint32(ntlmMsg[8:]) {
+	case 1:
+		c.challenge = make([]byte, 8)
+		rand.Read(c.challenge) //nolint:errcheck
+		targetName := smbEncodeUTF16(smbTestShare)
+		challenge := make([]byte, 56, 56+len(targetName)+4)
+		copy(challenge, ntlmSignature)
+		binary.LittleEndian.PutUint32(challenge[8:], 2)
+		binary.LittleEndian.PutUint16(challenge[12:], uint16(len(targetName)))
+		binary.LittleEndian.PutUint16(challenge[14:], uint16(len(targetName)))
+		binary.LittleEndian.PutUint32(challenge[16:], 56)
+		// reply with the flags requested by the client
+		copy(challenge[20:24], ntlmMsg[12:16])
+		copy(challenge[24:], c.challenge)
+		binary.LittleEndian.PutUint16(challenge[40:], 4)
+		binary.LittleEndian.PutUint16(challenge[42:], 4)
+		binary.LittleEndian.PutUint32(challenge[44:], uint32(56+len(targetName)))
+		challenge = append(challenge, targetName...)
+		// target info with MsvAvEOL only
+		challenge = append(challenge, 0, 0, 0, 0)
+		resp, err := asn1.MarshalWithParams(struct {
+			NegState      asn1.Enumerated       `asn1:"optional,explicit,tag:0"`
+			SupportedMech asn1.ObjectIdentifier `asn1:"optional,explicit,tag:1"`
+			ResponseToken []byte                `asn1:"optional,explicit,tag:2"`
+		}{
+			NegState:      1,
+			SupportedMech: ntlmOid,
+			ResponseToken: challenge,
+		}, "explicit,tag:1")
+		if err != nil {
+			return smbStatusInvalidParameter, nil
+		}
+		return smbStatusMoreProcessingRequired, smbSessionSetupResponse(resp)
+	case 3:
+		ntResponse := getNTLMField(ntlmMsg, 20)
+		if len(ntResponse) < 16 || c.challenge == nil {
+			return smbStatusInvalidParameter, nil
+		}
+		domain := getNTLMField(ntlmMsg, 28)
+		user := smbDecodeUTF16(getNTLMField(ntlmMsg, 36))
+		if user != smbTestUser {
+			return smbStatusLogonFailure, nil
+		}
+		h := md4.New()
+		h.Write(smbEncodeUTF16(smbTestPassword))
+		mac := hmac.New(md5.New, h.Sum(nil))
+		mac.Write(smbEncodeUTF16(strings.ToUpper(user)))
+		mac.Write(domain)
+		ntowf := mac.Sum(nil)
+
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(c.challenge)
+		mac.Write(ntResponse[16:])
+		if !hmac.Equal(mac.Sum(nil), ntResponse[:16]) {
+			return smbStatusLogonFailure, nil
+		}
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(ntResponse[:16])
+		sessionKey := mac.Sum(nil)
+		if binary.LittleEndian.Uint32(ntlmMsg[60:])&ntlmNegotiateKeyExch != 0 {
+			encryptedKey := getNTLMField(ntlmMsg, 52)
+			if len(encryptedKey) != 16 {
+				return smbStatusInvalidParameter, nil
+			}
+			cipher, err := rc4.NewCipher(sessionKey)
+			if err != nil {
+				return smbStatusInvalidParameter, nil
+			}
+			exportedKey := make([]byte, 16)
+			cipher.XORKeyStream(exportedKey, encryptedKey)
+			sessionKey = exportedKey
+		}
+		c.mu.Lock()
+		c.sessionKey = sessionKey
+		c.mu.Unlock()
+		return smbStatusSuccess, smbSessionSetupResponse(nil)
+	default:
+		return smbStatusInvalidParameter, nil
+	}
+}
+
+func (c *smbTestServerConn) treeConnect(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	offset := int(binary.LittleEndian.Uint16(b[4:]))
+	length := int(binary.LittleEndian.Uint16(b[6:]))
+	path := smbDecodeUTF16(msg[offset : offset+length])
+	if !strings.HasPrefix(path, `\\`) || !strings.HasSuffix(path, `\`+smbTestShare) {
+		return smbStatusBadNetworkName, nil
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint16(body, 16)
+	body[2] = 1
+	return smbStatusSuccess, body
+}
+
+func (c *smbTestServerConn) localPath(name []byte) string {
+	return filepath.Join(c.srv.root, filepath.FromSlash(strings.ReplaceAll(smbDecodeUTF16(name), `\`, "/")))
+}
+
+func (c *smbTestServerConn) getHandle(b []byte) (*smbTestHandle, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.handles[binary.LittleEndian.Uint64(b)]
+	return h, ok
+}
+
+func (c *smbTestServerConn) create(msg []byte) (uint32, []byte) { //nolint:gocyclo
+	if status := c.srv.getFailNextCreate(); status != 0 {
+		return status, nil
+	}
+	b := msg[smbHeaderSize:]
+	access := binary.LittleEndian.Uint32(b[24:])
+	disposition := binary.LittleEndian.Uint32(b[36:])
+	options := binary.LittleEndian.Uint32(b[40:])
+	offset := int(binary.LittleEndian.Uint16(b[44:]))
+	length := int(binary.LittleEndian.Uint16(b[46:]))
+	var name []byte
+	if length > 0 {
+		name = msg[offset : offset+length]
+	}
+	p := c.localPath(name)
+
+	info, err := os.Stat(p)
+	exists := err == nil
+	switch {
+	case disposition == smbDispositionCreate && exists:
+		return smbStatusObjectNameCollision, nil
+	case (disposition == smbDispositionOpen || disposition == smbDispositionOverwrite) && !exists:
+		return smbStatusObjectNameNotFound, nil
+	case exists && info.IsDir() && options&smbOptionNonDirectoryFile != 0:
+		return smbStatusFileIsADirectory, nil
+	case exists && !info.IsDir() && options&smbOptionDirectoryFile != 0:
+		return smbStatusNotADirectory, nil
+	}
+	h := &smbTestHandle{path: p}
+	if options&smbOptionDirectoryFile != 0 || (exists && info.IsDir()) {
+		h.isDir = true
+		if !exists {
+			if err := os.Mkdir(p, 0755); err != nil {
+				return smbTestToStatus(err), nil
+			}
+		}
+	} else {
+		flag := os.O_RDONLY
+		if access&(smbAccessWriteData|smbAccessGenericWrite) != 0 {
+			flag = os.O_RDWR
+		}
+		switch disposition {
+		case smbDispositionOpenIf:
+			flag |= os.O_CREATE
+		case smbDispositionCreate:
+			flag |= os.O_CREATE | os.O_EXCL
+		case smbDispositionOverwriteIf:
+			flag |= os.O_CREATE | os.O_TRUNC
+		case smbDispositionOverwrite:
+			flag |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(p, flag, 0644)
+		if err != nil {
+			return smbTestToStatus(err), nil
+		}
+		h.file = f
+	}
+	info, err = os.Stat(p)
+	if err != nil {
+		return smbTestToStatus(err), nil
+	}
+	c.mu.Lock()
+	c.nextHandle++
+	id := c.nextHandle
+	c.handles[id] = h
+	c.mu.Unlock()
+
+	body := make([]byte, 88)
+	binary.LittleEndian.PutUint16(body, 89)
+	binary.LittleEndian.PutUint64(body[24:], smbToFiletime(info.ModTime()))
+	binary.LittleEndian.PutUint64(body[48:], uint64(info.Size()))
+	binary.LittleEndian.PutUint32(body[56:], smbTestAttributes(info))
+	binary.LittleEndian.PutUint64(body[64:], id)
+	binary.LittleEndian.PutUint64(body[72:], id)
+	return smbStatusSuccess, body
+}
+
+func (c *smbTestServerConn) closeHandle(msg []byte) (uint32, []byte) {
+	id := binary.LittleEndian.Uint64(msg[smbHeaderSize+8:])
+	c.mu.Lock()
+	h, ok := c.handles[id]
+	delete(c.handles, id)
+	c.mu.Unlock()
+	if !ok {
+		return smbStatusInvalidParameter, nil
+	}
+	if h.file != nil {
+		h.file.Close()
+	}
+	if h.deleteOnClose {
+		if err := os.Remove(h.path); err != nil {
+			return smbTestToStatus(err), nil
+		}
+	}
+	body := make([]byte, 60)
+	binary.LittleEndian.PutUint16(body, 60)
+	return smbStatusSuccess, body
+}
+
+func (c *smbTestServerConn) read(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	length := binary.LittleEndian.Uint32(b[4:])
+	offset := int64(binary.LittleEndian.Uint64(b[8:]))
+	h, ok := c.getHandle(b[16:])
+	if !ok || h.file == nil || length > smbTestMaxIOSize {
+		return smbStatusInvalidParameter, nil
+	}
+	data := make([]byte, length)
+	n, err := h.file.ReadAt(data, offset)
+	if n == 0 && err == io.EOF {
+		return smbStatusEndOfFile, nil
+	}
+	if err != nil && err != io.EOF {
+		return smbTestToStatus(err), nil
+	}
+	body := make([]byte, 16, 16+n)
+	binary.LittleEndian.PutUint16(body, 17)
+	body[2] = smbHeaderSize + 16
+	binary.LittleEndian.PutUint32(body[4:], uint32(n))
+	return smbStatusSuccess, append(body, data[:n]...)
+}
+
+func (c *smbTestServerConn) write(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	dataOffset := int(binary.LittleEndian.Uint16(b[2:]))
+	length := int(binary.LittleEndian.Uint32(b[4:]))
+	offset := int64(binary.LittleEndian.Uint64(b[8:]))
+	h, ok := c.getHandle(b[16:])
+	if !ok || h.file == nil || length > smbTestMaxIOSize {
+		return smbStatusInvalidParameter, nil
+	}
+	n, err := h.file.WriteAt(msg[dataOffset:dataOffset+length], offset)
+	if err != nil {
+		return smbTestToStatus(err), nil
+	}
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint16(body, 17)
+	binary.LittleEndian.PutUint32(body[4:], uint32(n))
+	return smbStatusSuccess, body
+}
+
+// queryDirectory returns at most smbTestDirPageSize entries for each request
+// so the client must page through the results
+func (c *smbTestServerConn) queryDirectory(msg []byte) (uint32, []byte) {
+	c.srv.mu.Lock()
+	c.srv.queryDirRequests++
+	c.srv.mu.Unlock()
+
+	b := msg[smbHeaderSize:]
+	h, ok := c.getHandle(b[8:])
+	if !ok || !h.isDir {
+		return smbStatusInvalidParameter, nil
+	}
+	if !h.listed {
+		h.listed = true
+		entries, err := os.ReadDir(h.path)
+		if err != nil {
+			return smbTestToStatus(err), nil
+		}
+		h.entries = []string{".", ".."}
+		for _, entry := range entries {
+			h.entries = append(h.entries, entry.Name())
+		}
+	}
+	if len(h.entries) == 0 {
+		return smbStatusNoMoreFiles, nil
+	}
+	names := h.entries
+	if len(names) > smbTestDirPageSize {
+		names = names[:smbTestDirPageSize]
+	}
+	h.entries = h.entries[len(names):]
+
+	var output []byte
+	for idx, name := range names {
+		info, err := os.Stat(filepath.Join(h.path, name))
+		if err != nil {
+			return smbTestToStatus(err), nil
+		}
+		encodedName := smbEncodeUTF16(name)
+		entry := make([]byte, 64, 72+len(encodedName))
+		binary.LittleEndian.PutUint64(entry[24:], smbToFiletime(info.ModTime()))
+		binary.LittleEndian.PutUint64(entry[40:], uint64(info.Size()))
+		binary.LittleEndian.PutUint32(entry[56:], smbTestAttributes(info))
+		binary.LittleEndian.PutUint32(entry[60:], uint32(len(encodedName)))
+		entry = append(entry, encodedName...)
+		if idx < len(names)-1 {
+			for len(entry)%8 != 0 {
+				entry = append(entry, 0)
+			}
+			binary.LittleEndian.PutUint32(entry, uint32(len(entry)))
+		}
+		output = append(output, entry...)
+	}
+	return smbStatusSuccess, smbOutputBufferResponse(output)
+}
+
+func (c *smbTestServerConn) queryInfo(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	h, ok := c.getHandle(b[24:])
+	if !ok {
+		return smbStatusInvalidParameter, nil
+	}
+	switch {
+	case b[2] == smbInfoTypeFile && b[3] == smbFileStandardInformation:
+		info, err := os.Stat(h.path)
+		if err != nil {
+			return smbTestToStatus(err), nil
+		}
+		output := make([]byte, 24)
+		binary.LittleEndian.PutUint64(output, uint64(info.Size()))
+		binary.LittleEndian.PutUint64(output[8:], uint64(info.Size()))
+		binary.LittleEndian.PutUint32(output[16:], 1)
+		if info.IsDir() {
+			output[21] = 1
+		}
+		return smbStatusSuccess, smbOutputBufferResponse(output)
+	case b[2] == smbInfoTypeFilesystem && b[3] == smbFileFsFullSizeInformation:
+		output := make([]byte, 32)
+		binary.LittleEndian.PutUint64(output, 1000)
+		binary.LittleEndian.PutUint64(output[8:], 500)
+		binary.LittleEndian.PutUint64(output[16:], 500)
+		binary.LittleEndian.PutUint32(output[24:], 8)
+		binary.LittleEndian.PutUint32(output[28:], 512)
+		return smbStatusSuccess, smbOutputBufferResponse(output)
+	default:
+		return smbStatusNotSupported, nil
+	}
+}
+
+func (c *smbTestServerConn) setInfo(msg []byte) (uint32, []byte) {
+	b := msg[smbHeaderSize:]
+	length := int(binary.LittleEndian.Uint32(b[4:]))
+	offset := int(binary.LittleEndian.Uint16(b[8:]))
+	h, ok := c.getHandle(b[16:])
+	if !ok || b[2] != smbInfoTypeFile {
+		return smbStatusInvalidParameter, nil
+	}
+	info := msg[offset : offset+length]
+	var err error
+	switch b[3] {
+	case smbFileDispositionInformation:
+		if h.isDir {
+			entries, err := os.ReadDir(h.path)
+			if err == nil && len(entries) > 0 {
+				return smbStatusDirectoryNotEmpty, nil
+			}
+		}
+		h.deleteOnClose = info[0] != 0
+	case smbFileRenameInformation:
+		nameLength := int(binary.LittleEndian.Uint32(info[16:]))
+		target := c.localPath(info[20 : 20+nameLength])
+		// honor ReplaceIfExists as Windows and Samba do
+		if _, errStat := os.Stat(target); errStat == nil && info[0] == 0 {
+			return smbStatusObjectNameCollision, nil
+		}
+		err = os.Rename(h.path, target)
+	case smbFileBasicInformation:
+		atime := smbFromFiletime(binary.LittleEndian.Uint64(info[8:]))
+		mtime := smbFromFiletime(binary.LittleEndian.Uint64(info[16:]))
+		err = os.Chtimes(h.path, atime, mtime)
+	case smbFileEndOfFileInformation:
+		err = os.Truncate(h.path, int64(binary.LittleEndian.Uint64(info)))
+	default:
+		return smbStatusNotSupported, nil
+	}
+	if err != nil {
+		return smbTestToStatus(err), nil
+	}
+	body := make([]byte, 2)
+	binary.LittleEndian.PutUint16(body, 2)
+	return smbStatusSuccess, body
+}
+
+func smbSessionSetupResponse(token []byte) []byte {
+	body := make([]byte, 8, 8+len(token))
+	binary.LittleEndian.PutUint16(body, 9)
+	binary.LittleEndian.PutUint16(body[4:], smbHeaderSize+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(token)))
+	return append(body, token...)
+}
+
+func smbOutputBufferResponse(output []byte) []byte {
+	body := make([]byte, 8, 8+len(output))
+	binary.LittleEndian.PutUint16(body, 9)
+	binary.LittleEndian.PutUint16(body[2:], smbHeaderSize+8)
+	binary.LittleEndian.PutUint32(body[4:], uint32(len(output)))
+	return append(body, output...)
+}
+
+// smbSign signs an SMB 2.0.2 message using HMAC-SHA256
+func smbSign(sessionKey, msg []byte) {
+	binary.LittleEndian.PutUint32(msg[16:], binary.LittleEndian.Uint32(msg[16:])|smbFlagSigned)
+	copy(msg[48:64], make([]byte, 16))
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write(msg)
+	copy(msg[48:64], mac.Sum(nil))
+}
+
+func smbVerify(sessionKey, msg []byte) bool {
+	signature := append([]byte{}, msg[48:64]...)
+	copy(msg[48:64], make([]byte, 16))
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write(msg)
+	copy(msg[48:64], signature)
+	return hmac.Equal(mac.Sum(nil)[:16], signature)
+}
+
+func getNTLMField(msg []byte, offset int) []byte {
+	if len(msg) < offset+8 {
+		return nil
+	}
+	length := int(binary.LittleEndian.Uint16(msg[offset:]))
+	start := int(binary.LittleEndian.Uint32(msg[offset+4:]))
+	if start+length > len(msg) {
+		return nil
+	}
+	return msg[start : start+length]
+}
+
+func smbTestToStatus(err error) uint32 {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return smbStatusObjectNameNotFound
+	case errors.Is(err, fs.ErrExist):
+		return smbStatusObjectNameCollision
+	case errors.Is(err, fs.ErrPermission):
+		return smbStatusAccessDenied
+	default:
+		return smbStatusInvalidParameter
+	}
+}
+
+func smbTestAttributes(info fs.FileInfo) uint32 {
+	if info.IsDir() {
+		return smbAttributeDirectory
+	}
+	return smbAttributeNormal
+}
+
+func smbEncodeUTF16(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(codes))
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], code)
+	}
+	return b
+}
+
+func smbDecodeUTF16(b []byte) string {
+	codes := make([]uint16, len(b)/2)
+	for i := range codes {
+		codes[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(codes))
+}
+
+func smbToFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + 116444736000000000)
+}
+
+func smbFromFiletime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-116444736000000000)*100)
+}
+
+func newSMBFsForTest(t *testing.T, srv *smbTestServer) *SMBFs {
+	fs, err := NewSMBFs(xid.New().String(), t.TempDir(), "", srv.getConfig(smbTestPassword))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		fs.Close() //nolint:errcheck
+	})
+	return fs.(*SMBFs)
+}
+
+func smbUploadForTest(t *testing.T, fs *SMBFs, name string, flag int, offset int64, data []byte) {
+	_, w, cancelFn, err := fs.Create(name, flag, 0)
+	require.NoError(t, err)
+	require.NotNil(t, cancelFn)
+	n, err := w.WriteAt(data, offset)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	require.NoError(t, w.Close())
+}
+
+func smbDownloadForTest(t *testing.T, fs *SMBFs, name string, offset int64) []byte {
+	_, r, cancelFn, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	require.NotNil(t, cancelFn)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	return data
+}
+
+func TestSMBFsLogin(t *testing.T) {
+	srv := startSMBTestServer(t)
+	_, err := NewSMBFs(xid.New().String(), t.TempDir(), "", srv.getConfig("wrong password"))
+	assert.Error(t, err)
+
+	config := srv.getConfig(smbTestPassword)
+	config.Share = "missing"
+	_, err = NewSMBFs(xid.New().String(), t.TempDir(), "", config)
+	assert.Error(t, err)
+
+	fs := newSMBFsForTest(t, srv)
+	stat, err := fs.GetAvailableDiskSize("/")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(512), stat.Bsize)
+	assert.Equal(t, uint64(500), stat.Bavail)
+}
+
+func TestSMBFsUploadDownload(t *testing.T) {
+	srv := startSMBTestServer(t)
+	fs := newSMBFsForTest(t, srv)
+
+	smbUploadForTest(t, fs, "/file.txt", 0, 0, []byte("hello world"))
+	data, err := os.ReadFile(filepath.Join(srv.root, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	info, err := fs.Stat("/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+	assert.False(t, info.IsDir())
+
+	assert.Equal(t, []byte("hello world"), smbDownloadForTest(t, fs, "/file.txt", 0))
+	assert.Equal(t, []byte("world"), smbDownloadForTest(t, fs, "/file.txt", 6))
+	// a large file requires multiple read and write requests
+	largeData := make([]byte, 3*smbTestMaxIOSize+100)
+	_, err = rand.Read(largeData)
+	require.NoError(t, err)
+	smbUploadForTest(t, fs, "/large", 0, 0, largeData)
+	assert.Equal(t, largeData, smbDownloadForTest(t, fs, "/large", 0))
+	assert.Equal(t, largeData[smbTestMaxIOSize+1:], smbDownloadForTest(t, fs, "/large", smbTestMaxIOSize+1))
+	// resume the upload, the new data are appended
+	smbUploadForTest(t, fs, "/file.txt", os.O_WRONLY|os.O_CREATE, 11, []byte(" again"))
+	assert.Equal(t, []byte("hello world again"), smbDownloadForTest(t, fs, "/file.txt", 0))
+	// writing before the resume offset is not allowed
+	_, w, _, err := fs.Create("/file.txt", os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = w.WriteAt([]byte("data"), 0)
+	assert.Error(t, err)
+	require.NoError(t, w.Close())
+	// O_TRUNC overwrites the file
+	smbUploadForTest(t, fs, "/file.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0, []byte("new"))
+	assert.Equal(t, []byte("new"), smbDownloadForTest(t, fs, "/file.txt", 0))
+
+	_, _, _, err = fs.Create("/missing/file.txt", 0, CheckParentDir)
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+	_, _, _, err = fs.Open("/missing.txt", 0)
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+
+	err = fs.Truncate("/file.txt", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("n"), smbDownloadForTest(t, fs, "/file.txt", 0))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = fs.Chtimes("/file.txt", mtime, mtime, false)
+	assert.NoError(t, err)
+	info, err = fs.Stat("/file.txt")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()), "unexpected mtime %v", info.ModTime())
+	err = fs.Remove("/file.txt", false)
+	assert.NoError(t, err)
+	_, err = fs.Stat("/file.txt")
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+}
+
+func TestSMBFsRename(t *testing.T) {
+	srv := startSMBTestServer(t)
+	fs := newSMBFsForTest(t, srv)
+
+	smbUploadForTest(t, fs, "/a", 0, 0, []byte("a"))
+	smbUploadForTest(t, fs, "/b", 0, 0, []byte("b"))
+	_, _, err := fs.Rename("/a", "/c")
+	require.NoError(t, err)
+	// an existing target file is replaced
+	_, _, err = fs.Rename("/c", "/b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), smbDownloadForTest(t, fs, "/b", 0))
+	_, err = fs.Stat("/c")
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+	// atomic uploads rely on this behavior
+	uploadPath := fs.GetAtomicUploadPath("/b")
+	smbUploadForTest(t, fs, uploadPath, 0, 0, []byte("atomic"))
+	_, _, err = fs.Rename(uploadPath, "/b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("atomic"), smbDownloadForTest(t, fs, "/b", 0))
+	// an existing directory is never replaced
+	err = fs.Mkdir("/dir")
+	require.NoError(t, err)
+	smbUploadForTest(t, fs, "/dir/file", 0, 0, []byte("file"))
+	_, _, err = fs.Rename("/b", "/dir")
+	assert.Error(t, err)
+	assert.Equal(t, []byte("file"), smbDownloadForTest(t, fs, "/dir/file", 0))
+	assert.Equal(t, []byte("atomic"), smbDownloadForTest(t, fs, "/b", 0))
+	err = fs.Mkdir("/dir1")
+	require.NoError(t, err)
+	_, _, err = fs.Rename("/dir1", "/dir")
+	assert.Error(t, err)
+	_, _, err = fs.Rename("/dir1", "/dir2")
+	assert.NoError(t, err)
+	info, err := fs.Stat("/dir2")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	_, _, err = fs.Rename("/missing", "/b")
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+}
+
+func TestSMBFsReadDir(t *testing.T) {
+	srv := startSMBTestServer(t)
+	fs := newSMBFsForTest(t, srv)
+
+	err := fs.Mkdir("/dir")
+	require.NoError(t, err)
+	var expected []string
+	for i := 0; i < 10; i++ {
+		name := string(rune('a' + i))
+		smbUploadForTest(t, fs, "/dir/"+name, 0, 0, []byte(name))
+		expected = append(expected, name)
+	}
+	err = fs.Mkdir("/dir/sub")
+	require.NoError(t, err)
+	expected = append(expected, "sub")
+
+	lister, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	_, err = lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidDirListerLimit)
+	var names []string
+	var pages []int
+	for {
+		files, err := lister.Next(4)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		pages = append(pages, len(files))
+		for _, info := range files {
+			names = append(names, info.Name())
+			if info.Name() == "sub" {
+				assert.True(t, info.IsDir())
+			} else {
+				assert.Equal(t, int64(1), info.Size())
+			}
+		}
+	}
+	require.NoError(t, lister.Close())
+	assert.Equal(t, []int{4, 4, 3}, pages)
+	sort.Strings(names)
+	assert.Equal(t, expected, names)
+	// 13 entries, including "." and "..", and a final request to get
+	// STATUS_NO_MORE_FILES
+	assert.Equal(t, 6, srv.getQueryDirRequests())
+
+	numFiles, size, err := fs.GetDirSize("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, 10, numFiles)
+	assert.Equal(t, int64(10), size)
+
+	_, err = fs.ReadDir("/missing")
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+}
+
+func TestSMBFsSessionReset(t *testing.T) {
+	srv := startSMBTestServer(t)
+	fs := newSMBFsForTest(t, srv)
+
+	smbUploadForTest(t, fs, "/file", 0, 0, []byte("data"))
+	assert.Equal(t, 1, srv.getAcceptedConns())
+	// other errors do not reset the session
+	_, err := fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err), "unexpected error: %v", err)
+	srv.setFailNextCreate(smbStatusAccessDenied)
+	_, err = fs.Stat("/file")
+	assert.True(t, fs.IsPermission(err), "unexpected error: %v", err)
+	_, err = fs.Stat("/file")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, srv.getAcceptedConns())
+	// the server deleted the session, a new one is established
+	srv.setFailNextCreate(smbStatusUserSessionDeleted)
+	_, err = fs.Stat("/file")
+	assert.Error(t, err)
+	assert.True(t, isSMBSessionError(err))
+	info, err := fs.Stat("/file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size())
+	assert.Equal(t, 2, srv.getAcceptedConns())
+	// the connection is broken, a new one is established
+	srv.closeConnections()
+	_, err = fs.Stat("/file")
+	assert.True(t, isSMBSessionError(err), "unexpected error: %v", err)
+	assert.Equal(t, []byte("data"), smbDownloadForTest(t, fs, "/file", 0))
+	assert.Equal(t, 3, srv.getAcceptedConns())
+	// the same applies to transfers
+	srv.setFailNextCreate(smbStatusUserSessionDeleted)
+	_, _, _, err = fs.Open("/file", 0)
+	assert.True(t, isSMBSessionError(err))
+	assert.Equal(t, []byte("data"), smbDownloadForTest(t, fs, "/file", 0))
+	assert.Equal(t, 4, srv.getAcceptedConns())
+}
@@ -48,6 +48,15 @@ const (
 // Additional checks for files
 const (
 	CheckParentDir = 1
+	// CheckResume allows to resume the upload if it is interrupted. It only
+	// affects cloud storage providers, if resuming uploads is enabled, and it
+	// should only be set for protocols that can resume uploads
+	CheckResume = 2
+)
+
+const (
+	// ListerBatchSize defines the default number of entries to request to a DirLister
+	ListerBatchSize = 1000
 )
 
 var (
@@ -55,11 +64,13 @@ var (
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
-	ErrVfsUnsupported    = errors.New("not supported")
-	tempPath             string
-	sftpFingerprints     []string
-	allowSelfConnections int
-	renameMode           int
+	ErrVfsUnsupported        = errors.New("not supported")
+	errInvalidDirListerLimit = errors.New("dir lister: invalid limit, must be > 0")
+	tempPath                 string
+	sftpFingerprints         []string
+	allowSelfConnections     int
+	renameMode               int
+	cloudUploadResume        bool
 )
 
 // SetAllowSelfConnections sets the desired behaviour for self connections
@@ -87,6 +98,11 @@ func SetRenameMode(val int) {
 	renameMode = val
 }
 
+// SetCloudUploadResume enables or disables resumable uploads for cloud storage providers
+func SetCloudUploadResume(enabled bool) {
+	cloudUploadResume = enabled
+}
+
 // Fs defines the interface for filesystem backends
 type Fs interface {
 	Name() string
@@ -103,7 +119,7 @@ type Fs interface {
 	Chmod(name string, mode os.FileMode) error
 	Chtimes(name string, atime, mtime time.Time, isUploading bool) error
 	Truncate(name string, size int64) error
-	ReadDir(dirname string) ([]os.FileInfo, error)
+	ReadDir(dirname string) (DirLister, error)
 	Readlink(name string) (string, error)
 	IsUploadResumeSupported() bool
 	IsAtomicUploadSupported() bool
@@ -125,6 +141,16 @@ type Fs interface {
 	Close() error
 }
 
+// DirLister defines an interface to iterate over the entries of a directory
+// without loading all of them in memory
+type DirLister interface {
+	// Next returns at most limit entries. It can return fewer entries, even none,
+	// if some are filtered out. io.EOF is returned, possibly together with the last
+	// entries, when there are no more entries
+	Next(limit int) ([]os.FileInfo, error)
+	Close() error
+}
+
 // FsRealPather is a Fs that implements the RealPath method.
 type FsRealPather interface {
 	Fs
@@ -138,6 +164,13 @@ type fsMetadataChecker interface {
 	getFileNamesInPrefix(fsPrefix string) (map[string]bool, error)
 }
 
+// fsPendingUploadChecker is a Fs that persists interrupted uploads outside
+// of the visible namespace
+type fsPendingUploadChecker interface {
+	Fs
+	getPendingUploadInfo(name string) (os.FileInfo, error)
+}
+
 // FsFileCopier is a Fs that implements the CopyFile method.
 type FsFileCopier interface {
 	Fs
@@ -187,7 +220,9 @@ func (q *QuotaCheckResult) GetRemainingFiles() int {
 // S3FsConfig defines the configuration for S3 based filesystem
 type S3FsConfig struct {
 	sdk.BaseS3FsConfig
-	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
+	AccessSecret  *kms.Secret         `json:"access_secret,omitempty"`
+	MetadataCache MetadataCacheConfig `json:"metadata_cache"`
+	Encryption    EncryptionConfig    `json:"encryption"`
 }
 
 // HideConfidentialData hides confidential data
@@ -195,6 +230,7 @@ func (c *S3FsConfig) HideConfidentialData() {
 	if c.AccessSecret != nil {
 		c.AccessSecret.Hide()
 	}
+	c.Encryption.HideConfidentialData()
 }
 
 func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
@@ -229,6 +265,12 @@ func (c *S3FsConfig) isEqual(other S3FsConfig) bool {
 	if c.ForcePathStyle != other.ForcePathStyle {
 		return false
 	}
+	if !c.MetadataCache.isEqual(other.MetadataCache) {
+		return false
+	}
+	if !c.Encryption.isEqual(other.Encryption) {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -292,7 +334,7 @@ func (c *S3FsConfig) ValidateAndEncryptCredentials(additionalData string) error
 			return util.NewValidationError(fmt.Sprintf("could not encrypt s3 access secret: %v", err))
 		}
 	}
-	return nil
+	return c.Encryption.validateAndEncrypt(additionalData)
 }
 
 func (c *S3FsConfig) checkPartSizeAndConcurrency() error {
@@ -348,13 +390,18 @@ func (c *S3FsConfig) validate() error {
 	}
 	c.StorageClass = strings.TrimSpace(c.StorageClass)
 	c.ACL = strings.TrimSpace(c.ACL)
+	if err := c.MetadataCache.validate(); err != nil {
+		return err
+	}
 	return c.checkPartSizeAndConcurrency()
 }
 
 // GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
 type GCSFsConfig struct {
 	sdk.BaseGCSFsConfig
-	Credentials *kms.Secret `json:"credentials,omitempty"`
+	Credentials   *kms.Secret         `json:"credentials,omitempty"`
+	MetadataCache MetadataCacheConfig `json:"metadata_cache"`
+	Encryption    EncryptionConfig    `json:"encryption"`
 }
 
 // HideConfidentialData hides confidential data
@@ -362,6 +409,7 @@ func (c *GCSFsConfig) HideConfidentialData() {
 	if c.Credentials != nil {
 		c.Credentials.Hide()
 	}
+	c.Encryption.HideConfidentialData()
 }
 
 // ValidateAndEncryptCredentials validates the configuration and encrypts credentials if they are in plain text
@@ -376,7 +424,7 @@ func (c *GCSFsConfig) ValidateAndEncryptCredentials(additionalData string) error
 			return util.NewValidationError(fmt.Sprintf("could not encrypt GCS credentials: %v", err))
 		}
 	}
-	return nil
+	return c.Encryption.validateAndEncrypt(additionalData)
 }
 
 func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
@@ -401,6 +449,12 @@ func (c *GCSFsConfig) isEqual(other GCSFsConfig) bool {
 	if c.UploadPartMaxTime != other.UploadPartMaxTime {
 		return false
 	}
+	if !c.MetadataCache.isEqual(other.MetadataCache) {
+		return false
+	}
+	if !c.Encryption.isEqual(other.Encryption) {
+		return false
+	}
 	if c.Credentials == nil {
 		c.Credentials = kms.NewEmptySecret()
 	}
@@ -445,7 +499,7 @@ func (c *GCSFsConfig) validate() error {
 	if c.UploadPartMaxTime < 0 {
 		c.UploadPartMaxTime = 0
 	}
-	return nil
+	return c.MetadataCache.validate()
 }
 
 // AzBlobFsConfig defines the configuration for Azure Blob Storage based filesystem
@@ -455,7 +509,9 @@ type AzBlobFsConfig struct {
 	// The access key is stored encrypted based on the kms configuration
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
 	// Shared access signature URL, leave blank if using account/key
-	SASURL *kms.Secret `json:"sas_url,omitempty"`
+	SASURL        *kms.Secret         `json:"sas_url,omitempty"`
+	MetadataCache MetadataCacheConfig `json:"metadata_cache"`
+	Encryption    EncryptionConfig    `json:"encryption"`
 }
 
 // HideConfidentialData hides confidential data
@@ -466,6 +522,7 @@ func (c *AzBlobFsConfig) HideConfidentialData() {
 	if c.SASURL != nil {
 		c.SASURL.Hide()
 	}
+	c.Encryption.HideConfidentialData()
 }
 
 func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
@@ -508,6 +565,12 @@ func (c *AzBlobFsConfig) isEqual(other AzBlobFsConfig) bool {
 	if c.AccessTier != other.AccessTier {
 		return false
 	}
+	if !c.MetadataCache.isEqual(other.MetadataCache) {
+		return false
+	}
+	if !c.Encryption.isEqual(other.Encryption) {
+		return false
+	}
 	return c.isSecretEqual(other)
 }
 
@@ -538,7 +601,7 @@ func (c *AzBlobFsConfig) ValidateAndEncryptCredentials(additionalData string) er
 			return util.NewValidationError(fmt.Sprintf("could not encrypt Azure blob SAS URL: %v", err))
 		}
 	}
-	return nil
+	return c.Encryption.validateAndEncrypt(additionalData)
 }
 
 func (c *AzBlobFsConfig) checkCredentials() error {
@@ -627,7 +690,7 @@ func (c *AzBlobFsConfig) validate() error {
 	if !util.Contains(validAzAccessTier, c.AccessTier) {
 		return fmt.Errorf("invalid access tier %q, valid values: \"''%v\"", c.AccessTier, strings.Join(validAzAccessTier, ", "))
 	}
-	return nil
+	return c.MetadataCache.validate()
 }
 
 // CryptFsConfig defines the configuration to store local files as encrypted
@@ -689,6 +752,7 @@ type PipeWriter struct {
 	writer *pipeat.PipeWriterAt
 	err    error
 	done   chan bool
+	offset int64
 }
 
 // NewPipeWriter initializes a new PipeWriter
@@ -700,6 +764,14 @@ func NewPipeWriter(w *pipeat.PipeWriterAt) *PipeWriter {
 	}
 }
 
+// NewPipeWriterAtOffset initializes a new PipeWriter for an upload resumed at the
+// specified offset. The data before this offset is already stored
+func NewPipeWriterAtOffset(w *pipeat.PipeWriterAt, offset int64) *PipeWriter {
+	p := NewPipeWriter(w)
+	p.offset = offset
+	return p
+}
+
 // Close waits for the upload to end, closes the pipeat.PipeWriterAt and returns an error if any.
 func (p *PipeWriter) Close() error {
 	p.writer.Close() //nolint:errcheck // the returned error is always null
@@ -716,7 +788,10 @@ func (p *PipeWriter) Done(err error) {
 
 // WriteAt is a wrapper for pipeat WriteAt
 func (p *PipeWriter) WriteAt(data []byte, off int64) (int, error) {
-	return p.writer.WriteAt(data, off)
+	if off < p.offset {
+		return 0, fmt.Errorf("invalid write offset %d, the upload is resumed at offset %d", off, p.offset)
+	}
+	return p.writer.WriteAt(data, off-p.offset)
 }
 
 // Write is a wrapper for pipeat Write
@@ -724,6 +799,62 @@ func (p *PipeWriter) Write(data []byte) (int, error) {
 	return p.writer.Write(data)
 }
 
+type baseDirLister struct {
+	cache []os.FileInfo
+}
+
+func (l *baseDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if len(l.cache) >= limit {
+		return l.returnFromCache(limit), nil
+	}
+	return l.returnFromCache(limit), io.EOF
+}
+
+func (l *baseDirLister) returnFromCache(limit int) []os.FileInfo {
+	if len(l.cache) >= limit {
+		result := l.cache[:limit]
+		l.cache = l.cache[limit:]
+		return result
+	}
+	result := l.cache
+	l.cache = nil
+	return result
+}
+
+func (l *baseDirLister) Close() error {
+	l.cache = nil
+	return nil
+}
+
+// NewSliceDirLister returns a DirLister for the specified, already loaded, directory entries
+func NewSliceDirLister(files []os.FileInfo) DirLister {
+	return &baseDirLister{
+		cache: files,
+	}
+}
+
+// ReadAllDirEntries reads all the remaining entries from the specified
+// DirLister and closes it. It is intended for protocols whose libraries
+// require the full directory listing
+func ReadAllDirEntries(lister DirLister) ([]os.FileInfo, error) {
+	defer lister.Close()
+
+	var result []os.FileInfo
+	for {
+		files, err := lister.Next(ListerBatchSize)
+		result = append(result, files...)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func isEqualityCheckModeValid(mode int) bool {
 	return mode >= 0 || mode <= 1
 }
@@ -812,6 +943,44 @@ func HasOpenRWSupport(fs Fs) bool {
 	return false
 }
 
+// GetPendingUploadInfo returns a FileInfo describing the interrupted upload
+// for the specified name. Interrupted uploads are not reported by Stat, so
+// this method must be used before resuming an upload for a missing file.
+// An error satisfying os.IsNotExist is returned if there is no interrupted
+// upload or fs does not support this feature
+func GetPendingUploadInfo(fs Fs, name string) (os.FileInfo, error) {
+	if checker, ok := fs.(fsPendingUploadChecker); ok {
+		return checker.getPendingUploadInfo(name)
+	}
+	return nil, os.ErrNotExist
+}
+
+// AbandonedUploadsCleanupResult defines the result of an abandoned uploads cleanup
+type AbandonedUploadsCleanupResult struct {
+	// Removed is the number of removed uploads
+	Removed int
+	// QuotaFiles and QuotaSize are the number of files and the size, included
+	// in the quota when the uploads were interrupted, released by the cleanup
+	QuotaFiles int
+	QuotaSize  int64
+}
+
+// abandonedUploadsCleaner is implemented by the Fs that persist interrupted uploads
+// to allow resuming them
+type abandonedUploadsCleaner interface {
+	cleanupAbandonedUploads(olderThan time.Time) (AbandonedUploadsCleanupResult, error)
+}
+
+// CleanupAbandonedUploads removes the interrupted uploads not updated after
+// the specified time. It does nothing for the Fs that don't persist
+// interrupted uploads
+func CleanupAbandonedUploads(fs Fs, olderThan time.Time) (AbandonedUploadsCleanupResult, error) {
+	if cleaner, ok := fs.(abandonedUploadsCleaner); ok {
+		return cleaner.cleanupAbandonedUploads(olderThan)
+	}
+	return AbandonedUploadsCleanupResult{}, nil
+}
+
 // IsLocalOrCryptoFs returns true if fs is local or local encrypted
 func IsLocalOrCryptoFs(fs Fs) bool {
 	return IsLocalOsFs(fs) || IsCryptOsFs(fs)
@@ -0,0 +1,969 @@
+// Copyright (C) 2019-2023 Nicola Murino
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package vfs
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"github.com/sftpgo/sdk"
+
+	"github.com/drakkan/sftpgo/v2/internal/kms"
+	"github.com/drakkan/sftpgo/v2/internal/logger"
+	"github.com/drakkan/sftpgo/v2/internal/metric"
+	"github.com/drakkan/sftpgo/v2/internal/util"
+)
+
+const (
+	// webDAVFsName is the name for the WebDAV Fs implementation
+	webDAVFsName = "webdavfs"
+	// WebDAVFilesystemProvider defines the WebDAV storage provider.
+	// The SDK does not define it, so we use the first free value
+	WebDAVFilesystemProvider sdk.FilesystemProvider = 7
+)
+
+const (
+	webDAVNamespace    = "DAV:"
+	webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getcontenttype/><D:getetag/></D:prop></D:propfind>`
+	webDAVQuotaBody = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:quota-available-bytes/><D:quota-used-bytes/></D:prop></D:propfind>`
+	webDAVProppatchBody = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:getlastmodified>%s</D:getlastmodified></D:prop></D:set></D:propertyupdate>`
+)
+
+// WebDAVFsConfig defines the configuration for WebDAV based filesystem
+type WebDAVFsConfig struct {
+	// WebDAV endpoint including the base path, for example
+	// https://cloud.example.com/remote.php/dav/files/user
+	Endpoint string `json:"endpoint,omitempty"`
+	Username string `json:"username,omitempty"`
+	// SkipTLSVerify if true, the WebDAV client accepts any TLS certificate
+	// presented by the server and any host name in that certificate.
+	SkipTLSVerify bool `json:"skip_tls_verify,omitempty"`
+	// Defines how to check if this config points to the same
+	// server as another config. By default both the endpoint and
+	// the username must match. 1 means that only the endpoint must match.
+	// If different configs point to the same server the renaming
+	// between the fs configs is allowed.
+	EqualityCheckMode int         `json:"equality_check_mode,omitempty"`
+	Password          *kms.Secret `json:"password,omitempty"`
+}
+
+// HideConfidentialData hides confidential data
+func (c *WebDAVFsConfig) HideConfidentialData() {
+	if c.Password != nil {
+		c.Password.Hide()
+	}
+}
+
+func (c *WebDAVFsConfig) setNilSecretsIfEmpty() {
+	if c.Password != nil && c.Password.IsEmpty() {
+		c.Password = nil
+	}
+}
+
+func (c *WebDAVFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+func (c *WebDAVFsConfig) isEqual(other WebDAVFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.SkipTLSVerify != other.SkipTLSVerify {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *WebDAVFsConfig) isSameResource(other WebDAVFsConfig) bool {
+	if c.EqualityCheckMode == 0 || other.EqualityCheckMode == 0 {
+		if c.Username != other.Username {
+			return false
+		}
+	}
+	return c.Endpoint == other.Endpoint
+}
+
+// validate returns an error if the configuration is not valid
+func (c *WebDAVFsConfig) validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("webdavfs: endpoint cannot be empty")
+	}
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	endpointURL, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return fmt.Errorf("webdavfs: invalid endpoint: %w", err)
+	}
+	if !util.IsStringPrefixInSlice(c.Endpoint, supportedEndpointSchema) {
+		return errors.New("webdavfs: invalid endpoint schema: http and https are supported")
+	}
+	if endpointURL.Host == "" {
+		return errors.New("webdavfs: invalid endpoint: the host cannot be empty")
+	}
+	if endpointURL.RawQuery != "" || endpointURL.Fragment != "" {
+		return errors.New("webdavfs: invalid endpoint: query and fragment are not allowed")
+	}
+	if !isEqualityCheckModeValid(c.EqualityCheckMode) {
+		return errors.New("invalid equality_check_mode")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("webdavfs: invalid encrypted password")
+	}
+	if !c.Password.IsEmpty() && !c.Password.IsValidInput() {
+		return errors.New("webdavfs: invalid password")
+	}
+	return nil
+}
+
+// ValidateAndEncryptCredentials validates the config and encrypts credentials if they are in plain text
+func (c *WebDAVFsConfig) ValidateAndEncryptCredentials(additionalData string) error {
+	if err := c.validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate WebDAV fs config: %v", err))
+	}
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return util.NewValidationError(fmt.Sprintf("could not encrypt WebDAV fs password: %v", err))
+		}
+	}
+	return nil
+}
+
+// WebDAVFs is a Fs implementation for WebDAV servers
+type WebDAVFs struct {
+	connectionID string
+	localTempDir string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath  string
+	config     *WebDAVFsConfig
+	baseURL    *url.URL
+	client     *http.Client
+	ctxTimeout time.Duration
+}
+
+// NewWebDAVFs returns a WebDAVFs object that allows to interact with WebDAV servers
+func NewWebDAVFs(connectionID, localTempDir, mountPath string, config WebDAVFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		if tempPath != "" {
+			localTempDir = tempPath
+		} else {
+			localTempDir = filepath.Clean(os.TempDir())
+		}
+	}
+	config.setEmptyCredentialsIfNil()
+	if !config.Password.IsEmpty() {
+		if err := config.Password.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	baseURL, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("webdavfs: invalid endpoint: %w", err)
+	}
+	if baseURL.Path == "" {
+		baseURL.Path = "/"
+	}
+	fs := &WebDAVFs{
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    mountPath,
+		config:       &config,
+		baseURL:      baseURL,
+		ctxTimeout:   30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxResponseHeaderBytes = 1 << 16
+	transport.WriteBufferSize = 1 << 16
+	transport.ReadBufferSize = 1 << 16
+	if config.SkipTLSVerify {
+		if transport.TLSClientConfig != nil {
+			transport.TLSClientConfig.InsecureSkipVerify = true
+		} else {
+			transport.TLSClientConfig = getInsecureTLSConfig()
+		}
+	}
+	fs.client = &http.Client{
+		Transport: transport,
+		// redirects would drop the authorization header and WebDAV methods
+		// are not safe to follow anyway
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return fs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *WebDAVFs) Name() string {
+	return fmt.Sprintf("%s %q", webDAVFsName, fs.config.Endpoint)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *WebDAVFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *WebDAVFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.propfind(ctx, name, "0", webDAVPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to decode PROPFIND response: %w", err)
+	}
+	for _, r := range response.Responses {
+		if prop, ok := r.getProp(); ok {
+			return prop.getFileInfo(path.Base(name)), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *WebDAVFs) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+// Open opens the named file for reading.
+// A ranged GET request is used to resume downloads
+func (fs *WebDAVFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		resp, err := fs.sendRequest(ctx, http.MethodGet, name, nil, func(h http.Header) {
+			if offset > 0 {
+				h.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			}
+		})
+		if err != nil {
+			fsLog(fs, logger.LevelError, "download error, path %q, err: %v", name, err)
+			w.CloseWithError(err) //nolint:errcheck
+			metric.WebDAVFsTransferCompleted(0, 1, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if offset > 0 && resp.StatusCode == http.StatusOK {
+			// the server ignored the range header, skip the initial bytes
+			fsLog(fs, logger.LevelDebug, "range requests not supported, path %q, skipping %d bytes", name, offset)
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+				w.CloseWithError(err) //nolint:errcheck
+				metric.WebDAVFsTransferCompleted(0, 1, err)
+				return
+			}
+		}
+		n, err := io.Copy(w, resp.Body)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path %q size: %v, err: %+v", name, n, err)
+		metric.WebDAVFsTransferCompleted(n, 1, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *WebDAVFs) Create(name string, _, checks int) (File, *PipeWriter, func(), error) {
+	if checks&CheckParentDir != 0 {
+		_, err := fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		resp, err := fs.sendRequest(ctx, http.MethodPut, name, &wrapReader{reader: r}, func(h http.Header) {
+			if contentType != "" {
+				h.Set("Content-Type", contentType)
+			}
+		})
+		if err != nil {
+			fsLog(fs, logger.LevelError, "upload error, path %q, err: %v", name, err)
+			r.CloseWithError(err) //nolint:errcheck
+			p.Done(err)
+			metric.WebDAVFsTransferCompleted(0, 0, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %q, readed bytes: %d", name, r.GetReadedBytes())
+		metric.WebDAVFsTransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target using the MOVE method
+func (fs *WebDAVFs) Rename(source, target string) (int, int64, error) {
+	if source == target {
+		return -1, -1, nil
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, "MOVE", source, nil, func(h http.Header) {
+		h.Set("Destination", fs.getURL(target))
+		h.Set("Overwrite", "T")
+	})
+	if err != nil {
+		return -1, -1, err
+	}
+	defer resp.Body.Close()
+	return -1, -1, nil
+}
+
+// CopyFile implements the FsFileCopier interface using the COPY method
+func (fs *WebDAVFs) CopyFile(source, target string, _ int64) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, "COPY", source, nil, func(h http.Header) {
+		h.Set("Destination", fs.getURL(target))
+		h.Set("Overwrite", "T")
+		h.Set("Depth", "0")
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *WebDAVFs) Remove(name string, isDir bool) error {
+	if isDir {
+		// DELETE is recursive for collections
+		hasContents, err := fs.hasContents(name)
+		if err != nil {
+			return err
+		}
+		if hasContents {
+			return fmt.Errorf("cannot remove non empty directory: %q", name)
+		}
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *WebDAVFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, "MKCOL", name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*WebDAVFs) Symlink(_, _ string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*WebDAVFs) Readlink(_ string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*WebDAVFs) Chown(_ string, _ int, _ int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+func (*WebDAVFs) Chmod(_ string, _ os.FileMode) error {
+	return ErrVfsUnsupported
+}
+
+// Chtimes changes the access and modification times of the named file.
+// The modification time is set using PROPPATCH, not all the WebDAV servers
+// allow to change it
+func (fs *WebDAVFs) Chtimes(name string, _, mtime time.Time, _ bool) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	body := fmt.Sprintf(webDAVProppatchBody, mtime.UTC().Format(http.TimeFormat))
+	resp, err := fs.sendRequest(ctx, "PROPPATCH", name, strings.NewReader(body), func(h http.Header) {
+		h.Set("Content-Type", "application/xml; charset=utf-8")
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil
+	}
+	var response webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("unable to decode PROPPATCH response: %w", err)
+	}
+	for _, r := range response.Responses {
+		for _, p := range r.Propstats {
+			if !p.isOK() {
+				fsLog(fs, logger.LevelDebug, "unable to set the modification time for path %q, status: %q",
+					name, p.Status)
+				return ErrVfsUnsupported
+			}
+		}
+	}
+	return nil
+}
+
+// Truncate changes the size of the named file.
+// Only truncating to 0 bytes is supported
+func (fs *WebDAVFs) Truncate(name string, size int64) error {
+	if size != 0 {
+		return ErrVfsUnsupported
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.sendRequest(ctx, http.MethodPut, name, http.NoBody, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// ReadDir returns a DirLister for the directory named by dirname.
+// The PROPFIND response is decoded while iterating, without loading the whole
+// directory listing in memory
+func (fs *WebDAVFs) ReadDir(dirname string) (DirLister, error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	resp, err := fs.propfind(ctx, dirname, "1", webDAVPropfindBody)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	return &webDAVFsDirLister{
+		body:     resp.Body,
+		decoder:  xml.NewDecoder(resp.Body),
+		dirPath:  fs.getRequestPath(dirname),
+		cancelFn: cancelFn,
+	}, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported.
+func (*WebDAVFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported.
+func (*WebDAVFs) IsAtomicUploadSupported() bool {
+	return false
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*WebDAVFs) IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*WebDAVFs) IsPermission(err error) bool {
+	return errors.Is(err, fs.ErrPermission)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*WebDAVFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the specified local root directory if it does not exists
+func (fs *WebDAVFs) CheckRootPath(username string, uid int, gid int) bool {
+	// we need a local directory for temporary files
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files and their size
+func (fs *WebDAVFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize("/")
+}
+
+// CheckMetadata checks the metadata consistency
+func (*WebDAVFs) CheckMetadata() error {
+	return nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *WebDAVFs) GetDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := isDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += info.Size()
+				numFiles++
+				if numFiles%1000 == 0 {
+					fsLog(fs, logger.LevelDebug, "dirname %q scan in progress, files: %d, size: %d", dirname, numFiles, size)
+				}
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload.
+func (*WebDAVFs) GetAtomicUploadPath(_ string) string {
+	return ""
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+// This is the path as seen by SFTPGo users
+func (fs *WebDAVFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. The result are unordered
+func (fs *WebDAVFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+// Join joins any number of path elements into a single path
+func (*WebDAVFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*WebDAVFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *WebDAVFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return virtualPath, nil
+}
+
+// GetMimeType returns the content type
+func (fs *WebDAVFs) GetMimeType(name string) (string, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.propfind(ctx, name, "0", webDAVPropfindBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var response webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("unable to decode PROPFIND response: %w", err)
+	}
+	for _, r := range response.Responses {
+		if prop, ok := r.getProp(); ok && prop.ContentType != "" {
+			return prop.ContentType, nil
+		}
+	}
+	return mime.TypeByExtension(path.Ext(name)), nil
+}
+
+// Close closes the fs
+func (fs *WebDAVFs) Close() error {
+	fs.client.CloseIdleConnections()
+	return nil
+}
+
+// GetAvailableDiskSize returns the available size for the specified path.
+// The RFC 4331 quota properties are used, if supported by the server
+func (fs *WebDAVFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	resp, err := fs.propfind(ctx, dirName, "0", webDAVQuotaBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response webDAVMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("unable to decode PROPFIND response: %w", err)
+	}
+	for _, r := range response.Responses {
+		if prop, ok := r.getProp(); ok {
+			return prop.getStatVFS()
+		}
+	}
+	return nil, ErrStorageSizeUnavailable
+}
+
+func (fs *WebDAVFs) getCacheNamespace() string {
+	return getHashedNamespace(webDAVFsName, fs.config.Endpoint, fs.config.Username)
+}
+
+// getRequestPath returns the unescaped URL path for the specified name
+func (fs *WebDAVFs) getRequestPath(name string) string {
+	return path.Join(fs.baseURL.Path, name)
+}
+
+func (fs *WebDAVFs) getURL(name string) string {
+	u := *fs.baseURL
+	u.Path = fs.getRequestPath(name)
+	u.RawPath = ""
+	return u.String()
+}
+
+func (fs *WebDAVFs) hasContents(name string) (bool, error) {
+	lister, err := fs.ReadDir(name)
+	if err != nil {
+		return false, err
+	}
+	defer lister.Close()
+
+	for {
+		files, err := lister.Next(1)
+		if len(files) > 0 {
+			return true, nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+func (fs *WebDAVFs) propfind(ctx context.Context, name, depth, body string) (*http.Response, error) {
+	return fs.sendRequest(ctx, "PROPFIND", name, strings.NewReader(body), func(h http.Header) {
+		h.Set("Depth", depth)
+		h.Set("Content-Type", "application/xml; charset=utf-8")
+	})
+}
+
+func (fs *WebDAVFs) sendRequest(ctx context.Context, method, name string, body io.Reader,
+	setHeaders func(http.Header),
+) (*http.Response, error) {
+	url := fs.getURL(name)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if setHeaders != nil {
+		setHeaders(req.Header)
+	}
+	if fs.config.Username != "" || fs.config.Password.GetPayload() != "" {
+		req.SetBasicAuth(fs.config.Username, fs.config.Password.GetPayload())
+	}
+	resp, err := fs.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send %s request to URL %v: %w", method, url, err)
+	}
+	if err = getWebDAVErrorFromResponseCode(resp.StatusCode); err != nil {
+		resp.Body.Close()
+		fsLog(fs, logger.LevelDebug, "%s request for path %q failed, status code: %d", method, name, resp.StatusCode)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// walk recursively descends path, calling walkFn.
+func (fs *WebDAVFs) walk(filePath string, info fs.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(filePath, info, nil)
+	}
+	lister, err := fs.ReadDir(filePath)
+	var files []os.FileInfo
+	if err == nil {
+		// we don't want to keep the response open while walking the subdirectories
+		files, err = ReadAllDirEntries(lister)
+	}
+	err1 := walkFn(filePath, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range files {
+		objName := path.Join(filePath, fi.Name())
+		err = fs.walk(objName, fi, walkFn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getWebDAVErrorFromResponseCode(code int) error {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return os.ErrPermission
+	case http.StatusNotFound, http.StatusConflict:
+		// 409 is returned if an intermediate collection does not exist
+		return os.ErrNotExist
+	case http.StatusMethodNotAllowed:
+		// returned by MKCOL if the resource already exists
+		return os.ErrExist
+	case http.StatusNotImplemented:
+		return ErrVfsUnsupported
+	}
+	if code >= 200 && code < 300 {
+		return nil
+	}
+	return fmt.Errorf("unexpected response code: %v", code)
+}
+
+type webDAVMultiStatus struct {
+	XMLName   xml.Name         `xml:"DAV: multistatus"`
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+// getProp returns the properties with a successful status
+func (r *webDAVResponse) getProp() (webDAVProp, bool) {
+	for _, p := range r.Propstats {
+		if p.isOK() {
+			return p.Prop, true
+		}
+	}
+	return webDAVProp{}, false
+}
+
+type webDAVPropstat struct {
+	Prop   webDAVProp `xml:"DAV: prop"`
+	Status string     `xml:"DAV: status"`
+}
+
+func (p *webDAVPropstat) isOK() bool {
+	// the status line is something like "HTTP/1.1 200 OK"
+	fields := strings.Fields(p.Status)
+	if len(fields) < 2 {
+		return false
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+	return code >= 200 && code < 300
+}
+
+type webDAVResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+type webDAVProp struct {
+	ResourceType        webDAVResourceType `xml:"DAV: resourcetype"`
+	ContentLength       string             `xml:"DAV: getcontentlength"`
+	LastModified        string             `xml:"DAV: getlastmodified"`
+	ContentType         string             `xml:"DAV: getcontenttype"`
+	ETag                string             `xml:"DAV: getetag"`
+	QuotaAvailableBytes string             `xml:"DAV: quota-available-bytes"`
+	QuotaUsedBytes      string             `xml:"DAV: quota-used-bytes"`
+}
+
+func (p *webDAVProp) getFileInfo(name string) *FileInfo {
+	isDir := p.ResourceType.Collection != nil
+	var size int64
+	if !isDir && p.ContentLength != "" {
+		size, _ = strconv.ParseInt(strings.TrimSpace(p.ContentLength), 10, 64)
+	}
+	var modTime time.Time
+	if p.LastModified != "" {
+		modTime, _ = http.ParseTime(strings.TrimSpace(p.LastModified))
+	}
+	info := NewFileInfo(name, isDir, size, modTime, false)
+	info.SetETag(p.ETag)
+	return info
+}
+
+func (p *webDAVProp) getStatVFS() (*sftp.StatVFS, error) {
+	available, err := strconv.ParseInt(strings.TrimSpace(p.QuotaAvailableBytes), 10, 64)
+	if err != nil || available < 0 {
+		// a negative value means that the quota is not defined
+		return nil, ErrStorageSizeUnavailable
+	}
+	used, _ := strconv.ParseInt(strings.TrimSpace(p.QuotaUsedBytes), 10, 64)
+	if used < 0 {
+		used = 0
+	}
+	bsize := uint64(4096)
+	blocks := uint64(available+used) / bsize
+	bfree := uint64(available) / bsize
+	// these assumptions are wrong but still better than returning 0
+	files := blocks / 4
+	ffree := bfree / 4
+	return &sftp.StatVFS{
+		Bsize:   bsize,
+		Frsize:  bsize,
+		Blocks:  blocks,
+		Bfree:   bfree,
+		Bavail:  bfree,
+		Files:   files,
+		Ffree:   ffree,
+		Favail:  ffree,
+		Namemax: 255,
+	}, nil
+}
+
+type webDAVFsDirLister struct {
+	body     io.ReadCloser
+	decoder  *xml.Decoder
+	dirPath  string
+	cancelFn context.CancelFunc
+	finished bool
+}
+
+func (l *webDAVFsDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidDirListerLimit
+	}
+	if l.finished {
+		return nil, io.EOF
+	}
+	var result []os.FileInfo
+	for len(result) < limit {
+		token, err := l.decoder.Token()
+		if err == io.EOF {
+			l.finished = true
+			return result, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != webDAVNamespace || start.Name.Local != "response" {
+			continue
+		}
+		var response webDAVResponse
+		if err := l.decoder.DecodeElement(&response, &start); err != nil {
+			return nil, err
+		}
+		name, ok := l.getEntryName(response.Href)
+		if !ok {
+			continue
+		}
+		prop, ok := response.getProp()
+		if !ok {
+			continue
+		}
+		result = append(result, prop.getFileInfo(name))
+	}
+	return result, nil
+}
+
+// getEntryName returns the name for the specified href and false if the href
+// refers to the listed directory itself
+func (l *webDAVFsDirLister) getEntryName(href string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", false
+	}
+	entryPath := path.Clean(u.Path)
+	if entryPath == path.Clean(l.dirPath) || entryPath == "/" || entryPath == "." {
+		return "", false
+	}
+	return path.Base(entryPath), true
+}
+
+func (l *webDAVFsDirLister) Close() error {
+	err := l.body.Close()
+	l.cancelFn()
+	return err
+}
@@ -49,6 +49,9 @@ type webDavFile struct {
 	startOffset int64
 	isFinished  bool
 	readTryed   atomic.Bool
+	// lister and listerDone are used to read the directory entries page by page
+	lister     *common.DirListerAt
+	listerDone bool
 }
 
 func newWebDavFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter, pipeReader *pipeat.PipeReaderAt) *webDavFile {
@@ -106,15 +109,48 @@ func (fi *webDavFileInfo) ContentType(_ context.Context) (string, error) {
 	return "", webdav.ErrNotImplemented
 }
 
-// Readdir reads directory entries from the handle
-func (f *webDavFile) Readdir(_ int) ([]os.FileInfo, error) {
-	if !f.Connection.User.HasPerm(dataprovider.PermListItems, f.GetVirtualPath()) {
-		return nil, f.Connection.GetPermissionDeniedError()
+// Readdir reads directory entries from the handle.
+// As for os.File, if count is positive at most count entries are returned and
+// io.EOF is returned at the end of the directory, otherwise all the remaining
+// entries are returned. The WebDAV server library requests the full listing, the
+// entries are read from the lister page by page anyway
+func (f *webDavFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.lister == nil {
+		if !f.Connection.User.HasPerm(dataprovider.PermListItems, f.GetVirtualPath()) {
+			return nil, f.Connection.GetPermissionDeniedError()
+		}
+		lister, err := f.Connection.ListDir(f.GetVirtualPath())
+		if err != nil {
+			return nil, err
+		}
+		f.lister = lister
 	}
-	entries, err := f.Connection.ListDir(f.GetVirtualPath())
-	if err != nil {
-		return nil, err
+	if count <= 0 {
+		entries, err := vfs.ReadAllDirEntries(f.lister)
+		f.listerDone = true
+		if err != nil {
+			return nil, err
+		}
+		return f.getDirEntries(entries), nil
 	}
+	for !f.listerDone {
+		entries, err := f.lister.Next(count)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			f.listerDone = true
+			f.lister.Close() //nolint:errcheck
+		}
+		// the lister can return no entries, for example if they are hidden
+		if len(entries) > 0 {
+			return f.getDirEntries(entries), nil
+		}
+	}
+	return nil, io.EOF
+}
+
+func (f *webDavFile) getDirEntries(entries []os.FileInfo) []os.FileInfo {
 	for idx, info := range entries {
 		entries[idx] = &webDavFileInfo{
 			FileInfo:    info,
@@ -123,7 +159,7 @@ func (f *webDavFile) Readdir(_ int) ([]os.FileInfo, error) {
 			fsPath:      f.Fs.Join(f.GetFsPath(), info.Name()),
 		}
 	}
-	return entries, nil
+	return entries
 }
 
 // Stat the handle
@@ -353,12 +389,19 @@ func (f *webDavFile) Seek(offset int64, whence int) (int64, error) {
 			startByte = f.info.Size() - offset
 		}
 
-		_, r, cancelFn, err := f.Fs.Open(f.GetFsPath(), startByte)
+		file, r, cancelFn, err := f.Fs.Open(f.GetFsPath(), startByte)
 
 		f.Lock()
 		if err == nil {
-			f.startOffset = startByte
-			f.reader = r
+			if file != nil {
+				// the file is already positioned at startByte, next seeks use it directly
+				f.File = file
+				f.writer = file
+				f.reader = file
+			} else {
+				f.startOffset = startByte
+				f.reader = r
+			}
 		}
 		f.ErrTransfer = err
 		f.BaseTransfer.SetCancelFn(cancelFn)
@@ -374,6 +417,9 @@ func (f *webDavFile) Close() error {
 	if err := f.setFinished(); err != nil {
 		return err
 	}
+	if f.lister != nil {
+		f.lister.Close() //nolint:errcheck
+	}
 	err := f.closeIO()
 	if f.isTransfer() {
 		errBaseClose := f.BaseTransfer.Close()
@@ -944,6 +944,42 @@ func TestRenameWithLock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestListDirPages(t *testing.T) {
+	u := getTestUser()
+	u.Filters.FilePatterns = []sdk.PatternsFilter{
+		{
+			Path:            "/",
+			DeniedPatterns:  []string{"*.denied"},
+			DenyPolicy:      sdk.DenyPolicyHide,
+			AllowedPatterns: []string{},
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	// more entries than the ones read in a single page, some hidden entries
+	// are included so a page can be empty
+	numFiles := 250
+	for i := 0; i < numFiles; i++ {
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), fmt.Sprintf("file%d.dat", i)), []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	for i := 0; i < 150; i++ {
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), fmt.Sprintf("file%d.denied", i)), []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	client := getWebDavClient(user, false, nil)
+	files, err := client.ReadDir("/")
+	assert.NoError(t, err)
+	assert.Len(t, files, numFiles)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPropPatch(t *testing.T) {
 	u := getTestUser()
 	u.Username = u.Username + "1"
@@ -2179,6 +2215,158 @@ func TestSFTPBuffered(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWebDAVFs(t *testing.T) {
+	u := getTestUser()
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	folderName := "webdavfolder"
+	vdirPath := "/vdir"
+	mappedPath := filepath.Join(os.TempDir(), folderName)
+	u = getTestWebDAVUser()
+	u.QuotaFiles = 1000
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+			FsConfig: vfs.Filesystem{
+				Provider: vfs.WebDAVFilesystemProvider,
+				WebDAVConfig: vfs.WebDAVFsConfig{
+					Endpoint: fmt.Sprintf("http://%s/sub", webDavServerAddr),
+					Username: defaultUsername,
+					Password: kms.NewPlainSecret(defaultPassword),
+				},
+			},
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	err = os.MkdirAll(filepath.Join(localUser.GetHomeDir(), "sub"), os.ModePerm)
+	assert.NoError(t, err)
+	webDAVUser, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+
+	client := getWebDavClient(webDAVUser, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	fileContent := []byte("test file contents")
+	err = os.WriteFile(testFilePath, fileContent, os.ModePerm)
+	assert.NoError(t, err)
+	testFileSize := int64(len(fileContent))
+	err = uploadFileWithRawClient(testFilePath, testFileName, webDAVUser.Username, defaultPassword,
+		false, testFileSize, client)
+	assert.NoError(t, err)
+	info, err := os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName))
+	if assert.NoError(t, err) {
+		assert.Equal(t, testFileSize, info.Size())
+	}
+	localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+	err = downloadFile(testFileName, localDownloadPath, testFileSize, client)
+	assert.NoError(t, err)
+	// resume the download using a range request
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName), nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth(webDAVUser.Username, defaultPassword)
+	req.Header.Set("Range", "bytes=5-")
+	httpResp, err := httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		defer httpResp.Body.Close()
+		assert.Equal(t, http.StatusPartialContent, httpResp.StatusCode)
+		bodyBytes, err := io.ReadAll(httpResp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "file contents", string(bodyBytes))
+	}
+	// set the modification time
+	mtime := time.Date(2020, 10, 11, 12, 13, 14, 0, time.UTC)
+	propatchBody := fmt.Sprintf(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:getlastmodified>%s</D:getlastmodified></D:prop></D:set></D:propertyupdate>`,
+		mtime.Format(http.TimeFormat))
+	req, err = http.NewRequest("PROPPATCH", fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName),
+		bytes.NewReader([]byte(propatchBody)))
+	assert.NoError(t, err)
+	req.SetBasicAuth(webDAVUser.Username, defaultPassword)
+	httpResp, err = httpclient.GetHTTPClient().Do(req)
+	if assert.NoError(t, err) {
+		defer httpResp.Body.Close()
+		assert.Equal(t, http.StatusMultiStatus, httpResp.StatusCode)
+	}
+	info, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName))
+	if assert.NoError(t, err) {
+		assert.Equal(t, mtime.Unix(), info.ModTime().Unix())
+	}
+	// server side copy and rename
+	err = client.Copy(testFileName, testFileName+"_copy", false)
+	assert.NoError(t, err)
+	err = client.Rename(testFileName+"_copy", path.Join("sub", testFileName), false)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName+"_copy"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	// the sub dir is the virtual folder root
+	err = checkFileSize(path.Join(vdirPath, testFileName), testFileSize, client)
+	assert.NoError(t, err)
+	err = uploadFileWithRawClient(testFilePath, path.Join(vdirPath, testFileName+"1"), webDAVUser.Username,
+		defaultPassword, false, testFileSize, client)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), "sub", testFileName+"1"))
+	assert.NoError(t, err)
+
+	testDir := "tdir"
+	err = client.Mkdir(testDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Mkdir(path.Join(testDir, testDir), os.ModePerm)
+	assert.NoError(t, err)
+	err = client.Rename(testDir, testDir+"_renamed", false)
+	assert.NoError(t, err)
+	info, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testDir+"_renamed", testDir))
+	if assert.NoError(t, err) {
+		assert.True(t, info.IsDir())
+	}
+	contents, err := client.ReadDir("/")
+	assert.NoError(t, err)
+	if assert.Len(t, contents, 4) {
+		for _, info := range contents {
+			switch info.Name() {
+			case testFileName:
+				assert.False(t, info.IsDir())
+				assert.Equal(t, testFileSize, info.Size())
+			case "sub", "vdir", testDir + "_renamed":
+				assert.True(t, info.IsDir())
+			default:
+				t.Errorf("unexpected file/dir %q", info.Name())
+			}
+		}
+	}
+	user, _, err := httpdtest.GetUserByUsername(webDAVUser.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.UsedQuotaFiles)
+	assert.Equal(t, 3*testFileSize, user.UsedQuotaSize)
+
+	err = client.Remove(testFileName)
+	assert.NoError(t, err)
+	err = client.RemoveAll(testDir + "_renamed")
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testFileName))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = os.Stat(filepath.Join(localUser.GetHomeDir(), testDir+"_renamed"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.Remove(localDownloadPath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(webDAVUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(webDAVUser.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestBytesRangeRequests(t *testing.T) {
 	u := getTestUser()
 	u.Username = u.Username + "1"
@@ -3294,6 +3482,17 @@ func getTestSFTPUser() dataprovider.User {
 	return u
 }
 
+func getTestWebDAVUser() dataprovider.User {
+	u := getTestUser()
+	u.Username = u.Username + "_webdav"
+	u.HomeDir = filepath.Join(os.TempDir(), u.Username)
+	u.FsConfig.Provider = vfs.WebDAVFilesystemProvider
+	u.FsConfig.WebDAVConfig.Endpoint = fmt.Sprintf("http://%s", webDavServerAddr)
+	u.FsConfig.WebDAVConfig.Username = defaultUsername
+	u.FsConfig.WebDAVConfig.Password = kms.NewPlainSecret(defaultPassword)
+	return u
+}
+
 func getTestUserWithCryptFs() dataprovider.User {
 	user := getTestUser()
 	user.FsConfig.Provider = sdk.CryptedFilesystemProvider